@@ -2,12 +2,8 @@ package business
 
 import (
 	"context"
-	"fmt"
 	"sync"
 	"time"
-
-	"github.com/kiali/kiali/kubernetes"
-	"github.com/kiali/kiali/log"
 )
 
 // ResourcePermissions represents the permissions a user has for different resource types
@@ -26,38 +22,66 @@ var userPermissionsCache = struct {
 	permissions: make(map[string]*ResourcePermissions),
 }
 
-// CheckUserPermissions checks if a user has permission to access a specific resource
-func CheckUserPermissions(ctx context.Context, userClient kubernetes.ClientInterface, username, resourceType, verb string) (bool, error) {
+// Decision sources
+const (
+	DecisionSourceAPIServer = "apiserver"
+	DecisionSourceCache     = "cache"
+	DecisionSourceLocalRBAC = "local-rbac"
+)
+
+// Decision is the outcome of a permission check. It mirrors the status of a SubjectAccessReview,
+// so the reason given by the apiserver is not lost, and records where the decision came from.
+type Decision struct {
+	Allowed bool
+	// Denied is set when the authorizer explicitly denied the request. A decision neither allowed nor
+	// denied means the authorizer has no opinion, and lets the next authorizer of a chain decide.
+	Denied bool
+	// Reason is the explanation of the decision given by the authorizer, if any.
+	Reason string
+	// EvaluationError is set when the authorizer had trouble evaluating the request. A decision can be
+	// made despite an evaluation error, e.g. when another rule allowed the request.
+	EvaluationError string
+	// Source is where the decision came from: one of the DecisionSource constants, or the name of
+	// the authorizer that made it.
+	Source    string
+	Timestamp time.Time
+}
+
+// CheckUserPermissions checks if a user has permission to access a specific resource.
+// Kiali callers can wrap their client with NewKialiPermissionsClient.
+// It is a convenience wrapper of CheckUserPermissionsDecision.
+func CheckUserPermissions(ctx context.Context, userClient PermissionsClient, cluster, username, resourceType, verb string) (bool, error) {
+	decision, err := CheckUserPermissionsDecision(ctx, userClient, cluster, username, resourceType, verb)
+	return decision.Allowed, err
+}
+
+// CheckUserPermissionsDecision checks if a user has permission to access a specific resource of the
+// cluster of userClient, returning the full decision. The reviews are memoized by cluster and by
+// identity of the client, see clientIdentity: username only selects the cached permissions of
+// CacheUserPermissions. Errors carry the request ID of the context, see WithRequestID.
+func CheckUserPermissionsDecision(ctx context.Context, userClient PermissionsClient, cluster, username, resourceType, verb string) (Decision, error) {
 	// Get or check cached permissions
 	userPermissionsCache.RLock()
 	permissions, exists := userPermissionsCache.permissions[username]
 	userPermissionsCache.RUnlock()
 
 	if !exists || time.Since(permissions.LastChecked) > 5*time.Minute {
-		// Need to check permissions
-		review, err := userClient.GetSelfSubjectAccessReview(ctx, "", "", resourceType, []string{verb})
-		if err != nil {
-			log.Errorf("Error checking permissions for user %s on resource %s: %v", username, resourceType, err)
-			return false, fmt.Errorf("error checking permissions: %w", err)
-		}
-
-		if len(review) == 0 {
-			return false, nil
-		}
-
-		return review[0].Status.Allowed, nil
+		// Need to check permissions. Repeat questions are answered by the memo of the self reviews
+		return selfReviews.review(ctx, userClient, cluster, clientIdentity(userClient), username, AccessRequest{Resource: resourceType, Verb: verb})
 	}
 
 	// Check cached permissions
+	decision := Decision{Source: DecisionSourceCache, Timestamp: permissions.LastChecked}
 	if verbs, ok := permissions.ResourcePermissions[resourceType]; ok {
 		for _, v := range verbs {
 			if v == verb {
-				return true, nil
+				decision.Allowed = true
+				return decision, nil
 			}
 		}
 	}
 
-	return false, nil
+	return decision, nil
 }
 
 // CacheUserPermissions caches the permissions for a user
@@ -79,4 +103,5 @@ func ClearUserPermissions(username string) {
 	userPermissionsCache.Lock()
 	defer userPermissionsCache.Unlock()
 	delete(userPermissionsCache.permissions, username)
+	selfReviews.Forget(username)
 }
@@ -0,0 +1,164 @@
+package business
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ABACPolicyAPIVersion is the version of the ABAC policy objects. Lines without apiVersion use the
+// unversioned format, where the spec fields are at the top level.
+const ABACPolicyAPIVersion = "abac.authorization.kubernetes.io/v1beta1"
+
+// ABACPolicySpec is a rule of an ABAC policy file.
+type ABACPolicySpec struct {
+	User            string `json:"user,omitempty"`
+	Group           string `json:"group,omitempty"`
+	Readonly        bool   `json:"readonly,omitempty"`
+	APIGroup        string `json:"apiGroup,omitempty"`
+	Resource        string `json:"resource,omitempty"`
+	Namespace       string `json:"namespace,omitempty"`
+	NonResourcePath string `json:"nonResourcePath,omitempty"`
+}
+
+type abacPolicy struct {
+	APIVersion string         `json:"apiVersion"`
+	Kind       string         `json:"kind"`
+	Spec       ABACPolicySpec `json:"spec"`
+}
+
+// ABACImport is the RBAC equivalent of an ABAC policy file. The snapshot can be used by the evaluation,
+// diff and report helpers, e.g. with CompareSnapshots to check the RBAC replacement of the policies.
+type ABACImport struct {
+	Snapshot *RBACSnapshot
+	// Warnings describe the policies that could not be converted exactly.
+	Warnings []string
+}
+
+// ParseABACPolicies reads an ABAC policy file, one JSON policy object per line. Empty lines and lines
+// starting with # are ignored.
+func ParseABACPolicies(r io.Reader) ([]ABACPolicySpec, error) {
+	specs := []ABACPolicySpec{}
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var policy abacPolicy
+		if err := json.Unmarshal([]byte(line), &policy); err != nil {
+			return nil, fmt.Errorf("invalid ABAC policy at line %d: %w", lineNumber, err)
+		}
+		switch policy.APIVersion {
+		case ABACPolicyAPIVersion:
+			specs = append(specs, policy.Spec)
+		case "":
+			var spec ABACPolicySpec
+			if err := json.Unmarshal([]byte(line), &spec); err != nil {
+				return nil, fmt.Errorf("invalid ABAC policy at line %d: %w", lineNumber, err)
+			}
+			specs = append(specs, spec)
+		default:
+			return nil, fmt.Errorf("unsupported ABAC policy version %q at line %d", policy.APIVersion, lineNumber)
+		}
+	}
+	return specs, scanner.Err()
+}
+
+// ImportABACPolicies converts ABAC policies to ClusterRoles bound cluster-wide, or in a namespace, to the
+// subjects of the policies. Non-resource policies are skipped, since AccessRequests only model resources.
+func ImportABACPolicies(specs []ABACPolicySpec) *ABACImport {
+	result := &ABACImport{Warnings: []string{}}
+	clusterRoles := []rbac_v1.ClusterRole{}
+	crbs := []rbac_v1.ClusterRoleBinding{}
+	rbs := []rbac_v1.RoleBinding{}
+	// The policies whose resource has subresources, warned about once
+	withSubresources := []string{}
+
+	for i, spec := range specs {
+		if spec.Resource == "" {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("policy %d: non-resource policy skipped", i+1))
+			continue
+		}
+		if spec.User != "" && spec.User != "*" && spec.Group != "" && spec.Group != "*" {
+			// A binding to both would grant the user or the group, not the user only within the group
+			result.Warnings = append(result.Warnings, fmt.Sprintf("policy %d: matching both user %s and group %s cannot be expressed in RBAC, skipped", i+1, spec.User, spec.Group))
+			continue
+		}
+		subjects := abacSubjects(spec)
+		if len(subjects) == 0 {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("policy %d: no user or group, skipped", i+1))
+			continue
+		}
+
+		verbs := []string{"*"}
+		if spec.Readonly {
+			verbs = []string{"get", "list", "watch"}
+		}
+		// An ABAC resource includes its subresources, RBAC has no wildcard for the subresources of one resource
+		resources := []string{spec.Resource}
+		if spec.Resource != "*" {
+			withSubresources = append(withSubresources, fmt.Sprintf("%d (%s)", i+1, spec.Resource))
+		}
+		name := manifestName("abac", fmt.Sprint(i+1))
+		clusterRoles = append(clusterRoles, rbac_v1.ClusterRole{
+			ObjectMeta: meta_v1.ObjectMeta{Name: name},
+			Rules:      []rbac_v1.PolicyRule{{APIGroups: []string{spec.APIGroup}, Resources: resources, Verbs: verbs}},
+		})
+
+		roleRef := rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: name}
+		switch spec.Namespace {
+		case "*":
+			crbs = append(crbs, rbac_v1.ClusterRoleBinding{ObjectMeta: meta_v1.ObjectMeta{Name: name}, RoleRef: roleRef, Subjects: subjects})
+		case "":
+			result.Warnings = append(result.Warnings, fmt.Sprintf("policy %d: cluster-scoped only access is imported as cluster-wide access", i+1))
+			crbs = append(crbs, rbac_v1.ClusterRoleBinding{ObjectMeta: meta_v1.ObjectMeta{Name: name}, RoleRef: roleRef, Subjects: subjects})
+		default:
+			rbs = append(rbs, rbac_v1.RoleBinding{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: spec.Namespace}, RoleRef: roleRef, Subjects: subjects})
+		}
+	}
+
+	if len(withSubresources) > 0 {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("policies %s: the subresources cannot be expressed in RBAC, they are not imported", strings.Join(withSubresources, ", ")))
+	}
+	result.Snapshot = NewRBACSnapshot(clusterRoles, nil, crbs, rbs)
+	return result
+}
+
+// abacSubjects returns the RBAC subjects matching the user and group of the policy, which ABAC both requires
+// to match when set. "*" matches every user, authenticated or not.
+func abacSubjects(spec ABACPolicySpec) []rbac_v1.Subject {
+	if spec.User != "" && spec.User != "*" {
+		return []rbac_v1.Subject{subjectForUser(spec.User)}
+	}
+	if spec.Group != "" && spec.Group != "*" {
+		return []rbac_v1.Subject{{Kind: rbac_v1.GroupKind, APIGroup: rbac_v1.GroupName, Name: spec.Group}}
+	}
+	if spec.User == "*" || spec.Group == "*" {
+		return []rbac_v1.Subject{
+			{Kind: rbac_v1.GroupKind, APIGroup: rbac_v1.GroupName, Name: "system:authenticated"},
+			{Kind: rbac_v1.GroupKind, APIGroup: rbac_v1.GroupName, Name: "system:unauthenticated"},
+		}
+	}
+	return nil
+}
+
+// CompareSnapshots returns, per user name, the permissions granted by one snapshot and not the other:
+// Gained are only granted by "to", Lost only by "from". Users with identical permissions are omitted.
+// Wildcards are compared as such, not expanded.
+func CompareSnapshots(from, to *RBACSnapshot, users []UserInfo) map[string]*PermissionsDiff {
+	diffs := map[string]*PermissionsDiff{}
+	for _, user := range users {
+		gained, lost := diffPermissions(from.EffectivePermissions(user), to.EffectivePermissions(user))
+		if len(gained) > 0 || len(lost) > 0 {
+			diffs[user.Name] = &PermissionsDiff{Gained: gained, Lost: lost}
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,113 @@
+package business
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testABACPolicies = `
+# alice reads the pods of ns1
+{"apiVersion": "abac.authorization.kubernetes.io/v1beta1", "kind": "Policy", "spec": {"user": "alice", "namespace": "ns1", "resource": "pods", "readonly": true}}
+{"group": "developers", "namespace": "*", "apiGroup": "apps", "resource": "deployments"}
+{"user": "*", "nonResourcePath": "/version", "readonly": true}
+`
+
+func TestParseABACPolicies(t *testing.T) {
+	specs, err := ParseABACPolicies(strings.NewReader(testABACPolicies))
+	require.NoError(t, err)
+
+	assert.Equal(t, []ABACPolicySpec{
+		{User: "alice", Namespace: "ns1", Resource: "pods", Readonly: true},
+		{Group: "developers", Namespace: "*", APIGroup: "apps", Resource: "deployments"},
+		{User: "*", NonResourcePath: "/version", Readonly: true},
+	}, specs)
+}
+
+func TestParseABACPoliciesErrors(t *testing.T) {
+	_, err := ParseABACPolicies(strings.NewReader("\n{\"user\": "))
+	assert.ErrorContains(t, err, "invalid ABAC policy at line 2")
+
+	_, err = ParseABACPolicies(strings.NewReader(`{"apiVersion": "abac.authorization.kubernetes.io/v2", "spec": {"user": "alice"}}`))
+	assert.ErrorContains(t, err, `unsupported ABAC policy version "abac.authorization.kubernetes.io/v2" at line 1`)
+}
+
+func TestImportABACPolicies(t *testing.T) {
+	specs, err := ParseABACPolicies(strings.NewReader(testABACPolicies))
+	require.NoError(t, err)
+
+	imported := ImportABACPolicies(specs)
+	assert.Equal(t, []string{
+		"policy 3: non-resource policy skipped",
+		"policies 1 (pods), 2 (deployments): the subresources cannot be expressed in RBAC, they are not imported",
+	}, imported.Warnings)
+	alice := UserInfo{Name: "alice"}
+	developer := UserInfo{Name: "carol", Groups: []string{"developers"}}
+	cases := []struct {
+		user    UserInfo
+		req     AccessRequest
+		allowed bool
+	}{
+		{alice, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "list"}, true},
+		// Subresources are not imported
+		{alice, AccessRequest{Namespace: "ns1", Resource: "pods", Subresource: "log", Verb: "get"}, false},
+		{alice, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "delete"}, false},
+		{alice, AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "get"}, false},
+		{developer, AccessRequest{Namespace: "ns2", APIGroup: "apps", Resource: "deployments", Verb: "delete"}, true},
+		{developer, AccessRequest{Namespace: "ns2", Resource: "deployments", Verb: "delete"}, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.allowed, imported.Snapshot.Explain(c.user, c.req).Allowed, "%s %+v", c.user.Name, c.req)
+	}
+}
+
+func TestImportABACPoliciesWarnings(t *testing.T) {
+	imported := ImportABACPolicies([]ABACPolicySpec{
+		{User: "alice", Group: "developers", Resource: "pods", Namespace: "*"},
+		{Resource: "pods", Namespace: "*"},
+		{User: "*", Resource: "namespaces", Readonly: true},
+	})
+
+	assert.Equal(t, []string{
+		"policy 1: matching both user alice and group developers cannot be expressed in RBAC, skipped",
+		"policy 2: no user or group, skipped",
+		"policy 3: cluster-scoped only access is imported as cluster-wide access",
+		"policies 3 (namespaces): the subresources cannot be expressed in RBAC, they are not imported",
+	}, imported.Warnings)
+	// Everybody reads the namespaces
+	assert.True(t, imported.Snapshot.Explain(UserInfo{Name: "bob", Groups: []string{"system:authenticated"}}, AccessRequest{Resource: "namespaces", Verb: "list"}).Allowed)
+	// Neither alice nor the developers get the access granted only to alice within the developers
+	assert.False(t, imported.Snapshot.Explain(UserInfo{Name: "alice"}, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "delete"}).Allowed)
+	assert.False(t, imported.Snapshot.Explain(UserInfo{Name: "carol", Groups: []string{"developers"}}, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "delete"}).Allowed)
+}
+
+func TestImportABACPoliciesWildcardSubjects(t *testing.T) {
+	imported := ImportABACPolicies([]ABACPolicySpec{
+		{User: "*", Group: "developers", Namespace: "*", Resource: "pods"},
+		{User: "alice", Group: "*", Namespace: "*", Resource: "secrets"},
+	})
+
+	// ABAC requires both the user and the group to match, "*" only drops one of the conditions
+	assert.True(t, imported.Snapshot.Explain(UserInfo{Name: "carol", Groups: []string{"developers"}}, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "delete"}).Allowed)
+	assert.False(t, imported.Snapshot.Explain(UserInfo{Name: "bob", Groups: []string{"system:authenticated"}}, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "delete"}).Allowed)
+	assert.True(t, imported.Snapshot.Explain(UserInfo{Name: "alice"}, AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"}).Allowed)
+	assert.False(t, imported.Snapshot.Explain(UserInfo{Name: "bob", Groups: []string{"system:authenticated"}}, AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"}).Allowed)
+}
+
+func TestCompareSnapshots(t *testing.T) {
+	specs, err := ParseABACPolicies(strings.NewReader(testABACPolicies))
+	require.NoError(t, err)
+	abac := ImportABACPolicies(specs).Snapshot
+	rbac := testSnapshot(podReaderObjects()...)
+	users := []UserInfo{{Name: "alice"}, {Name: "carol", Groups: []string{"developers"}}}
+
+	diffs := CompareSnapshots(abac, rbac, users)
+	// alice reads the pods of ns1 in both
+	assert.NotContains(t, diffs, "alice")
+	require.Contains(t, diffs, "carol")
+	assert.NotEmpty(t, diffs["carol"].Gained)
+	assert.NotEmpty(t, diffs["carol"].Lost)
+	assert.Empty(t, CompareSnapshots(rbac, rbac, users))
+}
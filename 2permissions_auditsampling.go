@@ -0,0 +1,45 @@
+package business
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// AuditSampler decides which checks of a PermissionChecker are recorded by its audit sink, see
+// PermissionChecker.SetAuditSampler.
+type AuditSampler interface {
+	Sample(record AuditRecord) bool
+}
+
+// AuditSamplingPolicy is the default AuditSampler, configured by the AuditSampling of the config. Every
+// denial is recorded, and so is every check on a sensitive resource. The other allows are sampled. The
+// zero policy records the denials only.
+type AuditSamplingPolicy struct {
+	// AllowSampleRate is the fraction, between 0 and 1, of the allowed checks recorded.
+	AllowSampleRate float64 `yaml:"allow_sample_rate"`
+	// SensitiveResources are always recorded, in group/resource form, or just the resource for the core
+	// group, like the resources of the SensitivityTiers.
+	SensitiveResources []string `yaml:"sensitive_resources"`
+}
+
+// Sample implements AuditSampler.
+func (in AuditSamplingPolicy) Sample(record AuditRecord) bool {
+	if !record.Decision.Allowed {
+		return true
+	}
+	resource := record.Request.Resource
+	if record.Request.APIGroup != "" {
+		resource = record.Request.APIGroup + "/" + record.Request.Resource
+	}
+	if containsString(in.SensitiveResources, resource) {
+		return true
+	}
+	return in.AllowSampleRate > 0 && rand.Float64() < in.AllowSampleRate
+}
+
+func (in AuditSamplingPolicy) validate() error {
+	if in.AllowSampleRate < 0 || in.AllowSampleRate > 1 {
+		return fmt.Errorf("invalid audit allow sample rate %v, expected a value between 0 and 1", in.AllowSampleRate)
+	}
+	return nil
+}
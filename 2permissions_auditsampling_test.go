@@ -0,0 +1,75 @@
+package business
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditSamplingPolicy(t *testing.T) {
+	denied := AuditRecord{Request: AccessRequest{Resource: "pods", Verb: "get"}}
+	allowed := AuditRecord{Request: AccessRequest{Resource: "pods", Verb: "get"}, Decision: Decision{Allowed: true}}
+	secret := AuditRecord{Request: AccessRequest{Resource: "secrets", Verb: "get"}, Decision: Decision{Allowed: true}}
+	deployment := AuditRecord{Request: AccessRequest{APIGroup: "apps", Resource: "deployments", Verb: "update"}, Decision: Decision{Allowed: true}}
+
+	// The zero policy records the denials only
+	policy := AuditSamplingPolicy{}
+	assert.True(t, policy.Sample(denied))
+	assert.False(t, policy.Sample(allowed))
+
+	policy = AuditSamplingPolicy{SensitiveResources: []string{"secrets", "apps/deployments"}}
+	assert.True(t, policy.Sample(secret))
+	assert.True(t, policy.Sample(deployment))
+	assert.False(t, policy.Sample(allowed))
+
+	policy = AuditSamplingPolicy{AllowSampleRate: 1}
+	assert.True(t, policy.Sample(allowed))
+
+	assert.NoError(t, AuditSamplingPolicy{AllowSampleRate: 0.5}.validate())
+	assert.Error(t, AuditSamplingPolicy{AllowSampleRate: -0.1}.validate())
+	assert.Error(t, AuditSamplingPolicy{AllowSampleRate: 1.5}.validate())
+}
+
+// auditNothing is an AuditSampler recording no check.
+type auditNothing struct{}
+
+func (auditNothing) Sample(record AuditRecord) bool { return false }
+
+func TestCheckerAuditSampling(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.AuditSampling = AuditSamplingPolicy{SensitiveResources: []string{"secrets"}}
+	checker := newTestChecker(&testReviews{allow: allowUsers("alice")}, conf)
+	sink := &testAuditSink{}
+	checker.SetAuditSink(sink)
+	check := func(user string, req AccessRequest) {
+		_, err := checker.Check(testCtx, UserInfo{Name: user}, req)
+		require.NoError(t, err)
+	}
+
+	check("alice", AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"})
+	check("alice", AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"})
+	check("bob", AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"})
+	require.Len(t, sink.records, 2)
+	assert.Equal(t, "secrets", sink.records[0].Request.Resource)
+	assert.Equal(t, "bob", sink.records[1].User.Name)
+
+	// A custom sampler replaces the policy of the config, until it is reset
+	checker.SetAuditSampler(auditNothing{})
+	check("bob", AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "get"})
+	assert.Len(t, sink.records, 2)
+	checker.SetAuditSampler(nil)
+	check("bob", AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "list"})
+	assert.Len(t, sink.records, 3)
+}
+
+func TestAuditSampleRateFromEnv(t *testing.T) {
+	t.Setenv(PermissionsConfigEnvPrefix+"AUDIT_ALLOW_SAMPLE_RATE", "0.25")
+	conf, err := LoadPermissionsConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, 0.25, conf.AuditSampling.AllowSampleRate)
+
+	t.Setenv(PermissionsConfigEnvPrefix+"AUDIT_ALLOW_SAMPLE_RATE", "often")
+	_, err = LoadPermissionsConfig("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,273 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kiali/kiali/log"
+)
+
+// AuthorizerSubjectAccessReview is the name of the built-in authorizer asking the apiserver.
+const AuthorizerSubjectAccessReview = "subjectaccessreview"
+
+// Authorizer chain modes
+const (
+	// ChainModeFirstMatch lets the first authorizer allowing or denying the request decide, like the apiserver.
+	ChainModeFirstMatch = "first-match"
+	// ChainModeFirstAllow allows the request if any authorizer allows it, ignoring the denials.
+	ChainModeFirstAllow = "first-allow"
+	// ChainModeDenyOverrides asks every authorizer, and denies the request if any of them denies it.
+	ChainModeDenyOverrides = "deny-overrides"
+	// ChainModeQuorum allows the request when enough authorizers allow it, see PermissionsConfig.ChainQuorum.
+	ChainModeQuorum = "quorum"
+)
+
+// Error policies of the authorizers
+const (
+	// AuthorizerOnErrorFail stops the chain and fails the check.
+	AuthorizerOnErrorFail = "fail"
+	// AuthorizerOnErrorDeny handles the error as an explicit denial.
+	AuthorizerOnErrorDeny = "deny"
+	// AuthorizerOnErrorSkip handles the error as no opinion, so the next authorizers decide.
+	AuthorizerOnErrorSkip = "skip"
+)
+
+// Authorizer makes a decision about a request of a user. Like the apiserver authorizers, it can allow
+// it, deny it, or have no opinion (neither Allowed nor Denied) to let the next authorizer of the chain decide.
+type Authorizer interface {
+	Authorize(ctx context.Context, user UserInfo, req AccessRequest) (Decision, error)
+}
+
+// AuthorizerFactory creates an authorizer from the options of its AuthorizerConfig.
+type AuthorizerFactory func(client PermissionsClient, options map[string]string) (Authorizer, error)
+
+var authorizerRegistry = struct {
+	sync.RWMutex
+	factories map[string]AuthorizerFactory
+}{
+	factories: map[string]AuthorizerFactory{
+		AuthorizerSubjectAccessReview: func(client PermissionsClient, options map[string]string) (Authorizer, error) {
+			return &subjectAccessReviewAuthorizer{client: client}, nil
+		},
+	},
+}
+
+// RegisterAuthorizer makes an authorizer available to the chains of the checkers under the name, so
+// deployments can insert bespoke authorizers (e.g. a tenancy database lookup) into the decisions.
+// It is usually called from an init function. It panics if the name is already registered.
+func RegisterAuthorizer(name string, factory AuthorizerFactory) {
+	authorizerRegistry.Lock()
+	defer authorizerRegistry.Unlock()
+	if factory == nil {
+		panic("permissions: nil factory for authorizer " + name)
+	}
+	if _, dup := authorizerRegistry.factories[name]; dup {
+		panic("permissions: authorizer " + name + " registered twice")
+	}
+	authorizerRegistry.factories[name] = factory
+}
+
+// RegisteredAuthorizers returns the names of the registered authorizers, sorted.
+func RegisteredAuthorizers() []string {
+	authorizerRegistry.RLock()
+	defer authorizerRegistry.RUnlock()
+	names := make([]string, 0, len(authorizerRegistry.factories))
+	for name := range authorizerRegistry.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type namedAuthorizer struct {
+	name       string
+	authorizer Authorizer
+	timeout    time.Duration
+	onError    string
+}
+
+// authorizerChain combines the decisions of several authorizers according to its mode.
+type authorizerChain struct {
+	mode        string
+	quorum      int
+	authorizers []namedAuthorizer
+}
+
+// buildAuthorizerChain creates the chain of the config, instantiating its authorizers from the registry.
+func buildAuthorizerChain(client PermissionsClient, conf *PermissionsConfig) (*authorizerChain, error) {
+	confs := conf.Authorizers
+	if len(confs) == 0 {
+		confs = []AuthorizerConfig{{Name: AuthorizerSubjectAccessReview}}
+	}
+
+	chain := &authorizerChain{mode: conf.ChainMode, quorum: conf.ChainQuorum, authorizers: make([]namedAuthorizer, 0, len(confs))}
+	switch chain.mode {
+	case "":
+		chain.mode = ChainModeFirstMatch
+	case ChainModeFirstMatch, ChainModeFirstAllow, ChainModeDenyOverrides, ChainModeQuorum:
+	default:
+		return nil, fmt.Errorf("unknown authorizer chain mode %q", conf.ChainMode)
+	}
+	if chain.mode == ChainModeQuorum && (chain.quorum < 1 || chain.quorum > len(confs)) {
+		return nil, fmt.Errorf("quorum %d cannot be reached by %d authorizers", chain.quorum, len(confs))
+	}
+
+	authorizerRegistry.RLock()
+	defer authorizerRegistry.RUnlock()
+	for _, c := range confs {
+		switch c.OnError {
+		case "":
+			c.OnError = AuthorizerOnErrorFail
+		case AuthorizerOnErrorFail, AuthorizerOnErrorDeny, AuthorizerOnErrorSkip:
+		default:
+			return nil, fmt.Errorf("unknown error policy %q of authorizer %s", c.OnError, c.Name)
+		}
+		factory, ok := authorizerRegistry.factories[c.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown authorizer %q", c.Name)
+		}
+		if c.Name == AuthorizerCEL && !conf.FeatureGates.Enabled(FeatureCELAuthorizer) {
+			return nil, fmt.Errorf("authorizer %s needs the %s feature gate", c.Name, FeatureCELAuthorizer)
+		}
+		authorizer, err := factory(client, c.Options)
+		if err != nil {
+			return nil, fmt.Errorf("error creating authorizer %s: %w", c.Name, err)
+		}
+		chain.authorizers = append(chain.authorizers, namedAuthorizer{name: c.Name, authorizer: authorizer, timeout: c.Timeout, onError: c.OnError})
+	}
+	return chain, nil
+}
+
+// authorize combines the decisions of the authorizers according to the mode of the chain. The request is
+// denied if no authorizer has an opinion. An error of an authorizer whose error policy is fail stops the chain.
+// The errors handled by the deny and skip policies are kept in the EvaluationError of the combined decision,
+// so it is not cached.
+func (in *authorizerChain) authorize(ctx context.Context, user UserInfo, req AccessRequest) (combined Decision, err error) {
+	var evaluationErrors []string
+	defer func() {
+		if combined.EvaluationError == "" && len(evaluationErrors) > 0 {
+			combined.EvaluationError = strings.Join(evaluationErrors, "; ")
+		}
+	}()
+
+	var firstAllow, firstDeny *Decision
+	allows := 0
+	for _, a := range in.authorizers {
+		decision, err := a.call(ctx, user, req)
+		if err != nil {
+			return decision, err
+		}
+		if decision.EvaluationError != "" {
+			evaluationErrors = append(evaluationErrors, a.name+": "+decision.EvaluationError)
+		}
+
+		switch {
+		case decision.Allowed:
+			allows++
+			if firstAllow == nil {
+				firstAllow = &decision
+			}
+		case decision.Denied:
+			if firstDeny == nil {
+				firstDeny = &decision
+			}
+		default:
+			continue
+		}
+
+		// Stop as soon as the outcome is known
+		switch in.mode {
+		case ChainModeFirstMatch:
+			return decision, nil
+		case ChainModeFirstAllow:
+			if decision.Allowed {
+				return decision, nil
+			}
+		case ChainModeQuorum:
+			if allows >= in.quorum {
+				decision.Reason = fmt.Sprintf("allowed by %d of %d authorizers: %s", allows, len(in.authorizers), decision.Reason)
+				return decision, nil
+			}
+		}
+	}
+
+	switch {
+	case in.mode == ChainModeDenyOverrides && firstDeny != nil:
+		return *firstDeny, nil
+	case in.mode == ChainModeDenyOverrides && firstAllow != nil:
+		return *firstAllow, nil
+	case in.mode == ChainModeQuorum:
+		return Decision{Denied: firstDeny != nil, Reason: fmt.Sprintf("allowed by %d of %d authorizers, %d needed", allows, len(in.authorizers), in.quorum), Source: in.source(), Timestamp: time.Now()}, nil
+	case firstDeny != nil:
+		return *firstDeny, nil
+	}
+	return Decision{Reason: "no authorizer had an opinion", Source: in.source(), Timestamp: time.Now()}, nil
+}
+
+// reviewsOnly tells if the chain is the built-in authorizer alone, whose decisions are the ones of the
+// apiserver.
+func (in *authorizerChain) reviewsOnly() bool {
+	return len(in.authorizers) == 1 && in.authorizers[0].name == AuthorizerSubjectAccessReview
+}
+
+// source is the source of the decisions made by the chain as a whole.
+func (in *authorizerChain) source() string {
+	if len(in.authorizers) == 1 {
+		return in.authorizers[0].name
+	}
+	return "chain"
+}
+
+// call asks the authorizer, applying its timeout and its error policy. Its panics are denials.
+func (in namedAuthorizer) call(ctx context.Context, user UserInfo, req AccessRequest) (Decision, error) {
+	if in.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, in.timeout)
+		defer cancel()
+	}
+
+	decision, err := in.authorize(ctx, user, req)
+	if decision.Source == "" {
+		decision.Source = in.name
+	}
+	if err == nil {
+		return decision, nil
+	}
+
+	switch in.onError {
+	case AuthorizerOnErrorDeny:
+		log.Warningf("%sAuthorizer %s failed, denying: %v", logPrefix(ctx), in.name, err)
+		return Decision{Denied: true, Reason: "authorizer " + in.name + " failed", EvaluationError: err.Error(), Source: in.name, Timestamp: time.Now()}, nil
+	case AuthorizerOnErrorSkip:
+		log.Warningf("%sAuthorizer %s failed, skipping it: %v", logPrefix(ctx), in.name, err)
+		return Decision{EvaluationError: err.Error(), Source: in.name, Timestamp: time.Now()}, nil
+	default:
+		return decision, err
+	}
+}
+
+// subjectAccessReviewAuthorizer asks the apiserver with SubjectAccessReviews.
+type subjectAccessReviewAuthorizer struct {
+	client PermissionsClient
+}
+
+func (in *subjectAccessReviewAuthorizer) Authorize(ctx context.Context, user UserInfo, req AccessRequest) (Decision, error) {
+	// The client of the checkers schedules the reviews by the priority of the context, see NewLimitedClient
+	sar, err := in.client.CreateSubjectAccessReview(ctx, subjectAccessReviewFor(user, req))
+	if err != nil {
+		log.Errorf("%sError checking permissions of user %s: %v", logPrefix(ctx), redactedUser(user.Name), err)
+		return Decision{Source: DecisionSourceAPIServer, EvaluationError: err.Error(), Timestamp: time.Now()}, withRequestID(ctx, fmt.Errorf("error checking permissions: %w", err))
+	}
+	return Decision{
+		Allowed:         sar.Status.Allowed,
+		Denied:          sar.Status.Denied,
+		Reason:          sar.Status.Reason,
+		EvaluationError: sar.Status.EvaluationError,
+		Source:          DecisionSourceAPIServer,
+		Timestamp:       time.Now(),
+	}, nil
+}
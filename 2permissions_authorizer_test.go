@@ -0,0 +1,315 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testAuthorizerName is the name of testAuthorizer in the authorizer registry.
+const testAuthorizerName = "test"
+
+// testAuthorizer allows the users of its "allow" option and denies the ones of its "deny" option, both comma
+// separated, and has no opinion about the others. With its "names" option, it only decides on the objects
+// of these names. It fails when its "fail" option is set, and waits for the end of the context when its
+// "wait" option is set.
+type testAuthorizer struct {
+	allow, deny, names []string
+	fail, wait         bool
+}
+
+var errTestAuthorizer = errors.New("tenancy database unavailable")
+
+func init() {
+	RegisterAuthorizer(testAuthorizerName, func(client PermissionsClient, options map[string]string) (Authorizer, error) {
+		if _, ok := options["invalid"]; ok {
+			return nil, errors.New("invalid option")
+		}
+		return &testAuthorizer{
+			allow: strings.Split(options["allow"], ","),
+			deny:  strings.Split(options["deny"], ","),
+			names: strings.FieldsFunc(options["names"], func(r rune) bool { return r == ',' }),
+			fail:  options["fail"] != "",
+			wait:  options["wait"] != "",
+		}, nil
+	})
+}
+
+func (in *testAuthorizer) Authorize(ctx context.Context, user UserInfo, req AccessRequest) (Decision, error) {
+	switch {
+	case in.wait:
+		<-ctx.Done()
+		return Decision{}, ctx.Err()
+	case in.fail:
+		return Decision{}, errTestAuthorizer
+	case len(in.names) > 0 && !containsString(in.names, req.Name):
+		return Decision{}, nil
+	case containsString(in.allow, user.Name):
+		return Decision{Allowed: true, Reason: "allowed by the test authorizer"}, nil
+	case containsString(in.deny, user.Name):
+		return Decision{Denied: true, Reason: "denied by the test authorizer"}, nil
+	}
+	return Decision{}, nil
+}
+
+// testAuthorizerConfig returns the config of a testAuthorizer with the options, given as key-value pairs.
+func testAuthorizerConfig(options ...string) AuthorizerConfig {
+	conf := AuthorizerConfig{Name: testAuthorizerName, Options: map[string]string{}}
+	for i := 0; i+1 < len(options); i += 2 {
+		conf.Options[options[i]] = options[i+1]
+	}
+	return conf
+}
+
+func TestRegisteredAuthorizers(t *testing.T) {
+	assert.Subset(t, RegisteredAuthorizers(), []string{AuthorizerSubjectAccessReview, testAuthorizerName})
+
+	assert.Panics(t, func() {
+		RegisterAuthorizer(testAuthorizerName, func(PermissionsClient, map[string]string) (Authorizer, error) { return nil, nil })
+	})
+	assert.Panics(t, func() { RegisterAuthorizer("nil", nil) })
+	assert.NotContains(t, RegisteredAuthorizers(), "nil")
+}
+
+func TestCheckerUsesTheConfiguredAuthorizers(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.Authorizers = []AuthorizerConfig{
+		testAuthorizerConfig("allow", "alice", "deny", "mallory"),
+		{Name: AuthorizerSubjectAccessReview},
+	}
+	reviews := &testReviews{allow: allowUsers("bob")}
+	checker := newTestChecker(reviews, conf)
+
+	// The first authorizer with an opinion decides
+	decision, err := checker.Check(testCtx, UserInfo{Name: "alice"}, aliceSecrets)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, testAuthorizerName, decision.Source)
+	decision, err = checker.Check(testCtx, UserInfo{Name: "mallory"}, aliceSecrets)
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, "denied by the test authorizer", decision.Reason)
+	assert.Zero(t, reviews.calls.Load())
+
+	decision, err = checker.Check(testCtx, UserInfo{Name: "bob"}, aliceSecrets)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, DecisionSourceAPIServer, decision.Source)
+	assert.Equal(t, int64(1), reviews.calls.Load())
+}
+
+func TestApplyConfigPurgesTheDecisionsOfThePreviousChain(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.Authorizers = []AuthorizerConfig{testAuthorizerConfig("allow", "alice")}
+	checker := newTestChecker(&testReviews{}, conf)
+	decision, err := checker.Check(testCtx, UserInfo{Name: "alice"}, aliceSecrets)
+	require.NoError(t, err)
+	require.True(t, decision.Allowed)
+
+	// The decisions survive the unrelated changes
+	unrelated := *conf
+	unrelated.MaxConcurrentAPIRequests = 5
+	require.NoError(t, checker.ApplyConfig(&unrelated))
+	decision, err = checker.Check(testCtx, UserInfo{Name: "alice"}, aliceSecrets)
+	require.NoError(t, err)
+	assert.Equal(t, DecisionSourceCache, decision.Source)
+
+	allowThenDeny := []AuthorizerConfig{testAuthorizerConfig("allow", "alice"), testAuthorizerConfig("deny", "alice")}
+	for name, change := range map[string]func(previous, changed *PermissionsConfig){
+		"authorizers": func(previous, changed *PermissionsConfig) {
+			changed.Authorizers = []AuthorizerConfig{testAuthorizerConfig("deny", "alice")}
+		},
+		"chain mode": func(previous, changed *PermissionsConfig) {
+			previous.Authorizers, changed.Authorizers = allowThenDeny, allowThenDeny
+			changed.ChainMode = ChainModeDenyOverrides
+		},
+		"quorum": func(previous, changed *PermissionsConfig) {
+			previous.Authorizers, changed.Authorizers = allowThenDeny, allowThenDeny
+			previous.ChainMode, changed.ChainMode = ChainModeQuorum, ChainModeQuorum
+			previous.ChainQuorum, changed.ChainQuorum = 1, 2
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			previous, changed := *conf, *conf
+			change(&previous, &changed)
+			require.NoError(t, checker.ApplyConfig(&previous))
+			decision, err := checker.Check(testCtx, UserInfo{Name: "alice"}, aliceSecrets)
+			require.NoError(t, err)
+			require.True(t, decision.Allowed)
+
+			require.NoError(t, checker.ApplyConfig(&changed))
+			decision, err = checker.Check(testCtx, UserInfo{Name: "alice"}, aliceSecrets)
+			require.NoError(t, err)
+			assert.False(t, decision.Allowed)
+			assert.NotEqual(t, DecisionSourceCache, decision.Source)
+		})
+	}
+}
+
+func TestChainWithoutOpinionDenies(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.Authorizers = []AuthorizerConfig{testAuthorizerConfig("allow", "alice")}
+	chain, err := buildAuthorizerChain(nil, conf)
+	require.NoError(t, err)
+
+	decision, err := chain.authorize(testCtx, UserInfo{Name: "bob"}, aliceSecrets)
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, "no authorizer had an opinion", decision.Reason)
+	assert.Equal(t, testAuthorizerName, decision.Source)
+}
+
+func TestBuildAuthorizerChainErrors(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.Authorizers = []AuthorizerConfig{{Name: "tenancy"}}
+	_, err := buildAuthorizerChain(nil, conf)
+	assert.ErrorContains(t, err, `unknown authorizer "tenancy"`)
+
+	conf.Authorizers = []AuthorizerConfig{testAuthorizerConfig("invalid", "")}
+	_, err = buildAuthorizerChain(nil, conf)
+	assert.ErrorContains(t, err, "error creating authorizer test: invalid option")
+
+	// Invalid chains are rejected with the config
+	checker := newTestChecker(&testReviews{}, nil)
+	assert.Error(t, checker.ApplyConfig(conf))
+}
+
+// testChain builds the chain of the authorizers, combined according to the mode.
+func testChain(t *testing.T, mode string, quorum int, authorizers ...AuthorizerConfig) *authorizerChain {
+	t.Helper()
+	conf := NewPermissionsConfig()
+	conf.ChainMode = mode
+	conf.ChainQuorum = quorum
+	conf.Authorizers = authorizers
+	chain, err := buildAuthorizerChain(nil, conf)
+	require.NoError(t, err)
+	return chain
+}
+
+func TestAuthorizerChainModes(t *testing.T) {
+	authorizers := []AuthorizerConfig{
+		testAuthorizerConfig("allow", "bob", "deny", "alice"),
+		testAuthorizerConfig("allow", "alice,bob"),
+		testAuthorizerConfig("allow", "alice"),
+	}
+	cases := []struct {
+		mode    string
+		quorum  int
+		user    string
+		allowed bool
+		reason  string
+	}{
+		{"", 0, "alice", false, "denied by the test authorizer"},
+		{ChainModeFirstMatch, 0, "bob", true, "allowed by the test authorizer"},
+		{ChainModeFirstAllow, 0, "alice", true, "allowed by the test authorizer"},
+		{ChainModeDenyOverrides, 0, "alice", false, "denied by the test authorizer"},
+		{ChainModeDenyOverrides, 0, "bob", true, "allowed by the test authorizer"},
+		{ChainModeDenyOverrides, 0, "carol", false, "no authorizer had an opinion"},
+		{ChainModeQuorum, 2, "alice", true, "allowed by 2 of 3 authorizers: allowed by the test authorizer"},
+		{ChainModeQuorum, 3, "alice", false, "allowed by 2 of 3 authorizers, 3 needed"},
+	}
+	for _, c := range cases {
+		chain := testChain(t, c.mode, c.quorum, authorizers...)
+		decision, err := chain.authorize(testCtx, UserInfo{Name: c.user}, aliceSecrets)
+		require.NoError(t, err)
+		assert.Equal(t, c.allowed, decision.Allowed, "%s %s", c.mode, c.user)
+		assert.Equal(t, c.reason, decision.Reason, "%s %s", c.mode, c.user)
+	}
+}
+
+func TestAuthorizerChainQuorumDenial(t *testing.T) {
+	chain := testChain(t, ChainModeQuorum, 2,
+		testAuthorizerConfig("deny", "alice"),
+		testAuthorizerConfig("allow", "alice"),
+	)
+
+	decision, err := chain.authorize(testCtx, UserInfo{Name: "alice"}, aliceSecrets)
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.True(t, decision.Denied)
+	assert.Equal(t, "chain", decision.Source)
+}
+
+func TestAuthorizerErrorPolicies(t *testing.T) {
+	failing := testAuthorizerConfig("fail", "true")
+	allowing := testAuthorizerConfig("allow", "alice")
+
+	// fail stops the chain
+	_, err := testChain(t, ChainModeFirstMatch, 0, failing, allowing).authorize(testCtx, UserInfo{Name: "alice"}, aliceSecrets)
+	assert.ErrorIs(t, err, errTestAuthorizer)
+
+	failing.OnError = AuthorizerOnErrorDeny
+	decision, err := testChain(t, ChainModeFirstMatch, 0, failing, allowing).authorize(testCtx, UserInfo{Name: "alice"}, aliceSecrets)
+	require.NoError(t, err)
+	assert.True(t, decision.Denied)
+	assert.Equal(t, "authorizer test failed", decision.Reason)
+	assert.Equal(t, errTestAuthorizer.Error(), decision.EvaluationError)
+
+	failing.OnError = AuthorizerOnErrorSkip
+	decision, err = testChain(t, ChainModeFirstMatch, 0, failing, allowing).authorize(testCtx, UserInfo{Name: "alice"}, aliceSecrets)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	// The skipped error is kept, so the decision is not cached
+	assert.Equal(t, "test: "+errTestAuthorizer.Error(), decision.EvaluationError)
+}
+
+func TestDecisionsWithEvaluationErrorsAreNotCached(t *testing.T) {
+	failing := testAuthorizerConfig("fail", "true")
+	failing.OnError = AuthorizerOnErrorSkip
+	conf := NewPermissionsConfig()
+	conf.Authorizers = []AuthorizerConfig{failing, testAuthorizerConfig("allow", "alice")}
+	checker := newTestChecker(&testReviews{}, nil)
+	require.NoError(t, checker.ApplyConfig(conf))
+
+	for i := 0; i < 2; i++ {
+		decision, err := checker.Check(testCtx, UserInfo{Name: "alice"}, aliceSecrets)
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+		assert.NotEqual(t, DecisionSourceCache, decision.Source)
+	}
+}
+
+func TestBuildAuthorizerChainRejectsUnreachableQuorums(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.ChainMode = ChainModeQuorum
+	conf.Authorizers = []AuthorizerConfig{testAuthorizerConfig("allow", "alice"), testAuthorizerConfig("allow", "bob")}
+	for _, quorum := range []int{0, 3} {
+		conf.ChainQuorum = quorum
+		_, err := buildAuthorizerChain(nil, conf)
+		assert.ErrorContains(t, err, fmt.Sprintf("quorum %d cannot be reached by 2 authorizers", quorum))
+		assert.ErrorContains(t, conf.Validate(), fmt.Sprintf("quorum %d cannot be reached by 2 authorizers", quorum))
+	}
+}
+
+func TestAuthorizerTimeout(t *testing.T) {
+	waiting := testAuthorizerConfig("wait", "true")
+	waiting.Timeout = 10 * time.Millisecond
+
+	_, err := testChain(t, ChainModeFirstMatch, 0, waiting).authorize(testCtx, UserInfo{Name: "alice"}, aliceSecrets)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	waiting.OnError = AuthorizerOnErrorSkip
+	decision, err := testChain(t, ChainModeFirstMatch, 0, waiting, testAuthorizerConfig("allow", "alice")).authorize(testCtx, UserInfo{Name: "alice"}, aliceSecrets)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+}
+
+func TestBuildAuthorizerChainRejectsUnknownPolicies(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.ChainMode = "unanimity"
+	_, err := buildAuthorizerChain(nil, conf)
+	assert.ErrorContains(t, err, `unknown authorizer chain mode "unanimity"`)
+
+	conf.ChainMode = ChainModeFirstMatch
+	authorizer := testAuthorizerConfig()
+	authorizer.OnError = "retry"
+	conf.Authorizers = []AuthorizerConfig{authorizer}
+	_, err = buildAuthorizerChain(nil, conf)
+	assert.ErrorContains(t, err, `unknown error policy "retry" of authorizer test`)
+}
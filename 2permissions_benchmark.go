@@ -0,0 +1,179 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	auth_v1 "k8s.io/api/authorization/v1"
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+)
+
+// WorkloadCheck is a check of a comparison workload file, see LoadComparisonWorkload.
+type WorkloadCheck struct {
+	User          string   `yaml:"user"`
+	Groups        []string `yaml:"groups"`
+	AccessRequest `yaml:",inline"`
+}
+
+// LoadComparisonWorkload reads the checks of a CompareEvaluation from a YAML file listing them, e.g.
+//
+//   - user: alice
+//     groups: [developers]
+//     namespace: prod
+//     resource: pods
+//     subresource: log
+//     verb: get
+func LoadComparisonWorkload(path string) ([]ConformanceCase, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workload file %s: %w", path, err)
+	}
+	checks := []WorkloadCheck{}
+	if err := yaml.Unmarshal(content, &checks); err != nil {
+		return nil, fmt.Errorf("failed to parse workload file %s: %w", path, err)
+	}
+	cases := make([]ConformanceCase, 0, len(checks))
+	for i, check := range checks {
+		if check.Verb == "" || check.Resource == "" {
+			return nil, fmt.Errorf("check %d of workload file %s: the verb and resource are required", i, path)
+		}
+		cases = append(cases, ConformanceCase{User: UserInfo{Name: check.User, Groups: check.Groups}, Request: check.AccessRequest})
+	}
+	return cases, nil
+}
+
+// LatencySummary are the percentiles of the latencies of a path of a ComparisonReport.
+type LatencySummary struct {
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+// ComparisonReport compares the local evaluation on an RBAC snapshot with the SubjectAccessReviews of
+// the apiserver on the same workload, see CompareEvaluation.
+type ComparisonReport struct {
+	Checks int `json:"checks"`
+	// SnapshotLoad is the time taken to load the snapshot of the local path. The PermissionWatcher keeps
+	// it up to date instead in production, so it is not part of the latencies of the checks.
+	SnapshotLoad time.Duration  `json:"snapshotLoad"`
+	Local        LatencySummary `json:"local"`
+	Live         LatencySummary `json:"live"`
+	// LocalAPIServerCalls are the list calls loading the snapshot, LiveAPIServerCalls a review per check.
+	LocalAPIServerCalls int64 `json:"localAPIServerCalls"`
+	LiveAPIServerCalls  int64 `json:"liveAPIServerCalls"`
+	// Disagreements are the checks decided differently by the two paths.
+	Disagreements []ConformanceMismatch `json:"disagreements"`
+}
+
+// CompareEvaluation runs every case repeat times through both the local evaluation and the
+// SubjectAccessReviews of the client, to justify enabling the local fast path (see SetVerificationSource)
+// before doing so in production. The checks are run one at a time so the latencies are not skewed by
+// the client rate limits, and the decision cache is not used. Unlike permissionstest.CheckConformance,
+// it can run against a production cluster: other authorizers than RBAC show up as disagreements.
+func CompareEvaluation(ctx context.Context, client PermissionsClient, cases []ConformanceCase, repeat int) (*ComparisonReport, error) {
+	if repeat < 1 {
+		repeat = 1
+	}
+	report := &ComparisonReport{Disagreements: []ConformanceMismatch{}}
+
+	local := &countingRBACClient{PermissionsClient: client}
+	start := time.Now()
+	snapshot, err := LoadRBACSnapshot(ctx, local)
+	if err != nil {
+		return nil, err
+	}
+	report.SnapshotLoad = time.Since(start)
+	report.LocalAPIServerCalls = local.calls.Load()
+
+	live := &countingRBACClient{PermissionsClient: client}
+	authorizer := &subjectAccessReviewAuthorizer{client: live}
+	localLatencies := make([]time.Duration, 0, len(cases)*repeat)
+	liveLatencies := make([]time.Duration, 0, len(cases)*repeat)
+	for i := 0; i < repeat; i++ {
+		for _, c := range cases {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			start := time.Now()
+			explanation := snapshot.Explain(c.User, c.Request)
+			localLatencies = append(localLatencies, time.Since(start))
+
+			start = time.Now()
+			decision, err := authorizer.Authorize(ctx, c.User, c.Request)
+			if err != nil {
+				return nil, err
+			}
+			liveLatencies = append(liveLatencies, time.Since(start))
+
+			// The disagreements are the same on every repetition
+			if i == 0 && explanation.Allowed != decision.Allowed {
+				report.Disagreements = append(report.Disagreements, ConformanceMismatch{Case: c, Local: explanation.Allowed, Live: decision.Allowed, Explanation: explanation})
+			}
+		}
+	}
+
+	report.Checks = len(localLatencies)
+	report.Local = summarizeLatencies(localLatencies)
+	report.Live = summarizeLatencies(liveLatencies)
+	report.LiveAPIServerCalls = live.calls.Load()
+	return report, nil
+}
+
+// summarizeLatencies returns the nearest-rank percentiles of the latencies, which it sorts.
+func summarizeLatencies(latencies []time.Duration) LatencySummary {
+	if len(latencies) == 0 {
+		return LatencySummary{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p int) time.Duration {
+		rank := (p*len(latencies) + 99) / 100
+		if rank < 1 {
+			rank = 1
+		}
+		return latencies[rank-1]
+	}
+	return LatencySummary{P50: percentile(50), P90: percentile(90), P99: percentile(99), Max: latencies[len(latencies)-1]}
+}
+
+// countingRBACClient counts the apiserver calls of the local and the live evaluation.
+type countingRBACClient struct {
+	PermissionsClient
+	calls atomic.Int64
+}
+
+func (in *countingRBACClient) CreateSubjectAccessReview(ctx context.Context, sar *auth_v1.SubjectAccessReview) (*auth_v1.SubjectAccessReview, error) {
+	in.calls.Add(1)
+	return in.PermissionsClient.CreateSubjectAccessReview(ctx, sar)
+}
+
+func (in *countingRBACClient) ListClusterRoles(ctx context.Context) ([]rbac_v1.ClusterRole, error) {
+	in.calls.Add(1)
+	return in.PermissionsClient.ListClusterRoles(ctx)
+}
+
+func (in *countingRBACClient) ListClusterRoleBindings(ctx context.Context) ([]rbac_v1.ClusterRoleBinding, error) {
+	in.calls.Add(1)
+	return in.PermissionsClient.ListClusterRoleBindings(ctx)
+}
+
+func (in *countingRBACClient) ListRoles(ctx context.Context, namespace string) ([]rbac_v1.Role, error) {
+	in.calls.Add(1)
+	return in.PermissionsClient.ListRoles(ctx, namespace)
+}
+
+func (in *countingRBACClient) ListNamespaces(ctx context.Context) ([]core_v1.Namespace, error) {
+	in.calls.Add(1)
+	return listClientNamespaces(ctx, in.PermissionsClient)
+}
+
+func (in *countingRBACClient) ListRoleBindings(ctx context.Context, namespace string) ([]rbac_v1.RoleBinding, error) {
+	in.calls.Add(1)
+	return in.PermissionsClient.ListRoleBindings(ctx, namespace)
+}
@@ -0,0 +1,82 @@
+package business
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareEvaluation(t *testing.T) {
+	// The apiserver lets alice read the pods of every namespace, and nobody else
+	reviews := &testReviews{allow: allowUsers("alice")}
+	client := newTestClient(reviews, podReaderObjects()...)
+	cases := []ConformanceCase{
+		{User: UserInfo{Name: "alice"}, Request: AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}},
+		{User: UserInfo{Name: "alice"}, Request: AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "get"}},
+		{User: UserInfo{Name: "bob"}, Request: AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}},
+	}
+
+	report, err := CompareEvaluation(testCtx, client, cases, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 6, report.Checks)
+	assert.Equal(t, int64(4), report.LocalAPIServerCalls)
+	assert.Equal(t, int64(6), report.LiveAPIServerCalls)
+	assert.Equal(t, int64(6), reviews.calls.Load())
+	assert.LessOrEqual(t, report.Live.P50, report.Live.Max)
+
+	// The disagreements are reported once, whatever the repetitions
+	require.Len(t, report.Disagreements, 2)
+	assert.Equal(t, cases[1], report.Disagreements[0].Case)
+	assert.True(t, report.Disagreements[0].Live)
+	assert.Equal(t, cases[2], report.Disagreements[1].Case)
+	assert.True(t, report.Disagreements[1].Local)
+	assert.NotNil(t, report.Disagreements[1].Explanation)
+
+	reviews.err = errTestAPIServer
+	_, err = CompareEvaluation(testCtx, client, cases, 1)
+	assert.Error(t, err)
+}
+
+func TestSummarizeLatencies(t *testing.T) {
+	assert.Equal(t, LatencySummary{}, summarizeLatencies(nil))
+
+	latencies := make([]time.Duration, 0, 100)
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, LatencySummary{P50: 50 * time.Millisecond, P90: 90 * time.Millisecond, P99: 99 * time.Millisecond, Max: 100 * time.Millisecond}, summarizeLatencies(latencies))
+	assert.Equal(t, LatencySummary{P50: time.Second, P90: time.Second, P99: time.Second, Max: time.Second}, summarizeLatencies([]time.Duration{time.Second}))
+}
+
+func TestLoadComparisonWorkload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workload.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+- user: alice
+  groups: [developers]
+  namespace: ns1
+  resource: pods
+  subresource: log
+  verb: get
+- user: bob
+  api_group: apps
+  resource: deployments
+  verb: list
+`), 0o600))
+
+	cases, err := LoadComparisonWorkload(path)
+	require.NoError(t, err)
+	assert.Equal(t, []ConformanceCase{
+		{User: UserInfo{Name: "alice", Groups: []string{"developers"}}, Request: AccessRequest{Namespace: "ns1", Resource: "pods", Subresource: "log", Verb: "get"}},
+		{User: UserInfo{Name: "bob"}, Request: AccessRequest{APIGroup: "apps", Resource: "deployments", Verb: "list"}},
+	}, cases)
+
+	require.NoError(t, os.WriteFile(path, []byte("- user: alice\n  resource: pods\n"), 0o600))
+	_, err = LoadComparisonWorkload(path)
+	assert.Error(t, err)
+	_, err = LoadComparisonWorkload(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
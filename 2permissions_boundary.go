@@ -0,0 +1,162 @@
+package business
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	admission_v1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// BoundaryPolicy forbids risky actions in some namespaces to everybody but a few groups, whatever RBAC
+// grants, e.g. "only SREs can delete namespaces matching prod-*".
+type BoundaryPolicy struct {
+	Name string `yaml:"name"`
+	// Verbs, APIGroups and Resources select the forbidden requests, like a PolicyRule. "*" matches anything.
+	Verbs     []string `yaml:"verbs"`
+	APIGroups []string `yaml:"api_groups"`
+	Resources []string `yaml:"resources"`
+	// Namespaces are glob patterns, e.g. prod-*. For namespace objects the name of the namespace is matched.
+	// Empty means all the namespaces.
+	Namespaces []string `yaml:"namespaces"`
+	// ExemptGroups can still perform the requests.
+	ExemptGroups []string `yaml:"exempt_groups"`
+	// Message is returned to the denied users.
+	Message string `yaml:"message"`
+}
+
+// admissionOperations maps the verbs to the admission operations. Read-only verbs are not admitted.
+var admissionOperations = map[string]admission_v1beta1.OperationType{
+	"create":           admission_v1beta1.Create,
+	"update":           admission_v1beta1.Update,
+	"patch":            admission_v1beta1.Update,
+	"delete":           admission_v1beta1.Delete,
+	"deletecollection": admission_v1beta1.Delete,
+	"*":                admission_v1beta1.OperationAll,
+}
+
+// BuildValidatingAdmissionPolicies generates a ValidatingAdmissionPolicy and its binding per boundary
+// policy, so the boundaries are enforced natively by the apiserver (admissionregistration.k8s.io/v1beta1,
+// Kubernetes 1.28+), including for the requests not going through Kiali. Admission only sees the
+// mutating verbs: the read-only verbs of the policies (get, list, watch) are skipped, and a policy
+// without mutating verb is an error. Render the objects with RenderManifests.
+func BuildValidatingAdmissionPolicies(policies []BoundaryPolicy) ([]runtime.Object, error) {
+	objects := make([]runtime.Object, 0, 2*len(policies))
+	for _, policy := range policies {
+		operations := boundaryOperations(policy.Verbs)
+		if len(operations) == 0 {
+			return nil, fmt.Errorf("boundary policy %s has no verb enforceable by admission", policy.Name)
+		}
+		name := manifestName("kiali-boundary", policy.Name)
+		failurePolicy := admission_v1beta1.Fail
+		message := policy.Message
+		if message == "" {
+			message = fmt.Sprintf("forbidden by the %s boundary policy", policy.Name)
+		}
+
+		objects = append(objects,
+			&admission_v1beta1.ValidatingAdmissionPolicy{
+				TypeMeta:   meta_v1.TypeMeta{APIVersion: admission_v1beta1.SchemeGroupVersion.String(), Kind: "ValidatingAdmissionPolicy"},
+				ObjectMeta: meta_v1.ObjectMeta{Name: name},
+				Spec: admission_v1beta1.ValidatingAdmissionPolicySpec{
+					FailurePolicy: &failurePolicy,
+					MatchConstraints: &admission_v1beta1.MatchResources{
+						ResourceRules: []admission_v1beta1.NamedRuleWithOperations{{
+							RuleWithOperations: admission_v1beta1.RuleWithOperations{
+								Operations: operations,
+								Rule: admission_v1beta1.Rule{
+									APIGroups:   defaultWildcard(policy.APIGroups),
+									APIVersions: []string{"*"},
+									Resources:   defaultWildcard(policy.Resources),
+								},
+							},
+						}},
+					},
+					Validations: []admission_v1beta1.Validation{{
+						Expression: boundaryExpression(policy),
+						Message:    message,
+					}},
+				},
+			},
+			&admission_v1beta1.ValidatingAdmissionPolicyBinding{
+				TypeMeta:   meta_v1.TypeMeta{APIVersion: admission_v1beta1.SchemeGroupVersion.String(), Kind: "ValidatingAdmissionPolicyBinding"},
+				ObjectMeta: meta_v1.ObjectMeta{Name: name},
+				Spec: admission_v1beta1.ValidatingAdmissionPolicyBindingSpec{
+					PolicyName:        name,
+					ValidationActions: []admission_v1beta1.ValidationAction{admission_v1beta1.Deny},
+				},
+			},
+		)
+	}
+	return objects, nil
+}
+
+// boundaryOperations returns the admission operations of the verbs, sorted.
+func boundaryOperations(verbs []string) []admission_v1beta1.OperationType {
+	set := map[string]bool{}
+	for _, verb := range verbs {
+		if op, ok := admissionOperations[verb]; ok {
+			set[string(op)] = true
+		}
+	}
+	if set[string(admission_v1beta1.OperationAll)] {
+		return []admission_v1beta1.OperationType{admission_v1beta1.OperationAll}
+	}
+	operations := []admission_v1beta1.OperationType{}
+	for _, op := range sortedKeys(set) {
+		operations = append(operations, admission_v1beta1.OperationType(op))
+	}
+	return operations
+}
+
+// boundaryExpression returns the CEL expression admitting the requests outside of the namespaces of the
+// policy, or made by a member of an exempt group. The namespaces themselves are matched by name; the other
+// cluster-scoped resources are outside of every namespace.
+func boundaryExpression(policy BoundaryPolicy) string {
+	conditions := []string{}
+	if len(policy.Namespaces) > 0 {
+		patterns := make([]string, 0, len(policy.Namespaces))
+		for _, ns := range policy.Namespaces {
+			patterns = append(patterns, globToRegexp(ns))
+		}
+		pattern := celString(strings.Join(patterns, "|"))
+		conditions = append(conditions, fmt.Sprintf("!(request.namespace != '' ? request.namespace.matches(%s) : request.resource.group == '' && request.resource.resource == 'namespaces' && request.name.matches(%s))", pattern, pattern))
+	}
+	if len(policy.ExemptGroups) > 0 {
+		groups := append([]string{}, policy.ExemptGroups...)
+		sort.Strings(groups)
+		quoted := make([]string, 0, len(groups))
+		for _, group := range groups {
+			quoted = append(quoted, celString(group))
+		}
+		conditions = append(conditions, fmt.Sprintf("request.userInfo.groups.exists(g, g in [%s])", strings.Join(quoted, ", ")))
+	}
+	if len(conditions) == 0 {
+		return "false"
+	}
+	return strings.Join(conditions, " || ")
+}
+
+// globToRegexp converts a glob pattern, where * matches anything, to an anchored regular expression.
+func globToRegexp(glob string) string {
+	parts := strings.Split(glob, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return "^" + strings.Join(parts, ".*") + "$"
+}
+
+// celString quotes the string as a CEL string literal.
+func celString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func defaultWildcard(values []string) []string {
+	if len(values) == 0 {
+		return []string{"*"}
+	}
+	return values
+}
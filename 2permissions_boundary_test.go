@@ -0,0 +1,109 @@
+package business
+
+import (
+	"testing"
+
+	admission_v1beta1 "k8s.io/api/admissionregistration/v1beta1"
+
+	"github.com/google/cel-go/cel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testProdBoundary = BoundaryPolicy{
+	Name:         "prod-deletes",
+	Verbs:        []string{"get", "delete", "deletecollection"},
+	Resources:    []string{"namespaces", "deployments"},
+	APIGroups:    []string{"", "apps"},
+	Namespaces:   []string{"prod-*"},
+	ExemptGroups: []string{"sre", "admins"},
+}
+
+func TestBuildValidatingAdmissionPolicies(t *testing.T) {
+	objects, err := BuildValidatingAdmissionPolicies([]BoundaryPolicy{testProdBoundary})
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+
+	policy := objects[0].(*admission_v1beta1.ValidatingAdmissionPolicy)
+	assert.Equal(t, "kiali-boundary-prod-deletes", policy.Name)
+	assert.Equal(t, "ValidatingAdmissionPolicy", policy.Kind)
+	assert.Equal(t, admission_v1beta1.Fail, *policy.Spec.FailurePolicy)
+	rules := policy.Spec.MatchConstraints.ResourceRules
+	require.Len(t, rules, 1)
+	// get is not admitted, delete and deletecollection are the same operation
+	assert.Equal(t, []admission_v1beta1.OperationType{admission_v1beta1.Delete}, rules[0].Operations)
+	assert.Equal(t, []string{"", "apps"}, rules[0].APIGroups)
+	assert.Equal(t, []string{"*"}, rules[0].APIVersions)
+	assert.Equal(t, []string{"namespaces", "deployments"}, rules[0].Resources)
+	require.Len(t, policy.Spec.Validations, 1)
+	assert.Equal(t, `!(request.namespace != '' ? request.namespace.matches('^prod-.*$') : request.resource.group == '' && request.resource.resource == 'namespaces' && request.name.matches('^prod-.*$')) || request.userInfo.groups.exists(g, g in ['admins', 'sre'])`, policy.Spec.Validations[0].Expression)
+	assert.Equal(t, "forbidden by the prod-deletes boundary policy", policy.Spec.Validations[0].Message)
+
+	binding := objects[1].(*admission_v1beta1.ValidatingAdmissionPolicyBinding)
+	assert.Equal(t, "kiali-boundary-prod-deletes", binding.Name)
+	assert.Equal(t, policy.Name, binding.Spec.PolicyName)
+	assert.Equal(t, []admission_v1beta1.ValidationAction{admission_v1beta1.Deny}, binding.Spec.ValidationActions)
+}
+
+func TestBoundaryExpression(t *testing.T) {
+	env, err := cel.NewEnv(cel.Variable("request", cel.DynType))
+	require.NoError(t, err)
+	ast, issues := env.Compile(boundaryExpression(BoundaryPolicy{Namespaces: []string{"prod-*"}, ExemptGroups: []string{"sre"}}))
+	require.NoError(t, issues.Err())
+	program, err := env.Program(ast)
+	require.NoError(t, err)
+	admitted := func(group, resource, namespace, name string, groups ...string) bool {
+		out, _, err := program.Eval(map[string]interface{}{"request": map[string]interface{}{
+			"resource":  map[string]interface{}{"group": group, "resource": resource},
+			"namespace": namespace,
+			"name":      name,
+			"userInfo":  map[string]interface{}{"groups": groups},
+		}})
+		require.NoError(t, err)
+		return out.Value().(bool)
+	}
+
+	assert.False(t, admitted("apps", "deployments", "prod-eu", "web"))
+	assert.True(t, admitted("apps", "deployments", "dev", "prod-web"))
+	assert.True(t, admitted("apps", "deployments", "prod-eu", "web", "sre"))
+	assert.False(t, admitted("", "namespaces", "", "prod-eu"))
+	assert.True(t, admitted("", "namespaces", "", "dev"))
+	// The other cluster-scoped resources are not in the namespaces of their name
+	assert.True(t, admitted("rbac.authorization.k8s.io", "clusterroles", "", "prod-admin"))
+}
+
+func TestBuildValidatingAdmissionPoliciesDefaults(t *testing.T) {
+	objects, err := BuildValidatingAdmissionPolicies([]BoundaryPolicy{{Name: "freeze", Verbs: []string{"update", "*"}, Message: "frozen"}})
+	require.NoError(t, err)
+
+	policy := objects[0].(*admission_v1beta1.ValidatingAdmissionPolicy)
+	rule := policy.Spec.MatchConstraints.ResourceRules[0]
+	assert.Equal(t, []admission_v1beta1.OperationType{admission_v1beta1.OperationAll}, rule.Operations)
+	assert.Equal(t, []string{"*"}, rule.APIGroups)
+	assert.Equal(t, []string{"*"}, rule.Resources)
+	// Without namespaces nor exempt groups, nothing is admitted
+	assert.Equal(t, "false", policy.Spec.Validations[0].Expression)
+	assert.Equal(t, "frozen", policy.Spec.Validations[0].Message)
+}
+
+func TestBuildValidatingAdmissionPoliciesRejectsReadOnlyPolicies(t *testing.T) {
+	_, err := BuildValidatingAdmissionPolicies([]BoundaryPolicy{{Name: "no-reads", Verbs: []string{"get", "list"}}})
+	assert.ErrorContains(t, err, "boundary policy no-reads has no verb enforceable by admission")
+}
+
+func TestBoundaryOperations(t *testing.T) {
+	assert.Equal(t, []admission_v1beta1.OperationType{admission_v1beta1.Create, admission_v1beta1.Delete, admission_v1beta1.Update},
+		boundaryOperations([]string{"patch", "delete", "create", "update", "watch"}))
+	assert.Empty(t, boundaryOperations([]string{"get"}))
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	assert.Equal(t, `^prod-.*$`, globToRegexp("prod-*"))
+	assert.Equal(t, `^.*\.example\.com$`, globToRegexp("*.example.com"))
+	assert.Equal(t, `^ns1$`, globToRegexp("ns1"))
+}
+
+func TestCELString(t *testing.T) {
+	assert.Equal(t, `'sre'`, celString("sre"))
+	assert.Equal(t, `'o\'brien\\team'`, celString(`o'brien\team`))
+}
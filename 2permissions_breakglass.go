@@ -0,0 +1,447 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube "k8s.io/client-go/kubernetes"
+
+	"github.com/kiali/kiali/log"
+)
+
+// Labels and annotations of the bindings created by the break-glass flow. The label value is the name of
+// the BreakGlassGrant.
+const (
+	BreakGlassLabel              = "permissions.kiali.io/break-glass"
+	BreakGlassUserAnnotation     = "permissions.kiali.io/break-glass-user"
+	BreakGlassReasonAnnotation   = "permissions.kiali.io/break-glass-reason"
+	BreakGlassExpiresAnnotation  = "permissions.kiali.io/break-glass-expires-at"
+	DefaultBreakGlassSweepPeriod = time.Minute
+)
+
+// DecisionSourceBreakGlass is the source of the audit records of the break-glass events.
+const DecisionSourceBreakGlass = "break-glass"
+
+// Break-glass event types
+const (
+	BreakGlassEventGranted = "granted"
+	BreakGlassEventRevoked = "revoked"
+)
+
+var (
+	// ErrBreakGlassNotEligible is returned when the user may not activate the break-glass grant.
+	ErrBreakGlassNotEligible = errors.New("not eligible for this break-glass grant")
+	// ErrInvalidBreakGlassRequest is returned for unknown grants, missing reasons and invalid durations.
+	ErrInvalidBreakGlassRequest = errors.New("invalid break-glass request")
+)
+
+// BreakGlassGrant is a pre-approved elevated access, activated on demand for a limited time.
+type BreakGlassGrant struct {
+	Name string `yaml:"name"`
+	// ClusterRole is the role granted, cluster-wide or in Namespace if set.
+	ClusterRole string `yaml:"cluster_role"`
+	Namespace   string `yaml:"namespace"`
+	// Users and Groups can activate the grant.
+	Users  []string `yaml:"users"`
+	Groups []string `yaml:"groups"`
+	// MaxDuration bounds the duration of an activation.
+	MaxDuration time.Duration `yaml:"max_duration"`
+}
+
+func (in BreakGlassGrant) eligible(user UserInfo) bool {
+	return UserCacheTTL{Users: in.Users, Groups: in.Groups}.matches(user)
+}
+
+// BreakGlassEvent records an activation or a revocation of a break-glass grant.
+type BreakGlassEvent struct {
+	Type      string        `json:"type"`
+	Grant     string        `json:"grant"`
+	User      string        `json:"user"`
+	Reason    string        `json:"reason"`
+	Binding   RBACObjectRef `json:"binding"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+	Timestamp time.Time     `json:"timestamp"`
+	RequestID string        `json:"requestId,omitempty"`
+}
+
+// BreakGlassNotifier is told about every break-glass event, e.g. to page the security team. The events
+// are also always written to the Kiali log and to the audit sink of the checker, as the audit trail.
+type BreakGlassNotifier interface {
+	Notify(ctx context.Context, event BreakGlassEvent)
+}
+
+// BreakGlassManager activates the break-glass grants, as bindings created for the requested duration,
+// and guarantees their revocation: each binding is deleted when it expires, and Run deletes the expired
+// bindings left behind, e.g. by a restart. The decisions cached for the user are purged on activation
+// and revocation, in the cache of the checker, and in the caches of the other replicas when an
+// InvalidationBus is set, see SetInvalidationBus. It is safe for concurrent use.
+type BreakGlassManager struct {
+	k8s     kube.Interface
+	checker *PermissionChecker
+	grants  map[string]BreakGlassGrant
+
+	mu       sync.Mutex
+	notifier BreakGlassNotifier
+	bus      InvalidationBus
+	timers   map[RBACObjectRef]*time.Timer
+}
+
+// NewBreakGlassManager creates a manager of the grants. The k8s client must be allowed to manage the
+// bindings, and to bind the ClusterRoles of the grants.
+func NewBreakGlassManager(k8s kube.Interface, checker *PermissionChecker, grants []BreakGlassGrant) *BreakGlassManager {
+	manager := &BreakGlassManager{
+		k8s:     k8s,
+		checker: checker,
+		grants:  make(map[string]BreakGlassGrant, len(grants)),
+		timers:  map[RBACObjectRef]*time.Timer{},
+	}
+	for _, grant := range grants {
+		manager.grants[grant.Name] = grant
+	}
+	return manager
+}
+
+// SetNotifier sets the notifier of the break-glass events. A nil notifier disables the notifications.
+func (in *BreakGlassManager) SetNotifier(notifier BreakGlassNotifier) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.notifier = notifier
+}
+
+// SetInvalidationBus sets the bus broadcasting the purges of the cached decisions of the users to the
+// other replicas. Without it, only the cache of the checker of the manager is purged, which is enough
+// for a single replica or the Redis cache shared by the replicas. Replicas with their own memory caches
+// also purge them on the invalidations of their RBAC watch, see RunHAMaintenance, but only once the
+// binding is seen by the watch. A nil bus disables the broadcasts.
+func (in *BreakGlassManager) SetInvalidationBus(bus InvalidationBus) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.bus = bus
+}
+
+// Activate grants the break-glass access to the user for the duration, which must not exceed the
+// MaxDuration of the grant. A reason is mandatory.
+func (in *BreakGlassManager) Activate(ctx context.Context, user UserInfo, grantName, reason string, duration time.Duration) (*BreakGlassEvent, error) {
+	grant, ok := in.grants[grantName]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown grant %q", ErrInvalidBreakGlassRequest, grantName)
+	}
+	if !grant.eligible(user) {
+		return nil, fmt.Errorf("user %s: %w", user.Name, ErrBreakGlassNotEligible)
+	}
+	if reason == "" {
+		return nil, fmt.Errorf("%w: a reason is required to activate grant %s", ErrInvalidBreakGlassRequest, grantName)
+	}
+	if duration <= 0 || duration > grant.MaxDuration {
+		return nil, fmt.Errorf("%w: invalid duration %s for grant %s, the maximum is %s", ErrInvalidBreakGlassRequest, duration, grantName, grant.MaxDuration)
+	}
+
+	expiresAt := time.Now().Add(duration)
+	meta := meta_v1.ObjectMeta{
+		GenerateName: manifestName("break-glass", grantName) + "-",
+		Namespace:    grant.Namespace,
+		Labels:       map[string]string{BreakGlassLabel: grantName},
+		Annotations: map[string]string{
+			BreakGlassUserAnnotation:    user.Name,
+			BreakGlassReasonAnnotation:  reason,
+			BreakGlassExpiresAnnotation: expiresAt.UTC().Format(time.RFC3339),
+		},
+	}
+	roleRef := rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: grant.ClusterRole}
+	subjects := []rbac_v1.Subject{subjectForUser(user.Name)}
+
+	ref := RBACObjectRef{Namespace: grant.Namespace}
+	if grant.Namespace == "" {
+		crb, err := in.k8s.RbacV1().ClusterRoleBindings().Create(ctx, &rbac_v1.ClusterRoleBinding{ObjectMeta: meta, RoleRef: roleRef, Subjects: subjects}, meta_v1.CreateOptions{})
+		if err != nil {
+			return nil, withRequestID(ctx, fmt.Errorf("error activating break-glass grant %s: %w", grantName, err))
+		}
+		ref.Kind, ref.Name = "ClusterRoleBinding", crb.Name
+	} else {
+		rb, err := in.k8s.RbacV1().RoleBindings(grant.Namespace).Create(ctx, &rbac_v1.RoleBinding{ObjectMeta: meta, RoleRef: roleRef, Subjects: subjects}, meta_v1.CreateOptions{})
+		if err != nil {
+			return nil, withRequestID(ctx, fmt.Errorf("error activating break-glass grant %s: %w", grantName, err))
+		}
+		ref.Kind, ref.Name = "RoleBinding", rb.Name
+	}
+
+	in.purgeUser(ctx, user.Name, ref)
+	in.mu.Lock()
+	in.timers[ref] = time.AfterFunc(duration, func() {
+		if err := in.Revoke(context.Background(), ref); err != nil {
+			log.Errorf("Error revoking break-glass binding %s %s, it is retried by the sweep: %v", ref.Kind, ref.Name, err)
+		}
+	})
+	in.mu.Unlock()
+
+	event := BreakGlassEvent{
+		Type:      BreakGlassEventGranted,
+		Grant:     grantName,
+		User:      user.Name,
+		Reason:    reason,
+		Binding:   ref,
+		ExpiresAt: expiresAt,
+		Timestamp: time.Now(),
+		RequestID: RequestIDFromContext(ctx),
+	}
+	in.emit(ctx, event)
+	return &event, nil
+}
+
+// Revoke deletes a break-glass binding before its expiry, and purges the decisions cached for its user.
+// Revoking a binding already deleted is not an error. The other bindings are never deleted: the binding
+// must have the BreakGlassLabel, and is deleted only if it was not replaced since.
+func (in *BreakGlassManager) Revoke(ctx context.Context, ref RBACObjectRef) error {
+	var meta meta_v1.ObjectMeta
+	var err error
+	switch ref.Kind {
+	case "ClusterRoleBinding":
+		var crb *rbac_v1.ClusterRoleBinding
+		if crb, err = in.k8s.RbacV1().ClusterRoleBindings().Get(ctx, ref.Name, meta_v1.GetOptions{}); err == nil {
+			meta = crb.ObjectMeta
+			if err = breakGlassBinding(ref, meta); err == nil {
+				err = in.k8s.RbacV1().ClusterRoleBindings().Delete(ctx, ref.Name, meta_v1.DeleteOptions{Preconditions: &meta_v1.Preconditions{UID: &meta.UID}})
+			}
+		}
+	case "RoleBinding":
+		var rb *rbac_v1.RoleBinding
+		if rb, err = in.k8s.RbacV1().RoleBindings(ref.Namespace).Get(ctx, ref.Name, meta_v1.GetOptions{}); err == nil {
+			meta = rb.ObjectMeta
+			if err = breakGlassBinding(ref, meta); err == nil {
+				err = in.k8s.RbacV1().RoleBindings(ref.Namespace).Delete(ctx, ref.Name, meta_v1.DeleteOptions{Preconditions: &meta_v1.Preconditions{UID: &meta.UID}})
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported break-glass binding kind %q", ref.Kind)
+	}
+	if errors.Is(err, ErrInvalidBreakGlassRequest) {
+		return err
+	}
+
+	in.mu.Lock()
+	if timer, ok := in.timers[ref]; ok && (err == nil || k8s_errors.IsNotFound(err)) {
+		timer.Stop()
+		delete(in.timers, ref)
+	}
+	in.mu.Unlock()
+
+	if k8s_errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return withRequestID(ctx, fmt.Errorf("error revoking break-glass binding %s %s: %w", ref.Kind, ref.Name, err))
+	}
+
+	user := meta.Annotations[BreakGlassUserAnnotation]
+	in.purgeUser(ctx, user, ref)
+	expiresAt, _ := time.Parse(time.RFC3339, meta.Annotations[BreakGlassExpiresAnnotation])
+	in.emit(ctx, BreakGlassEvent{
+		Type:      BreakGlassEventRevoked,
+		Grant:     meta.Labels[BreakGlassLabel],
+		User:      user,
+		Reason:    meta.Annotations[BreakGlassReasonAnnotation],
+		Binding:   ref,
+		ExpiresAt: expiresAt,
+		Timestamp: time.Now(),
+		RequestID: RequestIDFromContext(ctx),
+	})
+	return nil
+}
+
+// breakGlassBinding returns an error if the binding was not created by the break-glass flow.
+func breakGlassBinding(ref RBACObjectRef, meta meta_v1.ObjectMeta) error {
+	if meta.Labels[BreakGlassLabel] == "" {
+		return fmt.Errorf("%w: %s %s is not a break-glass binding", ErrInvalidBreakGlassRequest, ref.Kind, ref.Name)
+	}
+	return nil
+}
+
+// RevokeExpired deletes the expired break-glass bindings of the cluster, including the ones created by
+// other replicas or before a restart.
+func (in *BreakGlassManager) RevokeExpired(ctx context.Context) error {
+	selector := meta_v1.ListOptions{LabelSelector: BreakGlassLabel}
+	crbs, err := in.k8s.RbacV1().ClusterRoleBindings().List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("error listing break-glass ClusterRoleBindings: %w", err)
+	}
+	rbs, err := in.k8s.RbacV1().RoleBindings("").List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("error listing break-glass RoleBindings: %w", err)
+	}
+
+	expired := []RBACObjectRef{}
+	for _, crb := range crbs.Items {
+		if breakGlassExpired(crb.ObjectMeta) {
+			expired = append(expired, RBACObjectRef{Kind: "ClusterRoleBinding", Name: crb.Name})
+		}
+	}
+	for _, rb := range rbs.Items {
+		if breakGlassExpired(rb.ObjectMeta) {
+			expired = append(expired, RBACObjectRef{Kind: "RoleBinding", Namespace: rb.Namespace, Name: rb.Name})
+		}
+	}
+
+	var errs []error
+	for _, ref := range expired {
+		if err := in.Revoke(ctx, ref); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run revokes the expired break-glass bindings every period until the context is cancelled. Zero
+// means DefaultBreakGlassSweepPeriod.
+func (in *BreakGlassManager) Run(ctx context.Context, period time.Duration) {
+	if period <= 0 {
+		period = DefaultBreakGlassSweepPeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		if err := in.RevokeExpired(ctx); err != nil {
+			log.Errorf("Error revoking expired break-glass bindings: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// breakGlassExpired returns true if the binding expired. Bindings with an invalid expiry are expired.
+func breakGlassExpired(meta meta_v1.ObjectMeta) bool {
+	expiresAt, err := time.Parse(time.RFC3339, meta.Annotations[BreakGlassExpiresAnnotation])
+	return err != nil || !time.Now().Before(expiresAt)
+}
+
+// purgeUser purges the decisions cached for the user, whose binding changed, and broadcasts the purge to
+// the other replicas.
+func (in *BreakGlassManager) purgeUser(ctx context.Context, username string, binding RBACObjectRef) {
+	if username == "" {
+		return
+	}
+	slices := UserCacheSlices(username)
+	if in.checker != nil {
+		if err := in.checker.InvalidateCache(ctx, slices); err != nil {
+			log.Errorf("%sError purging the cached decisions of user %s: %v", logPrefix(ctx), redactedUser(username), err)
+		}
+	}
+
+	in.mu.Lock()
+	bus := in.bus
+	in.mu.Unlock()
+	if bus != nil {
+		invalidation := PermissionInvalidation{Causes: []RBACObjectRef{binding}, Slices: slices, Timestamp: time.Now()}
+		if err := bus.Publish(ctx, invalidation); err != nil {
+			log.Errorf("%sError broadcasting the purge of the cached decisions of user %s: %v", logPrefix(ctx), redactedUser(username), err)
+		}
+	}
+}
+
+func (in *BreakGlassManager) emit(ctx context.Context, event BreakGlassEvent) {
+	log.Infof("%sBreak-glass grant %s %s for user [%s] until %s, binding %s %s/%s: %s",
+		logPrefix(ctx), event.Grant, event.Type, redactedUser(event.User), event.ExpiresAt.UTC().Format(time.RFC3339), event.Binding.Kind, event.Binding.Namespace, event.Binding.Name, event.Reason)
+	if in.checker != nil {
+		in.checker.recordAudit(ctx, event.auditRecord())
+	}
+
+	in.mu.Lock()
+	notifier := in.notifier
+	in.mu.Unlock()
+	if notifier != nil {
+		notifier.Notify(ctx, event)
+	}
+}
+
+// auditRecord returns the audit record of the event: the creation or the deletion of the binding for the
+// user, allowed by the grant.
+func (in BreakGlassEvent) auditRecord() AuditRecord {
+	verb := "create"
+	if in.Type == BreakGlassEventRevoked {
+		verb = "delete"
+	}
+	resource := "rolebindings"
+	if in.Binding.Kind == "ClusterRoleBinding" {
+		resource = "clusterrolebindings"
+	}
+	return AuditRecord{
+		Timestamp: in.Timestamp,
+		RequestID: in.RequestID,
+		User:      UserInfo{Name: in.User},
+		Request:   AccessRequest{Namespace: in.Binding.Namespace, APIGroup: rbac_v1.GroupName, Resource: resource, Name: in.Binding.Name, Verb: verb},
+		Decision: Decision{
+			Allowed:   true,
+			Reason:    fmt.Sprintf("break-glass grant %s %s until %s: %s", in.Grant, in.Type, in.ExpiresAt.UTC().Format(time.RFC3339), in.Reason),
+			Source:    DecisionSourceBreakGlass,
+			Timestamp: in.Timestamp,
+		},
+	}
+}
+
+// breakGlassRequest is the body of the break-glass activation requests.
+type breakGlassRequest struct {
+	Grant  string `json:"grant"`
+	Reason string `json:"reason"`
+	// Duration is a Go duration, e.g. 30m.
+	Duration string `json:"duration"`
+}
+
+// maxBreakGlassRequestBytes bounds the bodies of the break-glass requests.
+const maxBreakGlassRequestBytes = 16 << 10
+
+// EnableBreakGlass registers the optional break-glass endpoint at /api/break-glass. A POST activates a
+// grant for the caller and returns the BreakGlassEvent, see WriteOutput.
+func (in *PermissionsServer) EnableBreakGlass(manager *BreakGlassManager) {
+	in.router.Methods("POST").Path("/api/break-glass").Name("BreakGlass").HandlerFunc(in.guard(func(w http.ResponseWriter, r *http.Request) {
+		in.breakGlass(w, r, manager)
+	}, nil))
+}
+
+// breakGlass activates the grant of the breakGlassRequest of the body for the caller.
+func (in *PermissionsServer) breakGlass(w http.ResponseWriter, r *http.Request, manager *BreakGlassManager) {
+	user, ok := in.callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var body breakGlassRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBreakGlassRequestBytes)).Decode(&body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "break-glass request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "invalid break-glass request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	duration, err := time.ParseDuration(body.Duration)
+	if err != nil {
+		http.Error(w, "invalid break-glass duration: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	event, err := manager.Activate(requestContext(r), user, body.Grant, body.Reason, duration)
+	switch {
+	case errors.Is(err, ErrBreakGlassNotEligible):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, ErrInvalidBreakGlassRequest):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Errorf("Error activating break-glass grant: %v", err)
+		http.Error(w, "error activating break-glass grant", http.StatusInternalServerError)
+		return
+	}
+
+	writeOutput(w, r, http.StatusCreated, event, nil)
+}
@@ -0,0 +1,221 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kube_fake "k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testInvalidationBus struct {
+	mu            sync.Mutex
+	invalidations []PermissionInvalidation
+}
+
+func (in *testInvalidationBus) Publish(ctx context.Context, invalidation PermissionInvalidation) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.invalidations = append(in.invalidations, invalidation)
+	return nil
+}
+
+func (in *testInvalidationBus) Subscribe(ctx context.Context, handler func(PermissionInvalidation)) error {
+	return nil
+}
+
+var testBreakGlassGrant = BreakGlassGrant{Name: "oncall", ClusterRole: "admin", Namespace: "ns1", Users: []string{"alice"}, MaxDuration: time.Hour}
+
+// newTestBreakGlassManager returns a manager of testBreakGlassGrant over a fake clientset naming the
+// generated bindings.
+func newTestBreakGlassManager(checker *PermissionChecker) *BreakGlassManager {
+	k8s := kube_fake.NewSimpleClientset()
+	k8s.PrependReactor("create", "*", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		if object, ok := action.(k8s_testing.CreateAction).GetObject().(meta_v1.Object); ok && object.GetName() == "" {
+			object.SetName(object.GetGenerateName() + "x1")
+		}
+		return false, nil, nil
+	})
+	return NewBreakGlassManager(k8s, checker, []BreakGlassGrant{testBreakGlassGrant})
+}
+
+func TestBreakGlassEventsAreAudited(t *testing.T) {
+	checker := newTestChecker(&testReviews{}, NewPermissionsConfig())
+	sink := &testAuditSink{}
+	checker.SetAuditSink(sink)
+	manager := newTestBreakGlassManager(checker)
+
+	event, err := manager.Activate(WithRequestID(testCtx, "r1"), UserInfo{Name: "alice"}, "oncall", "incident 42", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, manager.Revoke(testCtx, event.Binding))
+
+	require.Len(t, sink.records, 2)
+	granted, revoked := sink.records[0], sink.records[1]
+	assert.Equal(t, "alice", granted.User.Name)
+	assert.Equal(t, "r1", granted.RequestID)
+	assert.Equal(t, AccessRequest{Namespace: "ns1", APIGroup: rbac_v1.GroupName, Resource: "rolebindings", Name: event.Binding.Name, Verb: "create"}, granted.Request)
+	assert.True(t, granted.Decision.Allowed)
+	assert.Equal(t, DecisionSourceBreakGlass, granted.Decision.Source)
+	assert.Contains(t, granted.Decision.Reason, "incident 42")
+	assert.Equal(t, EnforcementModeEnforce, granted.Mode)
+	assert.Equal(t, "delete", revoked.Request.Verb)
+}
+
+func TestBreakGlassAuditsAreRedacted(t *testing.T) {
+	checker := newTestChecker(&testReviews{}, NewPermissionsConfig())
+	// After the checker, which applies the redaction policy of its config
+	withTestRedaction(t, testRedactionPolicy)
+	sink := &testAuditSink{}
+	checker.SetAuditSink(sink)
+
+	_, err := newTestBreakGlassManager(checker).Activate(testCtx, UserInfo{Name: "alice"}, "oncall", "incident 42", time.Hour)
+	require.NoError(t, err)
+
+	require.Len(t, sink.records, 1)
+	assert.Equal(t, testRedactionPolicy.Username("alice"), sink.records[0].User.Name)
+}
+
+func TestBreakGlassPurgesTheCachedDecisionsOfTheReplicas(t *testing.T) {
+	reviews := &testReviews{}
+	checker := newTestChecker(reviews, NewPermissionsConfig())
+	manager := newTestBreakGlassManager(checker)
+	bus := &testInvalidationBus{}
+	manager.SetInvalidationBus(bus)
+	alice := UserInfo{Name: "alice"}
+	pods := AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "delete"}
+
+	_, err := checker.Check(testCtx, alice, pods)
+	require.NoError(t, err)
+	event, err := manager.Activate(testCtx, alice, "oncall", "incident 42", time.Hour)
+	require.NoError(t, err)
+	_, err = checker.Check(testCtx, alice, pods)
+	require.NoError(t, err)
+
+	// The decision cached before the activation was purged
+	assert.Equal(t, int64(2), reviews.calls.Load())
+	require.Len(t, bus.invalidations, 1)
+	assert.Equal(t, []RBACObjectRef{event.Binding}, bus.invalidations[0].Causes)
+	assert.Equal(t, UserCacheSlices("alice"), bus.invalidations[0].Slices)
+}
+
+func TestBreakGlassRevokeIsNotUndoneByTheChecksInFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var first sync.Once
+	reviews := &testReviews{allow: func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+		first.Do(func() {
+			close(started)
+			<-release
+		})
+		return true
+	}}
+	checker := newTestChecker(reviews, NewPermissionsConfig())
+	manager := newTestBreakGlassManager(checker)
+	alice := UserInfo{Name: "alice"}
+	pods := AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "delete"}
+
+	event, err := manager.Activate(testCtx, alice, "oncall", "incident 42", time.Hour)
+	require.NoError(t, err)
+	checked := make(chan error)
+	go func() {
+		_, err := checker.Check(testCtx, alice, pods)
+		checked <- err
+	}()
+	<-started
+	// The check in flight was allowed by the binding, revoked before it returns
+	require.NoError(t, manager.Revoke(testCtx, event.Binding))
+	close(release)
+	require.NoError(t, <-checked)
+
+	decision, err := checker.Check(testCtx, alice, pods)
+	require.NoError(t, err)
+	assert.Equal(t, DecisionSourceAPIServer, decision.Source)
+	assert.Equal(t, int64(2), reviews.calls.Load())
+}
+
+func TestBreakGlassRevokeOnlyDeletesBreakGlassBindings(t *testing.T) {
+	manager := newTestBreakGlassManager(nil)
+	admin := &rbac_v1.ClusterRoleBinding{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "cluster-admin"},
+		RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: "cluster-admin"},
+		Subjects:   []rbac_v1.Subject{{Kind: rbac_v1.GroupKind, APIGroup: rbac_v1.GroupName, Name: "system:masters"}},
+	}
+	_, err := manager.k8s.RbacV1().ClusterRoleBindings().Create(testCtx, admin, meta_v1.CreateOptions{})
+	require.NoError(t, err)
+
+	err = manager.Revoke(testCtx, RBACObjectRef{Kind: "ClusterRoleBinding", Name: "cluster-admin"})
+	assert.ErrorIs(t, err, ErrInvalidBreakGlassRequest)
+	_, err = manager.k8s.RbacV1().ClusterRoleBindings().Get(testCtx, "cluster-admin", meta_v1.GetOptions{})
+	assert.NoError(t, err)
+
+	// Revoking a binding already deleted is not an error
+	assert.NoError(t, manager.Revoke(testCtx, RBACObjectRef{Kind: "RoleBinding", Namespace: "ns1", Name: "missing"}))
+}
+
+func TestBreakGlassActivationChecks(t *testing.T) {
+	manager := newTestBreakGlassManager(nil)
+
+	_, err := manager.Activate(testCtx, UserInfo{Name: "bob"}, "oncall", "incident 42", time.Hour)
+	assert.ErrorIs(t, err, ErrBreakGlassNotEligible)
+	_, err = manager.Activate(testCtx, UserInfo{Name: "alice"}, "unknown", "incident 42", time.Hour)
+	assert.ErrorIs(t, err, ErrInvalidBreakGlassRequest)
+	_, err = manager.Activate(testCtx, UserInfo{Name: "alice"}, "oncall", "", time.Hour)
+	assert.ErrorIs(t, err, ErrInvalidBreakGlassRequest)
+	_, err = manager.Activate(testCtx, UserInfo{Name: "alice"}, "oncall", "incident 42", 2*time.Hour)
+	assert.ErrorIs(t, err, ErrInvalidBreakGlassRequest)
+}
+
+func TestBreakGlassEndpoint(t *testing.T) {
+	server := newTestServer(&testReviews{}, nil)
+	server.EnableBreakGlass(newTestBreakGlassManager(server.checker))
+	post := func(user, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/break-glass", strings.NewReader(body))
+		if user != "" {
+			r.Header.Set("X-Test-User", user)
+		}
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	w := post("alice", `{"grant": "oncall", "reason": "incident", "duration": "30m"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var event BreakGlassEvent
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &event))
+	assert.Equal(t, BreakGlassEvent{Type: BreakGlassEventGranted, Grant: "oncall", User: "alice", Reason: "incident"}, BreakGlassEvent{Type: event.Type, Grant: event.Grant, User: event.User, Reason: event.Reason})
+
+	assert.Equal(t, http.StatusUnauthorized, post("", `{"grant": "oncall", "reason": "incident", "duration": "30m"}`).Code)
+	assert.Equal(t, http.StatusForbidden, post("bob", `{"grant": "oncall", "reason": "incident", "duration": "30m"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post("alice", `{"grant": "oncall", "duration": "30m"}`).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("alice", `{"reason": "`+strings.Repeat("x", maxBreakGlassRequestBytes)+`"}`).Code)
+
+	// The activations are rate limited like the other endpoints
+	server.SetRateLimit(0.001, 1)
+	assert.Equal(t, http.StatusForbidden, post("bob", `{"grant": "oncall", "reason": "incident", "duration": "30m"}`).Code)
+	assert.Equal(t, http.StatusTooManyRequests, post("bob", `{"grant": "oncall", "reason": "incident", "duration": "30m"}`).Code)
+}
+
+func TestBreakGlassEndpointOutputFormats(t *testing.T) {
+	server := newTestServer(&testReviews{}, nil)
+	server.EnableBreakGlass(newTestBreakGlassManager(server.checker))
+	r := httptest.NewRequest("POST", "/api/break-glass?output=yaml", strings.NewReader(`{"grant": "oncall", "reason": "incident", "duration": "30m"}`))
+	r.Header.Set("X-Test-User", "alice")
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "application/yaml", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "grant: oncall\n")
+}
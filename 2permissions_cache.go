@@ -0,0 +1,377 @@
+package business
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix namespaces the keys written by the Redis decision cache.
+const redisKeyPrefix = "kiali:permissions:"
+
+// DefaultCacheMaxEntries is the bound of the memory decision cache when the config does not set it.
+const DefaultCacheMaxEntries = 100000
+
+// DecisionCache stores permission decisions for a limited time.
+type DecisionCache interface {
+	// Get returns the cached decision of the key. The second value is false on a cache miss.
+	Get(ctx context.Context, key string) (Decision, bool, error)
+	Set(ctx context.Context, key string, decision Decision, ttl time.Duration) error
+	// Ping checks the connectivity with the backend.
+	Ping(ctx context.Context) error
+	// Purge removes all the cached decisions.
+	Purge(ctx context.Context) error
+	// Invalidate removes the cached decisions of a slice.
+	Invalidate(ctx context.Context, slice CacheSlice) error
+}
+
+// NewDecisionCache creates the cache backend selected by the config.
+func NewDecisionCache(conf *PermissionsConfig) (DecisionCache, error) {
+	switch conf.CacheBackend {
+	case "", CacheBackendMemory:
+		return newMemoryDecisionCache(conf.cacheMaxEntries()), nil
+	case CacheBackendRedis:
+		return &redisDecisionCache{client: redis.NewClient(&redis.Options{Addr: conf.RedisAddress}), prefix: redisKeyPrefix + conf.CacheKeyPrefix}, nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", conf.CacheBackend)
+	}
+}
+
+// decisionCacheKey identifies the decision of a user for a request. The extra attributes are part of
+// the key, since e.g. the scopes of a token change the decisions. The fields are separated by "|" and
+// escaped with escapeKeyField, so no two users or requests share a key.
+func decisionCacheKey(user UserInfo, req AccessRequest) string {
+	groups := make([]string, len(user.Groups))
+	for i, group := range user.Groups {
+		groups[i] = escapeKeyField(group)
+	}
+	fields := []string{escapeKeyField(user.Name), strings.Join(groups, ","), extraKey(user.Extra)}
+	for _, field := range []string{req.Namespace, req.APIGroup, req.Resource, req.Subresource, req.Name, req.Verb, req.FieldSelector, req.LabelSelector} {
+		fields = append(fields, escapeKeyField(field))
+	}
+	return strings.Join(fields, "|")
+}
+
+// keyFieldEscaper percent-encodes the separators of the decision cache keys, and "%" itself.
+var keyFieldEscaper = strings.NewReplacer("%", "%25", "|", "%7C", ",", "%2C", ";", "%3B", "=", "%3D")
+
+// escapeKeyField escapes a field of a decision cache key, see decisionCacheKey.
+func escapeKeyField(s string) string {
+	return keyFieldEscaper.Replace(s)
+}
+
+// escapeGlob escapes the special characters of a Redis glob pattern.
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
+
+// extraKey serializes the extra attributes of a user in a stable order.
+func extraKey(extra map[string][]string) string {
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := make([]string, len(extra[k]))
+		for i, value := range extra[k] {
+			values[i] = escapeKeyField(value)
+		}
+		parts = append(parts, escapeKeyField(k)+"="+strings.Join(values, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+// splitDecisionCacheKey splits a decision cache key into its principal, the user with its groups and extra
+// attributes, and its request.
+func splitDecisionCacheKey(key string) (string, string) {
+	parts := strings.SplitN(key, "|", 4)
+	if len(parts) < 4 {
+		return key, ""
+	}
+	return strings.Join(parts[:3], "|"), parts[3]
+}
+
+// memoryCacheKey is a decision cache key made of the symbols of its principal and its request, see
+// splitDecisionCacheKey. Large clusters have many users asking the same few requests, so the entries
+// share these strings instead of holding their own keys.
+type memoryCacheKey struct {
+	principal uint32
+	request   uint32
+}
+
+type cachedDecision struct {
+	key      memoryCacheKey
+	decision Decision
+	// reason is the symbol of decision.Reason, which repeats across the users bound the same way.
+	reason  uint32
+	expires time.Time
+	// user and namespace are slices of the interned principal and request, escaped with escapeKeyField.
+	user      string
+	namespace string
+}
+
+// memoryDecisionCache is a DecisionCache local to the process, evicting the least recently used
+// decisions beyond its maximum number of entries. The principals, requests and reasons of the entries
+// are interned in a symbol table.
+type memoryDecisionCache struct {
+	mu sync.Mutex
+	// maxEntries bounds the entries. Zero means unbounded.
+	maxEntries int
+	entries    map[memoryCacheKey]*list.Element
+	// lru holds the *cachedDecision of the entries, the most recently used first
+	lru     *list.List
+	symbols *symbolTable
+}
+
+func newMemoryDecisionCache(maxEntries int) *memoryDecisionCache {
+	return &memoryDecisionCache{maxEntries: maxEntries, entries: map[memoryCacheKey]*list.Element{}, lru: list.New(), symbols: newSymbolTable()}
+}
+
+// lookup returns the element of the entry of the principal and request, if any. The caller holds the lock.
+func (in *memoryDecisionCache) lookup(principal, request string) (*list.Element, bool) {
+	principalID, ok := in.symbols.lookup(principal)
+	if !ok {
+		return nil, false
+	}
+	requestID, ok := in.symbols.lookup(request)
+	if !ok {
+		return nil, false
+	}
+	element, ok := in.entries[memoryCacheKey{principal: principalID, request: requestID}]
+	return element, ok
+}
+
+func (in *memoryDecisionCache) Get(ctx context.Context, key string) (Decision, bool, error) {
+	principal, request := splitDecisionCacheKey(key)
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	element, ok := in.lookup(principal, request)
+	if !ok {
+		return Decision{}, false, nil
+	}
+	entry := element.Value.(*cachedDecision)
+	if time.Now().After(entry.expires) {
+		in.remove(element)
+		return Decision{}, false, nil
+	}
+	in.lru.MoveToFront(element)
+	return entry.decision, true, nil
+}
+
+func (in *memoryDecisionCache) Set(ctx context.Context, key string, decision Decision, ttl time.Duration) error {
+	principal, request := splitDecisionCacheKey(key)
+	expires := time.Now().Add(ttl)
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if element, ok := in.lookup(principal, request); ok {
+		entry := element.Value.(*cachedDecision)
+		reason := in.symbols.acquire(decision.Reason)
+		in.symbols.release(entry.reason)
+		entry.reason = reason
+		decision.Reason = in.symbols.name(entry.reason)
+		entry.decision, entry.expires = decision, expires
+		in.lru.MoveToFront(element)
+		return nil
+	}
+
+	entry := &cachedDecision{key: memoryCacheKey{principal: in.symbols.acquire(principal), request: in.symbols.acquire(request)}, expires: expires}
+	entry.reason = in.symbols.acquire(decision.Reason)
+	decision.Reason = in.symbols.name(entry.reason)
+	entry.decision = decision
+	entry.user, _, _ = strings.Cut(in.symbols.name(entry.key.principal), "|")
+	entry.namespace, _, _ = strings.Cut(in.symbols.name(entry.key.request), "|")
+	in.entries[entry.key] = in.lru.PushFront(entry)
+	in.evict()
+	return nil
+}
+
+// setMaxEntries changes the bound of the entries, evicting the least recently used ones beyond it.
+func (in *memoryDecisionCache) setMaxEntries(maxEntries int) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.maxEntries = maxEntries
+	in.evict()
+}
+
+// len returns the number of entries, including the expired ones not evicted yet.
+func (in *memoryDecisionCache) len() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return len(in.entries)
+}
+
+// symbolCount returns the number of interned strings.
+func (in *memoryDecisionCache) symbolCount() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.symbols.len()
+}
+
+// evict removes the least recently used entries beyond the bound. The caller holds the lock.
+func (in *memoryDecisionCache) evict() {
+	for in.maxEntries > 0 && in.lru.Len() > in.maxEntries {
+		in.remove(in.lru.Back())
+	}
+}
+
+// remove removes the entry of the element and releases its symbols. The caller holds the lock.
+func (in *memoryDecisionCache) remove(element *list.Element) {
+	entry := element.Value.(*cachedDecision)
+	in.lru.Remove(element)
+	delete(in.entries, entry.key)
+	in.symbols.release(entry.key.principal)
+	in.symbols.release(entry.key.request)
+	in.symbols.release(entry.reason)
+}
+
+// snapshot returns the unexpired entries, the most recently used first.
+func (in *memoryDecisionCache) snapshot() []CachedDecisionEntry {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	now := time.Now()
+	entries := make([]CachedDecisionEntry, 0, in.lru.Len())
+	for element := in.lru.Front(); element != nil; element = element.Next() {
+		if entry := element.Value.(*cachedDecision); entry.expires.After(now) {
+			key := in.symbols.name(entry.key.principal) + "|" + in.symbols.name(entry.key.request)
+			entries = append(entries, CachedDecisionEntry{Key: key, Decision: entry.decision, Expires: entry.expires})
+		}
+	}
+	return entries
+}
+
+// restore adds the unexpired entries of a snapshot, keeping their order of use, and returns the number of
+// entries added. The entries whose keys are not decision cache keys, e.g. of a corrupted snapshot, are
+// skipped.
+func (in *memoryDecisionCache) restore(entries []CachedDecisionEntry) int {
+	now := time.Now()
+	restored := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		ttl := entry.Expires.Sub(now)
+		if ttl <= 0 {
+			continue
+		}
+		if len(strings.SplitN(entry.Key, "|", 4)) < 4 {
+			continue
+		}
+		if err := in.Set(context.Background(), entry.Key, entry.Decision, ttl); err == nil {
+			restored++
+		}
+	}
+	return restored
+}
+
+func (in *memoryDecisionCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (in *memoryDecisionCache) Purge(ctx context.Context) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.entries = map[memoryCacheKey]*list.Element{}
+	in.lru.Init()
+	in.symbols = newSymbolTable()
+	return nil
+}
+
+func (in *memoryDecisionCache) Invalidate(ctx context.Context, slice CacheSlice) error {
+	user, namespace := escapeKeyField(slice.User), escapeKeyField(slice.Namespace)
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for _, element := range in.entries {
+		entry := element.Value.(*cachedDecision)
+		if (slice.Namespace == AllNamespacesSlice || entry.namespace == namespace) && (slice.User == "" || entry.user == user) {
+			in.remove(element)
+		}
+	}
+	return nil
+}
+
+// redisDecisionCache is a DecisionCache shared by all the replicas through Redis.
+type redisDecisionCache struct {
+	client *redis.Client
+	// prefix is redisKeyPrefix followed by the CacheKeyPrefix of the config
+	prefix string
+}
+
+func (in *redisDecisionCache) Get(ctx context.Context, key string) (Decision, bool, error) {
+	value, err := in.client.Get(ctx, in.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Decision{}, false, nil
+	}
+	if err != nil {
+		return Decision{}, false, err
+	}
+
+	var decision Decision
+	if err := json.Unmarshal(value, &decision); err != nil {
+		return Decision{}, false, err
+	}
+	return decision, true, nil
+}
+
+func (in *redisDecisionCache) Set(ctx context.Context, key string, decision Decision, ttl time.Duration) error {
+	value, err := json.Marshal(decision)
+	if err != nil {
+		return err
+	}
+	return in.client.Set(ctx, in.prefix+key, value, ttl).Err()
+}
+
+// Close closes the connections to Redis, see PermissionChecker.ApplyConfig.
+func (in *redisDecisionCache) Close() error {
+	return in.client.Close()
+}
+
+func (in *redisDecisionCache) Ping(ctx context.Context) error {
+	return in.client.Ping(ctx).Err()
+}
+
+func (in *redisDecisionCache) Purge(ctx context.Context) error {
+	return in.deleteMatching(ctx, escapeGlob(in.prefix)+"*")
+}
+
+// Invalidate deletes the keys of the slice. The pattern may also match a few keys of other slices,
+// e.g. when the namespace is also the name of a resource, which are then needlessly evicted.
+func (in *redisDecisionCache) Invalidate(ctx context.Context, slice CacheSlice) error {
+	user, namespace := "*", "*"
+	if slice.User != "" {
+		user = escapeGlob(escapeKeyField(slice.User))
+	}
+	if slice.Namespace != AllNamespacesSlice {
+		namespace = escapeGlob(escapeKeyField(slice.Namespace))
+	}
+	return in.deleteMatching(ctx, escapeGlob(in.prefix)+user+"|*|"+namespace+"|*")
+}
+
+// deleteMatching deletes the keys matching the pattern, in batches.
+func (in *redisDecisionCache) deleteMatching(ctx context.Context, pattern string) error {
+	iter := in.client.Scan(ctx, 0, pattern, 500).Iterator()
+	keys := []string{}
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == 500 {
+			if err := in.client.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return in.client.Del(ctx, keys...).Err()
+	}
+	return nil
+}
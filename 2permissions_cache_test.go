@@ -0,0 +1,79 @@
+package business
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecisionCacheKeysDoNotCollide(t *testing.T) {
+	pods := AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}
+	keys := map[string]string{
+		"user with separator":      decisionCacheKey(UserInfo{Name: "alice|admins"}, pods),
+		"user and group":           decisionCacheKey(UserInfo{Name: "alice", Groups: []string{"admins"}}, pods),
+		"group with comma":         decisionCacheKey(UserInfo{Name: "alice", Groups: []string{"a,b"}}, pods),
+		"two groups":               decisionCacheKey(UserInfo{Name: "alice", Groups: []string{"a", "b"}}, pods),
+		"extra with separators":    decisionCacheKey(UserInfo{Name: "alice", Extra: map[string][]string{"scopes": {"a;b=c"}}}, pods),
+		"two extras":               decisionCacheKey(UserInfo{Name: "alice", Extra: map[string][]string{"scopes": {"a"}, "b": {"c"}}}, pods),
+		"namespace with separator": decisionCacheKey(UserInfo{Name: "alice"}, AccessRequest{Namespace: "ns1|pods", Verb: "get"}),
+		"escaped separator":        decisionCacheKey(UserInfo{Name: "alice%7Cadmins"}, pods),
+	}
+	seen := map[string]string{}
+	for name, key := range keys {
+		if other, ok := seen[key]; ok {
+			t.Errorf("%s and %s share the key %s", name, other, key)
+		}
+		seen[key] = name
+	}
+}
+
+func TestSplitDecisionCacheKey(t *testing.T) {
+	key := decisionCacheKey(UserInfo{Name: "alice|admins", Groups: []string{"a|b"}, Extra: map[string][]string{"x|y": {"z"}}}, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"})
+
+	principal, request := splitDecisionCacheKey(key)
+	assert.Equal(t, "alice%7Cadmins|a%7Cb|x%7Cy=z", principal)
+	assert.Equal(t, "ns1||pods|||get||", request)
+	assert.Equal(t, key, principal+"|"+request)
+}
+
+func TestMemoryDecisionCacheInvalidatesUsersWithSeparators(t *testing.T) {
+	cache := newMemoryDecisionCache(0)
+	pods := AccessRequest{Namespace: "ns|1", Resource: "pods", Verb: "get"}
+	tricky := UserInfo{Name: "alice|admins"}
+	alice := UserInfo{Name: "alice", Groups: []string{"admins"}}
+	require.NoError(t, cache.Set(testCtx, decisionCacheKey(tricky, pods), Decision{Allowed: true}, time.Hour))
+	require.NoError(t, cache.Set(testCtx, decisionCacheKey(alice, pods), Decision{Allowed: true}, time.Hour))
+
+	require.NoError(t, cache.Invalidate(testCtx, CacheSlice{User: "alice|admins", Namespace: "ns|1"}))
+
+	_, ok, err := cache.Get(testCtx, decisionCacheKey(tricky, pods))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, err = cache.Get(testCtx, decisionCacheKey(alice, pods))
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestMemoryDecisionCacheEvictsTheLeastRecentlyUsed(t *testing.T) {
+	cache := newMemoryDecisionCache(2)
+	key := func(verb string) string {
+		return decisionCacheKey(UserInfo{Name: "alice"}, AccessRequest{Resource: "pods", Verb: verb})
+	}
+	require.NoError(t, cache.Set(testCtx, key("get"), Decision{Allowed: true}, time.Hour))
+	require.NoError(t, cache.Set(testCtx, key("list"), Decision{Allowed: true}, time.Hour))
+	_, _, _ = cache.Get(testCtx, key("get"))
+	require.NoError(t, cache.Set(testCtx, key("watch"), Decision{Allowed: true}, time.Hour))
+
+	assert.Equal(t, 2, cache.len())
+	_, ok, _ := cache.Get(testCtx, key("list"))
+	assert.False(t, ok)
+	_, ok, _ = cache.Get(testCtx, key("get"))
+	assert.True(t, ok)
+}
+
+func TestEscapeGlob(t *testing.T) {
+	assert.Equal(t, `alice`, escapeGlob("alice"))
+	assert.Equal(t, `a\*b\?c\[d\]e\\f`, escapeGlob(`a*b?c[d]e\f`))
+}
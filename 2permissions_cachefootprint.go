@@ -0,0 +1,68 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// CacheFootprint is the memory used by the memory decision cache under a load of unique users, see
+// MeasureCacheFootprint.
+type CacheFootprint struct {
+	// Inserted is the number of decisions inserted, one per unique user.
+	Inserted int `json:"inserted"`
+	// MaxEntries is the bound of the cache, zero for no bound.
+	MaxEntries int `json:"maxEntries"`
+	// Entries is the number of decisions held at the end, at most MaxEntries if the bound holds.
+	Entries int `json:"entries"`
+	// Symbols is the number of distinct principals, requests and reasons interned by the entries.
+	Symbols int `json:"symbols"`
+	// HeapBytes is the growth of the live heap, after garbage collection, caused by the entries.
+	HeapBytes uint64 `json:"heapBytes"`
+	// BytesPerEntry is HeapBytes divided by Entries, for the capacity planning.
+	BytesPerEntry uint64 `json:"bytesPerEntry"`
+	// BoundHeld is false if the cache held more entries than its bound.
+	BoundHeld bool `json:"boundHeld"`
+}
+
+// MeasureCacheFootprint inserts decisions of inserted unique users into a memory decision cache bounded
+// to maxEntries (see PermissionsConfig.CacheMaxEntries, zero meaning no bound), with realistic keys and
+// reasons, and measures the memory it holds and whether the bound held during the whole load. The
+// heap is measured in the current process, so it should run alone, e.g. from a dedicated command.
+func MeasureCacheFootprint(inserted, maxEntries int) CacheFootprint {
+	footprint := CacheFootprint{Inserted: inserted, MaxEntries: maxEntries, BoundHeld: true}
+	stats := runtime.MemStats{}
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	before := stats.HeapAlloc
+
+	ctx := context.Background()
+	cache := newMemoryDecisionCache(maxEntries)
+	req := AccessRequest{APIGroup: "apps", Resource: "deployments", Verb: "list"}
+	for i := 0; i < inserted; i++ {
+		user := UserInfo{Name: fmt.Sprintf("user-%08d@example.com", i), Groups: []string{"system:authenticated", "developers"}}
+		req.Namespace = fmt.Sprintf("namespace-%04d", i%1000)
+		decision := Decision{Allowed: true, Reason: `RBAC: allowed by RoleBinding "developers/` + req.Namespace + `" of ClusterRole "edit" to Group "developers"`, Source: DecisionSourceAPIServer, Timestamp: time.Now()}
+		_ = cache.Set(ctx, decisionCacheKey(user, req), decision, time.Hour)
+		if maxEntries > 0 && i%1000 == 0 && cache.len() > maxEntries {
+			footprint.BoundHeld = false
+		}
+	}
+
+	footprint.Entries = cache.len()
+	footprint.Symbols = cache.symbolCount()
+	if maxEntries > 0 && footprint.Entries > maxEntries {
+		footprint.BoundHeld = false
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	if stats.HeapAlloc > before {
+		footprint.HeapBytes = stats.HeapAlloc - before
+	}
+	if footprint.Entries > 0 {
+		footprint.BytesPerEntry = footprint.HeapBytes / uint64(footprint.Entries)
+	}
+	runtime.KeepAlive(cache)
+	return footprint
+}
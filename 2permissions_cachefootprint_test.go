@@ -0,0 +1,104 @@
+package business
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheFootprintIsBounded(t *testing.T) {
+	footprint := MeasureCacheFootprint(20000, 1000)
+
+	assert.True(t, footprint.BoundHeld)
+	assert.Equal(t, 1000, footprint.Entries)
+	// A principal and a request per entry at most, and the reasons, which repeat per namespace
+	assert.LessOrEqual(t, footprint.Symbols, 3*1000)
+}
+
+func TestCacheFootprintSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak test")
+	}
+	bounded := MeasureCacheFootprint(500000, 10000)
+	require.True(t, bounded.BoundHeld)
+	require.Equal(t, 10000, bounded.Entries)
+	// Inserting 50 times the bound keeps the memory of the bound, with a generous margin per entry
+	assert.Less(t, bounded.HeapBytes, uint64(10000*4096), "%d bytes for %d entries", bounded.HeapBytes, bounded.Entries)
+
+	unbounded := MeasureCacheFootprint(100000, 0)
+	assert.Equal(t, 100000, unbounded.Entries)
+	assert.Greater(t, unbounded.HeapBytes, bounded.HeapBytes)
+}
+
+func TestMemoryDecisionCacheInternsTheRepeatedStrings(t *testing.T) {
+	cache := newMemoryDecisionCache(0)
+	req := AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}
+	for i := 0; i < 1000; i++ {
+		user := UserInfo{Name: fmt.Sprintf("user-%d", i), Groups: []string{"developers"}}
+		require.NoError(t, cache.Set(testCtx, decisionCacheKey(user, req), Decision{Allowed: true, Reason: "bound"}, time.Hour))
+	}
+
+	// The principals, then the request and the reason shared by all the entries
+	assert.Equal(t, 1000+2, cache.symbolCount())
+}
+
+func TestMemoryDecisionCacheReleasesTheSymbols(t *testing.T) {
+	cache := newMemoryDecisionCache(10)
+	for i := 0; i < 100; i++ {
+		user := UserInfo{Name: fmt.Sprintf("user-%d", i)}
+		req := AccessRequest{Namespace: fmt.Sprintf("ns%d", i), Resource: "pods", Verb: "get"}
+		require.NoError(t, cache.Set(testCtx, decisionCacheKey(user, req), Decision{Reason: fmt.Sprintf("reason %d", i)}, time.Hour))
+	}
+	// The evicted entries released their symbols
+	assert.Equal(t, 10, cache.len())
+	assert.Equal(t, 30, cache.symbolCount())
+
+	require.NoError(t, cache.Invalidate(testCtx, CacheSlice{Namespace: AllNamespacesSlice}))
+	assert.Zero(t, cache.len())
+	assert.Zero(t, cache.symbolCount())
+}
+
+func BenchmarkMemoryDecisionCacheSet(b *testing.B) {
+	cache := newMemoryDecisionCache(10000)
+	req := AccessRequest{APIGroup: "apps", Resource: "deployments", Verb: "list"}
+	decision := Decision{Allowed: true, Reason: "bound", Source: DecisionSourceAPIServer}
+	keys := make([]string, 100000)
+	for i := range keys {
+		req.Namespace = fmt.Sprintf("namespace-%04d", i%1000)
+		keys[i] = decisionCacheKey(UserInfo{Name: fmt.Sprintf("user-%08d", i)}, req)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = cache.Set(testCtx, keys[i%len(keys)], decision, time.Hour)
+	}
+}
+
+func BenchmarkCachedCheck(b *testing.B) {
+	checker := newTestChecker(&testReviews{allow: allowUsers("alice")}, NewPermissionsConfig())
+	alice := UserInfo{Name: "alice", Groups: []string{"developers"}}
+	req := AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}
+	_, _ = checker.Check(testCtx, alice, req)
+	checker.SetAuditSink(nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = checker.Check(testCtx, alice, req)
+	}
+}
+
+func BenchmarkCacheFootprint(b *testing.B) {
+	for _, maxEntries := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("max=%d", maxEntries), func(b *testing.B) {
+			var footprint CacheFootprint
+			for i := 0; i < b.N; i++ {
+				footprint = MeasureCacheFootprint(10*maxEntries, maxEntries)
+			}
+			b.ReportMetric(float64(footprint.BytesPerEntry), "bytes/entry")
+			b.ReportMetric(float64(footprint.Symbols), "symbols")
+		})
+	}
+}
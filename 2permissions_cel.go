@@ -0,0 +1,113 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/cel-go/cel"
+)
+
+// AuthorizerCEL is the name of the built-in authorizer making conditional decisions with a CEL expression.
+const AuthorizerCEL = "cel"
+
+// CEL authorizer effects
+const (
+	CELEffectAllow = "allow"
+	CELEffectDeny  = "deny"
+)
+
+func init() {
+	RegisterAuthorizer(AuthorizerCEL, NewCELAuthorizer)
+}
+
+// celAuthorizer allows or denies the requests matching a CEL expression, and has no opinion about the others.
+type celAuthorizer struct {
+	program cel.Program
+	effect  string
+	message string
+}
+
+// NewCELAuthorizer creates an authorizer from a CEL expression, for conditional decisions beyond plain RBAC.
+// Its options are:
+//
+//	expression: a boolean CEL expression over "request" (namespace, apiGroup, resource, subresource, name,
+//	  verb, fieldSelector, labelSelector) and "user" (name, groups, extra)
+//	effect: "allow" or "deny" the requests matching the expression
+//	message: the reason of the decisions, optional
+//
+// For example, to deny the deletion of the prod-* namespaces to everybody but SREs, put this authorizer
+// before the subjectaccessreview one in a first-match chain, with the deny effect and the expression:
+//
+//	request.verb == 'delete' && request.resource == 'namespaces' && request.name.startsWith('prod-') && !('sre' in user.groups)
+func NewCELAuthorizer(client PermissionsClient, options map[string]string) (Authorizer, error) {
+	effect := options["effect"]
+	if effect != CELEffectAllow && effect != CELEffectDeny {
+		return nil, fmt.Errorf("invalid effect %q, expected %s or %s", effect, CELEffectAllow, CELEffectDeny)
+	}
+
+	env, err := cel.NewEnv(
+		cel.Variable("request", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("user", cel.MapType(cel.StringType, cel.DynType)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(options["expression"])
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid CEL expression: %w", issues.Err())
+	}
+	if !ast.OutputType().IsExactType(cel.BoolType) {
+		return nil, fmt.Errorf("the CEL expression must return a bool, not %s", ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CEL expression: %w", err)
+	}
+
+	message := options["message"]
+	if message == "" {
+		message = effect + " condition matched: " + options["expression"]
+	}
+	return &celAuthorizer{program: program, effect: effect, message: message}, nil
+}
+
+func (in *celAuthorizer) Authorize(ctx context.Context, user UserInfo, req AccessRequest) (Decision, error) {
+	groups := user.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	extra := user.Extra
+	if extra == nil {
+		extra = map[string][]string{}
+	}
+
+	out, _, err := in.program.ContextEval(ctx, map[string]interface{}{
+		"request": map[string]string{
+			"namespace":     req.Namespace,
+			"apiGroup":      req.APIGroup,
+			"resource":      req.Resource,
+			"subresource":   req.Subresource,
+			"name":          req.Name,
+			"verb":          req.Verb,
+			"fieldSelector": req.FieldSelector,
+			"labelSelector": req.LabelSelector,
+		},
+		"user": map[string]interface{}{
+			"name":   user.Name,
+			"groups": groups,
+			"extra":  extra,
+		},
+	})
+	if err != nil {
+		return Decision{Source: AuthorizerCEL, EvaluationError: err.Error(), Timestamp: time.Now()}, fmt.Errorf("error evaluating CEL condition: %w", err)
+	}
+
+	decision := Decision{Source: AuthorizerCEL, Timestamp: time.Now()}
+	if matched, _ := out.Value().(bool); matched {
+		decision.Allowed = in.effect == CELEffectAllow
+		decision.Denied = in.effect == CELEffectDeny
+		decision.Reason = in.message
+	}
+	return decision, nil
+}
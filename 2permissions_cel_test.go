@@ -0,0 +1,73 @@
+package business
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testProdDeleteCondition = `request.verb == 'delete' && request.resource == 'namespaces' && request.name.startsWith('prod-') && !('sre' in user.groups)`
+
+func TestCELAuthorizerDeny(t *testing.T) {
+	authorizer, err := NewCELAuthorizer(nil, map[string]string{"effect": CELEffectDeny, "expression": testProdDeleteCondition})
+	require.NoError(t, err)
+	deleteProd := AccessRequest{Resource: "namespaces", Name: "prod-eu", Verb: "delete"}
+
+	decision, err := authorizer.Authorize(testCtx, UserInfo{Name: "alice", Groups: []string{"developers"}}, deleteProd)
+	require.NoError(t, err)
+	assert.True(t, decision.Denied)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, "deny condition matched: "+testProdDeleteCondition, decision.Reason)
+	assert.Equal(t, AuthorizerCEL, decision.Source)
+
+	// No opinion about the requests not matching the expression, e.g. of users without groups
+	decision, err = authorizer.Authorize(testCtx, UserInfo{Name: "bob", Groups: []string{"sre"}}, deleteProd)
+	require.NoError(t, err)
+	assert.False(t, decision.Denied || decision.Allowed)
+	decision, err = authorizer.Authorize(testCtx, UserInfo{Name: "carol"}, AccessRequest{Resource: "namespaces", Name: "dev", Verb: "delete"})
+	require.NoError(t, err)
+	assert.False(t, decision.Denied || decision.Allowed)
+}
+
+func TestCELAuthorizerAllow(t *testing.T) {
+	authorizer, err := NewCELAuthorizer(nil, map[string]string{
+		"effect":     CELEffectAllow,
+		"expression": `request.verb == 'get' && 'oncall' in user.extra && request.namespace.startsWith('team-')`,
+		"message":    "on-call read access",
+	})
+	require.NoError(t, err)
+
+	decision, err := authorizer.Authorize(testCtx, UserInfo{Name: "alice", Extra: map[string][]string{"oncall": {"true"}}}, AccessRequest{Namespace: "team-a", Resource: "pods", Verb: "get"})
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, "on-call read access", decision.Reason)
+
+	decision, err = authorizer.Authorize(testCtx, UserInfo{Name: "alice"}, AccessRequest{Namespace: "team-a", Resource: "pods", Verb: "get"})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+}
+
+func TestCELAuthorizerSelectors(t *testing.T) {
+	authorizer, err := NewCELAuthorizer(nil, map[string]string{"effect": CELEffectAllow, "expression": `request.verb == 'list' && request.fieldSelector == 'spec.nodeName=node1' && request.labelSelector == ''`})
+	require.NoError(t, err)
+
+	decision, err := authorizer.Authorize(testCtx, UserInfo{Name: "alice"}, AccessRequest{Resource: "pods", Verb: "list", FieldSelector: "spec.nodeName=node1"})
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	decision, err = authorizer.Authorize(testCtx, UserInfo{Name: "alice"}, AccessRequest{Resource: "pods", Verb: "list", FieldSelector: "spec.nodeName=node1", LabelSelector: "app=web"})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+}
+
+func TestNewCELAuthorizerErrors(t *testing.T) {
+	for options, message := range map[[2]string]string{
+		{"permit", "true"}:                      `invalid effect "permit"`,
+		{CELEffectAllow, "request.verb =="}:     "invalid CEL expression",
+		{CELEffectAllow, "request.verb"}:        "the CEL expression must return a bool",
+		{CELEffectDeny, "request.unknown == 1"}: "invalid CEL expression",
+	} {
+		_, err := NewCELAuthorizer(nil, map[string]string{"effect": options[0], "expression": options[1]})
+		assert.ErrorContains(t, err, message, options[1])
+	}
+}
@@ -0,0 +1,55 @@
+package business
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// authenticatedGroup is added by the apiserver to the groups of every authenticated user.
+const authenticatedGroup = "system:authenticated"
+
+// ErrNoClientCertificate is returned by UserInfoFromRequest for the requests without a verified client certificate.
+var ErrNoClientCertificate = errors.New("no verified client certificate")
+
+// UserInfoFromCertificate extracts the identity of an x509 client certificate like the apiserver does: the
+// common name is the username and the organizations are the groups, plus system:authenticated. The
+// certificate must have been verified by the caller, only its validity period is checked here.
+func UserInfoFromCertificate(cert *x509.Certificate) (UserInfo, error) {
+	if cert.Subject.CommonName == "" {
+		return UserInfo{}, fmt.Errorf("client certificate %s has no common name", cert.SerialNumber)
+	}
+	if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return UserInfo{}, fmt.Errorf("client certificate of %s is not valid at %s, only from %s to %s", cert.Subject.CommonName,
+			now.UTC().Format(time.RFC3339), cert.NotBefore.UTC().Format(time.RFC3339), cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	groups := make([]string, 0, len(cert.Subject.Organization)+1)
+	groups = append(groups, cert.Subject.Organization...)
+	if !containsString(groups, authenticatedGroup) {
+		groups = append(groups, authenticatedGroup)
+	}
+	return UserInfo{Name: cert.Subject.CommonName, Groups: groups}, nil
+}
+
+// UserInfoFromRequest extracts the identity of the mTLS client of the request. Only certificates verified
+// by the TLS server, i.e. with ClientAuth set to VerifyClientCertIfGiven or RequireAndVerifyClientCert, are
+// trusted.
+func UserInfoFromRequest(r *http.Request) (UserInfo, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return UserInfo{}, ErrNoClientCertificate
+	}
+	return UserInfoFromCertificate(r.TLS.VerifiedChains[0][0])
+}
+
+// CheckCertificate decides if the identity of the client certificate can perform the request, so the
+// controllers authenticated with mTLS are checked and audited like the token users. See Check.
+func (in *PermissionChecker) CheckCertificate(ctx context.Context, cert *x509.Certificate, req AccessRequest) (Decision, error) {
+	user, err := UserInfoFromCertificate(cert)
+	if err != nil {
+		return Decision{}, withRequestID(ctx, err)
+	}
+	return in.Check(ctx, user, req)
+}
@@ -0,0 +1,141 @@
+package business
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA is a certificate authority issuing client certificates for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t testing.TB, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// clientCertificate issues a client certificate of the common name and organizations.
+func (in *testCA) clientCertificate(t testing.TB, commonName string, organizations ...string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName, Organization: organizations},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, in.cert, &key.PublicKey, in.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writePEM writes the certificate of the CA in a file of the test directory, and returns its path.
+func (in *testCA) writePEM(t testing.TB) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: in.cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUserInfoFromCertificate(t *testing.T) {
+	ca := newTestCA(t, "clients")
+
+	user, err := UserInfoFromCertificate(ca.clientCertificate(t, "alice", "developers", "sre"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user.Name)
+	// The organizations are a DER set, encoded in the order of their encoding and not the given one
+	assert.ElementsMatch(t, []string{"developers", "sre", "system:authenticated"}, user.Groups)
+
+	user, err = UserInfoFromCertificate(ca.clientCertificate(t, "system:kube-controller-manager", "system:authenticated"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"system:authenticated"}, user.Groups)
+
+	_, err = UserInfoFromCertificate(ca.clientCertificate(t, ""))
+	assert.ErrorContains(t, err, "has no common name")
+
+	expired := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}, NotBefore: time.Now().Add(-2 * time.Hour), NotAfter: time.Now().Add(-time.Hour)}
+	_, err = UserInfoFromCertificate(expired)
+	assert.ErrorContains(t, err, "client certificate of alice is not valid")
+}
+
+func TestUserInfoFromRequest(t *testing.T) {
+	ca := newTestCA(t, "clients")
+	cert := ca.clientCertificate(t, "alice", "developers")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, err := UserInfoFromRequest(req)
+	assert.ErrorIs(t, err, ErrNoClientCertificate)
+
+	// Certificates presented but not verified are not trusted
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	_, err = UserInfoFromRequest(req)
+	assert.ErrorIs(t, err, ErrNoClientCertificate)
+
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{cert, ca.cert}}
+	user, err := UserInfoFromRequest(req)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user.Name)
+}
+
+func TestCheckCertificate(t *testing.T) {
+	ca := newTestCA(t, "clients")
+	checker := newTestChecker(aliceReadsPods(), nil)
+
+	decision, err := checker.CheckCertificate(testCtx, ca.clientCertificate(t, "alice"), alicePods)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+
+	_, err = checker.CheckCertificate(WithRequestID(testCtx, "req-1"), ca.clientCertificate(t, ""), alicePods)
+	assert.ErrorContains(t, err, "req-1")
+}
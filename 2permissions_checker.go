@@ -0,0 +1,505 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+
+	"github.com/kiali/kiali/log"
+)
+
+// EnforcementMode controls what a PermissionChecker does with denials.
+type EnforcementMode string
+
+const (
+	// EnforcementModeEnforce denies the requests the authorizer denies.
+	EnforcementModeEnforce EnforcementMode = "enforce"
+	// EnforcementModeAudit allows every request, but records the ones that would be denied.
+	EnforcementModeAudit EnforcementMode = "audit"
+	// EnforcementModeDisabled allows every request without asking the authorizer.
+	EnforcementModeDisabled EnforcementMode = "disabled"
+)
+
+// DecisionSourceEnforcementDisabled is the source of the decisions made while enforcement is disabled.
+const DecisionSourceEnforcementDisabled = "enforcement-disabled"
+
+// AuditRecord describes a check of a PermissionChecker: a denial, or an allow selected by its AuditSampler.
+type AuditRecord struct {
+	Timestamp time.Time
+	// RequestID is the ID of the originating API request, if known.
+	RequestID string
+	User      UserInfo
+	Request   AccessRequest
+	// Decision is the decision of the authorizer, before the enforcement mode is applied.
+	Decision Decision
+	Mode     EnforcementMode
+	// Enforced is false when the request was allowed anyway because of the audit mode.
+	Enforced bool
+}
+
+// AuditSink receives the audit records of a PermissionChecker, redacted by the RedactionPolicy.
+type AuditSink interface {
+	Record(ctx context.Context, record AuditRecord)
+}
+
+// logAuditSink is the default AuditSink, writing the records to the Kiali log.
+type logAuditSink struct{}
+
+func (logAuditSink) Record(ctx context.Context, record AuditRecord) {
+	req := record.Request
+	if record.Decision.Allowed {
+		log.Infof("%sPermission allowed: user [%s] verb [%s] resource [%s/%s] subresource [%s] name [%s] namespace [%s]: %s",
+			logPrefix(ctx), record.User.Name, req.Verb, req.APIGroup, req.Resource, req.Subresource, req.Name, req.Namespace, record.Decision.Reason)
+		return
+	}
+	log.Infof("%sPermission denied (enforced=%t): user [%s] verb [%s] resource [%s/%s] subresource [%s] name [%s] namespace [%s]: %s",
+		logPrefix(ctx), record.Enforced, record.User.Name, req.Verb, req.APIGroup, req.Resource, req.Subresource, req.Name, req.Namespace, record.Decision.Reason)
+}
+
+// PermissionChecker checks the permissions of arbitrary users with SubjectAccessReviews, caching
+// the decisions. Its enforcement mode allows rolling out enforcement without breaking users.
+// It is safe for concurrent use, including changes of its config.
+type PermissionChecker struct {
+	client PermissionsClient
+	// apiRequests bounds the reviews of the client, see APIRequestLimiter.
+	apiRequests *APIRequestLimiter
+
+	// applyMu serializes ApplyConfig, so the changes are computed against the config they replace.
+	applyMu sync.Mutex
+
+	mu        sync.RWMutex
+	conf      *PermissionsConfig
+	cache     DecisionCache
+	chain     *authorizerChain
+	overlay   *compiledOverlay
+	auditSink AuditSink
+	// auditSampler overrides the AuditSampling of the config, see SetAuditSampler.
+	auditSampler AuditSampler
+	// verification compares sampled decisions with the local evaluation, see SetVerificationSource.
+	verification *verification
+	// prefetcher queues the hinted checks, see Prefetch.
+	prefetcher *prefetcher
+	// claimProcessSettings rejects the configs changing the settings of the whole process, when the
+	// checker shares the process with others, see TenantRegistry.
+	claimProcessSettings func(conf *PermissionsConfig) error
+
+	// lifecycleMu guards drained, so no check starts once Drain waits for the in-flight ones.
+	lifecycleMu sync.RWMutex
+	drained     bool
+	inflight    sync.WaitGroup
+
+	// generationMu orders the cache writes of the checks and the invalidations, see cachedAuthorize.
+	generationMu sync.RWMutex
+	// generation counts the invalidations of the cache.
+	generation uint64
+}
+
+// NewPermissionChecker creates a checker with the default config, logging the denials.
+// Requests are authorized with SubjectAccessReviews until a config with other authorizers is applied.
+func NewPermissionChecker(client PermissionsClient) *PermissionChecker {
+	conf := NewPermissionsConfig()
+	apiRequests := NewAPIRequestLimiter(conf.MaxConcurrentAPIRequests)
+	// The prefetcher looks at the optional interfaces of the client itself, see userRulesReviewer
+	prefetcher := newPrefetcher(client)
+	client = NewLimitedClient(NewAccountingClient(client), apiRequests)
+	// The default chain is the built-in authorizer, which cannot fail to build
+	chain, _ := buildAuthorizerChain(client, conf)
+	overlay, _ := conf.Overlay.compile()
+	return &PermissionChecker{
+		client:      client,
+		apiRequests: apiRequests,
+		conf:        conf,
+		cache:       newMemoryDecisionCache(conf.cacheMaxEntries()),
+		chain:       chain,
+		overlay:     overlay,
+		auditSink:   logAuditSink{},
+		prefetcher:  prefetcher,
+	}
+}
+
+// APIRequestLimiter returns the limiter of the reviews of the checker, bounded by the
+// MaxConcurrentAPIRequests of its config, so other fan-outs can share it, see NewLimitedClient.
+func (in *PermissionChecker) APIRequestLimiter() *APIRequestLimiter {
+	return in.apiRequests
+}
+
+// Mode returns the current enforcement mode.
+func (in *PermissionChecker) Mode() EnforcementMode {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	return in.conf.Mode
+}
+
+// SetMode changes the enforcement mode. It can be called while checks are in flight.
+func (in *PermissionChecker) SetMode(mode EnforcementMode) error {
+	if err := validateEnforcementMode(mode); err != nil {
+		return err
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	conf := *in.conf
+	conf.Mode = mode
+	in.conf = &conf
+	return nil
+}
+
+// ApplyConfig replaces the config of the checker, e.g. after a hot reload. The cache backend
+// is only recreated if its settings changed. The cached decisions survive the other changes, except the
+// ones of the authorizers, the chain mode or the quorum: the decisions of the previous chain are purged.
+// The checker keeps a copy of conf, so the caller can reuse it, but the slices and maps of the
+// config are shared and must not be modified afterwards. The max concurrent API requests, the redaction,
+// the feature gates and the consumer budgets apply to the whole process: the checkers of a TenantRegistry
+// reject the configs changing them for the other tenants. A replaced cache backend is closed.
+func (in *PermissionChecker) ApplyConfig(conf *PermissionsConfig) error {
+	in.applyMu.Lock()
+	defer in.applyMu.Unlock()
+
+	copied := *conf
+	conf = &copied
+
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	in.mu.RLock()
+	cache, previousCache, claimProcessSettings := in.cache, in.cache, in.claimProcessSettings
+	backendChanged := conf.CacheBackend != in.conf.CacheBackend || conf.RedisAddress != in.conf.RedisAddress || conf.CacheKeyPrefix != in.conf.CacheKeyPrefix
+	chainChanged := !reflect.DeepEqual(conf.Authorizers, in.conf.Authorizers) || conf.ChainMode != in.conf.ChainMode || conf.ChainQuorum != in.conf.ChainQuorum
+	in.mu.RUnlock()
+
+	if backendChanged {
+		var err error
+		if cache, err = NewDecisionCache(conf); err != nil {
+			return err
+		}
+	}
+	chain, err := buildAuthorizerChain(in.client, conf)
+	if err != nil {
+		return err
+	}
+	overlay, err := conf.Overlay.compile()
+	if err != nil {
+		return err
+	}
+
+	if claimProcessSettings != nil {
+		if err := claimProcessSettings(conf); err != nil {
+			return err
+		}
+	}
+
+	if memory, ok := cache.(*memoryDecisionCache); ok && !backendChanged {
+		memory.setMaxEntries(conf.cacheMaxEntries())
+	}
+
+	in.apiRequests.SetLimit(conf.MaxConcurrentAPIRequests)
+	SetRedactionPolicy(conf.Redaction)
+	SetFeatureGates(conf.FeatureGates)
+	SetConsumerBudgets(conf.ConsumerBudgets)
+
+	in.mu.Lock()
+	in.conf = conf
+	in.cache = cache
+	in.chain = chain
+	in.overlay = overlay
+	in.mu.Unlock()
+
+	if closer, ok := previousCache.(io.Closer); ok && backendChanged {
+		// The checks in flight with the previous backend fail to use it, and decide without the cache
+		if err := closer.Close(); err != nil {
+			log.Warningf("Error closing the previous permissions cache: %v", err)
+		}
+	}
+	if chainChanged && !backendChanged {
+		// Purged once the new chain decides, the checks in flight do not cache the decisions of the previous one
+		in.nextGeneration()
+		if err := cache.Purge(context.Background()); err != nil {
+			return fmt.Errorf("config applied, but error purging the decisions of the previous authorizers: %w", err)
+		}
+	}
+	return nil
+}
+
+func validateEnforcementMode(mode EnforcementMode) error {
+	switch mode {
+	case EnforcementModeEnforce, EnforcementModeAudit, EnforcementModeDisabled:
+		return nil
+	default:
+		return fmt.Errorf("unknown enforcement mode %q", mode)
+	}
+}
+
+// SetAuditSink replaces the sink receiving the audit records. A nil sink disables auditing.
+func (in *PermissionChecker) SetAuditSink(sink AuditSink) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.auditSink = sink
+}
+
+// SetAuditSampler replaces the AuditSampling of the config with a custom sampler. A nil sampler restores
+// the AuditSampling of the config.
+func (in *PermissionChecker) SetAuditSampler(sampler AuditSampler) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.auditSampler = sampler
+}
+
+// Check decides if the user can perform the request, according to the enforcement mode. Evaluation errors
+// are handled according to the failure policy of the config.
+// The request ID of the context, see WithRequestID, is included in the logs, audit records and errors.
+// Once the checker is drained, ErrCheckerShutDown is returned.
+func (in *PermissionChecker) Check(ctx context.Context, user UserInfo, req AccessRequest) (Decision, error) {
+	if !in.beginCheck() {
+		return Decision{}, ErrCheckerShutDown
+	}
+	defer in.inflight.Done()
+
+	in.mu.RLock()
+	conf, cache, chain, overlay, sink, sampler, verification := in.conf, in.cache, in.chain, in.overlay, in.auditSink, in.auditSampler, in.verification
+	in.mu.RUnlock()
+	if sampler == nil {
+		sampler = conf.AuditSampling
+	}
+	mode := conf.Mode
+
+	if mode == EnforcementModeDisabled {
+		return Decision{Allowed: true, Reason: "permission enforcement is disabled", Source: DecisionSourceEnforcementDisabled, Timestamp: time.Now()}, nil
+	}
+
+	ttl := conf.cacheTTLFor(user, req)
+	user = withoutGroups(user, conf.ExcludedGroups)
+	decision, err := in.cachedAuthorize(ctx, cache, ttl, chain, user, req)
+	if err != nil {
+		if decision, err = applyFailurePolicy(ctx, conf.FailurePolicy, user, req, err); err != nil {
+			return decision, err
+		}
+	}
+	if verification != nil && conf.FeatureGates.Enabled(FeatureLocalEvaluator) && decision.Source != DecisionSourceCache && decision.Source != DecisionSourceFailurePolicy && rand.Float64() < conf.VerificationSampleRate {
+		verification.verifyInBackground(ctx, user, req, decision)
+	}
+	decision = overlay.apply(user, req, decision)
+
+	record := AuditRecord{Timestamp: decision.Timestamp, RequestID: RequestIDFromContext(ctx), User: user, Request: req, Decision: decision, Mode: mode, Enforced: !decision.Allowed && mode == EnforcementModeEnforce}
+	if sink != nil && sampler.Sample(record) {
+		sink.Record(ctx, currentRedaction().AuditRecord(record))
+	}
+	if decision.Allowed {
+		return decision, nil
+	}
+	if mode == EnforcementModeAudit {
+		allowed := decision
+		allowed.Allowed = true
+		allowed.Reason = "audit mode, would be denied: " + decision.Reason
+		return allowed, nil
+	}
+	return decision, nil
+}
+
+// recordAudit writes the record to the audit sink, redacted, whatever the audit sampling: it audits the
+// events which must never be dropped, e.g. the break-glass activations. The mode of the record is the
+// one of the checker.
+func (in *PermissionChecker) recordAudit(ctx context.Context, record AuditRecord) {
+	in.mu.RLock()
+	sink, mode := in.auditSink, in.conf.Mode
+	in.mu.RUnlock()
+	if sink == nil {
+		return
+	}
+	record.Mode = mode
+	sink.Record(ctx, currentRedaction().AuditRecord(record))
+}
+
+// authorize decides if the user can perform the request with the authorizer chain, whatever the
+// enforcement mode and the failure policy: the errors are returned, for the caller to deny the request.
+// It authorizes the access to the permission subsystem itself, which the audit and disabled modes, meant
+// to roll out the enforcement on Kiali, must not open.
+func (in *PermissionChecker) authorize(ctx context.Context, user UserInfo, req AccessRequest) (Decision, error) {
+	in.mu.RLock()
+	conf, cache, chain := in.conf, in.cache, in.chain
+	in.mu.RUnlock()
+
+	ttl := conf.cacheTTLFor(user, req)
+	user = withoutGroups(user, conf.ExcludedGroups)
+	return in.cachedAuthorize(ctx, cache, ttl, chain, user, req)
+}
+
+// PingCache checks the connectivity with the cache backend.
+func (in *PermissionChecker) PingCache(ctx context.Context) error {
+	in.mu.RLock()
+	cache := in.cache
+	in.mu.RUnlock()
+	return cache.Ping(ctx)
+}
+
+// PurgeCache removes all the cached decisions, e.g. after an RBAC change.
+func (in *PermissionChecker) PurgeCache(ctx context.Context) error {
+	in.nextGeneration()
+	in.mu.RLock()
+	cache := in.cache
+	in.mu.RUnlock()
+	return cache.Purge(ctx)
+}
+
+// InvalidateCache removes the cached decisions of the slices, as reported by the PermissionWatcher
+// change handler. Nil slices remove all the cached decisions.
+func (in *PermissionChecker) InvalidateCache(ctx context.Context, slices []CacheSlice) error {
+	if slices == nil {
+		return in.PurgeCache(ctx)
+	}
+	in.nextGeneration()
+	in.mu.RLock()
+	cache := in.cache
+	in.mu.RUnlock()
+	for _, slice := range slices {
+		if err := cache.Invalidate(ctx, slice); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsAllowed is a convenience wrapper of Check returning only whether the request is allowed.
+func (in *PermissionChecker) IsAllowed(ctx context.Context, user UserInfo, req AccessRequest) (bool, error) {
+	decision, err := in.Check(ctx, user, req)
+	return decision.Allowed, err
+}
+
+// nextGeneration is called before the cached decisions are invalidated, so the checks in flight, whose
+// decisions may predate the invalidation, do not cache them once it is done.
+func (in *PermissionChecker) nextGeneration() {
+	in.generationMu.Lock()
+	defer in.generationMu.Unlock()
+	in.generation++
+}
+
+// cachedAuthorize returns the cached decision of the request, or asks the authorizer chain and caches the
+// decision for ttl, unless the cache was invalidated in the meantime or the decision has an EvaluationError,
+// e.g. of an authorizer skipped on error. Cache errors are logged and the chain is asked instead. Cached
+// decisions older than the max staleness of the context, see WithMaxStaleness, are refreshed.
+func (in *PermissionChecker) cachedAuthorize(ctx context.Context, cache DecisionCache, ttl time.Duration, chain *authorizerChain, user UserInfo, req AccessRequest) (Decision, error) {
+	if ttl <= 0 {
+		return chain.authorize(ctx, user, req)
+	}
+	in.generationMu.RLock()
+	generation := in.generation
+	in.generationMu.RUnlock()
+
+	key := decisionCacheKey(user, req)
+	cached, found, err := cache.Get(ctx, key)
+	if err != nil {
+		log.Warningf("%sError reading the permissions cache: %v", logPrefix(ctx), err)
+	} else if found && freshEnough(ctx, cached) {
+		cached.Source = DecisionSourceCache
+		return cached, nil
+	}
+
+	decision, err := chain.authorize(ctx, user, req)
+	if err != nil {
+		return decision, err
+	}
+	in.storeDecision(ctx, cache, generation, ttl, key, decision)
+	return decision, nil
+}
+
+// storeDecision caches the decision made at the generation of the cache, unless the cache was invalidated
+// since or the decision has an evaluation error.
+func (in *PermissionChecker) storeDecision(ctx context.Context, cache DecisionCache, generation uint64, ttl time.Duration, key string, decision Decision) {
+	// Written under the read lock, so an invalidation either follows the write or prevents it
+	in.generationMu.RLock()
+	defer in.generationMu.RUnlock()
+	if in.generation != generation || decision.EvaluationError != "" {
+		return
+	}
+	if err := cache.Set(ctx, key, decision, ttl); err != nil {
+		log.Warningf("%sError writing the permissions cache: %v", logPrefix(ctx), err)
+	}
+}
+
+// withoutGroups returns the user without the given groups.
+func withoutGroups(user UserInfo, excluded []string) UserInfo {
+	if len(excluded) == 0 {
+		return user
+	}
+	groups := make([]string, 0, len(user.Groups))
+	for _, group := range user.Groups {
+		if !containsString(excluded, group) {
+			groups = append(groups, group)
+		}
+	}
+	user.Groups = groups
+	return user
+}
+
+// subjectAccessReviewFor builds the SubjectAccessReview asking if the user can perform the request.
+// The extra attributes of the user are passed along, so that e.g. scope-restricted tokens are not
+// evaluated as the full-privilege user.
+func subjectAccessReviewFor(user UserInfo, req AccessRequest) *auth_v1.SubjectAccessReview {
+	var extra map[string]auth_v1.ExtraValue
+	if len(user.Extra) > 0 {
+		extra = make(map[string]auth_v1.ExtraValue, len(user.Extra))
+		for k, v := range user.Extra {
+			extra[k] = auth_v1.ExtraValue(v)
+		}
+	}
+	attrs := &auth_v1.ResourceAttributes{
+		Namespace:   req.Namespace,
+		Verb:        req.Verb,
+		Group:       req.APIGroup,
+		Resource:    req.Resource,
+		Subresource: req.Subresource,
+		Name:        req.Name,
+	}
+	// The apiserver parses the raw selectors; older apiservers ignore them
+	if req.FieldSelector != "" {
+		attrs.FieldSelector = &auth_v1.FieldSelectorAttributes{RawSelector: req.FieldSelector}
+	}
+	if req.LabelSelector != "" {
+		attrs.LabelSelector = &auth_v1.LabelSelectorAttributes{RawSelector: req.LabelSelector}
+	}
+	return &auth_v1.SubjectAccessReview{
+		Spec: auth_v1.SubjectAccessReviewSpec{
+			User:               user.Name,
+			Groups:             user.Groups,
+			Extra:              extra,
+			ResourceAttributes: attrs,
+		},
+	}
+}
+
+// RequirePermission returns an HTTP middleware that checks the permission returned by requestFn for the
+// user returned by userFn before calling the next handler. Denied requests get a 403 problem+json
+// response, see WriteDenialProblem. Since the checker applies its enforcement mode, nothing is denied in
+// audit or disabled modes. The RequestIDHeader of the request, if any, is used as the request ID of the
+// check.
+func RequirePermission(checker *PermissionChecker, userFn func(r *http.Request) (UserInfo, error), requestFn func(r *http.Request) AccessRequest) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, err := userFn(r)
+			if err != nil {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := requestContext(r)
+			req := requestFn(r)
+			decision, err := checker.Check(ctx, user, req)
+			if err != nil {
+				http.Error(w, "error checking permissions", http.StatusInternalServerError)
+				return
+			}
+			if !decision.Allowed {
+				WriteDenialProblem(w, r.WithContext(ctx), req, decision)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
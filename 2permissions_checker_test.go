@@ -0,0 +1,211 @@
+package business
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	alicePods    = AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}
+	aliceSecrets = AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"}
+)
+
+// aliceReadsPods allows alice to read the pods, and nothing else.
+func aliceReadsPods() *testReviews {
+	return &testReviews{allow: func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+		return user.Name == "alice" && attrs.Resource == "pods"
+	}}
+}
+
+func TestCheckerEnforceMode(t *testing.T) {
+	checker := newTestChecker(aliceReadsPods(), nil)
+	sink := &testAuditSink{}
+	checker.SetAuditSink(sink)
+	assert.Equal(t, EnforcementModeEnforce, checker.Mode())
+
+	decision, err := checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	decision, err = checker.Check(testCtx, UserInfo{Name: "alice"}, aliceSecrets)
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+
+	// Only the denial is audited
+	require.Len(t, sink.records, 1)
+	assert.Equal(t, aliceSecrets, sink.records[0].Request)
+	assert.True(t, sink.records[0].Enforced)
+	assert.Equal(t, EnforcementModeEnforce, sink.records[0].Mode)
+}
+
+func TestCheckerAuditMode(t *testing.T) {
+	checker := newTestChecker(aliceReadsPods(), nil)
+	sink := &testAuditSink{}
+	checker.SetAuditSink(sink)
+	require.NoError(t, checker.SetMode(EnforcementModeAudit))
+
+	decision, err := checker.Check(testCtx, UserInfo{Name: "alice"}, aliceSecrets)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Contains(t, decision.Reason, "audit mode, would be denied")
+
+	require.Len(t, sink.records, 1)
+	assert.False(t, sink.records[0].Enforced)
+	assert.False(t, sink.records[0].Decision.Allowed)
+	assert.Equal(t, EnforcementModeAudit, sink.records[0].Mode)
+}
+
+func TestCheckerDisabledMode(t *testing.T) {
+	reviews := aliceReadsPods()
+	checker := newTestChecker(reviews, nil)
+	sink := &testAuditSink{}
+	checker.SetAuditSink(sink)
+	require.NoError(t, checker.SetMode(EnforcementModeDisabled))
+
+	decision, err := checker.Check(testCtx, UserInfo{Name: "bob"}, aliceSecrets)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, DecisionSourceEnforcementDisabled, decision.Source)
+	assert.Zero(t, reviews.calls.Load())
+	assert.Empty(t, sink.records)
+}
+
+func TestCheckerSetModeRejectsUnknownModes(t *testing.T) {
+	checker := newTestChecker(aliceReadsPods(), nil)
+
+	assert.Error(t, checker.SetMode("permissive"))
+	assert.Equal(t, EnforcementModeEnforce, checker.Mode())
+}
+
+func TestCheckerCachesTheDecisions(t *testing.T) {
+	reviews := aliceReadsPods()
+	checker := newTestChecker(reviews, nil)
+
+	for i := 0; i < 3; i++ {
+		_, err := checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+		require.NoError(t, err)
+	}
+	decision, err := checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+	assert.Equal(t, DecisionSourceCache, decision.Source)
+	assert.Equal(t, int64(1), reviews.calls.Load())
+
+	require.NoError(t, checker.PurgeCache(testCtx))
+	_, err = checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), reviews.calls.Load())
+}
+
+func TestCheckerDoesNotCacheTheDecisionsOfUsersWithoutTTL(t *testing.T) {
+	reviews := aliceReadsPods()
+	conf := NewPermissionsConfig()
+	conf.UserCacheTTLs = []UserCacheTTL{{Groups: []string{"break-glass"}, CacheTTL: 0}}
+	checker := newTestChecker(reviews, conf)
+
+	for i := 0; i < 2; i++ {
+		decision, err := checker.Check(testCtx, UserInfo{Name: "alice", Groups: []string{"break-glass"}}, alicePods)
+		require.NoError(t, err)
+		assert.Equal(t, DecisionSourceAPIServer, decision.Source)
+	}
+	assert.Equal(t, int64(2), reviews.calls.Load())
+}
+
+func TestRequirePermission(t *testing.T) {
+	checker := newTestChecker(aliceReadsPods(), nil)
+	handler := RequirePermission(checker,
+		func(r *http.Request) (UserInfo, error) {
+			if r.Header.Get("X-User") == "" {
+				return UserInfo{}, errTestAPIServer
+			}
+			return UserInfo{Name: r.Header.Get("X-User")}, nil
+		},
+		func(r *http.Request) AccessRequest { return alicePods },
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func(user string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/pods", nil)
+		if user != "" {
+			r.Header.Set("X-User", user)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+	assert.Equal(t, http.StatusNoContent, serve("alice").Code)
+	denied := serve("bob")
+	assert.Equal(t, http.StatusForbidden, denied.Code)
+	assert.Equal(t, ContentTypeProblemJSON, denied.Header().Get("Content-Type"))
+	assert.Equal(t, http.StatusUnauthorized, serve("").Code)
+}
+
+func TestSubjectAccessReviewForSelectors(t *testing.T) {
+	sar := subjectAccessReviewFor(UserInfo{Name: "alice"}, AccessRequest{Resource: "pods", Verb: "list", FieldSelector: "spec.nodeName=node1", LabelSelector: "app=web"})
+	assert.Equal(t, &auth_v1.FieldSelectorAttributes{RawSelector: "spec.nodeName=node1"}, sar.Spec.ResourceAttributes.FieldSelector)
+	assert.Equal(t, &auth_v1.LabelSelectorAttributes{RawSelector: "app=web"}, sar.Spec.ResourceAttributes.LabelSelector)
+
+	// Unscoped requests have no selectors, for the older apiservers
+	sar = subjectAccessReviewFor(UserInfo{Name: "alice"}, alicePods)
+	assert.Nil(t, sar.Spec.ResourceAttributes.FieldSelector)
+	assert.Nil(t, sar.Spec.ResourceAttributes.LabelSelector)
+}
+
+func TestCheckerDecidesPerSelector(t *testing.T) {
+	// alice only lists the pods of node1
+	reviews := &testReviews{allow: func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+		return attrs.FieldSelector != nil && attrs.FieldSelector.RawSelector == "spec.nodeName=node1"
+	}}
+	checker := newTestChecker(reviews, nil)
+	alice := UserInfo{Name: "alice"}
+	node1Pods := AccessRequest{Resource: "pods", Verb: "list", FieldSelector: "spec.nodeName=node1"}
+
+	decision, err := checker.Check(testCtx, alice, node1Pods)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	// The cached decision of a selector is not reused for the others
+	decision, err = checker.Check(testCtx, alice, AccessRequest{Resource: "pods", Verb: "list"})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	decision, err = checker.Check(testCtx, alice, AccessRequest{Resource: "pods", Verb: "list", FieldSelector: "spec.nodeName=node2"})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, int64(3), reviews.calls.Load())
+}
+
+func TestCheckerInvalidatesTheCacheSlices(t *testing.T) {
+	reviews := &testReviews{allow: allowUsers("alice", "bob")}
+	checker := newTestChecker(reviews, nil)
+	alice, bob := UserInfo{Name: "alice"}, UserInfo{Name: "bob"}
+	ns2Pods := AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "get"}
+	check := func(user UserInfo, req AccessRequest) string {
+		decision, err := checker.Check(testCtx, user, req)
+		require.NoError(t, err)
+		return decision.Source
+	}
+	for _, user := range []UserInfo{alice, bob} {
+		check(user, alicePods)
+		check(user, ns2Pods)
+	}
+
+	// Only the decisions of alice in ns1 are dropped
+	require.NoError(t, checker.InvalidateCache(testCtx, []CacheSlice{{User: "alice", Namespace: "ns1"}}))
+	assert.Equal(t, DecisionSourceAPIServer, check(alice, alicePods))
+	assert.Equal(t, DecisionSourceCache, check(alice, ns2Pods))
+	assert.Equal(t, DecisionSourceCache, check(bob, alicePods))
+
+	// A namespace slice drops the decisions of every user there
+	require.NoError(t, checker.InvalidateCache(testCtx, []CacheSlice{{Namespace: "ns2"}}))
+	assert.Equal(t, DecisionSourceAPIServer, check(alice, ns2Pods))
+	assert.Equal(t, DecisionSourceAPIServer, check(bob, ns2Pods))
+	assert.Equal(t, DecisionSourceCache, check(bob, alicePods))
+
+	// Nil slices drop everything
+	require.NoError(t, checker.InvalidateCache(testCtx, nil))
+	assert.Equal(t, DecisionSourceAPIServer, check(bob, alicePods))
+}
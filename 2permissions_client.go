@@ -0,0 +1,267 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	kube "k8s.io/client-go/kubernetes"
+
+	"github.com/kiali/kiali/kubernetes"
+)
+
+// PermissionsClient is the minimal set of cluster operations needed by the permission
+// helpers of this package. It is intentionally much smaller than Kiali's kubernetes.ClientInterface
+// so that the permission logic can be reused outside of Kiali. Use NewKialiPermissionsClient or
+// NewPermissionsClient to obtain an implementation.
+type PermissionsClient interface {
+	// GetSelfSubjectAccessReview checks if the identity of the client can perform the given verbs
+	// on the given resource type. One review is returned for each verb, in the same order.
+	GetSelfSubjectAccessReview(ctx context.Context, namespace, api, resourceType string, verbs []string) ([]*auth_v1.SelfSubjectAccessReview, error)
+	// CreateSubjectAccessReview checks if an arbitrary user can perform the action described in the review.
+	CreateSubjectAccessReview(ctx context.Context, sar *auth_v1.SubjectAccessReview) (*auth_v1.SubjectAccessReview, error)
+	// GetSelfSubjectRulesReview lists the rules the identity of the client has in the namespace.
+	GetSelfSubjectRulesReview(ctx context.Context, namespace string) (*auth_v1.SelfSubjectRulesReview, error)
+
+	// RBAC read access, used for local evaluation and reporting.
+	GetClusterRole(ctx context.Context, name string) (*rbac_v1.ClusterRole, error)
+	ListClusterRoles(ctx context.Context) ([]rbac_v1.ClusterRole, error)
+	ListClusterRoleBindings(ctx context.Context) ([]rbac_v1.ClusterRoleBinding, error)
+	ListRoles(ctx context.Context, namespace string) ([]rbac_v1.Role, error)
+	ListRoleBindings(ctx context.Context, namespace string) ([]rbac_v1.RoleBinding, error)
+
+	// ServerPreferredResources returns the resources served by the cluster, as reported by discovery.
+	ServerPreferredResources() ([]*meta_v1.APIResourceList, error)
+	// ServerVersion is a cheap discovery call, also used to check that the apiserver is reachable.
+	ServerVersion() (*version.Info, error)
+}
+
+// identifiedClient is implemented by the PermissionsClients knowing their identity, see clientIdentity.
+type identifiedClient interface {
+	// Identity identifies the credentials of the client: clients with the same identity get the same answers.
+	Identity() string
+}
+
+// clientIdentity identifies the client in the memoized self reviews, or returns "" if the client does not
+// know its identity.
+func clientIdentity(client PermissionsClient) string {
+	if identified, ok := client.(identifiedClient); ok {
+		return identified.Identity()
+	}
+	return ""
+}
+
+// selfAccessReviewer is implemented by the PermissionsClients reviewing any action of their identity, see
+// createSelfSubjectAccessReview. It is not part of PermissionsClient, so the existing implementations of
+// PermissionsClient keep compiling.
+type selfAccessReviewer interface {
+	// CreateSelfSubjectAccessReview checks if the identity of the client can perform the action described in
+	// the review, including its subresource, object name and selectors.
+	CreateSelfSubjectAccessReview(ctx context.Context, ssar *auth_v1.SelfSubjectAccessReview) (*auth_v1.SelfSubjectAccessReview, error)
+}
+
+// createSelfSubjectAccessReview checks if the identity of the client can perform the action described in the
+// review. The clients not implementing selfAccessReviewer only review the namespace, API group, resource and
+// verb of an action, the other actions are an error rather than a review of a broader action.
+func createSelfSubjectAccessReview(ctx context.Context, client PermissionsClient, ssar *auth_v1.SelfSubjectAccessReview) (*auth_v1.SelfSubjectAccessReview, error) {
+	if reviewer, ok := client.(selfAccessReviewer); ok {
+		return reviewer.CreateSelfSubjectAccessReview(ctx, ssar)
+	}
+	attrs := ssar.Spec.ResourceAttributes
+	if attrs == nil || attrs.Subresource != "" || attrs.Name != "" || attrs.FieldSelector != nil || attrs.LabelSelector != nil {
+		return nil, errors.New("the client only reviews the namespace, API group, resource and verb of its own actions")
+	}
+	reviews, err := client.GetSelfSubjectAccessReview(ctx, attrs.Namespace, attrs.Group, attrs.Resource, []string{attrs.Verb})
+	if err != nil {
+		return nil, err
+	}
+	if len(reviews) == 0 {
+		return nil, errors.New("no access review returned")
+	}
+	return reviews[0], nil
+}
+
+// userRulesReviewer is implemented by the PermissionsClients listing the rules of any user, see
+// NewImpersonatingPermissionsClient. It is not part of PermissionsClient, so the existing implementations of
+// PermissionsClient keep compiling.
+type userRulesReviewer interface {
+	// CreateUserRulesReview lists the rules the user has in the namespace, like a SelfSubjectRulesReview of
+	// the user.
+	CreateUserRulesReview(ctx context.Context, user UserInfo, namespace string) (*auth_v1.SelfSubjectRulesReview, error)
+}
+
+// errNoUserRulesReviews is returned by createUserRulesReview for the clients not implementing userRulesReviewer.
+var errNoUserRulesReviews = errors.New("the client does not list the rules of other users")
+
+// createUserRulesReview lists the rules of the user in the namespace with the client, which must implement
+// userRulesReviewer.
+func createUserRulesReview(ctx context.Context, client PermissionsClient, user UserInfo, namespace string) (*auth_v1.SelfSubjectRulesReview, error) {
+	reviewer, ok := client.(userRulesReviewer)
+	if !ok {
+		return nil, errNoUserRulesReviews
+	}
+	return reviewer.CreateUserRulesReview(ctx, user, namespace)
+}
+
+// namespacesClient is implemented by the PermissionsClients listing the namespaces, see listClientNamespaces.
+// It is not part of PermissionsClient, so the existing implementations of PermissionsClient keep compiling.
+type namespacesClient interface {
+	// ListNamespaces lists the namespaces, for their hierarchy, see FeatureHNC.
+	ListNamespaces(ctx context.Context) ([]core_v1.Namespace, error)
+}
+
+// listClientNamespaces lists the namespaces with the client, which must implement namespacesClient.
+func listClientNamespaces(ctx context.Context, client PermissionsClient) ([]core_v1.Namespace, error) {
+	lister, ok := client.(namespacesClient)
+	if !ok {
+		return nil, errors.New("the client does not list the namespaces")
+	}
+	return lister.ListNamespaces(ctx)
+}
+
+// clientIDs numbers the plain clients, whose credentials are unknown.
+var clientIDs atomic.Int64
+
+// kubePermissionsClient implements PermissionsClient on top of a plain client-go kubernetes.Interface.
+type kubePermissionsClient struct {
+	k8s kube.Interface
+	id  string
+}
+
+// NewPermissionsClient adapts a plain client-go kubernetes.Interface to a PermissionsClient.
+func NewPermissionsClient(k8s kube.Interface) PermissionsClient {
+	return &kubePermissionsClient{k8s: k8s, id: newClientID()}
+}
+
+func newClientID() string {
+	return fmt.Sprintf("client:%d", clientIDs.Add(1))
+}
+
+// Identity is unique to the client: the credentials of a plain kubernetes.Interface are unknown.
+func (in *kubePermissionsClient) Identity() string {
+	return in.id
+}
+
+func (in *kubePermissionsClient) GetSelfSubjectAccessReview(ctx context.Context, namespace, api, resourceType string, verbs []string) ([]*auth_v1.SelfSubjectAccessReview, error) {
+	reviews := make([]*auth_v1.SelfSubjectAccessReview, 0, len(verbs))
+	for _, verb := range verbs {
+		sar := &auth_v1.SelfSubjectAccessReview{
+			Spec: auth_v1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &auth_v1.ResourceAttributes{
+					Namespace: namespace,
+					Verb:      verb,
+					Group:     api,
+					Resource:  resourceType,
+				},
+			},
+		}
+		review, err := in.k8s.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, sar, meta_v1.CreateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+	return reviews, nil
+}
+
+func (in *kubePermissionsClient) CreateSelfSubjectAccessReview(ctx context.Context, ssar *auth_v1.SelfSubjectAccessReview) (*auth_v1.SelfSubjectAccessReview, error) {
+	return in.k8s.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, ssar, meta_v1.CreateOptions{})
+}
+
+func (in *kubePermissionsClient) CreateSubjectAccessReview(ctx context.Context, sar *auth_v1.SubjectAccessReview) (*auth_v1.SubjectAccessReview, error) {
+	return in.k8s.AuthorizationV1().SubjectAccessReviews().Create(ctx, sar, meta_v1.CreateOptions{})
+}
+
+func (in *kubePermissionsClient) GetSelfSubjectRulesReview(ctx context.Context, namespace string) (*auth_v1.SelfSubjectRulesReview, error) {
+	ssrr := &auth_v1.SelfSubjectRulesReview{
+		Spec: auth_v1.SelfSubjectRulesReviewSpec{Namespace: namespace},
+	}
+	return in.k8s.AuthorizationV1().SelfSubjectRulesReviews().Create(ctx, ssrr, meta_v1.CreateOptions{})
+}
+
+func (in *kubePermissionsClient) GetClusterRole(ctx context.Context, name string) (*rbac_v1.ClusterRole, error) {
+	return in.k8s.RbacV1().ClusterRoles().Get(ctx, name, meta_v1.GetOptions{})
+}
+
+func (in *kubePermissionsClient) ListClusterRoles(ctx context.Context) ([]rbac_v1.ClusterRole, error) {
+	list, err := in.k8s.RbacV1().ClusterRoles().List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (in *kubePermissionsClient) ListClusterRoleBindings(ctx context.Context) ([]rbac_v1.ClusterRoleBinding, error) {
+	list, err := in.k8s.RbacV1().ClusterRoleBindings().List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// ListRoles lists the Roles of the namespace. An empty namespace lists the Roles of all namespaces.
+func (in *kubePermissionsClient) ListRoles(ctx context.Context, namespace string) ([]rbac_v1.Role, error) {
+	list, err := in.k8s.RbacV1().Roles(namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// ListRoleBindings lists the RoleBindings of the namespace. An empty namespace lists the RoleBindings of all namespaces.
+func (in *kubePermissionsClient) ListRoleBindings(ctx context.Context, namespace string) ([]rbac_v1.RoleBinding, error) {
+	list, err := in.k8s.RbacV1().RoleBindings(namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (in *kubePermissionsClient) ListNamespaces(ctx context.Context) ([]core_v1.Namespace, error) {
+	list, err := in.k8s.CoreV1().Namespaces().List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (in *kubePermissionsClient) ServerPreferredResources() ([]*meta_v1.APIResourceList, error) {
+	return in.k8s.Discovery().ServerPreferredResources()
+}
+
+func (in *kubePermissionsClient) ServerVersion() (*version.Info, error) {
+	return in.k8s.Discovery().ServerVersion()
+}
+
+// kialiPermissionsClient implements PermissionsClient on top of Kiali's kubernetes.ClientInterface.
+// Access reviews go through the Kiali client, everything else through its underlying kubernetes.Interface.
+type kialiPermissionsClient struct {
+	kubePermissionsClient
+	client kubernetes.ClientInterface
+}
+
+// NewKialiPermissionsClient adapts Kiali's kubernetes.ClientInterface to a PermissionsClient.
+func NewKialiPermissionsClient(client kubernetes.ClientInterface) PermissionsClient {
+	return &kialiPermissionsClient{
+		kubePermissionsClient: kubePermissionsClient{k8s: client.Kube(), id: newClientID()},
+		client:                client,
+	}
+}
+
+// Identity identifies the client by its token, or is unique to the client without a token.
+func (in *kialiPermissionsClient) Identity() string {
+	if token := in.client.GetToken(); token != "" {
+		return tokenIdentity(token)
+	}
+	return in.kubePermissionsClient.Identity()
+}
+
+func (in *kialiPermissionsClient) GetSelfSubjectAccessReview(ctx context.Context, namespace, api, resourceType string, verbs []string) ([]*auth_v1.SelfSubjectAccessReview, error) {
+	return in.client.GetSelfSubjectAccessReview(ctx, namespace, api, resourceType, verbs)
+}
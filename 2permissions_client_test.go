@@ -0,0 +1,169 @@
+package business
+
+import (
+	"testing"
+	"time"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kube_fake "k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissionsClientReviewsEachVerbInOrder(t *testing.T) {
+	reviews := &testReviews{allow: func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+		return attrs.Verb == "get" || attrs.Verb == "watch"
+	}}
+	client := newTestClient(reviews, podReaderObjects()...)
+
+	result, err := client.GetSelfSubjectAccessReview(testCtx, "ns1", "", "pods", []string{"get", "delete", "watch"})
+	require.NoError(t, err)
+	require.Len(t, result, 3)
+	for i, verb := range []string{"get", "delete", "watch"} {
+		assert.Equal(t, verb, result[i].Spec.ResourceAttributes.Verb)
+		assert.Equal(t, "ns1", result[i].Spec.ResourceAttributes.Namespace)
+		assert.Equal(t, "pods", result[i].Spec.ResourceAttributes.Resource)
+	}
+	assert.True(t, result[0].Status.Allowed)
+	assert.False(t, result[1].Status.Allowed)
+	assert.True(t, result[2].Status.Allowed)
+}
+
+func TestPermissionsClientReviewErrors(t *testing.T) {
+	client := newTestClient(&testReviews{err: errTestAPIServer})
+
+	_, err := client.GetSelfSubjectAccessReview(testCtx, "ns1", "", "pods", []string{"get"})
+	assert.Error(t, err)
+}
+
+func TestSelfReviewsOfClientsWithoutSelfAccessReviewer(t *testing.T) {
+	reviews := &testReviews{allow: func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+		return attrs.Resource == "pods" && attrs.Subresource == ""
+	}}
+	// Only the methods of PermissionsClient, like the implementations predating selfAccessReviewer
+	client := struct{ PermissionsClient }{newTestClient(reviews)}
+	memo := NewSelfReviewMemo(time.Hour)
+
+	decision, err := memo.Review(testCtx, client, "", "", AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"})
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+
+	// A review of the pods would not answer for their logs
+	_, err = memo.Review(testCtx, client, "", "", AccessRequest{Namespace: "ns1", Resource: "pods", Subresource: "log", Verb: "get"})
+	assert.ErrorContains(t, err, "only reviews the namespace, API group, resource and verb")
+	assert.Equal(t, int64(1), reviews.calls.Load())
+}
+
+func TestPermissionsClientListsRBAC(t *testing.T) {
+	client := newTestClient(&testReviews{}, podReaderObjects()...)
+
+	clusterRoles, err := client.ListClusterRoles(testCtx)
+	require.NoError(t, err)
+	assert.Len(t, clusterRoles, 1)
+
+	clusterRole, err := client.GetClusterRole(testCtx, "pod-reader")
+	require.NoError(t, err)
+	assert.Equal(t, "pod-reader", clusterRole.Name)
+
+	crbs, err := client.ListClusterRoleBindings(testCtx)
+	require.NoError(t, err)
+	assert.Len(t, crbs, 1)
+
+	roles, err := client.ListRoles(testCtx, "ns1")
+	require.NoError(t, err)
+	assert.Len(t, roles, 1)
+
+	rbs, err := client.ListRoleBindings(testCtx, "")
+	require.NoError(t, err)
+	assert.Len(t, rbs, 2)
+	rbs, err = client.ListRoleBindings(testCtx, "ns2")
+	require.NoError(t, err)
+	assert.Empty(t, rbs)
+}
+
+func TestCheckUserPermissions(t *testing.T) {
+	reviews := &testReviews{allow: func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+		return attrs.Resource == "pods" && attrs.Verb == "get"
+	}}
+	client := newTestClient(reviews)
+	const username = "check-user-permissions"
+	t.Cleanup(func() { ClearUserPermissions(username) })
+
+	allowed, err := CheckUserPermissions(testCtx, client, "", username, "pods", "get")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, err = CheckUserPermissions(testCtx, client, "", username, "pods", "delete")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, int64(2), reviews.calls.Load())
+}
+
+func TestCheckUserPermissionsUsesTheCachedPermissions(t *testing.T) {
+	reviews := &testReviews{}
+	client := newTestClient(reviews)
+	const username = "cached-user-permissions"
+	t.Cleanup(func() { ClearUserPermissions(username) })
+
+	CacheUserPermissions(username, &ResourcePermissions{
+		ResourcePermissions: map[string][]string{"pods": {"get", "list"}},
+		LastChecked:         time.Now(),
+	})
+	decision, err := CheckUserPermissionsDecision(testCtx, client, "", username, "pods", "list")
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, DecisionSourceCache, decision.Source)
+	decision, err = CheckUserPermissionsDecision(testCtx, client, "", username, "services", "list")
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Zero(t, reviews.calls.Load())
+
+	// Stale permissions are checked again
+	CacheUserPermissions(username, &ResourcePermissions{
+		ResourcePermissions: map[string][]string{"pods": {"get"}},
+		LastChecked:         time.Now().Add(-time.Hour),
+	})
+	decision, err = CheckUserPermissionsDecision(testCtx, client, "", username, "pods", "get")
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, DecisionSourceAPIServer, decision.Source)
+	assert.Equal(t, int64(1), reviews.calls.Load())
+
+	ClearUserPermissions(username)
+	assert.Nil(t, GetUserPermissions(username))
+}
+
+func TestCheckUserPermissionsDecisionKeepsTheReview(t *testing.T) {
+	k8s := kube_fake.NewSimpleClientset()
+	k8s.PrependReactor("create", "selfsubjectaccessreviews", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		ssar := action.(k8s_testing.CreateAction).GetObject().(*auth_v1.SelfSubjectAccessReview).DeepCopy()
+		ssar.Status = auth_v1.SubjectAccessReviewStatus{Denied: true, Reason: "denied by policy", EvaluationError: "webhook timeout"}
+		return true, ssar, nil
+	})
+	const username = "decision-review"
+	t.Cleanup(func() { ClearUserPermissions(username) })
+
+	decision, err := CheckUserPermissionsDecision(testCtx, NewPermissionsClient(k8s), "", username, "secrets", "get")
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.True(t, decision.Denied)
+	assert.Equal(t, "denied by policy", decision.Reason)
+	assert.Equal(t, "webhook timeout", decision.EvaluationError)
+	assert.Equal(t, DecisionSourceAPIServer, decision.Source)
+	assert.False(t, decision.Timestamp.IsZero())
+}
+
+func TestCheckUserPermissionsDecisionErrors(t *testing.T) {
+	const username = "decision-error"
+	t.Cleanup(func() { ClearUserPermissions(username) })
+
+	decision, err := CheckUserPermissionsDecision(WithRequestID(testCtx, "req-1"), newTestClient(&testReviews{err: errTestAPIServer}), "", username, "pods", "get")
+	require.Error(t, err)
+	assert.ErrorIs(t, err, errTestAPIServer)
+	assert.Contains(t, err.Error(), "req-1")
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, DecisionSourceAPIServer, decision.Source)
+	assert.Contains(t, decision.EvaluationError, errTestAPIServer.Error())
+}
@@ -0,0 +1,516 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/kiali/kiali/log"
+)
+
+// PermissionsConfigEnvPrefix is the prefix of the environment variables overriding the permissions config.
+const PermissionsConfigEnvPrefix = "PERMISSIONS_"
+
+// Cache backends
+const (
+	CacheBackendMemory = "memory"
+	CacheBackendRedis  = "redis"
+)
+
+// PermissionsConfig holds the tunables of the permission subsystem.
+type PermissionsConfig struct {
+	Mode EnforcementMode `yaml:"mode"`
+	// FailurePolicy is applied when a request cannot be evaluated, see the FailurePolicy type for the
+	// errors reaching it. Empty means FailurePolicyError.
+	FailurePolicy FailurePolicy `yaml:"failure_policy"`
+	// CacheTTL is how long decisions are cached. Zero disables caching.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// CacheMaxEntries bounds the decisions of the memory cache backend, the least recently used being
+	// evicted. Zero means DefaultCacheMaxEntries, a negative value no bound. See MeasureCacheFootprint.
+	CacheMaxEntries int `yaml:"cache_max_entries"`
+	// CacheBackend is either "memory" or "redis".
+	CacheBackend string `yaml:"cache_backend"`
+	RedisAddress string `yaml:"redis_address"`
+	// CacheKeyPrefix separates the keys of the checkers sharing a Redis server, e.g. one per tenant.
+	CacheKeyPrefix string `yaml:"cache_key_prefix"`
+	// ExcludedGroups are ignored when checking permissions, e.g. system:authenticated.
+	ExcludedGroups []string `yaml:"excluded_groups"`
+	// GroupCacheTTL is how long resolved group memberships are cached, independently of the decisions.
+	// Zero means DefaultGroupCacheTTL. See PermissionChecker.CacheGroupMemberships.
+	GroupCacheTTL time.Duration `yaml:"group_cache_ttl"`
+	// SensitivityTiers override the cache TTL of sensitive resources.
+	SensitivityTiers []SensitivityTier `yaml:"sensitivity_tiers"`
+	// UserCacheTTLs shorten the cache TTL of some users or groups, e.g. no caching for break-glass
+	// admins. See BindingCacheTTLs to read them from annotated bindings.
+	UserCacheTTLs []UserCacheTTL `yaml:"user_cache_ttls"`
+	// ClientMetrics enables the Prometheus metrics of the apiserver calls, see RegisterClientMetrics.
+	ClientMetrics bool `yaml:"client_metrics"`
+	// Authorizers is the chain of authorizers asked in order, by registered name. Empty means a
+	// single subjectaccessreview authorizer.
+	Authorizers []AuthorizerConfig `yaml:"authorizers"`
+	// ChainMode is how the decisions of the authorizers are combined: one of the ChainMode constants.
+	// Empty means ChainModeFirstMatch, like the apiserver.
+	ChainMode string `yaml:"chain_mode"`
+	// ChainQuorum is the number of allows needed in ChainModeQuorum, between 1 and the number of authorizers.
+	ChainQuorum int `yaml:"chain_quorum"`
+	// WarmUpRequests are the requests pre-resolved by PermissionChecker.WarmUp, e.g. the ones checked by
+	// the landing page of the UI. They can be given in the compact form, see AccessRequests. Empty by
+	// default: the warm-up does nothing until they are set.
+	WarmUpRequests AccessRequests `yaml:"warm_up_requests"`
+	// WarmUpParallelism bounds the concurrent checks of a warm-up. Zero means DefaultWarmUpParallelism.
+	WarmUpParallelism int `yaml:"warm_up_parallelism"`
+	// MaxConcurrentAPIRequests bounds the apiserver requests made concurrently by the reviews of the checks
+	// of the checker and all its fan-out operations together, whatever their own parallelism. Under load, the slots go
+	// to the interactive requests first, see WithPriority. Zero means DefaultMaxConcurrentAPIRequests.
+	MaxConcurrentAPIRequests int `yaml:"max_concurrent_api_requests"`
+	// VerificationSampleRate is the fraction of the checks, between 0 and 1, also evaluated locally to
+	// detect discrepancies with the authorizers. See PermissionChecker.SetVerificationSource. It needs the
+	// FeatureLocalEvaluator feature gate.
+	VerificationSampleRate float64 `yaml:"verification_sample_rate"`
+	// ResolutionScope restricts the resolution and reporting of permissions to some API groups and
+	// resources. Empty means everything. It is applied to the watcher of a PermissionsService, see
+	// PermissionWatcher.SetResolutionScope.
+	ResolutionScope ResolutionScope `yaml:"resolution_scope"`
+	// Overlay is a policy layer applied on top of the authorizers, e.g. for default-deny namespaces.
+	Overlay OverlayPolicy `yaml:"overlay"`
+	// BreakGlassGrants are the pre-approved elevated accesses, see NewBreakGlassManager.
+	BreakGlassGrants []BreakGlassGrant `yaml:"break_glass_grants"`
+	// BoundaryPolicies forbid risky actions whatever RBAC grants. See BuildValidatingAdmissionPolicies
+	// to enforce them in the apiserver.
+	BoundaryPolicies []BoundaryPolicy `yaml:"boundary_policies"`
+	// AuditSampling selects the checks recorded by the audit sink, unless the checker has its own
+	// AuditSampler. The default records the denials only.
+	AuditSampling AuditSamplingPolicy `yaml:"audit_sampling"`
+	// IdentityMapping maps the identities of the cluster to the canonical ones of a multi-cluster setup.
+	// See TenantRegistry.CheckAll.
+	IdentityMapping IdentityMapping `yaml:"identity_mapping"`
+	// Redaction removes the user names and the resource names from the logs, audit records and exports.
+	Redaction RedactionPolicy `yaml:"redaction"`
+	// FeatureGates enable the experimental subsystems, see FeatureGates.
+	FeatureGates FeatureGates `yaml:"feature_gates"`
+	// ConsumerBudgets are the soft quotas of the apiserver calls of the consumers of the checks, see
+	// WithConsumer.
+	ConsumerBudgets []ConsumerBudget `yaml:"consumer_budgets"`
+	// IdentityProviders authenticate the callers of the PermissionsServer, tried in order. Empty means the
+	// x509 and tokenreview providers. See BuildAuthenticator.
+	IdentityProviders []IdentityProviderConfig `yaml:"identity_providers"`
+	// Retention bounds the history and the audit records kept by the stores, see RunRetention.
+	Retention RetentionPolicy `yaml:"retention"`
+}
+
+// AuthorizerConfig selects a registered authorizer and configures it.
+type AuthorizerConfig struct {
+	Name string `yaml:"name"`
+	// Options are passed to the factory of the authorizer.
+	Options map[string]string `yaml:"options"`
+	// Timeout bounds each call to the authorizer. Zero means no timeout.
+	Timeout time.Duration `yaml:"timeout"`
+	// OnError is what to do when the authorizer fails or times out: one of the AuthorizerOnError
+	// constants. Empty means AuthorizerOnErrorFail, which leaves the error to the FailurePolicy; deny and
+	// skip handle it in the chain instead.
+	OnError string `yaml:"on_error"`
+}
+
+// SensitivityTier groups resources deserving a specific treatment, like a shorter cache TTL.
+type SensitivityTier struct {
+	Name string `yaml:"name"`
+	// Resources are in group/resource form, or just the resource for the core group (e.g. secrets, rbac.authorization.k8s.io/roles).
+	Resources []string      `yaml:"resources"`
+	CacheTTL  time.Duration `yaml:"cache_ttl"`
+}
+
+// CacheTTLAnnotation sets, on a RoleBinding or ClusterRoleBinding, the cache TTL of the decisions of its
+// subjects, as a duration, e.g. "0s" for break-glass bindings. See BindingCacheTTLs.
+const CacheTTLAnnotation = "permissions.kiali.io/cache-ttl"
+
+// UserCacheTTL overrides the cache TTL of the decisions of some users, or of the members of some groups.
+type UserCacheTTL struct {
+	Users  []string `yaml:"users"`
+	Groups []string `yaml:"groups"`
+	// Namespaces restricts the override to the requests in these namespaces. Empty means all the requests.
+	Namespaces []string      `yaml:"namespaces,omitempty"`
+	CacheTTL   time.Duration `yaml:"cache_ttl"`
+}
+
+func (in UserCacheTTL) matches(user UserInfo) bool {
+	if containsString(in.Users, user.Name) {
+		return true
+	}
+	for _, group := range user.Groups {
+		if containsString(in.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// appliesTo returns true if the override applies to the request of the user.
+func (in UserCacheTTL) appliesTo(user UserInfo, req AccessRequest) bool {
+	return (len(in.Namespaces) == 0 || containsString(in.Namespaces, req.Namespace)) && in.matches(user)
+}
+
+// BindingCacheTTLs returns the TTL overrides set by the CacheTTLAnnotation of the bindings of the snapshot,
+// to be appended to the UserCacheTTLs of the config. The overrides of the RoleBindings only apply in their
+// namespace. Invalid annotations are logged and ignored.
+func BindingCacheTTLs(snapshot *RBACSnapshot) []UserCacheTTL {
+	overrides := []UserCacheTTL{}
+	add := func(kind, namespace, name string, annotations map[string]string, subjects []rbac_v1.Subject) {
+		value, ok := annotations[CacheTTLAnnotation]
+		if !ok {
+			return
+		}
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			log.Warningf("Ignoring invalid %s annotation of %s %s/%s: %v", CacheTTLAnnotation, kind, namespace, name, err)
+			return
+		}
+		override := UserCacheTTL{Users: []string{}, Groups: []string{}, CacheTTL: ttl}
+		if namespace != "" {
+			override.Namespaces = []string{namespace}
+		}
+		for _, subject := range subjects {
+			if subject.Kind == rbac_v1.GroupKind {
+				override.Groups = append(override.Groups, subject.Name)
+			} else if username := subjectUsername(subject, namespace); username != "" {
+				override.Users = append(override.Users, username)
+			}
+		}
+		overrides = append(overrides, override)
+	}
+	for _, crb := range snapshot.ClusterRoleBindings {
+		add("ClusterRoleBinding", "", crb.Name, crb.Annotations, crb.Subjects)
+	}
+	for _, rb := range snapshot.RoleBindings {
+		add("RoleBinding", rb.Namespace, rb.Name, rb.Annotations, rb.Subjects)
+	}
+	return overrides
+}
+
+// NewPermissionsConfig returns the default config: enforce mode and a 5 minutes in-memory cache.
+func NewPermissionsConfig() *PermissionsConfig {
+	return &PermissionsConfig{
+		Mode:         EnforcementModeEnforce,
+		CacheTTL:     5 * time.Minute,
+		CacheBackend: CacheBackendMemory,
+	}
+}
+
+// LoadPermissionsConfig reads the config from the YAML file at path, if not empty, and then applies
+// the PERMISSIONS_* environment variables. Settings absent from both keep their default value.
+func LoadPermissionsConfig(path string) (*PermissionsConfig, error) {
+	conf := NewPermissionsConfig()
+
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read permissions config file %s: %w", path, err)
+		}
+		if err := yaml.Unmarshal(content, conf); err != nil {
+			return nil, fmt.Errorf("failed to parse permissions config file %s: %w", path, err)
+		}
+	}
+
+	if err := conf.applyEnv(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// applyEnv overrides the config with the PERMISSIONS_* environment variables.
+func (in *PermissionsConfig) applyEnv() error {
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "MODE"); ok {
+		in.Mode = EnforcementMode(v)
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "FAILURE_POLICY"); ok {
+		in.FailurePolicy = FailurePolicy(v)
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "CACHE_TTL"); ok {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid %sCACHE_TTL: %w", PermissionsConfigEnvPrefix, err)
+		}
+		in.CacheTTL = ttl
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "GROUP_CACHE_TTL"); ok {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid %sGROUP_CACHE_TTL: %w", PermissionsConfigEnvPrefix, err)
+		}
+		in.GroupCacheTTL = ttl
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "CACHE_MAX_ENTRIES"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid %sCACHE_MAX_ENTRIES: %w", PermissionsConfigEnvPrefix, err)
+		}
+		in.CacheMaxEntries = limit
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "CACHE_BACKEND"); ok {
+		in.CacheBackend = v
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "REDIS_ADDRESS"); ok {
+		in.RedisAddress = v
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "CLIENT_METRICS"); ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %sCLIENT_METRICS: %w", PermissionsConfigEnvPrefix, err)
+		}
+		in.ClientMetrics = enabled
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "MAX_CONCURRENT_API_REQUESTS"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid %sMAX_CONCURRENT_API_REQUESTS: %w", PermissionsConfigEnvPrefix, err)
+		}
+		in.MaxConcurrentAPIRequests = limit
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "AUDIT_ALLOW_SAMPLE_RATE"); ok {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %sAUDIT_ALLOW_SAMPLE_RATE: %w", PermissionsConfigEnvPrefix, err)
+		}
+		in.AuditSampling.AllowSampleRate = rate
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "REDACTION_HASH_USERNAMES"); ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %sREDACTION_HASH_USERNAMES: %w", PermissionsConfigEnvPrefix, err)
+		}
+		in.Redaction.HashUsernames = enabled
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "REDACTION_HASH_KEY"); ok {
+		in.Redaction.HashKey = v
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "REDACTION_OMIT_RESOURCE_NAMES"); ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %sREDACTION_OMIT_RESOURCE_NAMES: %w", PermissionsConfigEnvPrefix, err)
+		}
+		in.Redaction.OmitResourceNames = enabled
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "FEATURE_GATES"); ok {
+		gates, err := ParseFeatureGates(v)
+		if err != nil {
+			return fmt.Errorf("invalid %sFEATURE_GATES: %w", PermissionsConfigEnvPrefix, err)
+		}
+		merged := FeatureGates{}
+		for feature, enabled := range in.FeatureGates {
+			merged[feature] = enabled
+		}
+		for feature, enabled := range gates {
+			merged[feature] = enabled
+		}
+		in.FeatureGates = merged
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "RETENTION_MAX_AGE"); ok {
+		age, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid %sRETENTION_MAX_AGE: %w", PermissionsConfigEnvPrefix, err)
+		}
+		in.Retention.MaxAge = age
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "RETENTION_MAX_ENTRIES"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid %sRETENTION_MAX_ENTRIES: %w", PermissionsConfigEnvPrefix, err)
+		}
+		in.Retention.MaxEntries = limit
+	}
+	if v, ok := os.LookupEnv(PermissionsConfigEnvPrefix + "EXCLUDED_GROUPS"); ok {
+		in.ExcludedGroups = []string{}
+		for _, group := range strings.Split(v, ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				in.ExcludedGroups = append(in.ExcludedGroups, group)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks the settings of the config, and the settings contradicting each other, e.g. a Redis
+// cache backend without address. Every problem is reported, one per line, prefixed by its settings.
+// It is called by PermissionChecker.ApplyConfig.
+func (in *PermissionsConfig) Validate() error {
+	errs := []error{}
+	invalid := func(setting string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", setting, err))
+		}
+	}
+
+	invalid("mode", validateEnforcementMode(in.Mode))
+	invalid("failure_policy", validateFailurePolicy(in.FailurePolicy))
+	invalid("identity_mapping", in.IdentityMapping.validate())
+	invalid("audit_sampling", in.AuditSampling.validate())
+	invalid("feature_gates", in.FeatureGates.validate())
+	invalid("retention", in.Retention.validate())
+	invalid("consumer_budgets", validateConsumerBudgets(in.ConsumerBudgets))
+	invalid("overlay", in.Overlay.validate())
+	for _, provider := range in.IdentityProviders {
+		if !containsString(RegisteredIdentityProviders(), provider.Name) {
+			invalid("identity_providers", fmt.Errorf("unknown identity provider %q, expected one of %s", provider.Name, strings.Join(RegisteredIdentityProviders(), ", ")))
+		}
+	}
+	if in.Mode == EnforcementModeDisabled && in.FailurePolicy == FailurePolicyOpen {
+		invalid("mode, failure_policy", fmt.Errorf("the %s failure policy has no effect when enforcement is %s, nothing is denied", FailurePolicyOpen, EnforcementModeDisabled))
+	}
+
+	if in.CacheTTL < 0 {
+		invalid("cache_ttl", fmt.Errorf("negative TTL %s", in.CacheTTL))
+	}
+	if in.GroupCacheTTL < 0 {
+		invalid("group_cache_ttl", fmt.Errorf("negative TTL %s", in.GroupCacheTTL))
+	}
+	if in.CacheTTL == 0 && len(in.WarmUpRequests) > 0 {
+		invalid("cache_ttl, warm_up_requests", errors.New("the warm-up requests are never cached when caching is disabled"))
+	}
+	switch in.CacheBackend {
+	case "", CacheBackendMemory:
+		if in.RedisAddress != "" {
+			invalid("cache_backend, redis_address", fmt.Errorf("the Redis address is ignored by the %s backend", CacheBackendMemory))
+		}
+	case CacheBackendRedis:
+		if in.RedisAddress == "" {
+			invalid("cache_backend, redis_address", fmt.Errorf("the %s backend needs an address", CacheBackendRedis))
+		}
+		if in.CacheMaxEntries != 0 {
+			invalid("cache_backend, cache_max_entries", fmt.Errorf("the entries of the %s backend are bounded by its own eviction policy", CacheBackendRedis))
+		}
+	default:
+		invalid("cache_backend", fmt.Errorf("unknown cache backend %q, expected %s or %s", in.CacheBackend, CacheBackendMemory, CacheBackendRedis))
+	}
+
+	switch in.ChainMode {
+	case "", ChainModeFirstMatch, ChainModeFirstAllow, ChainModeDenyOverrides:
+		if in.ChainQuorum != 0 {
+			invalid("chain_mode, chain_quorum", fmt.Errorf("the quorum is only used by the %s chain mode", ChainModeQuorum))
+		}
+	case ChainModeQuorum:
+		if in.ChainQuorum < 1 || in.ChainQuorum > max(len(in.Authorizers), 1) {
+			invalid("chain_quorum", fmt.Errorf("quorum %d cannot be reached by %d authorizers", in.ChainQuorum, max(len(in.Authorizers), 1)))
+		}
+	default:
+		invalid("chain_mode", fmt.Errorf("unknown authorizer chain mode %q", in.ChainMode))
+	}
+	for _, authorizer := range in.Authorizers {
+		if authorizer.Name == AuthorizerCEL && !in.FeatureGates.Enabled(FeatureCELAuthorizer) {
+			invalid("authorizers, feature_gates", fmt.Errorf("the %s authorizer needs the %s feature gate", AuthorizerCEL, FeatureCELAuthorizer))
+		}
+	}
+
+	if in.VerificationSampleRate < 0 || in.VerificationSampleRate > 1 {
+		invalid("verification_sample_rate", fmt.Errorf("invalid rate %v, expected a value between 0 and 1", in.VerificationSampleRate))
+	}
+	if in.VerificationSampleRate > 0 && !in.FeatureGates.Enabled(FeatureLocalEvaluator) {
+		invalid("verification_sample_rate, feature_gates", fmt.Errorf("the decisions are only verified with the %s feature gate", FeatureLocalEvaluator))
+	}
+	if in.Redaction.HashUsernames && in.Redaction.HashKey == "" {
+		invalid("redaction", errors.New("the user name hashes can be reversed by hashing known user names without a hash key"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid permissions config:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// cacheMaxEntries returns the bound of the memory decision cache, zero for no bound.
+func (in *PermissionsConfig) cacheMaxEntries() int {
+	switch {
+	case in.CacheMaxEntries < 0:
+		return 0
+	case in.CacheMaxEntries == 0:
+		return DefaultCacheMaxEntries
+	default:
+		return in.CacheMaxEntries
+	}
+}
+
+// cacheTTLFor returns the cache TTL of the request of the user, taking the sensitivity tiers and the
+// user overrides into account. User overrides only shorten the TTL of the resource, so they never extend
+// the caching of sensitive resources. When several apply, the shortest wins. The user must have all its
+// groups, including the excluded ones, so the overrides of the excluded groups apply.
+func (in *PermissionsConfig) cacheTTLFor(user UserInfo, req AccessRequest) time.Duration {
+	resource := req.Resource
+	if req.APIGroup != "" {
+		resource = req.APIGroup + "/" + req.Resource
+	}
+	ttl := in.CacheTTL
+	for _, tier := range in.SensitivityTiers {
+		if containsString(tier.Resources, resource) {
+			ttl = tier.CacheTTL
+			break
+		}
+	}
+	for _, override := range in.UserCacheTTLs {
+		if override.CacheTTL < ttl && override.appliesTo(user, req) {
+			ttl = override.CacheTTL
+		}
+	}
+	return ttl
+}
+
+// WatchPermissionsConfig reloads the config file when it changes and passes the new config to onChange,
+// until the context is cancelled. The directory of the file is watched, so the atomic symlink swaps
+// done by Kubernetes when a mounted ConfigMap is updated are detected; the events of the other files of the
+// directory are ignored. Invalid configs are logged and ignored.
+func WatchPermissionsConfig(ctx context.Context, path string, onChange func(*PermissionsConfig)) error {
+	path = filepath.Clean(path)
+	// realPath is the file the config path links to, which changes with the symlink swaps
+	realPath, _ := filepath.EvalSymlinks(path)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create permissions config watcher: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch permissions config file %s: %w", path, err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Chmod) {
+					continue
+				}
+				currentPath, _ := filepath.EvalSymlinks(path)
+				if filepath.Clean(event.Name) != path && currentPath == realPath {
+					continue
+				}
+				realPath = currentPath
+				conf, err := LoadPermissionsConfig(path)
+				if err != nil {
+					log.Errorf("Ignoring invalid permissions config: %v", err)
+					continue
+				}
+				log.Infof("Permissions config reloaded from %s", path)
+				onChange(conf)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("Error watching permissions config: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
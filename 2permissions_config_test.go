@@ -0,0 +1,324 @@
+package business
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestConfig writes the YAML config to a file of a temporary directory, returning its path.
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "permissions.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadPermissionsConfigDefaults(t *testing.T) {
+	conf, err := LoadPermissionsConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, NewPermissionsConfig(), conf)
+}
+
+func TestLoadPermissionsConfigFromYAML(t *testing.T) {
+	path := writeTestConfig(t, `
+mode: audit
+cache_ttl: 30s
+excluded_groups: [system:authenticated]
+sensitivity_tiers:
+- name: secrets
+  resources: [secrets]
+  cache_ttl: 5s
+`)
+
+	conf, err := LoadPermissionsConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, EnforcementModeAudit, conf.Mode)
+	assert.Equal(t, 30*time.Second, conf.CacheTTL)
+	assert.Equal(t, CacheBackendMemory, conf.CacheBackend)
+	assert.Equal(t, []string{"system:authenticated"}, conf.ExcludedGroups)
+	require.Len(t, conf.SensitivityTiers, 1)
+	assert.Equal(t, 5*time.Second, conf.SensitivityTiers[0].CacheTTL)
+}
+
+func TestLoadPermissionsConfigEnvOverridesYAML(t *testing.T) {
+	path := writeTestConfig(t, "mode: audit\ncache_ttl: 30s\n")
+	t.Setenv(PermissionsConfigEnvPrefix+"MODE", "disabled")
+	t.Setenv(PermissionsConfigEnvPrefix+"CACHE_BACKEND", CacheBackendRedis)
+	t.Setenv(PermissionsConfigEnvPrefix+"REDIS_ADDRESS", "redis:6379")
+	t.Setenv(PermissionsConfigEnvPrefix+"EXCLUDED_GROUPS", "system:authenticated, ,system:masters")
+	t.Setenv(PermissionsConfigEnvPrefix+"MAX_CONCURRENT_API_REQUESTS", "50")
+
+	conf, err := LoadPermissionsConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, EnforcementModeDisabled, conf.Mode)
+	assert.Equal(t, 30*time.Second, conf.CacheTTL)
+	assert.Equal(t, CacheBackendRedis, conf.CacheBackend)
+	assert.Equal(t, "redis:6379", conf.RedisAddress)
+	assert.Equal(t, []string{"system:authenticated", "system:masters"}, conf.ExcludedGroups)
+	assert.Equal(t, 50, conf.MaxConcurrentAPIRequests)
+}
+
+func TestLoadPermissionsConfigErrors(t *testing.T) {
+	_, err := LoadPermissionsConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+
+	_, err = LoadPermissionsConfig(writeTestConfig(t, "cache_ttl: [\n"))
+	assert.Error(t, err)
+
+	t.Setenv(PermissionsConfigEnvPrefix+"CACHE_TTL", "soon")
+	_, err = LoadPermissionsConfig("")
+	assert.ErrorContains(t, err, PermissionsConfigEnvPrefix+"CACHE_TTL")
+}
+
+func TestCacheTTLForSensitiveResources(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.SensitivityTiers = []SensitivityTier{
+		{Name: "secrets", Resources: []string{"secrets", "rbac.authorization.k8s.io/roles"}, CacheTTL: 5 * time.Second},
+	}
+	alice := UserInfo{Name: "alice"}
+
+	assert.Equal(t, 5*time.Minute, conf.cacheTTLFor(alice, AccessRequest{Resource: "pods"}))
+	assert.Equal(t, 5*time.Second, conf.cacheTTLFor(alice, AccessRequest{Resource: "secrets"}))
+	assert.Equal(t, 5*time.Second, conf.cacheTTLFor(alice, AccessRequest{APIGroup: "rbac.authorization.k8s.io", Resource: "roles"}))
+	assert.Equal(t, 5*time.Minute, conf.cacheTTLFor(alice, AccessRequest{Resource: "roles"}))
+}
+
+func TestCacheTTLForUserOverrides(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.SensitivityTiers = []SensitivityTier{{Name: "secrets", Resources: []string{"secrets"}, CacheTTL: 5 * time.Second}}
+	conf.UserCacheTTLs = []UserCacheTTL{
+		{Users: []string{"alice"}, CacheTTL: time.Minute},
+		{Groups: []string{"break-glass"}, CacheTTL: 0},
+		{Users: []string{"bob"}, CacheTTL: time.Hour},
+		{Users: []string{"dave"}, Namespaces: []string{"ns1"}, CacheTTL: time.Second},
+	}
+
+	assert.Equal(t, time.Minute, conf.cacheTTLFor(UserInfo{Name: "alice"}, AccessRequest{Resource: "pods"}))
+	// Overrides never extend the TTL of sensitive resources, nor the default one
+	assert.Equal(t, 5*time.Second, conf.cacheTTLFor(UserInfo{Name: "alice"}, AccessRequest{Resource: "secrets"}))
+	assert.Equal(t, 5*time.Minute, conf.cacheTTLFor(UserInfo{Name: "bob"}, AccessRequest{Resource: "pods"}))
+	// The shortest override wins
+	assert.Equal(t, time.Duration(0), conf.cacheTTLFor(UserInfo{Name: "alice", Groups: []string{"break-glass"}}, AccessRequest{Resource: "pods"}))
+	assert.Equal(t, 5*time.Minute, conf.cacheTTLFor(UserInfo{Name: "carol", Groups: []string{"developers"}}, AccessRequest{Resource: "pods"}))
+	// The namespaced overrides only apply in their namespaces
+	assert.Equal(t, time.Second, conf.cacheTTLFor(UserInfo{Name: "dave"}, AccessRequest{Namespace: "ns1", Resource: "pods"}))
+	assert.Equal(t, 5*time.Minute, conf.cacheTTLFor(UserInfo{Name: "dave"}, AccessRequest{Namespace: "ns2", Resource: "pods"}))
+	assert.Equal(t, 5*time.Minute, conf.cacheTTLFor(UserInfo{Name: "dave"}, AccessRequest{Resource: "nodes"}))
+}
+
+func TestCheckerAppliesTheTTLOverridesOfTheExcludedGroups(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.ExcludedGroups = []string{"break-glass"}
+	conf.UserCacheTTLs = []UserCacheTTL{{Groups: []string{"break-glass"}, CacheTTL: 0}}
+	reviews := aliceReadsPods()
+	checker := newTestChecker(reviews, conf)
+	alice := UserInfo{Name: "alice", Groups: []string{"break-glass"}}
+
+	for i := 0; i < 2; i++ {
+		decision, err := checker.Check(testCtx, alice, alicePods)
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+	}
+	// The group is excluded from the reviews, but its decisions are still not cached
+	assert.Equal(t, int64(2), reviews.calls.Load())
+}
+
+func TestLoadPermissionsConfigUserCacheTTLs(t *testing.T) {
+	conf, err := LoadPermissionsConfig(writeTestConfig(t, `
+user_cache_ttls:
+- users: [alice]
+  groups: [break-glass]
+  cache_ttl: 0s
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []UserCacheTTL{{Users: []string{"alice"}, Groups: []string{"break-glass"}}}, conf.UserCacheTTLs)
+}
+
+func TestBindingCacheTTLs(t *testing.T) {
+	breakGlass := testClusterRoleBinding("break-glass", "pod-reader", testGroup("break-glass"), testUser("alice"))
+	breakGlass.Annotations = map[string]string{CacheTTLAnnotation: "0s"}
+	ci := testRoleBinding("ns1", "ci", "ClusterRole", "pod-reader", rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Name: "deployer"})
+	ci.Annotations = map[string]string{CacheTTLAnnotation: "30s"}
+	invalid := testRoleBinding("ns1", "invalid", "ClusterRole", "pod-reader", testUser("bob"))
+	invalid.Annotations = map[string]string{CacheTTLAnnotation: "soon"}
+
+	overrides := BindingCacheTTLs(testSnapshot(append(podReaderObjects(), breakGlass, ci, invalid)...))
+	assert.ElementsMatch(t, []UserCacheTTL{
+		{Users: []string{"alice"}, Groups: []string{"break-glass"}, CacheTTL: 0},
+		{Users: []string{"system:serviceaccount:ns1:deployer"}, Groups: []string{}, Namespaces: []string{"ns1"}, CacheTTL: 30 * time.Second},
+	}, overrides)
+}
+
+func TestApplyConfigKeepsTheCacheOfTheSameBackend(t *testing.T) {
+	reviews := aliceReadsPods()
+	checker := newTestChecker(reviews, nil)
+	_, err := checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+
+	conf := NewPermissionsConfig()
+	conf.Mode = EnforcementModeAudit
+	require.NoError(t, checker.ApplyConfig(conf))
+	assert.Equal(t, EnforcementModeAudit, checker.Mode())
+	decision, err := checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+	assert.Equal(t, DecisionSourceCache, decision.Source)
+
+	// The checker keeps a copy
+	conf.Mode = EnforcementModeDisabled
+	assert.Equal(t, EnforcementModeAudit, checker.Mode())
+}
+
+func TestApplyConfigClosesTheReplacedBackend(t *testing.T) {
+	checker := newTestChecker(aliceReadsPods(), nil)
+	conf := NewPermissionsConfig()
+	conf.CacheBackend = CacheBackendRedis
+	conf.RedisAddress = "127.0.0.1:1"
+	require.NoError(t, checker.ApplyConfig(conf))
+	redisCache := checker.cache.(*redisDecisionCache)
+
+	require.NoError(t, checker.ApplyConfig(NewPermissionsConfig()))
+	assert.ErrorIs(t, redisCache.Ping(testCtx), redis.ErrClosed)
+}
+
+func TestApplyConfigConcurrentBackendChanges(t *testing.T) {
+	checker := newTestChecker(aliceReadsPods(), nil)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		conf := NewPermissionsConfig()
+		if i%2 == 0 {
+			conf.CacheBackend = CacheBackendRedis
+			conf.RedisAddress = "127.0.0.1:1"
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, checker.ApplyConfig(conf))
+		}()
+	}
+	wg.Wait()
+
+	// The cache is the backend of the last applied config
+	_, redisCache := checker.cache.(*redisDecisionCache)
+	assert.Equal(t, checker.conf.CacheBackend == CacheBackendRedis, redisCache)
+}
+
+func TestApplyConfigRejectsInvalidConfigs(t *testing.T) {
+	checker := newTestChecker(aliceReadsPods(), nil)
+	conf := NewPermissionsConfig()
+	conf.CacheBackend = "memcached"
+
+	assert.Error(t, checker.ApplyConfig(conf))
+	assert.Equal(t, EnforcementModeEnforce, checker.Mode())
+}
+
+func TestPermissionsConfigValidate(t *testing.T) {
+	require.NoError(t, NewPermissionsConfig().Validate())
+
+	conf := NewPermissionsConfig()
+	conf.Mode = EnforcementModeDisabled
+	conf.FailurePolicy = FailurePolicyOpen
+	conf.CacheTTL = 0
+	conf.WarmUpRequests = AccessRequests{{Namespace: "ns1", Resource: "pods", Verb: "get"}}
+	conf.CacheBackend = CacheBackendRedis
+	conf.ChainQuorum = 2
+	conf.Authorizers = []AuthorizerConfig{{Name: AuthorizerCEL}}
+	conf.VerificationSampleRate = 0.5
+	conf.Redaction = RedactionPolicy{HashUsernames: true}
+
+	// Every invalid or contradictory setting is reported at once
+	err := conf.Validate()
+	require.Error(t, err)
+	for _, setting := range []string{
+		"mode, failure_policy:",
+		"cache_ttl, warm_up_requests:",
+		"cache_backend, redis_address:",
+		"chain_mode, chain_quorum:",
+		"authorizers, feature_gates:",
+		"verification_sample_rate, feature_gates:",
+		"redaction:",
+	} {
+		assert.Contains(t, err.Error(), setting)
+	}
+
+	conf = NewPermissionsConfig()
+	conf.ChainMode = ChainModeQuorum
+	conf.ChainQuorum = 2
+	assert.ErrorContains(t, conf.Validate(), "quorum 2 cannot be reached by 1 authorizers")
+	conf.Authorizers = []AuthorizerConfig{{Name: AuthorizerCEL}, {Name: AuthorizerCEL}}
+	conf.FeatureGates = FeatureGates{FeatureCELAuthorizer: true}
+	assert.NoError(t, conf.Validate())
+}
+
+func TestWatchPermissionsConfigIgnoresTheOtherFiles(t *testing.T) {
+	path := writeTestConfig(t, "mode: enforce\n")
+	ctx, cancel := context.WithCancel(testCtx)
+	defer cancel()
+	reloaded := make(chan *PermissionsConfig, 10)
+	require.NoError(t, WatchPermissionsConfig(ctx, path, func(conf *PermissionsConfig) { reloaded <- conf }))
+
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(path), "other.yaml"), []byte("mode: audit\n"), 0o600))
+	select {
+	case <-reloaded:
+		assert.Fail(t, "config reloaded for another file")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestWatchPermissionsConfigOfAConfigMap(t *testing.T) {
+	// Like a mounted ConfigMap: config.yaml links to ..data/config.yaml, and ..data to the current version
+	dir := t.TempDir()
+	writeVersion := func(version, content string) {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, version), 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, version, "config.yaml"), []byte(content), 0o600))
+	}
+	writeVersion("v1", "mode: enforce\n")
+	require.NoError(t, os.Symlink("v1", filepath.Join(dir, "..data")))
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.Symlink(filepath.Join("..data", "config.yaml"), path))
+
+	ctx, cancel := context.WithCancel(testCtx)
+	defer cancel()
+	reloaded := make(chan *PermissionsConfig, 10)
+	require.NoError(t, WatchPermissionsConfig(ctx, path, func(conf *PermissionsConfig) { reloaded <- conf }))
+
+	writeVersion("v2", "mode: audit\n")
+	require.NoError(t, os.Symlink("v2", filepath.Join(dir, "..data_tmp")))
+	require.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	select {
+	case conf := <-reloaded:
+		assert.Equal(t, EnforcementModeAudit, conf.Mode)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "config not reloaded")
+	}
+}
+
+func TestWatchPermissionsConfig(t *testing.T) {
+	path := writeTestConfig(t, "mode: enforce\n")
+	ctx, cancel := context.WithCancel(testCtx)
+	defer cancel()
+	reloaded := make(chan *PermissionsConfig, 10)
+	require.NoError(t, WatchPermissionsConfig(ctx, path, func(conf *PermissionsConfig) { reloaded <- conf }))
+
+	require.NoError(t, os.WriteFile(path, []byte("mode: audit\n"), 0o600))
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case conf := <-reloaded:
+			if conf.Mode == EnforcementModeAudit {
+				return
+			}
+		case <-timeout:
+			require.FailNow(t, "config not reloaded")
+		}
+	}
+}
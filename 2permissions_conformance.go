@@ -0,0 +1,27 @@
+package business
+
+import (
+	"fmt"
+)
+
+// ConformanceCase is a permission check compared between the local RBAC evaluation and the apiserver, see
+// CompareEvaluation and permissionstest.CheckConformance.
+type ConformanceCase struct {
+	User    UserInfo
+	Request AccessRequest
+}
+
+// ConformanceMismatch is a case where the local evaluation disagrees with the apiserver.
+type ConformanceMismatch struct {
+	Case  ConformanceCase
+	Local bool
+	Live  bool
+	// Explanation is the local evaluation, naming the grants it relied on.
+	Explanation *Explanation
+}
+
+func (m ConformanceMismatch) String() string {
+	req := m.Case.Request
+	return fmt.Sprintf("user [%s] groups %v verb [%s] resource [%s/%s] subresource [%s] name [%s] namespace [%s]: local allowed=%t, apiserver allowed=%t",
+		m.Case.User.Name, m.Case.User.Groups, req.Verb, req.APIGroup, req.Resource, req.Subresource, req.Name, req.Namespace, m.Local, m.Live)
+}
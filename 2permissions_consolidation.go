@@ -0,0 +1,239 @@
+package business
+
+import (
+	"sort"
+	"strings"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+)
+
+// DefaultRoleSimilarityThreshold is the rule overlap above which two roles are considered near-duplicates.
+const DefaultRoleSimilarityThreshold = 0.9
+
+// ConsolidationProposal proposes to replace a set of near-duplicate roles of the same kind and
+// namespace with a single role granting the union of their rules.
+type ConsolidationProposal struct {
+	Kind string
+	// Namespace of the roles. Empty for ClusterRoles.
+	Namespace string
+	// Roles are the names of the near-duplicate roles.
+	Roles []string
+	// Similarity is the lowest overlap between two of the roles, in the [0, 1] range.
+	Similarity float64
+	// Consolidated is the name of the role that is kept; it is the most bound of the roles.
+	Consolidated string
+	// Rules are the rules of the consolidated role.
+	Rules []rbac_v1.PolicyRule
+	// BindingRewrites are the binding changes needed to stop using the other roles. The bindings of the
+	// consolidated role, which keep their role but gain the rules of the others, are listed too with the
+	// same From and To.
+	BindingRewrites []BindingRewrite
+}
+
+// BindingRewrite is a change of the role referenced by a binding.
+type BindingRewrite struct {
+	BindingKind string
+	BindingName string
+	Namespace   string
+	From        rbac_v1.RoleRef
+	To          rbac_v1.RoleRef
+	// AddedPermissions are the permissions the subjects of the binding gain with the consolidated role,
+	// in the namespace of the binding for RoleBindings.
+	AddedPermissions []AccessRequest
+}
+
+// consolidationCandidate is a role considered by FindRoleConsolidations.
+type consolidationCandidate struct {
+	name     string
+	atoms    map[AccessRequest]bool
+	bindings []RoleGrant
+}
+
+// FindRoleConsolidations detects near-duplicate Roles (within a namespace) and ClusterRoles whose rule
+// overlap is at least the threshold, and proposes consolidated roles to reduce RBAC sprawl.
+// The overlap of two roles is the Jaccard index of the individual permissions they grant, and every two
+// roles of a proposal overlap that much. The system:* roles, maintained by Kubernetes, and the aggregated
+// ClusterRoles, whose rules are maintained by the controller manager, are not considered.
+func (in *RBACSnapshot) FindRoleConsolidations(threshold float64) []ConsolidationProposal {
+	// kind/namespace -> candidates
+	groups := map[string][]*consolidationCandidate{}
+	candidates := map[string]*consolidationCandidate{}
+
+	for name, cr := range in.ClusterRoles {
+		if strings.HasPrefix(name, "system:") || cr.AggregationRule != nil {
+			continue
+		}
+		c := &consolidationCandidate{name: name, atoms: atomSet(cr.Rules)}
+		groups["ClusterRole/"] = append(groups["ClusterRole/"], c)
+		candidates["ClusterRole//"+name] = c
+	}
+	for _, r := range in.Roles {
+		if strings.HasPrefix(r.Name, "system:") {
+			continue
+		}
+		c := &consolidationCandidate{name: r.Name, atoms: atomSet(r.Rules)}
+		groups["Role/"+r.Namespace] = append(groups["Role/"+r.Namespace], c)
+		candidates["Role/"+r.Namespace+"/"+r.Name] = c
+	}
+
+	// Bindings are deduplicated, since Grants returns one entry per subject
+	seen := map[string]bool{}
+	for _, grant := range in.Grants() {
+		bindingKey := grant.BindingKind + "/" + grant.Namespace + "/" + grant.BindingName
+		if seen[bindingKey] {
+			continue
+		}
+		seen[bindingKey] = true
+		roleNamespace := ""
+		if grant.RoleRef.Kind == "Role" {
+			roleNamespace = grant.Namespace
+		}
+		if c, ok := candidates[grant.RoleRef.Kind+"/"+roleNamespace+"/"+grant.RoleRef.Name]; ok {
+			c.bindings = append(c.bindings, grant)
+		}
+	}
+
+	proposals := []ConsolidationProposal{}
+	for key, members := range groups {
+		parts := strings.SplitN(key, "/", 2)
+		sort.Slice(members, func(i, j int) bool { return members[i].name < members[j].name })
+		for _, cluster := range clusterSimilarRoles(members, threshold) {
+			proposals = append(proposals, buildConsolidationProposal(parts[0], parts[1], cluster))
+		}
+	}
+	sort.Slice(proposals, func(i, j int) bool {
+		if proposals[i].Namespace != proposals[j].Namespace {
+			return proposals[i].Namespace < proposals[j].Namespace
+		}
+		return proposals[i].Consolidated < proposals[j].Consolidated
+	})
+
+	return proposals
+}
+
+// clusterSimilarRoles groups the roles whose similarity with every other role of their group is at least
+// the threshold (complete linkage), so a chain of similar roles does not merge dissimilar ones. Each role
+// joins the first group it is similar enough to. Only groups of two or more roles are returned.
+func clusterSimilarRoles(members []*consolidationCandidate, threshold float64) [][]*consolidationCandidate {
+	groups := [][]*consolidationCandidate{}
+members:
+	for _, member := range members {
+		if len(member.atoms) > 0 {
+		groups:
+			for i, group := range groups {
+				for _, other := range group {
+					if jaccard(member.atoms, other.atoms) < threshold {
+						continue groups
+					}
+				}
+				groups[i] = append(group, member)
+				continue members
+			}
+		}
+		groups = append(groups, []*consolidationCandidate{member})
+	}
+
+	clusters := [][]*consolidationCandidate{}
+	for _, group := range groups {
+		if len(group) > 1 {
+			clusters = append(clusters, group)
+		}
+	}
+	return clusters
+}
+
+func buildConsolidationProposal(kind, namespace string, cluster []*consolidationCandidate) ConsolidationProposal {
+	kept := cluster[0]
+	union := map[AccessRequest]bool{}
+	similarity := 1.0
+	for i, c := range cluster {
+		if len(c.bindings) > len(kept.bindings) {
+			kept = c
+		}
+		for atom := range c.atoms {
+			union[atom] = true
+		}
+		for _, other := range cluster[i+1:] {
+			if s := jaccard(c.atoms, other.atoms); s < similarity {
+				similarity = s
+			}
+		}
+	}
+
+	unionList := make([]AccessRequest, 0, len(union))
+	for atom := range union {
+		unionList = append(unionList, atom)
+	}
+	sortAccessRequests(unionList)
+
+	proposal := ConsolidationProposal{
+		Kind:            kind,
+		Namespace:       namespace,
+		Roles:           make([]string, 0, len(cluster)),
+		Similarity:      similarity,
+		Consolidated:    kept.name,
+		Rules:           SynthesizeRole(unionList),
+		BindingRewrites: []BindingRewrite{},
+	}
+	for _, c := range cluster {
+		proposal.Roles = append(proposal.Roles, c.name)
+		for _, b := range c.bindings {
+			added := []AccessRequest{}
+			for _, atom := range unionList {
+				if !c.atoms[atom] {
+					if b.BindingKind == "RoleBinding" {
+						atom.Namespace = b.Namespace
+					}
+					added = append(added, atom)
+				}
+			}
+			if c == kept && len(added) == 0 {
+				continue
+			}
+			to := b.RoleRef
+			to.Name = kept.name
+			proposal.BindingRewrites = append(proposal.BindingRewrites, BindingRewrite{
+				BindingKind:      b.BindingKind,
+				BindingName:      b.BindingName,
+				Namespace:        b.Namespace,
+				From:             b.RoleRef,
+				To:               to,
+				AddedPermissions: added,
+			})
+		}
+	}
+	return proposal
+}
+
+// expandRules flattens the resource rules into individual permissions, one per API group,
+// resource, resource name and verb. Wildcards are kept as is. Non-resource rules are ignored.
+func expandRules(rules []rbac_v1.PolicyRule) []AccessRequest {
+	atoms := []AccessRequest{}
+	for atom := range RuleAtoms(rules) {
+		atoms = append(atoms, atom)
+	}
+	return atoms
+}
+
+// atomSet returns the expanded rules as a set.
+func atomSet(rules []rbac_v1.PolicyRule) map[AccessRequest]bool {
+	set := map[AccessRequest]bool{}
+	for _, atom := range expandRules(rules) {
+		set[atom] = true
+	}
+	return set
+}
+
+// jaccard returns the size of the intersection of the sets divided by the size of their union.
+func jaccard(a, b map[AccessRequest]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	intersection := 0
+	for atom := range a {
+		if b[atom] {
+			intersection++
+		}
+	}
+	return float64(intersection) / float64(len(a)+len(b)-intersection)
+}
@@ -0,0 +1,134 @@
+package business
+
+import (
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func consolidationObjects() *RBACSnapshot {
+	viewer := []rbac_v1.PolicyRule{testRule([]string{""}, []string{"pods", "services"}, []string{"get", "list", "watch"})}
+	return testSnapshot(
+		testClusterRole("viewer-a", viewer...),
+		testClusterRole("viewer-b", append(viewer, testRule([]string{""}, []string{"configmaps"}, []string{"get"}))...),
+		testClusterRole("admin", testRule([]string{"*"}, []string{"*"}, []string{"*"})),
+		testClusterRoleBinding("alice-viewer", "viewer-a", testUser("alice")),
+		testClusterRoleBinding("bob-viewer", "viewer-b", testUser("bob")),
+		testRoleBinding("ns1", "carol-viewer", "ClusterRole", "viewer-b", testUser("carol"), testUser("dave")),
+		testClusterRoleBinding("admins", "admin", testGroup("admins")),
+	)
+}
+
+func TestFindRoleConsolidations(t *testing.T) {
+	proposals := consolidationObjects().FindRoleConsolidations(0.8)
+
+	require.Len(t, proposals, 1)
+	proposal := proposals[0]
+	assert.Equal(t, "ClusterRole", proposal.Kind)
+	assert.Empty(t, proposal.Namespace)
+	assert.Equal(t, []string{"viewer-a", "viewer-b"}, proposal.Roles)
+	assert.InDelta(t, 6.0/7.0, proposal.Similarity, 1e-9)
+	// The most bound role is kept
+	assert.Equal(t, "viewer-b", proposal.Consolidated)
+	assert.Equal(t, []rbac_v1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}},
+		{APIGroups: []string{""}, Resources: []string{"pods", "services"}, Verbs: []string{"get", "list", "watch"}},
+	}, proposal.Rules)
+
+	require.Len(t, proposal.BindingRewrites, 1)
+	rewrite := proposal.BindingRewrites[0]
+	assert.Equal(t, "alice-viewer", rewrite.BindingName)
+	assert.Equal(t, "viewer-a", rewrite.From.Name)
+	assert.Equal(t, "viewer-b", rewrite.To.Name)
+	assert.Equal(t, "ClusterRole", rewrite.To.Kind)
+	assert.Equal(t, []AccessRequest{{Resource: "configmaps", Verb: "get"}}, rewrite.AddedPermissions)
+}
+
+func TestFindRoleConsolidationsAboveTheThreshold(t *testing.T) {
+	assert.Empty(t, consolidationObjects().FindRoleConsolidations(DefaultRoleSimilarityThreshold))
+}
+
+func TestFindRoleConsolidationsOnlyComparesRolesOfTheSameNamespace(t *testing.T) {
+	rule := testRule([]string{""}, []string{"pods"}, []string{"get"})
+	snapshot := testSnapshot(
+		testRole("ns1", "pods-a", rule),
+		testRole("ns1", "pods-b", rule),
+		testRole("ns2", "pods-c", rule),
+	)
+
+	proposals := snapshot.FindRoleConsolidations(DefaultRoleSimilarityThreshold)
+	require.Len(t, proposals, 1)
+	assert.Equal(t, "Role", proposals[0].Kind)
+	assert.Equal(t, "ns1", proposals[0].Namespace)
+	assert.Equal(t, []string{"pods-a", "pods-b"}, proposals[0].Roles)
+	assert.Equal(t, 1.0, proposals[0].Similarity)
+	assert.Empty(t, proposals[0].BindingRewrites)
+}
+
+func TestFindRoleConsolidationsRequiresEveryRoleToBeSimilar(t *testing.T) {
+	verbs := func(verbs ...string) rbac_v1.PolicyRule { return testRule([]string{""}, []string{"pods"}, verbs) }
+	snapshot := testSnapshot(
+		testClusterRole("a", verbs("get", "list", "watch", "create")),
+		testClusterRole("b", verbs("list", "watch", "create", "update")),
+		testClusterRole("c", verbs("watch", "create", "update", "delete")),
+	)
+
+	// b is similar to a and to c, but a and c are not similar
+	proposals := snapshot.FindRoleConsolidations(0.6)
+	require.Len(t, proposals, 1)
+	assert.Equal(t, []string{"a", "b"}, proposals[0].Roles)
+	assert.InDelta(t, 0.6, proposals[0].Similarity, 1e-9)
+}
+
+func TestFindRoleConsolidationsReportsTheWidenedBindings(t *testing.T) {
+	viewer := testRule([]string{""}, []string{"pods"}, []string{"get", "list"})
+	snapshot := testSnapshot(
+		testClusterRole("viewer-a", viewer),
+		testClusterRole("viewer-b", viewer, testRule([]string{""}, []string{"configmaps"}, []string{"get"})),
+		testClusterRoleBinding("alice-viewer", "viewer-a", testUser("alice")),
+		testRoleBinding("ns1", "bob-viewer", "ClusterRole", "viewer-a", testUser("bob")),
+		testClusterRoleBinding("carol-viewer", "viewer-b", testUser("carol")),
+	)
+
+	proposals := snapshot.FindRoleConsolidations(0.5)
+	require.Len(t, proposals, 1)
+	assert.Equal(t, "viewer-a", proposals[0].Consolidated)
+	// The bindings of the kept role are not rewritten, but gain the rules of the other role
+	widened := map[string][]AccessRequest{}
+	for _, rewrite := range proposals[0].BindingRewrites {
+		assert.Equal(t, "viewer-a", rewrite.To.Name)
+		widened[rewrite.BindingName+" from "+rewrite.From.Name] = rewrite.AddedPermissions
+	}
+	assert.Equal(t, map[string][]AccessRequest{
+		"alice-viewer from viewer-a": {{Resource: "configmaps", Verb: "get"}},
+		"bob-viewer from viewer-a":   {{Namespace: "ns1", Resource: "configmaps", Verb: "get"}},
+		"carol-viewer from viewer-b": {},
+	}, widened)
+}
+
+func TestFindRoleConsolidationsSkipsTheSystemAndAggregatedRoles(t *testing.T) {
+	rule := testRule([]string{""}, []string{"pods"}, []string{"get"})
+	aggregated := testClusterRole("aggregated", rule)
+	aggregated.AggregationRule = &rbac_v1.AggregationRule{}
+	snapshot := testSnapshot(
+		testClusterRole("pods", rule),
+		testClusterRole("system:pods", rule),
+		aggregated,
+		testRole("kube-system", "system:pods", rule),
+		testRole("kube-system", "pods", rule),
+	)
+
+	assert.Empty(t, snapshot.FindRoleConsolidations(DefaultRoleSimilarityThreshold))
+}
+
+func TestJaccard(t *testing.T) {
+	a := atomSet([]rbac_v1.PolicyRule{testRule([]string{""}, []string{"pods"}, []string{"get", "list"})})
+	b := atomSet([]rbac_v1.PolicyRule{testRule([]string{""}, []string{"pods"}, []string{"list", "watch"})})
+
+	assert.InDelta(t, 1.0/3.0, jaccard(a, b), 1e-9)
+	assert.Equal(t, 1.0, jaccard(a, a))
+	assert.Equal(t, 1.0, jaccard(map[AccessRequest]bool{}, map[AccessRequest]bool{}))
+}
@@ -0,0 +1,313 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	auth_v1 "k8s.io/api/authorization/v1"
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/kiali/kiali/log"
+)
+
+// UnlabelledConsumer is the consumer of the checks made with a context without consumer, see WithConsumer.
+const UnlabelledConsumer = "unlabelled"
+
+// Kinds of the accounted apiserver calls
+const (
+	APICallReview = "review"
+	APICallRBAC   = "rbac"
+)
+
+var (
+	consumerAPICalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kiali_permissions_consumer_apiserver_calls_total",
+		Help: "Number of apiserver calls of the permission subsystem, partitioned by consumer and kind of call.",
+	}, []string{"consumer", "call"})
+	consumerBudgetExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kiali_permissions_consumer_budget_exceeded_total",
+		Help: "Number of apiserver calls of the permission subsystem over the budget of their consumer.",
+	}, []string{"consumer"})
+)
+
+// RegisterCostMetrics registers the metrics of the apiserver calls attributed to the consumers, e.g. to
+// alert on kiali_permissions_consumer_budget_exceeded_total.
+func RegisterCostMetrics(registry prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{consumerAPICalls, consumerBudgetExceeded} {
+		if err := registry.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type consumerKey struct{}
+
+// WithConsumer returns a context whose checks are attributed to the consumer, e.g. the name of the UI page
+// or the subsystem making them, so their apiserver calls are accounted to it. See ConsumerBudget.
+func WithConsumer(ctx context.Context, consumer string) context.Context {
+	return context.WithValue(ctx, consumerKey{}, consumer)
+}
+
+// ConsumerFromContext returns the consumer set by WithConsumer, or UnlabelledConsumer.
+func ConsumerFromContext(ctx context.Context) string {
+	if consumer, _ := ctx.Value(consumerKey{}).(string); consumer != "" {
+		return consumer
+	}
+	return UnlabelledConsumer
+}
+
+// ConsumerBudget is a soft quota of the apiserver calls of a consumer: the calls over it are still made,
+// but logged and counted by kiali_permissions_consumer_budget_exceeded_total, e.g.
+//
+//	consumer_budgets:
+//	- consumer: matrix-report
+//	  max_calls: 10000
+//	  window: 1h
+type ConsumerBudget struct {
+	// Consumer is the consumer of the budget, or * for the consumers without their own budget.
+	Consumer string `yaml:"consumer"`
+	// MaxCalls is the number of apiserver calls allowed per window.
+	MaxCalls int `yaml:"max_calls"`
+	// Window is the period over which the calls are counted. Zero means one minute.
+	Window time.Duration `yaml:"window"`
+}
+
+func (in ConsumerBudget) window() time.Duration {
+	if in.Window > 0 {
+		return in.Window
+	}
+	return time.Minute
+}
+
+func validateConsumerBudgets(budgets []ConsumerBudget) error {
+	seen := map[string]bool{}
+	for _, budget := range budgets {
+		switch {
+		case budget.Consumer == "":
+			return errors.New("budget without consumer")
+		case seen[budget.Consumer]:
+			return fmt.Errorf("several budgets for consumer %s", budget.Consumer)
+		case budget.MaxCalls <= 0:
+			return fmt.Errorf("invalid max calls %d of consumer %s, expected a positive number", budget.MaxCalls, budget.Consumer)
+		case budget.Window < 0:
+			return fmt.Errorf("negative window of consumer %s", budget.Consumer)
+		}
+		seen[budget.Consumer] = true
+	}
+	return nil
+}
+
+// ConsumerCost is the apiserver traffic of a consumer, see ConsumerCosts.
+type ConsumerCost struct {
+	Consumer string `json:"consumer"`
+	// Calls is the number of apiserver calls since the start of the process.
+	Calls int64 `json:"calls"`
+	// WindowCalls is the number of calls in the current window of the budget of the consumer, if any.
+	WindowCalls int64 `json:"windowCalls"`
+	// Budget is the max calls per window of the consumer, zero without budget.
+	Budget int `json:"budget"`
+}
+
+// consumerCounter accounts the calls of a consumer.
+type consumerCounter struct {
+	calls       atomic.Int64
+	mu          sync.Mutex
+	windowStart time.Time
+	windowCalls int64
+	warned      bool
+}
+
+// costAccounting attributes the apiserver calls to the consumers of the process, against their budgets.
+type costAccounting struct {
+	mu        sync.RWMutex
+	budgets   map[string]ConsumerBudget
+	consumers map[string]*consumerCounter
+}
+
+var costs = &costAccounting{budgets: map[string]ConsumerBudget{}, consumers: map[string]*consumerCounter{}}
+
+// SetConsumerBudgets sets the budgets of the consumers of the process, resetting their windows. It is also
+// set by PermissionChecker.ApplyConfig.
+func SetConsumerBudgets(budgets []ConsumerBudget) {
+	byConsumer := make(map[string]ConsumerBudget, len(budgets))
+	for _, budget := range budgets {
+		byConsumer[budget.Consumer] = budget
+	}
+	costs.mu.Lock()
+	defer costs.mu.Unlock()
+	costs.budgets = byConsumer
+	for _, counter := range costs.consumers {
+		counter.mu.Lock()
+		counter.windowStart, counter.windowCalls, counter.warned = time.Time{}, 0, false
+		counter.mu.Unlock()
+	}
+}
+
+// ConsumerCosts returns the apiserver traffic of the consumers seen by the process, sorted by consumer.
+func ConsumerCosts() []ConsumerCost {
+	costs.mu.RLock()
+	defer costs.mu.RUnlock()
+	result := make([]ConsumerCost, 0, len(costs.consumers))
+	now := time.Now()
+	for consumer, counter := range costs.consumers {
+		cost := ConsumerCost{Consumer: consumer, Calls: counter.calls.Load()}
+		if budget, ok := costs.budgetOf(consumer); ok {
+			cost.Budget = budget.MaxCalls
+			counter.mu.Lock()
+			if now.Sub(counter.windowStart) < budget.window() {
+				cost.WindowCalls = counter.windowCalls
+			}
+			counter.mu.Unlock()
+		}
+		result = append(result, cost)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Consumer < result[j].Consumer })
+	return result
+}
+
+// budgetOf returns the budget of the consumer, or the * one. The caller holds the lock.
+func (in *costAccounting) budgetOf(consumer string) (ConsumerBudget, bool) {
+	if budget, ok := in.budgets[consumer]; ok {
+		return budget, true
+	}
+	budget, ok := in.budgets["*"]
+	return budget, ok
+}
+
+// account attributes n apiserver calls of the kind to the consumer of the context, and warns once per
+// window when the consumer exceeds its budget.
+func (in *costAccounting) account(ctx context.Context, call string, n int) {
+	consumer := ConsumerFromContext(ctx)
+	consumerAPICalls.WithLabelValues(consumer, call).Add(float64(n))
+
+	in.mu.RLock()
+	counter, ok := in.consumers[consumer]
+	budget, budgeted := in.budgetOf(consumer)
+	in.mu.RUnlock()
+	if !ok {
+		in.mu.Lock()
+		if counter, ok = in.consumers[consumer]; !ok {
+			counter = &consumerCounter{}
+			in.consumers[consumer] = counter
+		}
+		in.mu.Unlock()
+	}
+	counter.calls.Add(int64(n))
+	if !budgeted {
+		return
+	}
+
+	now := time.Now()
+	counter.mu.Lock()
+	if now.Sub(counter.windowStart) >= budget.window() {
+		counter.windowStart, counter.windowCalls, counter.warned = now, 0, false
+	}
+	counter.windowCalls += int64(n)
+	over := counter.windowCalls - int64(budget.MaxCalls)
+	warn := over > 0 && !counter.warned
+	counter.warned = counter.warned || over > 0
+	counter.mu.Unlock()
+
+	if over > 0 {
+		consumerBudgetExceeded.WithLabelValues(consumer).Add(float64(min(int64(n), over)))
+	}
+	if warn {
+		log.Warningf("%sConsumer %s exceeded its budget of %d apiserver calls per %s", logPrefix(ctx), consumer, budget.MaxCalls, budget.window())
+	}
+}
+
+// accountingClient is a PermissionsClient attributing its apiserver calls to the consumers of the
+// contexts, and marking them for the client metrics, see RegisterClientMetrics. The discovery calls have
+// no context, and are not accounted.
+type accountingClient struct {
+	PermissionsClient
+}
+
+// NewAccountingClient wraps the client so its apiserver calls are accounted to the consumers, see
+// WithConsumer. The checkers account the calls of their client.
+func NewAccountingClient(client PermissionsClient) PermissionsClient {
+	if _, ok := client.(*accountingClient); ok {
+		return client
+	}
+	return &accountingClient{PermissionsClient: client}
+}
+
+// Identity is the identity of the wrapped client.
+func (in *accountingClient) Identity() string {
+	return clientIdentity(in.PermissionsClient)
+}
+
+func (in *accountingClient) GetSelfSubjectAccessReview(ctx context.Context, namespace, api, resourceType string, verbs []string) ([]*auth_v1.SelfSubjectAccessReview, error) {
+	ctx = withPermissionsCall(ctx)
+	// One review per verb
+	costs.account(ctx, APICallReview, len(verbs))
+	return in.PermissionsClient.GetSelfSubjectAccessReview(ctx, namespace, api, resourceType, verbs)
+}
+
+func (in *accountingClient) CreateSelfSubjectAccessReview(ctx context.Context, ssar *auth_v1.SelfSubjectAccessReview) (*auth_v1.SelfSubjectAccessReview, error) {
+	ctx = withPermissionsCall(ctx)
+	costs.account(ctx, APICallReview, 1)
+	return createSelfSubjectAccessReview(ctx, in.PermissionsClient, ssar)
+}
+
+func (in *accountingClient) CreateSubjectAccessReview(ctx context.Context, sar *auth_v1.SubjectAccessReview) (*auth_v1.SubjectAccessReview, error) {
+	ctx = withPermissionsCall(ctx)
+	costs.account(ctx, APICallReview, 1)
+	return in.PermissionsClient.CreateSubjectAccessReview(ctx, sar)
+}
+
+func (in *accountingClient) GetSelfSubjectRulesReview(ctx context.Context, namespace string) (*auth_v1.SelfSubjectRulesReview, error) {
+	ctx = withPermissionsCall(ctx)
+	costs.account(ctx, APICallReview, 1)
+	return in.PermissionsClient.GetSelfSubjectRulesReview(ctx, namespace)
+}
+
+func (in *accountingClient) CreateUserRulesReview(ctx context.Context, user UserInfo, namespace string) (*auth_v1.SelfSubjectRulesReview, error) {
+	ctx = withPermissionsCall(ctx)
+	costs.account(ctx, APICallReview, 1)
+	return createUserRulesReview(ctx, in.PermissionsClient, user, namespace)
+}
+
+func (in *accountingClient) GetClusterRole(ctx context.Context, name string) (*rbac_v1.ClusterRole, error) {
+	ctx = withPermissionsCall(ctx)
+	costs.account(ctx, APICallRBAC, 1)
+	return in.PermissionsClient.GetClusterRole(ctx, name)
+}
+
+func (in *accountingClient) ListClusterRoles(ctx context.Context) ([]rbac_v1.ClusterRole, error) {
+	ctx = withPermissionsCall(ctx)
+	costs.account(ctx, APICallRBAC, 1)
+	return in.PermissionsClient.ListClusterRoles(ctx)
+}
+
+func (in *accountingClient) ListClusterRoleBindings(ctx context.Context) ([]rbac_v1.ClusterRoleBinding, error) {
+	ctx = withPermissionsCall(ctx)
+	costs.account(ctx, APICallRBAC, 1)
+	return in.PermissionsClient.ListClusterRoleBindings(ctx)
+}
+
+func (in *accountingClient) ListRoles(ctx context.Context, namespace string) ([]rbac_v1.Role, error) {
+	ctx = withPermissionsCall(ctx)
+	costs.account(ctx, APICallRBAC, 1)
+	return in.PermissionsClient.ListRoles(ctx, namespace)
+}
+
+func (in *accountingClient) ListRoleBindings(ctx context.Context, namespace string) ([]rbac_v1.RoleBinding, error) {
+	ctx = withPermissionsCall(ctx)
+	costs.account(ctx, APICallRBAC, 1)
+	return in.PermissionsClient.ListRoleBindings(ctx, namespace)
+}
+
+func (in *accountingClient) ListNamespaces(ctx context.Context) ([]core_v1.Namespace, error) {
+	ctx = withPermissionsCall(ctx)
+	costs.account(ctx, APICallRBAC, 1)
+	return listClientNamespaces(ctx, in.PermissionsClient)
+}
@@ -0,0 +1,96 @@
+package business
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	auth_v1 "k8s.io/api/authorization/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// consumerCost returns the cost of the consumer among ConsumerCosts.
+func consumerCost(t *testing.T, consumer string) ConsumerCost {
+	t.Helper()
+	for _, cost := range ConsumerCosts() {
+		if cost.Consumer == consumer {
+			return cost
+		}
+	}
+	t.Fatalf("no cost of consumer %s", consumer)
+	return ConsumerCost{}
+}
+
+func TestConsumerFromContext(t *testing.T) {
+	assert.Equal(t, UnlabelledConsumer, ConsumerFromContext(testCtx))
+	assert.Equal(t, UnlabelledConsumer, ConsumerFromContext(WithConsumer(testCtx, "")))
+	assert.Equal(t, "matrix-report", ConsumerFromContext(WithConsumer(testCtx, "matrix-report")))
+}
+
+func TestValidateConsumerBudgets(t *testing.T) {
+	assert.NoError(t, validateConsumerBudgets(nil))
+	assert.NoError(t, validateConsumerBudgets([]ConsumerBudget{{Consumer: "matrix-report", MaxCalls: 100}, {Consumer: "*", MaxCalls: 10, Window: time.Hour}}))
+	assert.Error(t, validateConsumerBudgets([]ConsumerBudget{{MaxCalls: 100}}))
+	assert.Error(t, validateConsumerBudgets([]ConsumerBudget{{Consumer: "a", MaxCalls: 1}, {Consumer: "a", MaxCalls: 2}}))
+	assert.Error(t, validateConsumerBudgets([]ConsumerBudget{{Consumer: "a"}}))
+	assert.Error(t, validateConsumerBudgets([]ConsumerBudget{{Consumer: "a", MaxCalls: 1, Window: -time.Minute}}))
+}
+
+func TestAccountingClient(t *testing.T) {
+	client := NewAccountingClient(newTestClient(&testReviews{allow: allowUsers("alice")}))
+	assert.Same(t, client, NewAccountingClient(client))
+	ctx := WithConsumer(testCtx, "test-accounting-client")
+
+	sar := &auth_v1.SubjectAccessReview{Spec: auth_v1.SubjectAccessReviewSpec{
+		User:               "alice",
+		ResourceAttributes: &auth_v1.ResourceAttributes{Namespace: "ns1", Resource: "pods", Verb: "get"},
+	}}
+	_, err := client.CreateSubjectAccessReview(ctx, sar)
+	require.NoError(t, err)
+	_, err = client.GetSelfSubjectAccessReview(ctx, "ns1", "", "pods", []string{"get", "list"})
+	require.NoError(t, err)
+	_, err = client.ListClusterRoles(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(consumerAPICalls.WithLabelValues("test-accounting-client", APICallReview)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(consumerAPICalls.WithLabelValues("test-accounting-client", APICallRBAC)))
+	assert.Equal(t, ConsumerCost{Consumer: "test-accounting-client", Calls: 4}, consumerCost(t, "test-accounting-client"))
+}
+
+func TestConsumerBudgets(t *testing.T) {
+	SetConsumerBudgets([]ConsumerBudget{{Consumer: "test-budget", MaxCalls: 2, Window: time.Hour}, {Consumer: "*", MaxCalls: 100}})
+	t.Cleanup(func() { SetConsumerBudgets(nil) })
+	ctx := WithConsumer(testCtx, "test-budget")
+
+	// The calls over the budget are still made, and counted
+	costs.account(ctx, APICallReview, 3)
+	assert.Equal(t, 1.0, testutil.ToFloat64(consumerBudgetExceeded.WithLabelValues("test-budget")))
+	costs.account(ctx, APICallRBAC, 1)
+	assert.Equal(t, 2.0, testutil.ToFloat64(consumerBudgetExceeded.WithLabelValues("test-budget")))
+	assert.Equal(t, ConsumerCost{Consumer: "test-budget", Calls: 4, WindowCalls: 4, Budget: 2}, consumerCost(t, "test-budget"))
+
+	// The consumers without their own budget have the * one
+	costs.account(WithConsumer(testCtx, "test-budget-default"), APICallReview, 1)
+	assert.Equal(t, ConsumerCost{Consumer: "test-budget-default", Calls: 1, WindowCalls: 1, Budget: 100}, consumerCost(t, "test-budget-default"))
+
+	// Setting the budgets resets the windows, not the totals
+	SetConsumerBudgets([]ConsumerBudget{{Consumer: "test-budget", MaxCalls: 10}})
+	assert.Equal(t, ConsumerCost{Consumer: "test-budget", Calls: 4, Budget: 10}, consumerCost(t, "test-budget"))
+	assert.Equal(t, ConsumerCost{Consumer: "test-budget-default", Calls: 1}, consumerCost(t, "test-budget-default"))
+}
+
+func TestCheckerAccountsConsumers(t *testing.T) {
+	t.Cleanup(func() { SetConsumerBudgets(nil) })
+	conf := NewPermissionsConfig()
+	conf.ConsumerBudgets = []ConsumerBudget{{Consumer: "test-checker", MaxCalls: 1}}
+	checker := newTestChecker(&testReviews{allow: allowUsers("alice")}, conf)
+
+	decision, err := checker.Check(WithConsumer(testCtx, "test-checker"), UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	cost := consumerCost(t, "test-checker")
+	assert.Equal(t, 1, cost.Budget)
+	assert.Positive(t, cost.Calls)
+}
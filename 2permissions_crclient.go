@@ -0,0 +1,242 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrNoUserInContext is returned by the clients of NewPermissionCheckingClient for the contexts without
+// a user, see WithUser.
+var ErrNoUserInContext = errors.New("no user in context")
+
+type userKey struct{}
+
+// WithUser returns a context impersonating the user in the calls of the clients of
+// NewPermissionCheckingClient.
+func WithUser(ctx context.Context, user UserInfo) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext returns the user set by WithUser.
+func UserFromContext(ctx context.Context) (UserInfo, bool) {
+	user, ok := ctx.Value(userKey{}).(UserInfo)
+	return user, ok
+}
+
+// permissionCheckingClient is a controller-runtime client checking the permissions of the user of the
+// context before forwarding the calls. It implements each method rather than embedding the wrapped
+// client, so a method added to client.Client fails to compile instead of bypassing the checks.
+type permissionCheckingClient struct {
+	wrapped client.Client
+	checker *PermissionChecker
+}
+
+var _ client.Client = &permissionCheckingClient{}
+
+// NewPermissionCheckingClient wraps a controller-runtime client so each read and write is first checked
+// for the user of the context, see WithUser, with the checker and its decision cache. Denied calls fail
+// with a Forbidden API error, like the apiserver would, so user-scoped controllers and UIs enforce RBAC
+// transparently while the wrapped client keeps its own, usually broader, identity.
+func NewPermissionCheckingClient(c client.Client, checker *PermissionChecker) client.Client {
+	return &permissionCheckingClient{wrapped: c, checker: checker}
+}
+
+func (in *permissionCheckingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if err := in.check(ctx, obj, "", key.Namespace, key.Name, "get"); err != nil {
+		return err
+	}
+	return in.wrapped.Get(ctx, key, obj, opts...)
+}
+
+func (in *permissionCheckingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := (&client.ListOptions{}).ApplyOptions(opts)
+	gvr, err := in.resourceFor(list, true)
+	if err != nil {
+		return err
+	}
+	req := AccessRequestForGVR(gvr, listOpts.Namespace, "", "list")
+	if listOpts.FieldSelector != nil && !listOpts.FieldSelector.Empty() {
+		req.FieldSelector = listOpts.FieldSelector.String()
+	}
+	if listOpts.LabelSelector != nil && !listOpts.LabelSelector.Empty() {
+		req.LabelSelector = listOpts.LabelSelector.String()
+	}
+	if err := in.checkRequest(ctx, gvr, req); err != nil {
+		return err
+	}
+	return in.wrapped.List(ctx, list, opts...)
+}
+
+func (in *permissionCheckingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	// Create requests are authorized without the name of the object
+	if err := in.check(ctx, obj, "", obj.GetNamespace(), "", "create"); err != nil {
+		return err
+	}
+	return in.wrapped.Create(ctx, obj, opts...)
+}
+
+func (in *permissionCheckingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := in.check(ctx, obj, "", obj.GetNamespace(), obj.GetName(), "delete"); err != nil {
+		return err
+	}
+	return in.wrapped.Delete(ctx, obj, opts...)
+}
+
+func (in *permissionCheckingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := in.check(ctx, obj, "", obj.GetNamespace(), obj.GetName(), "update"); err != nil {
+		return err
+	}
+	return in.wrapped.Update(ctx, obj, opts...)
+}
+
+func (in *permissionCheckingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if err := in.check(ctx, obj, "", obj.GetNamespace(), obj.GetName(), "patch"); err != nil {
+		return err
+	}
+	return in.wrapped.Patch(ctx, obj, patch, opts...)
+}
+
+// Apply is authorized like the server-side apply requests: patch, and create if the object does not exist.
+func (in *permissionCheckingClient) Apply(ctx context.Context, obj runtime.ApplyConfiguration, opts ...client.ApplyOption) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return fmt.Errorf("invalid apply configuration: %w", err)
+	}
+	applied := &unstructured.Unstructured{Object: content}
+	target := &meta_v1.PartialObjectMetadata{ObjectMeta: meta_v1.ObjectMeta{Namespace: applied.GetNamespace(), Name: applied.GetName()}}
+	target.SetGroupVersionKind(applied.GroupVersionKind())
+	if err := in.check(ctx, target, "", target.Namespace, target.Name, "patch"); err != nil {
+		return err
+	}
+	err = in.wrapped.Get(ctx, client.ObjectKeyFromObject(target), target)
+	switch {
+	case k8s_errors.IsNotFound(err):
+		if err := in.check(ctx, target, "", target.Namespace, "", "create"); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	}
+	return in.wrapped.Apply(ctx, obj, opts...)
+}
+
+func (in *permissionCheckingClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	deleteOpts := (&client.DeleteAllOfOptions{}).ApplyOptions(opts)
+	if err := in.check(ctx, obj, "", deleteOpts.Namespace, "", "deletecollection"); err != nil {
+		return err
+	}
+	return in.wrapped.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (in *permissionCheckingClient) Status() client.SubResourceWriter {
+	return in.SubResource("status")
+}
+
+func (in *permissionCheckingClient) SubResource(subResource string) client.SubResourceClient {
+	return &permissionCheckingSubResourceClient{wrapped: in.wrapped.SubResource(subResource), parent: in, subResource: subResource}
+}
+
+func (in *permissionCheckingClient) Scheme() *runtime.Scheme {
+	return in.wrapped.Scheme()
+}
+
+func (in *permissionCheckingClient) RESTMapper() meta.RESTMapper {
+	return in.wrapped.RESTMapper()
+}
+
+func (in *permissionCheckingClient) GroupVersionKindFor(obj runtime.Object) (schema.GroupVersionKind, error) {
+	return in.wrapped.GroupVersionKindFor(obj)
+}
+
+func (in *permissionCheckingClient) IsObjectNamespaced(obj runtime.Object) (bool, error) {
+	return in.wrapped.IsObjectNamespaced(obj)
+}
+
+// check checks the verb on the resource of the object.
+func (in *permissionCheckingClient) check(ctx context.Context, obj client.Object, subResource, namespace, name, verb string) error {
+	gvr, err := in.resourceFor(obj, false)
+	if err != nil {
+		return err
+	}
+	req := AccessRequestForGVR(gvr, namespace, name, verb)
+	req.Subresource = subResource
+	return in.checkRequest(ctx, gvr, req)
+}
+
+func (in *permissionCheckingClient) checkRequest(ctx context.Context, gvr schema.GroupVersionResource, req AccessRequest) error {
+	user, ok := UserFromContext(ctx)
+	if !ok {
+		return ErrNoUserInContext
+	}
+	decision, err := in.checker.Check(ctx, user, req)
+	if err != nil {
+		return err
+	}
+	if !decision.Allowed {
+		return k8s_errors.NewForbidden(gvr.GroupResource(), req.Name, fmt.Errorf("user %q cannot %s: %s", user.Name, req.Verb, decision.Reason))
+	}
+	return nil
+}
+
+// resourceFor maps the object, or the list, to its resource with the RESTMapper of the client.
+func (in *permissionCheckingClient) resourceFor(obj runtime.Object, isList bool) (schema.GroupVersionResource, error) {
+	gvk, err := in.wrapped.GroupVersionKindFor(obj)
+	if err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+	if isList {
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	}
+	mapping, err := in.wrapped.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+	return mapping.Resource, nil
+}
+
+// permissionCheckingSubResourceClient checks the calls on a subresource, e.g. status, like its parent.
+type permissionCheckingSubResourceClient struct {
+	wrapped     client.SubResourceClient
+	parent      *permissionCheckingClient
+	subResource string
+}
+
+var _ client.SubResourceClient = &permissionCheckingSubResourceClient{}
+
+func (in *permissionCheckingSubResourceClient) Get(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceGetOption) error {
+	if err := in.parent.check(ctx, obj, in.subResource, obj.GetNamespace(), obj.GetName(), "get"); err != nil {
+		return err
+	}
+	return in.wrapped.Get(ctx, obj, subResource, opts...)
+}
+
+func (in *permissionCheckingSubResourceClient) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	if err := in.parent.check(ctx, obj, in.subResource, obj.GetNamespace(), obj.GetName(), "create"); err != nil {
+		return err
+	}
+	return in.wrapped.Create(ctx, obj, subResource, opts...)
+}
+
+func (in *permissionCheckingSubResourceClient) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	if err := in.parent.check(ctx, obj, in.subResource, obj.GetNamespace(), obj.GetName(), "update"); err != nil {
+		return err
+	}
+	return in.wrapped.Update(ctx, obj, opts...)
+}
+
+func (in *permissionCheckingSubResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	if err := in.parent.check(ctx, obj, in.subResource, obj.GetNamespace(), obj.GetName(), "patch"); err != nil {
+		return err
+	}
+	return in.wrapped.Patch(ctx, obj, patch, opts...)
+}
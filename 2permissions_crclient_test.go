@@ -0,0 +1,147 @@
+package business
+
+import (
+	"sync"
+	"testing"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+	core_v1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	core_v1_ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordedReviews allows alice to do anything with the pods of ns1, recording the attributes reviewed.
+type recordedReviews struct {
+	mu         sync.Mutex
+	attributes []auth_v1.ResourceAttributes
+}
+
+func (in *recordedReviews) allow(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.attributes = append(in.attributes, *attrs)
+	return user.Name == "alice" && attrs.Resource == "pods" && attrs.Namespace == "ns1"
+}
+
+// last returns the attributes of the last review, without the selectors.
+func (in *recordedReviews) last() auth_v1.ResourceAttributes {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	last := in.attributes[len(in.attributes)-1]
+	return auth_v1.ResourceAttributes{Namespace: last.Namespace, Verb: last.Verb, Group: last.Group, Resource: last.Resource, Subresource: last.Subresource, Name: last.Name}
+}
+
+func newTestCheckingClient() (client.Client, *recordedReviews) {
+	recorded := &recordedReviews{}
+	// Every call is reviewed
+	conf := NewPermissionsConfig()
+	conf.CacheTTL = 0
+	checker := newTestChecker(&testReviews{allow: recorded.allow}, conf)
+	// The RESTMapper of the fake client knows no kind by default
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(core_v1.SchemeGroupVersion.WithKind("Pod"), meta.RESTScopeNamespace)
+	wrapped := fake.NewClientBuilder().WithRESTMapper(mapper).WithObjects(
+		&core_v1.Pod{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns1", Name: "web", Labels: map[string]string{"app": "web"}}},
+		&core_v1.Pod{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns2", Name: "web"}},
+	).WithStatusSubresource(&core_v1.Pod{}).Build()
+	return NewPermissionCheckingClient(wrapped, checker), recorded
+}
+
+func TestPermissionCheckingClientRequiresAUser(t *testing.T) {
+	c, recorded := newTestCheckingClient()
+
+	err := c.Get(testCtx, client.ObjectKey{Namespace: "ns1", Name: "web"}, &core_v1.Pod{})
+	assert.ErrorIs(t, err, ErrNoUserInContext)
+	assert.Empty(t, recorded.attributes)
+
+	user, ok := UserFromContext(WithUser(testCtx, UserInfo{Name: "alice"}))
+	assert.True(t, ok)
+	assert.Equal(t, "alice", user.Name)
+}
+
+func TestPermissionCheckingClientReads(t *testing.T) {
+	c, recorded := newTestCheckingClient()
+	alice := WithUser(testCtx, UserInfo{Name: "alice"})
+
+	pod := &core_v1.Pod{}
+	require.NoError(t, c.Get(alice, client.ObjectKey{Namespace: "ns1", Name: "web"}, pod))
+	assert.Equal(t, "web", pod.Name)
+	assert.Equal(t, auth_v1.ResourceAttributes{Namespace: "ns1", Verb: "get", Resource: "pods", Name: "web"}, recorded.last())
+
+	err := c.Get(alice, client.ObjectKey{Namespace: "ns2", Name: "web"}, &core_v1.Pod{})
+	assert.True(t, k8s_errors.IsForbidden(err), "%v", err)
+	err = c.Get(WithUser(testCtx, UserInfo{Name: "bob"}), client.ObjectKey{Namespace: "ns1", Name: "web"}, &core_v1.Pod{})
+	assert.True(t, k8s_errors.IsForbidden(err), "%v", err)
+
+	pods := &core_v1.PodList{}
+	require.NoError(t, c.List(alice, pods, client.InNamespace("ns1"), client.MatchingLabels{"app": "web"}))
+	assert.Len(t, pods.Items, 1)
+	assert.Equal(t, auth_v1.ResourceAttributes{Namespace: "ns1", Verb: "list", Resource: "pods"}, recorded.last())
+	require.NotNil(t, recorded.attributes[len(recorded.attributes)-1].LabelSelector)
+
+	err = c.List(alice, &core_v1.PodList{})
+	assert.True(t, k8s_errors.IsForbidden(err), "%v", err)
+}
+
+func TestPermissionCheckingClientWrites(t *testing.T) {
+	c, recorded := newTestCheckingClient()
+	alice := WithUser(testCtx, UserInfo{Name: "alice"})
+
+	// Create requests are reviewed without the name
+	require.NoError(t, c.Create(alice, &core_v1.Pod{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns1", Name: "db"}}))
+	assert.Equal(t, auth_v1.ResourceAttributes{Namespace: "ns1", Verb: "create", Resource: "pods"}, recorded.last())
+
+	pod := &core_v1.Pod{}
+	require.NoError(t, c.Get(alice, client.ObjectKey{Namespace: "ns1", Name: "db"}, pod))
+	pod.Labels = map[string]string{"app": "db"}
+	require.NoError(t, c.Update(alice, pod))
+	assert.Equal(t, auth_v1.ResourceAttributes{Namespace: "ns1", Verb: "update", Resource: "pods", Name: "db"}, recorded.last())
+
+	require.NoError(t, c.Status().Update(alice, pod))
+	assert.Equal(t, auth_v1.ResourceAttributes{Namespace: "ns1", Verb: "update", Resource: "pods", Subresource: "status", Name: "db"}, recorded.last())
+
+	require.NoError(t, c.Delete(alice, pod))
+	assert.Equal(t, auth_v1.ResourceAttributes{Namespace: "ns1", Verb: "delete", Resource: "pods", Name: "db"}, recorded.last())
+
+	web := &core_v1.Pod{}
+	require.NoError(t, c.Get(alice, client.ObjectKey{Namespace: "ns1", Name: "web"}, web))
+	patch := client.MergeFrom(web.DeepCopy())
+	web.Labels["tier"] = "frontend"
+	require.NoError(t, c.Patch(alice, web, patch))
+	assert.Equal(t, auth_v1.ResourceAttributes{Namespace: "ns1", Verb: "patch", Resource: "pods", Name: "web"}, recorded.last())
+
+	require.NoError(t, c.DeleteAllOf(alice, &core_v1.Pod{}, client.InNamespace("ns1")))
+	assert.Equal(t, auth_v1.ResourceAttributes{Namespace: "ns1", Verb: "deletecollection", Resource: "pods"}, recorded.last())
+
+	err := c.Delete(alice, &core_v1.Pod{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns2", Name: "web"}})
+	assert.True(t, k8s_errors.IsForbidden(err), "%v", err)
+	err = c.Create(WithUser(testCtx, UserInfo{Name: "bob"}), &core_v1.Pod{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns1", Name: "cache"}})
+	assert.True(t, k8s_errors.IsForbidden(err), "%v", err)
+}
+
+func TestPermissionCheckingClientApply(t *testing.T) {
+	c, recorded := newTestCheckingClient()
+
+	// Server-side apply is not forwarded to the wrapped client for denied users
+	err := c.Apply(WithUser(testCtx, UserInfo{Name: "bob"}), core_v1_ac.Pod("web", "ns1"), client.FieldOwner("test"))
+	assert.True(t, k8s_errors.IsForbidden(err), "%v", err)
+	assert.Equal(t, auth_v1.ResourceAttributes{Namespace: "ns1", Verb: "patch", Resource: "pods", Name: "web"}, recorded.last())
+
+	// Applying a missing object also needs create
+	err = c.Apply(WithUser(testCtx, UserInfo{Name: "alice"}), core_v1_ac.Pod("web", "ns2"), client.FieldOwner("test"))
+	assert.True(t, k8s_errors.IsForbidden(err), "%v", err)
+	assert.Equal(t, auth_v1.ResourceAttributes{Namespace: "ns2", Verb: "patch", Resource: "pods", Name: "web"}, recorded.last())
+
+	alice := WithUser(testCtx, UserInfo{Name: "alice"})
+	_ = c.Apply(alice, core_v1_ac.Pod("db", "ns1").WithLabels(map[string]string{"app": "db"}), client.FieldOwner("test"))
+	assert.Equal(t, auth_v1.ResourceAttributes{Namespace: "ns1", Verb: "create", Resource: "pods"}, recorded.last())
+	_ = c.Apply(alice, core_v1_ac.Pod("web", "ns1").WithLabels(map[string]string{"tier": "frontend"}), client.FieldOwner("test"))
+	assert.Equal(t, auth_v1.ResourceAttributes{Namespace: "ns1", Verb: "patch", Resource: "pods", Name: "web"}, recorded.last())
+}
@@ -0,0 +1,219 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/kiali/kiali/log"
+)
+
+// maxClosestRules bounds the number of near misses reported for a denied request.
+const maxClosestRules = 5
+
+// NearMiss is an existing rule granted to the user that almost allows a denied request.
+type NearMiss struct {
+	PermissionPath
+	// Mismatches lists the request attributes not covered by the rule:
+	// "namespace", "verb", "apiGroup", "resource" or "name".
+	Mismatches []string
+}
+
+// DenialExplanation reports why a request is denied and how it could be granted.
+type DenialExplanation struct {
+	User    UserInfo
+	Request AccessRequest
+	// Allowed is true if the request is not denied, in which case there is nothing to remediate.
+	Allowed bool
+	// ClosestRules are the rules of the user that differ the least from the request, closest first.
+	ClosestRules []NearMiss
+	// SuggestedManifests is a Role and RoleBinding YAML (or ClusterRole and ClusterRoleBinding for
+	// cluster-scoped requests) that would grant exactly the missing permission.
+	SuggestedManifests string
+}
+
+// ExplainDenial evaluates the request for the user and, when it is denied, reports the closest
+// matching rules the user already has along with a suggested remediation.
+func (in *RBACSnapshot) ExplainDenial(user UserInfo, attrs AccessRequest) (*DenialExplanation, error) {
+	result := &DenialExplanation{User: user, Request: attrs, ClosestRules: []NearMiss{}}
+	if in.Explain(user, attrs).Allowed {
+		result.Allowed = true
+		return result, nil
+	}
+
+	for _, grant := range in.Grants() {
+		if !subjectMatches(grant.Subject, grant.Namespace, user) {
+			continue
+		}
+		for _, rule := range grant.Rules {
+			mismatches := ruleMismatches(grant, rule, attrs)
+			if len(mismatches) == 0 {
+				continue
+			}
+			result.ClosestRules = append(result.ClosestRules, NearMiss{
+				PermissionPath: PermissionPath{
+					BindingKind: grant.BindingKind,
+					BindingName: grant.BindingName,
+					Namespace:   grant.Namespace,
+					Subject:     grant.Subject,
+					RoleRef:     grant.RoleRef,
+					Rule:        rule,
+				},
+				Mismatches: mismatches,
+			})
+		}
+	}
+	sort.SliceStable(result.ClosestRules, func(i, j int) bool {
+		return len(result.ClosestRules[i].Mismatches) < len(result.ClosestRules[j].Mismatches)
+	})
+	if len(result.ClosestRules) > maxClosestRules {
+		result.ClosestRules = result.ClosestRules[:maxClosestRules]
+	}
+
+	manifests, err := RenderManifests(buildRoleManifests(
+		manifestName("grant", user.Name, attrs.Verb, attrs.Resource, attrs.Subresource),
+		attrs.Namespace,
+		[]rbac_v1.PolicyRule{ruleForRequest(attrs)},
+		[]rbac_v1.Subject{subjectForUser(user.Name)},
+	))
+	if err != nil {
+		return nil, err
+	}
+	result.SuggestedManifests = manifests
+
+	return result, nil
+}
+
+// ruleMismatches returns the attributes of the request that the rule, granted through grant, does not cover.
+func ruleMismatches(grant RoleGrant, rule rbac_v1.PolicyRule, attrs AccessRequest) []string {
+	mismatches := []string{}
+	if !grantAppliesTo(grant, attrs.Namespace) {
+		mismatches = append(mismatches, "namespace")
+	}
+	if !verbMatches(rule, attrs.Verb) {
+		mismatches = append(mismatches, "verb")
+	}
+	if !apiGroupMatches(rule, attrs.APIGroup) {
+		mismatches = append(mismatches, "apiGroup")
+	}
+	if !resourceMatches(rule, attrs.Resource, attrs.Subresource) {
+		mismatches = append(mismatches, "resource")
+	}
+	if !resourceNameMatches(rule, attrs.Name) {
+		mismatches = append(mismatches, "name")
+	}
+	return mismatches
+}
+
+// ruleForRequest returns the narrowest PolicyRule allowing the request.
+func ruleForRequest(attrs AccessRequest) rbac_v1.PolicyRule {
+	resource := attrs.Resource
+	if attrs.Subresource != "" {
+		resource = attrs.Resource + "/" + attrs.Subresource
+	}
+	rule := rbac_v1.PolicyRule{
+		APIGroups: []string{attrs.APIGroup},
+		Resources: []string{resource},
+		Verbs:     []string{attrs.Verb},
+	}
+	if attrs.Name != "" {
+		rule.ResourceNames = []string{attrs.Name}
+	}
+	return rule
+}
+
+// DenialProblemType identifies the access denied problems, see RFC 7807.
+const DenialProblemType = "urn:kiali:problem:permission-denied"
+
+// Content types of the denial responses
+const (
+	ContentTypeProblemJSON = "application/problem+json"
+	ContentTypeJSON        = "application/json"
+)
+
+// DenialProblem is an access denied response in the RFC 7807 problem details format, extended with the
+// denied request so frontends can tell the user what access is missing. The reason of the decision is not
+// included: the authorizers' reasons, e.g. the details of webhooks or CEL expressions, are internal.
+type DenialProblem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the denied API request.
+	Instance string `json:"instance,omitempty"`
+
+	Verb        string `json:"verb"`
+	APIGroup    string `json:"apiGroup"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	// Source is the authorizer or cache that made the decision.
+	Source    string `json:"source,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// NewDenialProblem describes the denial of the request. The request ID of the context, see WithRequestID,
+// is included so the denial can be correlated with the audit records.
+func NewDenialProblem(ctx context.Context, req AccessRequest, decision Decision) *DenialProblem {
+	resource := req.Resource
+	if req.APIGroup != "" {
+		resource += "." + req.APIGroup
+	}
+	if req.Subresource != "" {
+		resource += "/" + req.Subresource
+	}
+	detail := fmt.Sprintf("cannot %s %s", req.Verb, resource)
+	if req.Name != "" {
+		detail += " " + req.Name
+	}
+	if req.Namespace != "" {
+		detail += " in namespace " + req.Namespace
+	}
+
+	return &DenialProblem{
+		Type:        DenialProblemType,
+		Title:       "Access denied",
+		Status:      http.StatusForbidden,
+		Detail:      detail,
+		Verb:        req.Verb,
+		APIGroup:    req.APIGroup,
+		Resource:    req.Resource,
+		Subresource: req.Subresource,
+		Name:        req.Name,
+		Namespace:   req.Namespace,
+		Source:      decision.Source,
+		RequestID:   RequestIDFromContext(ctx),
+	}
+}
+
+// WriteDenialProblem writes the denial of the request as application/problem+json, with a 403 status. The
+// reason of the decision is logged with the request ID, not sent.
+func WriteDenialProblem(w http.ResponseWriter, r *http.Request, req AccessRequest, decision Decision) {
+	writeDenial(w, r, req, decision, ContentTypeProblemJSON)
+}
+
+// WriteDenialJSON is like WriteDenialProblem, with the application/json content type for the clients
+// not accepting problem+json.
+func WriteDenialJSON(w http.ResponseWriter, r *http.Request, req AccessRequest, decision Decision) {
+	writeDenial(w, r, req, decision, ContentTypeJSON)
+}
+
+func writeDenial(w http.ResponseWriter, r *http.Request, req AccessRequest, decision Decision, contentType string) {
+	ctx := requestContext(r)
+	problem := NewDenialProblem(ctx, req, decision)
+	problem.Instance = r.URL.Path
+	if decision.Reason != "" {
+		log.Infof("%sAccess denied, %s: %s", logPrefix(ctx), problem.Detail, decision.Reason)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(problem.Status)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		log.Errorf("%sError writing access denied response: %v", logPrefix(ctx), err)
+	}
+}
@@ -0,0 +1,128 @@
+package business
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainDenial(t *testing.T) {
+	snapshot := testSnapshot(append(podReaderObjects(),
+		testRole("ns1", "service-reader", testRule([]string{""}, []string{"services"}, []string{"get"})),
+		testRoleBinding("ns1", "alice-services", "Role", "service-reader", testUser("alice")),
+	)...)
+	alice := UserInfo{Name: "alice"}
+
+	denial, err := snapshot.ExplainDenial(alice, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "delete"})
+	require.NoError(t, err)
+	assert.False(t, denial.Allowed)
+	require.Len(t, denial.ClosestRules, 2)
+	// The pod reader only misses the verb, the service reader also misses the resource
+	assert.Equal(t, "alice-pods", denial.ClosestRules[0].BindingName)
+	assert.Equal(t, []string{"verb"}, denial.ClosestRules[0].Mismatches)
+	assert.Equal(t, "alice-services", denial.ClosestRules[1].BindingName)
+	assert.Equal(t, []string{"verb", "resource"}, denial.ClosestRules[1].Mismatches)
+
+	assert.Contains(t, denial.SuggestedManifests, "kind: Role\n")
+	assert.Contains(t, denial.SuggestedManifests, "kind: RoleBinding\n")
+	assert.Contains(t, denial.SuggestedManifests, "name: grant-alice-delete-pods\n")
+	assert.Contains(t, denial.SuggestedManifests, "namespace: ns1\n")
+	assert.Contains(t, denial.SuggestedManifests, "- delete\n")
+
+	denial, err = snapshot.ExplainDenial(alice, AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "get"})
+	require.NoError(t, err)
+	require.NotEmpty(t, denial.ClosestRules)
+	assert.Equal(t, []string{"namespace"}, denial.ClosestRules[0].Mismatches)
+}
+
+func TestExplainDenialOfAnAllowedRequest(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+
+	denial, err := snapshot.ExplainDenial(UserInfo{Name: "bob"}, AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "list"})
+	require.NoError(t, err)
+	assert.True(t, denial.Allowed)
+	assert.Empty(t, denial.ClosestRules)
+	assert.Empty(t, denial.SuggestedManifests)
+}
+
+func TestExplainDenialOfAClusterScopedRequest(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+
+	denial, err := snapshot.ExplainDenial(UserInfo{Name: "system:serviceaccount:ns1:builder"}, AccessRequest{Resource: "nodes", Verb: "get", Name: "node1"})
+	require.NoError(t, err)
+	assert.Empty(t, denial.ClosestRules)
+	assert.Contains(t, denial.SuggestedManifests, "kind: ClusterRole\n")
+	assert.Contains(t, denial.SuggestedManifests, "kind: ClusterRoleBinding\n")
+	assert.Contains(t, denial.SuggestedManifests, "kind: ServiceAccount\n")
+	assert.Contains(t, denial.SuggestedManifests, "- node1\n")
+}
+
+func TestRuleForRequest(t *testing.T) {
+	assert.Equal(t, rbac_v1.PolicyRule{
+		APIGroups:     []string{"apps"},
+		Resources:     []string{"deployments/scale"},
+		ResourceNames: []string{"web"},
+		Verbs:         []string{"update"},
+	}, ruleForRequest(AccessRequest{APIGroup: "apps", Resource: "deployments", Subresource: "scale", Name: "web", Verb: "update"}))
+}
+
+func TestManifestName(t *testing.T) {
+	assert.Equal(t, "grant-alice-example.com-get-pods", manifestName("grant", "Alice@Example.com", "get", "pods", ""))
+	assert.Equal(t, "grant-system-serviceaccount-ns1-builder", manifestName("grant", "system:serviceaccount:ns1:builder"))
+	assert.Len(t, manifestName("grant", string(make([]byte, 300))), len("grant"))
+}
+
+func TestSubjectForUser(t *testing.T) {
+	assert.Equal(t, testUser("alice"), subjectForUser("alice"))
+	assert.Equal(t, rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Namespace: "ns1", Name: "builder"}, subjectForUser("system:serviceaccount:ns1:builder"))
+}
+
+func TestNewDenialProblem(t *testing.T) {
+	ctx := WithRequestID(testCtx, "req-1")
+	decision := Decision{Reason: "no RBAC policy matched", Source: DecisionSourceAPIServer}
+
+	problem := NewDenialProblem(ctx, AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Subresource: "scale", Name: "web", Verb: "update"}, decision)
+	assert.Equal(t, DenialProblemType, problem.Type)
+	assert.Equal(t, http.StatusForbidden, problem.Status)
+	assert.Equal(t, "cannot update deployments.apps/scale web in namespace ns1", problem.Detail)
+	assert.Equal(t, DecisionSourceAPIServer, problem.Source)
+	assert.Equal(t, "req-1", problem.RequestID)
+
+	problem = NewDenialProblem(testCtx, AccessRequest{Resource: "nodes", Verb: "list"}, Decision{})
+	assert.Equal(t, "cannot list nodes", problem.Detail)
+	assert.Empty(t, problem.RequestID)
+}
+
+func TestWriteDenialProblem(t *testing.T) {
+	for contentType, write := range map[string]func(http.ResponseWriter, *http.Request, AccessRequest, Decision){
+		ContentTypeProblemJSON: WriteDenialProblem,
+		ContentTypeJSON:        WriteDenialJSON,
+	} {
+		t.Run(contentType, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodDelete, "/api/namespaces/ns1/pods/web", nil)
+			r.Header.Set(RequestIDHeader, "req-2")
+			rec := httptest.NewRecorder()
+
+			write(rec, r, AccessRequest{Namespace: "ns1", Resource: "pods", Name: "web", Verb: "delete"}, Decision{Reason: "webhook policy-engine: rule 12 matched", Source: DecisionSourceCache})
+
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+			assert.Equal(t, contentType, rec.Header().Get("Content-Type"))
+			var problem DenialProblem
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+			assert.Equal(t, "/api/namespaces/ns1/pods/web", problem.Instance)
+			assert.Equal(t, "req-2", problem.RequestID)
+			assert.Equal(t, "delete", problem.Verb)
+			assert.Equal(t, "web", problem.Name)
+			assert.Equal(t, DecisionSourceCache, problem.Source)
+			assert.Equal(t, "cannot delete pods web in namespace ns1", problem.Detail)
+			// The reason of the authorizer is internal, it is only logged
+			assert.NotContains(t, rec.Body.String(), "policy-engine")
+		})
+	}
+}
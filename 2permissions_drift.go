@@ -0,0 +1,253 @@
+package business
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// DriftReportAPIVersion identifies the schema of the drift reports, which CI gates parse.
+const DriftReportAPIVersion = "kiali.io/rbac-drift/v1"
+
+// GitOpsLabels are the labels and annotations set by GitOps controllers on the objects they manage: Argo
+// CD, with label or annotation tracking, and the kustomize and helm controllers of Flux. The label of the
+// Argo CD label tracking is also set by many Helm charts.
+var GitOpsLabels = []string{
+	"app.kubernetes.io/instance",
+	"argocd.argoproj.io/tracking-id",
+	"kustomize.toolkit.fluxcd.io/name",
+	"helm.toolkit.fluxcd.io/name",
+}
+
+// DriftObject is an RBAC object of a drift report.
+type DriftObject struct {
+	Kind      string `json:"kind" yaml:"kind"`
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Name      string `json:"name" yaml:"name"`
+}
+
+// DesiredRBAC is the set of RBAC objects declared in Git.
+type DesiredRBAC struct {
+	// Source describes where the objects were read from, e.g. the directory.
+	Source  string
+	objects map[DriftObject]bool
+}
+
+// DriftReport compares the live RBAC objects of a cluster with the desired ones. Lists are sorted.
+type DriftReport struct {
+	APIVersion  string    `json:"apiVersion" yaml:"apiVersion"`
+	GeneratedAt time.Time `json:"generatedAt" yaml:"generatedAt"`
+	Source      string    `json:"source" yaml:"source"`
+	// Unmanaged are the live bindings absent from the desired state, e.g. granted by hand.
+	Unmanaged []DriftObject `json:"unmanaged" yaml:"unmanaged"`
+	// Missing are the desired roles and bindings absent from the cluster, e.g. not synced yet.
+	Missing []DriftObject `json:"missing" yaml:"missing"`
+}
+
+// HasDrift returns true if the cluster has unmanaged bindings, e.g. to fail a CI gate. Missing objects
+// are not drift: the GitOps controller is expected to create them.
+func (in *DriftReport) HasDrift() bool {
+	return len(in.Unmanaged) > 0
+}
+
+// LoadDesiredRBAC reads the roles and bindings of the YAML and JSON manifests of the directory and its
+// subdirectories. Other objects are ignored, and so are the namespaces of cluster-scoped objects.
+// Namespaced objects without a namespace get defaultNamespace, like kubectl apply would.
+func LoadDesiredRBAC(dir, defaultNamespace string) (*DesiredRBAC, error) {
+	desired := &DesiredRBAC{Source: dir, objects: map[DriftObject]bool{}}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			// Hidden directories, e.g. .git, hold no manifests
+			if path != dir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if err := desired.read(file, defaultNamespace); err != nil {
+			return fmt.Errorf("invalid manifest %s: %w", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return desired, nil
+}
+
+// read adds the RBAC objects of a YAML stream. Lists, e.g. of kubectl get -o yaml, are expanded.
+func (in *DesiredRBAC) read(r io.Reader, defaultNamespace string) error {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		var manifest struct {
+			APIVersion string `json:"apiVersion"`
+			Kind       string `json:"kind"`
+			Metadata   struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			Items []map[string]interface{} `json:"items"`
+		}
+		if err := yaml.Unmarshal(doc, &manifest); err != nil {
+			return err
+		}
+		if manifest.Items != nil && strings.HasSuffix(manifest.Kind, "List") {
+			for _, item := range manifest.Items {
+				content, err := yaml.Marshal(item)
+				if err != nil {
+					return err
+				}
+				if err := in.read(bytes.NewReader(content), defaultNamespace); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !strings.HasPrefix(manifest.APIVersion, rbac_v1.GroupName+"/") {
+			continue
+		}
+		object := DriftObject{Kind: manifest.Kind, Namespace: manifest.Metadata.Namespace, Name: manifest.Metadata.Name}
+		switch manifest.Kind {
+		case "ClusterRole", "ClusterRoleBinding":
+			object.Namespace = ""
+		case "Role", "RoleBinding":
+			if object.Namespace == "" {
+				object.Namespace = defaultNamespace
+			}
+		default:
+			continue
+		}
+		in.objects[object] = true
+	}
+}
+
+// DesiredFromGitOpsLabels returns the RBAC objects of the snapshot managed by a GitOps controller, i.e.
+// having one of the GitOpsLabels, for the clusters synced by Flux or Argo CD from an application
+// instead of a local checkout.
+func DesiredFromGitOpsLabels(snapshot *RBACSnapshot) *DesiredRBAC {
+	desired := &DesiredRBAC{Source: "gitops-labels", objects: map[DriftObject]bool{}}
+	managed := func(labels, annotations map[string]string) bool {
+		for _, key := range GitOpsLabels {
+			if labels[key] != "" || annotations[key] != "" {
+				return true
+			}
+		}
+		return false
+	}
+	for _, cr := range snapshot.ClusterRoles {
+		if managed(cr.Labels, cr.Annotations) {
+			desired.objects[DriftObject{Kind: "ClusterRole", Name: cr.Name}] = true
+		}
+	}
+	for _, r := range snapshot.Roles {
+		if managed(r.Labels, r.Annotations) {
+			desired.objects[DriftObject{Kind: "Role", Namespace: r.Namespace, Name: r.Name}] = true
+		}
+	}
+	for _, crb := range snapshot.ClusterRoleBindings {
+		if managed(crb.Labels, crb.Annotations) {
+			desired.objects[DriftObject{Kind: "ClusterRoleBinding", Name: crb.Name}] = true
+		}
+	}
+	for _, rb := range snapshot.RoleBindings {
+		if managed(rb.Labels, rb.Annotations) {
+			desired.objects[DriftObject{Kind: "RoleBinding", Namespace: rb.Namespace, Name: rb.Name}] = true
+		}
+	}
+	return desired
+}
+
+// DetectDrift compares the live objects of the snapshot with the desired ones. Filter the system objects
+// out of the snapshot first, see WithoutSystem, unless they are managed in Git too.
+func DetectDrift(snapshot *RBACSnapshot, desired *DesiredRBAC) *DriftReport {
+	report := &DriftReport{
+		APIVersion:  DriftReportAPIVersion,
+		GeneratedAt: time.Now(),
+		Source:      desired.Source,
+		Unmanaged:   []DriftObject{},
+		Missing:     []DriftObject{},
+	}
+	live := map[DriftObject]bool{}
+	for _, cr := range snapshot.ClusterRoles {
+		live[DriftObject{Kind: "ClusterRole", Name: cr.Name}] = true
+	}
+	for _, r := range snapshot.Roles {
+		live[DriftObject{Kind: "Role", Namespace: r.Namespace, Name: r.Name}] = true
+	}
+	for _, crb := range snapshot.ClusterRoleBindings {
+		object := DriftObject{Kind: "ClusterRoleBinding", Name: crb.Name}
+		live[object] = true
+		if !desired.objects[object] {
+			report.Unmanaged = append(report.Unmanaged, object)
+		}
+	}
+	for _, rb := range snapshot.RoleBindings {
+		object := DriftObject{Kind: "RoleBinding", Namespace: rb.Namespace, Name: rb.Name}
+		live[object] = true
+		if !desired.objects[object] {
+			report.Unmanaged = append(report.Unmanaged, object)
+		}
+	}
+	for object := range desired.objects {
+		if !live[object] {
+			report.Missing = append(report.Missing, object)
+		}
+	}
+	sortDriftObjects(report.Unmanaged)
+	sortDriftObjects(report.Missing)
+	return report
+}
+
+// DriftObjectColumns are the columns of the drift report lists.
+var DriftObjectColumns = []Column[DriftObject]{
+	{Header: "KIND", Value: func(in DriftObject) string { return in.Kind }},
+	{Header: "NAMESPACE", Value: func(in DriftObject) string { return in.Namespace }},
+	{Header: "NAME", Value: func(in DriftObject) string { return in.Name }},
+}
+
+func sortDriftObjects(objects []DriftObject) {
+	sort.Slice(objects, func(i, j int) bool {
+		a, b := objects[i], objects[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+}
@@ -0,0 +1,135 @@
+package business
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestManifests writes the files, keyed by their path relative to the returned temporary directory.
+func writeTestManifests(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	return dir
+}
+
+func TestLoadDesiredRBAC(t *testing.T) {
+	dir := writeTestManifests(t, map[string]string{
+		"cluster.yaml": `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: pod-reader
+  namespace: ignored
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: bob-pods
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: not-rbac
+`,
+		"teams/ns1/bindings.yml": `
+apiVersion: v1
+kind: List
+items:
+- apiVersion: rbac.authorization.k8s.io/v1
+  kind: RoleBinding
+  metadata:
+    name: alice-pods
+- apiVersion: rbac.authorization.k8s.io/v1
+  kind: RoleBinding
+  metadata:
+    name: developers-deployments
+    namespace: ns1
+`,
+		"teams/ns1/role.json": `{"apiVersion": "rbac.authorization.k8s.io/v1", "kind": "Role", "metadata": {"name": "deployment-editor", "namespace": "ns1"}}`,
+		"README.md":           "apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: readme\n",
+		".git/config.yaml":    "apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: hidden\n",
+	})
+
+	desired, err := LoadDesiredRBAC(dir, "ns1")
+	require.NoError(t, err)
+	assert.Equal(t, dir, desired.Source)
+	assert.Equal(t, map[DriftObject]bool{
+		{Kind: "ClusterRole", Name: "pod-reader"}:                               true,
+		{Kind: "ClusterRoleBinding", Name: "bob-pods"}:                          true,
+		{Kind: "RoleBinding", Namespace: "ns1", Name: "alice-pods"}:             true,
+		{Kind: "RoleBinding", Namespace: "ns1", Name: "developers-deployments"}: true,
+		{Kind: "Role", Namespace: "ns1", Name: "deployment-editor"}:             true,
+	}, desired.objects)
+
+	_, err = LoadDesiredRBAC(writeTestManifests(t, map[string]string{"invalid.yaml": "kind: [Role"}), "default")
+	assert.ErrorContains(t, err, "invalid.yaml")
+}
+
+func TestDetectDrift(t *testing.T) {
+	dir := writeTestManifests(t, map[string]string{
+		"rbac.yaml": `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: bob-pods
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: alice-pods
+  namespace: ns1
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: carol-pods
+  namespace: ns2
+`,
+	})
+	desired, err := LoadDesiredRBAC(dir, "default")
+	require.NoError(t, err)
+
+	report := DetectDrift(testSnapshot(podReaderObjects()...), desired)
+	assert.Equal(t, DriftReportAPIVersion, report.APIVersion)
+	assert.True(t, report.HasDrift())
+	// Live roles are never unmanaged, only bindings grant access
+	assert.Equal(t, []DriftObject{{Kind: "RoleBinding", Namespace: "ns1", Name: "developers-deployments"}}, report.Unmanaged)
+	assert.Equal(t, []DriftObject{{Kind: "RoleBinding", Namespace: "ns2", Name: "carol-pods"}}, report.Missing)
+
+	// Missing objects are not drift
+	report = DetectDrift(testSnapshot(podReaderObjects()[:4]...), desired)
+	assert.False(t, report.HasDrift())
+	assert.Len(t, report.Missing, 1)
+}
+
+func TestDesiredFromGitOpsLabels(t *testing.T) {
+	objects := podReaderObjects()
+	crb := testClusterRoleBinding("argo-pods", "pod-reader", testUser("carol"))
+	crb.Labels = map[string]string{"app.kubernetes.io/instance": "rbac"}
+	rb := testRoleBinding("ns2", "flux-pods", "ClusterRole", "pod-reader", testUser("carol"))
+	rb.Annotations = map[string]string{"kustomize.toolkit.fluxcd.io/name": "rbac"}
+	snapshot := testSnapshot(append(objects, crb, rb)...)
+
+	desired := DesiredFromGitOpsLabels(snapshot)
+	assert.Equal(t, map[DriftObject]bool{
+		{Kind: "ClusterRoleBinding", Name: "argo-pods"}:            true,
+		{Kind: "RoleBinding", Namespace: "ns2", Name: "flux-pods"}: true,
+	}, desired.objects)
+
+	report := DetectDrift(snapshot, desired)
+	assert.Equal(t, []DriftObject{
+		{Kind: "ClusterRoleBinding", Name: "bob-pods"},
+		{Kind: "RoleBinding", Namespace: "ns1", Name: "alice-pods"},
+		{Kind: "RoleBinding", Namespace: "ns1", Name: "developers-deployments"},
+	}, report.Unmanaged)
+	assert.Empty(t, report.Missing)
+}
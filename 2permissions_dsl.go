@@ -0,0 +1,124 @@
+package business
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// namespacePrefix prefixes the namespace of the compact form of the access requests.
+const namespacePrefix = "ns/"
+
+// ParseAccessRequests parses the compact form of access requests, readable in the CLI, the config files
+// and the tests:
+//
+//	VERB[,VERB...] RESOURCE[.GROUP][/SUBRESOURCE] [NAME] [in ns/NAMESPACE]
+//
+// e.g. "get,list deployments.apps in ns/prod" or "get pods/log web-0 in ns/prod". The resource and its
+// group are given like in kubectl auth can-i. Requests without a namespace are cluster-wide. One request
+// is returned per verb, in their order.
+func ParseAccessRequests(text string) ([]AccessRequest, error) {
+	fields := strings.Fields(text)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid access request %q, expected VERB[,VERB...] RESOURCE[.GROUP][/SUBRESOURCE] [NAME] [in ns/NAMESPACE]", text)
+	}
+
+	req := AccessRequest{}
+	resource, subresource, _ := strings.Cut(fields[1], "/")
+	req.Resource, req.APIGroup, _ = strings.Cut(resource, ".")
+	req.Subresource = subresource
+	if req.Resource == "" || strings.Contains(subresource, "/") {
+		return nil, fmt.Errorf("invalid resource %q in access request %q", fields[1], text)
+	}
+
+	rest := fields[2:]
+	if len(rest) > 0 && rest[0] != "in" {
+		req.Name = rest[0]
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		namespace, ok := strings.CutPrefix(strings.Join(rest[1:], " "), namespacePrefix)
+		if rest[0] != "in" || len(rest) != 2 || !ok || namespace == "" {
+			return nil, fmt.Errorf("invalid access request %q, expected the namespace as \"in ns/NAMESPACE\" after the resource and name", text)
+		}
+		req.Namespace = namespace
+	}
+
+	requests := []AccessRequest{}
+	for _, verb := range strings.Split(fields[0], ",") {
+		if verb == "" {
+			return nil, fmt.Errorf("empty verb in access request %q", text)
+		}
+		req.Verb = verb
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+// ParseAccessRequest parses the compact form of a single access request, see ParseAccessRequests.
+func ParseAccessRequest(text string) (AccessRequest, error) {
+	requests, err := ParseAccessRequests(text)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+	if len(requests) != 1 {
+		return AccessRequest{}, fmt.Errorf("invalid access request %q, expected a single verb", text)
+	}
+	return requests[0], nil
+}
+
+// FormatAccessRequest returns the compact form of the request, see ParseAccessRequests. The field and
+// label selectors have no compact form and are left out.
+func FormatAccessRequest(req AccessRequest) string {
+	resource := req.Resource
+	if req.APIGroup != "" {
+		resource += "." + req.APIGroup
+	}
+	if req.Subresource != "" {
+		resource += "/" + req.Subresource
+	}
+	text := req.Verb + " " + resource
+	if req.Name != "" {
+		text += " " + req.Name
+	}
+	if req.Namespace != "" {
+		text += " in " + namespacePrefix + req.Namespace
+	}
+	return text
+}
+
+// AccessRequests are the access requests of the config files, given either in the compact form of
+// ParseAccessRequests or as mappings of the fields of AccessRequest, e.g.
+//
+//	warm_up_requests:
+//	- get,list pods in ns/prod
+//	- verb: get
+//	  resource: pods
+//	  subresource: log
+type AccessRequests []AccessRequest
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (in *AccessRequests) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.SequenceNode {
+		return fmt.Errorf("line %d: expected a list of access requests", value.Line)
+	}
+	requests := AccessRequests{}
+	for _, item := range value.Content {
+		if item.Kind == yaml.ScalarNode {
+			parsed, err := ParseAccessRequests(item.Value)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", item.Line, err)
+			}
+			requests = append(requests, parsed...)
+			continue
+		}
+		req := AccessRequest{}
+		if err := item.Decode(&req); err != nil {
+			return err
+		}
+		requests = append(requests, req)
+	}
+	*in = requests
+	return nil
+}
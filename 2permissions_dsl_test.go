@@ -0,0 +1,79 @@
+package business
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAccessRequests(t *testing.T) {
+	requests, err := ParseAccessRequests("get,list deployments.apps in ns/prod")
+	require.NoError(t, err)
+	assert.Equal(t, []AccessRequest{
+		{Namespace: "prod", APIGroup: "apps", Resource: "deployments", Verb: "get"},
+		{Namespace: "prod", APIGroup: "apps", Resource: "deployments", Verb: "list"},
+	}, requests)
+
+	for text, expected := range map[string]AccessRequest{
+		"get pods/log web-0 in ns/prod":     {Namespace: "prod", Resource: "pods", Subresource: "log", Name: "web-0", Verb: "get"},
+		"list ingresses.networking.k8s.io":  {APIGroup: "networking.k8s.io", Resource: "ingresses", Verb: "list"},
+		"update deployments.apps/scale web": {APIGroup: "apps", Resource: "deployments", Subresource: "scale", Name: "web", Verb: "update"},
+		"  delete   namespaces   team-a  ":  {Resource: "namespaces", Name: "team-a", Verb: "delete"},
+	} {
+		req, err := ParseAccessRequest(text)
+		require.NoError(t, err, text)
+		assert.Equal(t, expected, req, text)
+	}
+
+	for _, text := range []string{
+		"",
+		"get",
+		"get .apps",
+		"get pods/log/extra",
+		"get,,list pods",
+		"get pods in prod",
+		"get pods in ns/",
+		"get pods web in ns/prod extra",
+		"get pods web-0 web-1",
+	} {
+		_, err := ParseAccessRequests(text)
+		assert.Error(t, err, text)
+	}
+	_, err = ParseAccessRequest("get,list pods")
+	assert.Error(t, err)
+}
+
+func TestFormatAccessRequest(t *testing.T) {
+	for _, text := range []string{
+		"get pods/log web-0 in ns/prod",
+		"list deployments.apps",
+		"update deployments.apps/scale web in ns/prod",
+	} {
+		req, err := ParseAccessRequest(text)
+		require.NoError(t, err)
+		assert.Equal(t, text, FormatAccessRequest(req))
+	}
+}
+
+func TestAccessRequestsUnmarshalYAML(t *testing.T) {
+	var requests AccessRequests
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- get,list pods in ns/prod
+- verb: get
+  resource: pods
+  subresource: log
+`), &requests))
+	assert.Equal(t, AccessRequests{
+		{Namespace: "prod", Resource: "pods", Verb: "get"},
+		{Namespace: "prod", Resource: "pods", Verb: "list"},
+		{Resource: "pods", Subresource: "log", Verb: "get"},
+	}, requests)
+
+	assert.Error(t, yaml.Unmarshal([]byte("get pods"), &requests))
+	err := yaml.Unmarshal([]byte("- get pods\n- get\n"), &requests)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+}
@@ -0,0 +1,232 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+)
+
+// AccessRequest describes a single action on a resource, like the resource attributes
+// of a SubjectAccessReview. An empty Namespace means a cluster-scoped request.
+type AccessRequest struct {
+	Namespace   string `yaml:"namespace"`
+	APIGroup    string `yaml:"api_group"`
+	Resource    string `yaml:"resource"`
+	Subresource string `yaml:"subresource"`
+	Name        string `yaml:"name"`
+	Verb        string `yaml:"verb"`
+	// FieldSelector and LabelSelector scope list, watch and deletecollection requests, e.g.
+	// spec.nodeName=node1. They are passed to the authorizers of Kubernetes 1.31+ clusters. RBAC
+	// cannot grant selector-scoped access, so the local evaluation ignores them.
+	FieldSelector string `yaml:"field_selector"`
+	LabelSelector string `yaml:"label_selector"`
+}
+
+// PermissionPath is one chain of RBAC objects granting an action: the binding,
+// the subject of the binding matching the user, the bound role and the matching rule.
+type PermissionPath struct {
+	BindingKind string
+	BindingName string
+	// Namespace of the binding. Empty for ClusterRoleBindings.
+	Namespace string
+	Subject   rbac_v1.Subject
+	RoleRef   rbac_v1.RoleRef
+	Rule      rbac_v1.PolicyRule
+}
+
+// String returns a human readable description of the path.
+func (p PermissionPath) String() string {
+	binding := p.BindingKind + " " + p.BindingName
+	if p.Namespace != "" {
+		binding = p.BindingKind + " " + p.Namespace + "/" + p.BindingName
+	}
+	return fmt.Sprintf("%s binds %s %s to %s %s, whose rule allows verbs [%s] on resources [%s] of API groups [%s]",
+		binding, p.RoleRef.Kind, p.RoleRef.Name, p.Subject.Kind, p.Subject.Name,
+		strings.Join(p.Rule.Verbs, ","), strings.Join(p.Rule.Resources, ","), strings.Join(p.Rule.APIGroups, ","))
+}
+
+// Explanation is the justification of an authorization decision made on an RBACSnapshot.
+// The request is allowed if there is at least one path.
+type Explanation struct {
+	User    UserInfo
+	Request AccessRequest
+	Allowed bool
+	Paths   []PermissionPath
+}
+
+// Explain evaluates the request for the user against the snapshot and returns every
+// binding, role and rule that allows it, e.g. to answer why a user can delete deployments.
+// RBAC ignores the extra attributes of the user, so restrictions like token scopes are not
+// applied: use a PermissionChecker when they matter. A rule the evaluation cannot handle denies the
+// request, instead of crashing the process.
+func (in *RBACSnapshot) Explain(user UserInfo, attrs AccessRequest) (explanation *Explanation) {
+	defer func() {
+		if r := recover(); r != nil {
+			logPanic(context.Background(), DecisionSourceLocalRBAC, r)
+			explanation = &Explanation{User: user, Request: attrs, Paths: []PermissionPath{}}
+		}
+	}()
+	explanation = &Explanation{User: user, Request: attrs, Paths: []PermissionPath{}}
+	for _, grant := range in.Grants() {
+		if !grantAppliesTo(grant, attrs.Namespace) || !subjectMatches(grant.Subject, grant.Namespace, user) {
+			continue
+		}
+		for _, rule := range grant.Rules {
+			if ruleAllows(rule, attrs) {
+				explanation.Paths = append(explanation.Paths, PermissionPath{
+					BindingKind: grant.BindingKind,
+					BindingName: grant.BindingName,
+					Namespace:   grant.Namespace,
+					Subject:     grant.Subject,
+					RoleRef:     grant.RoleRef,
+					Rule:        rule,
+				})
+			}
+		}
+	}
+	explanation.Allowed = len(explanation.Paths) > 0
+	return explanation
+}
+
+// grantAppliesTo returns true if the grant is effective in the namespace. Cluster-wide grants
+// apply everywhere; namespaced grants never apply to cluster-scoped requests.
+func grantAppliesTo(grant RoleGrant, namespace string) bool {
+	return grant.Namespace == "" || grant.Namespace == namespace
+}
+
+// subjectMatches returns true if the binding subject refers to the user, directly,
+// through one of its groups or as a ServiceAccount.
+func subjectMatches(subject rbac_v1.Subject, bindingNamespace string, user UserInfo) bool {
+	switch subject.Kind {
+	case rbac_v1.UserKind:
+		return subject.Name == user.Name
+	case rbac_v1.GroupKind:
+		for _, group := range user.Groups {
+			if group == subject.Name {
+				return true
+			}
+		}
+	case rbac_v1.ServiceAccountKind:
+		namespace := subject.Namespace
+		if namespace == "" {
+			namespace = bindingNamespace
+		}
+		return user.Name == "system:serviceaccount:"+namespace+":"+subject.Name
+	}
+	return false
+}
+
+// ruleAllows implements the RBAC matching semantics of a PolicyRule against a request,
+// including wildcards, subresources and resource names.
+func ruleAllows(rule rbac_v1.PolicyRule, attrs AccessRequest) bool {
+	return verbMatches(rule, attrs.Verb) &&
+		apiGroupMatches(rule, attrs.APIGroup) &&
+		resourceMatches(rule, attrs.Resource, attrs.Subresource) &&
+		resourceNameMatches(rule, attrs.Name)
+}
+
+func verbMatches(rule rbac_v1.PolicyRule, verb string) bool {
+	for _, v := range rule.Verbs {
+		if v == rbac_v1.VerbAll || v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+func apiGroupMatches(rule rbac_v1.PolicyRule, apiGroup string) bool {
+	for _, g := range rule.APIGroups {
+		if g == rbac_v1.APIGroupAll || g == apiGroup {
+			return true
+		}
+	}
+	return false
+}
+
+func resourceMatches(rule rbac_v1.PolicyRule, resource, subresource string) bool {
+	combined := resource
+	if subresource != "" {
+		combined = resource + "/" + subresource
+	}
+	for _, r := range rule.Resources {
+		if r == rbac_v1.ResourceAll || r == combined {
+			return true
+		}
+		// A rule can also match any resource having the subresource, e.g. */scale
+		if subresource != "" && r == "*/"+subresource {
+			return true
+		}
+	}
+	return false
+}
+
+func resourceNameMatches(rule rbac_v1.PolicyRule, name string) bool {
+	if len(rule.ResourceNames) == 0 {
+		return true
+	}
+	for _, n := range rule.ResourceNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// EffectivePermissions returns every individual permission granted to the user by the snapshot.
+// The Namespace of each permission is the namespace of the granting RoleBinding, or empty for
+// permissions granted cluster-wide. Wildcards are not expanded.
+func (in *RBACSnapshot) EffectivePermissions(user UserInfo) map[AccessRequest]bool {
+	permissions := map[AccessRequest]bool{}
+	for permission := range in.Permissions(user) {
+		permissions[permission] = true
+	}
+	return permissions
+}
+
+// Decision returns the explanation as a Decision, with the first permission path as reason.
+func (e *Explanation) Decision() Decision {
+	decision := Decision{Allowed: e.Allowed, Source: DecisionSourceLocalRBAC, Timestamp: time.Now()}
+	if e.Allowed {
+		decision.Reason = e.Paths[0].String()
+	}
+	return decision
+}
+
+// WhoCan returns the subjects of the bindings allowing the request, sorted and without duplicates.
+// Groups are returned as such, not expanded to their members.
+func (in *RBACSnapshot) WhoCan(attrs AccessRequest) []rbac_v1.Subject {
+	return in.whoCan(attrs, func(grant RoleGrant) bool { return grantAppliesTo(grant, attrs.Namespace) })
+}
+
+// whoCan returns the subjects of the grants allowing the request among those that apply, see WhoCan.
+func (in *RBACSnapshot) whoCan(attrs AccessRequest, applies func(RoleGrant) bool) []rbac_v1.Subject {
+	seen := map[rbac_v1.Subject]bool{}
+	subjects := []rbac_v1.Subject{}
+	for _, grant := range in.Grants() {
+		if !applies(grant) || seen[grant.Subject] {
+			continue
+		}
+		for _, rule := range grant.Rules {
+			if ruleAllows(rule, attrs) {
+				seen[grant.Subject] = true
+				subjects = append(subjects, grant.Subject)
+				break
+			}
+		}
+	}
+	sort.Slice(subjects, func(i, j int) bool {
+		a, b := subjects[i], subjects[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return subjects
+}
@@ -0,0 +1,149 @@
+package business
+
+import (
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+	alice := UserInfo{Name: "alice"}
+
+	explanation := snapshot.Explain(alice, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"})
+	assert.True(t, explanation.Allowed)
+	require.Len(t, explanation.Paths, 1)
+	path := explanation.Paths[0]
+	assert.Equal(t, "RoleBinding", path.BindingKind)
+	assert.Equal(t, "alice-pods", path.BindingName)
+	assert.Equal(t, "ns1", path.Namespace)
+	assert.Equal(t, "pod-reader", path.RoleRef.Name)
+	assert.Equal(t, "RoleBinding ns1/alice-pods binds ClusterRole pod-reader to User alice, whose rule allows verbs [get,list,watch] on resources [pods] of API groups []", path.String())
+
+	decision := explanation.Decision()
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, DecisionSourceLocalRBAC, decision.Source)
+	assert.Equal(t, path.String(), decision.Reason)
+
+	// The RoleBinding does not apply to the other namespaces, nor to cluster-scoped requests
+	for _, namespace := range []string{"ns2", ""} {
+		explanation = snapshot.Explain(alice, AccessRequest{Namespace: namespace, Resource: "pods", Verb: "get"})
+		assert.False(t, explanation.Allowed, namespace)
+		assert.Empty(t, explanation.Paths, namespace)
+		assert.Empty(t, explanation.Decision().Reason)
+	}
+
+	explanation = snapshot.Explain(alice, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "delete"})
+	assert.False(t, explanation.Allowed)
+}
+
+func TestExplainGroupsAndServiceAccounts(t *testing.T) {
+	snapshot := testSnapshot(append(podReaderObjects(),
+		testRoleBinding("ns1", "builder-pods", "ClusterRole", "pod-reader", rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Name: "builder"}),
+	)...)
+	deployments := AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "update"}
+
+	assert.True(t, snapshot.Explain(UserInfo{Name: "carol", Groups: []string{"developers"}}, deployments).Allowed)
+	assert.False(t, snapshot.Explain(UserInfo{Name: "carol", Groups: []string{"ops"}}, deployments).Allowed)
+	// The group of a rule must match
+	deployments.APIGroup = "extensions"
+	assert.False(t, snapshot.Explain(UserInfo{Name: "carol", Groups: []string{"developers"}}, deployments).Allowed)
+
+	// ServiceAccount subjects without namespace are in the namespace of the binding
+	pods := AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "list"}
+	assert.True(t, snapshot.Explain(UserInfo{Name: "system:serviceaccount:ns1:builder"}, pods).Allowed)
+	assert.False(t, snapshot.Explain(UserInfo{Name: "system:serviceaccount:ns2:builder"}, pods).Allowed)
+}
+
+func TestExplainListsEveryPath(t *testing.T) {
+	snapshot := testSnapshot(append(podReaderObjects(),
+		testClusterRoleBinding("alice-pods-everywhere", "pod-reader", testUser("alice")),
+	)...)
+
+	explanation := snapshot.Explain(UserInfo{Name: "alice"}, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"})
+	assert.Len(t, explanation.Paths, 2)
+}
+
+func TestRuleAllows(t *testing.T) {
+	cases := map[string]struct {
+		rule    rbac_v1.PolicyRule
+		request AccessRequest
+		allowed bool
+	}{
+		"exact": {
+			rule:    testRule([]string{""}, []string{"pods"}, []string{"get"}),
+			request: AccessRequest{Resource: "pods", Verb: "get"},
+			allowed: true,
+		},
+		"wildcards": {
+			rule:    testRule([]string{"*"}, []string{"*"}, []string{"*"}),
+			request: AccessRequest{APIGroup: "apps", Resource: "deployments", Subresource: "scale", Verb: "patch"},
+			allowed: true,
+		},
+		"resource without its subresource": {
+			rule:    testRule([]string{""}, []string{"pods"}, []string{"get"}),
+			request: AccessRequest{Resource: "pods", Subresource: "log", Verb: "get"},
+		},
+		"subresource": {
+			rule:    testRule([]string{""}, []string{"pods/log"}, []string{"get"}),
+			request: AccessRequest{Resource: "pods", Subresource: "log", Verb: "get"},
+			allowed: true,
+		},
+		"subresource of any resource": {
+			rule:    testRule([]string{"apps"}, []string{"*/scale"}, []string{"update"}),
+			request: AccessRequest{APIGroup: "apps", Resource: "statefulsets", Subresource: "scale", Verb: "update"},
+			allowed: true,
+		},
+		"resource name": {
+			rule:    rbac_v1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{"settings"}, Verbs: []string{"get"}},
+			request: AccessRequest{Resource: "configmaps", Name: "settings", Verb: "get"},
+			allowed: true,
+		},
+		"other resource name": {
+			rule:    rbac_v1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{"settings"}, Verbs: []string{"get"}},
+			request: AccessRequest{Resource: "configmaps", Name: "secrets", Verb: "get"},
+		},
+		"resource names never allow unnamed requests": {
+			rule:    rbac_v1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{"settings"}, Verbs: []string{"list"}},
+			request: AccessRequest{Resource: "configmaps", Verb: "list"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.allowed, ruleAllows(tc.rule, tc.request))
+		})
+	}
+}
+
+func TestWhoCan(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+
+	assert.Equal(t, []rbac_v1.Subject{testUser("alice"), testUser("bob")},
+		snapshot.WhoCan(AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}))
+	assert.Equal(t, []rbac_v1.Subject{testUser("bob")},
+		snapshot.WhoCan(AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "get"}))
+	assert.Equal(t, []rbac_v1.Subject{testGroup("developers")},
+		snapshot.WhoCan(AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "patch"}))
+	assert.Empty(t, snapshot.WhoCan(AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"}))
+}
+
+func TestEffectivePermissions(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+
+	permissions := snapshot.EffectivePermissions(UserInfo{Name: "alice"})
+	assert.Equal(t, map[AccessRequest]bool{
+		{Namespace: "ns1", Resource: "pods", Verb: "get"}:   true,
+		{Namespace: "ns1", Resource: "pods", Verb: "list"}:  true,
+		{Namespace: "ns1", Resource: "pods", Verb: "watch"}: true,
+	}, permissions)
+}
+
+func TestExplainIgnoresSelectors(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+
+	explanation := snapshot.Explain(UserInfo{Name: "alice"}, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "list", FieldSelector: "spec.nodeName=node1", LabelSelector: "app=web"})
+	assert.True(t, explanation.Allowed)
+}
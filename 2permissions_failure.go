@@ -0,0 +1,79 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kiali/kiali/log"
+)
+
+// FailurePolicy controls what a PermissionChecker does when the request cannot be evaluated, e.g. when
+// the apiserver times out. Errors of the cache backend are not evaluation errors: the authorizers are
+// asked instead.
+//
+// Only the errors failing the authorizer chain reach the failure policy, i.e. the ones of the authorizers
+// whose on_error is fail, see AuthorizerConfig.OnError. The errors of the authorizers whose on_error is
+// deny or skip are handled by the chain, as denials or as no opinion, and never reach the failure policy:
+// with them, a fail-open policy does not allow the requests the apiserver could not evaluate.
+type FailurePolicy string
+
+const (
+	// FailurePolicyClosed denies the request.
+	FailurePolicyClosed FailurePolicy = "fail-closed"
+	// FailurePolicyOpen allows the request, for deployments where availability matters more than access control.
+	FailurePolicyOpen FailurePolicy = "fail-open"
+	// FailurePolicyError returns the error to the caller, which decides. It is the default, like before the
+	// failure policies.
+	FailurePolicyError FailurePolicy = "error"
+)
+
+// DecisionSourceFailurePolicy is the source of the decisions made by the failure policy.
+const DecisionSourceFailurePolicy = "failure-policy"
+
+// failurePolicyTriggers counts the evaluation errors handled by the failure policies.
+var failurePolicyTriggers = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "kiali_permissions_failure_policy_total",
+	Help: "Number of permission checks that failed to evaluate, partitioned by the failure policy applied.",
+}, []string{"policy"})
+
+// RegisterFailurePolicyMetrics registers kiali_permissions_failure_policy_total{policy} in the registry, so
+// operators can see how often the failure policy decides instead of the authorizers.
+func RegisterFailurePolicyMetrics(registry prometheus.Registerer) error {
+	return registry.Register(failurePolicyTriggers)
+}
+
+func validateFailurePolicy(policy FailurePolicy) error {
+	switch policy {
+	case "", FailurePolicyClosed, FailurePolicyOpen, FailurePolicyError:
+		return nil
+	default:
+		return fmt.Errorf("unknown failure policy %q, expected %s, %s or %s", policy, FailurePolicyClosed, FailurePolicyOpen, FailurePolicyError)
+	}
+}
+
+// applyFailurePolicy handles the evaluation error of the request according to the policy. The empty
+// policy means FailurePolicyError.
+func applyFailurePolicy(ctx context.Context, policy FailurePolicy, user UserInfo, req AccessRequest, err error) (Decision, error) {
+	if policy == "" {
+		policy = FailurePolicyError
+	}
+	failurePolicyTriggers.WithLabelValues(string(policy)).Inc()
+
+	decision := Decision{EvaluationError: err.Error(), Source: DecisionSourceFailurePolicy, Timestamp: time.Now()}
+	switch policy {
+	case FailurePolicyOpen:
+		log.Warningf("%sAllowing %s of %s to user %s on evaluation error (fail-open): %v", logPrefix(ctx), req.Verb, req.Resource, redactedUser(user.Name), err)
+		decision.Allowed = true
+		decision.Reason = "allowed by the fail-open policy on evaluation error"
+		return decision, nil
+	case FailurePolicyClosed:
+		decision.Denied = true
+		decision.Reason = "denied by the fail-closed policy on evaluation error"
+		return decision, nil
+	default:
+		return decision, err
+	}
+}
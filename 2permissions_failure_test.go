@@ -0,0 +1,82 @@
+package business
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckerFailurePolicies(t *testing.T) {
+	cases := []struct {
+		policy  FailurePolicy
+		allowed bool
+		err     bool
+	}{
+		{policy: "", err: true},
+		{policy: FailurePolicyClosed, allowed: false},
+		{policy: FailurePolicyOpen, allowed: true},
+		{policy: FailurePolicyError, err: true},
+	}
+	for _, c := range cases {
+		t.Run(string(c.policy), func(t *testing.T) {
+			conf := NewPermissionsConfig()
+			conf.FailurePolicy = c.policy
+			checker := newTestChecker(&testReviews{err: errTestAPIServer}, conf)
+			label := string(c.policy)
+			if label == "" {
+				label = string(FailurePolicyError)
+			}
+			triggered := testutil.ToFloat64(failurePolicyTriggers.WithLabelValues(label))
+
+			decision, err := checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+			if c.err {
+				assert.ErrorIs(t, err, errTestAPIServer)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, c.allowed, decision.Allowed)
+			assert.Equal(t, !c.allowed && !c.err, decision.Denied)
+			assert.Equal(t, DecisionSourceFailurePolicy, decision.Source)
+			assert.Contains(t, decision.EvaluationError, errTestAPIServer.Error())
+			assert.Equal(t, triggered+1, testutil.ToFloat64(failurePolicyTriggers.WithLabelValues(label)))
+		})
+	}
+}
+
+func TestCheckerRejectsUnknownFailurePolicies(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.FailurePolicy = "fail-sometimes"
+	assert.ErrorContains(t, newTestChecker(&testReviews{}, nil).ApplyConfig(conf), `unknown failure policy "fail-sometimes"`)
+}
+
+func TestLoadPermissionsConfigFailurePolicy(t *testing.T) {
+	t.Setenv(PermissionsConfigEnvPrefix+"FAILURE_POLICY", string(FailurePolicyOpen))
+	conf, err := LoadPermissionsConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, FailurePolicyOpen, conf.FailurePolicy)
+}
+
+func TestRegisterFailurePolicyMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	require.NoError(t, RegisterFailurePolicyMetrics(registry))
+	assert.Error(t, RegisterFailurePolicyMetrics(registry))
+}
+
+func TestFailurePolicyOnlyHandlesTheChainErrors(t *testing.T) {
+	failing := testAuthorizerConfig("fail", "true")
+	failing.OnError = AuthorizerOnErrorDeny
+	conf := NewPermissionsConfig()
+	conf.FailurePolicy = FailurePolicyOpen
+	conf.Authorizers = []AuthorizerConfig{failing}
+	checker := newTestChecker(&testReviews{}, conf)
+
+	// The error is a denial of the chain, not an evaluation error for the fail-open policy
+	decision, err := checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+	assert.True(t, decision.Denied)
+	assert.Equal(t, testAuthorizerName, decision.Source)
+}
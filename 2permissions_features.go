@@ -0,0 +1,126 @@
+package business
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/kiali/kiali/log"
+)
+
+// Feature names an experimental subsystem that ships disabled, or enabled, until it graduates, like the
+// feature gates of Kubernetes.
+type Feature string
+
+// Feature gates
+const (
+	// FeatureLocalEvaluator compares sampled decisions with the local evaluation of the RBAC snapshot,
+	// see PermissionChecker.SetVerificationSource.
+	FeatureLocalEvaluator Feature = "LocalEvaluator"
+	// FeatureCELAuthorizer allows the cel authorizer in the authorizer chain, see NewCELAuthorizer.
+	FeatureCELAuthorizer Feature = "CELAuthorizer"
+	// FeatureHNC propagates the Roles and RoleBindings down the namespace hierarchy, see
+	// RBACSnapshot.WithHierarchy.
+	FeatureHNC Feature = "HNC"
+)
+
+// Feature maturity stages
+const (
+	FeatureAlpha = "alpha"
+	FeatureBeta  = "beta"
+)
+
+// FeatureSpec is the default state and the maturity of a feature.
+type FeatureSpec struct {
+	Default bool
+	Stage   string
+}
+
+// knownFeatures are the features that can be gated. Alpha features are disabled by default.
+var knownFeatures = map[Feature]FeatureSpec{
+	FeatureLocalEvaluator: {Default: false, Stage: FeatureAlpha},
+	FeatureCELAuthorizer:  {Default: false, Stage: FeatureAlpha},
+	FeatureHNC:            {Default: false, Stage: FeatureAlpha},
+}
+
+// FeatureGates enable or disable features by name, the others keep their default, e.g.
+//
+//	feature_gates:
+//	  CELAuthorizer: true
+//	  HNC: true
+type FeatureGates map[Feature]bool
+
+// Enabled returns whether the feature is enabled by the gates, or by default.
+func (in FeatureGates) Enabled(feature Feature) bool {
+	if enabled, ok := in[feature]; ok {
+		return enabled
+	}
+	return knownFeatures[feature].Default
+}
+
+// ParseFeatureGates parses a comma separated list of NAME=BOOL, the form of the
+// PERMISSIONS_FEATURE_GATES environment variable, e.g. "CELAuthorizer=true,HNC=false".
+func ParseFeatureGates(text string) (FeatureGates, error) {
+	gates := FeatureGates{}
+	for _, gate := range strings.Split(text, ",") {
+		if gate = strings.TrimSpace(gate); gate == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(gate, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid feature gate %q, expected NAME=BOOL", gate)
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of feature gate %s: %w", name, err)
+		}
+		gates[Feature(strings.TrimSpace(name))] = enabled
+	}
+	return gates, nil
+}
+
+func (in FeatureGates) validate() error {
+	unknown := []string{}
+	for feature := range in {
+		if _, ok := knownFeatures[feature]; !ok {
+			unknown = append(unknown, string(feature))
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	known := []string{}
+	for feature := range knownFeatures {
+		known = append(known, string(feature))
+	}
+	sort.Strings(known)
+	return fmt.Errorf("unknown features %s, expected one of %s", strings.Join(unknown, ", "), strings.Join(known, ", "))
+}
+
+// featureGates are the gates of the whole process, set by PermissionChecker.ApplyConfig, for the features
+// used from places without a config.
+var featureGates atomic.Pointer[FeatureGates]
+
+// SetFeatureGates sets the feature gates of the process.
+func SetFeatureGates(gates FeatureGates) {
+	for feature, enabled := range gates {
+		switch {
+		case enabled && !knownFeatures[feature].Default:
+			log.Infof("Feature %s (%s) is enabled", feature, knownFeatures[feature].Stage)
+		case !enabled && knownFeatures[feature].Default:
+			log.Infof("Feature %s (%s) is disabled", feature, knownFeatures[feature].Stage)
+		}
+	}
+	featureGates.Store(&gates)
+}
+
+// FeatureEnabled returns whether the feature is enabled in the process, see SetFeatureGates.
+func FeatureEnabled(feature Feature) bool {
+	if gates := featureGates.Load(); gates != nil {
+		return gates.Enabled(feature)
+	}
+	return knownFeatures[feature].Default
+}
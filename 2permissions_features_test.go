@@ -0,0 +1,49 @@
+package business
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatureGates(t *testing.T) {
+	// The alpha features are disabled by default
+	assert.False(t, FeatureGates(nil).Enabled(FeatureHNC))
+	assert.True(t, FeatureGates{FeatureHNC: true}.Enabled(FeatureHNC))
+	assert.False(t, FeatureGates{FeatureHNC: true}.Enabled(FeatureCELAuthorizer))
+
+	gates, err := ParseFeatureGates(" CELAuthorizer=true, ,HNC = false")
+	require.NoError(t, err)
+	assert.Equal(t, FeatureGates{FeatureCELAuthorizer: true, FeatureHNC: false}, gates)
+	for _, text := range []string{"CELAuthorizer", "HNC=maybe"} {
+		_, err := ParseFeatureGates(text)
+		assert.Error(t, err, text)
+	}
+
+	assert.NoError(t, gates.validate())
+	assert.ErrorContains(t, FeatureGates{"Teleport": true, "Hyperdrive": false}.validate(), "unknown features Hyperdrive, Teleport")
+}
+
+func TestFeatureGatesFromConfig(t *testing.T) {
+	path := writeTestConfig(t, "feature_gates:\n  CELAuthorizer: true\n  HNC: true\n")
+	t.Setenv(PermissionsConfigEnvPrefix+"FEATURE_GATES", "HNC=false,LocalEvaluator=true")
+
+	// The environment overrides the gates of the file one by one
+	conf, err := LoadPermissionsConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, FeatureGates{FeatureCELAuthorizer: true, FeatureHNC: false, FeatureLocalEvaluator: true}, conf.FeatureGates)
+
+	t.Cleanup(func() { SetFeatureGates(FeatureGates{}) })
+	newTestChecker(&testReviews{}, conf)
+	assert.True(t, FeatureEnabled(FeatureLocalEvaluator))
+	assert.False(t, FeatureEnabled(FeatureHNC))
+}
+
+func TestCELAuthorizerNeedsItsFeatureGate(t *testing.T) {
+	checker := newTestChecker(&testReviews{}, nil)
+	conf := NewPermissionsConfig()
+	conf.Authorizers = []AuthorizerConfig{{Name: AuthorizerCEL}}
+
+	assert.ErrorContains(t, checker.ApplyConfig(conf), string(FeatureCELAuthorizer))
+}
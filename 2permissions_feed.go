@@ -0,0 +1,198 @@
+package business
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/kiali/kiali/log"
+)
+
+// AccessFeedAPIVersion identifies the schema of the access feed. It must be bumped on any
+// incompatible change, since developer portals ingest the feed without a Kiali dependency.
+const AccessFeedAPIVersion = "kiali.io/access-feed/v1"
+
+// AccessFeed is a stable JSON document describing the access of every team (RBAC group) per
+// namespace, suitable for ingestion by Backstage or other internal developer portals.
+// All the lists of the feed are sorted, so two feeds of an unchanged cluster only differ in GeneratedAt.
+type AccessFeed struct {
+	APIVersion  string    `json:"apiVersion"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	// ListMeta paginates the teams, see ListOptions.
+	ListMeta
+	Teams []TeamAccess `json:"teams"`
+}
+
+// TeamAccess holds the access granted to a team.
+type TeamAccess struct {
+	Team       string            `json:"team"`
+	Namespaces []NamespaceAccess `json:"namespaces"`
+}
+
+// NamespaceAccess holds the access granted to a team in a namespace. The namespace
+// is "*" for access granted cluster-wide by a ClusterRoleBinding.
+type NamespaceAccess struct {
+	Namespace string           `json:"namespace"`
+	Roles     []string         `json:"roles"`
+	Resources []ResourceAccess `json:"resources"`
+}
+
+// ResourceAccess lists the verbs allowed on a resource. If ResourceNames is not empty,
+// the verbs are only allowed on the named objects.
+type ResourceAccess struct {
+	APIGroup      string   `json:"apiGroup"`
+	Resource      string   `json:"resource"`
+	ResourceNames []string `json:"resourceNames,omitempty"`
+	Verbs         []string `json:"verbs"`
+}
+
+// BuildAccessFeed builds the access feed from the Group subjects of the snapshot bindings.
+func BuildAccessFeed(snapshot *RBACSnapshot) *AccessFeed {
+	// team -> namespace -> accumulated access
+	type nsAccumulator struct {
+		roles     map[string]bool
+		resources map[string]*ResourceAccess
+	}
+	teams := map[string]map[string]*nsAccumulator{}
+
+	for _, grant := range snapshot.Grants() {
+		if grant.Subject.Kind != rbac_v1.GroupKind {
+			continue
+		}
+		namespace := grant.Namespace
+		if namespace == "" {
+			namespace = "*"
+		}
+		if teams[grant.Subject.Name] == nil {
+			teams[grant.Subject.Name] = map[string]*nsAccumulator{}
+		}
+		acc, ok := teams[grant.Subject.Name][namespace]
+		if !ok {
+			acc = &nsAccumulator{roles: map[string]bool{}, resources: map[string]*ResourceAccess{}}
+			teams[grant.Subject.Name][namespace] = acc
+		}
+		acc.roles[grant.RoleRef.Kind+"/"+grant.RoleRef.Name] = true
+
+		for _, rule := range grant.Rules {
+			names := append([]string(nil), rule.ResourceNames...)
+			sort.Strings(names)
+			for _, apiGroup := range rule.APIGroups {
+				for _, resource := range rule.Resources {
+					key := apiGroup + "|" + resource + "|" + strings.Join(names, ",")
+					ra, ok := acc.resources[key]
+					if !ok {
+						ra = &ResourceAccess{APIGroup: apiGroup, Resource: resource, ResourceNames: names}
+						acc.resources[key] = ra
+					}
+					ra.Verbs = mergeSorted(ra.Verbs, rule.Verbs)
+				}
+			}
+		}
+	}
+
+	feed := &AccessFeed{
+		APIVersion:  AccessFeedAPIVersion,
+		GeneratedAt: snapshot.LoadedAt.UTC(),
+		Teams:       make([]TeamAccess, 0, len(teams)),
+	}
+	for team, namespaces := range teams {
+		teamAccess := TeamAccess{Team: team, Namespaces: make([]NamespaceAccess, 0, len(namespaces))}
+		for namespace, acc := range namespaces {
+			nsAccess := NamespaceAccess{Namespace: namespace, Roles: sortedKeys(acc.roles), Resources: make([]ResourceAccess, 0, len(acc.resources))}
+			for _, ra := range acc.resources {
+				nsAccess.Resources = append(nsAccess.Resources, *ra)
+			}
+			sort.Slice(nsAccess.Resources, func(i, j int) bool {
+				a, b := nsAccess.Resources[i], nsAccess.Resources[j]
+				if a.APIGroup != b.APIGroup {
+					return a.APIGroup < b.APIGroup
+				}
+				if a.Resource != b.Resource {
+					return a.Resource < b.Resource
+				}
+				return strings.Join(a.ResourceNames, ",") < strings.Join(b.ResourceNames, ",")
+			})
+			teamAccess.Namespaces = append(teamAccess.Namespaces, nsAccess)
+		}
+		sort.Slice(teamAccess.Namespaces, func(i, j int) bool {
+			return teamAccess.Namespaces[i].Namespace < teamAccess.Namespaces[j].Namespace
+		})
+		feed.Teams = append(feed.Teams, teamAccess)
+	}
+	sort.Slice(feed.Teams, func(i, j int) bool {
+		return feed.Teams[i].Team < feed.Teams[j].Team
+	})
+
+	return feed
+}
+
+// AccessFeedHandler returns an HTTP handler serving the access feed of the cluster
+// reachable with the given client. The RBAC objects are read on every request. With the
+// excludeSystem=true query parameter, the system roles, subjects and bootstrap bindings are omitted.
+// The mode query parameter selects the cluster or namespaced grants only, see GrantMode. The output query
+// parameter selects the format, JSON by default, see WriteOutput. The teams are paginated, see
+// ListOptions. Responses have an ETag, which only changes with the access of the teams, so portals
+// polling the feed get a 304 when nothing changed.
+func AccessFeedHandler(client PermissionsClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mode, err := ParseGrantMode(r.URL.Query().Get("mode"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts, err := ParseListOptions(r)
+		if err != nil {
+			listError(w, err)
+			return
+		}
+		snapshot, err := LoadRBACSnapshot(r.Context(), client)
+		if err != nil {
+			log.Errorf("Error building the access feed: %v", err)
+			http.Error(w, "error reading RBAC objects", http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("excludeSystem") == "true" {
+			snapshot = snapshot.WithoutSystem(ExcludeAllSystem)
+		}
+		snapshot = snapshot.WithGrantMode(mode)
+
+		feed := currentRedaction().AccessFeed(BuildAccessFeed(snapshot))
+		hash, err := ContentHash(feed.Teams)
+		if err != nil {
+			log.Errorf("Error hashing the access feed: %v", err)
+		} else if notModified(w, r, hash) {
+			return
+		}
+		if feed.Teams, feed.ListMeta, err = paginate(feed.Teams, opts); err != nil {
+			listError(w, err)
+			return
+		}
+		writeList(w, r, feed, "teams", opts, AccessFeedTable(feed))
+	}
+}
+
+// mergeSorted returns the sorted union of both string slices, without duplicates.
+func mergeSorted(a, b []string) []string {
+	set := make(map[string]bool, len(a)+len(b))
+	for _, s := range a {
+		set[s] = true
+	}
+	for _, s := range b {
+		set[s] = true
+	}
+	return sortedKeys(set)
+}
+
+// sortedKeys returns the keys of the set in ascending order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
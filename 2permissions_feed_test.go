@@ -0,0 +1,121 @@
+package business
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildAccessFeed(t *testing.T) {
+	objects := append(podReaderObjects(),
+		testClusterRoleBinding("ops-pods", "pod-reader", testGroup("ops")),
+		testRoleBinding("ns2", "ops-deployments", "ClusterRole", "pod-reader", testGroup("ops")),
+	)
+
+	feed := BuildAccessFeed(testSnapshot(objects...))
+
+	assert.Equal(t, AccessFeedAPIVersion, feed.APIVersion)
+	require.Len(t, feed.Teams, 2)
+	assert.Equal(t, TeamAccess{
+		Team: "developers",
+		Namespaces: []NamespaceAccess{{
+			Namespace: "ns1",
+			Roles:     []string{"Role/deployment-editor"},
+			Resources: []ResourceAccess{{APIGroup: "apps", Resource: "deployments", Verbs: []string{"get", "list", "patch", "update"}}},
+		}},
+	}, feed.Teams[0])
+
+	// Access granted cluster-wide is under "*", which sorts first
+	ops := feed.Teams[1]
+	assert.Equal(t, "ops", ops.Team)
+	require.Len(t, ops.Namespaces, 2)
+	assert.Equal(t, "*", ops.Namespaces[0].Namespace)
+	assert.Equal(t, "ns2", ops.Namespaces[1].Namespace)
+	assert.Equal(t, []string{"ClusterRole/pod-reader"}, ops.Namespaces[1].Roles)
+}
+
+func TestBuildAccessFeedMergesTheVerbsOfAResource(t *testing.T) {
+	feed := BuildAccessFeed(testSnapshot(
+		testRole("ns1", "readers", testRule([]string{""}, []string{"pods"}, []string{"list", "get"})),
+		testRole("ns1", "deleters", testRule([]string{""}, []string{"pods"}, []string{"delete", "get"})),
+		testRoleBinding("ns1", "team-readers", "Role", "readers", testGroup("team")),
+		testRoleBinding("ns1", "team-deleters", "Role", "deleters", testGroup("team")),
+		testRoleBinding("ns1", "alice", "Role", "deleters", testUser("alice")),
+	))
+
+	require.Len(t, feed.Teams, 1)
+	require.Len(t, feed.Teams[0].Namespaces, 1)
+	ns := feed.Teams[0].Namespaces[0]
+	assert.Equal(t, []string{"Role/deleters", "Role/readers"}, ns.Roles)
+	assert.Equal(t, []ResourceAccess{{APIGroup: "", Resource: "pods", Verbs: []string{"delete", "get", "list"}}}, ns.Resources)
+}
+
+func TestAccessFeedHandler(t *testing.T) {
+	handler := AccessFeedHandler(newTestClient(&testReviews{}, podReaderObjects()...))
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/access-feed", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var feed AccessFeed
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &feed))
+	require.Len(t, feed.Teams, 1)
+	assert.Equal(t, "developers", feed.Teams[0].Team)
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	// Unchanged access is not sent again
+	req := httptest.NewRequest(http.MethodGet, "/access-feed", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/access-feed?mode=bogus", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAccessFeedHandlerExcludesSystem(t *testing.T) {
+	handler := AccessFeedHandler(newTestClient(&testReviews{}, append(podReaderObjects(),
+		testClusterRole("system:viewer", testRule([]string{""}, []string{"nodes"}, []string{"get"})),
+		testRoleBinding("ns1", "ops-view", "ClusterRole", "system:viewer", testGroup("ops")),
+	)...))
+
+	teams := func(target string) []string {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var feed AccessFeed
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &feed))
+		names := []string{}
+		for _, team := range feed.Teams {
+			names = append(names, team.Team)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"developers", "ops"}, teams("/access-feed"))
+	// The binding of the ops group to the system role grants real privileges, it is kept
+	assert.Equal(t, []string{"developers", "ops"}, teams("/access-feed?excludeSystem=true"))
+}
+
+func TestAccessFeedHandlerGrantMode(t *testing.T) {
+	handler := AccessFeedHandler(newTestClient(&testReviews{}, podReaderObjects()...))
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/access-feed?mode=cluster", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var feed AccessFeed
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &feed))
+	assert.Empty(t, feed.Teams)
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/access-feed?mode=namespaced", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &feed))
+	require.Len(t, feed.Teams, 1)
+	assert.Equal(t, "developers", feed.Teams[0].Team)
+}
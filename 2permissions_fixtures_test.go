@@ -0,0 +1,161 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kube_fake "k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+)
+
+// testReviews answers the access reviews of a fake clientset, counting them.
+type testReviews struct {
+	// allow decides the reviews; self reviews are made by the user "self".
+	allow func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool
+	// err fails the reviews, when set.
+	err   error
+	calls atomic.Int64
+}
+
+// newTestClient returns a PermissionsClient over a fake clientset holding the RBAC objects, whose access
+// reviews are answered by reviews.
+func newTestClient(reviews *testReviews, objects ...runtime.Object) PermissionsClient {
+	k8s := kube_fake.NewSimpleClientset(objects...)
+	k8s.PrependReactor("create", "subjectaccessreviews", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		sar := action.(k8s_testing.CreateAction).GetObject().(*auth_v1.SubjectAccessReview).DeepCopy()
+		allowed, err := reviews.review(UserInfo{Name: sar.Spec.User, Groups: sar.Spec.Groups}, sar.Spec.ResourceAttributes)
+		sar.Status.Allowed = allowed
+		return true, sar, err
+	})
+	k8s.PrependReactor("create", "selfsubjectaccessreviews", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		ssar := action.(k8s_testing.CreateAction).GetObject().(*auth_v1.SelfSubjectAccessReview).DeepCopy()
+		allowed, err := reviews.review(UserInfo{Name: "self"}, ssar.Spec.ResourceAttributes)
+		ssar.Status.Allowed = allowed
+		return true, ssar, err
+	})
+	return NewPermissionsClient(k8s)
+}
+
+func (in *testReviews) review(user UserInfo, attrs *auth_v1.ResourceAttributes) (bool, error) {
+	in.calls.Add(1)
+	if in.err != nil {
+		return false, in.err
+	}
+	return in.allow != nil && in.allow(user, attrs), nil
+}
+
+// allowUsers allows every request of the users.
+func allowUsers(names ...string) func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+	return func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+		for _, name := range names {
+			if user.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// testAuditSink keeps the audit records.
+type testAuditSink struct {
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func (in *testAuditSink) Record(ctx context.Context, record AuditRecord) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.records = append(in.records, record)
+}
+
+var errTestAPIServer = errors.New("apiserver unavailable")
+
+// newTestChecker returns a checker whose reviews are answered by reviews, with the config.
+func newTestChecker(reviews *testReviews, conf *PermissionsConfig, objects ...runtime.Object) *PermissionChecker {
+	checker := NewPermissionChecker(newTestClient(reviews, objects...))
+	if conf != nil {
+		if err := checker.ApplyConfig(conf); err != nil {
+			panic(err)
+		}
+	}
+	return checker
+}
+
+func testClusterRole(name string, rules ...rbac_v1.PolicyRule) *rbac_v1.ClusterRole {
+	return &rbac_v1.ClusterRole{ObjectMeta: meta_v1.ObjectMeta{Name: name}, Rules: rules}
+}
+
+func testRole(namespace, name string, rules ...rbac_v1.PolicyRule) *rbac_v1.Role {
+	return &rbac_v1.Role{ObjectMeta: meta_v1.ObjectMeta{Namespace: namespace, Name: name}, Rules: rules}
+}
+
+func testClusterRoleBinding(name, clusterRole string, subjects ...rbac_v1.Subject) *rbac_v1.ClusterRoleBinding {
+	return &rbac_v1.ClusterRoleBinding{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name},
+		RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: clusterRole},
+		Subjects:   subjects,
+	}
+}
+
+func testRoleBinding(namespace, name, kind, role string, subjects ...rbac_v1.Subject) *rbac_v1.RoleBinding {
+	return &rbac_v1.RoleBinding{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: namespace, Name: name},
+		RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: kind, Name: role},
+		Subjects:   subjects,
+	}
+}
+
+func testUser(name string) rbac_v1.Subject {
+	return rbac_v1.Subject{Kind: rbac_v1.UserKind, APIGroup: rbac_v1.GroupName, Name: name}
+}
+
+func testGroup(name string) rbac_v1.Subject {
+	return rbac_v1.Subject{Kind: rbac_v1.GroupKind, APIGroup: rbac_v1.GroupName, Name: name}
+}
+
+func testRule(apiGroups, resources, verbs []string) rbac_v1.PolicyRule {
+	return rbac_v1.PolicyRule{APIGroups: apiGroups, Resources: resources, Verbs: verbs}
+}
+
+// testSnapshot builds a snapshot of the objects, which are ClusterRoles, Roles and their bindings.
+func testSnapshot(objects ...runtime.Object) *RBACSnapshot {
+	var (
+		clusterRoles []rbac_v1.ClusterRole
+		roles        []rbac_v1.Role
+		crbs         []rbac_v1.ClusterRoleBinding
+		rbs          []rbac_v1.RoleBinding
+	)
+	for _, object := range objects {
+		switch o := object.(type) {
+		case *rbac_v1.ClusterRole:
+			clusterRoles = append(clusterRoles, *o)
+		case *rbac_v1.Role:
+			roles = append(roles, *o)
+		case *rbac_v1.ClusterRoleBinding:
+			crbs = append(crbs, *o)
+		case *rbac_v1.RoleBinding:
+			rbs = append(rbs, *o)
+		}
+	}
+	return NewRBACSnapshot(clusterRoles, roles, crbs, rbs)
+}
+
+// podReaderObjects are the objects of the usual fixture: alice reads the pods of ns1, bob the pods of all
+// the namespaces, and the developers group edits the deployments of ns1.
+func podReaderObjects() []runtime.Object {
+	return []runtime.Object{
+		testClusterRole("pod-reader", testRule([]string{""}, []string{"pods"}, []string{"get", "list", "watch"})),
+		testRole("ns1", "deployment-editor", testRule([]string{"apps"}, []string{"deployments"}, []string{"get", "list", "update", "patch"})),
+		testRoleBinding("ns1", "alice-pods", "ClusterRole", "pod-reader", testUser("alice")),
+		testClusterRoleBinding("bob-pods", "pod-reader", testUser("bob")),
+		testRoleBinding("ns1", "developers-deployments", "Role", "deployment-editor", testGroup("developers")),
+	}
+}
+
+var testCtx = context.Background()
@@ -0,0 +1,71 @@
+package business
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func FuzzDecisionCacheKey(f *testing.F) {
+	f.Add("alice", "admins", "ns1", "pods", "alice", "admins", "ns1", "pods")
+	f.Add("alice|admins", "", "ns1", "pods", "alice", "admins", "ns1", "pods")
+	f.Add("alice", "a,b", "ns1", "pods", "alice", "a", "b", "pods")
+	f.Add("a%7Cb", "", "ns|1", "pods", "a|b", "", "ns|1", "pods")
+	f.Fuzz(func(t *testing.T, name1, group1, namespace1, resource1, name2, group2, namespace2, resource2 string) {
+		user1, user2 := UserInfo{Name: name1, Groups: []string{group1}}, UserInfo{Name: name2, Groups: []string{group2}}
+		req1, req2 := AccessRequest{Namespace: namespace1, Resource: resource1, Verb: "get"}, AccessRequest{Namespace: namespace2, Resource: resource2, Verb: "get"}
+		key1, key2 := decisionCacheKey(user1, req1), decisionCacheKey(user2, req2)
+
+		same := name1 == name2 && group1 == group2 && namespace1 == namespace2 && resource1 == resource2
+		if (key1 == key2) != same {
+			t.Fatalf("keys %q and %q of %v %v and %v %v", key1, key2, user1, req1, user2, req2)
+		}
+		principal, request := splitDecisionCacheKey(key1)
+		if principal+"|"+request != key1 {
+			t.Fatalf("key %q split into %q and %q", key1, principal, request)
+		}
+
+		// Invalidating the slice of the first user and namespace keeps the decision of the second one,
+		// unless they share the user and the namespace
+		cache := newMemoryDecisionCache(0)
+		_ = cache.Set(testCtx, key1, Decision{Allowed: true}, time.Hour)
+		_ = cache.Set(testCtx, key2, Decision{Allowed: true}, time.Hour)
+		_ = cache.Invalidate(testCtx, CacheSlice{User: name1, Namespace: namespace1})
+		if _, found, _ := cache.Get(testCtx, key1); found && namespace1 != AllNamespacesSlice && name1 != "" {
+			t.Fatalf("decision of %v %v not invalidated", user1, req1)
+		}
+		_, found, _ := cache.Get(testCtx, key2)
+		if sharesSlice := name1 == name2 && namespace1 == namespace2; found == sharesSlice && namespace1 != AllNamespacesSlice && name1 != "" {
+			t.Fatalf("decision of %v %v found=%t after invalidating %s in %s", user2, req2, found, name1, namespace1)
+		}
+	})
+}
+
+func FuzzLoadPermissionsConfig(f *testing.F) {
+	f.Add("mode: audit\ncache_ttl: 1m\n")
+	f.Add("mode: enforce\ncache_backend: redis\nredis_address: localhost:6379\n")
+	f.Add("redaction:\n  hash_usernames: true\n")
+	f.Add("user_cache_ttls:\n- users: [alice]\n  cache_ttl: 10s\n")
+	f.Add("mode: [\n")
+	f.Fuzz(func(t *testing.T, content string) {
+		path := filepath.Join(t.TempDir(), "permissions.yaml")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		conf, err := LoadPermissionsConfig(path)
+		if err != nil {
+			return
+		}
+		// Validating twice decides the same, and the valid configs make a decision cache
+		first, second := conf.Validate(), conf.Validate()
+		if (first == nil) != (second == nil) {
+			t.Fatalf("config %q validated with %v, then with %v", content, first, second)
+		}
+		if first == nil && conf.CacheBackend != CacheBackendRedis {
+			if _, err := NewDecisionCache(conf); err != nil {
+				t.Fatalf("valid config %q without a decision cache: %v", content, err)
+			}
+		}
+	})
+}
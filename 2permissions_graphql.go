@@ -0,0 +1,295 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/kiali/kiali/log"
+)
+
+// maxGraphQLRequestBytes bounds the bodies of the GraphQL requests.
+const maxGraphQLRequestBytes = 64 << 10
+
+// graphQLRequest is the body of a GraphQL HTTP request.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// UserAccess is the access of a user, grouped by namespace. The namespace is "*" for cluster-wide access.
+type UserAccess struct {
+	Name       string            `json:"name"`
+	Groups     []string          `json:"groups"`
+	Namespaces []NamespaceAccess `json:"namespaces"`
+}
+
+// PermissionsDiff holds the permissions only one of two users has.
+type PermissionsDiff struct {
+	Gained []AccessRequest `json:"gained"`
+	Lost   []AccessRequest `json:"lost"`
+}
+
+// NewPermissionsGraphQLSchema builds an optional GraphQL schema over the RBAC data of the cluster, so UI
+// teams can fetch exactly the access data they need in one round trip:
+//
+//	user(name, groups, mode): the namespaces, resources and verbs of a user, for all, cluster or namespaced grants
+//	whoCan(verb, resource, apiGroup, subresource, namespace, name): the subjects allowed to do something
+//	diff(from, fromGroups, to, toGroups): what the "to" user can do that "from" cannot (gained), and vice versa (lost)
+//
+// The RBAC objects are read once per request of GraphQLHandler, whatever the number of fields of the query.
+func NewPermissionsGraphQLSchema(client PermissionsClient) (graphql.Schema, error) {
+	resourceType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ResourceAccess",
+		Fields: graphql.Fields{
+			"apiGroup":      &graphql.Field{Type: graphql.String},
+			"resource":      &graphql.Field{Type: graphql.String},
+			"resourceNames": &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"verbs":         &graphql.Field{Type: graphql.NewList(graphql.String)},
+		},
+	})
+	namespaceType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "NamespaceAccess",
+		Fields: graphql.Fields{
+			"namespace": &graphql.Field{Type: graphql.String},
+			"resources": &graphql.Field{Type: graphql.NewList(resourceType)},
+		},
+	})
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserAccess",
+		Fields: graphql.Fields{
+			"name":       &graphql.Field{Type: graphql.String},
+			"groups":     &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"namespaces": &graphql.Field{Type: graphql.NewList(namespaceType)},
+		},
+	})
+	subjectType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subject",
+		Fields: graphql.Fields{
+			"kind":      &graphql.Field{Type: graphql.String},
+			"name":      &graphql.Field{Type: graphql.String},
+			"namespace": &graphql.Field{Type: graphql.String},
+		},
+	})
+	permissionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Permission",
+		Fields: graphql.Fields{
+			"namespace":   &graphql.Field{Type: graphql.String},
+			"apiGroup":    &graphql.Field{Type: graphql.String},
+			"resource":    &graphql.Field{Type: graphql.String},
+			"subresource": &graphql.Field{Type: graphql.String},
+			"name":        &graphql.Field{Type: graphql.String},
+			"verb":        &graphql.Field{Type: graphql.String},
+		},
+	})
+	diffType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PermissionsDiff",
+		Fields: graphql.Fields{
+			"gained": &graphql.Field{Type: graphql.NewList(permissionType)},
+			"lost":   &graphql.Field{Type: graphql.NewList(permissionType)},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"user": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"name":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"groups": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String)},
+					"mode":   &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					mode, _ := p.Args["mode"].(string)
+					grantMode, err := ParseGrantMode(mode)
+					if err != nil {
+						return nil, err
+					}
+					snapshot, err := requestRBACSnapshot(p.Context, client)
+					if err != nil {
+						return nil, err
+					}
+					snapshot = snapshot.WithGrantMode(grantMode)
+					user := userFromArgs(p.Args, "name", "groups")
+					return &UserAccess{Name: user.Name, Groups: user.Groups, Namespaces: groupPermissionsByNamespace(snapshot.EffectivePermissions(user))}, nil
+				},
+			},
+			"whoCan": &graphql.Field{
+				Type: graphql.NewList(subjectType),
+				Args: graphql.FieldConfigArgument{
+					"verb":        &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"resource":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"apiGroup":    &graphql.ArgumentConfig{Type: graphql.String},
+					"subresource": &graphql.ArgumentConfig{Type: graphql.String},
+					"namespace":   &graphql.ArgumentConfig{Type: graphql.String},
+					"name":        &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					snapshot, err := requestRBACSnapshot(p.Context, client)
+					if err != nil {
+						return nil, err
+					}
+					attrs := AccessRequest{}
+					attrs.Verb, _ = p.Args["verb"].(string)
+					attrs.Resource, _ = p.Args["resource"].(string)
+					attrs.APIGroup, _ = p.Args["apiGroup"].(string)
+					attrs.Subresource, _ = p.Args["subresource"].(string)
+					attrs.Namespace, _ = p.Args["namespace"].(string)
+					attrs.Name, _ = p.Args["name"].(string)
+					return snapshot.WhoCan(attrs), nil
+				},
+			},
+			"diff": &graphql.Field{
+				Type: diffType,
+				Args: graphql.FieldConfigArgument{
+					"from":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"fromGroups": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String)},
+					"to":         &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"toGroups":   &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					snapshot, err := requestRBACSnapshot(p.Context, client)
+					if err != nil {
+						return nil, err
+					}
+					from := snapshot.EffectivePermissions(userFromArgs(p.Args, "from", "fromGroups"))
+					to := snapshot.EffectivePermissions(userFromArgs(p.Args, "to", "toGroups"))
+					gained, lost := diffPermissions(from, to)
+					return &PermissionsDiff{Gained: gained, Lost: lost}, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// GraphQLHandler returns an HTTP handler executing GraphQL queries, sent as JSON POST bodies, against the schema.
+func GraphQLHandler(schema graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLRequestBytes)).Decode(&req); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "GraphQL request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "invalid GraphQL request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			OperationName:  req.OperationName,
+			VariableValues: req.Variables,
+			Context:        context.WithValue(r.Context(), rbacSnapshotKey{}, &requestSnapshot{}),
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Errorf("Error writing GraphQL response: %v", err)
+		}
+	}
+}
+
+// EnableGraphQL registers the optional GraphQL endpoint at /api/graphql. Its queries tell what any user
+// can do, so the callers need list on the permissions of the users.
+func (in *PermissionsServer) EnableGraphQL() error {
+	schema, err := NewPermissionsGraphQLSchema(in.client)
+	if err != nil {
+		return err
+	}
+	in.router.Methods("POST").Path("/api/graphql").Name("PermissionsGraphQL").HandlerFunc(in.guard(GraphQLHandler(schema), listAllUserPermissions))
+	return nil
+}
+
+type rbacSnapshotKey struct{}
+
+// requestSnapshot is the RBAC snapshot shared by the resolvers of a GraphQL request.
+type requestSnapshot struct {
+	once     sync.Once
+	snapshot *RBACSnapshot
+	err      error
+}
+
+// requestRBACSnapshot returns the RBAC snapshot of the GraphQL request of ctx, loading it on the first call.
+// Without a request, e.g. when the schema is executed directly, every call loads a snapshot.
+func requestRBACSnapshot(ctx context.Context, client PermissionsClient) (*RBACSnapshot, error) {
+	shared, ok := ctx.Value(rbacSnapshotKey{}).(*requestSnapshot)
+	if !ok {
+		return LoadRBACSnapshot(ctx, client)
+	}
+	shared.once.Do(func() {
+		shared.snapshot, shared.err = LoadRBACSnapshot(ctx, client)
+	})
+	return shared.snapshot, shared.err
+}
+
+// userFromArgs builds a UserInfo from the GraphQL arguments holding its name and groups.
+func userFromArgs(args map[string]interface{}, nameArg, groupsArg string) UserInfo {
+	user := UserInfo{Groups: []string{}}
+	user.Name, _ = args[nameArg].(string)
+	if groups, ok := args[groupsArg].([]interface{}); ok {
+		for _, g := range groups {
+			if group, ok := g.(string); ok {
+				user.Groups = append(user.Groups, group)
+			}
+		}
+	}
+	return user
+}
+
+// groupPermissionsByNamespace arranges individual permissions by namespace and resource, merging the verbs.
+// Cluster-wide permissions are grouped under the "*" namespace.
+func groupPermissionsByNamespace(permissions map[AccessRequest]bool) []NamespaceAccess {
+	byNamespace := map[string]map[string]*ResourceAccess{}
+	for p := range permissions {
+		namespace := p.Namespace
+		if namespace == "" {
+			namespace = "*"
+		}
+		resource := p.Resource
+		if p.Subresource != "" {
+			resource = p.Resource + "/" + p.Subresource
+		}
+		if byNamespace[namespace] == nil {
+			byNamespace[namespace] = map[string]*ResourceAccess{}
+		}
+		key := p.APIGroup + "|" + resource + "|" + p.Name
+		ra, ok := byNamespace[namespace][key]
+		if !ok {
+			ra = &ResourceAccess{APIGroup: p.APIGroup, Resource: resource}
+			if p.Name != "" {
+				ra.ResourceNames = []string{p.Name}
+			}
+			byNamespace[namespace][key] = ra
+		}
+		ra.Verbs = mergeSorted(ra.Verbs, []string{p.Verb})
+	}
+
+	namespaces := make([]NamespaceAccess, 0, len(byNamespace))
+	for namespace, resources := range byNamespace {
+		nsAccess := NamespaceAccess{Namespace: namespace, Resources: make([]ResourceAccess, 0, len(resources))}
+		for _, ra := range resources {
+			nsAccess.Resources = append(nsAccess.Resources, *ra)
+		}
+		sort.Slice(nsAccess.Resources, func(i, j int) bool {
+			a, b := nsAccess.Resources[i], nsAccess.Resources[j]
+			return a.APIGroup+"|"+a.Resource+"|"+strings.Join(a.ResourceNames, ",") < b.APIGroup+"|"+b.Resource+"|"+strings.Join(b.ResourceNames, ",")
+		})
+		namespaces = append(namespaces, nsAccess)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Namespace < namespaces[j].Namespace
+	})
+	return namespaces
+}
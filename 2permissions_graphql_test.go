@@ -0,0 +1,165 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// queryGraphQL posts the query to the GraphQL endpoint of a server over the usual fixture, decoding the data of
+// the response into data. It returns the messages of the errors of the response.
+func queryGraphQL(t *testing.T, query string, data interface{}) []string {
+	t.Helper()
+	server := newTestServer(&testReviews{allow: allowUsers("admin")}, nil)
+	require.NoError(t, server.EnableGraphQL())
+	body, err := json.Marshal(graphQLRequest{Query: query})
+	require.NoError(t, err)
+
+	w := postGraphQL(server, string(body), "admin")
+	require.Equal(t, http.StatusOK, w.Code)
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	if data != nil {
+		require.NoError(t, json.Unmarshal(result.Data, data))
+	}
+	messages := []string{}
+	for _, e := range result.Errors {
+		messages = append(messages, e.Message)
+	}
+	return messages
+}
+
+func postGraphQL(server *PermissionsServer, body, caller string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/graphql", strings.NewReader(body))
+	r.Header.Set("X-Test-User", caller)
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, r)
+	return w
+}
+
+// countingClient counts the ClusterRole lists, i.e. the RBAC snapshots loaded.
+type countingClient struct {
+	PermissionsClient
+	lists atomic.Int64
+}
+
+func (in *countingClient) ListClusterRoles(ctx context.Context) ([]rbac_v1.ClusterRole, error) {
+	in.lists.Add(1)
+	return in.PermissionsClient.ListClusterRoles(ctx)
+}
+
+func TestGraphQLUser(t *testing.T) {
+	var data struct {
+		User UserAccess `json:"user"`
+	}
+	errs := queryGraphQL(t, `{ user(name: "carol", groups: ["developers"]) { name groups namespaces { namespace resources { apiGroup resource verbs } } } }`, &data)
+	require.Empty(t, errs)
+
+	assert.Equal(t, UserAccess{
+		Name:   "carol",
+		Groups: []string{"developers"},
+		Namespaces: []NamespaceAccess{{
+			Namespace: "ns1",
+			Resources: []ResourceAccess{{APIGroup: "apps", Resource: "deployments", Verbs: []string{"get", "list", "patch", "update"}}},
+		}},
+	}, data.User)
+}
+
+func TestGraphQLUserGrantModes(t *testing.T) {
+	var data struct {
+		Bob   UserAccess `json:"bob"`
+		Alice UserAccess `json:"alice"`
+	}
+	errs := queryGraphQL(t, `{
+		bob: user(name: "bob", mode: "cluster") { namespaces { namespace resources { resource verbs } } }
+		alice: user(name: "alice", mode: "cluster") { namespaces { namespace } }
+	}`, &data)
+	require.Empty(t, errs)
+
+	// Cluster-wide access is grouped under "*"
+	require.Len(t, data.Bob.Namespaces, 1)
+	assert.Equal(t, "*", data.Bob.Namespaces[0].Namespace)
+	assert.Equal(t, []ResourceAccess{{Resource: "pods", Verbs: []string{"get", "list", "watch"}}}, data.Bob.Namespaces[0].Resources)
+	assert.Empty(t, data.Alice.Namespaces)
+
+	errs = queryGraphQL(t, `{ user(name: "bob", mode: "everything") { name } }`, nil)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0], "unknown grant mode")
+}
+
+func TestGraphQLWhoCan(t *testing.T) {
+	var data struct {
+		WhoCan []rbac_v1.Subject `json:"whoCan"`
+	}
+	errs := queryGraphQL(t, `{ whoCan(verb: "list", resource: "pods", namespace: "ns1") { kind name } }`, &data)
+	require.Empty(t, errs)
+
+	assert.Equal(t, []rbac_v1.Subject{{Kind: "User", Name: "alice"}, {Kind: "User", Name: "bob"}}, data.WhoCan)
+}
+
+func TestGraphQLDiff(t *testing.T) {
+	var data struct {
+		Diff PermissionsDiff `json:"diff"`
+	}
+	errs := queryGraphQL(t, `{ diff(from: "alice", to: "bob") { gained { namespace resource verb } lost { namespace resource verb } } }`, &data)
+	require.Empty(t, errs)
+
+	assert.Equal(t, []AccessRequest{
+		{Resource: "pods", Verb: "get"},
+		{Resource: "pods", Verb: "list"},
+		{Resource: "pods", Verb: "watch"},
+	}, data.Diff.Gained)
+	assert.Equal(t, []AccessRequest{
+		{Namespace: "ns1", Resource: "pods", Verb: "get"},
+		{Namespace: "ns1", Resource: "pods", Verb: "list"},
+		{Namespace: "ns1", Resource: "pods", Verb: "watch"},
+	}, data.Diff.Lost)
+}
+
+func TestGraphQLHandlerRejectsInvalidBodies(t *testing.T) {
+	server := newTestServer(&testReviews{allow: allowUsers("admin")}, nil)
+	require.NoError(t, server.EnableGraphQL())
+
+	assert.Equal(t, http.StatusBadRequest, postGraphQL(server, "{", "admin").Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, postGraphQL(server, `{"query": "`+strings.Repeat("x", maxGraphQLRequestBytes)+`"}`, "admin").Code)
+}
+
+func TestGraphQLNeedsListAccess(t *testing.T) {
+	server := newTestServer(&testReviews{allow: allowUsers("admin")}, nil)
+	require.NoError(t, server.EnableGraphQL())
+	body := `{"query": "{ user(name: \"alice\") { name } }"}`
+
+	assert.Equal(t, http.StatusUnauthorized, postGraphQL(server, body, "").Code)
+	assert.Equal(t, http.StatusForbidden, postGraphQL(server, body, "alice").Code)
+	assert.Equal(t, http.StatusOK, postGraphQL(server, body, "admin").Code)
+}
+
+func TestGraphQLLoadsTheSnapshotOncePerRequest(t *testing.T) {
+	client := &countingClient{PermissionsClient: newTestClient(&testReviews{}, podReaderObjects()...)}
+	schema, err := NewPermissionsGraphQLSchema(client)
+	require.NoError(t, err)
+	handler := GraphQLHandler(schema)
+	body := `{"query": "{ user(name: \"alice\") { name } whoCan(verb: \"get\", resource: \"pods\") { name } diff(from: \"alice\", to: \"bob\") { gained { verb } } }"}`
+
+	for i := 1; i <= 2; i++ {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/api/graphql", strings.NewReader(body)))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), `"errors"`)
+		assert.Equal(t, int64(i), client.lists.Load())
+	}
+}
@@ -0,0 +1,181 @@
+package business
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/kiali/kiali/log"
+)
+
+// DefaultGroupCacheTTL is how long group memberships are cached when no TTL is given.
+const DefaultGroupCacheTTL = 10 * time.Minute
+
+// DefaultGroupCacheMaxEntries bounds the users whose memberships are cached.
+const DefaultGroupCacheMaxEntries = 10000
+
+// groupResolutionTimeout bounds a resolution of the memberships, which is shared by the concurrent
+// callers and so does not stop with the context of the first one.
+const groupResolutionTimeout = 30 * time.Second
+
+type cachedGroups struct {
+	username string
+	groups   []string
+	expires  time.Time
+}
+
+// GroupMembershipCache is a GroupProvider caching the memberships resolved by another provider (OIDC, LDAP,
+// TokenReview...) with its own TTL, separate from the decision cache, so recomputing permissions reuses the
+// memberships. When a refresh finds different memberships, the change handler is called, e.g. to invalidate
+// the cached decisions of the user with PermissionChecker.InvalidateCache. The concurrent resolutions of the
+// memberships of a user share a single call to the provider. Beyond DefaultGroupCacheMaxEntries users, the
+// least recently used memberships are dropped.
+type GroupMembershipCache struct {
+	provider GroupProvider
+	// ttl returns the TTL of the resolved memberships.
+	ttl        func() time.Duration
+	maxEntries int
+	flight     singleflight.Group
+
+	mu       sync.Mutex
+	onChange func(ctx context.Context, username string, previous, current []string)
+	entries  map[string]*list.Element
+	// lru holds the *cachedGroups of the entries, the most recently used first
+	lru *list.List
+}
+
+// NewGroupMembershipCache wraps the provider with a cache. A zero ttl means DefaultGroupCacheTTL.
+func NewGroupMembershipCache(provider GroupProvider, ttl time.Duration) *GroupMembershipCache {
+	if ttl <= 0 {
+		ttl = DefaultGroupCacheTTL
+	}
+	return newGroupMembershipCache(provider, func() time.Duration { return ttl })
+}
+
+func newGroupMembershipCache(provider GroupProvider, ttl func() time.Duration) *GroupMembershipCache {
+	return &GroupMembershipCache{provider: provider, ttl: ttl, maxEntries: DefaultGroupCacheMaxEntries, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+// CacheGroupMemberships wraps the provider with a cache whose TTL is the GroupCacheTTL of the config of the
+// checker, and whose membership changes invalidate the cached decisions of the user.
+func (in *PermissionChecker) CacheGroupMemberships(provider GroupProvider) *GroupMembershipCache {
+	cache := newGroupMembershipCache(provider, func() time.Duration {
+		in.mu.RLock()
+		defer in.mu.RUnlock()
+		if in.conf.GroupCacheTTL > 0 {
+			return in.conf.GroupCacheTTL
+		}
+		return DefaultGroupCacheTTL
+	})
+	cache.SetChangeHandler(func(ctx context.Context, username string, previous, current []string) {
+		if err := in.InvalidateCache(ctx, UserCacheSlices(username)); err != nil {
+			log.Warningf("%sError invalidating the cached decisions of user %s after a change of groups: %v", logPrefix(ctx), redactedUser(username), err)
+		}
+	})
+	return cache
+}
+
+// SetChangeHandler registers a function called when the refreshed memberships of a user differ from the
+// cached ones. It is not called the first time the memberships of a user are resolved.
+func (in *GroupMembershipCache) SetChangeHandler(handler func(ctx context.Context, username string, previous, current []string)) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.onChange = handler
+}
+
+// GetGroups returns the cached memberships of the user, resolving them when missing or expired.
+func (in *GroupMembershipCache) GetGroups(ctx context.Context, username string) ([]string, error) {
+	in.mu.Lock()
+	if element, ok := in.entries[username]; ok {
+		entry := element.Value.(*cachedGroups)
+		if time.Now().Before(entry.expires) {
+			in.lru.MoveToFront(element)
+			in.mu.Unlock()
+			return entry.groups, nil
+		}
+	}
+	in.mu.Unlock()
+	return in.Refresh(ctx, username)
+}
+
+// Refresh resolves the memberships of the user again, e.g. when notified of a directory change, and calls
+// the change handler if they changed.
+func (in *GroupMembershipCache) Refresh(ctx context.Context, username string) ([]string, error) {
+	results := in.flight.DoChan(username, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), groupResolutionTimeout)
+		defer cancel()
+		return in.resolve(ctx, username)
+	})
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.([]string), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// resolve gets the memberships of the user from the provider and caches them.
+func (in *GroupMembershipCache) resolve(ctx context.Context, username string) ([]string, error) {
+	groups, err := in.provider.GetGroups(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	groups = mergeSorted(groups, nil)
+	entry := &cachedGroups{username: username, groups: groups, expires: time.Now().Add(in.ttl())}
+
+	in.mu.Lock()
+	var previous []string
+	element, found := in.entries[username]
+	if found {
+		previous = element.Value.(*cachedGroups).groups
+		element.Value = entry
+		in.lru.MoveToFront(element)
+	} else {
+		in.entries[username] = in.lru.PushFront(entry)
+		for in.lru.Len() > in.maxEntries {
+			delete(in.entries, in.lru.Remove(in.lru.Back()).(*cachedGroups).username)
+		}
+	}
+	handler := in.onChange
+	in.mu.Unlock()
+
+	if found && handler != nil && !equalStrings(previous, groups) {
+		handler(ctx, username, previous, groups)
+	}
+	return groups, nil
+}
+
+// Invalidate forgets the memberships of the user, which are resolved again on the next request.
+func (in *GroupMembershipCache) Invalidate(username string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if element, ok := in.entries[username]; ok {
+		in.lru.Remove(element)
+		delete(in.entries, username)
+	}
+}
+
+// UserCacheSlices returns the cache slices of all the decisions of the user, e.g. to invalidate them
+// when the memberships of the user change.
+func UserCacheSlices(username string) []CacheSlice {
+	return []CacheSlice{{Namespace: AllNamespacesSlice, User: username}}
+}
+
+// equalStrings returns true if both sorted lists are equal.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
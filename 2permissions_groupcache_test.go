@@ -0,0 +1,213 @@
+package business
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupMembershipCache(t *testing.T) {
+	provider := &testGroupProvider{groups: map[string][]string{"alice": {"ops", "developers"}}}
+	cache := NewGroupMembershipCache(provider, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		groups, err := cache.GetGroups(testCtx, "alice")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"developers", "ops"}, groups)
+	}
+	assert.Equal(t, 1, provider.calls)
+
+	cache.Invalidate("alice")
+	_, err := cache.GetGroups(testCtx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 2, provider.calls)
+}
+
+func TestGroupMembershipCacheExpires(t *testing.T) {
+	provider := &testGroupProvider{groups: map[string][]string{"alice": {"developers"}}}
+	cache := NewGroupMembershipCache(provider, time.Millisecond)
+
+	_, err := cache.GetGroups(testCtx, "alice")
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = cache.GetGroups(testCtx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 2, provider.calls)
+
+	assert.Equal(t, DefaultGroupCacheTTL, NewGroupMembershipCache(provider, 0).ttl())
+}
+
+func TestGroupMembershipCacheDoesNotCacheErrors(t *testing.T) {
+	provider := &testGroupProvider{err: errTestAPIServer}
+	cache := NewGroupMembershipCache(provider, time.Hour)
+
+	_, err := cache.GetGroups(testCtx, "alice")
+	assert.ErrorIs(t, err, errTestAPIServer)
+	provider.err = nil
+	_, err = cache.GetGroups(testCtx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 2, provider.calls)
+}
+
+func TestGroupMembershipCacheChangeHandler(t *testing.T) {
+	provider := &testGroupProvider{groups: map[string][]string{"alice": {"developers"}}}
+	cache := NewGroupMembershipCache(provider, time.Hour)
+	type change struct {
+		username          string
+		previous, current []string
+	}
+	changes := []change{}
+	cache.SetChangeHandler(func(ctx context.Context, username string, previous, current []string) {
+		changes = append(changes, change{username, previous, current})
+	})
+
+	// Neither the first resolution nor an identical refresh is a change
+	_, err := cache.GetGroups(testCtx, "alice")
+	require.NoError(t, err)
+	_, err = cache.Refresh(testCtx, "alice")
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	provider.groups["alice"] = []string{"sre", "developers"}
+	groups, err := cache.Refresh(testCtx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"developers", "sre"}, groups)
+	assert.Equal(t, []change{{"alice", []string{"developers"}, []string{"developers", "sre"}}}, changes)
+}
+
+func TestGroupMembershipCacheIsBounded(t *testing.T) {
+	provider := &testGroupProvider{groups: map[string][]string{"alice": {"developers"}, "bob": {"ops"}, "carol": {"sre"}}}
+	cache := NewGroupMembershipCache(provider, time.Hour)
+	cache.maxEntries = 2
+
+	for _, username := range []string{"alice", "bob", "alice", "carol"} {
+		_, err := cache.GetGroups(testCtx, username)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, provider.calls)
+
+	// bob, the least recently used, was dropped
+	assert.Len(t, cache.entries, 2)
+	assert.Equal(t, 2, cache.lru.Len())
+	_, err := cache.GetGroups(testCtx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 3, provider.calls)
+	_, err = cache.GetGroups(testCtx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, 4, provider.calls)
+}
+
+// blockingGroupProvider resolves the groups once released, counting the calls.
+type blockingGroupProvider struct {
+	release chan struct{}
+	calls   atomic.Int64
+}
+
+func (in *blockingGroupProvider) GetGroups(ctx context.Context, username string) ([]string, error) {
+	in.calls.Add(1)
+	select {
+	case <-in.release:
+		return []string{"developers"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestGroupMembershipCacheSharesTheConcurrentResolutions(t *testing.T) {
+	provider := &blockingGroupProvider{release: make(chan struct{})}
+	cache := NewGroupMembershipCache(provider, time.Hour)
+
+	// The resolution survives the caller that started it
+	ctx, cancel := context.WithCancel(testCtx)
+	cancelled := make(chan error)
+	go func() {
+		_, err := cache.GetGroups(ctx, "alice")
+		cancelled <- err
+	}()
+	require.Eventually(t, func() bool { return provider.calls.Load() == 1 }, 5*time.Second, time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			groups, err := cache.GetGroups(testCtx, "alice")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"developers"}, groups)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(provider.release)
+	wg.Wait()
+	assert.Equal(t, int64(1), provider.calls.Load())
+}
+
+func TestCheckerCacheGroupMemberships(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.GroupCacheTTL = 2 * time.Minute
+	reviews := &testReviews{allow: allowUsers("alice")}
+	checker := newTestChecker(reviews, conf)
+	provider := &testGroupProvider{groups: map[string][]string{"alice": {"developers"}}}
+	cache := checker.CacheGroupMemberships(provider)
+	assert.Equal(t, 2*time.Minute, cache.ttl())
+
+	// The TTL follows the config
+	conf.GroupCacheTTL = 0
+	require.NoError(t, checker.ApplyConfig(conf))
+	assert.Equal(t, DefaultGroupCacheTTL, cache.ttl())
+
+	// A change of groups invalidates the decisions of the user
+	_, err := cache.GetGroups(testCtx, "alice")
+	require.NoError(t, err)
+	_, err = checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+	provider.groups["alice"] = []string{"ops"}
+	_, err = cache.Refresh(testCtx, "alice")
+	require.NoError(t, err)
+	decision, err := checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+	assert.Equal(t, DecisionSourceAPIServer, decision.Source)
+}
+
+func TestUserCacheSlicesInvalidateEveryDecisionOfTheUser(t *testing.T) {
+	reviews := &testReviews{allow: allowUsers("alice", "bob")}
+	checker := newTestChecker(reviews, nil)
+	requests := []AccessRequest{alicePods, {Namespace: "ns2", Resource: "pods", Verb: "get"}, {Resource: "nodes", Verb: "list"}}
+	for _, user := range []UserInfo{{Name: "alice"}, {Name: "bob"}} {
+		for _, req := range requests {
+			_, err := checker.Check(testCtx, user, req)
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, checker.InvalidateCache(testCtx, UserCacheSlices("alice")))
+	for _, req := range requests {
+		alice, err := checker.Check(testCtx, UserInfo{Name: "alice"}, req)
+		require.NoError(t, err)
+		assert.Equal(t, DecisionSourceAPIServer, alice.Source, "%+v", req)
+		bob, err := checker.Check(testCtx, UserInfo{Name: "bob"}, req)
+		require.NoError(t, err)
+		assert.Equal(t, DecisionSourceCache, bob.Source, "%+v", req)
+	}
+}
+
+func TestLoadPermissionsConfigGroupCacheTTL(t *testing.T) {
+	t.Setenv(PermissionsConfigEnvPrefix+"GROUP_CACHE_TTL", "2m")
+	conf, err := LoadPermissionsConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, conf.GroupCacheTTL)
+
+	t.Setenv(PermissionsConfigEnvPrefix+"GROUP_CACHE_TTL", "often")
+	_, err = LoadPermissionsConfig("")
+	assert.ErrorContains(t, err, PermissionsConfigEnvPrefix+"GROUP_CACHE_TTL")
+
+	conf.GroupCacheTTL = -time.Minute
+	assert.ErrorContains(t, conf.Validate(), "group_cache_ttl")
+}
@@ -0,0 +1,244 @@
+package business
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/kiali/kiali/log"
+)
+
+// UserInfo identifies the subject whose permissions are being resolved.
+type UserInfo struct {
+	Name   string
+	Groups []string
+	// Extra holds the additional attributes of the user given by the authenticator, e.g. the scopes of
+	// an OpenShift OAuth token.
+	Extra map[string][]string
+}
+
+// GroupProvider resolves the groups a user is a member of. It is used when the
+// user token does not carry group claims, so RBAC bindings to groups can still be resolved.
+type GroupProvider interface {
+	GetGroups(ctx context.Context, username string) ([]string, error)
+}
+
+// ResolveUserInfo returns the user with its groups completed by the provider. Groups that
+// are already present (i.e. coming from the token) are kept. A nil provider is a no-op.
+func ResolveUserInfo(ctx context.Context, provider GroupProvider, user UserInfo) (UserInfo, error) {
+	if provider == nil {
+		return user, nil
+	}
+
+	groups, err := provider.GetGroups(ctx, user.Name)
+	if err != nil {
+		return user, fmt.Errorf("error resolving groups of user %s: %w", user.Name, err)
+	}
+
+	resolved := user
+	resolved.Groups = mergeSorted(user.Groups, groups)
+	return resolved, nil
+}
+
+// LDAPGroupProviderConfig configures the connection and queries of an LDAPGroupProvider.
+type LDAPGroupProviderConfig struct {
+	// URL of the directory, e.g. ldaps://ldap.example.com:636
+	URL string
+	// StartTLS upgrades the connections of ldap:// URLs to TLS. Either ldaps:// or StartTLS is required,
+	// unless AllowInsecure is set.
+	StartTLS           bool
+	InsecureSkipVerify bool
+	// AllowInsecure allows the ldap:// URLs without StartTLS, whose bind password and searches go in
+	// clear text, e.g. for a directory on the same host.
+	AllowInsecure bool
+	// BindDN and BindPassword are the credentials of the service account used for the searches.
+	BindDN       string
+	BindPassword string
+	// BaseDN is the root of the searches.
+	BaseDN string
+	// UserFilter finds the entry of the user. The %s placeholder is replaced with the escaped username.
+	// Defaults to (uid=%s); use (sAMAccountName=%s) for Active Directory.
+	UserFilter string
+	// GroupFilter finds the groups of the user. The %s placeholder is replaced with the escaped user DN.
+	// Defaults to (member=%s); use (member:1.2.840.113556.1.4.1941:=%s) to include nested Active Directory groups.
+	GroupFilter string
+	// GroupNameAttribute is the attribute holding the group name that RBAC bindings refer to. Defaults to cn.
+	GroupNameAttribute string
+	// Timeout bounds the connection and each request to the directory. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// ldapConn is the part of *ldap.Conn used by the LDAPGroupProvider.
+type ldapConn interface {
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	SetTimeout(timeout time.Duration)
+	IsClosing() bool
+	Close() error
+}
+
+// LDAPGroupProvider is a GroupProvider reading group membership from an LDAP directory,
+// such as the corporate Active Directory. Its connection, bound with the service account, is kept
+// between the lookups, which are made one at a time on it.
+type LDAPGroupProvider struct {
+	conf LDAPGroupProviderConfig
+	dial func() (ldapConn, error)
+
+	mu   sync.Mutex
+	conn ldapConn
+}
+
+// NewLDAPGroupProvider creates an LDAPGroupProvider, filling the defaults of the unset settings. It
+// returns an error if the connections would not be encrypted without AllowInsecure, or if the timeout is
+// negative.
+func NewLDAPGroupProvider(conf LDAPGroupProviderConfig) (*LDAPGroupProvider, error) {
+	u, err := url.Parse(conf.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL: %w", err)
+	}
+	switch {
+	case u.Scheme == "ldaps" && conf.StartTLS:
+		return nil, fmt.Errorf("StartTLS cannot be used with the ldaps URL %s, already encrypted", conf.URL)
+	case u.Scheme == "ldap" && !conf.StartTLS && !conf.AllowInsecure:
+		return nil, fmt.Errorf("the LDAP URL %s is not encrypted: use ldaps, StartTLS, or allow insecure connections", conf.URL)
+	case u.Scheme != "ldap" && u.Scheme != "ldaps":
+		return nil, fmt.Errorf("unsupported LDAP URL %s, expected ldap:// or ldaps://", conf.URL)
+	}
+	if conf.Timeout < 0 {
+		return nil, fmt.Errorf("negative LDAP timeout %s", conf.Timeout)
+	}
+
+	if conf.UserFilter == "" {
+		conf.UserFilter = "(uid=%s)"
+	}
+	if conf.GroupFilter == "" {
+		conf.GroupFilter = "(member=%s)"
+	}
+	if conf.GroupNameAttribute == "" {
+		conf.GroupNameAttribute = "cn"
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 10 * time.Second
+	}
+	provider := &LDAPGroupProvider{conf: conf}
+	provider.dial = provider.dialDirectory
+	return provider, nil
+}
+
+// dialDirectory connects to the directory, over TLS, and binds with the service account.
+func (in *LDAPGroupProvider) dialDirectory() (ldapConn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: in.conf.InsecureSkipVerify, MinVersion: tls.VersionTLS12}
+	conn, err := ldap.DialURL(in.conf.URL, ldap.DialWithDialer(&net.Dialer{Timeout: in.conf.Timeout}), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to LDAP server: %w", err)
+	}
+	conn.SetTimeout(in.conf.Timeout)
+	if in.conf.StartTLS {
+		if u, err := url.Parse(in.conf.URL); err == nil {
+			tlsConfig.ServerName = u.Hostname()
+		}
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("cannot start TLS with LDAP server: %w", err)
+		}
+	}
+	if in.conf.BindDN != "" {
+		if err := conn.Bind(in.conf.BindDN, in.conf.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("cannot bind to LDAP server: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// GetGroups returns the names of the groups the user is a member of. An unknown user has no groups.
+// A connection closed by the directory, e.g. when idle, is replaced once.
+func (in *LDAPGroupProvider) GetGroups(ctx context.Context, username string) ([]string, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	groups, err := in.lookup(ctx, username)
+	if err != nil && ldap.IsErrorWithCode(err, ldap.ErrorNetwork) && ctx.Err() == nil {
+		log.Debugf("%sReconnecting to the LDAP server: %v", logPrefix(ctx), err)
+		groups, err = in.lookup(ctx, username)
+	}
+	return groups, err
+}
+
+// Close closes the connection to the directory, if any. The next lookup connects again.
+func (in *LDAPGroupProvider) Close() error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.conn == nil {
+		return nil
+	}
+	err := in.conn.Close()
+	in.conn = nil
+	return err
+}
+
+// lookup searches the groups of the user on the connection, opening it if needed. The connection is
+// dropped on network errors. The caller holds the lock.
+func (in *LDAPGroupProvider) lookup(ctx context.Context, username string) ([]string, error) {
+	if in.conn == nil || in.conn.IsClosing() {
+		conn, err := in.dial()
+		if err != nil {
+			return nil, err
+		}
+		in.conn = conn
+	}
+	conn := in.conn
+	defer func() {
+		if conn.IsClosing() {
+			in.conn = nil
+		}
+	}()
+
+	timeout := in.conf.Timeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	conn.SetTimeout(timeout)
+
+	userSearch := ldap.NewSearchRequest(in.conf.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		strings.ReplaceAll(in.conf.UserFilter, "%s", ldap.EscapeFilter(username)), []string{"dn"}, nil)
+	users, err := in.search(conn, userSearch)
+	if err != nil {
+		return nil, fmt.Errorf("error searching LDAP user %s: %w", username, err)
+	}
+	if len(users.Entries) == 0 {
+		log.Debugf("User %s not found in LDAP directory", redactedUser(username))
+		return []string{}, nil
+	}
+	if len(users.Entries) > 1 {
+		return nil, fmt.Errorf("LDAP user filter matched more than one entry for user %s", username)
+	}
+
+	groupSearch := ldap.NewSearchRequest(in.conf.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		strings.ReplaceAll(in.conf.GroupFilter, "%s", ldap.EscapeFilter(users.Entries[0].DN)), []string{in.conf.GroupNameAttribute}, nil)
+	groups, err := in.search(conn, groupSearch)
+	if err != nil {
+		return nil, fmt.Errorf("error searching LDAP groups of user %s: %w", username, err)
+	}
+
+	names := make([]string, 0, len(groups.Entries))
+	for _, entry := range groups.Entries {
+		names = append(names, entry.GetAttributeValues(in.conf.GroupNameAttribute)...)
+	}
+	return names, nil
+}
+
+// search runs the request on the connection, closing it on network errors so the next lookup reconnects.
+func (in *LDAPGroupProvider) search(conn ldapConn, request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	result, err := conn.Search(request)
+	if err != nil && ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		conn.Close()
+	}
+	return result, err
+}
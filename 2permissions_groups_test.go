@@ -0,0 +1,194 @@
+package business
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGroupProvider resolves the groups of the users from a map, counting the calls.
+type testGroupProvider struct {
+	groups map[string][]string
+	err    error
+	calls  int
+}
+
+func (in *testGroupProvider) GetGroups(ctx context.Context, username string) ([]string, error) {
+	in.calls++
+	return in.groups[username], in.err
+}
+
+func TestResolveUserInfoMergesTheGroups(t *testing.T) {
+	provider := &testGroupProvider{groups: map[string][]string{"alice": {"developers", "ops"}}}
+
+	user, err := ResolveUserInfo(testCtx, provider, UserInfo{Name: "alice", Groups: []string{"system:authenticated", "ops"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"developers", "ops", "system:authenticated"}, user.Groups)
+
+	user, err = ResolveUserInfo(testCtx, provider, UserInfo{Name: "bob"})
+	require.NoError(t, err)
+	assert.Empty(t, user.Groups)
+}
+
+func TestResolveUserInfoWithoutProvider(t *testing.T) {
+	user := UserInfo{Name: "alice", Groups: []string{"developers"}}
+
+	resolved, err := ResolveUserInfo(testCtx, nil, user)
+	require.NoError(t, err)
+	assert.Equal(t, user, resolved)
+}
+
+func TestResolveUserInfoKeepsTheUserOnErrors(t *testing.T) {
+	user := UserInfo{Name: "alice", Groups: []string{"developers"}}
+
+	resolved, err := ResolveUserInfo(testCtx, &testGroupProvider{err: errTestAPIServer}, user)
+	assert.ErrorIs(t, err, errTestAPIServer)
+	assert.Equal(t, user, resolved)
+}
+
+func TestNewLDAPGroupProviderDefaults(t *testing.T) {
+	provider, err := NewLDAPGroupProvider(LDAPGroupProviderConfig{URL: "ldaps://localhost"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "(uid=%s)", provider.conf.UserFilter)
+	assert.Equal(t, "(member=%s)", provider.conf.GroupFilter)
+	assert.Equal(t, "cn", provider.conf.GroupNameAttribute)
+	assert.NotZero(t, provider.conf.Timeout)
+
+	provider, err = NewLDAPGroupProvider(LDAPGroupProviderConfig{URL: "ldap://localhost", StartTLS: true, UserFilter: "(sAMAccountName=%s)", GroupNameAttribute: "name"})
+	require.NoError(t, err)
+	assert.Equal(t, "(sAMAccountName=%s)", provider.conf.UserFilter)
+	assert.Equal(t, "name", provider.conf.GroupNameAttribute)
+}
+
+func TestNewLDAPGroupProviderRequiresEncryption(t *testing.T) {
+	for _, conf := range []LDAPGroupProviderConfig{
+		{URL: "ldap://localhost"},
+		{URL: "ldaps://localhost", StartTLS: true},
+		{URL: "http://localhost"},
+		{},
+		{URL: "ldaps://localhost", Timeout: -time.Second},
+	} {
+		_, err := NewLDAPGroupProvider(conf)
+		assert.Error(t, err, "%+v", conf)
+	}
+
+	_, err := NewLDAPGroupProvider(LDAPGroupProviderConfig{URL: "ldap://localhost", AllowInsecure: true})
+	assert.NoError(t, err)
+}
+
+func TestLDAPGroupProviderUnreachable(t *testing.T) {
+	provider, err := NewLDAPGroupProvider(LDAPGroupProviderConfig{URL: "ldap://127.0.0.1:1", AllowInsecure: true, Timeout: time.Second})
+	require.NoError(t, err)
+
+	_, err = provider.GetGroups(testCtx, "alice")
+	assert.Error(t, err)
+}
+
+// testLDAPConn answers the searches from a map of filters to entries, recording the requests.
+type testLDAPConn struct {
+	entries  map[string][]*ldap.Entry
+	err      error
+	requests []*ldap.SearchRequest
+	closed   bool
+}
+
+func (in *testLDAPConn) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	in.requests = append(in.requests, request)
+	if in.err != nil {
+		return nil, in.err
+	}
+	return &ldap.SearchResult{Entries: in.entries[request.Filter]}, nil
+}
+
+func (in *testLDAPConn) SetTimeout(timeout time.Duration) {}
+
+func (in *testLDAPConn) IsClosing() bool { return in.closed }
+
+func (in *testLDAPConn) Close() error {
+	in.closed = true
+	return nil
+}
+
+// testLDAPProvider returns an LDAPGroupProvider whose connections are the conns, in order, counting the dials.
+func testLDAPProvider(t *testing.T, conns ...*testLDAPConn) (*LDAPGroupProvider, *int) {
+	provider, err := NewLDAPGroupProvider(LDAPGroupProviderConfig{URL: "ldaps://localhost", BaseDN: "dc=example,dc=com"})
+	require.NoError(t, err)
+	dials := 0
+	provider.dial = func() (ldapConn, error) {
+		if dials == len(conns) {
+			return nil, errTestAPIServer
+		}
+		dials++
+		return conns[dials-1], nil
+	}
+	return provider, &dials
+}
+
+func TestLDAPGroupProviderSearches(t *testing.T) {
+	aliceDN := "uid=alice,ou=people (eu),dc=example,dc=com"
+	conn := &testLDAPConn{entries: map[string][]*ldap.Entry{
+		"(uid=alice)": {ldap.NewEntry(aliceDN, nil)},
+		`(member=uid=alice,ou=people \28eu\29,dc=example,dc=com)`: {
+			ldap.NewEntry("cn=developers", map[string][]string{"cn": {"developers"}}),
+			ldap.NewEntry("cn=ops", map[string][]string{"cn": {"ops"}}),
+		},
+		"(uid=twin)": {ldap.NewEntry("uid=twin,ou=a", nil), ldap.NewEntry("uid=twin,ou=b", nil)},
+	}}
+	provider, dials := testLDAPProvider(t, conn)
+
+	groups, err := provider.GetGroups(testCtx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"developers", "ops"}, groups)
+	assert.Equal(t, "dc=example,dc=com", conn.requests[0].BaseDN)
+	assert.Equal(t, []string{"cn"}, conn.requests[1].Attributes)
+
+	groups, err = provider.GetGroups(testCtx, "bob")
+	require.NoError(t, err)
+	assert.Empty(t, groups)
+
+	_, err = provider.GetGroups(testCtx, "twin")
+	assert.Error(t, err)
+
+	// The connection is kept between the lookups
+	assert.Equal(t, 1, *dials)
+}
+
+func TestLDAPGroupProviderEscapesTheFilters(t *testing.T) {
+	conn := &testLDAPConn{}
+	provider, _ := testLDAPProvider(t, conn)
+
+	groups, err := provider.GetGroups(testCtx, "a*)(uid=*")
+	require.NoError(t, err)
+	assert.Empty(t, groups)
+	assert.Equal(t, `(uid=a\2a\29\28uid=\2a)`, conn.requests[0].Filter)
+}
+
+func TestLDAPGroupProviderReconnects(t *testing.T) {
+	broken := &testLDAPConn{err: ldap.NewError(ldap.ErrorNetwork, errTestAPIServer)}
+	conn := &testLDAPConn{entries: map[string][]*ldap.Entry{
+		"(uid=alice)":        {ldap.NewEntry("uid=alice", nil)},
+		"(member=uid=alice)": {ldap.NewEntry("cn=developers", map[string][]string{"cn": {"developers"}})},
+	}}
+	provider, dials := testLDAPProvider(t, broken, conn)
+
+	// The connection closed by the directory is replaced once
+	groups, err := provider.GetGroups(testCtx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"developers"}, groups)
+	assert.True(t, broken.closed)
+	assert.Equal(t, 2, *dials)
+
+	// The other errors keep the connection
+	conn.err = errTestAPIServer
+	_, err = provider.GetGroups(testCtx, "alice")
+	assert.ErrorIs(t, err, errTestAPIServer)
+	assert.False(t, conn.closed)
+
+	require.NoError(t, provider.Close())
+	assert.True(t, conn.closed)
+}
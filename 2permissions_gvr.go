@@ -0,0 +1,83 @@
+package business
+
+import (
+	"context"
+	"strings"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// AccessRequestForGVR builds the request of the verb on an object of the resource, for the dynamic-client
+// and controller-runtime users. A subresource can be given in the resource of the GVR, e.g. pods/log.
+// The version is ignored, since RBAC does not depend on it. The name is empty for the requests on the
+// whole collection, and the namespace is empty for cluster-scoped resources or all namespaces.
+func AccessRequestForGVR(gvr schema.GroupVersionResource, namespace, name, verb string) AccessRequest {
+	resource, subresource, _ := strings.Cut(gvr.Resource, "/")
+	return AccessRequest{
+		Namespace:   namespace,
+		APIGroup:    gvr.Group,
+		Resource:    resource,
+		Subresource: subresource,
+		Name:        name,
+		Verb:        verb,
+	}
+}
+
+// CheckGVR is like Check for a request keyed by its GroupVersionResource, see AccessRequestForGVR.
+func (in *PermissionChecker) CheckGVR(ctx context.Context, user UserInfo, gvr schema.GroupVersionResource, namespace, name, verb string) (Decision, error) {
+	return in.Check(ctx, user, AccessRequestForGVR(gvr, namespace, name, verb))
+}
+
+// FilterAllowedObjects returns the objects of the resource the user can perform the verb on, in their
+// order. The verb is checked per object, since a rule may name the allowed objects, or an authorizer deny
+// some of them. When the checker only asks the apiserver, whose RBAC allowing the whole collection allows
+// every object of it, the verb is first checked once per namespace, and only checked per object in the
+// namespaces where it is not allowed on the whole collection. It works with typed objects as well as
+// unstructured ones.
+func FilterAllowedObjects[T meta_v1.Object](ctx context.Context, checker *PermissionChecker, user UserInfo, gvr schema.GroupVersionResource, verb string, objects []T) ([]T, error) {
+	shortcut := checker.collectionAllowsObjects()
+	namespaceAllowed := map[string]bool{}
+	allowed := make([]T, 0, len(objects))
+	for _, obj := range objects {
+		ns := obj.GetNamespace()
+		nsAllowed, checked := namespaceAllowed[ns]
+		if shortcut && !checked {
+			decision, err := checker.CheckGVR(ctx, user, gvr, ns, "", verb)
+			if err != nil {
+				return nil, err
+			}
+			nsAllowed = decision.Allowed
+			namespaceAllowed[ns] = nsAllowed
+		}
+		if !nsAllowed {
+			decision, err := checker.CheckGVR(ctx, user, gvr, ns, obj.GetName(), verb)
+			if err != nil {
+				return nil, err
+			}
+			if !decision.Allowed {
+				continue
+			}
+		}
+		allowed = append(allowed, obj)
+	}
+	return allowed, nil
+}
+
+// collectionAllowsObjects returns true if the decisions of the checker on a whole collection hold for its
+// objects, i.e. if its authorizers only ask the apiserver.
+func (in *PermissionChecker) collectionAllowsObjects() bool {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	for _, authorizer := range in.chain.authorizers {
+		if authorizer.name != AuthorizerSubjectAccessReview {
+			return false
+		}
+	}
+	return true
+}
+
+// ExplainGVR is like Explain for a request keyed by its GroupVersionResource, see AccessRequestForGVR.
+func (in *RBACSnapshot) ExplainGVR(user UserInfo, gvr schema.GroupVersionResource, namespace, name, verb string) *Explanation {
+	return in.Explain(user, AccessRequestForGVR(gvr, namespace, name, verb))
+}
@@ -0,0 +1,113 @@
+package business
+
+import (
+	"testing"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var podsGVR = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+// aliceListsPodsOfNs1 allows alice to list all the pods of ns1, and only the web pod of ns2.
+func aliceListsPodsOfNs1() *testReviews {
+	return &testReviews{allow: func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+		return user.Name == "alice" && attrs.Resource == "pods" && attrs.Verb == "list" &&
+			(attrs.Namespace == "ns1" || (attrs.Namespace == "ns2" && attrs.Name == "web"))
+	}}
+}
+
+func TestAccessRequestForGVR(t *testing.T) {
+	assert.Equal(t, AccessRequest{Namespace: "ns1", Resource: "pods", Name: "web", Verb: "get"},
+		AccessRequestForGVR(podsGVR, "ns1", "web", "get"))
+	assert.Equal(t, AccessRequest{Namespace: "ns1", Resource: "pods", Subresource: "log", Name: "web", Verb: "get"},
+		AccessRequestForGVR(schema.GroupVersionResource{Version: "v1", Resource: "pods/log"}, "ns1", "web", "get"))
+	assert.Equal(t, AccessRequest{APIGroup: "apps", Resource: "deployments", Verb: "list"},
+		AccessRequestForGVR(schema.GroupVersionResource{Group: "apps", Version: "v1beta1", Resource: "deployments"}, "", "", "list"))
+}
+
+func TestCheckGVR(t *testing.T) {
+	checker := newTestChecker(aliceReadsPods(), nil)
+
+	decision, err := checker.CheckGVR(testCtx, UserInfo{Name: "alice"}, podsGVR, alicePods.Namespace, alicePods.Name, alicePods.Verb)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+
+	decision, err = checker.CheckGVR(testCtx, UserInfo{Name: "alice"}, schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, "ns1", "", "get")
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+}
+
+func TestFilterAllowedObjects(t *testing.T) {
+	reviews := aliceListsPodsOfNs1()
+	checker := newTestChecker(reviews, nil)
+	pod := func(namespace, name string) *core_v1.Pod {
+		return &core_v1.Pod{ObjectMeta: meta_v1.ObjectMeta{Namespace: namespace, Name: name}}
+	}
+	pods := []*core_v1.Pod{pod("ns1", "web"), pod("ns2", "web"), pod("ns1", "db"), pod("ns2", "db"), pod("ns3", "web")}
+
+	allowed, err := FilterAllowedObjects(testCtx, checker, UserInfo{Name: "alice"}, podsGVR, "list", pods)
+	require.NoError(t, err)
+	assert.Equal(t, []*core_v1.Pod{pods[0], pods[1], pods[2]}, allowed)
+	// One check per namespace, and per object of the namespaces not allowed as a whole
+	assert.Equal(t, int64(3+3), reviews.calls.Load())
+}
+
+func TestFilterAllowedObjectsWithOtherAuthorizers(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.ChainMode = ChainModeDenyOverrides
+	conf.Authorizers = []AuthorizerConfig{{Name: AuthorizerSubjectAccessReview}, testAuthorizerConfig("deny", "alice", "names", "db")}
+	reviews := aliceListsPodsOfNs1()
+	checker := newTestChecker(reviews, conf)
+	pods := []*core_v1.Pod{
+		{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns1", Name: "web"}},
+		{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns1", Name: "db"}},
+	}
+
+	// The objects are checked one by one, the authorizers may deny some of them
+	allowed, err := FilterAllowedObjects(testCtx, checker, UserInfo{Name: "alice"}, podsGVR, "list", pods)
+	require.NoError(t, err)
+	assert.Equal(t, pods[:1], allowed)
+	assert.Equal(t, int64(2), reviews.calls.Load())
+}
+
+func TestFilterAllowedUnstructuredObjects(t *testing.T) {
+	checker := newTestChecker(aliceListsPodsOfNs1(), nil)
+	pod := func(namespace, name string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		return obj
+	}
+	pods := []*unstructured.Unstructured{pod("ns2", "db"), pod("ns1", "db")}
+
+	allowed, err := FilterAllowedObjects(testCtx, checker, UserInfo{Name: "alice"}, podsGVR, "list", pods)
+	require.NoError(t, err)
+	assert.Equal(t, pods[1:], allowed)
+
+	allowed, err = FilterAllowedObjects(testCtx, checker, UserInfo{Name: "bob"}, podsGVR, "list", pods)
+	require.NoError(t, err)
+	assert.Empty(t, allowed)
+}
+
+func TestFilterAllowedObjectsErrors(t *testing.T) {
+	checker := newTestChecker(&testReviews{err: errTestAPIServer}, nil)
+
+	_, err := FilterAllowedObjects(testCtx, checker, UserInfo{Name: "alice"}, podsGVR, "list", []*core_v1.Pod{{}})
+	assert.ErrorIs(t, err, errTestAPIServer)
+}
+
+func TestExplainGVR(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+
+	explanation := snapshot.ExplainGVR(UserInfo{Name: "alice"}, podsGVR, "ns1", "web", "get")
+	assert.True(t, explanation.Allowed)
+	assert.Equal(t, AccessRequest{Namespace: "ns1", Resource: "pods", Name: "web", Verb: "get"}, explanation.Request)
+	assert.False(t, snapshot.ExplainGVR(UserInfo{Name: "alice"}, podsGVR, "ns2", "web", "get").Allowed)
+}
@@ -0,0 +1,95 @@
+package business
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/kiali/kiali/log"
+)
+
+// permissionsHashPrefix identifies the algorithm and the canonical form of the hashes, so hashes stored
+// by a previous version are not compared with new ones if either changes.
+const permissionsHashPrefix = "sha256:v1:"
+
+// PermissionsHash returns a stable hash of the permissions, e.g. of EffectivePermissions: the SHA-256
+// of the sorted permissions, one per line. Equal sets of permissions have the same hash whatever the
+// bindings granting them, so change detection and drift alerts can store and compare the hashes
+// instead of the permissions.
+func PermissionsHash(permissions map[AccessRequest]bool) string {
+	lines := make([]string, 0, len(permissions))
+	for p, granted := range permissions {
+		if !granted {
+			continue
+		}
+		// The unit separator cannot appear in the fields, so distinct permissions cannot collide
+		lines = append(lines, strings.Join([]string{p.Namespace, p.APIGroup, p.Resource, p.Subresource, p.Name, p.Verb, p.FieldSelector, p.LabelSelector}, "\x1f"))
+	}
+	sort.Strings(lines)
+	sum := sha256.New()
+	for _, line := range lines {
+		sum.Write([]byte(line))
+		sum.Write([]byte{'\n'})
+	}
+	return permissionsHashPrefix + hex.EncodeToString(sum.Sum(nil))
+}
+
+// PermissionsHash returns the hash of the effective permissions of the user, see PermissionsHash.
+func (in *RBACSnapshot) PermissionsHash(user UserInfo) string {
+	return PermissionsHash(in.EffectivePermissions(user))
+}
+
+// ContentHash returns the SHA-256 of the JSON form of the value, e.g. for the ETag of a report whose
+// lists are sorted. Fields like the generation time must be left out of the value.
+func ContentHash(value interface{}) (string, error) {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// permissionsHash serves the hash of the effective permissions of the caller, with the hash as ETag, so
+// clients can poll it cheaply and refetch the permissions only when it changes.
+func (in *PermissionsServer) permissionsHash(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	user, ok := in.callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	snapshot, err := in.snapshot(r)
+	if err != nil {
+		log.Errorf("%sError hashing the permissions of user %s: %v", logPrefix(ctx), redactedUser(user.Name), err)
+		http.Error(w, "error reading RBAC objects", http.StatusInternalServerError)
+		return
+	}
+	hash := snapshot.PermissionsHash(user)
+	if notModified(w, r, hash) {
+		return
+	}
+	WriteOutput(w, r, map[string]string{"user": user.Name, "hash": hash}, nil)
+}
+
+// notModified sets the ETag of the response to the hash, and returns true after writing a 304 if the
+// If-None-Match header of the request matches it. The query parameters select the representation, e.g.
+// the output format or the page, so they are part of the ETag.
+func notModified(w http.ResponseWriter, r *http.Request, hash string) bool {
+	etag := `"` + hash + `"`
+	if r.URL.RawQuery != "" {
+		query := sha256.Sum256([]byte(r.URL.RawQuery))
+		etag = `"` + hash + "-" + hex.EncodeToString(query[:8]) + `"`
+	}
+	w.Header().Set("ETag", etag)
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package business
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissionsHash(t *testing.T) {
+	permissions := map[AccessRequest]bool{
+		{Namespace: "ns1", Resource: "pods", Verb: "get"}:  true,
+		{Namespace: "ns1", Resource: "pods", Verb: "list"}: true,
+	}
+	hash := PermissionsHash(permissions)
+	assert.True(t, strings.HasPrefix(hash, permissionsHashPrefix), hash)
+
+	// Permissions not granted are ignored
+	permissions[AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"}] = false
+	assert.Equal(t, hash, PermissionsHash(permissions))
+
+	permissions[AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"}] = true
+	assert.NotEqual(t, hash, PermissionsHash(permissions))
+
+	// The fields cannot be shifted into one another
+	assert.NotEqual(t,
+		PermissionsHash(map[AccessRequest]bool{{Namespace: "ns1", Resource: "pods", Verb: "get"}: true}),
+		PermissionsHash(map[AccessRequest]bool{{Namespace: "ns1", APIGroup: "pods", Verb: "get"}: true}),
+	)
+	assert.Equal(t, PermissionsHash(nil), PermissionsHash(map[AccessRequest]bool{}))
+}
+
+func TestRBACSnapshotPermissionsHash(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+
+	// Equal permissions have the same hash whatever the bindings granting them
+	other := testSnapshot(
+		testRole("ns1", "reader", testRule([]string{""}, []string{"pods"}, []string{"watch", "list", "get"})),
+		testRoleBinding("ns1", "carol-pods", "Role", "reader", testUser("carol")),
+	)
+	assert.Equal(t, snapshot.PermissionsHash(UserInfo{Name: "alice"}), other.PermissionsHash(UserInfo{Name: "carol"}))
+	assert.NotEqual(t, snapshot.PermissionsHash(UserInfo{Name: "alice"}), snapshot.PermissionsHash(UserInfo{Name: "bob"}))
+}
+
+func TestContentHash(t *testing.T) {
+	hash, err := ContentHash(map[string]string{"user": "alice"})
+	require.NoError(t, err)
+	again, err := ContentHash(map[string]string{"user": "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, hash, again)
+	assert.Len(t, hash, 64)
+
+	_, err = ContentHash(make(chan int))
+	assert.Error(t, err)
+}
+
+func TestNotModified(t *testing.T) {
+	serve := func(url, ifNoneMatch string) (*httptest.ResponseRecorder, bool) {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		return rec, notModified(rec, req, "sha256:v1:abc")
+	}
+
+	rec, ok := serve("/api/permissions/hash", "")
+	assert.False(t, ok)
+	etag := rec.Header().Get("ETag")
+	assert.Equal(t, `"sha256:v1:abc"`, etag)
+
+	rec, ok = serve("/api/permissions/hash", `"other", W/`+etag)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	_, ok = serve("/api/permissions/hash", "*")
+	assert.True(t, ok)
+
+	// The query selects the representation, so it is part of the ETag
+	rec, ok = serve("/api/permissions/hash?output=yaml", etag)
+	assert.False(t, ok)
+	yamlETag := rec.Header().Get("ETag")
+	assert.NotEqual(t, etag, yamlETag)
+	_, ok = serve("/api/permissions/hash?output=yaml", yamlETag)
+	assert.True(t, ok)
+}
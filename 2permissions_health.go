@@ -0,0 +1,79 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// readinessTimeout bounds the time spent by all the readiness checks of a probe.
+const readinessTimeout = 5 * time.Second
+
+// readinessCheck is a named readiness condition of the server.
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// healthz reports that the process is alive. It does not depend on external systems, so a
+// cluster or cache outage does not get the pod restarted.
+func (in *PermissionsServer) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+// readyz reports whether the server can answer permission questions: the informers are synced,
+// the cache backend is reachable and so is the apiserver. Like the apiserver probes, the result of
+// each check is listed in the body and the status is 503 if any of them fails.
+func (in *PermissionsServer) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	body := strings.Builder{}
+	ready := true
+	for _, c := range in.readinessChecks() {
+		if err := c.check(ctx); err != nil {
+			ready = false
+			fmt.Fprintf(&body, "[-]%s failed: %v\n", c.name, err)
+		} else {
+			fmt.Fprintf(&body, "[+]%s ok\n", c.name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	if ready {
+		w.WriteHeader(http.StatusOK)
+		body.WriteString("readyz check passed\n")
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		body.WriteString("readyz check failed\n")
+	}
+	_, _ = w.Write([]byte(body.String()))
+}
+
+func (in *PermissionsServer) readinessChecks() []readinessCheck {
+	checks := []readinessCheck{}
+	if in.watcher != nil {
+		checks = append(checks, readinessCheck{name: "informers", check: func(ctx context.Context) error {
+			if !in.watcher.HasSynced() {
+				return errors.New("RBAC informers not synced")
+			}
+			return nil
+		}})
+	}
+	if in.checker != nil {
+		checks = append(checks, readinessCheck{name: "cache", check: in.checker.PingCache})
+	}
+	// The self-query servers have no credentials to ask the apiserver
+	if in.client != nil {
+		checks = append(checks, readinessCheck{name: "apiserver", check: func(ctx context.Context) error {
+			_, err := in.client.ServerVersion()
+			return err
+		}})
+	}
+	return checks
+}
@@ -0,0 +1,43 @@
+package business
+
+import (
+	"net/http"
+	"testing"
+
+	"k8s.io/client-go/informers"
+	kube_fake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthzIsOpen(t *testing.T) {
+	w := serve(newTestServer(&testReviews{}, nil), "GET", "/healthz", "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+}
+
+func TestReadyz(t *testing.T) {
+	watcher, _ := startTestWatcher(t, nil)
+	client := newTestClient(&testReviews{}, podReaderObjects()...)
+	server := NewPermissionsServer(client, NewPermissionChecker(client), watcher)
+
+	w := serve(server, "GET", "/readyz", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "[+]informers ok")
+	assert.Contains(t, w.Body.String(), "[+]cache ok")
+	assert.Contains(t, w.Body.String(), "[+]apiserver ok")
+	assert.Contains(t, w.Body.String(), "readyz check passed")
+}
+
+func TestReadyzFailsUntilTheInformersSync(t *testing.T) {
+	watcher := NewPermissionWatcher(informers.NewSharedInformerFactory(kube_fake.NewSimpleClientset(), 0))
+	client := newTestClient(&testReviews{})
+	server := NewPermissionsServer(client, NewPermissionChecker(client), watcher)
+
+	w := serve(server, "GET", "/readyz", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "[-]informers failed")
+	assert.Contains(t, w.Body.String(), "[+]cache ok")
+	assert.Contains(t, w.Body.String(), "readyz check failed")
+}
@@ -0,0 +1,113 @@
+package business
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kiali/kiali/log"
+)
+
+// DefaultPermissionHistorySize is the number of changes kept in memory by a PermissionHistoryRecorder.
+const DefaultPermissionHistorySize = 1000
+
+// historyStoreTimeout bounds the calls to the PermissionHistoryStore, so a stuck store does not stall
+// the watcher recording the changes.
+const historyStoreTimeout = 10 * time.Second
+
+// PermissionHistoryStore persists permission changes beyond the in-memory history.
+type PermissionHistoryStore interface {
+	Append(ctx context.Context, change PermissionChange) error
+}
+
+// PermissionBaselineStore is a PermissionHistoryStore also persisting the complete permissions of the
+// users when they start being watched, from which their changes apply, e.g. SQLiteHistoryStore.
+type PermissionBaselineStore interface {
+	PermissionHistoryStore
+	AppendBaseline(ctx context.Context, user string, permissions []AccessRequest, timestamp time.Time) error
+}
+
+// PermissionHistoryRecorder keeps the most recent permission changes in a ring buffer and,
+// optionally, forwards every change to a persistent store.
+type PermissionHistoryRecorder struct {
+	store PermissionHistoryStore
+
+	mu      sync.RWMutex
+	entries []PermissionChange
+	next    int
+	full    bool
+}
+
+// NewPermissionHistoryRecorder creates a recorder keeping the last size changes in memory.
+// The store can be nil if changes should not be persisted.
+func NewPermissionHistoryRecorder(size int, store PermissionHistoryStore) *PermissionHistoryRecorder {
+	if size <= 0 {
+		size = DefaultPermissionHistorySize
+	}
+	return &PermissionHistoryRecorder{
+		store:   store,
+		entries: make([]PermissionChange, size),
+	}
+}
+
+// Record appends the change to the history. Persistence errors, including the stores not answering within
+// historyStoreTimeout, are logged, but the change is always kept in memory.
+func (in *PermissionHistoryRecorder) Record(change PermissionChange) {
+	in.mu.Lock()
+	in.entries[in.next] = change
+	in.next = (in.next + 1) % len(in.entries)
+	if in.next == 0 {
+		in.full = true
+	}
+	in.mu.Unlock()
+
+	if in.store != nil {
+		// The in-memory history is queried by user, only the persisted one is redacted
+		change = currentRedaction().PermissionChange(change)
+		ctx, cancel := context.WithTimeout(context.Background(), historyStoreTimeout)
+		defer cancel()
+		if err := in.store.Append(ctx, change); err != nil {
+			log.Errorf("Error persisting permission change of user %s: %v", change.User, err)
+		}
+	}
+}
+
+// RecordBaseline persists the complete permissions of the user, if the store is a PermissionBaselineStore.
+// They are not kept in memory.
+func (in *PermissionHistoryRecorder) RecordBaseline(user string, permissions map[AccessRequest]bool, timestamp time.Time) {
+	store, ok := in.store.(PermissionBaselineStore)
+	if !ok {
+		return
+	}
+	policy := currentRedaction()
+	baseline := make([]AccessRequest, 0, len(permissions))
+	for permission := range permissions {
+		baseline = append(baseline, policy.Request(permission))
+	}
+	sortAccessRequests(baseline)
+	ctx, cancel := context.WithTimeout(context.Background(), historyStoreTimeout)
+	defer cancel()
+	if err := store.AppendBaseline(ctx, policy.Username(user), baseline, timestamp); err != nil {
+		log.Errorf("Error persisting permission baseline of user %s: %v", policy.Username(user), err)
+	}
+}
+
+// History returns the in-memory changes of the user, oldest first.
+// An empty username returns the changes of all the users.
+func (in *PermissionHistoryRecorder) History(username string) []PermissionChange {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+
+	ordered := in.entries[:in.next]
+	if in.full {
+		ordered = append(append([]PermissionChange{}, in.entries[in.next:]...), in.entries[:in.next]...)
+	}
+
+	history := []PermissionChange{}
+	for _, change := range ordered {
+		if username == "" || change.User == username {
+			history = append(history, change)
+		}
+	}
+	return history
+}
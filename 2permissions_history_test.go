@@ -0,0 +1,147 @@
+package business
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHistoryStore is a PermissionBaselineStore keeping the changes and baselines in memory.
+type testHistoryStore struct {
+	mu        sync.Mutex
+	changes   []PermissionChange
+	baselines map[string][]AccessRequest
+	err       error
+	// blocked, when set, blocks the appends until it is closed
+	blocked chan struct{}
+	// bounded is true if the contexts of the appends had a deadline
+	bounded bool
+}
+
+func (in *testHistoryStore) Append(ctx context.Context, change PermissionChange) error {
+	if in.blocked != nil {
+		<-in.blocked
+	}
+	_, bounded := ctx.Deadline()
+	in.mu.Lock()
+	in.bounded = bounded
+	in.mu.Unlock()
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.err != nil {
+		return in.err
+	}
+	in.changes = append(in.changes, change)
+	return nil
+}
+
+func (in *testHistoryStore) AppendBaseline(ctx context.Context, user string, permissions []AccessRequest, timestamp time.Time) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.baselines == nil {
+		in.baselines = map[string][]AccessRequest{}
+	}
+	in.baselines[user] = permissions
+	return nil
+}
+
+func TestPermissionHistoryRecorderKeepsTheLatestChanges(t *testing.T) {
+	recorder := NewPermissionHistoryRecorder(3, nil)
+	for _, user := range []string{"alice", "bob", "alice", "carol"} {
+		recorder.Record(PermissionChange{User: user})
+	}
+
+	users := []string{}
+	for _, change := range recorder.History("") {
+		users = append(users, change.User)
+	}
+	assert.Equal(t, []string{"bob", "alice", "carol"}, users)
+	assert.Len(t, recorder.History("alice"), 1)
+	assert.Empty(t, recorder.History("dave"))
+}
+
+func TestPermissionHistoryRecorderPersistsTheChanges(t *testing.T) {
+	store := &testHistoryStore{}
+	recorder := NewPermissionHistoryRecorder(0, store)
+
+	recorder.Record(PermissionChange{User: "alice", Gained: []AccessRequest{{Resource: "pods", Verb: "get"}}})
+	require.Len(t, store.changes, 1)
+	assert.Equal(t, "alice", store.changes[0].User)
+
+	// Changes not persisted are still kept in memory
+	store.err = errTestAPIServer
+	recorder.Record(PermissionChange{User: "bob"})
+	assert.Len(t, store.changes, 1)
+	assert.Len(t, recorder.History(""), 2)
+}
+
+func TestPermissionHistoryRecorderBaseline(t *testing.T) {
+	store := &testHistoryStore{}
+	recorder := NewPermissionHistoryRecorder(0, store)
+	get := AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}
+	list := AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "list"}
+
+	recorder.RecordBaseline("alice", map[AccessRequest]bool{list: true, get: true}, time.Now())
+	assert.Equal(t, []AccessRequest{get, list}, store.baselines["alice"])
+	assert.Empty(t, recorder.History(""))
+
+	// Stores without baselines are skipped
+	NewPermissionHistoryRecorder(0, nil).RecordBaseline("alice", map[AccessRequest]bool{get: true}, time.Now())
+}
+
+func TestPermissionWatcherRecordsTheHistory(t *testing.T) {
+	store := &testHistoryStore{}
+	recorder := NewPermissionHistoryRecorder(0, store)
+	watcher, k8s := startTestWatcher(t, func(watcher *PermissionWatcher) {
+		watcher.SetHistoryRecorder(recorder)
+	})
+	changes, cancel := watcher.Watch(UserInfo{Name: "alice"})
+	defer cancel()
+	store.mu.Lock()
+	assert.Len(t, store.baselines["alice"], 3)
+	store.mu.Unlock()
+
+	require.NoError(t, k8s.RbacV1().RoleBindings("ns1").Delete(testCtx, "alice-pods", meta_v1.DeleteOptions{}))
+	change := receiveChange(t, changes)
+
+	// Recorded once the change is sent
+	require.Eventually(t, func() bool { return len(recorder.History("alice")) == 1 }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, change.Lost, recorder.History("alice")[0].Lost)
+	store.mu.Lock()
+	assert.Len(t, store.changes, 1)
+	assert.True(t, store.bounded)
+	store.mu.Unlock()
+}
+
+func TestPermissionWatcherIsNotBlockedByTheHistoryStore(t *testing.T) {
+	store := &testHistoryStore{blocked: make(chan struct{})}
+	defer close(store.blocked)
+	watcher, k8s := startTestWatcher(t, func(watcher *PermissionWatcher) {
+		watcher.SetHistoryRecorder(NewPermissionHistoryRecorder(0, store))
+	})
+	changes, cancel := watcher.Watch(UserInfo{Name: "alice"})
+	defer cancel()
+
+	require.NoError(t, k8s.RbacV1().RoleBindings("ns1").Delete(testCtx, "alice-pods", meta_v1.DeleteOptions{}))
+	receiveChange(t, changes)
+
+	// The change of alice is stuck in the store, the subscriptions are not
+	subscribed := make(chan struct{})
+	go func() {
+		_, cancel := watcher.Watch(UserInfo{Name: "bob"})
+		cancel()
+		close(subscribed)
+	}()
+	select {
+	case <-subscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the subscriptions are locked while the change is recorded")
+	}
+}
@@ -0,0 +1,159 @@
+package business
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/kiali/kiali/log"
+)
+
+const (
+	// HNCInheritedFromLabel is set by the Hierarchical Namespace Controller on the objects it propagates,
+	// with the namespace of the source object.
+	HNCInheritedFromLabel = "hnc.x-k8s.io/inherited-from"
+	// HNCNoPropagationAnnotation set to "true" excludes an object from propagation.
+	HNCNoPropagationAnnotation = "propagate.hnc.x-k8s.io/none"
+	// hncDepthLabelSuffix is the suffix of the tree labels set by HNC on every namespace of a hierarchy,
+	// <ancestor>.tree.hnc.x-k8s.io/depth=<distance>.
+	hncDepthLabelSuffix = ".tree.hnc.x-k8s.io/depth"
+)
+
+// hncDisabledWarning logs once that the hierarchies are ignored, see FeatureHNC.
+var hncDisabledWarning sync.Once
+
+// NamespaceHierarchy is the tree of the namespaces managed by the Hierarchical Namespace Controller.
+type NamespaceHierarchy struct {
+	// parents is keyed by namespace. Root namespaces are not in the map.
+	parents map[string]string
+}
+
+// NewNamespaceHierarchy reads the hierarchy from the tree labels HNC sets on the namespaces.
+func NewNamespaceHierarchy(namespaces []core_v1.Namespace) *NamespaceHierarchy {
+	hierarchy := &NamespaceHierarchy{parents: map[string]string{}}
+	for _, namespace := range namespaces {
+		if parent := hncParent(namespace.Labels); parent != "" {
+			hierarchy.parents[namespace.Name] = parent
+		}
+	}
+	return hierarchy
+}
+
+// hncParent returns the parent of a namespace from its tree labels, or "" for root namespaces.
+func hncParent(labels map[string]string) string {
+	for key, depth := range labels {
+		if depth == "1" && strings.HasSuffix(key, hncDepthLabelSuffix) {
+			return strings.TrimSuffix(key, hncDepthLabelSuffix)
+		}
+	}
+	return ""
+}
+
+// Parent returns the parent of the namespace, or "" for root namespaces.
+func (in *NamespaceHierarchy) Parent(namespace string) string {
+	return in.parents[namespace]
+}
+
+// Ancestors returns the ancestors of the namespace, the parent first.
+func (in *NamespaceHierarchy) Ancestors(namespace string) []string {
+	ancestors := []string{}
+	seen := map[string]bool{namespace: true}
+	for parent := in.parents[namespace]; parent != "" && !seen[parent]; parent = in.parents[parent] {
+		seen[parent] = true
+		ancestors = append(ancestors, parent)
+	}
+	return ancestors
+}
+
+// Descendants returns the descendants of the namespace, sorted.
+func (in *NamespaceHierarchy) Descendants(namespace string) []string {
+	descendants := []string{}
+	for child := range in.parents {
+		for _, ancestor := range in.Ancestors(child) {
+			if ancestor == namespace {
+				descendants = append(descendants, child)
+				break
+			}
+		}
+	}
+	sort.Strings(descendants)
+	return descendants
+}
+
+// WithHierarchy returns a snapshot where the Roles and RoleBindings of the namespaces are also in all
+// their descendants, as HNC propagates them, so the effective namespaced permissions include the
+// inherited grants. This covers the namespaces HNC has not synced yet, and the snapshots filtered to
+// some namespaces. Objects excluded with HNCNoPropagationAnnotation, and objects already present in the
+// descendant with the same name, are not copied. Copies get HNCInheritedFromLabel, like HNC's.
+// Without the FeatureHNC feature gate, the snapshot is returned unchanged.
+func (in *RBACSnapshot) WithHierarchy(hierarchy *NamespaceHierarchy) *RBACSnapshot {
+	if !FeatureEnabled(FeatureHNC) {
+		hncDisabledWarning.Do(func() {
+			log.Warningf("Ignoring the namespace hierarchy, the %s feature gate is disabled", FeatureHNC)
+		})
+		return in
+	}
+	inherited := *in
+	inherited.Roles = make(map[string]*rbac_v1.Role, len(in.Roles))
+	for key, role := range in.Roles {
+		inherited.Roles[key] = role
+	}
+	inherited.RoleBindings = append([]*rbac_v1.RoleBinding{}, in.RoleBindings...)
+
+	bindings := map[string]bool{}
+	for _, rb := range in.RoleBindings {
+		bindings[rb.Namespace+"/"+rb.Name] = true
+	}
+	for namespace := range hierarchy.parents {
+		for _, ancestor := range hierarchy.Ancestors(namespace) {
+			for _, role := range in.Roles {
+				if role.Namespace != ancestor || !hncPropagates(role.Labels, role.Annotations) {
+					continue
+				}
+				if _, exists := inherited.Roles[namespace+"/"+role.Name]; !exists {
+					copied := role.DeepCopy()
+					copied.Namespace = namespace
+					copied.Labels = hncInheritedLabels(copied.Labels, ancestor)
+					inherited.Roles[namespace+"/"+role.Name] = copied
+				}
+			}
+			for _, rb := range in.RoleBindings {
+				if rb.Namespace != ancestor || !hncPropagates(rb.Labels, rb.Annotations) || bindings[namespace+"/"+rb.Name] {
+					continue
+				}
+				bindings[namespace+"/"+rb.Name] = true
+				copied := rb.DeepCopy()
+				copied.Namespace = namespace
+				copied.Labels = hncInheritedLabels(copied.Labels, ancestor)
+				inherited.RoleBindings = append(inherited.RoleBindings, copied)
+			}
+		}
+	}
+	return &inherited
+}
+
+// InheritedFrom returns the namespace a RoleBinding was propagated from by HNC, or "" for the
+// RoleBindings created in their namespace.
+func InheritedFrom(rb *rbac_v1.RoleBinding) string {
+	return rb.Labels[HNCInheritedFromLabel]
+}
+
+// hncPropagates returns true if HNC propagates the object: it is not a propagated copy, whose own source
+// is propagated instead, and it is not excluded.
+func hncPropagates(labels, annotations map[string]string) bool {
+	return labels[HNCInheritedFromLabel] == "" && annotations[HNCNoPropagationAnnotation] != "true"
+}
+
+// hncInheritedLabels returns a copy of the labels with the inherited-from label of HNC. Objects
+// propagated through several levels keep the namespace of the original.
+func hncInheritedLabels(labels map[string]string, source string) map[string]string {
+	inherited := make(map[string]string, len(labels)+1)
+	for key, value := range labels {
+		inherited[key] = value
+	}
+	inherited[HNCInheritedFromLabel] = source
+	return inherited
+}
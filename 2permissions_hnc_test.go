@@ -0,0 +1,194 @@
+package business
+
+import (
+	"testing"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withTestFeatureGates sets the feature gates of the process for the test.
+func withTestFeatureGates(t *testing.T, gates FeatureGates) {
+	SetFeatureGates(gates)
+	t.Cleanup(func() { SetFeatureGates(FeatureGates{}) })
+}
+
+// hncNamespace returns a namespace with the tree labels of HNC, the parent first.
+func hncNamespace(name string, ancestors ...string) core_v1.Namespace {
+	labels := map[string]string{name + hncDepthLabelSuffix: "0"}
+	for i, ancestor := range ancestors {
+		labels[ancestor+hncDepthLabelSuffix] = string(rune('1' + i))
+	}
+	return core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+// testHierarchyNamespaces are ns1 with the child team-a, itself with the child team-a-dev, and the root ns2.
+func testHierarchyNamespaces() []core_v1.Namespace {
+	return []core_v1.Namespace{
+		hncNamespace("ns1"),
+		hncNamespace("team-a", "ns1"),
+		hncNamespace("team-a-dev", "team-a", "ns1"),
+		hncNamespace("ns2"),
+	}
+}
+
+// testHierarchyObjects returns the testHierarchyNamespaces, for a fake clientset.
+func testHierarchyObjects() []runtime.Object {
+	objects := []runtime.Object{}
+	for _, namespace := range testHierarchyNamespaces() {
+		namespace := namespace
+		objects = append(objects, &namespace)
+	}
+	return objects
+}
+
+// testHierarchy is the hierarchy of the testHierarchyNamespaces.
+func testHierarchy() *NamespaceHierarchy {
+	return NewNamespaceHierarchy(testHierarchyNamespaces())
+}
+
+func TestNamespaceHierarchy(t *testing.T) {
+	hierarchy := testHierarchy()
+
+	assert.Equal(t, "team-a", hierarchy.Parent("team-a-dev"))
+	assert.Equal(t, "", hierarchy.Parent("ns1"))
+	assert.Equal(t, []string{"team-a", "ns1"}, hierarchy.Ancestors("team-a-dev"))
+	assert.Empty(t, hierarchy.Ancestors("ns2"))
+	assert.Equal(t, []string{"team-a", "team-a-dev"}, hierarchy.Descendants("ns1"))
+	assert.Equal(t, []string{"team-a-dev"}, hierarchy.Descendants("team-a"))
+	assert.Empty(t, hierarchy.Descendants("ns2"))
+
+	// Cycles do not loop forever
+	cycle := &NamespaceHierarchy{parents: map[string]string{"a": "b", "b": "a"}}
+	assert.Equal(t, []string{"b"}, cycle.Ancestors("a"))
+}
+
+func TestWithHierarchy(t *testing.T) {
+	withTestFeatureGates(t, FeatureGates{FeatureHNC: true})
+	excluded := testRoleBinding("ns1", "local-only", "ClusterRole", "pod-reader", testUser("dave"))
+	excluded.Annotations = map[string]string{HNCNoPropagationAnnotation: "true"}
+	// team-a already has its own developers-deployments binding, to another role
+	overridden := testRoleBinding("team-a", "developers-deployments", "ClusterRole", "pod-reader", testGroup("developers"))
+	snapshot := testSnapshot(append(podReaderObjects(), excluded, overridden)...)
+
+	inherited := snapshot.WithHierarchy(testHierarchy())
+
+	alice := UserInfo{Name: "alice"}
+	for _, namespace := range []string{"ns1", "team-a", "team-a-dev"} {
+		assert.True(t, inherited.Explain(alice, AccessRequest{Namespace: namespace, Resource: "pods", Verb: "get"}).Allowed, namespace)
+	}
+	assert.False(t, inherited.Explain(alice, AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "get"}).Allowed)
+	assert.False(t, inherited.Explain(UserInfo{Name: "dave"}, AccessRequest{Namespace: "team-a", Resource: "pods", Verb: "get"}).Allowed)
+
+	// The binding of team-a, the nearest, is propagated to team-a-dev instead of the one of ns1
+	carol := UserInfo{Name: "carol", Groups: []string{"developers"}}
+	for _, namespace := range []string{"team-a", "team-a-dev"} {
+		assert.False(t, inherited.Explain(carol, AccessRequest{Namespace: namespace, APIGroup: "apps", Resource: "deployments", Verb: "update"}).Allowed, namespace)
+		assert.True(t, inherited.Explain(carol, AccessRequest{Namespace: namespace, Resource: "pods", Verb: "get"}).Allowed, namespace)
+	}
+	assert.True(t, inherited.Explain(carol, AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "update"}).Allowed)
+
+	// Copies are labeled with the namespace of the original, even through several levels
+	for _, rb := range inherited.RoleBindings {
+		if rb.Namespace == "team-a-dev" && rb.Name == "alice-pods" {
+			assert.Equal(t, "ns1", InheritedFrom(rb))
+		}
+	}
+	role, ok := inherited.Roles["team-a-dev/deployment-editor"]
+	require.True(t, ok)
+	assert.Equal(t, "ns1", role.Labels[HNCInheritedFromLabel])
+
+	// The snapshot is not modified
+	assert.Len(t, snapshot.RoleBindings, 4)
+	assert.Len(t, snapshot.Roles, 1)
+	assert.Equal(t, "", InheritedFrom(snapshot.RoleBindings[0]))
+}
+
+func TestWithHierarchyRequiresTheFeatureGate(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+	assert.Same(t, snapshot, snapshot.WithHierarchy(testHierarchy()))
+}
+
+func TestLoadRBACSnapshotWithHierarchy(t *testing.T) {
+	client := newTestClient(&testReviews{}, append(podReaderObjects(), testHierarchyObjects()...)...)
+	alice := UserInfo{Name: "alice"}
+	pods := AccessRequest{Namespace: "team-a-dev", Resource: "pods", Verb: "get"}
+
+	snapshot, err := LoadRBACSnapshot(testCtx, client)
+	require.NoError(t, err)
+	assert.False(t, snapshot.Explain(alice, pods).Allowed)
+
+	withTestFeatureGates(t, FeatureGates{FeatureHNC: true})
+	snapshot, err = LoadRBACSnapshot(testCtx, client)
+	require.NoError(t, err)
+	assert.True(t, snapshot.Explain(alice, pods).Allowed)
+
+	// The clients not listing the namespaces cannot load the hierarchy
+	_, err = LoadRBACSnapshot(testCtx, struct{ PermissionsClient }{client})
+	assert.ErrorContains(t, err, "the client does not list the namespaces")
+}
+
+func TestPermissionWatcherWithHierarchy(t *testing.T) {
+	withTestFeatureGates(t, FeatureGates{FeatureHNC: true})
+	slices := make(chan []CacheSlice, 10)
+	watcher, k8s := startTestWatcher(t, func(watcher *PermissionWatcher) {
+		watcher.SetChangeHandler(func(causes []RBACObjectRef, changed []CacheSlice) {
+			for _, cause := range causes {
+				if cause.Name == "carol-pods" {
+					slices <- changed
+					return
+				}
+			}
+		})
+	}, testHierarchyObjects()...)
+	assert.True(t, watcher.Snapshot().Explain(UserInfo{Name: "alice"}, AccessRequest{Namespace: "team-a-dev", Resource: "pods", Verb: "get"}).Allowed)
+	changes, cancel := watcher.Watch(UserInfo{Name: "carol"})
+	defer cancel()
+
+	// The binding of team-a is inherited by team-a-dev
+	_, err := k8s.RbacV1().RoleBindings("team-a").Create(testCtx, testRoleBinding("team-a", "carol-pods", "ClusterRole", "pod-reader", testUser("carol")), meta_v1.CreateOptions{})
+	require.NoError(t, err)
+	select {
+	case changed := <-slices:
+		assert.Equal(t, []CacheSlice{{Namespace: "team-a", User: "carol"}, {Namespace: "team-a-dev", User: "carol"}}, changed)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "change handler not called")
+	}
+	change := receiveChange(t, changes)
+	assert.Len(t, change.Gained, 6)
+	assert.Contains(t, change.Gained, AccessRequest{Namespace: "team-a-dev", Resource: "pods", Verb: "list"})
+}
+
+func TestPermissionWatcherNotifiesTheNewParents(t *testing.T) {
+	watcher := NewPermissionWatcher(nil)
+	handler := watcher.hierarchyHandler()
+	root, child := hncNamespace("ns2"), hncNamespace("ns2")
+	child.Labels["ns1"+hncDepthLabelSuffix] = "1"
+
+	handler.OnAdd(&root, false)
+	handler.OnUpdate(&root, &root)
+	causes, slices := watcher.takeCauses()
+	assert.Empty(t, causes)
+	assert.Empty(t, slices)
+
+	handler.OnUpdate(&root, &child)
+	causes, slices = watcher.takeCauses()
+	assert.Equal(t, []RBACObjectRef{{Kind: "Namespace", Name: "ns2"}}, causes)
+	assert.Equal(t, []CacheSlice{{Namespace: "ns2"}}, slices)
+}
+
+func TestWithDescendants(t *testing.T) {
+	hierarchy := testHierarchy()
+
+	assert.Equal(t, []CacheSlice{{Namespace: "ns2"}, {Namespace: "team-a", User: "alice"}, {Namespace: "team-a-dev", User: "alice"}},
+		withDescendants([]CacheSlice{{Namespace: "ns2"}, {Namespace: "team-a", User: "alice"}}, hierarchy))
+	// The user slices are included in the slices of the whole namespaces
+	assert.Equal(t, []CacheSlice{{Namespace: "ns1"}, {Namespace: "team-a"}, {Namespace: "team-a-dev"}},
+		withDescendants([]CacheSlice{{Namespace: "ns1"}, {Namespace: "team-a-dev", User: "alice"}}, hierarchy))
+	assert.Nil(t, withDescendants(nil, hierarchy))
+}
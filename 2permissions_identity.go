@@ -0,0 +1,187 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kiali/kiali/log"
+)
+
+// LocalIdentityPrefix marks the canonical identities of the usernames and groups of a cluster that are not
+// correlated with the other clusters, see IdentityMapping.
+const LocalIdentityPrefix = "local:"
+
+// IdentityMapping maps the usernames and groups of a cluster to the canonical identities shared by all
+// the clusters, so the same human is correlated across clusters, e.g. when their OIDC issuers use
+// different prefixes or claims. Explicit mappings take precedence over the prefixes. The system: identities
+// of Kubernetes are the same in every cluster. The other identities whose canonical name would be ambiguous,
+// i.e. those without the prefix, and those whose name without the prefix is an explicitly mapped canonical
+// name or a system: name, are kept distinct: their canonical name is the cluster name after
+// LocalIdentityPrefix. So two identities of a cluster never share a canonical identity.
+type IdentityMapping struct {
+	// UsernamePrefix is the prefix of the usernames of the cluster, e.g. oidc:, absent from the canonical ones.
+	UsernamePrefix string `yaml:"username_prefix"`
+	// GroupPrefix is the prefix of the groups of the cluster absent from the canonical ones.
+	GroupPrefix string `yaml:"group_prefix"`
+	// Usernames maps cluster usernames to canonical usernames, e.g. when the cluster uses the sub claim
+	// and the others the email claim.
+	Usernames map[string]string `yaml:"usernames"`
+	// Groups maps cluster groups to canonical groups.
+	Groups map[string]string `yaml:"groups"`
+}
+
+// IsEmpty returns true if the identities of the cluster are the canonical ones.
+func (in IdentityMapping) IsEmpty() bool {
+	return in.UsernamePrefix == "" && in.GroupPrefix == "" && len(in.Usernames) == 0 && len(in.Groups) == 0
+}
+
+// validate rejects the explicit mappings that cannot be reversed, mapping two cluster identities to the
+// same canonical identity, or contradicting the prefix, and the reserved canonical identities.
+func (in IdentityMapping) validate() error {
+	for _, kind := range []struct {
+		name    string
+		mapping map[string]string
+		prefix  string
+	}{{"username", in.Usernames, in.UsernamePrefix}, {"group", in.Groups, in.GroupPrefix}} {
+		seen := make(map[string]string, len(kind.mapping))
+		for _, local := range sortedMapKeys(kind.mapping) {
+			canonical := kind.mapping[local]
+			if other, ok := seen[canonical]; ok {
+				return fmt.Errorf("%ss %s and %s are both mapped to canonical %s %s", kind.name, other, local, kind.name, canonical)
+			}
+			seen[canonical] = local
+			if strings.HasPrefix(canonical, LocalIdentityPrefix) || strings.HasPrefix(canonical, "system:") {
+				return fmt.Errorf("%s %s is mapped to the reserved canonical %s %s", kind.name, local, kind.name, canonical)
+			}
+			if trimmed := strings.TrimPrefix(local, kind.prefix); kind.prefix != "" && trimmed != local && trimmed != canonical {
+				return fmt.Errorf("%s %s is mapped to canonical %s %s, but its prefix maps it to %s", kind.name, local, kind.name, canonical, trimmed)
+			}
+		}
+	}
+	return nil
+}
+
+// ToCanonical maps a user of the cluster to its canonical identity. Extra attributes are kept.
+func (in IdentityMapping) ToCanonical(user UserInfo) UserInfo {
+	canonical := user
+	canonical.Name = mapIdentity(user.Name, in.Usernames, in.UsernamePrefix)
+	canonical.Groups = make([]string, 0, len(user.Groups))
+	for _, group := range user.Groups {
+		canonical.Groups = append(canonical.Groups, mapIdentity(group, in.Groups, in.GroupPrefix))
+	}
+	return canonical
+}
+
+// ToCluster maps a canonical user to its identity in the cluster, the inverse of ToCanonical.
+func (in IdentityMapping) ToCluster(user UserInfo) UserInfo {
+	local := user
+	local.Name = unmapIdentity(user.Name, in.Usernames, in.UsernamePrefix)
+	local.Groups = make([]string, 0, len(user.Groups))
+	for _, group := range user.Groups {
+		local.Groups = append(local.Groups, unmapIdentity(group, in.Groups, in.GroupPrefix))
+	}
+	return local
+}
+
+// mapIdentity returns the canonical name of the cluster name, see IdentityMapping.
+func mapIdentity(name string, mapping map[string]string, prefix string) string {
+	if canonical, ok := mapping[name]; ok {
+		return canonical
+	}
+	if strings.HasPrefix(name, "system:") {
+		return name
+	}
+	trimmed, prefixed := strings.CutPrefix(name, prefix)
+	if !prefixed || isMappedCanonical(trimmed, mapping) || strings.HasPrefix(trimmed, LocalIdentityPrefix) || strings.HasPrefix(trimmed, "system:") {
+		return LocalIdentityPrefix + name
+	}
+	return trimmed
+}
+
+// unmapIdentity returns the cluster name of the canonical name, the inverse of mapIdentity.
+func unmapIdentity(name string, mapping map[string]string, prefix string) string {
+	for local, canonical := range mapping {
+		if canonical == name {
+			return local
+		}
+	}
+	if local, ok := strings.CutPrefix(name, LocalIdentityPrefix); ok {
+		return local
+	}
+	if strings.HasPrefix(name, "system:") {
+		return name
+	}
+	return prefix + name
+}
+
+// isMappedCanonical returns whether the name is the canonical name of an explicit mapping.
+func isMappedCanonical(name string, mapping map[string]string) bool {
+	for _, canonical := range mapping {
+		if canonical == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ClusterSnapshot is the RBAC snapshot of one of the clusters of an aggregated report.
+type ClusterSnapshot struct {
+	Cluster  string
+	Snapshot *RBACSnapshot
+	Identity IdentityMapping
+}
+
+// ClusterPermissions are the permissions of a canonical user in each cluster, keyed by cluster name.
+type ClusterPermissions struct {
+	User        UserInfo
+	Permissions map[string][]AccessRequest
+}
+
+// AggregatePermissions returns the effective permissions of the canonical users in each cluster, each
+// user being evaluated with its identity in the cluster. Users are in the given order.
+func AggregatePermissions(clusters []ClusterSnapshot, users []UserInfo) []ClusterPermissions {
+	aggregated := make([]ClusterPermissions, 0, len(users))
+	for _, user := range users {
+		permissions := ClusterPermissions{User: user, Permissions: make(map[string][]AccessRequest, len(clusters))}
+		for _, cluster := range clusters {
+			requests := []AccessRequest{}
+			for req := range cluster.Snapshot.Permissions(cluster.Identity.ToCluster(user)) {
+				requests = append(requests, req)
+			}
+			permissions.Permissions[cluster.Cluster] = requests
+		}
+		aggregated = append(aggregated, permissions)
+	}
+	return aggregated
+}
+
+// CheckAll checks the request of the canonical user in every tenant, with the identity of the user in
+// the cluster of the tenant, see PermissionsConfig.IdentityMapping. Decisions are keyed by tenant; the
+// tenants failing to decide are listed in the returned error.
+func (in *TenantRegistry) CheckAll(ctx context.Context, user UserInfo, req AccessRequest) (map[string]Decision, error) {
+	decisions := map[string]Decision{}
+	failed := []string{}
+	for _, tenant := range in.Tenants() {
+		checker, ok := in.Checker(tenant)
+		if !ok {
+			// Removed meanwhile
+			continue
+		}
+		checker.mu.RLock()
+		identity := checker.conf.IdentityMapping
+		checker.mu.RUnlock()
+
+		decision, err := checker.Check(ctx, identity.ToCluster(user), req)
+		if err != nil {
+			log.Errorf("%sFailed to check %s of user %s in tenant %s: %v", logPrefix(ctx), req.Verb, redactedUser(user.Name), tenant, err)
+			failed = append(failed, tenant)
+			continue
+		}
+		decisions[tenant] = decision
+	}
+	if len(failed) > 0 {
+		return decisions, withRequestID(ctx, fmt.Errorf("failed to check the request in tenants %s", strings.Join(failed, ", ")))
+	}
+	return decisions, nil
+}
@@ -0,0 +1,105 @@
+package business
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var oidcIdentity = IdentityMapping{
+	UsernamePrefix: "oidc:",
+	GroupPrefix:    "oidc:",
+	Usernames:      map[string]string{"0f3a9c": "carol@example.com"},
+	Groups:         map[string]string{"eng-dev": "developers"},
+}
+
+func TestIdentityMapping(t *testing.T) {
+	local := UserInfo{Name: "oidc:alice", Groups: []string{"oidc:sre", "eng-dev"}, Extra: map[string][]string{"scopes": {"read"}}}
+	canonical := oidcIdentity.ToCanonical(local)
+	assert.Equal(t, UserInfo{Name: "alice", Groups: []string{"sre", "developers"}, Extra: local.Extra}, canonical)
+	assert.Equal(t, local, oidcIdentity.ToCluster(canonical))
+
+	assert.Equal(t, "carol@example.com", oidcIdentity.ToCanonical(UserInfo{Name: "0f3a9c"}).Name)
+	assert.Equal(t, "0f3a9c", oidcIdentity.ToCluster(UserInfo{Name: "carol@example.com"}).Name)
+
+	assert.True(t, IdentityMapping{}.IsEmpty())
+	assert.False(t, oidcIdentity.IsEmpty())
+	assert.Equal(t, UserInfo{Name: "alice", Groups: []string{}}, IdentityMapping{}.ToCluster(UserInfo{Name: "alice"}))
+}
+
+func TestIdentityMappingKeepsTheAmbiguousIdentitiesDistinct(t *testing.T) {
+	local := UserInfo{Name: "alice", Groups: []string{"system:authenticated", "developers", "oidc:developers", "oidc:system:masters", "oidc:local:sre"}}
+	canonical := oidcIdentity.ToCanonical(local)
+	assert.Equal(t, "local:alice", canonical.Name)
+	assert.Equal(t, []string{"system:authenticated", "local:developers", "local:oidc:developers", "local:oidc:system:masters", "local:oidc:local:sre"}, canonical.Groups)
+	assert.Equal(t, local, oidcIdentity.ToCluster(canonical))
+
+	// The system: identities are never prefixed
+	cluster := oidcIdentity.ToCluster(UserInfo{Name: "system:serviceaccount:ns1:default", Groups: []string{"system:serviceaccounts", "sre"}})
+	assert.Equal(t, UserInfo{Name: "system:serviceaccount:ns1:default", Groups: []string{"system:serviceaccounts", "oidc:sre"}}, cluster)
+
+	// Without prefix, the unmapped names are canonical, unless they are explicitly mapped canonical names
+	explicit := IdentityMapping{Usernames: map[string]string{"0f3a9c": "carol"}}
+	assert.Equal(t, "bob", explicit.ToCanonical(UserInfo{Name: "bob"}).Name)
+	assert.Equal(t, "local:carol", explicit.ToCanonical(UserInfo{Name: "carol"}).Name)
+	assert.Equal(t, "carol", explicit.ToCluster(UserInfo{Name: "local:carol"}).Name)
+}
+
+func TestIdentityMappingMustBeReversible(t *testing.T) {
+	require.NoError(t, oidcIdentity.validate())
+
+	for _, mapping := range []IdentityMapping{
+		{UsernamePrefix: "oidc:", Usernames: map[string]string{"oidc:bob": "robert"}},
+		{GroupPrefix: "oidc:", Groups: map[string]string{"oidc:dev": "developers"}},
+		{Groups: map[string]string{"admins": "system:masters"}},
+		{Usernames: map[string]string{"0f3a9c": "local:carol"}},
+	} {
+		assert.Error(t, mapping.validate(), "%+v", mapping)
+	}
+	assert.NoError(t, IdentityMapping{UsernamePrefix: "oidc:", Usernames: map[string]string{"oidc:bob": "bob"}}.validate())
+
+	conf := NewPermissionsConfig()
+	conf.IdentityMapping = IdentityMapping{Groups: map[string]string{"eng-dev": "developers", "eng-ops": "developers"}}
+	err := newTestChecker(&testReviews{}, nil).ApplyConfig(conf)
+	assert.ErrorContains(t, err, "both mapped to canonical group developers")
+}
+
+func TestAggregatePermissions(t *testing.T) {
+	clusters := []ClusterSnapshot{
+		{Cluster: "east", Snapshot: testSnapshot(podReaderObjects()...)},
+		{Cluster: "west", Snapshot: testSnapshot(
+			testClusterRole("pod-reader", testRule([]string{""}, []string{"pods"}, []string{"get"})),
+			testRoleBinding("ns2", "alice-pods", "ClusterRole", "pod-reader", testUser("oidc:alice")),
+		), Identity: oidcIdentity},
+	}
+
+	aggregated := AggregatePermissions(clusters, []UserInfo{{Name: "alice"}, {Name: "dave"}})
+	require.Len(t, aggregated, 2)
+	assert.Equal(t, "alice", aggregated[0].User.Name)
+	assert.Len(t, aggregated[0].Permissions["east"], 3)
+	assert.Equal(t, []AccessRequest{{Namespace: "ns2", Resource: "pods", Verb: "get"}}, aggregated[0].Permissions["west"])
+	assert.Equal(t, map[string][]AccessRequest{"east": {}, "west": {}}, aggregated[1].Permissions)
+}
+
+func TestTenantRegistryCheckAll(t *testing.T) {
+	registry := NewTenantRegistry()
+	_, err := registry.Add("east", newTestClient(&testReviews{allow: allowUsers("alice")}), nil)
+	require.NoError(t, err)
+	west := NewPermissionsConfig()
+	west.IdentityMapping = oidcIdentity
+	_, err = registry.Add("west", newTestClient(&testReviews{allow: allowUsers("oidc:alice")}), west)
+	require.NoError(t, err)
+
+	decisions, err := registry.CheckAll(testCtx, UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+	require.Len(t, decisions, 2)
+	assert.True(t, decisions["east"].Allowed)
+	assert.True(t, decisions["west"].Allowed)
+
+	_, err = registry.Add("north", newTestClient(&testReviews{err: errTestAPIServer}), NewPermissionsConfig())
+	require.NoError(t, err)
+	decisions, err = registry.CheckAll(testCtx, UserInfo{Name: "alice"}, alicePods)
+	assert.ErrorContains(t, err, "failed to check the request in tenants north")
+	assert.Len(t, decisions, 2)
+}
@@ -0,0 +1,349 @@
+package business
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	kube "k8s.io/client-go/kubernetes"
+)
+
+// Names of the built-in identity providers
+const (
+	IdentityProviderTokenReview   = "tokenreview"
+	IdentityProviderX509          = "x509"
+	IdentityProviderTrustedHeader = "trusted-header"
+	IdentityProviderOIDC          = "oidc"
+)
+
+// Credentials are what the client of a request presents to authenticate. Every identity provider reads
+// the part it understands.
+type Credentials struct {
+	// Token is the bearer token of the Authorization header.
+	Token string
+	// Certificate is the client certificate, verified by the TLS server, nil without one.
+	Certificate *x509.Certificate
+	// PeerCertificates is the certificate chain presented by the client, verified or not by the TLS
+	// server, for the providers verifying it with their own CAs.
+	PeerCertificates []*x509.Certificate
+	// Header is the header of the request, e.g. for the identities asserted by an authenticating proxy.
+	Header http.Header
+	// RemoteAddr is the network address of the client, host:port.
+	RemoteAddr string
+}
+
+// CredentialsFromRequest returns the credentials of the request. The Certificate is only set if the TLS
+// server verified it, see MutualTLSConfig.
+func CredentialsFromRequest(r *http.Request) Credentials {
+	creds := Credentials{Header: r.Header, RemoteAddr: r.RemoteAddr}
+	creds.Token, _ = bearerToken(r)
+	if r.TLS != nil {
+		creds.PeerCertificates = r.TLS.PeerCertificates
+		if len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			creds.Certificate = r.TLS.VerifiedChains[0][0]
+		}
+	}
+	return creds
+}
+
+// IdentityProvider resolves the user of credentials, the authentication counterpart of Authorizer. It
+// returns ErrNoCredentials when the credentials have none of its kind, so the next provider is tried.
+type IdentityProvider interface {
+	ResolveUser(ctx context.Context, creds Credentials) (UserInfo, error)
+}
+
+// IdentityProviderFunc adapts a function to an IdentityProvider.
+type IdentityProviderFunc func(ctx context.Context, creds Credentials) (UserInfo, error)
+
+func (in IdentityProviderFunc) ResolveUser(ctx context.Context, creds Credentials) (UserInfo, error) {
+	return in(ctx, creds)
+}
+
+// IdentityProviderConfig selects a registered identity provider and configures it.
+type IdentityProviderConfig struct {
+	Name string `yaml:"name"`
+	// Options are passed to the factory of the identity provider.
+	Options map[string]string `yaml:"options"`
+}
+
+// IdentityProviderFactory creates an identity provider from the options of its IdentityProviderConfig. The
+// client is the identity of the backend, e.g. for the TokenReviews.
+type IdentityProviderFactory func(k8s kube.Interface, options map[string]string) (IdentityProvider, error)
+
+var identityProviderRegistry = struct {
+	sync.RWMutex
+	factories map[string]IdentityProviderFactory
+}{
+	factories: map[string]IdentityProviderFactory{
+		IdentityProviderTokenReview: func(k8s kube.Interface, options map[string]string) (IdentityProvider, error) {
+			return NewTokenReviewIdentityProvider(k8s), nil
+		},
+		IdentityProviderX509: func(k8s kube.Interface, options map[string]string) (IdentityProvider, error) {
+			return NewX509IdentityProvider(), nil
+		},
+		IdentityProviderTrustedHeader: func(k8s kube.Interface, options map[string]string) (IdentityProvider, error) {
+			return NewTrustedHeaderIdentityProvider(options)
+		},
+		IdentityProviderOIDC: func(k8s kube.Interface, options map[string]string) (IdentityProvider, error) {
+			return NewOIDCIdentityProvider(options)
+		},
+	},
+}
+
+// RegisterIdentityProvider makes an identity provider available to BuildAuthenticator under the name, so
+// deployments can authenticate their users with bespoke credentials, like RegisterAuthorizer. It is
+// usually called from an init function. It panics if the name is already registered.
+func RegisterIdentityProvider(name string, factory IdentityProviderFactory) {
+	identityProviderRegistry.Lock()
+	defer identityProviderRegistry.Unlock()
+	if factory == nil {
+		panic("permissions: nil factory for identity provider " + name)
+	}
+	if _, dup := identityProviderRegistry.factories[name]; dup {
+		panic("permissions: identity provider " + name + " registered twice")
+	}
+	identityProviderRegistry.factories[name] = factory
+}
+
+// RegisteredIdentityProviders returns the names of the registered identity providers, sorted.
+func RegisteredIdentityProviders() []string {
+	identityProviderRegistry.RLock()
+	defer identityProviderRegistry.RUnlock()
+	names := make([]string, 0, len(identityProviderRegistry.factories))
+	for name := range identityProviderRegistry.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BuildAuthenticator creates the authenticator trying the identity providers of the configs in order, see
+// IdentityProviderAuthenticator. Empty configs mean the x509 and tokenreview providers, like
+// FirstAuthenticator of UserInfoFromRequest and BearerTokenAuthenticator.
+func BuildAuthenticator(k8s kube.Interface, confs []IdentityProviderConfig) (Authenticator, error) {
+	if len(confs) == 0 {
+		confs = []IdentityProviderConfig{{Name: IdentityProviderX509}, {Name: IdentityProviderTokenReview}}
+	}
+	identityProviderRegistry.RLock()
+	defer identityProviderRegistry.RUnlock()
+	providers := make([]IdentityProvider, 0, len(confs))
+	for _, c := range confs {
+		factory, ok := identityProviderRegistry.factories[c.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown identity provider %q", c.Name)
+		}
+		provider, err := factory(k8s, c.Options)
+		if err != nil {
+			return nil, fmt.Errorf("error creating identity provider %s: %w", c.Name, err)
+		}
+		providers = append(providers, provider)
+	}
+	return IdentityProviderAuthenticator(providers...), nil
+}
+
+// IdentityProviderAuthenticator tries the identity providers in order. The first one finding its
+// credentials decides, like FirstAuthenticator: a request with an invalid token is not authenticated by
+// its client certificate.
+func IdentityProviderAuthenticator(providers ...IdentityProvider) Authenticator {
+	return func(r *http.Request) (UserInfo, error) {
+		creds := CredentialsFromRequest(r)
+		for _, provider := range providers {
+			user, err := provider.ResolveUser(requestContext(r), creds)
+			if errors.Is(err, ErrNoCredentials) {
+				continue
+			}
+			return user, err
+		}
+		return UserInfo{}, ErrNoCredentials
+	}
+}
+
+// NewTokenReviewIdentityProvider authenticates the bearer tokens with TokenReviews, caching the identities
+// like BearerTokenAuthenticator.
+func NewTokenReviewIdentityProvider(k8s kube.Interface) IdentityProvider {
+	cache := newTokenIdentityCache(func(ctx context.Context, token string) (UserInfo, error) {
+		return reviewToken(ctx, k8s, token)
+	})
+	return IdentityProviderFunc(func(ctx context.Context, creds Credentials) (UserInfo, error) {
+		if creds.Token == "" {
+			return UserInfo{}, ErrNoCredentials
+		}
+		return cache.resolve(ctx, creds.Token)
+	})
+}
+
+// NewX509IdentityProvider authenticates the verified client certificates like the apiserver, see
+// UserInfoFromCertificate.
+func NewX509IdentityProvider() IdentityProvider {
+	return IdentityProviderFunc(func(ctx context.Context, creds Credentials) (UserInfo, error) {
+		if creds.Certificate == nil {
+			return UserInfo{}, ErrNoCredentials
+		}
+		return UserInfoFromCertificate(creds.Certificate)
+	})
+}
+
+// trustedHeaderProvider reads the identities asserted in the headers of an authenticating proxy.
+type trustedHeaderProvider struct {
+	usernameHeaders []string
+	groupHeaders    []string
+	extraPrefixes   []string
+	allowedNames    []string
+	// proxyCAs verify the certificates of the proxies, instead of the TLS server, if set.
+	proxyCAs     *x509.CertPool
+	trustedCIDRs []*net.IPNet
+}
+
+// NewTrustedHeaderIdentityProvider trusts the identities asserted in the headers of the requests by an
+// authenticating proxy, following the front-proxy conventions of the apiserver, so the service can sit
+// behind e.g. oauth2-proxy. The proxy is authenticated by its client certificate, or by its address, so
+// clients cannot assert identities themselves. Its options are, as comma separated lists:
+//
+//	username-headers: the headers of the username, the first one set is used. Default X-Remote-User
+//	group-headers: the headers of the groups, repeated. Default X-Remote-Group
+//	extra-headers-prefix: the prefixes of the headers of the extra attributes. Default X-Remote-Extra-
+//	client-ca-file: the CAs of the certificates of the proxies, like --requestheader-client-ca-file.
+//	  They must only sign the certificates of the proxies, never the ones of the users
+//	allowed-names: the common names of the certificates of the proxies. Empty means any. It needs a
+//	  client-ca-file
+//	trusted-cidrs: the networks of the proxies, e.g. 127.0.0.1/32 for a proxy in the same pod. With
+//	  only this option, the proxies need no certificate
+//
+// At least one of client-ca-file and trusted-cidrs is required: the certificates verified by the TLS
+// server with its general client CAs are never enough, since any user with a client certificate could
+// then assert any identity. The certificates of the proxies must also be requested by the TLS server: put
+// their CAs in its client CAs, and this provider before the x509 one, so the requests of the proxies are
+// not authenticated as the proxies themselves.
+func NewTrustedHeaderIdentityProvider(options map[string]string) (IdentityProvider, error) {
+	list := func(option, defaultValue string) []string {
+		value, ok := options[option]
+		if !ok {
+			value = defaultValue
+		}
+		values := []string{}
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		return values
+	}
+	provider := &trustedHeaderProvider{
+		usernameHeaders: list("username-headers", "X-Remote-User"),
+		groupHeaders:    list("group-headers", "X-Remote-Group"),
+		extraPrefixes:   list("extra-headers-prefix", "X-Remote-Extra-"),
+		allowedNames:    list("allowed-names", ""),
+	}
+	if len(provider.usernameHeaders) == 0 {
+		return nil, errors.New("no username header")
+	}
+	if caFile := options["client-ca-file"]; caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the proxy CAs: %w", err)
+		}
+		provider.proxyCAs = x509.NewCertPool()
+		if !provider.proxyCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+	}
+	for _, cidr := range list("trusted-cidrs", "") {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted CIDR: %w", err)
+		}
+		provider.trustedCIDRs = append(provider.trustedCIDRs, network)
+	}
+	if provider.proxyCAs == nil && len(provider.trustedCIDRs) == 0 {
+		return nil, errors.New("no client-ca-file nor trusted-cidrs, the proxies cannot be authenticated")
+	}
+	if provider.proxyCAs == nil && len(provider.allowedNames) > 0 {
+		return nil, errors.New("allowed-names needs a client-ca-file")
+	}
+	return provider, nil
+}
+
+func (in *trustedHeaderProvider) ResolveUser(ctx context.Context, creds Credentials) (UserInfo, error) {
+	name := ""
+	for _, header := range in.usernameHeaders {
+		if name = creds.Header.Get(header); name != "" {
+			break
+		}
+	}
+	if name == "" {
+		return UserInfo{}, ErrNoCredentials
+	}
+	// The headers of clients connecting directly are never trusted
+	if err := in.verifyProxy(creds); err != nil {
+		return UserInfo{}, fmt.Errorf("identity asserted in the %s header by an untrusted client: %w", strings.Join(in.usernameHeaders, ", "), err)
+	}
+
+	user := UserInfo{Name: name, Groups: []string{}}
+	for _, header := range in.groupHeaders {
+		user.Groups = append(user.Groups, creds.Header.Values(header)...)
+	}
+	for header, values := range creds.Header {
+		for _, prefix := range in.extraPrefixes {
+			key, ok := strings.CutPrefix(strings.ToLower(header), strings.ToLower(prefix))
+			if !ok || key == "" {
+				continue
+			}
+			// The keys are percent-encoded, the header names being case insensitive
+			if unescaped, err := url.PathUnescape(key); err == nil {
+				key = unescaped
+			}
+			if user.Extra == nil {
+				user.Extra = map[string][]string{}
+			}
+			user.Extra[key] = append(user.Extra[key], values...)
+		}
+	}
+	return user, nil
+}
+
+// verifyProxy checks that the client is a trusted proxy: in the trusted networks, if any, and with a
+// certificate of the proxy CAs and an allowed name, if there are proxy CAs. The certificates verified by the
+// TLS server alone are never trusted.
+func (in *trustedHeaderProvider) verifyProxy(creds Credentials) error {
+	if len(in.trustedCIDRs) > 0 {
+		host, _, err := net.SplitHostPort(creds.RemoteAddr)
+		if err != nil {
+			host = creds.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		trusted := false
+		for _, network := range in.trustedCIDRs {
+			trusted = trusted || (ip != nil && network.Contains(ip))
+		}
+		if !trusted {
+			return fmt.Errorf("address %s is not in the trusted networks", host)
+		}
+	}
+	if in.proxyCAs == nil {
+		return nil
+	}
+
+	if len(creds.PeerCertificates) == 0 {
+		return errors.New("no client certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range creds.PeerCertificates[1:] {
+		intermediates.AddCert(intermediate)
+	}
+	cert := creds.PeerCertificates[0]
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: in.proxyCAs, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		return fmt.Errorf("client certificate not signed by the proxy CAs: %w", err)
+	}
+	if len(in.allowedNames) > 0 && !containsString(in.allowedNames, cert.Subject.CommonName) {
+		return fmt.Errorf("%q is not an allowed proxy", cert.Subject.CommonName)
+	}
+	return nil
+}
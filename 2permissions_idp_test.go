@@ -0,0 +1,167 @@
+package business
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authn_v1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kube "k8s.io/client-go/kubernetes"
+	kube_fake "k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func proxyHeaders(user string, groups ...string) http.Header {
+	header := http.Header{}
+	header.Set("X-Remote-User", user)
+	for _, group := range groups {
+		header.Add("X-Remote-Group", group)
+	}
+	return header
+}
+
+func TestTrustedHeaderProviderRequiresProxyAuthentication(t *testing.T) {
+	_, err := NewTrustedHeaderIdentityProvider(map[string]string{})
+	assert.Error(t, err)
+
+	_, err = NewTrustedHeaderIdentityProvider(map[string]string{"allowed-names": "proxy"})
+	assert.Error(t, err)
+
+	_, err = NewTrustedHeaderIdentityProvider(map[string]string{"trusted-cidrs": "10.0.0.0/8", "allowed-names": "proxy"})
+	assert.Error(t, err)
+
+	_, err = NewTrustedHeaderIdentityProvider(map[string]string{"trusted-cidrs": "not-a-cidr"})
+	assert.Error(t, err)
+}
+
+func TestTrustedHeaderProviderRejectsCertificatesOfOtherCAs(t *testing.T) {
+	proxyCA := newTestCA(t, "proxy-ca")
+	userCA := newTestCA(t, "user-ca")
+	provider, err := NewTrustedHeaderIdentityProvider(map[string]string{"client-ca-file": proxyCA.writePEM(t)})
+	require.NoError(t, err)
+
+	// A user whose certificate was verified by the TLS server cannot assert another identity
+	userCert := userCA.clientCertificate(t, "mallory")
+	creds := Credentials{Header: proxyHeaders("admin", "system:masters"), Certificate: userCert, PeerCertificates: []*x509.Certificate{userCert}}
+	_, err = provider.ResolveUser(testCtx, creds)
+	assert.Error(t, err)
+
+	creds.PeerCertificates = nil
+	_, err = provider.ResolveUser(testCtx, creds)
+	assert.Error(t, err)
+}
+
+func TestTrustedHeaderProviderTrustsProxies(t *testing.T) {
+	proxyCA := newTestCA(t, "proxy-ca")
+	provider, err := NewTrustedHeaderIdentityProvider(map[string]string{"client-ca-file": proxyCA.writePEM(t), "allowed-names": "front-proxy"})
+	require.NoError(t, err)
+
+	header := proxyHeaders("alice", "developers", "qa")
+	header.Set("X-Remote-Extra-Scopes%2Fread", "pods")
+	proxyCert := proxyCA.clientCertificate(t, "front-proxy")
+	user, err := provider.ResolveUser(testCtx, Credentials{Header: header, PeerCertificates: []*x509.Certificate{proxyCert}})
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user.Name)
+	assert.Equal(t, []string{"developers", "qa"}, user.Groups)
+	assert.Equal(t, []string{"pods"}, user.Extra["scopes/read"])
+
+	otherProxy := proxyCA.clientCertificate(t, "other-proxy")
+	_, err = provider.ResolveUser(testCtx, Credentials{Header: header, PeerCertificates: []*x509.Certificate{otherProxy}})
+	assert.Error(t, err)
+}
+
+func TestTrustedHeaderProviderTrustedNetworks(t *testing.T) {
+	provider, err := NewTrustedHeaderIdentityProvider(map[string]string{"trusted-cidrs": "127.0.0.1/32"})
+	require.NoError(t, err)
+
+	user, err := provider.ResolveUser(testCtx, Credentials{Header: proxyHeaders("alice"), RemoteAddr: "127.0.0.1:41000"})
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user.Name)
+
+	_, err = provider.ResolveUser(testCtx, Credentials{Header: proxyHeaders("alice"), RemoteAddr: "10.1.2.3:41000"})
+	assert.Error(t, err)
+}
+
+func TestTrustedHeaderProviderWithoutHeader(t *testing.T) {
+	provider, err := NewTrustedHeaderIdentityProvider(map[string]string{"trusted-cidrs": "127.0.0.1/32"})
+	require.NoError(t, err)
+
+	_, err = provider.ResolveUser(testCtx, Credentials{Header: http.Header{}, RemoteAddr: "10.1.2.3:41000"})
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+// testTokenReviews returns a client whose TokenReviews authenticate the tokens of the map, and count them.
+func testTokenReviews(tokens map[string]string, reviews *int) kube.Interface {
+	k8s := kube_fake.NewSimpleClientset()
+	k8s.PrependReactor("create", "tokenreviews", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		review := action.(k8s_testing.CreateAction).GetObject().(*authn_v1.TokenReview).DeepCopy()
+		*reviews++
+		if user, ok := tokens[review.Spec.Token]; ok {
+			review.Status = authn_v1.TokenReviewStatus{Authenticated: true, User: authn_v1.UserInfo{Username: user, Groups: []string{"system:authenticated"}}}
+		} else {
+			review.Status.Error = "invalid bearer token"
+		}
+		return true, review, nil
+	})
+	return k8s
+}
+
+func TestIdentityProviderRegistry(t *testing.T) {
+	assert.Subset(t, RegisteredIdentityProviders(), []string{IdentityProviderOIDC, IdentityProviderTokenReview, IdentityProviderTrustedHeader, IdentityProviderX509})
+	assert.Panics(t, func() {
+		RegisterIdentityProvider(IdentityProviderX509, func(kube.Interface, map[string]string) (IdentityProvider, error) { return nil, nil })
+	})
+	assert.Panics(t, func() { RegisterIdentityProvider("nil", nil) })
+
+	_, err := BuildAuthenticator(nil, []IdentityProviderConfig{{Name: "kerberos"}})
+	assert.Error(t, err)
+	_, err = BuildAuthenticator(nil, []IdentityProviderConfig{{Name: IdentityProviderOIDC, Options: map[string]string{"issuer-url": "http://issuer"}}})
+	assert.Error(t, err)
+}
+
+func TestBuildAuthenticatorDefaults(t *testing.T) {
+	reviews := 0
+	authenticate, err := BuildAuthenticator(testTokenReviews(map[string]string{"bob-token": "bob"}, &reviews), nil)
+	require.NoError(t, err)
+	cert := newTestCA(t, "client-ca").clientCertificate(t, "alice", "developers")
+	request := func(token string, cert *x509.Certificate) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/api/permissions/hash", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		if cert != nil {
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		return r
+	}
+
+	// The client certificates come first, then the tokens
+	user, err := authenticate(request("stolen-token", cert))
+	require.NoError(t, err)
+	assert.Equal(t, UserInfo{Name: "alice", Groups: []string{"developers", "system:authenticated"}}, user)
+	assert.Equal(t, 0, reviews)
+
+	for i := 0; i < 2; i++ {
+		user, err = authenticate(request("bob-token", nil))
+		require.NoError(t, err)
+		assert.Equal(t, "bob", user.Name)
+	}
+	assert.Equal(t, 1, reviews)
+
+	_, err = authenticate(request("stolen-token", nil))
+	assert.Error(t, err)
+	_, err = authenticate(request("", nil))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestX509IdentityProviderNeedsAVerifiedCertificate(t *testing.T) {
+	cert := newTestCA(t, "client-ca").clientCertificate(t, "alice")
+	_, err := NewX509IdentityProvider().ResolveUser(testCtx, Credentials{PeerCertificates: []*x509.Certificate{cert}})
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
@@ -0,0 +1,143 @@
+package business
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// maxImpersonatedClients bounds the clients kept by an Impersonator, the least recently used are dropped.
+const maxImpersonatedClients = 1000
+
+// Impersonator asks the apiserver if users can perform requests, with SelfSubjectAccessReviews made
+// impersonating them. The client of each impersonated user is reused across its checks.
+type Impersonator struct {
+	restConfig *rest.Config
+
+	mu sync.Mutex
+	// clients holds the elements of lru, by principal, see impersonatedPrincipal
+	clients map[string]*list.Element
+	lru     *list.List
+}
+
+// impersonatedClient is an element of the LRU list of an Impersonator.
+type impersonatedClient struct {
+	principal string
+	client    kube.Interface
+}
+
+// NewImpersonator returns an Impersonator using restConfig, which must have the privileges to impersonate
+// the users.
+func NewImpersonator(restConfig *rest.Config) *Impersonator {
+	return &Impersonator{restConfig: restConfig, clients: map[string]*list.Element{}, lru: list.New()}
+}
+
+// CheckImpersonated asks the apiserver, with a SelfSubjectAccessReview made impersonating the user, its
+// groups and its extra attributes, if the user can perform the request. It answers as the apiserver would
+// for the requests of the user itself. The restConfig must have the privileges to impersonate the user.
+// To check several requests, use an Impersonator, which reuses the clients.
+func CheckImpersonated(ctx context.Context, restConfig *rest.Config, user UserInfo, req AccessRequest) (Decision, error) {
+	return NewImpersonator(restConfig).Check(ctx, user, req)
+}
+
+// Check asks the apiserver if the user can perform the request, see CheckImpersonated.
+func (in *Impersonator) Check(ctx context.Context, user UserInfo, req AccessRequest) (Decision, error) {
+	k8s, err := in.client(user)
+	if err != nil {
+		return Decision{}, err
+	}
+	review, err := k8s.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &auth_v1.SelfSubjectAccessReview{
+		Spec: auth_v1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: subjectAccessReviewFor(user, req).Spec.ResourceAttributes,
+		},
+	}, meta_v1.CreateOptions{})
+	if err != nil {
+		return Decision{Source: DecisionSourceAPIServer, EvaluationError: err.Error(), Timestamp: time.Now()}, withRequestID(ctx, fmt.Errorf("error reviewing permissions of user %s: %w", user.Name, err))
+	}
+	return Decision{
+		Allowed:         review.Status.Allowed,
+		Denied:          review.Status.Denied,
+		Reason:          review.Status.Reason,
+		EvaluationError: review.Status.EvaluationError,
+		Source:          DecisionSourceAPIServer,
+		Timestamp:       time.Now(),
+	}, nil
+}
+
+// ReviewRules lists the rules of the user in the namespace, with a SelfSubjectRulesReview made impersonating
+// the user, its groups and its extra attributes.
+func (in *Impersonator) ReviewRules(ctx context.Context, user UserInfo, namespace string) (*auth_v1.SelfSubjectRulesReview, error) {
+	k8s, err := in.client(user)
+	if err != nil {
+		return nil, err
+	}
+	review, err := k8s.AuthorizationV1().SelfSubjectRulesReviews().Create(ctx, &auth_v1.SelfSubjectRulesReview{
+		Spec: auth_v1.SelfSubjectRulesReviewSpec{Namespace: namespace},
+	}, meta_v1.CreateOptions{})
+	if err != nil {
+		return nil, withRequestID(ctx, fmt.Errorf("error reviewing the rules of user %s: %w", user.Name, err))
+	}
+	return review, nil
+}
+
+// client returns the client impersonating the user, creating it on the first check of the user.
+func (in *Impersonator) client(user UserInfo) (kube.Interface, error) {
+	principal := impersonatedPrincipal(user)
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if element, ok := in.clients[principal]; ok {
+		in.lru.MoveToFront(element)
+		return element.Value.(*impersonatedClient).client, nil
+	}
+
+	k8s, err := kube.NewForConfig(impersonatingConfig(in.restConfig, user))
+	if err != nil {
+		return nil, fmt.Errorf("error creating impersonating client for user %s: %w", user.Name, err)
+	}
+	in.clients[principal] = in.lru.PushFront(&impersonatedClient{principal: principal, client: k8s})
+	if in.lru.Len() > maxImpersonatedClients {
+		oldest := in.lru.Back()
+		in.lru.Remove(oldest)
+		delete(in.clients, oldest.Value.(*impersonatedClient).principal)
+	}
+	return k8s, nil
+}
+
+// impersonatedPrincipal identifies the user, its groups and its extra attributes, which are all impersonated.
+func impersonatedPrincipal(user UserInfo) string {
+	principal, _ := splitDecisionCacheKey(decisionCacheKey(user, AccessRequest{}))
+	return principal
+}
+
+// impersonatingPermissionsClient is a PermissionsClient also listing the rules of any user, see
+// NewImpersonatingPermissionsClient.
+type impersonatingPermissionsClient struct {
+	*kubePermissionsClient
+	impersonator *Impersonator
+}
+
+// NewImpersonatingPermissionsClient returns a PermissionsClient of restConfig which also lists the rules of
+// any user, with SelfSubjectRulesReviews made impersonating the user, so e.g. a hint of Prefetch takes one
+// review per namespace instead of one SubjectAccessReview per request. The restConfig must have the
+// privileges to impersonate the users.
+func NewImpersonatingPermissionsClient(restConfig *rest.Config) (PermissionsClient, error) {
+	k8s, err := kube.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating client: %w", err)
+	}
+	return &impersonatingPermissionsClient{
+		kubePermissionsClient: &kubePermissionsClient{k8s: k8s, id: newClientID()},
+		impersonator:          NewImpersonator(restConfig),
+	}, nil
+}
+
+func (in *impersonatingPermissionsClient) CreateUserRulesReview(ctx context.Context, user UserInfo, namespace string) (*auth_v1.SelfSubjectRulesReview, error) {
+	return in.impersonator.ReviewRules(ctx, user, namespace)
+}
@@ -0,0 +1,115 @@
+package business
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/rest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// impersonationAPIServer answers the impersonated SelfSubjectAccessReviews with the status returned by review,
+// given the impersonation headers of the request.
+func impersonationAPIServer(t *testing.T, review func(impersonated http.Header, attrs *auth_v1.ResourceAttributes) auth_v1.SubjectAccessReviewStatus) *rest.Config {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ssar auth_v1.SelfSubjectAccessReview
+		if err := json.NewDecoder(r.Body).Decode(&ssar); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ssar.APIVersion, ssar.Kind = "authorization.k8s.io/v1", "SelfSubjectAccessReview"
+		ssar.Status = review(r.Header, ssar.Spec.ResourceAttributes)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(ssar)
+	}))
+	t.Cleanup(server.Close)
+	// The fake apiserver decodes JSON, not the protobuf the typed clients send by default
+	return &rest.Config{Host: server.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}}
+}
+
+func TestCheckImpersonated(t *testing.T) {
+	var impersonated http.Header
+	conf := impersonationAPIServer(t, func(headers http.Header, attrs *auth_v1.ResourceAttributes) auth_v1.SubjectAccessReviewStatus {
+		impersonated = headers.Clone()
+		return auth_v1.SubjectAccessReviewStatus{Allowed: true, Reason: "bound"}
+	})
+	user := UserInfo{Name: "alice", Groups: []string{"developers"}, Extra: map[string][]string{"scopes": {"read", "write"}}}
+
+	decision, err := CheckImpersonated(testCtx, conf, user, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"})
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.False(t, decision.Denied)
+	assert.Equal(t, "bound", decision.Reason)
+	assert.Equal(t, DecisionSourceAPIServer, decision.Source)
+	assert.Equal(t, "alice", impersonated.Get("Impersonate-User"))
+	assert.Equal(t, []string{"developers"}, impersonated.Values("Impersonate-Group"))
+	assert.Equal(t, []string{"read", "write"}, impersonated.Values("Impersonate-Extra-Scopes"))
+}
+
+func TestCheckImpersonatedKeepsExplicitDenials(t *testing.T) {
+	conf := impersonationAPIServer(t, func(http.Header, *auth_v1.ResourceAttributes) auth_v1.SubjectAccessReviewStatus {
+		return auth_v1.SubjectAccessReviewStatus{Denied: true, Reason: "denied by webhook"}
+	})
+
+	decision, err := CheckImpersonated(testCtx, conf, UserInfo{Name: "alice"}, AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.True(t, decision.Denied)
+	assert.Equal(t, "denied by webhook", decision.Reason)
+}
+
+func TestImpersonatorReusesTheClientOfEachUser(t *testing.T) {
+	impersonated := []string{}
+	conf := impersonationAPIServer(t, func(headers http.Header, attrs *auth_v1.ResourceAttributes) auth_v1.SubjectAccessReviewStatus {
+		impersonated = append(impersonated, headers.Get("Impersonate-User"))
+		return auth_v1.SubjectAccessReviewStatus{Allowed: headers.Get("Impersonate-User") == "alice"}
+	})
+	impersonator := NewImpersonator(conf)
+	alice := UserInfo{Name: "alice"}
+
+	for _, user := range []UserInfo{alice, alice, {Name: "bob"}, {Name: "alice", Groups: []string{"developers"}}} {
+		decision, err := impersonator.Check(testCtx, user, alicePods)
+		require.NoError(t, err)
+		assert.Equal(t, user.Name == "alice", decision.Allowed)
+	}
+	assert.Equal(t, []string{"alice", "alice", "bob", "alice"}, impersonated)
+	// alice has one client, alice within the developers another one
+	assert.Equal(t, 3, impersonator.lru.Len())
+	assert.Len(t, impersonator.clients, 3)
+}
+
+func TestImpersonatingPermissionsClientReviewsTheRulesOfTheUser(t *testing.T) {
+	var impersonated http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ssrr auth_v1.SelfSubjectRulesReview
+		if !strings.HasSuffix(r.URL.Path, "/selfsubjectrulesreviews") || json.NewDecoder(r.Body).Decode(&ssrr) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		impersonated = r.Header.Clone()
+		ssrr.APIVersion, ssrr.Kind = "authorization.k8s.io/v1", "SelfSubjectRulesReview"
+		ssrr.Status.ResourceRules = []auth_v1.ResourceRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{ssrr.Spec.Namespace + "-pods"}}}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(ssrr)
+	}))
+	defer server.Close()
+
+	client, err := NewImpersonatingPermissionsClient(&rest.Config{Host: server.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}})
+	require.NoError(t, err)
+	review, err := createUserRulesReview(testCtx, client, UserInfo{Name: "alice", Groups: []string{"developers"}}, "ns1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ns1-pods"}, review.Status.ResourceRules[0].Resources)
+	assert.Equal(t, "alice", impersonated.Get("Impersonate-User"))
+	assert.Equal(t, []string{"developers"}, impersonated.Values("Impersonate-Group"))
+
+	_, err = createUserRulesReview(testCtx, newTestClient(&testReviews{}), UserInfo{Name: "alice"}, "ns1")
+	assert.ErrorIs(t, err, errNoUserRulesReviews)
+}
@@ -0,0 +1,191 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	"github.com/kiali/kiali/log"
+)
+
+// DefaultInventoryLimit is the number of objects listed per resource type and namespace
+// when InventoryOptions.Limit is not set.
+const DefaultInventoryLimit = 100
+
+// InventoryOptions tunes BuildResourceInventory.
+type InventoryOptions struct {
+	// Namespaces restricts the inventory of namespaced resources. Empty means all namespaces.
+	Namespaces []string
+	// Limit is the maximum number of objects returned per resource type and namespace.
+	Limit int64
+	// Scope restricts the inventory to some API groups and resources. Empty means all of them.
+	Scope ResolutionScope
+}
+
+// ResourceInventory lists the concrete objects a user can see.
+type ResourceInventory struct {
+	User      string
+	Resources []InventoryResource
+}
+
+// InventoryResource is a page of the objects of a resource type the user can list. Namespace is
+// empty when the objects were listed cluster-wide. If Continue is not empty there are more
+// objects, which can be fetched with ListAccessibleObjects.
+type InventoryResource struct {
+	Resource  schema.GroupVersionResource
+	Namespace string
+	Objects   []InventoryObject
+	Continue  string
+}
+
+// InventoryObject identifies an object of the inventory.
+type InventoryObject struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// BuildResourceInventory enumerates the objects the user can see, per resource type. The RBAC snapshot
+// is used to find the resource types and namespaces where the user may list, and the objects are then
+// listed impersonating the user, so the apiserver remains the authority on what is returned.
+// The restConfig must have the privileges to impersonate the user, its groups and its extra attributes.
+func BuildResourceInventory(ctx context.Context, restConfig *rest.Config, client PermissionsClient, user UserInfo, opts InventoryOptions) (*ResourceInventory, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultInventoryLimit
+	}
+
+	snapshot, err := LoadRBACSnapshot(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	resourceLists, err := client.ServerPreferredResources()
+	if err != nil && len(resourceLists) == 0 {
+		return nil, fmt.Errorf("error discovering cluster resources: %w", err)
+	}
+	dyn, err := impersonatingDynamicClient(restConfig, user)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := opts.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = bindingNamespaces(snapshot)
+	}
+
+	inventory := &ResourceInventory{User: user.Name, Resources: []InventoryResource{}}
+	for _, list := range resourceLists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, res := range list.APIResources {
+			if strings.Contains(res.Name, "/") || !containsString(res.Verbs, "list") || !opts.Scope.Includes(gv.Group, res.Name) {
+				continue
+			}
+			gvr := gv.WithResource(res.Name)
+
+			// List cluster-wide when allowed, otherwise only where a RoleBinding allows it
+			listNamespaces := []string{}
+			clusterWide := snapshot.Explain(user, AccessRequest{APIGroup: gv.Group, Resource: res.Name, Verb: "list"}).Allowed
+			if clusterWide && (len(opts.Namespaces) == 0 || !res.Namespaced) {
+				listNamespaces = append(listNamespaces, "")
+			} else if res.Namespaced {
+				for _, ns := range namespaces {
+					if snapshot.Explain(user, AccessRequest{Namespace: ns, APIGroup: gv.Group, Resource: res.Name, Verb: "list"}).Allowed {
+						listNamespaces = append(listNamespaces, ns)
+					}
+				}
+			}
+
+			for _, ns := range listNamespaces {
+				page, err := listAccessibleObjects(ctx, dyn, gvr, ns, opts.Limit, "")
+				if err != nil {
+					if errors.IsForbidden(err) || errors.IsNotFound(err) {
+						log.Debugf("User %s cannot list %s in namespace [%s]: %v", redactedUser(user.Name), gvr.String(), ns, err)
+						continue
+					}
+					return nil, err
+				}
+				inventory.Resources = append(inventory.Resources, *page)
+			}
+		}
+	}
+
+	return inventory, nil
+}
+
+// ListAccessibleObjects fetches a page of the objects of a resource type, impersonating the user.
+// Pass the Continue token of a previous page to fetch the next one.
+func ListAccessibleObjects(ctx context.Context, restConfig *rest.Config, user UserInfo, gvr schema.GroupVersionResource, namespace string, limit int64, continueToken string) (*InventoryResource, error) {
+	dyn, err := impersonatingDynamicClient(restConfig, user)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultInventoryLimit
+	}
+	return listAccessibleObjects(ctx, dyn, gvr, namespace, limit, continueToken)
+}
+
+func listAccessibleObjects(ctx context.Context, dyn dynamic.Interface, gvr schema.GroupVersionResource, namespace string, limit int64, continueToken string) (*InventoryResource, error) {
+	list, err := dyn.Resource(gvr).Namespace(namespace).List(ctx, meta_v1.ListOptions{Limit: limit, Continue: continueToken})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &InventoryResource{
+		Resource:  gvr,
+		Namespace: namespace,
+		Objects:   make([]InventoryObject, 0, len(list.Items)),
+		Continue:  list.GetContinue(),
+	}
+	for _, item := range list.Items {
+		page.Objects = append(page.Objects, InventoryObject{Namespace: item.GetNamespace(), Name: item.GetName()})
+	}
+	return page, nil
+}
+
+// impersonatingDynamicClient returns a dynamic client acting as the user, see impersonatingConfig.
+func impersonatingDynamicClient(restConfig *rest.Config, user UserInfo) (dynamic.Interface, error) {
+	dyn, err := dynamic.NewForConfig(impersonatingConfig(restConfig, user))
+	if err != nil {
+		return nil, fmt.Errorf("error creating impersonating client for user %s: %w", user.Name, err)
+	}
+	return dyn, nil
+}
+
+// impersonatingConfig returns a copy of restConfig impersonating the user, its groups and its extra
+// attributes, which become the Impersonate-User, Impersonate-Group and Impersonate-Extra-* headers.
+// Permissions are mostly granted to groups, so impersonating the user name alone would miss them.
+func impersonatingConfig(restConfig *rest.Config, user UserInfo) *rest.Config {
+	impersonated := rest.CopyConfig(restConfig)
+	impersonated.Impersonate = rest.ImpersonationConfig{
+		UserName: user.Name,
+		Groups:   user.Groups,
+		Extra:    user.Extra,
+	}
+	return impersonated
+}
+
+// bindingNamespaces returns the namespaces having at least one RoleBinding, sorted.
+func bindingNamespaces(snapshot *RBACSnapshot) []string {
+	set := map[string]bool{}
+	for _, rb := range snapshot.RoleBindings {
+		set[rb.Namespace] = true
+	}
+	return sortedKeys(set)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
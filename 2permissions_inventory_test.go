@@ -0,0 +1,122 @@
+package business
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// discoveryClient is a PermissionsClient discovering the resources.
+type discoveryClient struct {
+	PermissionsClient
+	resources []*meta_v1.APIResourceList
+}
+
+func (in *discoveryClient) ServerPreferredResources() ([]*meta_v1.APIResourceList, error) {
+	return in.resources, nil
+}
+
+// inventoryAPIServer serves a pod of the namespace on every list, recording the paths and the impersonated users.
+func inventoryAPIServer(t *testing.T) (*httptest.Server, func() (paths, users []string)) {
+	var (
+		mu           sync.Mutex
+		paths, users []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		users = append(users, r.Header.Get("Impersonate-User"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "PodList",
+			"metadata":   map[string]interface{}{"continue": "next"},
+			"items": []interface{}{map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Pod",
+				"metadata":   map[string]interface{}{"namespace": "ns1", "name": "web"},
+			}},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, func() ([]string, []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		return paths, users
+	}
+}
+
+func inventoryClient() PermissionsClient {
+	return &discoveryClient{
+		PermissionsClient: newTestClient(&testReviews{}, podReaderObjects()...),
+		resources: []*meta_v1.APIResourceList{{
+			GroupVersion: "v1",
+			APIResources: []meta_v1.APIResource{
+				{Name: "pods", Namespaced: true, Verbs: []string{"get", "list", "watch"}},
+				{Name: "pods/log", Namespaced: true, Verbs: []string{"get"}},
+				{Name: "secrets", Namespaced: true, Verbs: []string{"get", "list"}},
+				{Name: "bindings", Namespaced: true, Verbs: []string{"create"}},
+			},
+		}},
+	}
+}
+
+func TestBuildResourceInventory(t *testing.T) {
+	server, requests := inventoryAPIServer(t)
+
+	inventory, err := BuildResourceInventory(testCtx, &rest.Config{Host: server.URL}, inventoryClient(), UserInfo{Name: "alice"}, InventoryOptions{})
+	require.NoError(t, err)
+
+	// alice only lists the pods of ns1
+	assert.Equal(t, "alice", inventory.User)
+	require.Len(t, inventory.Resources, 1)
+	page := inventory.Resources[0]
+	assert.Equal(t, schema.GroupVersionResource{Version: "v1", Resource: "pods"}, page.Resource)
+	assert.Equal(t, "ns1", page.Namespace)
+	assert.Equal(t, []InventoryObject{{Namespace: "ns1", Name: "web"}}, page.Objects)
+	assert.Equal(t, "next", page.Continue)
+	paths, users := requests()
+	assert.Equal(t, []string{"/api/v1/namespaces/ns1/pods"}, paths)
+	assert.Equal(t, []string{"alice"}, users)
+}
+
+func TestBuildResourceInventoryListsClusterWide(t *testing.T) {
+	server, requests := inventoryAPIServer(t)
+
+	inventory, err := BuildResourceInventory(testCtx, &rest.Config{Host: server.URL}, inventoryClient(), UserInfo{Name: "bob"}, InventoryOptions{})
+	require.NoError(t, err)
+	require.Len(t, inventory.Resources, 1)
+	assert.Empty(t, inventory.Resources[0].Namespace)
+	paths, _ := requests()
+	assert.Equal(t, []string{"/api/v1/pods"}, paths)
+
+	// Selected namespaces are listed one by one
+	_, err = BuildResourceInventory(testCtx, &rest.Config{Host: server.URL}, inventoryClient(), UserInfo{Name: "bob"}, InventoryOptions{Namespaces: []string{"ns1", "ns2"}})
+	require.NoError(t, err)
+	paths, _ = requests()
+	assert.Equal(t, []string{"/api/v1/pods", "/api/v1/namespaces/ns1/pods", "/api/v1/namespaces/ns2/pods"}, paths)
+}
+
+func TestImpersonatingConfig(t *testing.T) {
+	conf := &rest.Config{Host: "https://kubernetes", BearerToken: "kiali"}
+	user := UserInfo{Name: "alice", Groups: []string{"developers"}, Extra: map[string][]string{"scopes": {"user:info"}}}
+
+	impersonated := impersonatingConfig(conf, user)
+	assert.Equal(t, rest.ImpersonationConfig{UserName: "alice", Groups: []string{"developers"}, Extra: map[string][]string{"scopes": {"user:info"}}}, impersonated.Impersonate)
+	assert.Equal(t, "kiali", impersonated.BearerToken)
+	assert.Empty(t, conf.Impersonate.UserName)
+}
+
+func TestBindingNamespaces(t *testing.T) {
+	assert.Equal(t, []string{"ns1"}, bindingNamespaces(testSnapshot(podReaderObjects()...)))
+}
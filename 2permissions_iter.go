@@ -0,0 +1,131 @@
+package business
+
+import (
+	"iter"
+	"strings"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+)
+
+// AllGrants iterates over the grants of the snapshot, like Grants, without building the list.
+func (in *RBACSnapshot) AllGrants() iter.Seq[RoleGrant] {
+	return func(yield func(RoleGrant) bool) {
+		for _, crb := range in.ClusterRoleBindings {
+			rules, ok := in.roleRules(crb.RoleRef, "")
+			if !ok {
+				continue
+			}
+			for _, subject := range crb.Subjects {
+				if !yield(RoleGrant{
+					BindingKind: "ClusterRoleBinding",
+					BindingName: crb.Name,
+					RoleRef:     crb.RoleRef,
+					Subject:     subject,
+					Rules:       rules,
+				}) {
+					return
+				}
+			}
+		}
+		for _, rb := range in.RoleBindings {
+			rules, ok := in.roleRules(rb.RoleRef, rb.Namespace)
+			if !ok {
+				continue
+			}
+			for _, subject := range rb.Subjects {
+				if !yield(RoleGrant{
+					BindingKind: "RoleBinding",
+					BindingName: rb.Name,
+					Namespace:   rb.Namespace,
+					RoleRef:     rb.RoleRef,
+					Subject:     subject,
+					Rules:       rules,
+				}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Permissions iterates over the permissions granted to the user, in the namespace of their grant. Unlike
+// EffectivePermissions, a permission granted by several bindings is yielded once per binding.
+func (in *RBACSnapshot) Permissions(user UserInfo) iter.Seq[AccessRequest] {
+	return func(yield func(AccessRequest) bool) {
+		for grant := range in.AllGrants() {
+			if !subjectMatches(grant.Subject, grant.Namespace, user) {
+				continue
+			}
+			for atom := range RuleAtoms(grant.Rules) {
+				atom.Namespace = grant.Namespace
+				if !yield(atom) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// namespacePermissions returns the permissions granted to the user by the RoleBindings of the
+// namespaces, like EffectivePermissions restricted to these namespaces.
+func (in *RBACSnapshot) namespacePermissions(user UserInfo, namespaces map[string]bool) map[AccessRequest]bool {
+	permissions := map[AccessRequest]bool{}
+	for _, rb := range in.RoleBindings {
+		if !namespaces[rb.Namespace] {
+			continue
+		}
+		rules, ok := in.roleRules(rb.RoleRef, rb.Namespace)
+		if !ok {
+			continue
+		}
+		for _, subject := range rb.Subjects {
+			if !subjectMatches(subject, rb.Namespace, user) {
+				continue
+			}
+			for atom := range RuleAtoms(rules) {
+				atom.Namespace = rb.Namespace
+				permissions[atom] = true
+			}
+		}
+	}
+	return permissions
+}
+
+// PermissionMatrix iterates over the permissions of each user, user by user, for the cluster-wide
+// matrix of who can do what. Like Permissions, duplicates are not removed.
+func (in *RBACSnapshot) PermissionMatrix(users []UserInfo) iter.Seq2[UserInfo, AccessRequest] {
+	return func(yield func(UserInfo, AccessRequest) bool) {
+		for _, user := range users {
+			for permission := range in.Permissions(user) {
+				if !yield(user, permission) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// RuleAtoms iterates over the resource rules flattened into individual permissions, one per API group,
+// resource, resource name and verb. Wildcards are kept as is. Non-resource rules are ignored.
+func RuleAtoms(rules []rbac_v1.PolicyRule) iter.Seq[AccessRequest] {
+	return func(yield func(AccessRequest) bool) {
+		for _, rule := range rules {
+			names := rule.ResourceNames
+			if len(names) == 0 {
+				names = []string{""}
+			}
+			for _, apiGroup := range rule.APIGroups {
+				for _, resource := range rule.Resources {
+					res, sub, _ := strings.Cut(resource, "/")
+					for _, name := range names {
+						for _, verb := range rule.Verbs {
+							if !yield(AccessRequest{APIGroup: apiGroup, Resource: res, Subresource: sub, Name: name, Verb: verb}) {
+								return
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
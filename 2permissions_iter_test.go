@@ -0,0 +1,100 @@
+package business
+
+import (
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllGrants(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+
+	grants := []RoleGrant{}
+	for grant := range snapshot.AllGrants() {
+		grants = append(grants, grant)
+	}
+	assert.Len(t, grants, 3)
+	assert.Equal(t, snapshot.Grants(), grants)
+
+	for grant := range snapshot.AllGrants() {
+		assert.Equal(t, "ClusterRoleBinding", grant.BindingKind)
+		break
+	}
+}
+
+func TestPermissionsYieldsOnePermissionPerBinding(t *testing.T) {
+	snapshot := testSnapshot(append(podReaderObjects(),
+		testRoleBinding("ns1", "alice-pods-again", "ClusterRole", "pod-reader", testUser("alice")),
+	)...)
+	alice := UserInfo{Name: "alice"}
+
+	permissions := []AccessRequest{}
+	for permission := range snapshot.Permissions(alice) {
+		permissions = append(permissions, permission)
+	}
+	get := AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}
+	list := AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "list"}
+	watch := AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "watch"}
+	assert.ElementsMatch(t, []AccessRequest{get, list, watch, get, list, watch}, permissions)
+	assert.Equal(t, map[AccessRequest]bool{get: true, list: true, watch: true}, snapshot.EffectivePermissions(alice))
+
+	assert.Empty(t, snapshot.EffectivePermissions(UserInfo{Name: "carol"}))
+}
+
+func TestPermissionMatrix(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+	users := []UserInfo{{Name: "alice"}, {Name: "bob"}, {Name: "carol", Groups: []string{"developers"}}}
+
+	matrix := map[string][]AccessRequest{}
+	for user, permission := range snapshot.PermissionMatrix(users) {
+		matrix[user.Name] = append(matrix[user.Name], permission)
+	}
+	assert.Len(t, matrix["alice"], 3)
+	assert.ElementsMatch(t, []AccessRequest{
+		{Resource: "pods", Verb: "get"},
+		{Resource: "pods", Verb: "list"},
+		{Resource: "pods", Verb: "watch"},
+	}, matrix["bob"])
+	assert.Len(t, matrix["carol"], 4)
+	for _, permission := range matrix["carol"] {
+		assert.Equal(t, "apps", permission.APIGroup)
+		assert.Equal(t, "ns1", permission.Namespace)
+	}
+
+	count := 0
+	for range snapshot.PermissionMatrix(users) {
+		count++
+		if count == 4 {
+			break
+		}
+	}
+	assert.Equal(t, 4, count)
+}
+
+func TestRuleAtoms(t *testing.T) {
+	rules := []rbac_v1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"pods", "pods/log"}, ResourceNames: []string{"web", "db"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+		{NonResourceURLs: []string{"/healthz"}, Verbs: []string{"get"}},
+	}
+
+	atoms := []AccessRequest{}
+	for atom := range RuleAtoms(rules) {
+		atoms = append(atoms, atom)
+	}
+	assert.Equal(t, []AccessRequest{
+		{Resource: "pods", Name: "web", Verb: "get"},
+		{Resource: "pods", Name: "db", Verb: "get"},
+		{Resource: "pods", Subresource: "log", Name: "web", Verb: "get"},
+		{Resource: "pods", Subresource: "log", Name: "db", Verb: "get"},
+		{APIGroup: "*", Resource: "*", Verb: "*"},
+	}, atoms)
+	assert.Equal(t, atoms, expandRules(rules))
+
+	for atom := range RuleAtoms(rules) {
+		assert.Equal(t, "web", atom.Name)
+		break
+	}
+}
@@ -0,0 +1,182 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordination_v1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/kiali/kiali/log"
+)
+
+// ErrLeadershipLost is returned by RunWithLeaderElection and RunHAMaintenance when the replica stops leading.
+var ErrLeadershipLost = errors.New("permissions maintenance leadership lost")
+
+// redisInvalidationChannel is the Redis pub/sub channel of the permission invalidations.
+const redisInvalidationChannel = "kiali:permissions:invalidations"
+
+// LeaderElectionConfig configures the Lease used to elect the replica running the RBAC watch.
+type LeaderElectionConfig struct {
+	Namespace string
+	LeaseName string
+	// Identity must be unique per replica, usually the pod name.
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// PermissionInvalidation is broadcast to all the replicas when RBAC objects change.
+type PermissionInvalidation struct {
+	Causes []RBACObjectRef `json:"causes"`
+	// Slices are the affected cache slices. Absent means that all the cached decisions are affected.
+	Slices    []CacheSlice `json:"slices,omitempty"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+// InvalidationBus broadcasts invalidations between the replicas.
+type InvalidationBus interface {
+	Publish(ctx context.Context, invalidation PermissionInvalidation) error
+	// Subscribe calls handler for every published invalidation until the context is cancelled.
+	Subscribe(ctx context.Context, handler func(PermissionInvalidation)) error
+}
+
+// redisInvalidationBus is an InvalidationBus using Redis pub/sub.
+type redisInvalidationBus struct {
+	client *redis.Client
+}
+
+// NewRedisInvalidationBus creates an InvalidationBus on top of the Redis server at address.
+func NewRedisInvalidationBus(address string) InvalidationBus {
+	return &redisInvalidationBus{client: redis.NewClient(&redis.Options{Addr: address})}
+}
+
+func (in *redisInvalidationBus) Publish(ctx context.Context, invalidation PermissionInvalidation) error {
+	payload, err := json.Marshal(invalidation)
+	if err != nil {
+		return err
+	}
+	return in.client.Publish(ctx, redisInvalidationChannel, payload).Err()
+}
+
+func (in *redisInvalidationBus) Subscribe(ctx context.Context, handler func(PermissionInvalidation)) error {
+	sub := in.client.Subscribe(ctx, redisInvalidationChannel)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return fmt.Errorf("failed to subscribe to permission invalidations: %w", err)
+	}
+
+	go func() {
+		defer sub.Close()
+		ch := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				var invalidation PermissionInvalidation
+				if err := json.Unmarshal([]byte(msg.Payload), &invalidation); err != nil {
+					log.Warningf("Ignoring malformed permission invalidation: %v", err)
+					continue
+				}
+				handler(invalidation)
+			}
+		}
+	}()
+	return nil
+}
+
+// RunWithLeaderElection runs whileLeading while this replica holds the Lease. It blocks until the context is
+// cancelled, or returns ErrLeadershipLost when the leadership is lost: the elector does not run for the
+// Lease again, as whileLeading may still be running.
+func RunWithLeaderElection(ctx context.Context, leases coordination_v1.LeasesGetter, conf LeaderElectionConfig, whileLeading func(ctx context.Context)) error {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  meta_v1.ObjectMeta{Namespace: conf.Namespace, Name: conf.LeaseName},
+		Client:     leases,
+		LockConfig: resourcelock.ResourceLockConfig{Identity: conf.Identity},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   conf.LeaseDuration,
+		RenewDeadline:   conf.RenewDeadline,
+		RetryPeriod:     conf.RetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Infof("Replica %s is now leading the permissions maintenance", conf.Identity)
+				whileLeading(ctx)
+			},
+			OnStoppedLeading: func() {
+				log.Infof("Replica %s stopped leading the permissions maintenance", conf.Identity)
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("invalid leader election config: %w", err)
+	}
+
+	elector.Run(ctx)
+	if ctx.Err() == nil {
+		return ErrLeadershipLost
+	}
+	return nil
+}
+
+// RunHAMaintenance coordinates the RBAC maintenance of several replicas. Every replica serves checks and
+// invalidates the affected cached decisions when an invalidation is broadcast, but only the elected leader runs the
+// watcher informers and the recomputation of the effective permissions, and publishes the invalidations.
+// Note that, consequently, Watch subscriptions are only served by the leader replica.
+// Since informers cannot be restarted once stopped, a replica leads at most once: ErrLeadershipLost is
+// returned when the leadership is lost, and the replica should be restarted. Otherwise, it blocks until
+// the context is cancelled.
+func RunHAMaintenance(ctx context.Context, leases coordination_v1.LeasesGetter, conf LeaderElectionConfig, watcher *PermissionWatcher, checker *PermissionChecker, bus InvalidationBus) error {
+	err := bus.Subscribe(ctx, func(invalidation PermissionInvalidation) {
+		if err := checker.InvalidateCache(ctx, invalidation.Slices); err != nil {
+			log.Errorf("Error invalidating the permissions cache: %v", err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	watcher.SetChangeHandler(func(causes []RBACObjectRef, slices []CacheSlice) {
+		if slices != nil && len(slices) == 0 {
+			// No cached decision is affected
+			return
+		}
+		if err := bus.Publish(ctx, PermissionInvalidation{Causes: causes, Slices: slices, Timestamp: time.Now()}); err != nil {
+			log.Errorf("Error broadcasting permission invalidation: %v", err)
+		}
+	})
+
+	electionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Set by whileLeading, running in the goroutine of the elector
+	var lost atomic.Bool
+	err = RunWithLeaderElection(electionCtx, leases, conf, func(leaderCtx context.Context) {
+		if err := watcher.Start(leaderCtx.Done()); err != nil {
+			log.Errorf("Error starting the RBAC watcher: %v", err)
+		} else {
+			<-leaderCtx.Done()
+		}
+		if ctx.Err() == nil {
+			lost.Store(true)
+			cancel()
+		}
+	})
+	if lost.Load() {
+		return ErrLeadershipLost
+	}
+	return err
+}
@@ -0,0 +1,209 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	kube_fake "k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testLeaderElectionConfig = LeaderElectionConfig{
+	Namespace:     "istio-system",
+	LeaseName:     "kiali-permissions",
+	Identity:      "kiali-0",
+	LeaseDuration: 2 * time.Second,
+	RenewDeadline: time.Second,
+	RetryPeriod:   100 * time.Millisecond,
+}
+
+// loopbackInvalidationBus delivers the published invalidations to its subscribers, like a bus shared by
+// the replicas.
+type loopbackInvalidationBus struct {
+	mu        sync.Mutex
+	handlers  []func(PermissionInvalidation)
+	published chan PermissionInvalidation
+}
+
+func newLoopbackInvalidationBus() *loopbackInvalidationBus {
+	return &loopbackInvalidationBus{published: make(chan PermissionInvalidation, 10)}
+}
+
+func (in *loopbackInvalidationBus) Publish(ctx context.Context, invalidation PermissionInvalidation) error {
+	in.mu.Lock()
+	handlers := in.handlers
+	in.mu.Unlock()
+	for _, handler := range handlers {
+		handler(invalidation)
+	}
+	in.published <- invalidation
+	return nil
+}
+
+func (in *loopbackInvalidationBus) Subscribe(ctx context.Context, handler func(PermissionInvalidation)) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.handlers = append(in.handlers, handler)
+	return nil
+}
+
+func TestRunWithLeaderElection(t *testing.T) {
+	k8s := kube_fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(testCtx)
+	defer cancel()
+
+	leading := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- RunWithLeaderElection(ctx, k8s.CoordinationV1(), testLeaderElectionConfig, func(ctx context.Context) {
+			close(leading)
+			<-ctx.Done()
+		})
+	}()
+
+	select {
+	case <-leading:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "replica not leading")
+	}
+	lease, err := k8s.CoordinationV1().Leases("istio-system").Get(testCtx, "kiali-permissions", meta_v1.GetOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, lease.Spec.HolderIdentity)
+	assert.Equal(t, "kiali-0", *lease.Spec.HolderIdentity)
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "leader election not stopped")
+	}
+}
+
+func TestRunWithLeaderElectionRejectsInvalidConfigs(t *testing.T) {
+	conf := testLeaderElectionConfig
+	conf.RenewDeadline = conf.LeaseDuration
+
+	err := RunWithLeaderElection(testCtx, kube_fake.NewSimpleClientset().CoordinationV1(), conf, func(ctx context.Context) {})
+	assert.ErrorContains(t, err, "invalid leader election config")
+}
+
+func TestRunHAMaintenanceBroadcastsTheInvalidations(t *testing.T) {
+	k8s, waitWatching := newTestWatchedClientset(t)
+	watcher := NewPermissionWatcher(informers.NewSharedInformerFactory(k8s, 0))
+	reviews := aliceReadsPods()
+	checker := newTestChecker(reviews, nil)
+	bus := newLoopbackInvalidationBus()
+	ctx, cancel := context.WithCancel(testCtx)
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- RunHAMaintenance(ctx, k8s.CoordinationV1(), testLeaderElectionConfig, watcher, checker, bus)
+	}()
+	waitWatching()
+	// The changes made before the initial snapshot are not invalidations
+	require.Eventually(t, watcher.HasSynced, 5*time.Second, time.Millisecond)
+
+	_, err := checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+	_, err = k8s.RbacV1().RoleBindings("ns1").Create(testCtx, testRoleBinding("ns1", "alice-ns1", "ClusterRole", "pod-reader", testUser("alice")), meta_v1.CreateOptions{})
+	require.NoError(t, err)
+
+	select {
+	case invalidation := <-bus.published:
+		assert.Equal(t, []RBACObjectRef{{Kind: "RoleBinding", Namespace: "ns1", Name: "alice-ns1"}}, invalidation.Causes)
+		assert.NotEmpty(t, invalidation.Slices)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no invalidation published")
+	}
+	// The subscribed checker dropped the cached decision of alice
+	decision, err := checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+	assert.Equal(t, DecisionSourceAPIServer, decision.Source)
+	assert.Equal(t, int64(2), reviews.calls.Load())
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "maintenance not stopped")
+	}
+}
+
+// failLeaseRenewals returns a function making the apiserver reject the renewals of the Lease, so the
+// leader loses it. The reactor is added before the client is used, as the fake clientset does not lock
+// its reactors.
+func failLeaseRenewals(k8s *kube_fake.Clientset) func() {
+	var rejected atomic.Bool
+	k8s.PrependReactor("update", "leases", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		if rejected.Load() {
+			return true, nil, fmt.Errorf("lease renewals rejected")
+		}
+		return false, nil, nil
+	})
+	return func() { rejected.Store(true) }
+}
+
+func TestRunWithLeaderElectionReturnsWhenTheLeadershipIsLost(t *testing.T) {
+	k8s := kube_fake.NewSimpleClientset()
+	loseLease := failLeaseRenewals(k8s)
+	var runs atomic.Int32
+	leading := make(chan struct{}, 1)
+	done := make(chan error)
+	go func() {
+		done <- RunWithLeaderElection(testCtx, k8s.CoordinationV1(), testLeaderElectionConfig, func(ctx context.Context) {
+			runs.Add(1)
+			leading <- struct{}{}
+			<-ctx.Done()
+		})
+	}()
+
+	select {
+	case <-leading:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "replica not leading")
+	}
+	loseLease()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ErrLeadershipLost)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "leadership loss not returned")
+	}
+	// The replica does not run for the Lease again
+	assert.Equal(t, int32(1), runs.Load())
+}
+
+func TestRunHAMaintenanceReturnsWhenTheLeadershipIsLost(t *testing.T) {
+	k8s, waitWatching := newTestWatchedClientset(t)
+	watcher := NewPermissionWatcher(informers.NewSharedInformerFactory(k8s, 0))
+	checker := newTestChecker(aliceReadsPods(), nil)
+	loseLease := failLeaseRenewals(k8s)
+
+	done := make(chan error)
+	go func() {
+		done <- RunHAMaintenance(testCtx, k8s.CoordinationV1(), testLeaderElectionConfig, watcher, checker, newLoopbackInvalidationBus())
+	}()
+	waitWatching()
+	loseLease()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ErrLeadershipLost)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "leadership loss not returned")
+	}
+}
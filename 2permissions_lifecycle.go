@@ -0,0 +1,310 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kiali/kiali/log"
+)
+
+// ErrCheckerShutDown is returned by PermissionChecker.Check once the checker is drained, see Drain.
+var ErrCheckerShutDown = errors.New("permission checker is shut down")
+
+// FlushingAuditSink is an AuditSink buffering its records, flushed when the checker is drained.
+type FlushingAuditSink interface {
+	AuditSink
+	// Flush writes out the buffered records, until the context is done.
+	Flush(ctx context.Context) error
+}
+
+// CachedDecisionEntry is a decision of the memory decision cache, as persisted across restarts.
+type CachedDecisionEntry struct {
+	Key      string    `json:"key"`
+	Decision Decision  `json:"decision"`
+	Expires  time.Time `json:"expires"`
+}
+
+// CacheSnapshotStore persists the decisions of the memory decision cache across restarts, so a restarted
+// replica does not send a burst of reviews to the apiserver. The Redis cache outlives the replicas and is
+// not persisted. The decisions expire like if the replica had not restarted, so the staleness of the
+// restored decisions is bounded by the cache TTLs, as usual.
+type CacheSnapshotStore interface {
+	Save(ctx context.Context, entries []CachedDecisionEntry) error
+	// Load returns the saved entries, or none if nothing was saved.
+	Load(ctx context.Context) ([]CachedDecisionEntry, error)
+}
+
+// fileCacheSnapshotStore is a CacheSnapshotStore writing the entries to a JSON file.
+type fileCacheSnapshotStore struct {
+	path string
+}
+
+// NewFileCacheSnapshotStore creates a CacheSnapshotStore saving the entries as JSON in the file at path,
+// e.g. on an emptyDir volume surviving the restarts of the container.
+func NewFileCacheSnapshotStore(path string) CacheSnapshotStore {
+	return &fileCacheSnapshotStore{path: path}
+}
+
+func (in *fileCacheSnapshotStore) Save(ctx context.Context, entries []CachedDecisionEntry) error {
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	// Written aside and renamed, so a crash never leaves a truncated snapshot
+	tmp := in.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return fmt.Errorf("failed to write cache snapshot %s: %w", tmp, err)
+	}
+	return os.Rename(tmp, in.path)
+}
+
+func (in *fileCacheSnapshotStore) Load(ctx context.Context) ([]CachedDecisionEntry, error) {
+	content, err := os.ReadFile(in.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache snapshot %s: %w", in.path, err)
+	}
+	entries := []CachedDecisionEntry{}
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse cache snapshot %s: %w", in.path, err)
+	}
+	return entries, nil
+}
+
+// beginCheck registers an in-flight check, unless the checker is drained. The caller calls
+// in.inflight.Done() when the check is over.
+func (in *PermissionChecker) beginCheck() bool {
+	in.lifecycleMu.RLock()
+	defer in.lifecycleMu.RUnlock()
+	if in.drained {
+		return false
+	}
+	in.inflight.Add(1)
+	return true
+}
+
+// Drain makes the new checks fail with ErrCheckerShutDown, and waits for the in-flight checks until the
+// context is done. Their audit records are then flushed if the sink is a FlushingAuditSink.
+func (in *PermissionChecker) Drain(ctx context.Context) error {
+	in.lifecycleMu.Lock()
+	in.drained = true
+	in.lifecycleMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		in.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("in-flight permission checks not drained: %w", ctx.Err())
+	}
+
+	in.mu.RLock()
+	sink := in.auditSink
+	in.mu.RUnlock()
+	if flushing, ok := sink.(FlushingAuditSink); ok {
+		if err := flushing.Flush(ctx); err != nil {
+			return fmt.Errorf("error flushing the audit sink: %w", err)
+		}
+	}
+	return nil
+}
+
+// SaveCacheSnapshot saves the unexpired decisions of the memory decision cache to the store. It does
+// nothing with the other cache backends, nor when the redaction policy redacts anything: the keys of the
+// decisions hold the names of the users and of the objects, which cannot be redacted without making the
+// decisions unusable.
+func (in *PermissionChecker) SaveCacheSnapshot(ctx context.Context, store CacheSnapshotStore) error {
+	in.mu.RLock()
+	cache := in.cache
+	in.mu.RUnlock()
+	memory, ok := cache.(*memoryDecisionCache)
+	if !ok {
+		return nil
+	}
+	if !currentRedaction().isEmpty() {
+		log.Infof("Not saving the cached permission decisions: the redaction policy forbids persisting the user and object names")
+		return nil
+	}
+	entries := memory.snapshot()
+	if err := store.Save(ctx, entries); err != nil {
+		return err
+	}
+	log.Infof("Saved %d cached permission decisions", len(entries))
+	return nil
+}
+
+// RestoreCacheSnapshot loads the decisions saved by SaveCacheSnapshot into the memory decision cache,
+// except the expired ones. It does nothing with the other cache backends.
+func (in *PermissionChecker) RestoreCacheSnapshot(ctx context.Context, store CacheSnapshotStore) error {
+	in.mu.RLock()
+	cache := in.cache
+	in.mu.RUnlock()
+	memory, ok := cache.(*memoryDecisionCache)
+	if !ok {
+		return nil
+	}
+	entries, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	log.Infof("Restored %d cached permission decisions", memory.restore(entries))
+	return nil
+}
+
+// PermissionsService runs the permission subsystem as a whole, when it runs as a sidecar or a standalone
+// server: the RBAC watcher, the checker and the HTTP server, and shuts them down in order.
+type PermissionsService struct {
+	checker *PermissionChecker
+	// watcher and httpServer are optional.
+	watcher    *PermissionWatcher
+	httpServer *http.Server
+	cacheStore CacheSnapshotStore
+	retention  RetentionPolicy
+	retained   []RetentionStore
+
+	mu sync.Mutex
+	// stopCh stops the informers of the watcher, and Run.
+	stopCh chan struct{}
+}
+
+// NewPermissionsService creates a service running the checker and the watcher, which can be nil.
+func NewPermissionsService(checker *PermissionChecker, watcher *PermissionWatcher) *PermissionsService {
+	return &PermissionsService{checker: checker, watcher: watcher, stopCh: make(chan struct{})}
+}
+
+// SetHTTPServer sets the server serving e.g. PermissionsServer.Handler, run by Run.
+func (in *PermissionsService) SetHTTPServer(server *http.Server) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.httpServer = server
+}
+
+// SetCacheSnapshotStore sets the store of the cached decisions, restored by Run and saved by Shutdown.
+func (in *PermissionsService) SetCacheSnapshotStore(store CacheSnapshotStore) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.cacheStore = store
+}
+
+// SetRetention sets the policy applied to the stores every DefaultRetentionInterval by Run, e.g. the
+// Retention of the config.
+func (in *PermissionsService) SetRetention(policy RetentionPolicy, stores ...RetentionStore) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.retention, in.retained = policy, stores
+}
+
+// ApplyConfig applies the config to the checker, see PermissionChecker.ApplyConfig, and its ResolutionScope to
+// the watcher, if any, from its next recomputation.
+func (in *PermissionsService) ApplyConfig(conf *PermissionsConfig) error {
+	if err := in.checker.ApplyConfig(conf); err != nil {
+		return err
+	}
+	if in.watcher != nil {
+		in.watcher.SetResolutionScope(conf.ResolutionScope)
+	}
+	return nil
+}
+
+// Run restores the cached decisions, starts the watcher with the ResolutionScope of the config of the checker
+// and the retention, and serves HTTP, until the context is cancelled or Shutdown is called. It then returns,
+// without shutting down: call Shutdown with a deadline.
+func (in *PermissionsService) Run(ctx context.Context) error {
+	in.mu.Lock()
+	httpServer, cacheStore, retention, retained := in.httpServer, in.cacheStore, in.retention, in.retained
+	in.mu.Unlock()
+
+	if cacheStore != nil {
+		// A missing snapshot only costs reviews, it does not prevent serving
+		if err := in.checker.RestoreCacheSnapshot(ctx, cacheStore); err != nil {
+			log.Warningf("Error restoring the cached permission decisions: %v", err)
+		}
+	}
+	if in.watcher != nil {
+		in.checker.mu.RLock()
+		scope := in.checker.conf.ResolutionScope
+		in.checker.mu.RUnlock()
+		in.watcher.SetResolutionScope(scope)
+		if err := in.watcher.Start(in.stopCh); err != nil {
+			return err
+		}
+	}
+
+	retentionCtx, stopRetention := context.WithCancel(ctx)
+	defer stopRetention()
+	go RunRetention(retentionCtx, retention, DefaultRetentionInterval, retained...)
+
+	serveErr := make(chan error, 1)
+	if httpServer != nil {
+		go func() {
+			if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- err
+			}
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-in.stopCh:
+		return nil
+	case err := <-serveErr:
+		return fmt.Errorf("permissions server failed: %w", err)
+	}
+}
+
+// Shutdown stops the service, in order: the HTTP server stops accepting requests and finishes the
+// in-flight ones, the checker is drained and its audit sink flushed, the informers of the watcher are
+// stopped and the cached decisions are saved. Every step is bounded by the deadline of the context; the
+// steps after a failed one still run, and all the errors are returned. It can be called once.
+func (in *PermissionsService) Shutdown(ctx context.Context) error {
+	in.mu.Lock()
+	httpServer, cacheStore := in.httpServer, in.cacheStore
+	in.mu.Unlock()
+
+	errs := []error{}
+	if httpServer != nil {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("error shutting down the permissions server: %w", err))
+		}
+	}
+	if err := in.checker.Drain(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	close(in.stopCh)
+	if in.watcher != nil {
+		stopped := make(chan struct{})
+		go func() {
+			in.watcher.factory.Shutdown()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("RBAC informers not stopped: %w", ctx.Err()))
+		}
+	}
+
+	if cacheStore != nil {
+		if err := in.checker.SaveCacheSnapshot(ctx, cacheStore); err != nil {
+			errs = append(errs, fmt.Errorf("error saving the cached permission decisions: %w", err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	log.Infof("Permissions service shut down")
+	return nil
+}
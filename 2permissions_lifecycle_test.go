@@ -0,0 +1,164 @@
+package business
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flushingTestAuditSink is a testAuditSink counting its flushes.
+type flushingTestAuditSink struct {
+	testAuditSink
+	flushes int
+}
+
+func (in *flushingTestAuditSink) Flush(ctx context.Context) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.flushes++
+	return nil
+}
+
+func TestCacheSnapshotSurvivesRestarts(t *testing.T) {
+	store := NewFileCacheSnapshotStore(filepath.Join(t.TempDir(), "cache.json"))
+	checker := newTestChecker(aliceReadsPods(), nil)
+	_, err := checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+	require.NoError(t, checker.SaveCacheSnapshot(testCtx, store))
+
+	// The restarted checker answers from the restored decisions
+	reviews := aliceReadsPods()
+	restarted := newTestChecker(reviews, nil)
+	require.NoError(t, restarted.RestoreCacheSnapshot(testCtx, store))
+	decision, err := restarted.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, DecisionSourceCache, decision.Source)
+	assert.Zero(t, reviews.calls.Load())
+}
+
+func TestFileCacheSnapshotStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	store := NewFileCacheSnapshotStore(path)
+
+	entries, err := store.Load(testCtx)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	saved := []CachedDecisionEntry{{Key: "alice", Decision: Decision{Allowed: true}, Expires: time.Now().Add(time.Minute).UTC().Truncate(time.Second)}}
+	require.NoError(t, store.Save(testCtx, saved))
+	entries, err = store.Load(testCtx)
+	require.NoError(t, err)
+	assert.Equal(t, saved, entries)
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	_, err = store.Load(testCtx)
+	assert.Error(t, err)
+}
+
+func TestMemoryDecisionCacheRestoreSkipsExpiredAndInvalidEntries(t *testing.T) {
+	cache := newMemoryDecisionCache(0)
+	fresh := decisionCacheKey(UserInfo{Name: "alice"}, alicePods)
+	restored := cache.restore([]CachedDecisionEntry{
+		{Key: fresh, Decision: Decision{Allowed: true}, Expires: time.Now().Add(time.Minute)},
+		{Key: decisionCacheKey(UserInfo{Name: "bob"}, alicePods), Decision: Decision{Allowed: true}, Expires: time.Now().Add(-time.Minute)},
+		{Key: "carol|", Decision: Decision{Allowed: true}, Expires: time.Now().Add(time.Minute)},
+		{Key: "", Decision: Decision{Allowed: true}, Expires: time.Now().Add(time.Minute)},
+	})
+	assert.Equal(t, 1, restored)
+
+	entries := cache.snapshot()
+	require.Len(t, entries, 1)
+	assert.Equal(t, fresh, entries[0].Key)
+}
+
+func TestDrainWaitsForTheInFlightChecks(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	checker := newTestChecker(&testReviews{allow: func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+		close(started)
+		<-release
+		return true
+	}}, nil)
+	sink := &flushingTestAuditSink{}
+	checker.SetAuditSink(sink)
+
+	checked := make(chan error, 1)
+	go func() {
+		_, err := checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+		checked <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(testCtx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, checker.Drain(ctx), context.DeadlineExceeded)
+
+	// The new checks are rejected while the in-flight one finishes
+	_, err := checker.Check(testCtx, UserInfo{Name: "bob"}, alicePods)
+	assert.ErrorIs(t, err, ErrCheckerShutDown)
+	close(release)
+	require.NoError(t, <-checked)
+
+	require.NoError(t, checker.Drain(testCtx))
+	assert.Equal(t, 1, sink.flushes)
+}
+
+func TestPermissionsServiceShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	checker := newTestChecker(aliceReadsPods(), nil)
+	service := NewPermissionsService(checker, nil)
+	service.SetCacheSnapshotStore(NewFileCacheSnapshotStore(path))
+
+	ran := make(chan error, 1)
+	go func() { ran <- service.Run(testCtx) }()
+	_, err := checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+
+	require.NoError(t, service.Shutdown(testCtx))
+	require.NoError(t, <-ran)
+	_, err = checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+	assert.ErrorIs(t, err, ErrCheckerShutDown)
+
+	entries, err := NewFileCacheSnapshotStore(path).Load(testCtx)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestPermissionsServiceAppliesTheResolutionScope(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.ResolutionScope = ResolutionScope{APIGroups: []string{"apps"}}
+	checker := newTestChecker(aliceReadsPods(), conf)
+	k8s, waitWatching := newTestWatchedClientset(t)
+	watcher := NewPermissionWatcher(informers.NewSharedInformerFactory(k8s, 0))
+	service := NewPermissionsService(checker, watcher)
+
+	ran := make(chan error, 1)
+	go func() { ran <- service.Run(testCtx) }()
+	waitWatching()
+	carol := UserInfo{Name: "carol", Groups: []string{"developers"}}
+	require.Eventually(t, func() bool { return watcher.Snapshot() != nil }, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, watcher.Snapshot().EffectivePermissions(UserInfo{Name: "bob"}))
+	assert.Len(t, watcher.Snapshot().EffectivePermissions(carol), 4)
+
+	// A new scope applies from the next RBAC change
+	require.NoError(t, service.ApplyConfig(NewPermissionsConfig()))
+	_, err := k8s.RbacV1().ClusterRoles().Create(testCtx, testClusterRole("unrelated"), meta_v1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return len(watcher.Snapshot().EffectivePermissions(UserInfo{Name: "bob"})) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, service.Shutdown(testCtx))
+	require.NoError(t, <-ran)
+}
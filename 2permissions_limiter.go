@@ -0,0 +1,182 @@
+package business
+
+import (
+	"context"
+	"sync"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// DefaultMaxConcurrentAPIRequests is the default bound of the apiserver requests made concurrently by
+// the reviews and the fan-out operations.
+const DefaultMaxConcurrentAPIRequests = 20
+
+// APIRequestLimiter bounds the apiserver requests made concurrently by the reviews and the fan-out
+// operations (warm-ups, per-namespace reviews...) using it, so the load on the apiserver stays
+// predictable however many of them run at the same time, and the background operations yield to the
+// interactive checks. Each PermissionChecker has its own, see PermissionChecker.APIRequestLimiter; the
+// other clients can share it with NewLimitedClient.
+//
+// It is a semaphore whose size can change while it is in use. The waiters are queued by priority, and the
+// freed slots granted by weighted round robin, so the background requests yield to the interactive ones
+// under load without starving.
+type APIRequestLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	inFlight int
+	// waiters are closed when granted a slot, in the order of priorities
+	waiters map[Priority][]chan struct{}
+	// credits are the slots left to grant to each priority in the current round
+	credits map[Priority]int
+}
+
+// NewAPIRequestLimiter creates a limiter of limit concurrent requests. Zero or less means
+// DefaultMaxConcurrentAPIRequests.
+func NewAPIRequestLimiter(limit int) *APIRequestLimiter {
+	if limit <= 0 {
+		limit = DefaultMaxConcurrentAPIRequests
+	}
+	return &APIRequestLimiter{limit: limit, waiters: map[Priority][]chan struct{}{}, credits: map[Priority]int{}}
+}
+
+// acquire waits for a slot, granted according to the priority of the context, or for the context to be done.
+func (in *APIRequestLimiter) acquire(ctx context.Context) error {
+	priority := PriorityFromContext(ctx)
+	in.mu.Lock()
+	if in.inFlight < in.limit && in.waiting() == 0 {
+		in.inFlight++
+		in.mu.Unlock()
+		return nil
+	}
+	granted := make(chan struct{})
+	in.waiters[priority] = append(in.waiters[priority], granted)
+	in.mu.Unlock()
+
+	select {
+	case <-granted:
+		return nil
+	case <-ctx.Done():
+		in.mu.Lock()
+		defer in.mu.Unlock()
+		for i, waiter := range in.waiters[priority] {
+			if waiter == granted {
+				in.waiters[priority] = append(in.waiters[priority][:i], in.waiters[priority][i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// Granted meanwhile, the slot is given to the next waiter
+		in.inFlight--
+		in.dispatch()
+		return ctx.Err()
+	}
+}
+
+// release frees a slot taken by acquire.
+func (in *APIRequestLimiter) release() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.inFlight--
+	in.dispatch()
+}
+
+// SetLimit changes the bound of the limiter, including for the requests waiting for a slot. Zero or
+// less means DefaultMaxConcurrentAPIRequests. It is also set by PermissionChecker.ApplyConfig.
+func (in *APIRequestLimiter) SetLimit(limit int) {
+	if limit <= 0 {
+		limit = DefaultMaxConcurrentAPIRequests
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.limit = limit
+	in.dispatch()
+}
+
+// waiting returns the number of queued waiters. The caller holds the lock.
+func (in *APIRequestLimiter) waiting() int {
+	n := 0
+	for _, waiters := range in.waiters {
+		n += len(waiters)
+	}
+	return n
+}
+
+// dispatch grants the free slots to the waiters. The caller holds the lock.
+func (in *APIRequestLimiter) dispatch() {
+	for in.inFlight < in.limit {
+		priority, ok := in.next()
+		if !ok {
+			return
+		}
+		granted := in.waiters[priority][0]
+		in.waiters[priority] = in.waiters[priority][1:]
+		in.inFlight++
+		close(granted)
+	}
+}
+
+// next returns the priority of the next waiter to grant: the highest priority with waiters and credits
+// left, a new round starting when none has. The caller holds the lock.
+func (in *APIRequestLimiter) next() (Priority, bool) {
+	for round := 0; round < 2; round++ {
+		for _, priority := range priorities {
+			if len(in.waiters[priority]) > 0 && in.credits[priority] > 0 {
+				in.credits[priority]--
+				return priority, true
+			}
+		}
+		for _, priority := range priorities {
+			in.credits[priority] = priorityWeights[priority]
+		}
+	}
+	return "", false
+}
+
+// limitedClient is a PermissionsClient whose reviews count against a limiter.
+type limitedClient struct {
+	PermissionsClient
+	limiter *APIRequestLimiter
+}
+
+// NewLimitedClient wraps the client so its SubjectAccessReviews and SelfSubjectRulesReviews count against
+// the limiter, e.g. the one of a checker to share its MaxConcurrentAPIRequests with a fan-out.
+func NewLimitedClient(client PermissionsClient, limiter *APIRequestLimiter) PermissionsClient {
+	return &limitedClient{PermissionsClient: client, limiter: limiter}
+}
+
+// Identity is the identity of the wrapped client.
+func (in *limitedClient) Identity() string {
+	return clientIdentity(in.PermissionsClient)
+}
+
+func (in *limitedClient) CreateSelfSubjectAccessReview(ctx context.Context, ssar *auth_v1.SelfSubjectAccessReview) (*auth_v1.SelfSubjectAccessReview, error) {
+	return createSelfSubjectAccessReview(ctx, in.PermissionsClient, ssar)
+}
+
+func (in *limitedClient) ListNamespaces(ctx context.Context) ([]core_v1.Namespace, error) {
+	return listClientNamespaces(ctx, in.PermissionsClient)
+}
+
+func (in *limitedClient) CreateSubjectAccessReview(ctx context.Context, sar *auth_v1.SubjectAccessReview) (*auth_v1.SubjectAccessReview, error) {
+	if err := in.limiter.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer in.limiter.release()
+	return in.PermissionsClient.CreateSubjectAccessReview(ctx, sar)
+}
+
+func (in *limitedClient) CreateUserRulesReview(ctx context.Context, user UserInfo, namespace string) (*auth_v1.SelfSubjectRulesReview, error) {
+	if err := in.limiter.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer in.limiter.release()
+	return createUserRulesReview(ctx, in.PermissionsClient, user, namespace)
+}
+
+func (in *limitedClient) GetSelfSubjectRulesReview(ctx context.Context, namespace string) (*auth_v1.SelfSubjectRulesReview, error) {
+	if err := in.limiter.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer in.limiter.release()
+	return in.PermissionsClient.GetSelfSubjectRulesReview(ctx, namespace)
+}
@@ -0,0 +1,191 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitForWaiters waits until n acquires are queued in the limiter.
+func waitForWaiters(t *testing.T, limiter *APIRequestLimiter, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		return limiter.waiting() == n
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestAPIRequestLimiterWeights(t *testing.T) {
+	limiter := NewAPIRequestLimiter(1)
+	require.NoError(t, limiter.acquire(testCtx))
+
+	var mu sync.Mutex
+	granted := []Priority{}
+	wg := sync.WaitGroup{}
+	queue := func(priority Priority) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := limiter.acquire(WithPriority(testCtx, priority)); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			granted = append(granted, priority)
+			mu.Unlock()
+			limiter.release()
+		}()
+	}
+	// The background ones queue first, and still yield to the interactive ones
+	queue(PriorityBackground)
+	queue(PriorityBackground)
+	waitForWaiters(t, limiter, 2)
+	for i := 0; i < 8; i++ {
+		queue(PriorityInteractive)
+	}
+	waitForWaiters(t, limiter, 10)
+
+	limiter.release()
+	wg.Wait()
+
+	i, b := PriorityInteractive, PriorityBackground
+	assert.Equal(t, []Priority{i, i, i, i, b, i, i, i, i, b}, granted)
+	assert.Zero(t, limiter.inFlight)
+}
+
+func TestAPIRequestLimiterBackgroundDoesNotStarve(t *testing.T) {
+	limiter := NewAPIRequestLimiter(1)
+	require.NoError(t, limiter.acquire(testCtx))
+
+	done := make(chan error, 1)
+	go func() {
+		err := limiter.acquire(WithPriority(testCtx, PriorityBackground))
+		if err == nil {
+			limiter.release()
+		}
+		done <- err
+	}()
+	waitForWaiters(t, limiter, 1)
+
+	limiter.release()
+	require.NoError(t, <-done)
+}
+
+func TestAPIRequestLimiterCancellation(t *testing.T) {
+	limiter := NewAPIRequestLimiter(1)
+	require.NoError(t, limiter.acquire(testCtx))
+
+	ctx, cancel := context.WithCancel(testCtx)
+	done := make(chan error, 1)
+	go func() { done <- limiter.acquire(WithPriority(ctx, PriorityBackground)) }()
+	waitForWaiters(t, limiter, 1)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	waitForWaiters(t, limiter, 0)
+
+	// The cancelled waiter neither holds nor blocks the slot
+	limiter.release()
+	ctx, cancel = context.WithTimeout(testCtx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, limiter.acquire(ctx))
+	limiter.release()
+	assert.Zero(t, limiter.inFlight)
+}
+
+func TestAPIRequestLimiterSetLimit(t *testing.T) {
+	limiter := NewAPIRequestLimiter(1)
+	require.NoError(t, limiter.acquire(testCtx))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, limiter.acquire(testCtx))
+		}()
+	}
+	waitForWaiters(t, limiter, 2)
+
+	// Raising the limit grants the waiters without any release
+	limiter.SetLimit(3)
+	wg.Wait()
+	assert.Equal(t, 3, limiter.inFlight)
+
+	// Lowering it below the slots in flight only delays the next acquires
+	limiter.SetLimit(1)
+	limiter.release()
+	limiter.release()
+	ctx, cancel := context.WithTimeout(testCtx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, limiter.acquire(ctx), context.DeadlineExceeded)
+	limiter.release()
+	require.NoError(t, limiter.acquire(testCtx))
+}
+
+func TestAPIRequestLimiterUnknownPriority(t *testing.T) {
+	assert.Equal(t, PriorityInteractive, PriorityFromContext(WithPriority(testCtx, "urgent")))
+	assert.Equal(t, PriorityInteractive, PriorityFromContext(testCtx))
+	assert.Equal(t, PriorityBackground, PriorityFromContext(WithPriority(testCtx, PriorityBackground)))
+
+	limiter := NewAPIRequestLimiter(1)
+	require.NoError(t, limiter.acquire(testCtx))
+	done := make(chan error, 1)
+	go func() { done <- limiter.acquire(WithPriority(testCtx, "urgent")) }()
+	waitForWaiters(t, limiter, 1)
+
+	limiter.release()
+	require.NoError(t, <-done)
+}
+
+func TestParsePriority(t *testing.T) {
+	priority, err := ParsePriority("background")
+	require.NoError(t, err)
+	assert.Equal(t, PriorityBackground, priority)
+
+	_, err = ParsePriority("urgent")
+	assert.Error(t, err)
+}
+
+func TestCheckersHaveTheirOwnAPIRequestLimiter(t *testing.T) {
+	checker, other := newTestChecker(&testReviews{}, nil), newTestChecker(&testReviews{}, nil)
+	assert.Equal(t, DefaultMaxConcurrentAPIRequests, checker.APIRequestLimiter().limit)
+
+	conf := NewPermissionsConfig()
+	conf.MaxConcurrentAPIRequests = 4
+	require.NoError(t, checker.ApplyConfig(conf))
+	assert.Equal(t, 4, checker.APIRequestLimiter().limit)
+	assert.Equal(t, DefaultMaxConcurrentAPIRequests, other.APIRequestLimiter().limit)
+
+	checker.APIRequestLimiter().SetLimit(0)
+	assert.Equal(t, DefaultMaxConcurrentAPIRequests, checker.APIRequestLimiter().limit)
+}
+
+func TestFanOutsShareTheMaxConcurrentAPIRequests(t *testing.T) {
+	limiter := NewAPIRequestLimiter(2)
+	var inflight, maxInflight atomic.Int64
+	client := NewLimitedClient(rulesReviewClient(&inflight, &maxInflight), limiter)
+	namespaces := []string{}
+	for i := 0; i < 10; i++ {
+		namespaces = append(namespaces, fmt.Sprintf("ns%d", i))
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := ReviewNamespaceRules(testCtx, client, namespaces, 5)
+			assert.Len(t, result.Rules, len(namespaces))
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, maxInflight.Load(), int64(2))
+}
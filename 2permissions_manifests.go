@@ -0,0 +1,115 @@
+package business
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// invalidNameChars matches the characters not allowed in the name of an RBAC object.
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// manifestName joins the parts into a valid object name, e.g. for roles generated for a user.
+func manifestName(parts ...string) string {
+	name := invalidNameChars.ReplaceAllString(strings.ToLower(strings.Join(parts, "-")), "-")
+	name = strings.Trim(name, "-.")
+	if len(name) > 253 {
+		name = strings.TrimRight(name[:253], "-.")
+	}
+	return name
+}
+
+// subjectForUser returns the binding subject referring to the user. ServiceAccount
+// usernames (system:serviceaccount:<namespace>:<name>) are mapped to ServiceAccount subjects.
+func subjectForUser(username string) rbac_v1.Subject {
+	if parts := strings.Split(username, ":"); len(parts) == 4 && parts[0] == "system" && parts[1] == "serviceaccount" {
+		return rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Namespace: parts[2], Name: parts[3]}
+	}
+	return rbac_v1.Subject{Kind: rbac_v1.UserKind, APIGroup: rbac_v1.GroupName, Name: username}
+}
+
+// buildRoleManifests returns a Role and a RoleBinding granting the rules to the subjects in the
+// namespace, or a ClusterRole and a ClusterRoleBinding if the namespace is empty.
+func buildRoleManifests(name, namespace string, rules []rbac_v1.PolicyRule, subjects []rbac_v1.Subject) []runtime.Object {
+	if namespace == "" {
+		return []runtime.Object{
+			&rbac_v1.ClusterRole{
+				TypeMeta:   meta_v1.TypeMeta{APIVersion: rbac_v1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+				ObjectMeta: meta_v1.ObjectMeta{Name: name},
+				Rules:      rules,
+			},
+			&rbac_v1.ClusterRoleBinding{
+				TypeMeta:   meta_v1.TypeMeta{APIVersion: rbac_v1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+				ObjectMeta: meta_v1.ObjectMeta{Name: name},
+				RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: name},
+				Subjects:   subjects,
+			},
+		}
+	}
+
+	return []runtime.Object{
+		&rbac_v1.Role{
+			TypeMeta:   meta_v1.TypeMeta{APIVersion: rbac_v1.SchemeGroupVersion.String(), Kind: "Role"},
+			ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: namespace},
+			Rules:      rules,
+		},
+		&rbac_v1.RoleBinding{
+			TypeMeta:   meta_v1.TypeMeta{APIVersion: rbac_v1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+			ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: namespace},
+			RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "Role", Name: name},
+			Subjects:   subjects,
+		},
+	}
+}
+
+// RenderManifests renders the objects as a multi-document YAML stream.
+func RenderManifests(objects []runtime.Object) (string, error) {
+	docs := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		doc, err := yaml.Marshal(obj)
+		if err != nil {
+			return "", fmt.Errorf("error rendering %s manifest: %w", obj.GetObjectKind().GroupVersionKind().Kind, err)
+		}
+		docs = append(docs, string(doc))
+	}
+	return strings.Join(docs, "---\n"), nil
+}
+
+// RenderAsManifests renders the minimal roles and bindings reproducing the current effective access of
+// the user, e.g. to migrate ad hoc grants into GitOps: a ClusterRole and ClusterRoleBinding for the
+// cluster-wide permissions, and a Role and RoleBinding per namespace for the others. They bind the user
+// directly, whatever grants the access today, groups included. Namespaced permissions also granted
+// cluster-wide are left out. Non-resource rules are not rendered.
+func (in *RBACSnapshot) RenderAsManifests(user UserInfo) (string, error) {
+	permissions := in.EffectivePermissions(user)
+	clusterWide := []AccessRequest{}
+	for p := range permissions {
+		if p.Namespace == "" {
+			clusterWide = append(clusterWide, p)
+		}
+	}
+	clusterRules := SynthesizeRole(clusterWide)
+
+	requirements := clusterWide
+	for p := range permissions {
+		if p.Namespace == "" {
+			continue
+		}
+		covered := false
+		for _, rule := range clusterRules {
+			if ruleAllows(rule, p) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			requirements = append(requirements, p)
+		}
+	}
+	return SynthesizeRoleManifests(manifestName("access", user.Name), requirements, []rbac_v1.Subject{subjectForUser(user.Name)})
+}
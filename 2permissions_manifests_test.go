@@ -0,0 +1,95 @@
+package business
+
+import (
+	"strings"
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parseTestManifests parses the multi-document YAML of RenderManifests back into RBAC objects.
+func parseTestManifests(t *testing.T, manifests string) []runtime.Object {
+	objects := []runtime.Object{}
+	for _, doc := range strings.Split(manifests, "---\n") {
+		var typeMeta meta_v1.TypeMeta
+		require.NoError(t, yaml.Unmarshal([]byte(doc), &typeMeta))
+		var obj runtime.Object
+		switch typeMeta.Kind {
+		case "ClusterRole":
+			obj = &rbac_v1.ClusterRole{}
+		case "ClusterRoleBinding":
+			obj = &rbac_v1.ClusterRoleBinding{}
+		case "Role":
+			obj = &rbac_v1.Role{}
+		case "RoleBinding":
+			obj = &rbac_v1.RoleBinding{}
+		default:
+			t.Fatalf("unexpected manifest kind %q", typeMeta.Kind)
+		}
+		require.NoError(t, yaml.Unmarshal([]byte(doc), obj))
+		objects = append(objects, obj)
+	}
+	return objects
+}
+
+func TestRenderAsManifests(t *testing.T) {
+	// The pods of ns1 are also granted cluster-wide to bob
+	objects := append(podReaderObjects(),
+		testRoleBinding("ns1", "developers-pods", "ClusterRole", "pod-reader", testGroup("developers")),
+		testRoleBinding("ns1", "bob-pods", "ClusterRole", "pod-reader", testUser("bob")),
+	)
+	snapshot := testSnapshot(objects...)
+
+	// The access granted through groups is bound to the user directly
+	carol := UserInfo{Name: "carol", Groups: []string{"developers"}}
+	manifests, err := snapshot.RenderAsManifests(carol)
+	require.NoError(t, err)
+	rendered := parseTestManifests(t, manifests)
+	require.Len(t, rendered, 2)
+	role, ok := rendered[0].(*rbac_v1.Role)
+	require.True(t, ok, "%T", rendered[0])
+	assert.Equal(t, "access-carol", role.Name)
+	assert.Equal(t, "ns1", role.Namespace)
+	binding, ok := rendered[1].(*rbac_v1.RoleBinding)
+	require.True(t, ok, "%T", rendered[1])
+	assert.Equal(t, []rbac_v1.Subject{testUser("carol")}, binding.Subjects)
+
+	// The rendered manifests reproduce the access of the user
+	renderedSnapshot := testSnapshot(rendered...)
+	for p := range snapshot.EffectivePermissions(carol) {
+		assert.True(t, renderedSnapshot.Explain(UserInfo{Name: "carol"}, p).Allowed, "%+v", p)
+	}
+
+	// Namespaced permissions also granted cluster-wide are left out
+	manifests, err = snapshot.RenderAsManifests(UserInfo{Name: "bob"})
+	require.NoError(t, err)
+	rendered = parseTestManifests(t, manifests)
+	require.Len(t, rendered, 2)
+	assert.IsType(t, &rbac_v1.ClusterRole{}, rendered[0])
+	assert.IsType(t, &rbac_v1.ClusterRoleBinding{}, rendered[1])
+	for _, verb := range []string{"get", "list", "watch"} {
+		assert.True(t, testSnapshot(rendered...).Explain(UserInfo{Name: "bob"}, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: verb}).Allowed, verb)
+	}
+}
+
+func TestRenderAsManifestsOfServiceAccounts(t *testing.T) {
+	robot := "system:serviceaccount:ns1:robot"
+	snapshot := testSnapshot(append(podReaderObjects(),
+		testRoleBinding("ns1", "robot-pods", "ClusterRole", "pod-reader", rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Namespace: "ns1", Name: "robot"}),
+	)...)
+
+	manifests, err := snapshot.RenderAsManifests(UserInfo{Name: robot})
+	require.NoError(t, err)
+	rendered := parseTestManifests(t, manifests)
+	require.Len(t, rendered, 2)
+	binding, ok := rendered[1].(*rbac_v1.RoleBinding)
+	require.True(t, ok, "%T", rendered[1])
+	assert.Equal(t, "access-system-serviceaccount-ns1-robot", binding.Name)
+	assert.Equal(t, []rbac_v1.Subject{{Kind: rbac_v1.ServiceAccountKind, Namespace: "ns1", Name: "robot"}}, binding.Subjects)
+}
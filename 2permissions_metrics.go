@@ -0,0 +1,120 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/tools/metrics"
+)
+
+// permissionAPIGroups are the API groups called by the permission subsystem.
+var permissionAPIGroups = []string{"authorization.k8s.io", "rbac.authorization.k8s.io"}
+
+// The client metrics are shared by the registries, see RegisterClientMetrics.
+var (
+	clientLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kiali_permissions_apiserver_request_duration_seconds",
+		Help:    "Latency of the apiserver calls of the permission subsystem.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"verb", "resource"})
+	clientResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kiali_permissions_apiserver_request_results_total",
+		Help: "Number of apiserver calls of the permission subsystem, partitioned by status code, method and host.",
+	}, []string{"code", "method", "host"})
+)
+
+// RegisterClientMetrics wires the client-go request metrics into the registry when conf.ClientMetrics is
+// set, so operators can see the apiserver load generated by the permission subsystem:
+//
+//	kiali_permissions_apiserver_request_duration_seconds{verb, resource}: the latency of the calls to the
+//	authorization and RBAC APIs, e.g. resource="subjectaccessreviews.authorization.k8s.io"
+//	kiali_permissions_apiserver_request_results_total{code, method, host}: the results of the calls of
+//	the clients of the checkers, see NewAccountingClient
+//
+// It can be called for several registries, and again for the same one. client-go accepts a single metrics
+// adapter per process: nothing is observed if another one was registered first.
+func RegisterClientMetrics(conf *PermissionsConfig, registry prometheus.Registerer) error {
+	if !conf.ClientMetrics {
+		return nil
+	}
+	for _, collector := range []prometheus.Collector{clientLatency, clientResults} {
+		if err := registry.Register(collector); err != nil && !errors.As(err, &prometheus.AlreadyRegisteredError{}) {
+			return err
+		}
+	}
+	// Only the first adapter is kept by client-go, the next calls do nothing
+	metrics.Register(metrics.RegisterOpts{
+		RequestLatency: &latencyMetric{histogram: clientLatency},
+		RequestResult:  &resultMetric{counter: clientResults},
+	})
+	return nil
+}
+
+type permissionsCallKey struct{}
+
+// withPermissionsCall marks the context of an apiserver call of the permission subsystem, for the
+// client metrics.
+func withPermissionsCall(ctx context.Context) context.Context {
+	return context.WithValue(ctx, permissionsCallKey{}, true)
+}
+
+// isPermissionsCall returns true if the context is the one of an apiserver call of the permission
+// subsystem, see withPermissionsCall.
+func isPermissionsCall(ctx context.Context) bool {
+	marked, _ := ctx.Value(permissionsCallKey{}).(bool)
+	return marked
+}
+
+// latencyMetric adapts a histogram to the client-go latency metric, keeping the permission API calls.
+type latencyMetric struct {
+	histogram *prometheus.HistogramVec
+}
+
+func (in *latencyMetric) Observe(ctx context.Context, verb string, u url.URL, latency time.Duration) {
+	group, resource := requestResource(u.Path)
+	if !containsString(permissionAPIGroups, group) {
+		return
+	}
+	in.histogram.WithLabelValues(verb, resource+"."+group).Observe(latency.Seconds())
+}
+
+// resultMetric adapts a counter to the client-go result metric, keeping the calls of the permission
+// subsystem: client-go does not report their URL.
+type resultMetric struct {
+	counter *prometheus.CounterVec
+}
+
+func (in *resultMetric) Increment(ctx context.Context, code, method, host string) {
+	if !isPermissionsCall(ctx) {
+		return
+	}
+	in.counter.WithLabelValues(code, method, host).Inc()
+}
+
+// requestResource returns the API group and the resource of an apiserver URL path, e.g.
+// /apis/rbac.authorization.k8s.io/v1/namespaces/ns1/roles/r1 is rbac.authorization.k8s.io and roles.
+// Names and namespaces are dropped to keep the cardinality of the metrics low.
+func requestResource(path string) (string, string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	var group string
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		group = parts[1]
+		parts = parts[3:]
+	default:
+		return "", ""
+	}
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		parts = parts[2:]
+	}
+	if len(parts) == 0 {
+		return group, ""
+	}
+	return group, parts[0]
+}
@@ -0,0 +1,124 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestResource(t *testing.T) {
+	cases := map[string][2]string{
+		"/apis/rbac.authorization.k8s.io/v1/namespaces/ns1/roles/r1":             {"rbac.authorization.k8s.io", "roles"},
+		"/apis/rbac.authorization.k8s.io/v1/clusterroles":                        {"rbac.authorization.k8s.io", "clusterroles"},
+		"/apis/authorization.k8s.io/v1/subjectaccessreviews":                     {"authorization.k8s.io", "subjectaccessreviews"},
+		"/apis/authorization.k8s.io/v1/namespaces/ns1/localsubjectaccessreviews": {"authorization.k8s.io", "localsubjectaccessreviews"},
+		"/api/v1/namespaces/ns1/pods/web":                                        {"", "pods"},
+		"/api/v1/namespaces":                                                     {"", "namespaces"},
+		"/apis/apps/v1":                                                          {"apps", ""},
+		"/version":                                                               {"", ""},
+	}
+	for path, expected := range cases {
+		group, resource := requestResource(path)
+		assert.Equal(t, expected, [2]string{group, resource}, path)
+	}
+}
+
+func TestLatencyMetricKeepsThePermissionCalls(t *testing.T) {
+	histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test_latency_seconds"}, []string{"verb", "resource"})
+	metric := &latencyMetric{histogram: histogram}
+
+	metric.Observe(testCtx, "POST", url.URL{Path: "/apis/authorization.k8s.io/v1/subjectaccessreviews"}, time.Second)
+	metric.Observe(testCtx, "GET", url.URL{Path: "/apis/rbac.authorization.k8s.io/v1/namespaces/ns1/rolebindings"}, time.Second)
+	metric.Observe(testCtx, "GET", url.URL{Path: "/api/v1/namespaces/ns1/pods"}, time.Second)
+
+	assert.Equal(t, 2, testutil.CollectAndCount(histogram))
+	assert.Equal(t, uint64(1), histogramCount(t, histogram, "POST", "subjectaccessreviews.authorization.k8s.io"))
+	assert.Equal(t, uint64(1), histogramCount(t, histogram, "GET", "rolebindings.rbac.authorization.k8s.io"))
+}
+
+// histogramCount returns the number of observations of the histogram with the label values.
+func histogramCount(t *testing.T, histogram *prometheus.HistogramVec, labels ...string) uint64 {
+	t.Helper()
+	observer, err := histogram.GetMetricWithLabelValues(labels...)
+	require.NoError(t, err)
+	metric := &dto.Metric{}
+	require.NoError(t, observer.(prometheus.Metric).Write(metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestResultMetric(t *testing.T) {
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_results_total"}, []string{"code", "method", "host"})
+	metric := &resultMetric{counter: counter}
+	ctx := withPermissionsCall(testCtx)
+
+	metric.Increment(ctx, "200", "POST", "kubernetes")
+	metric.Increment(ctx, "200", "POST", "kubernetes")
+	metric.Increment(ctx, "403", "GET", "kubernetes")
+	// The calls of the other clients are not counted
+	metric.Increment(testCtx, "200", "GET", "kubernetes")
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(counter.WithLabelValues("200", "POST", "kubernetes")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(counter.WithLabelValues("403", "GET", "kubernetes")))
+	assert.Equal(t, 2, testutil.CollectAndCount(counter))
+}
+
+func TestAccountingClientMarksThePermissionsCalls(t *testing.T) {
+	var marked bool
+	client := NewAccountingClient(&contextRecordingClient{record: func(ctx context.Context) { marked = isPermissionsCall(ctx) }})
+
+	_, err := client.ListRoleBindings(testCtx, "ns1")
+	require.NoError(t, err)
+	assert.True(t, marked)
+}
+
+// contextRecordingClient records the context of its ListRoleBindings calls.
+type contextRecordingClient struct {
+	PermissionsClient
+	record func(ctx context.Context)
+}
+
+func (in *contextRecordingClient) ListRoleBindings(ctx context.Context, namespace string) ([]rbac_v1.RoleBinding, error) {
+	in.record(ctx)
+	return nil, nil
+}
+
+func TestRegisterClientMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	conf := NewPermissionsConfig()
+
+	// Disabled by default
+	require.NoError(t, RegisterClientMetrics(conf, registry))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	assert.Empty(t, families)
+
+	conf.ClientMetrics = true
+	require.NoError(t, RegisterClientMetrics(conf, registry))
+	err = registry.Register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kiali_permissions_apiserver_request_results_total",
+		Help: "Number of apiserver calls of the permission subsystem, partitioned by status code, method and host.",
+	}, []string{"code", "method", "host"}))
+	assert.True(t, errors.As(err, &prometheus.AlreadyRegisteredError{}))
+
+	// Registering again, in the same registry or another one, is fine
+	require.NoError(t, RegisterClientMetrics(conf, registry))
+	require.NoError(t, RegisterClientMetrics(conf, prometheus.NewRegistry()))
+
+	// Other registration errors are returned
+	conflicting := prometheus.NewRegistry()
+	require.NoError(t, conflicting.Register(prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kiali_permissions_apiserver_request_results_total",
+		Help: "Conflicting help.",
+	})))
+	assert.Error(t, RegisterClientMetrics(conf, conflicting))
+}
@@ -0,0 +1,96 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NamespaceSelector selects namespaces by name, with a glob like "team-*", and by labels, with a label
+// selector like "env=prod". A namespace is selected if it matches both; an empty selector selects all
+// the namespaces.
+type NamespaceSelector struct {
+	// Glob matches the namespace names with the syntax of path.Match.
+	Glob string `json:"glob,omitempty"`
+	// LabelSelector matches the namespace labels with the syntax of kubectl -l.
+	LabelSelector string `json:"labelSelector,omitempty"`
+}
+
+// NamespaceLister lists the namespaces of the cluster, e.g. from a namespace informer.
+type NamespaceLister func(ctx context.Context) ([]core_v1.Namespace, error)
+
+// IsEmpty returns true if the selector selects all the namespaces.
+func (in NamespaceSelector) IsEmpty() bool {
+	return in.Glob == "" && in.LabelSelector == ""
+}
+
+// Validate checks the syntax of the glob and the label selector.
+func (in NamespaceSelector) Validate() error {
+	if _, err := path.Match(in.Glob, ""); err != nil {
+		return fmt.Errorf("invalid namespace glob %q: %w", in.Glob, err)
+	}
+	if _, err := labels.Parse(in.LabelSelector); err != nil {
+		return fmt.Errorf("invalid namespace label selector %q: %w", in.LabelSelector, err)
+	}
+	return nil
+}
+
+// Select returns the sorted names of the selected namespaces.
+func (in NamespaceSelector) Select(namespaces []core_v1.Namespace) ([]string, error) {
+	selector, err := labels.Parse(in.LabelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace label selector %q: %w", in.LabelSelector, err)
+	}
+	selected := []string{}
+	for _, namespace := range namespaces {
+		if in.Glob != "" {
+			if matched, err := path.Match(in.Glob, namespace.Name); err != nil {
+				return nil, fmt.Errorf("invalid namespace glob %q: %w", in.Glob, err)
+			} else if !matched {
+				continue
+			}
+		}
+		if selector.Matches(labels.Set(namespace.Labels)) {
+			selected = append(selected, namespace.Name)
+		}
+	}
+	sort.Strings(selected)
+	return selected, nil
+}
+
+// Namespaces returns the sorted namespaces of the Roles and RoleBindings of the snapshot, the only ones
+// where the grants differ from the cluster-wide ones.
+func (in *RBACSnapshot) Namespaces() []string {
+	seen := map[string]bool{}
+	for _, role := range in.Roles {
+		seen[role.Namespace] = true
+	}
+	for _, rb := range in.RoleBindings {
+		seen[rb.Namespace] = true
+	}
+	namespaces := make([]string, 0, len(seen))
+	for namespace := range seen {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// WhoCanInNamespaces returns the subjects allowed to make the request in at least one of the namespaces,
+// sorted and without duplicates, see WhoCan. The namespace of the request is ignored. No namespace
+// means no subject.
+func (in *RBACSnapshot) WhoCanInNamespaces(attrs AccessRequest, namespaces []string) []rbac_v1.Subject {
+	if len(namespaces) == 0 {
+		return []rbac_v1.Subject{}
+	}
+	selected := map[string]bool{}
+	for _, namespace := range namespaces {
+		selected[namespace] = true
+	}
+	return in.whoCan(attrs, func(grant RoleGrant) bool { return grant.Namespace == "" || selected[grant.Namespace] })
+}
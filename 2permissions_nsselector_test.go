@@ -0,0 +1,107 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testNamespace(name string, labels map[string]string) core_v1.Namespace {
+	return core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+// testNamespaces lists ns1 in prod and ns2 in dev.
+func testNamespaces(ctx context.Context) ([]core_v1.Namespace, error) {
+	return []core_v1.Namespace{
+		testNamespace("ns2", map[string]string{"env": "dev"}),
+		testNamespace("ns1", map[string]string{"env": "prod"}),
+	}, nil
+}
+
+func TestNamespaceSelector(t *testing.T) {
+	namespaces := []core_v1.Namespace{
+		testNamespace("team-b", map[string]string{"env": "prod"}),
+		testNamespace("team-a", map[string]string{"env": "dev"}),
+		testNamespace("kube-system", map[string]string{"env": "prod"}),
+	}
+	for _, tc := range []struct {
+		selector NamespaceSelector
+		expected []string
+	}{
+		{selector: NamespaceSelector{}, expected: []string{"kube-system", "team-a", "team-b"}},
+		{selector: NamespaceSelector{Glob: "team-*"}, expected: []string{"team-a", "team-b"}},
+		{selector: NamespaceSelector{LabelSelector: "env=prod"}, expected: []string{"kube-system", "team-b"}},
+		{selector: NamespaceSelector{Glob: "team-*", LabelSelector: "env in (prod)"}, expected: []string{"team-b"}},
+		{selector: NamespaceSelector{Glob: "other-*"}, expected: []string{}},
+	} {
+		selected, err := tc.selector.Select(namespaces)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, selected, "%+v", tc.selector)
+	}
+
+	assert.True(t, NamespaceSelector{}.IsEmpty())
+	assert.Error(t, NamespaceSelector{Glob: "team-["}.Validate())
+	assert.Error(t, NamespaceSelector{LabelSelector: "=prod"}.Validate())
+	_, err := NamespaceSelector{LabelSelector: "env in prod"}.Select(namespaces)
+	assert.Error(t, err)
+}
+
+func TestWhoCanInNamespaces(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+	assert.Equal(t, []string{"ns1"}, snapshot.Namespaces())
+
+	pods := AccessRequest{Resource: "pods", Verb: "get"}
+	assert.Equal(t, podReaderSubjects(), snapshot.WhoCanInNamespaces(pods, []string{"ns1", "ns2"}))
+	assert.Equal(t, []rbac_v1.Subject{testUser("bob")}, snapshot.WhoCanInNamespaces(pods, []string{"ns2"}))
+	assert.Empty(t, snapshot.WhoCanInNamespaces(pods, nil))
+}
+
+func TestWhoCanNamespaceSelector(t *testing.T) {
+	server := newTestServer(&testReviews{allow: allowUsers("admin")}, nil)
+	whoCan := func(query string) WhoCanResponse {
+		w := serve(server, "GET", "/api/who-can?verb=get&resource=pods&"+query, "admin")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response WhoCanResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	// Without namespace lister, the globs match the namespaces of the snapshot
+	response := whoCan("namespaceGlob=ns*")
+	assert.Equal(t, []string{"ns1"}, response.Namespaces)
+	assert.Equal(t, podReaderSubjects(), response.Subjects)
+	assert.Equal(t, http.StatusBadRequest, serve(server, "GET", "/api/who-can?verb=get&resource=pods&namespaceSelector=env%3Ddev", "admin").Code)
+
+	server.SetNamespaceLister(testNamespaces)
+	response = whoCan("namespaceSelector=env%3Ddev")
+	assert.Equal(t, []string{"ns2"}, response.Namespaces)
+	assert.Equal(t, []rbac_v1.Subject{testUser("bob")}, response.Subjects)
+
+	assert.Equal(t, http.StatusBadRequest, serve(server, "GET", "/api/who-can?verb=get&resource=pods&namespace=ns1&namespaceGlob=ns*", "admin").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(server, "GET", "/api/who-can?verb=get&resource=pods&namespaceGlob=ns[", "admin").Code)
+}
+
+func TestPermissionMatrixNamespaceSelector(t *testing.T) {
+	server := newTestServer(&testReviews{allow: allowUsers("admin")}, nil)
+	server.SetNamespaceLister(testNamespaces)
+
+	w := serve(server, "GET", "/api/permissions-matrix?user=alice&user=bob&namespaceSelector=env%3Ddev", "admin")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response PermissionMatrixResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, []string{"ns2"}, response.Namespaces)
+	// The permissions of alice are in ns1 only, the ones of bob cluster-wide
+	require.Len(t, response.Entries, 3)
+	for _, entry := range response.Entries {
+		assert.Equal(t, "bob", entry.User)
+		assert.Empty(t, entry.Namespace)
+	}
+}
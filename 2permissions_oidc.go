@@ -0,0 +1,255 @@
+package business
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// oidcKeysRefreshInterval bounds the refreshes of the keys of the issuer, triggered by the tokens signed
+// with unknown keys, so forged tokens cannot flood the issuer.
+const oidcKeysRefreshInterval = time.Minute
+
+// oidcProvider verifies the ID tokens of an OpenID Connect issuer locally, with the published keys.
+type oidcProvider struct {
+	issuer         string
+	clientID       string
+	usernameClaim  string
+	usernamePrefix string
+	groupsClaim    string
+	groupsPrefix   string
+	client         *http.Client
+	// flight shares the fetches of the keys between the concurrent tokens signed with an unknown key.
+	flight singleflight.Group
+
+	mu          sync.Mutex
+	keys        *jose.JSONWebKeySet
+	refreshedAt time.Time
+}
+
+// NewOIDCIdentityProvider authenticates the bearer tokens that are ID tokens of an OpenID Connect issuer,
+// like the OIDC authentication of the apiserver. The signatures are verified with the keys published by
+// the issuer, without a request per token. The tokens of other issuers, e.g. the service account tokens,
+// are left to the next providers. Its options are:
+//
+//	issuer-url: the URL of the issuer, with its /.well-known/openid-configuration
+//	client-id: the audience of the tokens
+//	username-claim: the claim of the username. Default sub. The email claim needs email_verified set to true
+//	username-prefix: prepended to the usernames, e.g. oidc:. Like the apiserver, the usernames of other claims
+//	  than email are prefixed with the issuer URL and # by default; - disables the prefix
+//	groups-claim: the claim of the groups, a string or a list. Empty means no groups
+//	groups-prefix: prepended to the groups
+//	ca-file: the CAs of the issuer, the system ones by default
+//
+// The tokens whose username or groups are system: identities, reserved to Kubernetes, are rejected.
+func NewOIDCIdentityProvider(options map[string]string) (IdentityProvider, error) {
+	provider := &oidcProvider{
+		issuer:         options["issuer-url"],
+		clientID:       options["client-id"],
+		usernameClaim:  options["username-claim"],
+		usernamePrefix: options["username-prefix"],
+		groupsClaim:    options["groups-claim"],
+		groupsPrefix:   options["groups-prefix"],
+		client:         &http.Client{Timeout: 10 * time.Second},
+	}
+	if !strings.HasPrefix(provider.issuer, "https://") {
+		return nil, fmt.Errorf("invalid issuer URL %q, expected an https URL", provider.issuer)
+	}
+	if provider.clientID == "" {
+		return nil, errors.New("the client ID is required")
+	}
+	if provider.usernameClaim == "" {
+		provider.usernameClaim = "sub"
+	}
+	switch {
+	case provider.usernamePrefix == "-":
+		provider.usernamePrefix = ""
+	case provider.usernamePrefix == "" && provider.usernameClaim != "email":
+		// The subjects of different issuers could collide with each other and with the other users
+		provider.usernamePrefix = provider.issuer + "#"
+	}
+	if caFile := options["ca-file"]; caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the issuer CAs: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		provider.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}}
+	}
+	return provider, nil
+}
+
+func (in *oidcProvider) ResolveUser(ctx context.Context, creds Credentials) (UserInfo, error) {
+	if creds.Token == "" {
+		return UserInfo{}, ErrNoCredentials
+	}
+	token, err := jwt.ParseSigned(creds.Token)
+	if err != nil {
+		// Not a JWT, e.g. an opaque token of another provider
+		return UserInfo{}, ErrNoCredentials
+	}
+	unverified := jwt.Claims{}
+	if err := token.UnsafeClaimsWithoutVerification(&unverified); err != nil || unverified.Issuer != in.issuer {
+		return UserInfo{}, ErrNoCredentials
+	}
+	if len(token.Headers) == 0 || token.Headers[0].KeyID == "" {
+		return UserInfo{}, errors.New("the ID token has no key ID")
+	}
+
+	key, err := in.key(ctx, token.Headers[0].KeyID)
+	if err != nil {
+		return UserInfo{}, err
+	}
+	standard := jwt.Claims{}
+	claims := map[string]interface{}{}
+	if err := token.Claims(key, &standard, &claims); err != nil {
+		return UserInfo{}, fmt.Errorf("invalid signature of the ID token: %w", err)
+	}
+	// ValidateWithLeeway accepts the tokens without expiry, which would be valid forever
+	if standard.Expiry == nil {
+		return UserInfo{}, errors.New("invalid ID token: the token has no expiry")
+	}
+	expected := jwt.Expected{Issuer: in.issuer, Audience: jwt.Audience{in.clientID}, Time: time.Now()}
+	if err := standard.ValidateWithLeeway(expected, jwt.DefaultLeeway); err != nil {
+		return UserInfo{}, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	name, _ := claims[in.usernameClaim].(string)
+	if name == "" {
+		return UserInfo{}, fmt.Errorf("the ID token has no %s claim", in.usernameClaim)
+	}
+	if in.usernameClaim == "email" {
+		// Anybody can claim an unverified email, like the apiserver the claim must be verified explicitly
+		if verified, _ := claims["email_verified"].(bool); !verified {
+			return UserInfo{}, fmt.Errorf("the email %s of the ID token is not verified", name)
+		}
+	}
+	user := UserInfo{Name: in.usernamePrefix + name, Groups: []string{}}
+	if strings.HasPrefix(user.Name, "system:") {
+		return UserInfo{}, fmt.Errorf("the username %s of the ID token is reserved to Kubernetes", user.Name)
+	}
+	if in.groupsClaim != "" {
+		switch groups := claims[in.groupsClaim].(type) {
+		case string:
+			user.Groups = append(user.Groups, in.groupsPrefix+groups)
+		case []interface{}:
+			for _, group := range groups {
+				if group, ok := group.(string); ok {
+					user.Groups = append(user.Groups, in.groupsPrefix+group)
+				}
+			}
+		}
+	}
+	for _, group := range user.Groups {
+		if strings.HasPrefix(group, "system:") {
+			return UserInfo{}, fmt.Errorf("the group %s of the ID token is reserved to Kubernetes", group)
+		}
+	}
+	return user, nil
+}
+
+// key returns the key of the issuer with the ID, refreshing the keys if it is unknown. The keys are fetched
+// without holding the lock, once for the concurrent tokens.
+func (in *oidcProvider) key(ctx context.Context, id string) (*jose.JSONWebKey, error) {
+	in.mu.Lock()
+	keys, refreshedAt := in.keys, in.refreshedAt
+	in.mu.Unlock()
+	if keys != nil {
+		if keys := keys.Key(id); len(keys) > 0 {
+			return &keys[0], nil
+		}
+		if time.Since(refreshedAt) < oidcKeysRefreshInterval {
+			return nil, fmt.Errorf("the ID token is signed with the unknown key %s", id)
+		}
+	}
+
+	// The fetch is shared, it does not stop with the context of the first token; the client bounds it
+	results := in.flight.DoChan("keys", func() (interface{}, error) {
+		return in.refreshKeys(context.WithoutCancel(ctx))
+	})
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return nil, fmt.Errorf("error fetching the keys of issuer %s: %w", in.issuer, result.Err)
+		}
+		keys = result.Val.(*jose.JSONWebKeySet)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if keys := keys.Key(id); len(keys) > 0 {
+		return &keys[0], nil
+	}
+	return nil, fmt.Errorf("the ID token is signed with the unknown key %s", id)
+}
+
+// refreshKeys fetches the keys of the issuer, unless they were refreshed within the interval, e.g. by a
+// fetch that ended meanwhile.
+func (in *oidcProvider) refreshKeys(ctx context.Context) (*jose.JSONWebKeySet, error) {
+	in.mu.Lock()
+	if in.keys != nil && time.Since(in.refreshedAt) < oidcKeysRefreshInterval {
+		defer in.mu.Unlock()
+		return in.keys, nil
+	}
+	in.mu.Unlock()
+
+	keys, err := in.fetchKeys(ctx)
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.refreshedAt = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	in.keys = keys
+	return keys, nil
+}
+
+// fetchKeys fetches the keys published by the issuer, at the jwks_uri of its discovery document.
+func (in *oidcProvider) fetchKeys(ctx context.Context) (*jose.JSONWebKeySet, error) {
+	discovery := struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}{}
+	if err := in.getJSON(ctx, strings.TrimSuffix(in.issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	// The discovery document of another issuer must not be trusted, see OpenID Connect Discovery 4.3
+	if discovery.Issuer != in.issuer {
+		return nil, fmt.Errorf("the discovery document is of issuer %q", discovery.Issuer)
+	}
+	keys := &jose.JSONWebKeySet{}
+	if err := in.getJSON(ctx, discovery.JWKSURI, keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (in *oidcProvider) getJSON(ctx context.Context, url string, value interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := in.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(value)
+}
@@ -0,0 +1,213 @@
+package business
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testIssuer is an OpenID Connect issuer publishing the key-1 key, and counting the fetches of its keys.
+type testIssuer struct {
+	server     *httptest.Server
+	caFile     string
+	key        *rsa.PrivateKey
+	keyFetches atomic.Int64
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	issuer := &testIssuer{key: key}
+	issuer.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": issuer.server.URL, "jwks_uri": issuer.server.URL + "/keys"})
+		case "/keys":
+			issuer.keyFetches.Add(1)
+			_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "key-1", Algorithm: "RS256", Use: "sig"}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(issuer.server.Close)
+
+	issuer.caFile = filepath.Join(t.TempDir(), "issuer-ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(issuer.caFile, caPEM, 0o600))
+	return issuer
+}
+
+// token returns an ID token of the issuer with the claims, signed with the key of the ID.
+func (in *testIssuer) token(t *testing.T, keyID string, claims jwt.Claims, extra map[string]interface{}) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: in.key, KeyID: keyID}}, nil)
+	require.NoError(t, err)
+	builder := jwt.Signed(signer).Claims(claims)
+	if extra != nil {
+		builder = builder.Claims(extra)
+	}
+	token, err := builder.CompactSerialize()
+	require.NoError(t, err)
+	return token
+}
+
+// claims returns valid claims of the issuer for kiali, for the next hour.
+func (in *testIssuer) claims(subject string) jwt.Claims {
+	now := time.Now()
+	return jwt.Claims{Issuer: in.server.URL, Subject: subject, Audience: jwt.Audience{"kiali"}, IssuedAt: jwt.NewNumericDate(now), Expiry: jwt.NewNumericDate(now.Add(time.Hour))}
+}
+
+func (in *testIssuer) provider(t *testing.T, options map[string]string) IdentityProvider {
+	options["issuer-url"], options["client-id"], options["ca-file"] = in.server.URL, "kiali", in.caFile
+	provider, err := NewOIDCIdentityProvider(options)
+	require.NoError(t, err)
+	return provider
+}
+
+func TestOIDCIdentityProviderOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{},
+		{"issuer-url": "http://issuer.example.com", "client-id": "kiali"},
+		{"issuer-url": "https://issuer.example.com"},
+		{"issuer-url": "https://issuer.example.com", "client-id": "kiali", "ca-file": filepath.Join(t.TempDir(), "missing.pem")},
+	} {
+		_, err := NewOIDCIdentityProvider(options)
+		assert.Error(t, err, options)
+	}
+}
+
+func TestOIDCIdentityProvider(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t, map[string]string{"username-claim": "email", "username-prefix": "oidc:", "groups-claim": "groups", "groups-prefix": "oidc:"})
+
+	token := issuer.token(t, "key-1", issuer.claims("1234"), map[string]interface{}{"email": "alice@example.com", "email_verified": true, "groups": []string{"developers", "qa"}})
+	user, err := provider.ResolveUser(testCtx, Credentials{Token: token})
+	require.NoError(t, err)
+	assert.Equal(t, UserInfo{Name: "oidc:alice@example.com", Groups: []string{"oidc:developers", "oidc:qa"}}, user)
+
+	// A single group can be a string
+	token = issuer.token(t, "key-1", issuer.claims("1234"), map[string]interface{}{"email": "alice@example.com", "email_verified": true, "groups": "developers"})
+	user, err = provider.ResolveUser(testCtx, Credentials{Token: token})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"oidc:developers"}, user.Groups)
+
+	token = issuer.token(t, "key-1", issuer.claims("1234"), map[string]interface{}{"email": "mallory@example.com", "email_verified": false})
+	_, err = provider.ResolveUser(testCtx, Credentials{Token: token})
+	assert.Error(t, err)
+	// The email must be verified explicitly
+	token = issuer.token(t, "key-1", issuer.claims("1234"), map[string]interface{}{"email": "mallory@example.com"})
+	_, err = provider.ResolveUser(testCtx, Credentials{Token: token})
+	assert.Error(t, err)
+	token = issuer.token(t, "key-1", issuer.claims("1234"), nil)
+	_, err = provider.ResolveUser(testCtx, Credentials{Token: token})
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), issuer.keyFetches.Load())
+}
+
+func TestOIDCIdentityProviderRejectsInvalidTokens(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t, map[string]string{})
+
+	claims := issuer.claims("alice")
+	claims.Audience = jwt.Audience{"grafana"}
+	_, err := provider.ResolveUser(testCtx, Credentials{Token: issuer.token(t, "key-1", claims, nil)})
+	assert.Error(t, err)
+
+	claims = issuer.claims("alice")
+	claims.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	_, err = provider.ResolveUser(testCtx, Credentials{Token: issuer.token(t, "key-1", claims, nil)})
+	assert.Error(t, err)
+
+	// A token without expiry would be valid forever
+	claims = issuer.claims("alice")
+	claims.Expiry = nil
+	_, err = provider.ResolveUser(testCtx, Credentials{Token: issuer.token(t, "key-1", claims, nil)})
+	assert.ErrorContains(t, err, "no expiry")
+
+	// The keys are refreshed at most once per interval for the unknown keys
+	for i := 0; i < 3; i++ {
+		_, err = provider.ResolveUser(testCtx, Credentials{Token: issuer.token(t, "key-2", issuer.claims("alice"), nil)})
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int64(1), issuer.keyFetches.Load())
+
+	user, err := provider.ResolveUser(testCtx, Credentials{Token: issuer.token(t, "key-1", issuer.claims("alice"), nil)})
+	require.NoError(t, err)
+	assert.Equal(t, UserInfo{Name: issuer.server.URL + "#alice", Groups: []string{}}, user)
+}
+
+func TestOIDCIdentityProviderUsernamePrefix(t *testing.T) {
+	issuer := newTestIssuer(t)
+	token := issuer.token(t, "key-1", issuer.claims("1234"), map[string]interface{}{"email": "alice@example.com", "email_verified": true})
+	for _, test := range []struct {
+		options map[string]string
+		name    string
+	}{
+		// Like the apiserver, the subjects are prefixed with the issuer by default
+		{map[string]string{}, issuer.server.URL + "#1234"},
+		{map[string]string{"username-prefix": "-"}, "1234"},
+		{map[string]string{"username-prefix": "oidc:"}, "oidc:1234"},
+		{map[string]string{"username-claim": "email"}, "alice@example.com"},
+	} {
+		user, err := issuer.provider(t, test.options).ResolveUser(testCtx, Credentials{Token: token})
+		require.NoError(t, err)
+		assert.Equal(t, test.name, user.Name, test.options)
+	}
+}
+
+func TestOIDCIdentityProviderRejectsTheSystemIdentities(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t, map[string]string{"username-prefix": "-", "groups-claim": "groups"})
+
+	_, err := provider.ResolveUser(testCtx, Credentials{Token: issuer.token(t, "key-1", issuer.claims("system:admin"), nil)})
+	assert.ErrorContains(t, err, "reserved")
+	token := issuer.token(t, "key-1", issuer.claims("alice"), map[string]interface{}{"groups": []string{"developers", "system:masters"}})
+	_, err = provider.ResolveUser(testCtx, Credentials{Token: token})
+	assert.ErrorContains(t, err, "reserved")
+}
+
+func TestOIDCIdentityProviderFetchesTheKeysOnce(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t, map[string]string{})
+	token := issuer.token(t, "key-1", issuer.claims("alice"), nil)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := provider.ResolveUser(testCtx, Credentials{Token: token})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1), issuer.keyFetches.Load())
+}
+
+func TestOIDCIdentityProviderLeavesOtherTokens(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider(t, map[string]string{})
+
+	claims := issuer.claims("system:serviceaccount:istio-system:kiali")
+	claims.Issuer = "https://kubernetes.default.svc"
+	for _, token := range []string{"", "opaque-token", issuer.token(t, "key-1", claims, nil)} {
+		_, err := provider.ResolveUser(testCtx, Credentials{Token: token})
+		assert.ErrorIs(t, err, ErrNoCredentials, token)
+	}
+	assert.Equal(t, int64(0), issuer.keyFetches.Load())
+}
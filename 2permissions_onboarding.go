@@ -0,0 +1,181 @@
+package business
+
+import (
+	"fmt"
+	"sort"
+
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// AccessTier is the access granted to a team in its namespace. The tiers are the default user-facing
+// ClusterRoles of Kubernetes, with the same name.
+type AccessTier string
+
+const (
+	AccessTierView  AccessTier = "view"
+	AccessTierEdit  AccessTier = "edit"
+	AccessTierAdmin AccessTier = "admin"
+)
+
+// OnboardedTeamLabel is set on the objects of the onboarding bundles to the name of the team.
+const OnboardedTeamLabel = "permissions.kiali.io/team"
+
+// tierProbes are the requests telling the tiers apart: each tier allows its own probes and those of
+// the lower tiers, and denies those of the higher tiers.
+var tierProbes = []struct {
+	tier     AccessTier
+	requests []AccessRequest
+}{
+	{tier: AccessTierView, requests: []AccessRequest{
+		{Resource: "pods", Verb: "list"},
+		{Resource: "pods", Subresource: "log", Verb: "get"},
+		{APIGroup: "apps", Resource: "deployments", Verb: "watch"},
+	}},
+	{tier: AccessTierEdit, requests: []AccessRequest{
+		{Resource: "secrets", Verb: "get"},
+		{Resource: "pods", Subresource: "exec", Verb: "create"},
+		{APIGroup: "apps", Resource: "deployments", Verb: "update"},
+	}},
+	{tier: AccessTierAdmin, requests: []AccessRequest{
+		{APIGroup: rbac_v1.GroupName, Resource: "rolebindings", Verb: "create"},
+		{APIGroup: rbac_v1.GroupName, Resource: "roles", Verb: "delete"},
+	}},
+}
+
+// TeamDefinition describes a team to onboard.
+type TeamDefinition struct {
+	Name string `yaml:"name"`
+	// Namespace is the namespace of the team, the name of the team by default.
+	Namespace string     `yaml:"namespace"`
+	Groups    []string   `yaml:"groups"`
+	Tier      AccessTier `yaml:"tier"`
+}
+
+// OnboardingBundle holds the objects onboarding a team: its namespace and a RoleBinding of the
+// ClusterRole of the tier to its groups. Network policies, quotas and the like are left to the
+// platform conventions and not generated.
+type OnboardingBundle struct {
+	Team    TeamDefinition
+	Objects []runtime.Object
+}
+
+// OnboardingCheck is a probe of the verification of an onboarding bundle.
+type OnboardingCheck struct {
+	Request  AccessRequest `json:"request"`
+	Expected bool          `json:"expected"`
+	Allowed  bool          `json:"allowed"`
+}
+
+// OnboardingVerification compares the access of a team with its tier. The checks are sorted, the
+// failed ones first.
+type OnboardingVerification struct {
+	Team   string            `json:"team"`
+	Tier   AccessTier        `json:"tier"`
+	Passed bool              `json:"passed"`
+	Checks []OnboardingCheck `json:"checks"`
+}
+
+// BuildOnboardingBundle returns the onboarding bundle of the team, see OnboardingBundle.
+func BuildOnboardingBundle(team TeamDefinition) (*OnboardingBundle, error) {
+	if team.Name == "" {
+		return nil, fmt.Errorf("the team has no name")
+	}
+	if len(team.Groups) == 0 {
+		return nil, fmt.Errorf("team %s has no groups", team.Name)
+	}
+	if _, err := probesOf(team.Tier); err != nil {
+		return nil, fmt.Errorf("team %s: %w", team.Name, err)
+	}
+	if team.Namespace == "" {
+		team.Namespace = manifestName(team.Name)
+	}
+
+	labels := map[string]string{OnboardedTeamLabel: manifestName(team.Name)}
+	subjects := make([]rbac_v1.Subject, 0, len(team.Groups))
+	for _, group := range team.Groups {
+		subjects = append(subjects, rbac_v1.Subject{Kind: rbac_v1.GroupKind, APIGroup: rbac_v1.GroupName, Name: group})
+	}
+	return &OnboardingBundle{
+		Team: team,
+		Objects: []runtime.Object{
+			&core_v1.Namespace{
+				TypeMeta:   meta_v1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+				ObjectMeta: meta_v1.ObjectMeta{Name: team.Namespace, Labels: labels},
+			},
+			&rbac_v1.RoleBinding{
+				TypeMeta:   meta_v1.TypeMeta{APIVersion: rbac_v1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+				ObjectMeta: meta_v1.ObjectMeta{Name: manifestName(team.Name, string(team.Tier)), Namespace: team.Namespace, Labels: labels},
+				RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: string(team.Tier)},
+				Subjects:   subjects,
+			},
+		},
+	}, nil
+}
+
+// Verify checks that the bundle, once applied to the cluster of the snapshot, grants the members of
+// the team the access of the tier in the namespace, and not the access of a higher tier. The snapshot
+// provides the ClusterRoles of the tiers, which are aggregated and differ between clusters, and the
+// bindings already granting access to the groups, e.g. cluster-wide.
+func (in *OnboardingBundle) Verify(snapshot *RBACSnapshot) (*OnboardingVerification, error) {
+	probes, err := probesOf(in.Team.Tier)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := snapshot.ClusterRoles[string(in.Team.Tier)]; !ok {
+		return nil, fmt.Errorf("the cluster has no %s ClusterRole", in.Team.Tier)
+	}
+
+	applied := &RBACSnapshot{
+		ClusterRoles:        snapshot.ClusterRoles,
+		Roles:               snapshot.Roles,
+		ClusterRoleBindings: snapshot.ClusterRoleBindings,
+		RoleBindings:        append([]*rbac_v1.RoleBinding{}, snapshot.RoleBindings...),
+		LoadedAt:            snapshot.LoadedAt,
+	}
+	for _, obj := range in.Objects {
+		if rb, ok := obj.(*rbac_v1.RoleBinding); ok {
+			applied.RoleBindings = append(applied.RoleBindings, rb)
+		}
+	}
+
+	// A member of the team, having only its groups
+	member := UserInfo{Name: "onboarding:" + manifestName(in.Team.Name), Groups: in.Team.Groups}
+	verification := &OnboardingVerification{Team: in.Team.Name, Tier: in.Team.Tier, Passed: true, Checks: []OnboardingCheck{}}
+	for req, expected := range probes {
+		req.Namespace = in.Team.Namespace
+		check := OnboardingCheck{Request: req, Expected: expected, Allowed: applied.Explain(member, req).Allowed}
+		if check.Allowed != check.Expected {
+			verification.Passed = false
+		}
+		verification.Checks = append(verification.Checks, check)
+	}
+	sort.SliceStable(verification.Checks, func(i, j int) bool {
+		a, b := verification.Checks[i], verification.Checks[j]
+		if failedA, failedB := a.Allowed != a.Expected, b.Allowed != b.Expected; failedA != failedB {
+			return failedA
+		}
+		return fmt.Sprint(a.Request) < fmt.Sprint(b.Request)
+	})
+	return verification, nil
+}
+
+// probesOf returns the probes of the tiers, with true for the ones the tier must allow.
+func probesOf(tier AccessTier) (map[AccessRequest]bool, error) {
+	probes := map[AccessRequest]bool{}
+	expected, found := true, false
+	for _, tp := range tierProbes {
+		for _, req := range tp.requests {
+			probes[req] = expected
+		}
+		if tp.tier == tier {
+			expected, found = false, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown access tier %q, expected %s, %s or %s", tier, AccessTierView, AccessTierEdit, AccessTierAdmin)
+	}
+	return probes, nil
+}
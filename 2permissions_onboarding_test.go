@@ -0,0 +1,93 @@
+package business
+
+import (
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tierClusterRoles are simplified default ClusterRoles of the access tiers.
+func tierClusterRoles() []runtime.Object {
+	view := []rbac_v1.PolicyRule{
+		testRule([]string{""}, []string{"pods", "pods/log"}, []string{"get", "list", "watch"}),
+		testRule([]string{"apps"}, []string{"deployments"}, []string{"get", "list", "watch"}),
+	}
+	edit := []rbac_v1.PolicyRule{
+		testRule([]string{""}, []string{"secrets"}, []string{"get", "list", "watch", "create", "update", "delete"}),
+		testRule([]string{""}, []string{"pods/exec"}, []string{"create"}),
+		testRule([]string{"apps"}, []string{"deployments"}, []string{"create", "update", "patch", "delete"}),
+	}
+	admin := []rbac_v1.PolicyRule{
+		testRule([]string{rbac_v1.GroupName}, []string{"roles", "rolebindings"}, []string{"get", "list", "watch", "create", "update", "delete"}),
+	}
+	return []runtime.Object{
+		testClusterRole("view", view...),
+		testClusterRole("edit", append(append([]rbac_v1.PolicyRule{}, view...), edit...)...),
+		testClusterRole("admin", append(append(append([]rbac_v1.PolicyRule{}, view...), edit...), admin...)...),
+	}
+}
+
+func TestBuildOnboardingBundle(t *testing.T) {
+	bundle, err := BuildOnboardingBundle(TeamDefinition{Name: "Payments", Groups: []string{"payments-devs", "payments-ops"}, Tier: AccessTierEdit})
+	require.NoError(t, err)
+	require.Len(t, bundle.Objects, 2)
+
+	// The namespace is named after the team by default
+	ns, ok := bundle.Objects[0].(*core_v1.Namespace)
+	require.True(t, ok, "%T", bundle.Objects[0])
+	assert.Equal(t, "payments", ns.Name)
+	assert.Equal(t, "payments", ns.Labels[OnboardedTeamLabel])
+	rb, ok := bundle.Objects[1].(*rbac_v1.RoleBinding)
+	require.True(t, ok, "%T", bundle.Objects[1])
+	assert.Equal(t, "payments-edit", rb.Name)
+	assert.Equal(t, "payments", rb.Namespace)
+	assert.Equal(t, rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: "edit"}, rb.RoleRef)
+	assert.Equal(t, []rbac_v1.Subject{testGroup("payments-devs"), testGroup("payments-ops")}, rb.Subjects)
+
+	bundle, err = BuildOnboardingBundle(TeamDefinition{Name: "payments", Namespace: "team-payments", Groups: []string{"payments"}, Tier: AccessTierView})
+	require.NoError(t, err)
+	assert.Equal(t, "team-payments", bundle.Objects[1].(*rbac_v1.RoleBinding).Namespace)
+
+	for _, invalid := range []TeamDefinition{
+		{Groups: []string{"payments"}, Tier: AccessTierView},
+		{Name: "payments", Tier: AccessTierView},
+		{Name: "payments", Groups: []string{"payments"}, Tier: "owner"},
+	} {
+		_, err := BuildOnboardingBundle(invalid)
+		assert.Error(t, err, "%+v", invalid)
+	}
+}
+
+func TestVerifyOnboardingBundle(t *testing.T) {
+	snapshot := testSnapshot(tierClusterRoles()...)
+
+	bundle, err := BuildOnboardingBundle(TeamDefinition{Name: "payments", Groups: []string{"payments"}, Tier: AccessTierEdit})
+	require.NoError(t, err)
+	verification, err := bundle.Verify(snapshot)
+	require.NoError(t, err)
+	assert.True(t, verification.Passed)
+	assert.Equal(t, AccessTierEdit, verification.Tier)
+	require.Len(t, verification.Checks, 8)
+	for _, check := range verification.Checks {
+		assert.Equal(t, check.Request.APIGroup != rbac_v1.GroupName, check.Expected, "%+v", check.Request)
+		assert.Equal(t, "payments", check.Request.Namespace)
+	}
+
+	// Access granted beyond the tier fails the verification, the failed checks first
+	snapshot = testSnapshot(append(tierClusterRoles(), testClusterRoleBinding("payments-admin", "admin", testGroup("payments")))...)
+	verification, err = bundle.Verify(snapshot)
+	require.NoError(t, err)
+	assert.False(t, verification.Passed)
+	for i, check := range verification.Checks {
+		assert.Equal(t, i < 2, check.Allowed != check.Expected, "%+v", check)
+	}
+
+	// The tiers are the ClusterRoles of the cluster
+	_, err = bundle.Verify(testSnapshot())
+	assert.Error(t, err)
+}
@@ -0,0 +1,179 @@
+package business
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DecisionSourceOverlay is the source of the decisions denied by the overlay policy.
+const DecisionSourceOverlay = "overlay"
+
+// OverlayPolicy is a policy layer applied by the PermissionChecker on top of the decisions of the
+// authorizers. It can only deny: in its default-deny namespaces, for extra-sensitive environments, a
+// request allowed by the authorizers is only allowed if an overlay rule also allows it.
+type OverlayPolicy struct {
+	// DefaultDenyNamespaces are glob patterns, e.g. prod-*. For namespace objects the name of the
+	// namespace is matched.
+	DefaultDenyNamespaces []string `yaml:"default_deny_namespaces"`
+	// Allows are the explicit allows of the default-deny namespaces.
+	Allows []OverlayRule `yaml:"allows"`
+}
+
+// OverlayRule explicitly allows requests in the default-deny namespaces. Empty lists match anything, and
+// so does "*" in Verbs, APIGroups and Resources.
+type OverlayRule struct {
+	Users  []string `yaml:"users"`
+	Groups []string `yaml:"groups"`
+	// Namespaces are glob patterns.
+	Namespaces []string `yaml:"namespaces"`
+	Verbs      []string `yaml:"verbs"`
+	APIGroups  []string `yaml:"api_groups"`
+	Resources  []string `yaml:"resources"`
+}
+
+// validate checks the glob patterns of the policy.
+func (in OverlayPolicy) validate() error {
+	_, err := in.compile()
+	return err
+}
+
+// compile returns the policy with its glob patterns compiled, or an error if one is invalid.
+func (in OverlayPolicy) compile() (*compiledOverlay, error) {
+	defaultDeny, err := compileNamespaceGlobs(in.DefaultDenyNamespaces)
+	if err != nil {
+		return nil, fmt.Errorf("default_deny_namespaces: %w", err)
+	}
+	overlay := &compiledOverlay{defaultDeny: defaultDeny, allows: make([]compiledOverlayRule, 0, len(in.Allows))}
+	for i, rule := range in.Allows {
+		namespaces, err := compileNamespaceGlobs(rule.Namespaces)
+		if err != nil {
+			return nil, fmt.Errorf("allows[%d].namespaces: %w", i, err)
+		}
+		overlay.allows = append(overlay.allows, compiledOverlayRule{OverlayRule: rule, namespaces: namespaces})
+	}
+	return overlay, nil
+}
+
+// compiledOverlay is an OverlayPolicy with its glob patterns compiled, see OverlayPolicy.compile.
+type compiledOverlay struct {
+	defaultDeny namespaceGlobs
+	allows      []compiledOverlayRule
+}
+
+type compiledOverlayRule struct {
+	OverlayRule
+	namespaces namespaceGlobs
+}
+
+// apply returns the decision, denied if the request is in a default-deny namespace and no overlay rule
+// allows it. A request of a namespaced resource without namespace, e.g. listing the pods of all the
+// namespaces, touches the default-deny namespaces too: only the rules without namespaces can allow it.
+// Denials of the authorizers are returned as is.
+func (in *compiledOverlay) apply(user UserInfo, req AccessRequest, decision Decision) Decision {
+	if !decision.Allowed || in.defaultDeny.regexp == nil {
+		return decision
+	}
+	namespace := overlayNamespace(req)
+	allNamespaces := namespace == "" && !clusterScopedResource(req)
+	if !allNamespaces && !in.defaultDeny.matches(namespace) {
+		return decision
+	}
+	for _, rule := range in.allows {
+		if rule.matches(user, req, namespace) {
+			return decision
+		}
+	}
+	reason := "namespace " + namespace + " is default-deny and no overlay rule allows the request"
+	if allNamespaces {
+		reason = "the request spans all the namespaces, some of them default-deny, and no overlay rule allows it"
+	}
+	return Decision{
+		Denied:    true,
+		Reason:    reason,
+		Source:    DecisionSourceOverlay,
+		Timestamp: time.Now(),
+	}
+}
+
+func (in compiledOverlayRule) matches(user UserInfo, req AccessRequest, namespace string) bool {
+	if len(in.Users) > 0 || len(in.Groups) > 0 {
+		if !(UserCacheTTL{Users: in.Users, Groups: in.Groups}).matches(user) {
+			return false
+		}
+	}
+	return (len(in.Namespaces) == 0 || in.namespaces.matches(namespace)) &&
+		overlayListMatches(in.Verbs, req.Verb) &&
+		overlayListMatches(in.APIGroups, req.APIGroup) &&
+		overlayListMatches(in.Resources, req.Resource)
+}
+
+// overlayNamespace returns the namespace of the request, or the name of the namespace object.
+func overlayNamespace(req AccessRequest) string {
+	if req.Namespace == "" && req.APIGroup == "" && req.Resource == "namespaces" {
+		return req.Name
+	}
+	return req.Namespace
+}
+
+// clusterScopedResources are the built-in cluster-scoped resources, by API group. The other resources,
+// e.g. the custom ones, are considered namespaced, so the overlay fails closed.
+var clusterScopedResources = map[string][]string{
+	"":                             {"namespaces", "nodes", "persistentvolumes", "componentstatuses"},
+	"rbac.authorization.k8s.io":    {"clusterroles", "clusterrolebindings"},
+	"storage.k8s.io":               {"storageclasses", "csidrivers", "csinodes", "volumeattachments"},
+	"apiextensions.k8s.io":         {"customresourcedefinitions"},
+	"apiregistration.k8s.io":       {"apiservices"},
+	"admissionregistration.k8s.io": {"mutatingwebhookconfigurations", "validatingwebhookconfigurations", "validatingadmissionpolicies", "validatingadmissionpolicybindings"},
+	"scheduling.k8s.io":            {"priorityclasses"},
+	"node.k8s.io":                  {"runtimeclasses"},
+	"networking.k8s.io":            {"ingressclasses"},
+	"certificates.k8s.io":          {"certificatesigningrequests"},
+	"flowcontrol.apiserver.k8s.io": {"flowschemas", "prioritylevelconfigurations"},
+	"authentication.k8s.io":        {"tokenreviews", "selfsubjectreviews"},
+	"authorization.k8s.io":         {"subjectaccessreviews", "selfsubjectaccessreviews", "selfsubjectrulesreviews"},
+}
+
+// clusterScopedResource returns true if the resource of the request is a built-in cluster-scoped resource.
+func clusterScopedResource(req AccessRequest) bool {
+	return containsString(clusterScopedResources[req.APIGroup], req.Resource)
+}
+
+func overlayListMatches(list []string, value string) bool {
+	return len(list) == 0 || containsString(list, "*") || containsString(list, value)
+}
+
+// namespaceGlobPattern matches the valid namespace glob patterns: namespace names, where * matches anything.
+var namespaceGlobPattern = regexp.MustCompile(`^[a-z0-9*]([-a-z0-9*]*[a-z0-9*])?$`)
+
+// namespaceGlobs are glob patterns of namespaces compiled into a single regular expression, nil for none.
+type namespaceGlobs struct {
+	regexp *regexp.Regexp
+}
+
+// compileNamespaceGlobs compiles the glob patterns, returning an error if one is not a valid namespace
+// glob pattern.
+func compileNamespaceGlobs(globs []string) (namespaceGlobs, error) {
+	if len(globs) == 0 {
+		return namespaceGlobs{}, nil
+	}
+	patterns := make([]string, 0, len(globs))
+	for _, glob := range globs {
+		if !namespaceGlobPattern.MatchString(glob) {
+			return namespaceGlobs{}, fmt.Errorf("invalid namespace glob %q, expected a namespace name where * matches anything", glob)
+		}
+		patterns = append(patterns, globToRegexp(glob))
+	}
+	compiled, err := regexp.Compile(strings.Join(patterns, "|"))
+	if err != nil {
+		return namespaceGlobs{}, fmt.Errorf("invalid namespace globs %q: %w", globs, err)
+	}
+	return namespaceGlobs{regexp: compiled}, nil
+}
+
+// matches returns true if the value matches one of the glob patterns. The empty value, e.g. of
+// cluster-scoped requests, matches no pattern.
+func (in namespaceGlobs) matches(value string) bool {
+	return value != "" && in.regexp != nil && in.regexp.MatchString(value)
+}
@@ -0,0 +1,118 @@
+package business
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testOverlay = OverlayPolicy{
+	DefaultDenyNamespaces: []string{"prod-*"},
+	Allows: []OverlayRule{
+		{Groups: []string{"sre"}},
+		{Users: []string{"alice"}, Namespaces: []string{"prod-web"}, Verbs: []string{"get", "list"}, Resources: []string{"pods"}},
+	},
+}
+
+func TestOverlayPolicyApply(t *testing.T) {
+	overlay, err := testOverlay.compile()
+	require.NoError(t, err)
+	allowed := Decision{Allowed: true, Source: DecisionSourceAPIServer}
+	alice := UserInfo{Name: "alice"}
+	cases := []struct {
+		name    string
+		user    UserInfo
+		req     AccessRequest
+		allowed bool
+	}{
+		{"not default-deny", alice, AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "delete"}, true},
+		{"cluster-scoped", alice, AccessRequest{Resource: "nodes", Verb: "list"}, true},
+		{"explicit allow", alice, AccessRequest{Namespace: "prod-web", Resource: "pods", Verb: "get"}, true},
+		{"other verb", alice, AccessRequest{Namespace: "prod-web", Resource: "pods", Verb: "delete"}, false},
+		{"other namespace", alice, AccessRequest{Namespace: "prod-db", Resource: "pods", Verb: "get"}, false},
+		{"group allow", UserInfo{Name: "bob", Groups: []string{"sre"}}, AccessRequest{Namespace: "prod-db", Resource: "secrets", Verb: "delete"}, true},
+		{"namespace object", alice, AccessRequest{Resource: "namespaces", Name: "prod-db", Verb: "delete"}, false},
+		{"all namespaces", alice, AccessRequest{Resource: "pods", Verb: "list"}, false},
+		{"all namespaces of a custom resource", alice, AccessRequest{APIGroup: "example.com", Resource: "widgets", Verb: "list"}, false},
+		{"all namespaces group allow", UserInfo{Name: "bob", Groups: []string{"sre"}}, AccessRequest{Resource: "pods", Verb: "list"}, true},
+		{"namespace list", alice, AccessRequest{Resource: "namespaces", Verb: "list"}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			decision := overlay.apply(c.user, c.req, allowed)
+			assert.Equal(t, c.allowed, decision.Allowed)
+			if !c.allowed {
+				assert.True(t, decision.Denied)
+				assert.Equal(t, DecisionSourceOverlay, decision.Source)
+				assert.Contains(t, decision.Reason, "default-deny")
+			}
+		})
+	}
+
+	denied := Decision{Denied: true, Reason: "RBAC", Source: DecisionSourceAPIServer}
+	assert.Equal(t, denied, overlay.apply(UserInfo{Name: "bob", Groups: []string{"sre"}}, AccessRequest{Namespace: "prod-db", Resource: "pods", Verb: "get"}, denied))
+	none, err := OverlayPolicy{}.compile()
+	require.NoError(t, err)
+	assert.Equal(t, allowed, none.apply(alice, AccessRequest{Namespace: "prod-db", Resource: "pods", Verb: "get"}, allowed))
+}
+
+func TestCheckerAppliesTheOverlayPolicy(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.Overlay = testOverlay
+	checker := newTestChecker(&testReviews{allow: allowUsers("alice")}, conf)
+
+	decision, err := checker.Check(testCtx, UserInfo{Name: "alice"}, AccessRequest{Namespace: "prod-web", Resource: "pods", Verb: "get"})
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+
+	// Listing the pods of all the namespaces lists the ones of prod-web too
+	decision, err = checker.Check(testCtx, UserInfo{Name: "alice"}, AccessRequest{Resource: "pods", Verb: "list"})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, DecisionSourceOverlay, decision.Source)
+
+	for i := 0; i < 2; i++ {
+		decision, err = checker.Check(testCtx, UserInfo{Name: "alice"}, AccessRequest{Namespace: "prod-web", Resource: "secrets", Verb: "get"})
+		require.NoError(t, err)
+		assert.False(t, decision.Allowed)
+		// The cached decisions of the authorizers are also overlaid
+		assert.Equal(t, DecisionSourceOverlay, decision.Source)
+	}
+}
+
+func TestNamespaceGlobs(t *testing.T) {
+	globs, err := compileNamespaceGlobs([]string{"dev", "prod-*"})
+	require.NoError(t, err)
+	assert.True(t, globs.matches("prod-web"))
+	assert.True(t, globs.matches("dev"))
+	assert.False(t, globs.matches("preprod-web"))
+	assert.False(t, globs.matches("dev-web"))
+
+	globs, err = compileNamespaceGlobs([]string{"*"})
+	require.NoError(t, err)
+	assert.True(t, globs.matches("ns1"))
+	assert.False(t, globs.matches(""))
+	assert.False(t, namespaceGlobs{}.matches("ns1"))
+
+	for _, glob := range []string{"", "Prod-*", "prod_*", "prod-[a-z]", "prod-?", "-prod"} {
+		_, err := compileNamespaceGlobs([]string{"dev", glob})
+		assert.ErrorContains(t, err, "invalid namespace glob", glob)
+	}
+}
+
+func TestConfigRejectsInvalidOverlayGlobs(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.Overlay = OverlayPolicy{DefaultDenyNamespaces: []string{"prod-[0-9]"}}
+	assert.ErrorContains(t, conf.Validate(), "overlay: default_deny_namespaces: invalid namespace glob")
+
+	conf.Overlay = OverlayPolicy{DefaultDenyNamespaces: []string{"prod-*"}, Allows: []OverlayRule{{Namespaces: []string{"prod-web"}}, {Namespaces: []string{"Prod"}}}}
+	assert.ErrorContains(t, conf.Validate(), "overlay: allows[1].namespaces: invalid namespace glob")
+
+	// The checker keeps applying its previous overlay
+	checker := newTestChecker(&testReviews{allow: allowUsers("alice")}, nil)
+	assert.Error(t, checker.ApplyConfig(conf))
+	decision, err := checker.Check(testCtx, UserInfo{Name: "alice"}, AccessRequest{Namespace: "prod-db", Resource: "pods", Verb: "get"})
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+}
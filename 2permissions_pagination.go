@@ -0,0 +1,151 @@
+package business
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kiali/kiali/log"
+)
+
+// MaxListLimit is the largest page size of the list endpoints.
+const MaxListLimit = 5000
+
+// ErrContinueExpired is returned for continue tokens of a list that changed since they were issued. The
+// client must restart from the first page, like with the 410 Gone of the apiserver.
+var ErrContinueExpired = errors.New("the list changed since the continue token was issued, restart from the first page")
+
+// ListMeta holds the pagination state of a list response, like the list metadata of Kubernetes.
+type ListMeta struct {
+	// Continue is set if there are more items, to be passed in the continue query parameter.
+	Continue string `json:"continue,omitempty"`
+	// RemainingItemCount is the number of items after the page, set if there are more items.
+	RemainingItemCount *int `json:"remainingItemCount,omitempty"`
+}
+
+// ListOptions are the pagination and field selection query parameters of the list endpoints:
+// limit, continue and fields, a comma separated list of the JSON fields kept in each item, e.g.
+// fields=kind,name for subjects. The fields only apply to the json and yaml output formats.
+type ListOptions struct {
+	// Limit is the maximum number of items per page, 0 for all of them.
+	Limit    int
+	Continue string
+	Fields   []string
+}
+
+// ParseListOptions reads the list options of the query parameters of the request.
+func ParseListOptions(r *http.Request) (ListOptions, error) {
+	query := r.URL.Query()
+	opts := ListOptions{Continue: query.Get("continue")}
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		if opts.Limit, err = strconv.Atoi(limit); err != nil || opts.Limit < 0 || opts.Limit > MaxListLimit {
+			return ListOptions{}, fmt.Errorf("invalid limit %q, expected a number between 0 and %d", limit, MaxListLimit)
+		}
+	}
+	if fields := query.Get("fields"); fields != "" {
+		for _, field := range strings.Split(fields, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				opts.Fields = append(opts.Fields, field)
+			}
+		}
+	}
+	return opts, nil
+}
+
+// paginate returns the page of the items selected by the options. The items must be sorted. The
+// continue tokens hold the position of the next page and a hash of the items, so a token of a list
+// that changed since is rejected with ErrContinueExpired rather than skipping or repeating items.
+func paginate[T any](items []T, opts ListOptions) ([]T, ListMeta, error) {
+	if opts.Limit == 0 && opts.Continue == "" {
+		return items, ListMeta{}, nil
+	}
+	hash, err := ContentHash(items)
+	if err != nil {
+		return nil, ListMeta{}, err
+	}
+	version := hash[:16]
+
+	offset := 0
+	if opts.Continue != "" {
+		token, err := base64.RawURLEncoding.DecodeString(opts.Continue)
+		if err != nil {
+			return nil, ListMeta{}, fmt.Errorf("invalid continue token")
+		}
+		tokenVersion, position, _ := strings.Cut(string(token), ":")
+		if offset, err = strconv.Atoi(position); err != nil || offset < 0 {
+			return nil, ListMeta{}, fmt.Errorf("invalid continue token")
+		}
+		if tokenVersion != version || offset > len(items) {
+			return nil, ListMeta{}, ErrContinueExpired
+		}
+	}
+
+	end := len(items)
+	if opts.Limit > 0 && offset+opts.Limit < end {
+		end = offset + opts.Limit
+	}
+	meta := ListMeta{}
+	if end < len(items) {
+		remaining := len(items) - end
+		meta.Continue = base64.RawURLEncoding.EncodeToString([]byte(version + ":" + strconv.Itoa(end)))
+		meta.RemainingItemCount = &remaining
+	}
+	return items[offset:end], meta, nil
+}
+
+// selectFields returns the JSON form of the value, where the items of its list field only keep the
+// fields. Without fields, the value is returned as is.
+func selectFields(value interface{}, listField string, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return value, nil
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(content, &object); err != nil {
+		return nil, err
+	}
+	items, _ := object[listField].([]interface{})
+	for i, item := range items {
+		full, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		selected := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			if v, ok := full[field]; ok {
+				selected[field] = v
+			}
+		}
+		items[i] = selected
+	}
+	return object, nil
+}
+
+// writeList writes a list response, see WriteOutput, with the fields of the options selected in the
+// items of its list field.
+func writeList(w http.ResponseWriter, r *http.Request, value interface{}, listField string, opts ListOptions, table *Table) {
+	selected, err := selectFields(value, listField, opts.Fields)
+	if err != nil {
+		log.Errorf("%sError selecting the fields of the %s: %v", logPrefix(requestContext(r)), listField, err)
+		http.Error(w, "error selecting the fields", http.StatusInternalServerError)
+		return
+	}
+	WriteOutput(w, r, selected, table)
+}
+
+// listError writes the error of the list options: a 410 Gone for expired continue tokens, a 400 otherwise.
+func listError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrContinueExpired) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
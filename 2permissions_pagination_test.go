@@ -0,0 +1,110 @@
+package business
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseListOptions(t *testing.T) {
+	opts, err := ParseListOptions(httptest.NewRequest(http.MethodGet, "/api/who-can?limit=10&continue=abc&fields=kind,+name,", nil))
+	require.NoError(t, err)
+	assert.Equal(t, ListOptions{Limit: 10, Continue: "abc", Fields: []string{"kind", "name"}}, opts)
+
+	opts, err = ParseListOptions(httptest.NewRequest(http.MethodGet, "/api/who-can", nil))
+	require.NoError(t, err)
+	assert.Equal(t, ListOptions{}, opts)
+
+	for _, limit := range []string{"-1", "ten", "5001"} {
+		_, err := ParseListOptions(httptest.NewRequest(http.MethodGet, "/api/who-can?limit="+limit, nil))
+		assert.Error(t, err, limit)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e"}
+
+	page, meta, err := paginate(items, ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, items, page)
+	assert.Equal(t, ListMeta{}, meta)
+
+	pages := [][]string{}
+	opts := ListOptions{Limit: 2}
+	for {
+		page, meta, err := paginate(items, opts)
+		require.NoError(t, err)
+		pages = append(pages, page)
+		if meta.Continue == "" {
+			assert.Nil(t, meta.RemainingItemCount)
+			break
+		}
+		assert.Equal(t, len(items)-2*len(pages), *meta.RemainingItemCount)
+		opts.Continue = meta.Continue
+	}
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, pages)
+
+	// The tokens of a list that changed since are rejected
+	_, meta, err = paginate(items, ListOptions{Limit: 2})
+	require.NoError(t, err)
+	_, _, err = paginate(append(items, "f"), ListOptions{Limit: 2, Continue: meta.Continue})
+	assert.ErrorIs(t, err, ErrContinueExpired)
+
+	for _, token := range []string{"not base64!", "YWJj", "YWJjOi0x"} {
+		_, _, err := paginate(items, ListOptions{Limit: 2, Continue: token})
+		require.Error(t, err, token)
+		assert.NotErrorIs(t, err, ErrContinueExpired, token)
+	}
+}
+
+func TestSelectFields(t *testing.T) {
+	value := WhoCanResponse{Request: AccessRequest{Resource: "pods", Verb: "get"}, Subjects: podReaderSubjects()}
+
+	same, err := selectFields(value, "subjects", nil)
+	require.NoError(t, err)
+	assert.Equal(t, value, same)
+
+	selected, err := selectFields(value, "subjects", []string{"name", "missing"})
+	require.NoError(t, err)
+	content, err := json.Marshal(selected)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"request":{"Namespace":"","APIGroup":"","Resource":"pods","Subresource":"","Name":"","Verb":"get","FieldSelector":"","LabelSelector":""},"subjects":[{"name":"alice"},{"name":"bob"}]}`, string(content))
+}
+
+// podReaderSubjects are the subjects allowed to get the pods of ns1 by podReaderObjects.
+func podReaderSubjects() []rbac_v1.Subject {
+	return []rbac_v1.Subject{testUser("alice"), testUser("bob")}
+}
+
+func TestWhoCanPagination(t *testing.T) {
+	server := newTestServer(&testReviews{allow: allowUsers("admin")}, nil)
+	whoCan := "/api/who-can?verb=get&resource=pods&namespace=ns1"
+
+	w := serve(server, "GET", whoCan+"&limit=1&fields=name", "admin")
+	require.Equal(t, http.StatusOK, w.Code)
+	var first map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+	assert.Equal(t, []interface{}{map[string]interface{}{"name": "alice"}}, first["subjects"])
+	assert.Equal(t, float64(1), first["remainingItemCount"])
+	token, ok := first["continue"].(string)
+	require.True(t, ok)
+
+	w = serve(server, "GET", whoCan+"&limit=1&continue="+url.QueryEscape(token), "admin")
+	require.Equal(t, http.StatusOK, w.Code)
+	var second WhoCanResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &second))
+	assert.Equal(t, podReaderSubjects()[1:], second.Subjects)
+	assert.Empty(t, second.Continue)
+
+	// The token is tied to the list it was issued for
+	w = serve(server, "GET", "/api/who-can?verb=get&apiGroup=apps&resource=deployments&namespace=ns1&limit=1&continue="+url.QueryEscape(token), "admin")
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Equal(t, http.StatusBadRequest, serve(server, "GET", whoCan+"&limit=many", "admin").Code)
+}
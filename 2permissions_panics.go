@@ -0,0 +1,44 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kiali/kiali/log"
+)
+
+// evaluationPanics counts the panics recovered during the evaluation of the requests.
+var evaluationPanics = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "kiali_permissions_evaluation_panics_total",
+	Help: "Number of panics recovered while evaluating permission requests, partitioned by component.",
+}, []string{"component"})
+
+// RegisterPanicMetrics registers kiali_permissions_evaluation_panics_total{component} in the registry,
+// where component is the name of the authorizer, or local-rbac for the local evaluation of the RBAC rules.
+// Any increase is a bug of the component, or a rule it cannot handle.
+func RegisterPanicMetrics(registry prometheus.Registerer) error {
+	return registry.Register(evaluationPanics)
+}
+
+// logPanic logs and counts a panic recovered from the component.
+func logPanic(ctx context.Context, component string, recovered interface{}) {
+	evaluationPanics.WithLabelValues(component).Inc()
+	log.Errorf("%sRecovered from a panic of %s, denying: %v\n%s", logPrefix(ctx), component, recovered, debug.Stack())
+}
+
+// authorize calls the authorizer, turning its panics into denials, so a buggy plugin cannot crash the
+// process. The error policy of the authorizer does not apply: skipping it could allow the request.
+func (in namedAuthorizer) authorize(ctx context.Context, user UserInfo, req AccessRequest) (decision Decision, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logPanic(ctx, in.name, r)
+			decision = Decision{Denied: true, Reason: "authorizer " + in.name + " failed", EvaluationError: fmt.Sprintf("panic: %v", r), Source: in.name, Timestamp: time.Now()}
+			err = nil
+		}
+	}()
+	return in.authorizer.Authorize(ctx, user, req)
+}
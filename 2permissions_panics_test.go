@@ -0,0 +1,60 @@
+package business
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// panickingAuthorizerName is the name of panickingAuthorizer in the authorizer registry.
+const panickingAuthorizerName = "panicking"
+
+// panickingAuthorizer panics on every request, like a buggy plugin.
+type panickingAuthorizer struct{}
+
+func init() {
+	RegisterAuthorizer(panickingAuthorizerName, func(PermissionsClient, map[string]string) (Authorizer, error) {
+		return panickingAuthorizer{}, nil
+	})
+}
+
+func (panickingAuthorizer) Authorize(ctx context.Context, user UserInfo, req AccessRequest) (Decision, error) {
+	var rules []rbac_v1.PolicyRule
+	return Decision{Allowed: rules[0].Verbs[0] == req.Verb}, nil
+}
+
+func TestAuthorizerPanicsAreDenials(t *testing.T) {
+	conf := NewPermissionsConfig()
+	// Even when its errors are skipped, the panics of an authorizer must not let the next one allow
+	conf.Authorizers = []AuthorizerConfig{
+		{Name: panickingAuthorizerName, OnError: AuthorizerOnErrorSkip},
+		testAuthorizerConfig("allow", "alice"),
+	}
+	checker := newTestChecker(&testReviews{}, conf)
+	panics := testutil.ToFloat64(evaluationPanics.WithLabelValues(panickingAuthorizerName))
+
+	decision, err := checker.Check(testCtx, UserInfo{Name: "alice"}, alicePods)
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.True(t, decision.Denied)
+	assert.Equal(t, panickingAuthorizerName, decision.Source)
+	assert.Contains(t, decision.EvaluationError, "panic: ")
+	assert.Equal(t, panics+1, testutil.ToFloat64(evaluationPanics.WithLabelValues(panickingAuthorizerName)))
+}
+
+func TestExplainPanicsAreDenials(t *testing.T) {
+	// A nil binding cannot be evaluated
+	snapshot := testSnapshot(podReaderObjects()...)
+	snapshot.ClusterRoleBindings = append(snapshot.ClusterRoleBindings, nil)
+	panics := testutil.ToFloat64(evaluationPanics.WithLabelValues(DecisionSourceLocalRBAC))
+
+	explanation := snapshot.Explain(UserInfo{Name: "bob"}, alicePods)
+	assert.False(t, explanation.Allowed)
+	assert.Empty(t, explanation.Paths)
+	assert.Equal(t, panics+1, testutil.ToFloat64(evaluationPanics.WithLabelValues(DecisionSourceLocalRBAC)))
+}
@@ -0,0 +1,330 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/kiali/kiali/log"
+)
+
+// DefaultPrefetchParallelism is the number of concurrent checks resolving the prefetch hints.
+const DefaultPrefetchParallelism = 4
+
+const (
+	// maxPrefetchRequests bounds the requests of a hint.
+	maxPrefetchRequests = 500
+	// maxPendingPrefetches bounds the checks waiting to be prefetched, the hints over it are dropped.
+	maxPendingPrefetches = 5000
+	// maxPrefetchHintBytes bounds the bodies of the prefetch requests.
+	maxPrefetchHintBytes = 64 << 10
+)
+
+// PrefetchHint describes the upcoming checks of a user, e.g. the UI opening the workloads page of a
+// namespace hints the verbs of pods, deployments and replicasets there:
+//
+//	{
+//	  "reason": "workloads page",
+//	  "namespaces": ["bookinfo"],
+//	  "resources": [
+//	    {"resource": "pods", "verbs": ["get", "list"]},
+//	    {"apiGroup": "apps", "resource": "deployments", "verbs": ["get", "list", "patch"]},
+//	    {"apiGroup": "apps", "resource": "replicasets", "verbs": ["get", "list"]}
+//	  ]
+//	}
+type PrefetchHint struct {
+	// Reason is the upcoming need, for the logs.
+	Reason string `json:"reason,omitempty"`
+	// Namespaces are the namespaces of the requests. Without namespace, the requests are cluster-wide.
+	Namespaces []string           `json:"namespaces,omitempty"`
+	Resources  []PrefetchResource `json:"resources"`
+}
+
+// PrefetchResource is a resource of a PrefetchHint, with the verbs to prefetch.
+type PrefetchResource struct {
+	APIGroup    string   `json:"apiGroup,omitempty"`
+	Resource    string   `json:"resource"`
+	Subresource string   `json:"subresource,omitempty"`
+	Verbs       []string `json:"verbs"`
+}
+
+// Requests returns the access requests of the hint, one per namespace, resource and verb.
+func (in PrefetchHint) Requests() []AccessRequest {
+	namespaces := in.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	requests := []AccessRequest{}
+	for _, namespace := range namespaces {
+		for _, resource := range in.Resources {
+			for _, verb := range resource.Verbs {
+				requests = append(requests, AccessRequest{Namespace: namespace, APIGroup: resource.APIGroup, Resource: resource.Resource, Subresource: resource.Subresource, Verb: verb})
+			}
+		}
+	}
+	return requests
+}
+
+func (in PrefetchHint) validate() error {
+	if len(in.Resources) == 0 {
+		return errors.New("hint without resources")
+	}
+	// The size is bounded before building the requests, counting the verbs of all the resources
+	verbs := 0
+	for _, resource := range in.Resources {
+		if resource.Resource == "" {
+			return errors.New("hinted resource without name")
+		}
+		if len(resource.Verbs) == 0 {
+			return fmt.Errorf("hinted resource %s without verbs", groupResource(resource.APIGroup, resource.Resource))
+		}
+		if verbs += len(resource.Verbs); verbs > maxPrefetchRequests {
+			return fmt.Errorf("hint of more than %d requests", maxPrefetchRequests)
+		}
+	}
+	if n := verbs * max(len(in.Namespaces), 1); n > maxPrefetchRequests {
+		return fmt.Errorf("hint of %d requests, at most %d are allowed", n, maxPrefetchRequests)
+	}
+	return nil
+}
+
+// prefetchCheck are hinted checks waiting to be resolved: a single request, or the requests of a namespace
+// resolved with one rules review, see prefetchRules.
+type prefetchCheck struct {
+	ctx context.Context
+	// keys are the cache keys of the requests
+	keys []string
+	// user has its excluded groups, see cacheTTLFor
+	user UserInfo
+	reqs []AccessRequest
+}
+
+// prefetcher queues the hinted checks of a checker, deduplicated across hints, and resolves them with
+// DefaultPrefetchParallelism workers.
+type prefetcher struct {
+	// rulesReviews tells if the client of the checker lists the rules of the users, see userRulesReviewer.
+	rulesReviews bool
+
+	mu      sync.Mutex
+	pending []prefetchCheck
+	// waiting counts the requests of the pending checks
+	waiting int
+	// queued are the cache keys of the pending and running checks
+	queued  map[string]bool
+	running int
+}
+
+func newPrefetcher(client PermissionsClient) *prefetcher {
+	_, rulesReviews := client.(userRulesReviewer)
+	return &prefetcher{rulesReviews: rulesReviews, queued: map[string]bool{}}
+}
+
+// Prefetch queues the checks of the hint for the user and returns at once, so the decisions are in the
+// cache when the actual checks come. The checks are made with PriorityBackground unless the context has a
+// priority, and outlive the context, keeping its values, e.g. its consumer. The checks already queued by
+// other hints are not repeated, and the decisions still cached make no apiserver call. It returns the
+// number of checks queued, which is zero when caching or enforcement is disabled. No denial is audited.
+//
+// When the client lists the rules of the users, see NewImpersonatingPermissionsClient, and the requests are
+// authorized with SubjectAccessReviews only, the requests of each namespace are resolved with one rules
+// review instead of one review per request.
+func (in *PermissionChecker) Prefetch(ctx context.Context, user UserInfo, hint PrefetchHint) (int, error) {
+	if err := hint.validate(); err != nil {
+		return 0, fmt.Errorf("invalid prefetch hint: %w", err)
+	}
+	if !in.beginCheck() {
+		return 0, ErrCheckerShutDown
+	}
+	defer in.inflight.Done()
+
+	in.mu.RLock()
+	conf := in.conf
+	in.mu.RUnlock()
+	if conf.Mode == EnforcementModeDisabled {
+		return 0, nil
+	}
+
+	ctx = withDefaultPriority(context.WithoutCancel(ctx), PriorityBackground)
+	// The checks keep the excluded groups, for the TTL overrides, see cacheTTLFor
+	checked := withoutGroups(user, conf.ExcludedGroups)
+	queued, dropped := 0, 0
+
+	p := in.prefetcher
+	in.mu.RLock()
+	byNamespace := p.rulesReviews && in.chain.reviewsOnly()
+	in.mu.RUnlock()
+	namespaces := map[string]int{}
+
+	p.mu.Lock()
+	for _, req := range hint.Requests() {
+		if conf.cacheTTLFor(user, req) <= 0 {
+			continue
+		}
+		key := decisionCacheKey(checked, req)
+		if p.queued[key] {
+			continue
+		}
+		if p.waiting >= maxPendingPrefetches {
+			dropped++
+			continue
+		}
+		p.queued[key] = true
+		p.waiting++
+		queued++
+		// The cluster-wide requests are not in the rules of a namespace
+		if i, ok := namespaces[req.Namespace]; ok {
+			p.pending[i].keys = append(p.pending[i].keys, key)
+			p.pending[i].reqs = append(p.pending[i].reqs, req)
+			continue
+		}
+		if byNamespace && req.Namespace != "" {
+			namespaces[req.Namespace] = len(p.pending)
+		}
+		p.pending = append(p.pending, prefetchCheck{ctx: ctx, keys: []string{key}, user: user, reqs: []AccessRequest{req}})
+	}
+	for p.running < DefaultPrefetchParallelism && p.running < len(p.pending) {
+		p.running++
+		go in.runPrefetches()
+	}
+	p.mu.Unlock()
+
+	if dropped > 0 {
+		log.Debugf("%sDropped %d prefetches of user %s, %d are pending", logPrefix(ctx), dropped, redactedUser(user.Name), maxPendingPrefetches)
+	}
+	if queued > 0 {
+		log.Debugf("%sPrefetching %d decisions of user %s for %s", logPrefix(ctx), queued, redactedUser(user.Name), hint.Reason)
+	}
+	return queued, nil
+}
+
+// runPrefetches resolves the pending checks into the decision cache until there is none.
+func (in *PermissionChecker) runPrefetches() {
+	p := in.prefetcher
+	for {
+		p.mu.Lock()
+		if len(p.pending) == 0 {
+			p.running--
+			p.mu.Unlock()
+			return
+		}
+		check := p.pending[0]
+		p.pending = p.pending[1:]
+		p.waiting -= len(check.reqs)
+		p.mu.Unlock()
+
+		in.prefetch(check)
+
+		p.mu.Lock()
+		for _, key := range check.keys {
+			delete(p.queued, key)
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (in *PermissionChecker) prefetch(check prefetchCheck) {
+	if !in.beginCheck() {
+		return
+	}
+	defer in.inflight.Done()
+
+	in.mu.RLock()
+	conf, cache, chain := in.conf, in.cache, in.chain
+	in.mu.RUnlock()
+	if conf.Mode == EnforcementModeDisabled {
+		return
+	}
+	reqs := check.reqs
+	// The config may have changed the authorizers since the checks were queued
+	if len(reqs) > 1 && chain.reviewsOnly() {
+		reqs = in.prefetchRules(check, conf, cache)
+	}
+	for _, req := range reqs {
+		ttl := conf.cacheTTLFor(check.user, req)
+		if _, err := in.cachedAuthorize(check.ctx, cache, ttl, chain, withoutGroups(check.user, conf.ExcludedGroups), req); err != nil {
+			log.Debugf("%sError prefetching the permissions of user %s: %v", logPrefix(check.ctx), redactedUser(check.user.Name), err)
+		}
+	}
+}
+
+// prefetchRules resolves the requests of a namespace into the decision cache with one rules review of the
+// user, and returns the requests left to review one by one: all of them if the review fails, and the ones
+// its rules do not allow if the review is incomplete, e.g. because of a webhook authorizer.
+func (in *PermissionChecker) prefetchRules(check prefetchCheck, conf *PermissionsConfig, cache DecisionCache) []AccessRequest {
+	in.generationMu.RLock()
+	generation := in.generation
+	in.generationMu.RUnlock()
+
+	user := withoutGroups(check.user, conf.ExcludedGroups)
+	namespace := check.reqs[0].Namespace
+	review, err := createUserRulesReview(check.ctx, in.client, user, namespace)
+	if err != nil {
+		log.Debugf("%sError reviewing the rules of user %s in namespace %s, prefetching its requests one by one: %v", logPrefix(check.ctx), redactedUser(check.user.Name), namespace, err)
+		return check.reqs
+	}
+
+	rules := make([]rbac_v1.PolicyRule, 0, len(review.Status.ResourceRules))
+	for _, rule := range review.Status.ResourceRules {
+		rules = append(rules, rbac_v1.PolicyRule{Verbs: rule.Verbs, APIGroups: rule.APIGroups, Resources: rule.Resources, ResourceNames: rule.ResourceNames})
+	}
+	left := []AccessRequest{}
+	for _, req := range check.reqs {
+		decision := Decision{Source: DecisionSourceAPIServer, Reason: "not allowed by the rules of the namespace", Timestamp: time.Now()}
+		for _, rule := range rules {
+			if ruleAllows(rule, req) {
+				decision.Allowed, decision.Reason = true, "allowed by the rules of the namespace"
+				break
+			}
+		}
+		// The rules of an incomplete review do not tell the denials
+		if !decision.Allowed && review.Status.Incomplete {
+			left = append(left, req)
+			continue
+		}
+		in.storeDecision(check.ctx, cache, generation, conf.cacheTTLFor(check.user, req), decisionCacheKey(user, req), decision)
+	}
+	return left
+}
+
+// PrefetchResponse is the response of the prefetch endpoint.
+type PrefetchResponse struct {
+	// Queued is the number of checks queued, see PermissionChecker.Prefetch.
+	Queued int `json:"queued"`
+}
+
+// prefetch queues the checks of the PrefetchHint of the body for the caller, and answers at once with a
+// PrefetchResponse, see WriteOutput.
+func (in *PermissionsServer) prefetch(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	caller, ok := in.callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var hint PrefetchHint
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPrefetchHintBytes)).Decode(&hint); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "prefetch hint too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "invalid prefetch hint: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	queued, err := in.checker.Prefetch(ctx, caller, hint)
+	switch {
+	case errors.Is(err, ErrCheckerShutDown):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeOutput(w, r, http.StatusAccepted, PrefetchResponse{Queued: queued}, nil)
+}
@@ -0,0 +1,217 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func workloadsHint(namespaces ...string) PrefetchHint {
+	return PrefetchHint{
+		Reason:     "workloads page",
+		Namespaces: namespaces,
+		Resources: []PrefetchResource{
+			{Resource: "pods", Verbs: []string{"get", "list"}},
+			{APIGroup: "apps", Resource: "deployments", Verbs: []string{"get", "list", "patch"}},
+		},
+	}
+}
+
+func TestPrefetchHintRequests(t *testing.T) {
+	requests := workloadsHint("ns1", "ns2").Requests()
+
+	assert.Len(t, requests, 10)
+	assert.Contains(t, requests, AccessRequest{Namespace: "ns2", APIGroup: "apps", Resource: "deployments", Verb: "patch"})
+	assert.Len(t, workloadsHint().Requests(), 5)
+}
+
+func TestPrefetchHintValidation(t *testing.T) {
+	assert.NoError(t, workloadsHint("ns1").validate())
+	assert.Error(t, PrefetchHint{}.validate())
+	assert.Error(t, PrefetchHint{Resources: []PrefetchResource{{Verbs: []string{"get"}}}}.validate())
+	assert.Error(t, PrefetchHint{Resources: []PrefetchResource{{Resource: "pods"}}}.validate())
+
+	namespaces := make([]string, 1000)
+	for i := range namespaces {
+		namespaces[i] = "ns"
+	}
+	assert.Error(t, workloadsHint(namespaces...).validate())
+	verbs := make([]string, maxPrefetchRequests+1)
+	assert.Error(t, PrefetchHint{Resources: []PrefetchResource{{Resource: "pods", Verbs: verbs}}}.validate())
+}
+
+func TestPrefetchWarmsTheCache(t *testing.T) {
+	reviews := &testReviews{allow: allowUsers("alice")}
+	checker := newTestChecker(reviews, nil)
+	alice := UserInfo{Name: "alice"}
+
+	queued, err := checker.Prefetch(testCtx, alice, workloadsHint("ns1"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, queued)
+	require.Eventually(t, func() bool { return reviews.calls.Load() == 5 }, 5*time.Second, time.Millisecond)
+
+	decision, err := checker.Check(testCtx, alice, AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "patch"})
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, DecisionSourceCache, decision.Source)
+	assert.Equal(t, int64(5), reviews.calls.Load())
+}
+
+func TestPrefetchDeduplicatesTheHints(t *testing.T) {
+	release := make(chan struct{})
+	reviews := &testReviews{allow: func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+		<-release
+		return true
+	}}
+	checker := newTestChecker(reviews, nil)
+	alice := UserInfo{Name: "alice"}
+
+	queued, err := checker.Prefetch(testCtx, alice, workloadsHint("ns1"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, queued)
+	queued, err = checker.Prefetch(testCtx, alice, workloadsHint("ns1"))
+	require.NoError(t, err)
+	assert.Zero(t, queued)
+
+	close(release)
+	prefetchesDone(t, checker)
+	assert.Equal(t, int64(5), reviews.calls.Load())
+}
+
+// rulesReviewingClient lists the same rules for every user and namespace, counting its reviews.
+type rulesReviewingClient struct {
+	PermissionsClient
+	rules      []auth_v1.ResourceRule
+	incomplete bool
+	reviews    atomic.Int64
+}
+
+func (in *rulesReviewingClient) CreateUserRulesReview(ctx context.Context, user UserInfo, namespace string) (*auth_v1.SelfSubjectRulesReview, error) {
+	in.reviews.Add(1)
+	return &auth_v1.SelfSubjectRulesReview{Status: auth_v1.SubjectRulesReviewStatus{ResourceRules: in.rules, Incomplete: in.incomplete}}, nil
+}
+
+// prefetchesDone waits for the prefetches of the checker.
+func prefetchesDone(t *testing.T, checker *PermissionChecker) {
+	require.Eventually(t, func() bool {
+		checker.prefetcher.mu.Lock()
+		defer checker.prefetcher.mu.Unlock()
+		return checker.prefetcher.running == 0
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestPrefetchReviewsTheRulesOfEachNamespace(t *testing.T) {
+	reviews := &testReviews{allow: allowUsers("alice")}
+	client := &rulesReviewingClient{PermissionsClient: newTestClient(reviews), rules: []auth_v1.ResourceRule{
+		{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+		{Verbs: []string{"get"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}},
+	}}
+	checker := NewPermissionChecker(client)
+	alice := UserInfo{Name: "alice"}
+
+	queued, err := checker.Prefetch(testCtx, alice, workloadsHint("ns1", "ns2"))
+	require.NoError(t, err)
+	assert.Equal(t, 10, queued)
+	prefetchesDone(t, checker)
+	assert.Equal(t, int64(2), client.reviews.Load())
+	assert.Zero(t, reviews.calls.Load())
+
+	decision, err := checker.Check(testCtx, alice, AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "list"})
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, DecisionSourceCache, decision.Source)
+	// The review is complete, what its rules do not allow is denied
+	decision, err = checker.Check(testCtx, alice, AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "patch"})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, DecisionSourceCache, decision.Source)
+	assert.Zero(t, reviews.calls.Load())
+}
+
+func TestPrefetchReviewsWhatAnIncompleteRulesReviewDoesNotAllow(t *testing.T) {
+	reviews := &testReviews{allow: allowUsers("alice")}
+	client := &rulesReviewingClient{PermissionsClient: newTestClient(reviews), incomplete: true, rules: []auth_v1.ResourceRule{
+		{Verbs: []string{"*"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+	}}
+	checker := NewPermissionChecker(client)
+	alice := UserInfo{Name: "alice"}
+
+	_, err := checker.Prefetch(testCtx, alice, workloadsHint("ns1"))
+	require.NoError(t, err)
+	prefetchesDone(t, checker)
+	assert.Equal(t, int64(1), client.reviews.Load())
+	// The 3 verbs of the deployments are reviewed one by one
+	assert.Equal(t, int64(3), reviews.calls.Load())
+
+	decision, err := checker.Check(testCtx, alice, AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "patch"})
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, DecisionSourceCache, decision.Source)
+}
+
+func TestPrefetchWithoutRulesReviews(t *testing.T) {
+	reviews := &testReviews{allow: allowUsers("alice")}
+	client := &rulesReviewingClient{PermissionsClient: newTestClient(reviews)}
+	checker := NewPermissionChecker(client)
+
+	// The cluster-wide requests are not in the rules of a namespace
+	_, err := checker.Prefetch(testCtx, UserInfo{Name: "alice"}, workloadsHint())
+	require.NoError(t, err)
+	prefetchesDone(t, checker)
+	assert.Zero(t, client.reviews.Load())
+	assert.Equal(t, int64(5), reviews.calls.Load())
+}
+
+func TestPrefetchWithoutCaching(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.CacheTTL = 0
+	reviews := &testReviews{}
+	checker := newTestChecker(reviews, conf)
+
+	queued, err := checker.Prefetch(testCtx, UserInfo{Name: "alice"}, workloadsHint("ns1"))
+	require.NoError(t, err)
+	assert.Zero(t, queued)
+
+	_, err = checker.Prefetch(testCtx, UserInfo{Name: "alice"}, PrefetchHint{})
+	assert.Error(t, err)
+}
+
+func TestPrefetchEndpoint(t *testing.T) {
+	server := newTestServer(&testReviews{}, nil)
+	post := func(body string, query ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/permissions/prefetch"+strings.Join(query, ""), strings.NewReader(body))
+		r.Header.Set("X-Test-User", "alice")
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	hint, err := json.Marshal(workloadsHint("ns1"))
+	require.NoError(t, err)
+	w := post(string(hint))
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var response PrefetchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 5, response.Queued)
+
+	// The response has the output formats of the other endpoints
+	w = post(string(hint), "?output=yaml")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "application/yaml", w.Header().Get("Content-Type"))
+	assert.Regexp(t, `^queued: \d+\n$`, w.Body.String())
+	assert.Equal(t, http.StatusBadRequest, post(string(hint), "?output=table").Code)
+
+	assert.Equal(t, http.StatusBadRequest, post(`{"resources": []}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(`{`).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(`{"reason": "`+strings.Repeat("x", maxPrefetchHintBytes)+`"}`).Code)
+}
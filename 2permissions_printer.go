@@ -0,0 +1,288 @@
+package business
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kiali/kiali/log"
+)
+
+// Output formats, like the -o flag of kubectl. The custom-columns format is custom-columns=HEADER:.json.path,...
+const (
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+	OutputTable = "table"
+	OutputWide  = "wide"
+
+	outputCustomColumnsPrefix = "custom-columns="
+)
+
+// OutputFormat is a parsed output format.
+type OutputFormat struct {
+	// Name is one of the Output constants, or custom-columns.
+	Name          string
+	CustomColumns []CustomColumn
+}
+
+// CustomColumn is a column of the custom-columns format, whose cells are the JSONPath of the JSON of
+// each item, e.g. .user.name.
+type CustomColumn struct {
+	Header   string
+	JSONPath string
+}
+
+// ParseOutputFormat parses an output format. The empty string means the default format.
+func ParseOutputFormat(output, defaultFormat string) (OutputFormat, error) {
+	if output == "" {
+		output = defaultFormat
+	}
+	switch output {
+	case OutputJSON, OutputYAML, OutputTable, OutputWide:
+		return OutputFormat{Name: output}, nil
+	}
+	spec, ok := strings.CutPrefix(output, outputCustomColumnsPrefix)
+	if !ok {
+		return OutputFormat{}, fmt.Errorf("unknown output format %q, expected %s, %s, %s, %s or %sHEADER:.path,...", output, OutputJSON, OutputYAML, OutputTable, OutputWide, outputCustomColumnsPrefix)
+	}
+	format := OutputFormat{Name: "custom-columns"}
+	for _, column := range strings.Split(spec, ",") {
+		header, path, ok := strings.Cut(column, ":")
+		if !ok || header == "" || path == "" {
+			return OutputFormat{}, fmt.Errorf("invalid custom column %q, expected HEADER:.json.path", column)
+		}
+		format.CustomColumns = append(format.CustomColumns, CustomColumn{Header: header, JSONPath: path})
+	}
+	return format, nil
+}
+
+// Column is a column of the table and wide formats. Wide columns are only printed in the wide format.
+type Column[T any] struct {
+	Header string
+	Wide   bool
+	Value  func(T) string
+}
+
+// Table holds the cells of the table and wide formats of a report.
+type Table struct {
+	headers []string
+	wide    []bool
+	rows    [][]string
+}
+
+// NewTable builds the table of the items with the columns.
+func NewTable[T any](items []T, columns ...Column[T]) *Table {
+	table := &Table{rows: make([][]string, 0, len(items))}
+	for _, column := range columns {
+		table.headers = append(table.headers, column.Header)
+		table.wide = append(table.wide, column.Wide)
+	}
+	for _, item := range items {
+		row := make([]string, 0, len(columns))
+		for _, column := range columns {
+			row = append(row, column.Value(item))
+		}
+		table.rows = append(table.rows, row)
+	}
+	return table
+}
+
+// PrintOutput writes the report in the format: the value for json, yaml and custom-columns, where each
+// item of a slice value is a row, and the table for table and wide. A nil table means the report has no
+// table format.
+func PrintOutput(w io.Writer, format OutputFormat, value interface{}, table *Table) error {
+	switch format.Name {
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case OutputYAML:
+		out, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+	case OutputTable, OutputWide:
+		if table == nil {
+			return fmt.Errorf("the %s output format is not supported by this report", format.Name)
+		}
+		return printTable(w, table, format.Name == OutputWide)
+	default:
+		return printCustomColumns(w, format.CustomColumns, value)
+	}
+}
+
+// WriteOutput writes the report in the format of the output query parameter of the request, JSON by
+// default, so the HTTP endpoints have the same output options as the CLI. Invalid formats get a 400.
+func WriteOutput(w http.ResponseWriter, r *http.Request, value interface{}, table *Table) {
+	writeOutput(w, r, http.StatusOK, value, table)
+}
+
+// writeOutput is WriteOutput with the status of the successful responses, e.g. 201 for the creations.
+func writeOutput(w http.ResponseWriter, r *http.Request, status int, value interface{}, table *Table) {
+	format, err := ParseOutputFormat(r.URL.Query().Get("output"), OutputJSON)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (format.Name == OutputTable || format.Name == OutputWide) && table == nil {
+		http.Error(w, "the "+format.Name+" output format is not supported by this endpoint", http.StatusBadRequest)
+		return
+	}
+	switch format.Name {
+	case OutputJSON:
+		w.Header().Set("Content-Type", ContentTypeJSON)
+	case OutputYAML:
+		w.Header().Set("Content-Type", "application/yaml")
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	// Custom columns can fail half-way, so they are rendered before writing anything
+	var out bytes.Buffer
+	if err := PrintOutput(&out, format, value, table); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(status)
+	if _, err := w.Write(out.Bytes()); err != nil {
+		log.Errorf("Error writing the %s output: %v", format.Name, err)
+	}
+}
+
+func printTable(w io.Writer, table *Table, wide bool) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	printRow := func(cells []string) {
+		kept := make([]string, 0, len(cells))
+		for i, cell := range cells {
+			if wide || !table.wide[i] {
+				kept = append(kept, cell)
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(kept, "\t"))
+	}
+	printRow(table.headers)
+	for _, row := range table.rows {
+		printRow(row)
+	}
+	return tw.Flush()
+}
+
+func printCustomColumns(w io.Writer, columns []CustomColumn, value interface{}) error {
+	paths := make([]*jsonpath.JSONPath, 0, len(columns))
+	headers := make([]string, 0, len(columns))
+	for _, column := range columns {
+		path := jsonpath.New(column.Header).AllowMissingKeys(true)
+		if err := path.Parse("{" + column.JSONPath + "}"); err != nil {
+			return fmt.Errorf("invalid JSONPath of column %s: %w", column.Header, err)
+		}
+		paths = append(paths, path)
+		headers = append(headers, column.Header)
+	}
+
+	items := []interface{}{value}
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Slice {
+		items = make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, v.Index(i).Interface())
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, item := range items {
+		// JSONPath is evaluated on the JSON form, so the paths use the JSON field names
+		content, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		var data interface{}
+		if err := json.Unmarshal(content, &data); err != nil {
+			return err
+		}
+		cells := make([]string, 0, len(paths))
+		for _, path := range paths {
+			var cell bytes.Buffer
+			if err := path.Execute(&cell, data); err != nil {
+				return err
+			}
+			if cell.Len() == 0 {
+				cell.WriteString("<none>")
+			}
+			cells = append(cells, cell.String())
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// AccessRequestColumns are the columns of the permission lists.
+var AccessRequestColumns = []Column[AccessRequest]{
+	{Header: "NAMESPACE", Value: func(in AccessRequest) string { return in.Namespace }},
+	{Header: "RESOURCE", Value: func(in AccessRequest) string { return groupResource(in.APIGroup, in.Resource) }},
+	{Header: "SUBRESOURCE", Wide: true, Value: func(in AccessRequest) string { return in.Subresource }},
+	{Header: "NAME", Wide: true, Value: func(in AccessRequest) string { return in.Name }},
+	{Header: "VERB", Value: func(in AccessRequest) string { return in.Verb }},
+}
+
+// SubjectColumns are the columns of the subject lists, e.g. of WhoCan.
+var SubjectColumns = []Column[rbac_v1.Subject]{
+	{Header: "KIND", Value: func(in rbac_v1.Subject) string { return in.Kind }},
+	{Header: "NAME", Value: func(in rbac_v1.Subject) string { return in.Name }},
+	{Header: "NAMESPACE", Value: func(in rbac_v1.Subject) string { return in.Namespace }},
+}
+
+// PermissionPathColumns are the columns of the explanations.
+var PermissionPathColumns = []Column[PermissionPath]{
+	{Header: "BINDING", Value: func(in PermissionPath) string { return in.BindingKind + "/" + in.BindingName }},
+	{Header: "NAMESPACE", Value: func(in PermissionPath) string { return in.Namespace }},
+	{Header: "ROLE", Value: func(in PermissionPath) string { return in.RoleRef.Kind + "/" + in.RoleRef.Name }},
+	{Header: "SUBJECT", Value: func(in PermissionPath) string { return in.Subject.Kind + "/" + in.Subject.Name }},
+	{Header: "VERBS", Wide: true, Value: func(in PermissionPath) string { return strings.Join(in.Rule.Verbs, ",") }},
+	{Header: "RESOURCES", Wide: true, Value: func(in PermissionPath) string { return strings.Join(in.Rule.Resources, ",") }},
+}
+
+// accessFeedRow is a row of the table of the access feed, one per team, namespace and resource.
+type accessFeedRow struct {
+	team      string
+	namespace string
+	roles     []string
+	resource  ResourceAccess
+}
+
+// AccessFeedTable returns the table of the access feed, with a row per team, namespace and resource.
+func AccessFeedTable(feed *AccessFeed) *Table {
+	rows := []accessFeedRow{}
+	for _, team := range feed.Teams {
+		for _, namespace := range team.Namespaces {
+			for _, resource := range namespace.Resources {
+				rows = append(rows, accessFeedRow{team: team.Team, namespace: namespace.Namespace, roles: namespace.Roles, resource: resource})
+			}
+		}
+	}
+	return NewTable(rows,
+		Column[accessFeedRow]{Header: "TEAM", Value: func(in accessFeedRow) string { return in.team }},
+		Column[accessFeedRow]{Header: "NAMESPACE", Value: func(in accessFeedRow) string { return in.namespace }},
+		Column[accessFeedRow]{Header: "RESOURCE", Value: func(in accessFeedRow) string { return groupResource(in.resource.APIGroup, in.resource.Resource) }},
+		Column[accessFeedRow]{Header: "VERBS", Value: func(in accessFeedRow) string { return strings.Join(in.resource.Verbs, ",") }},
+		Column[accessFeedRow]{Header: "NAMES", Wide: true, Value: func(in accessFeedRow) string { return strings.Join(in.resource.ResourceNames, ",") }},
+		Column[accessFeedRow]{Header: "ROLES", Wide: true, Value: func(in accessFeedRow) string { return strings.Join(in.roles, ",") }},
+	)
+}
+
+// groupResource returns the resource in the resource.group form of kubectl.
+func groupResource(apiGroup, resource string) string {
+	if apiGroup == "" {
+		return resource
+	}
+	return resource + "." + apiGroup
+}
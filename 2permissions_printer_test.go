@@ -0,0 +1,114 @@
+package business
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOutputFormat(t *testing.T) {
+	format, err := ParseOutputFormat("", OutputTable)
+	require.NoError(t, err)
+	assert.Equal(t, OutputFormat{Name: OutputTable}, format)
+
+	format, err = ParseOutputFormat("yaml", OutputTable)
+	require.NoError(t, err)
+	assert.Equal(t, OutputFormat{Name: OutputYAML}, format)
+
+	format, err = ParseOutputFormat("custom-columns=USER:.name,VERB:.verb", OutputTable)
+	require.NoError(t, err)
+	assert.Equal(t, OutputFormat{Name: "custom-columns", CustomColumns: []CustomColumn{{Header: "USER", JSONPath: ".name"}, {Header: "VERB", JSONPath: ".verb"}}}, format)
+
+	for _, invalid := range []string{"xml", "custom-columns=", "custom-columns=USER", "custom-columns=:.name", "custom-columns=USER:"} {
+		_, err := ParseOutputFormat(invalid, OutputTable)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func testPrinted(t *testing.T, output string, value interface{}, table *Table) string {
+	format, err := ParseOutputFormat(output, OutputTable)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	require.NoError(t, PrintOutput(&out, format, value, table))
+	return out.String()
+}
+
+func TestPrintOutputTables(t *testing.T) {
+	requests := []AccessRequest{
+		{Namespace: "ns1", Resource: "pods", Subresource: "log", Name: "web", Verb: "get"},
+		{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "list"},
+	}
+	table := NewTable(requests, AccessRequestColumns...)
+
+	// Wide columns are only in the wide format
+	lines := strings.Split(strings.TrimSpace(testPrinted(t, OutputTable, requests, table)), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"NAMESPACE", "RESOURCE", "VERB"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"ns1", "pods", "get"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"ns1", "deployments.apps", "list"}, strings.Fields(lines[2]))
+
+	lines = strings.Split(strings.TrimSpace(testPrinted(t, OutputWide, requests, table)), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"NAMESPACE", "RESOURCE", "SUBRESOURCE", "NAME", "VERB"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"ns1", "pods", "log", "web", "get"}, strings.Fields(lines[1]))
+
+	format, err := ParseOutputFormat(OutputTable, OutputJSON)
+	require.NoError(t, err)
+	assert.Error(t, PrintOutput(&bytes.Buffer{}, format, requests, nil))
+}
+
+func TestPrintOutputValues(t *testing.T) {
+	subjects := []rbac_v1.Subject{testUser("alice"), testGroup("developers")}
+
+	assert.JSONEq(t, `[{"kind":"User","apiGroup":"rbac.authorization.k8s.io","name":"alice"},{"kind":"Group","apiGroup":"rbac.authorization.k8s.io","name":"developers"}]`, testPrinted(t, OutputJSON, subjects, nil))
+	assert.Contains(t, testPrinted(t, OutputYAML, subjects, nil), "name: developers\n")
+
+	// Each item of a slice is a row, and missing values are <none>
+	lines := strings.Split(strings.TrimSpace(testPrinted(t, "custom-columns=NAME:.name,NAMESPACE:.namespace", subjects, nil)), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"NAME", "NAMESPACE"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"alice", "<none>"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"developers", "<none>"}, strings.Fields(lines[2]))
+
+	lines = strings.Split(strings.TrimSpace(testPrinted(t, "custom-columns=NAME:.name", subjects[0], nil)), "\n")
+	assert.Equal(t, []string{"NAME", "alice"}, lines)
+
+	format, err := ParseOutputFormat("custom-columns=NAME:.name[", OutputTable)
+	require.NoError(t, err)
+	assert.Error(t, PrintOutput(&bytes.Buffer{}, format, subjects, nil))
+}
+
+func TestWriteOutput(t *testing.T) {
+	subjects := []rbac_v1.Subject{testUser("alice")}
+	table := NewTable(subjects, SubjectColumns...)
+	write := func(query string, table *Table) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		WriteOutput(rec, httptest.NewRequest(http.MethodGet, "/who-can"+query, nil), subjects, table)
+		return rec
+	}
+
+	// JSON by default
+	rec := write("", table)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, ContentTypeJSON, rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), `"name": "alice"`)
+
+	rec = write("?output=yaml", table)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/yaml", rec.Header().Get("Content-Type"))
+
+	rec = write("?output=table", table)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Body.String(), "KIND"), rec.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, write("?output=xml", table).Code)
+	assert.Equal(t, http.StatusBadRequest, write("?output=wide", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, write("?output=custom-columns=NAME:.name[", table).Code)
+}
@@ -0,0 +1,62 @@
+package business
+
+import (
+	"context"
+	"fmt"
+)
+
+// Priority classes the checks and the operations making apiserver calls, so under load the background ones
+// yield to the interactive ones, see WithPriority.
+type Priority string
+
+const (
+	// PriorityInteractive is the priority of the checks a user is waiting for, the default.
+	PriorityInteractive Priority = "interactive"
+	// PriorityBackground is the priority of the warm-ups, the matrix recomputations and the reports.
+	PriorityBackground Priority = "background"
+)
+
+// priorities are the priority classes, the highest first.
+var priorities = []Priority{PriorityInteractive, PriorityBackground}
+
+// priorityWeights are the shares of the apiserver request slots granted to each priority when all of them
+// are waiting: 4 interactive requests for each background one.
+var priorityWeights = map[Priority]int{
+	PriorityInteractive: 4,
+	PriorityBackground:  1,
+}
+
+// ParsePriority parses the name of a priority class.
+func ParsePriority(name string) (Priority, error) {
+	for _, priority := range priorities {
+		if string(priority) == name {
+			return priority, nil
+		}
+	}
+	return "", fmt.Errorf("unknown priority %q, expected %s or %s", name, PriorityInteractive, PriorityBackground)
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose apiserver calls are scheduled with the priority, against the
+// APIRequestLimiter of the client. Unknown priorities are interactive.
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFromContext returns the priority set by WithPriority, or PriorityInteractive if none or an
+// unknown one is set.
+func PriorityFromContext(ctx context.Context) Priority {
+	if priority, ok := ctx.Value(priorityKey{}).(Priority); ok && priorityWeights[priority] > 0 {
+		return priority
+	}
+	return PriorityInteractive
+}
+
+// withDefaultPriority returns a context with the priority, unless the context has one.
+func withDefaultPriority(ctx context.Context, priority Priority) context.Context {
+	if _, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return ctx
+	}
+	return WithPriority(ctx, priority)
+}
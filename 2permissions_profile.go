@@ -0,0 +1,131 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kube "k8s.io/client-go/kubernetes"
+)
+
+// PermissionProfileAPIVersion identifies the schema of the permission profiles. It must be bumped on any
+// incompatible change, and ApplyPermissionProfile must keep accepting the older versions.
+const PermissionProfileAPIVersion = "kiali.io/permission-profile/v1"
+
+// PermissionProfile captures the intended permissions of a subject in a cluster-agnostic form: only the
+// rules per namespace are kept, not the names of the roles and bindings granting them. It is used to
+// migrate teams between clusters.
+type PermissionProfile struct {
+	APIVersion string    `json:"apiVersion" yaml:"apiVersion"`
+	Subject    string    `json:"subject" yaml:"subject"`
+	ExportedAt time.Time `json:"exportedAt" yaml:"exportedAt"`
+	// Namespaces holds the rules of each namespace. The empty namespace holds the cluster-wide rules.
+	Namespaces []ProfileNamespace `json:"namespaces" yaml:"namespaces"`
+}
+
+// ProfileNamespace is the set of rules of a profile in a namespace.
+type ProfileNamespace struct {
+	Namespace string               `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Rules     []rbac_v1.PolicyRule `json:"rules" yaml:"rules"`
+}
+
+// ApplyProfileOptions tunes ApplyPermissionProfile.
+type ApplyProfileOptions struct {
+	// NamespaceMapping renames the namespaces of the profile on the target cluster. Namespaces absent
+	// from the mapping keep their name.
+	NamespaceMapping map[string]string
+	// DryRun only returns the objects, without applying them.
+	DryRun bool
+}
+
+// ExportPermissionProfile captures the effective permissions of the user in the snapshot, whatever the
+// bindings (user, group or ServiceAccount) granting them, as minimal rules per namespace.
+func ExportPermissionProfile(snapshot *RBACSnapshot, user UserInfo) *PermissionProfile {
+	byNamespace := map[string][]AccessRequest{}
+	for p := range snapshot.EffectivePermissions(user) {
+		byNamespace[p.Namespace] = append(byNamespace[p.Namespace], p)
+	}
+
+	profile := &PermissionProfile{
+		APIVersion: PermissionProfileAPIVersion,
+		Subject:    user.Name,
+		ExportedAt: time.Now(),
+		Namespaces: make([]ProfileNamespace, 0, len(byNamespace)),
+	}
+	for ns, requirements := range byNamespace {
+		profile.Namespaces = append(profile.Namespaces, ProfileNamespace{Namespace: ns, Rules: SynthesizeRole(requirements)})
+	}
+	sort.Slice(profile.Namespaces, func(i, j int) bool {
+		return profile.Namespaces[i].Namespace < profile.Namespaces[j].Namespace
+	})
+	return profile
+}
+
+// ApplyPermissionProfile generates the roles and bindings granting the profile to its subject, a Role and
+// RoleBinding per namespace plus a ClusterRole and ClusterRoleBinding for the cluster-wide rules, and applies
+// them to the target cluster unless opts.DryRun is set. The generated objects are returned, e.g. to be
+// rendered with RenderManifests. The namespaces must exist on the target cluster.
+func ApplyPermissionProfile(ctx context.Context, k8s kube.Interface, profile *PermissionProfile, opts ApplyProfileOptions) ([]runtime.Object, error) {
+	if profile.APIVersion != PermissionProfileAPIVersion {
+		return nil, fmt.Errorf("unsupported permission profile version %q", profile.APIVersion)
+	}
+	if profile.Subject == "" {
+		return nil, fmt.Errorf("the permission profile has no subject")
+	}
+
+	name := manifestName("profile", profile.Subject)
+	subjects := []rbac_v1.Subject{subjectForUser(profile.Subject)}
+	objects := []runtime.Object{}
+	for _, pn := range profile.Namespaces {
+		ns := pn.Namespace
+		if mapped, ok := opts.NamespaceMapping[ns]; ok && ns != "" {
+			ns = mapped
+		}
+		objects = append(objects, buildRoleManifests(name, ns, pn.Rules, subjects)...)
+	}
+
+	if opts.DryRun {
+		return objects, nil
+	}
+	if err := applyRBACObjects(ctx, k8s, objects); err != nil {
+		return objects, fmt.Errorf("error applying permission profile of %s: %w", profile.Subject, err)
+	}
+	return objects, nil
+}
+
+// applyRBACObjects creates the RBAC objects, or updates them if they already exist, so a profile can be
+// applied again. Only ClusterRoles, Roles and their bindings are supported.
+func applyRBACObjects(ctx context.Context, k8s kube.Interface, objects []runtime.Object) error {
+	for _, obj := range objects {
+		var err error
+		switch o := obj.(type) {
+		case *rbac_v1.ClusterRole:
+			if _, err = k8s.RbacV1().ClusterRoles().Create(ctx, o, meta_v1.CreateOptions{}); errors.IsAlreadyExists(err) {
+				_, err = k8s.RbacV1().ClusterRoles().Update(ctx, o, meta_v1.UpdateOptions{})
+			}
+		case *rbac_v1.Role:
+			if _, err = k8s.RbacV1().Roles(o.Namespace).Create(ctx, o, meta_v1.CreateOptions{}); errors.IsAlreadyExists(err) {
+				_, err = k8s.RbacV1().Roles(o.Namespace).Update(ctx, o, meta_v1.UpdateOptions{})
+			}
+		case *rbac_v1.ClusterRoleBinding:
+			if _, err = k8s.RbacV1().ClusterRoleBindings().Create(ctx, o, meta_v1.CreateOptions{}); errors.IsAlreadyExists(err) {
+				_, err = k8s.RbacV1().ClusterRoleBindings().Update(ctx, o, meta_v1.UpdateOptions{})
+			}
+		case *rbac_v1.RoleBinding:
+			if _, err = k8s.RbacV1().RoleBindings(o.Namespace).Create(ctx, o, meta_v1.CreateOptions{}); errors.IsAlreadyExists(err) {
+				_, err = k8s.RbacV1().RoleBindings(o.Namespace).Update(ctx, o, meta_v1.UpdateOptions{})
+			}
+		default:
+			return fmt.Errorf("unsupported RBAC object %T", obj)
+		}
+		if err != nil {
+			return fmt.Errorf("error applying RBAC object: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package business
+
+import (
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_fake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportPermissionProfile(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+
+	profile := ExportPermissionProfile(snapshot, UserInfo{Name: "carol", Groups: []string{"developers"}})
+	assert.Equal(t, PermissionProfileAPIVersion, profile.APIVersion)
+	assert.Equal(t, "carol", profile.Subject)
+	assert.False(t, profile.ExportedAt.IsZero())
+	require.Len(t, profile.Namespaces, 1)
+	assert.Equal(t, "ns1", profile.Namespaces[0].Namespace)
+	assert.Equal(t, []rbac_v1.PolicyRule{{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"get", "list", "patch", "update"}}}, profile.Namespaces[0].Rules)
+
+	// Cluster-wide rules are in the empty namespace
+	profile = ExportPermissionProfile(snapshot, UserInfo{Name: "bob"})
+	require.Len(t, profile.Namespaces, 1)
+	assert.Empty(t, profile.Namespaces[0].Namespace)
+}
+
+func TestApplyPermissionProfile(t *testing.T) {
+	source := testSnapshot(podReaderObjects()...)
+	profile := ExportPermissionProfile(source, UserInfo{Name: "alice"})
+	k8s := kube_fake.NewSimpleClientset()
+
+	objects, err := ApplyPermissionProfile(testCtx, k8s, profile, ApplyProfileOptions{NamespaceMapping: map[string]string{"ns1": "team-a"}})
+	require.NoError(t, err)
+	assert.Len(t, objects, 2)
+	// Applying again updates the objects
+	_, err = ApplyPermissionProfile(testCtx, k8s, profile, ApplyProfileOptions{NamespaceMapping: map[string]string{"ns1": "team-a"}})
+	require.NoError(t, err)
+
+	target, err := LoadRBACSnapshot(testCtx, NewPermissionsClient(k8s))
+	require.NoError(t, err)
+	alice := UserInfo{Name: "alice"}
+	assert.True(t, target.Explain(alice, AccessRequest{Namespace: "team-a", Resource: "pods", Verb: "watch"}).Allowed)
+	assert.False(t, target.Explain(alice, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}).Allowed)
+}
+
+func TestApplyPermissionProfileDryRun(t *testing.T) {
+	profile := ExportPermissionProfile(testSnapshot(podReaderObjects()...), UserInfo{Name: "bob"})
+	k8s := kube_fake.NewSimpleClientset()
+
+	objects, err := ApplyPermissionProfile(testCtx, k8s, profile, ApplyProfileOptions{DryRun: true})
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+	assert.IsType(t, &rbac_v1.ClusterRole{}, objects[0])
+	assert.IsType(t, &rbac_v1.ClusterRoleBinding{}, objects[1])
+
+	clusterRoles, err := k8s.RbacV1().ClusterRoles().List(testCtx, meta_v1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, clusterRoles.Items)
+}
+
+func TestApplyPermissionProfileErrors(t *testing.T) {
+	k8s := kube_fake.NewSimpleClientset()
+
+	_, err := ApplyPermissionProfile(testCtx, k8s, &PermissionProfile{APIVersion: "kiali.io/permission-profile/v0", Subject: "alice"}, ApplyProfileOptions{})
+	assert.ErrorContains(t, err, `unsupported permission profile version "kiali.io/permission-profile/v0"`)
+	_, err = ApplyPermissionProfile(testCtx, k8s, &PermissionProfile{APIVersion: PermissionProfileAPIVersion}, ApplyProfileOptions{})
+	assert.ErrorContains(t, err, "the permission profile has no subject")
+}
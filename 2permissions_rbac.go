@@ -0,0 +1,179 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// RBACSnapshot is a point-in-time copy of the RBAC objects of a cluster. It is the input
+// of the helpers that evaluate or report on permissions without asking the apiserver.
+type RBACSnapshot struct {
+	// ClusterRoles is keyed by name.
+	ClusterRoles map[string]*rbac_v1.ClusterRole
+	// Roles is keyed by namespace/name.
+	Roles               map[string]*rbac_v1.Role
+	ClusterRoleBindings []*rbac_v1.ClusterRoleBinding
+	RoleBindings        []*rbac_v1.RoleBinding
+	// LoadedAt is the time when the objects were read from the cluster.
+	LoadedAt time.Time
+}
+
+// RoleGrant is a role granted to a subject through a binding. Namespace is empty for
+// grants made by a ClusterRoleBinding, which apply cluster-wide.
+type RoleGrant struct {
+	BindingKind string
+	BindingName string
+	Namespace   string
+	RoleRef     rbac_v1.RoleRef
+	Subject     rbac_v1.Subject
+	Rules       []rbac_v1.PolicyRule
+}
+
+// LoadRBACSnapshot reads all the Roles, ClusterRoles and their bindings of the cluster. With the FeatureHNC
+// feature gate, it also reads the namespaces, and propagates the Roles and RoleBindings down their
+// hierarchy, see RBACSnapshot.WithHierarchy.
+func LoadRBACSnapshot(ctx context.Context, client PermissionsClient) (*RBACSnapshot, error) {
+	clusterRoles, err := client.ListClusterRoles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ClusterRoles: %w", err)
+	}
+	roles, err := client.ListRoles(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Roles: %w", err)
+	}
+	crbs, err := client.ListClusterRoleBindings(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ClusterRoleBindings: %w", err)
+	}
+	rbs, err := client.ListRoleBindings(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list RoleBindings: %w", err)
+	}
+
+	snapshot := NewRBACSnapshot(clusterRoles, roles, crbs, rbs)
+	if FeatureEnabled(FeatureHNC) {
+		namespaces, err := listClientNamespaces(ctx, client)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Namespaces: %w", err)
+		}
+		snapshot = snapshot.WithHierarchy(NewNamespaceHierarchy(namespaces))
+	}
+	return snapshot, nil
+}
+
+// NewRBACSnapshot builds a snapshot from already fetched RBAC objects.
+func NewRBACSnapshot(clusterRoles []rbac_v1.ClusterRole, roles []rbac_v1.Role, crbs []rbac_v1.ClusterRoleBinding, rbs []rbac_v1.RoleBinding) *RBACSnapshot {
+	snapshot := &RBACSnapshot{
+		ClusterRoles:        make(map[string]*rbac_v1.ClusterRole, len(clusterRoles)),
+		Roles:               make(map[string]*rbac_v1.Role, len(roles)),
+		ClusterRoleBindings: make([]*rbac_v1.ClusterRoleBinding, 0, len(crbs)),
+		RoleBindings:        make([]*rbac_v1.RoleBinding, 0, len(rbs)),
+		LoadedAt:            time.Now(),
+	}
+	for i := range clusterRoles {
+		snapshot.ClusterRoles[clusterRoles[i].Name] = &clusterRoles[i]
+	}
+	for i := range roles {
+		snapshot.Roles[roles[i].Namespace+"/"+roles[i].Name] = &roles[i]
+	}
+	for i := range crbs {
+		snapshot.ClusterRoleBindings = append(snapshot.ClusterRoleBindings, &crbs[i])
+	}
+	for i := range rbs {
+		snapshot.RoleBindings = append(snapshot.RoleBindings, &rbs[i])
+	}
+	return snapshot
+}
+
+// roleRules returns the rules of the role referenced by a binding living in the given namespace.
+// The second value is false if the role does not exist.
+func (in *RBACSnapshot) roleRules(roleRef rbac_v1.RoleRef, namespace string) ([]rbac_v1.PolicyRule, bool) {
+	switch roleRef.Kind {
+	case "ClusterRole":
+		if cr, ok := in.ClusterRoles[roleRef.Name]; ok {
+			return cr.Rules, true
+		}
+	case "Role":
+		if r, ok := in.Roles[namespace+"/"+roleRef.Name]; ok {
+			return r.Rules, true
+		}
+	}
+	return nil, false
+}
+
+// Objects returns the objects of the snapshot, e.g. to export them: the ClusterRoles and Roles sorted by
+// name, then the bindings.
+func (in *RBACSnapshot) Objects() []runtime.Object {
+	objects := make([]runtime.Object, 0, len(in.ClusterRoles)+len(in.Roles)+len(in.ClusterRoleBindings)+len(in.RoleBindings))
+	for _, key := range sortedMapKeys(in.ClusterRoles) {
+		objects = append(objects, in.ClusterRoles[key])
+	}
+	for _, key := range sortedMapKeys(in.Roles) {
+		objects = append(objects, in.Roles[key])
+	}
+	for _, crb := range in.ClusterRoleBindings {
+		objects = append(objects, crb)
+	}
+	for _, rb := range in.RoleBindings {
+		objects = append(objects, rb)
+	}
+	return objects
+}
+
+func sortedMapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Grants resolves every binding of the snapshot into one RoleGrant per subject.
+// Bindings referencing roles that do not exist are skipped.
+func (in *RBACSnapshot) Grants() []RoleGrant {
+	grants := []RoleGrant{}
+	for grant := range in.AllGrants() {
+		grants = append(grants, grant)
+	}
+	return grants
+}
+
+// GrantMode selects the grants resolved: the cluster-wide ones, made by ClusterRoleBindings, the
+// namespaced ones, made by RoleBindings, or both. UIs often render them separately.
+type GrantMode string
+
+const (
+	GrantModeAll        GrantMode = "all"
+	GrantModeCluster    GrantMode = "cluster"
+	GrantModeNamespaced GrantMode = "namespaced"
+)
+
+// ParseGrantMode validates a grant mode. The empty string means GrantModeAll.
+func ParseGrantMode(mode string) (GrantMode, error) {
+	switch GrantMode(mode) {
+	case "", GrantModeAll:
+		return GrantModeAll, nil
+	case GrantModeCluster, GrantModeNamespaced:
+		return GrantMode(mode), nil
+	default:
+		return "", fmt.Errorf("unknown grant mode %q, expected %s, %s or %s", mode, GrantModeAll, GrantModeCluster, GrantModeNamespaced)
+	}
+}
+
+// WithGrantMode returns a snapshot holding only the bindings of the mode. The roles are kept.
+func (in *RBACSnapshot) WithGrantMode(mode GrantMode) *RBACSnapshot {
+	filtered := *in
+	switch mode {
+	case GrantModeCluster:
+		filtered.RoleBindings = []*rbac_v1.RoleBinding{}
+	case GrantModeNamespaced:
+		filtered.ClusterRoleBindings = []*rbac_v1.ClusterRoleBinding{}
+	}
+	return &filtered
+}
@@ -0,0 +1,40 @@
+package business
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGrantMode(t *testing.T) {
+	for mode, expected := range map[string]GrantMode{"": GrantModeAll, "all": GrantModeAll, "cluster": GrantModeCluster, "namespaced": GrantModeNamespaced} {
+		parsed, err := ParseGrantMode(mode)
+		require.NoError(t, err)
+		assert.Equal(t, expected, parsed)
+	}
+
+	_, err := ParseGrantMode("Cluster")
+	assert.ErrorContains(t, err, `unknown grant mode "Cluster"`)
+}
+
+func TestWithGrantMode(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+
+	cluster := snapshot.WithGrantMode(GrantModeCluster)
+	assert.Empty(t, cluster.RoleBindings)
+	assert.Len(t, cluster.ClusterRoleBindings, 1)
+	assert.Empty(t, cluster.EffectivePermissions(UserInfo{Name: "alice"}))
+	assert.Len(t, cluster.EffectivePermissions(UserInfo{Name: "bob"}), 3)
+
+	namespaced := snapshot.WithGrantMode(GrantModeNamespaced)
+	assert.Empty(t, namespaced.ClusterRoleBindings)
+	assert.Len(t, namespaced.RoleBindings, 2)
+	assert.Empty(t, namespaced.EffectivePermissions(UserInfo{Name: "bob"}))
+	// The roles of the cluster-wide grants are kept for the namespaced ones
+	assert.Len(t, namespaced.EffectivePermissions(UserInfo{Name: "alice"}), 3)
+
+	assert.Equal(t, snapshot.Grants(), snapshot.WithGrantMode(GrantModeAll).Grants())
+	// The snapshot is not modified
+	assert.Len(t, snapshot.Grants(), 3)
+}
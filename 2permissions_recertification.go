@@ -0,0 +1,361 @@
+package business
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ReviewCampaignAPIVersion identifies the schema of the review campaigns, which are stored between the
+// generation of the packets and the collection of the decisions.
+const ReviewCampaignAPIVersion = "kiali.io/review-campaign/v1"
+
+// Reviewer decisions
+const (
+	ReviewDecisionPending = ""
+	ReviewDecisionKeep    = "keep"
+	ReviewDecisionRevoke  = "revoke"
+)
+
+// ReviewCampaign is an access recertification campaign: the access of every team at a point in time,
+// bundled in packets per team and namespace, and the decisions of the reviewers.
+type ReviewCampaign struct {
+	APIVersion string         `json:"apiVersion"`
+	Name       string         `json:"name"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	Packets    []ReviewPacket `json:"packets"`
+}
+
+// ReviewPacket holds the access of a team in a namespace, "*" for the cluster-wide access. The team is
+// the subject of the bindings: a group, a user or a ServiceAccount.
+type ReviewPacket struct {
+	Team string `json:"team"`
+	// TeamKind is the kind of the subject, so e.g. a user and a group of the same name have their own packets.
+	TeamKind string `json:"teamKind"`
+	// TeamNamespace is the namespace of a ServiceAccount.
+	TeamNamespace string       `json:"teamNamespace,omitempty"`
+	Namespace     string       `json:"namespace"`
+	Items         []ReviewItem `json:"items"`
+}
+
+// teamID identifies the team of the packet, e.g. Group/developers or ServiceAccount/ci/deployer.
+func (in ReviewPacket) teamID() string {
+	if in.TeamNamespace != "" {
+		return in.TeamKind + "/" + in.TeamNamespace + "/" + in.Team
+	}
+	return in.TeamKind + "/" + in.Team
+}
+
+// ReviewItem is a role granted to a subject by a binding, to be kept or revoked.
+type ReviewItem struct {
+	// ID identifies the item in the campaign.
+	ID        string           `json:"id"`
+	Binding   RBACObjectRef    `json:"binding"`
+	Subject   rbac_v1.Subject  `json:"subject"`
+	RoleRef   rbac_v1.RoleRef  `json:"roleRef"`
+	Resources []ResourceAccess `json:"resources"`
+	Decision  string           `json:"decision,omitempty"`
+	Reviewer  string           `json:"reviewer,omitempty"`
+	Comment   string           `json:"comment,omitempty"`
+	DecidedAt *time.Time       `json:"decidedAt,omitempty"`
+}
+
+// RevocationPlan holds the changes revoking the access of the approved revocations: the bindings
+// updated without the revoked subjects, to be applied, and the bindings without subjects left, to be
+// deleted.
+type RevocationPlan struct {
+	Updated []runtime.Object
+	Deleted []RBACObjectRef
+}
+
+// NewReviewCampaign bundles the current access of the snapshot into review packets, sorted by team, kind of
+// team and namespace.
+func NewReviewCampaign(name string, snapshot *RBACSnapshot) *ReviewCampaign {
+	packets := map[[2]string]*ReviewPacket{}
+	for grant := range snapshot.AllGrants() {
+		namespace := grant.Namespace
+		if namespace == "" {
+			namespace = "*"
+		}
+		team := ReviewPacket{Team: grant.Subject.Name, TeamKind: grant.Subject.Kind, Namespace: namespace, Items: []ReviewItem{}}
+		if grant.Subject.Kind == rbac_v1.ServiceAccountKind {
+			team.TeamNamespace = grant.Subject.Namespace
+		}
+		// Keyed by the kind, namespace and name of the subject: the default ServiceAccounts of the
+		// namespaces, or a user and a group of the same name, are different teams
+		key := [2]string{team.teamID(), namespace}
+		packet, ok := packets[key]
+		if !ok {
+			packet = &team
+			packets[key] = packet
+		}
+		binding := RBACObjectRef{Kind: grant.BindingKind, Namespace: grant.Namespace, Name: grant.BindingName}
+		packet.Items = append(packet.Items, ReviewItem{
+			ID:        reviewItemID(binding, grant.Subject),
+			Binding:   binding,
+			Subject:   grant.Subject,
+			RoleRef:   grant.RoleRef,
+			Resources: ruleResourceAccess(grant.Rules),
+		})
+	}
+
+	campaign := &ReviewCampaign{
+		APIVersion: ReviewCampaignAPIVersion,
+		Name:       name,
+		CreatedAt:  snapshot.LoadedAt.UTC(),
+		Packets:    make([]ReviewPacket, 0, len(packets)),
+	}
+	for _, packet := range packets {
+		sort.Slice(packet.Items, func(i, j int) bool { return packet.Items[i].ID < packet.Items[j].ID })
+		campaign.Packets = append(campaign.Packets, *packet)
+	}
+	sort.Slice(campaign.Packets, func(i, j int) bool {
+		a, b := campaign.Packets[i], campaign.Packets[j]
+		if a.Team != b.Team {
+			return a.Team < b.Team
+		}
+		if a.teamID() != b.teamID() {
+			return a.teamID() < b.teamID()
+		}
+		return a.Namespace < b.Namespace
+	})
+	return campaign
+}
+
+// reviewItemID identifies a subject of a binding, e.g. RoleBinding/ns1/dev-access/Group/developers.
+func reviewItemID(binding RBACObjectRef, subject rbac_v1.Subject) string {
+	parts := []string{binding.Kind, binding.Namespace, binding.Name, subject.Kind}
+	if subject.Kind == rbac_v1.ServiceAccountKind && subject.Namespace != "" {
+		parts = append(parts, subject.Namespace)
+	}
+	return strings.Join(append(parts, subject.Name), "/")
+}
+
+// ruleResourceAccess lists the verbs allowed per resource by the rules, like the access feed.
+func ruleResourceAccess(rules []rbac_v1.PolicyRule) []ResourceAccess {
+	resources := []ResourceAccess{}
+	for _, rule := range rules {
+		for _, apiGroup := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				resources = append(resources, ResourceAccess{APIGroup: apiGroup, Resource: resource, ResourceNames: rule.ResourceNames, Verbs: mergeSorted(rule.Verbs, nil)})
+			}
+		}
+	}
+	return resources
+}
+
+// Decide records the decision of a reviewer about an item: ReviewDecisionKeep or ReviewDecisionRevoke.
+// A later decision replaces the previous one.
+func (in *ReviewCampaign) Decide(itemID, decision, reviewer, comment string) error {
+	if decision != ReviewDecisionKeep && decision != ReviewDecisionRevoke {
+		return fmt.Errorf("invalid review decision %q, expected %s or %s", decision, ReviewDecisionKeep, ReviewDecisionRevoke)
+	}
+	if reviewer == "" {
+		return fmt.Errorf("the reviewer of item %s is required", itemID)
+	}
+	for i := range in.Packets {
+		for j := range in.Packets[i].Items {
+			item := &in.Packets[i].Items[j]
+			if item.ID == itemID {
+				now := time.Now().UTC()
+				item.Decision, item.Reviewer, item.Comment, item.DecidedAt = decision, reviewer, comment, &now
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("unknown review item %s", itemID)
+}
+
+// Pending returns the number of items without decision.
+func (in *ReviewCampaign) Pending() int {
+	pending := 0
+	for _, packet := range in.Packets {
+		for _, item := range packet.Items {
+			if item.Decision == ReviewDecisionPending {
+				pending++
+			}
+		}
+	}
+	return pending
+}
+
+// RevocationPlan computes the changes revoking the items with the revoke decision, against the current
+// bindings of the snapshot. Bindings deleted since the campaign was created are ignored. The items of
+// campaigns redacted by the redaction policy are recognized. Render the updated bindings with
+// RenderManifests.
+func (in *ReviewCampaign) RevocationPlan(snapshot *RBACSnapshot) *RevocationPlan {
+	revoked := map[string]bool{}
+	for _, packet := range in.Packets {
+		for _, item := range packet.Items {
+			if item.Decision == ReviewDecisionRevoke {
+				revoked[item.ID] = true
+			}
+		}
+	}
+
+	policy := currentRedaction()
+	plan := &RevocationPlan{Updated: []runtime.Object{}, Deleted: []RBACObjectRef{}}
+	keptSubjects := func(binding RBACObjectRef, subjects []rbac_v1.Subject) ([]rbac_v1.Subject, bool) {
+		kept := make([]rbac_v1.Subject, 0, len(subjects))
+		for _, subject := range subjects {
+			if !revoked[reviewItemID(binding, subject)] && !revoked[reviewItemID(binding, policy.subject(subject))] {
+				kept = append(kept, subject)
+			}
+		}
+		return kept, len(kept) != len(subjects)
+	}
+
+	for _, crb := range snapshot.ClusterRoleBindings {
+		ref := RBACObjectRef{Kind: "ClusterRoleBinding", Name: crb.Name}
+		kept, changed := keptSubjects(ref, crb.Subjects)
+		switch {
+		case !changed:
+		case len(kept) == 0:
+			plan.Deleted = append(plan.Deleted, ref)
+		default:
+			plan.Updated = append(plan.Updated, &rbac_v1.ClusterRoleBinding{
+				TypeMeta:   meta_v1.TypeMeta{APIVersion: rbac_v1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+				ObjectMeta: meta_v1.ObjectMeta{Name: crb.Name, Labels: crb.Labels, Annotations: crb.Annotations},
+				RoleRef:    crb.RoleRef,
+				Subjects:   kept,
+			})
+		}
+	}
+	for _, rb := range snapshot.RoleBindings {
+		ref := RBACObjectRef{Kind: "RoleBinding", Namespace: rb.Namespace, Name: rb.Name}
+		kept, changed := keptSubjects(ref, rb.Subjects)
+		switch {
+		case !changed:
+		case len(kept) == 0:
+			plan.Deleted = append(plan.Deleted, ref)
+		default:
+			plan.Updated = append(plan.Updated, &rbac_v1.RoleBinding{
+				TypeMeta:   meta_v1.TypeMeta{APIVersion: rbac_v1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+				ObjectMeta: meta_v1.ObjectMeta{Name: rb.Name, Namespace: rb.Namespace, Labels: rb.Labels, Annotations: rb.Annotations},
+				RoleRef:    rb.RoleRef,
+				Subjects:   kept,
+			})
+		}
+	}
+	return plan
+}
+
+// ReviewPacketColumns are the columns of the review packets.
+var ReviewPacketColumns = []Column[ReviewPacket]{
+	{Header: "TEAM", Value: func(in ReviewPacket) string { return in.teamID() }},
+	{Header: "NAMESPACE", Value: func(in ReviewPacket) string { return in.Namespace }},
+	{Header: "ITEMS", Value: func(in ReviewPacket) string { return strconv.Itoa(len(in.Items)) }},
+	{Header: "PENDING", Value: func(in ReviewPacket) string {
+		pending := 0
+		for _, item := range in.Items {
+			if item.Decision == ReviewDecisionPending {
+				pending++
+			}
+		}
+		return strconv.Itoa(pending)
+	}},
+}
+
+// PrintOutput writes the campaign in the format, see PrintOutput; the table lists the packets. Like the
+// other formats, the campaign is redacted by the redaction policy, see RedactionPolicy.ReviewCampaign.
+func (in *ReviewCampaign) PrintOutput(w io.Writer, format OutputFormat) error {
+	redacted := currentRedaction().ReviewCampaign(in)
+	return PrintOutput(w, format, redacted, NewTable(redacted.Packets, ReviewPacketColumns...))
+}
+
+// WriteJSON writes the campaign as JSON, the format read back by ReadReviewCampaign, see PrintOutput.
+func (in *ReviewCampaign) WriteJSON(w io.Writer) error {
+	return in.PrintOutput(w, OutputFormat{Name: OutputJSON})
+}
+
+// ReadReviewCampaign reads a campaign written by WriteJSON, e.g. to record the decisions.
+func ReadReviewCampaign(r io.Reader) (*ReviewCampaign, error) {
+	campaign := &ReviewCampaign{}
+	if err := json.NewDecoder(r).Decode(campaign); err != nil {
+		return nil, fmt.Errorf("invalid review campaign: %w", err)
+	}
+	if campaign.APIVersion != ReviewCampaignAPIVersion {
+		return nil, fmt.Errorf("unsupported review campaign version %q", campaign.APIVersion)
+	}
+	return campaign, nil
+}
+
+// WriteCSV writes one row per item and resource, e.g. to be reviewed in a spreadsheet. The id column is
+// the item ID to pass to Decide, the team column is qualified by its kind, e.g. Group/developers. The cells
+// that spreadsheets would take for formulas are escaped, see csvCell.
+func (in *ReviewCampaign) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"id", "team", "namespace", "role", "apiGroup", "resource", "resourceNames", "verbs", "decision", "reviewer", "comment"}); err != nil {
+		return err
+	}
+	for _, packet := range currentRedaction().ReviewCampaign(in).Packets {
+		for _, item := range packet.Items {
+			role := item.RoleRef.Kind + "/" + item.RoleRef.Name
+			for _, resource := range item.Resources {
+				row := []string{
+					item.ID, packet.teamID(), packet.Namespace, role, resource.APIGroup, resource.Resource,
+					strings.Join(resource.ResourceNames, " "), strings.Join(resource.Verbs, " "),
+					item.Decision, item.Reviewer, item.Comment,
+				}
+				for i := range row {
+					row[i] = csvCell(row[i])
+				}
+				if err := writer.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvCell escapes the values starting like a formula, which spreadsheets would evaluate (CSV injection):
+// they are prefixed with a quote, so they are displayed as text.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+var reviewCampaignTemplate = template.Must(template.New("campaign").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Access review {{.Name}}</title></head>
+<body>
+<h1>Access review {{.Name}}</h1>
+<p>Access as of {{.CreatedAt.Format "2006-01-02 15:04 MST"}}</p>
+{{range .Packets}}
+<h2>{{.TeamKind}} {{if .TeamNamespace}}{{.TeamNamespace}}/{{end}}{{.Team}} in {{if eq .Namespace "*"}}all namespaces{{else}}{{.Namespace}}{{end}}</h2>
+<table border="1">
+<tr><th>Item</th><th>Role</th><th>Access</th><th>Decision</th><th>Reviewer</th><th>Comment</th></tr>
+{{range .Items}}
+<tr>
+<td>{{.ID}}</td>
+<td>{{.RoleRef.Kind}}/{{.RoleRef.Name}}</td>
+<td>{{range .Resources}}{{.Resource}}{{if .APIGroup}}.{{.APIGroup}}{{end}}: {{range $i, $v := .Verbs}}{{if $i}}, {{end}}{{$v}}{{end}}<br>{{end}}</td>
+<td>{{if .Decision}}{{.Decision}}{{else}}pending{{end}}</td>
+<td>{{.Reviewer}}</td>
+<td>{{.Comment}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes the campaign as a standalone HTML page, e.g. to be sent to the reviewers.
+func (in *ReviewCampaign) WriteHTML(w io.Writer) error {
+	return reviewCampaignTemplate.Execute(w, currentRedaction().ReviewCampaign(in))
+}
@@ -0,0 +1,173 @@
+package business
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewReviewCampaign(t *testing.T) {
+	campaign := NewReviewCampaign("q3", testSnapshot(podReaderObjects()...))
+
+	assert.Equal(t, ReviewCampaignAPIVersion, campaign.APIVersion)
+	assert.Equal(t, "q3", campaign.Name)
+	require.Len(t, campaign.Packets, 3)
+	teams := []string{}
+	for _, packet := range campaign.Packets {
+		teams = append(teams, packet.Team+"@"+packet.Namespace)
+	}
+	assert.Equal(t, []string{"alice@ns1", "bob@*", "developers@ns1"}, teams)
+
+	item := campaign.Packets[0].Items[0]
+	assert.Equal(t, "RoleBinding/ns1/alice-pods/User/alice", item.ID)
+	assert.Equal(t, "pod-reader", item.RoleRef.Name)
+	assert.Equal(t, []ResourceAccess{{APIGroup: "", Resource: "pods", Verbs: []string{"get", "list", "watch"}}}, item.Resources)
+	assert.Equal(t, "ClusterRoleBinding//bob-pods/User/bob", campaign.Packets[1].Items[0].ID)
+	assert.Equal(t, 3, campaign.Pending())
+}
+
+func TestNewReviewCampaignKeepsTheTeamsOfTheSameNameApart(t *testing.T) {
+	serviceAccount := func(namespace string) rbac_v1.Subject {
+		return rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Namespace: namespace, Name: "default"}
+	}
+	campaign := NewReviewCampaign("q3", testSnapshot(
+		testClusterRole("pod-reader", testRule([]string{""}, []string{"pods"}, []string{"get"})),
+		testClusterRoleBinding("ops-user", "pod-reader", testUser("ops")),
+		testClusterRoleBinding("ops-group", "pod-reader", testGroup("ops")),
+		testClusterRoleBinding("defaults", "pod-reader", serviceAccount("ns1"), serviceAccount("ns2")),
+	))
+
+	teams := []string{}
+	for _, packet := range campaign.Packets {
+		require.Len(t, packet.Items, 1)
+		teams = append(teams, packet.teamID())
+	}
+	assert.Equal(t, []string{"ServiceAccount/ns1/default", "ServiceAccount/ns2/default", "Group/ops", "User/ops"}, teams)
+}
+
+func TestReviewItemID(t *testing.T) {
+	binding := RBACObjectRef{Kind: "RoleBinding", Namespace: "ns1", Name: "ci"}
+	assert.Equal(t, "RoleBinding/ns1/ci/ServiceAccount/ci/deployer",
+		reviewItemID(binding, rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Namespace: "ci", Name: "deployer"}))
+	assert.Equal(t, "RoleBinding/ns1/ci/ServiceAccount/deployer",
+		reviewItemID(binding, rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Name: "deployer"}))
+	assert.Equal(t, "RoleBinding/ns1/ci/Group/developers", reviewItemID(binding, testGroup("developers")))
+}
+
+func TestReviewCampaignDecide(t *testing.T) {
+	campaign := NewReviewCampaign("q3", testSnapshot(podReaderObjects()...))
+	id := campaign.Packets[0].Items[0].ID
+
+	require.NoError(t, campaign.Decide(id, ReviewDecisionKeep, "carol", "on call"))
+	require.NoError(t, campaign.Decide(id, ReviewDecisionRevoke, "dave", "left the team"))
+	item := campaign.Packets[0].Items[0]
+	assert.Equal(t, ReviewDecisionRevoke, item.Decision)
+	assert.Equal(t, "dave", item.Reviewer)
+	assert.Equal(t, "left the team", item.Comment)
+	assert.NotNil(t, item.DecidedAt)
+	assert.Equal(t, 2, campaign.Pending())
+
+	assert.ErrorContains(t, campaign.Decide(id, "maybe", "carol", ""), `invalid review decision "maybe"`)
+	assert.ErrorContains(t, campaign.Decide(id, ReviewDecisionKeep, "", ""), "reviewer")
+	assert.ErrorContains(t, campaign.Decide("RoleBinding/ns9/missing/User/alice", ReviewDecisionKeep, "carol", ""), "unknown review item")
+}
+
+func TestReviewCampaignRevocationPlan(t *testing.T) {
+	snapshot := testSnapshot(append(podReaderObjects(),
+		testRoleBinding("ns2", "shared", "ClusterRole", "pod-reader", testUser("alice"), testUser("bob")),
+	)...)
+	campaign := NewReviewCampaign("q3", snapshot)
+	for _, id := range []string{"RoleBinding/ns1/alice-pods/User/alice", "RoleBinding/ns2/shared/User/alice", "ClusterRoleBinding//bob-pods/User/bob"} {
+		require.NoError(t, campaign.Decide(id, ReviewDecisionRevoke, "carol", ""))
+	}
+	require.NoError(t, campaign.Decide("RoleBinding/ns1/developers-deployments/Group/developers", ReviewDecisionKeep, "carol", ""))
+
+	// bob-pods was deleted since the campaign was created
+	current := testSnapshot(append(podReaderObjects()[:3],
+		podReaderObjects()[4],
+		testRoleBinding("ns2", "shared", "ClusterRole", "pod-reader", testUser("alice"), testUser("bob")),
+	)...)
+	plan := campaign.RevocationPlan(current)
+	assert.Equal(t, []RBACObjectRef{{Kind: "RoleBinding", Namespace: "ns1", Name: "alice-pods"}}, plan.Deleted)
+	require.Len(t, plan.Updated, 1)
+	updated := plan.Updated[0].(*rbac_v1.RoleBinding)
+	assert.Equal(t, "shared", updated.Name)
+	assert.Equal(t, "RoleBinding", updated.Kind)
+	assert.Equal(t, []rbac_v1.Subject{testUser("bob")}, updated.Subjects)
+}
+
+func TestReviewCampaignJSON(t *testing.T) {
+	campaign := NewReviewCampaign("q3", testSnapshot(podReaderObjects()...))
+	require.NoError(t, campaign.Decide(campaign.Packets[1].Items[0].ID, ReviewDecisionKeep, "carol", ""))
+
+	var content bytes.Buffer
+	require.NoError(t, campaign.WriteJSON(&content))
+	read, err := ReadReviewCampaign(&content)
+	require.NoError(t, err)
+	assert.Equal(t, campaign.Packets, read.Packets)
+	assert.Equal(t, 2, read.Pending())
+
+	_, err = ReadReviewCampaign(strings.NewReader(`{"apiVersion": "kiali.io/review-campaign/v0"}`))
+	assert.ErrorContains(t, err, "unsupported review campaign version")
+	_, err = ReadReviewCampaign(strings.NewReader("{"))
+	assert.ErrorContains(t, err, "invalid review campaign")
+}
+
+func TestReviewCampaignPrintOutput(t *testing.T) {
+	campaign := NewReviewCampaign("q3", testSnapshot(podReaderObjects()...))
+	require.NoError(t, campaign.Decide(campaign.Packets[1].Items[0].ID, ReviewDecisionKeep, "carol", ""))
+
+	var content bytes.Buffer
+	require.NoError(t, campaign.PrintOutput(&content, OutputFormat{Name: OutputTable}))
+	lines := strings.Split(strings.TrimSpace(content.String()), "\n")
+	require.Len(t, lines, 1+len(campaign.Packets))
+	assert.Equal(t, []string{"TEAM", "NAMESPACE", "ITEMS", "PENDING"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{campaign.Packets[1].teamID(), campaign.Packets[1].Namespace, "1", "0"}, strings.Fields(lines[2]))
+
+	content.Reset()
+	require.NoError(t, campaign.PrintOutput(&content, OutputFormat{Name: OutputYAML}))
+	assert.Contains(t, content.String(), "name: q3\n")
+}
+
+func TestReviewCampaignCSVAndHTML(t *testing.T) {
+	campaign := NewReviewCampaign("q3", testSnapshot(podReaderObjects()...))
+	require.NoError(t, campaign.Decide("RoleBinding/ns1/alice-pods/User/alice", ReviewDecisionRevoke, "carol", "left, the team"))
+
+	var content bytes.Buffer
+	require.NoError(t, campaign.WriteCSV(&content))
+	rows, err := csv.NewReader(&content).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+	assert.Equal(t, "id", rows[0][0])
+	assert.Equal(t, []string{"RoleBinding/ns1/alice-pods/User/alice", "User/alice", "ns1", "ClusterRole/pod-reader", "", "pods", "", "get list watch", "revoke", "carol", "left, the team"}, rows[1])
+
+	content.Reset()
+	require.NoError(t, campaign.WriteHTML(&content))
+	html := content.String()
+	assert.Contains(t, html, "<h1>Access review q3</h1>")
+	assert.Contains(t, html, "<h2>User bob in all namespaces</h2>")
+	assert.Contains(t, html, "deployments.apps: get, list, patch, update")
+	assert.Contains(t, html, "<td>pending</td>")
+}
+
+func TestReviewCampaignCSVEscapesTheFormulas(t *testing.T) {
+	campaign := NewReviewCampaign("q3", testSnapshot(podReaderObjects()...))
+	require.NoError(t, campaign.Decide("RoleBinding/ns1/alice-pods/User/alice", ReviewDecisionRevoke, "=HYPERLINK(\"http://evil\")", "-1+1"))
+
+	var content bytes.Buffer
+	require.NoError(t, campaign.WriteCSV(&content))
+	rows, err := csv.NewReader(&content).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"revoke", "'=HYPERLINK(\"http://evil\")", "'-1+1"}, rows[1][8:])
+
+	assert.Equal(t, "'@SUM(A1)", csvCell("@SUM(A1)"))
+	assert.Equal(t, "'+1", csvCell("+1"))
+	assert.Equal(t, "pods", csvCell("pods"))
+	assert.Equal(t, "", csvCell(""))
+}
@@ -0,0 +1,267 @@
+package business
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync/atomic"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+)
+
+// redactedName replaces the names of the objects omitted by a RedactionPolicy.
+const redactedName = "<redacted>"
+
+// RedactionPolicy removes personal data from what the permission subsystem writes out: the logs, the
+// audit records, the discrepancy reports, the persisted permission history and the exported reports, i.e.
+// the review campaigns, the usage heatmaps, the drift reports and the access feed. The decision cache is
+// not persisted when it would be redacted, see PermissionChecker.SaveCacheSnapshot. The in-memory state
+// and the API responses, which are about the caller or authorized, are not redacted.
+type RedactionPolicy struct {
+	// HashUsernames replaces the user names with a hash, stable so the records of a user can still be
+	// correlated. The extra attributes of the users are dropped, since they often hold the same data.
+	HashUsernames bool `yaml:"hash_usernames"`
+	// HashKey keys the hashes, so they cannot be reversed by hashing known user names. It is required
+	// when HashUsernames is set.
+	HashKey string `yaml:"hash_key"`
+	// OmitResourceNames replaces the names of the objects, e.g. of the Secrets named after their users.
+	OmitResourceNames bool `yaml:"omit_resource_names"`
+}
+
+// redaction is the policy of the whole process, set by PermissionChecker.ApplyConfig, since the logs
+// are written from places without a config.
+var redaction atomic.Pointer[RedactionPolicy]
+
+// SetRedactionPolicy sets the redaction policy of the process.
+func SetRedactionPolicy(policy RedactionPolicy) {
+	redaction.Store(&policy)
+}
+
+// currentRedaction returns the redaction policy of the process, no redaction by default.
+func currentRedaction() RedactionPolicy {
+	if policy := redaction.Load(); policy != nil {
+		return *policy
+	}
+	return RedactionPolicy{}
+}
+
+// redactedUser returns the user name to write in the logs, see RedactionPolicy.
+func redactedUser(name string) string {
+	return currentRedaction().Username(name)
+}
+
+// Username returns the name, or its hash if the policy hashes the user names.
+func (in RedactionPolicy) Username(name string) string {
+	if !in.HashUsernames || name == "" {
+		return name
+	}
+	mac := hmac.New(sha256.New, []byte(in.HashKey))
+	mac.Write([]byte(name))
+	return "user-" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// User returns the user redacted by the policy. The groups are kept.
+func (in RedactionPolicy) User(user UserInfo) UserInfo {
+	if !in.HashUsernames {
+		return user
+	}
+	user.Name = in.Username(user.Name)
+	user.Extra = nil
+	return user
+}
+
+// isEmpty returns true if the policy redacts nothing.
+func (in RedactionPolicy) isEmpty() bool {
+	return !in.HashUsernames && !in.OmitResourceNames
+}
+
+// subject returns the subject of a binding redacted by the policy: the names of the User subjects are
+// hashed, the groups and the ServiceAccounts are kept.
+func (in RedactionPolicy) subject(subject rbac_v1.Subject) rbac_v1.Subject {
+	if subject.Kind == rbac_v1.UserKind {
+		subject.Name = in.Username(subject.Name)
+	}
+	return subject
+}
+
+// resourceNames returns the names of the objects of rules redacted by the policy.
+func (in RedactionPolicy) resourceNames(names []string) []string {
+	if !in.OmitResourceNames || len(names) == 0 {
+		return names
+	}
+	return []string{redactedName}
+}
+
+// resources returns the access to resources redacted by the policy.
+func (in RedactionPolicy) resources(resources []ResourceAccess) []ResourceAccess {
+	if !in.OmitResourceNames {
+		return resources
+	}
+	redacted := make([]ResourceAccess, 0, len(resources))
+	for _, resource := range resources {
+		resource.ResourceNames = in.resourceNames(resource.ResourceNames)
+		redacted = append(redacted, resource)
+	}
+	return redacted
+}
+
+// Request returns the request redacted by the policy.
+func (in RedactionPolicy) Request(req AccessRequest) AccessRequest {
+	if in.OmitResourceNames && req.Name != "" {
+		req.Name = redactedName
+	}
+	return req
+}
+
+// reason returns the reason of a decision about the user, where the user name can appear, e.g. in the
+// bindings listed by the authorizers.
+func (in RedactionPolicy) reason(user UserInfo, reason string) string {
+	if !in.HashUsernames || user.Name == "" {
+		return reason
+	}
+	return strings.ReplaceAll(reason, user.Name, in.Username(user.Name))
+}
+
+// AuditRecord returns the record redacted by the policy.
+func (in RedactionPolicy) AuditRecord(record AuditRecord) AuditRecord {
+	record.Decision.Reason = in.reason(record.User, record.Decision.Reason)
+	record.User = in.User(record.User)
+	record.Request = in.Request(record.Request)
+	return record
+}
+
+// Discrepancy returns the discrepancy redacted by the policy. The local paths and rules are kept, except
+// the names of their User subjects.
+func (in RedactionPolicy) Discrepancy(d Discrepancy) Discrepancy {
+	d.Live.Reason = in.reason(d.User, d.Live.Reason)
+	if in.HashUsernames {
+		paths := make([]PermissionPath, 0, len(d.LocalPaths))
+		for _, path := range d.LocalPaths {
+			path.Subject = in.subject(path.Subject)
+			paths = append(paths, path)
+		}
+		d.LocalPaths = paths
+	}
+	d.User = in.User(d.User)
+	d.Request = in.Request(d.Request)
+	return d
+}
+
+// PermissionChange returns the change redacted by the policy.
+func (in RedactionPolicy) PermissionChange(change PermissionChange) PermissionChange {
+	change.User = in.Username(change.User)
+	if in.OmitResourceNames {
+		redact := func(requests []AccessRequest) []AccessRequest {
+			redacted := make([]AccessRequest, 0, len(requests))
+			for _, req := range requests {
+				redacted = append(redacted, in.Request(req))
+			}
+			return redacted
+		}
+		change.Gained = redact(change.Gained)
+		change.Lost = redact(change.Lost)
+	}
+	return change
+}
+
+// ReviewCampaign returns a copy of the campaign redacted by the policy: the User subjects, the teams they
+// form and the reviewers are hashed, and the items are identified by the redacted subjects, which
+// RevocationPlan still recognizes.
+func (in RedactionPolicy) ReviewCampaign(campaign *ReviewCampaign) *ReviewCampaign {
+	if in.isEmpty() {
+		return campaign
+	}
+	redacted := *campaign
+	redacted.Packets = make([]ReviewPacket, 0, len(campaign.Packets))
+	for _, packet := range campaign.Packets {
+		items := make([]ReviewItem, 0, len(packet.Items))
+		for _, item := range packet.Items {
+			if in.HashUsernames {
+				item.Subject = in.subject(item.Subject)
+				item.ID = reviewItemID(item.Binding, item.Subject)
+				item.Reviewer = in.Username(item.Reviewer)
+			}
+			item.Resources = in.resources(item.Resources)
+			items = append(items, item)
+			if in.HashUsernames && item.Subject.Kind == rbac_v1.UserKind {
+				packet.Team = item.Subject.Name
+			}
+		}
+		packet.Items = items
+		redacted.Packets = append(redacted.Packets, packet)
+	}
+	return &redacted
+}
+
+// UsageHeatmap returns a copy of the heatmap with the user names redacted by the policy.
+func (in RedactionPolicy) UsageHeatmap(heatmap *UsageHeatmap) *UsageHeatmap {
+	if !in.HashUsernames {
+		return heatmap
+	}
+	redacted := *heatmap
+	redacted.Cells = make([]UsageCell, 0, len(heatmap.Cells))
+	for _, cell := range heatmap.Cells {
+		cell.User = in.Username(cell.User)
+		redacted.Cells = append(redacted.Cells, cell)
+	}
+	return &redacted
+}
+
+// AccessFeed returns a copy of the feed redacted by the policy. The feed names the groups, which are
+// kept, so only the names of the objects of the rules are redacted.
+func (in RedactionPolicy) AccessFeed(feed *AccessFeed) *AccessFeed {
+	if !in.OmitResourceNames {
+		return feed
+	}
+	redacted := *feed
+	redacted.Teams = make([]TeamAccess, 0, len(feed.Teams))
+	for _, team := range feed.Teams {
+		namespaces := make([]NamespaceAccess, 0, len(team.Namespaces))
+		for _, namespace := range team.Namespaces {
+			namespace.Resources = in.resources(namespace.Resources)
+			namespaces = append(namespaces, namespace)
+		}
+		team.Namespaces = namespaces
+		redacted.Teams = append(redacted.Teams, team)
+	}
+	return &redacted
+}
+
+// DriftReport returns a copy of the report redacted by the policy: the names of the bindings, often
+// named after their users, are omitted. The roles are kept.
+func (in RedactionPolicy) DriftReport(report *DriftReport) *DriftReport {
+	if !in.OmitResourceNames {
+		return report
+	}
+	redact := func(objects []DriftObject) []DriftObject {
+		redacted := make([]DriftObject, 0, len(objects))
+		for _, object := range objects {
+			if object.Kind == "RoleBinding" || object.Kind == "ClusterRoleBinding" {
+				object.Name = redactedName
+			}
+			redacted = append(redacted, object)
+		}
+		return redacted
+	}
+	redacted := *report
+	redacted.Unmanaged = redact(report.Unmanaged)
+	redacted.Missing = redact(report.Missing)
+	return &redacted
+}
+
+// report returns the document redacted by the policy, if it is one of the exported reports.
+func (in RedactionPolicy) report(value interface{}) interface{} {
+	switch report := value.(type) {
+	case *ReviewCampaign:
+		return in.ReviewCampaign(report)
+	case *UsageHeatmap:
+		return in.UsageHeatmap(report)
+	case *AccessFeed:
+		return in.AccessFeed(report)
+	case *DriftReport:
+		return in.DriftReport(report)
+	default:
+		return value
+	}
+}
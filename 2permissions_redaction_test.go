@@ -0,0 +1,162 @@
+package business
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withTestRedaction sets the redaction policy of the process for the test.
+func withTestRedaction(t *testing.T, policy RedactionPolicy) {
+	SetRedactionPolicy(policy)
+	t.Cleanup(func() { SetRedactionPolicy(RedactionPolicy{}) })
+}
+
+var testRedactionPolicy = RedactionPolicy{HashUsernames: true, HashKey: "key", OmitResourceNames: true}
+
+func TestRedactedReviewCampaignStillPlansTheRevocations(t *testing.T) {
+	withTestRedaction(t, testRedactionPolicy)
+	snapshot := testSnapshot(podReaderObjects()...)
+	campaign := NewReviewCampaign("q3", snapshot)
+
+	var content bytes.Buffer
+	require.NoError(t, campaign.WriteJSON(&content))
+	// The bindings keep their names, e.g. alice-pods
+	assert.NotContains(t, content.String(), `"alice"`)
+	assert.NotContains(t, content.String(), "User/alice")
+	assert.Contains(t, content.String(), "developers")
+
+	redacted, err := ReadReviewCampaign(&content)
+	require.NoError(t, err)
+	aliceID := reviewItemID(RBACObjectRef{Kind: "RoleBinding", Namespace: "ns1", Name: "alice-pods"}, testRedactionPolicy.subject(testUser("alice")))
+	require.NoError(t, redacted.Decide(aliceID, ReviewDecisionRevoke, "carol", ""))
+
+	plan := redacted.RevocationPlan(snapshot)
+	assert.Equal(t, []RBACObjectRef{{Kind: "RoleBinding", Namespace: "ns1", Name: "alice-pods"}}, plan.Deleted)
+	assert.Empty(t, plan.Updated)
+}
+
+func TestReviewCampaignExportsAreRedacted(t *testing.T) {
+	withTestRedaction(t, testRedactionPolicy)
+	campaign := NewReviewCampaign("q3", testSnapshot(podReaderObjects()...))
+	require.NoError(t, campaign.Decide(campaign.Packets[0].Items[0].ID, ReviewDecisionKeep, "carol", ""))
+
+	var csv, html bytes.Buffer
+	require.NoError(t, campaign.WriteCSV(&csv))
+	require.NoError(t, campaign.WriteHTML(&html))
+	for _, export := range []string{csv.String(), html.String()} {
+		assert.NotContains(t, export, "User/alice")
+		assert.NotContains(t, export, "carol")
+		assert.Contains(t, export, testRedactionPolicy.Username("alice"))
+	}
+	assert.NotContains(t, csv.String(), ",alice,")
+	assert.NotContains(t, html.String(), "<h2>alice ")
+	// The campaign itself is not redacted
+	assert.Equal(t, "carol", campaign.Packets[0].Items[0].Reviewer)
+}
+
+func TestSavedReportsAreRedacted(t *testing.T) {
+	withTestRedaction(t, testRedactionPolicy)
+	store := NewFileReportStore(t.TempDir())
+	heatmap := &UsageHeatmap{Cells: []UsageCell{{User: "alice", Resource: "pods", Verb: "get", Count: 3, Granted: true}}}
+	drift := &DriftReport{Unmanaged: []DriftObject{{Kind: "RoleBinding", Namespace: "ns1", Name: "alice-admin"}, {Kind: "Role", Namespace: "ns1", Name: "admin"}}}
+	feed := &AccessFeed{Teams: []TeamAccess{{Team: "developers", Namespaces: []NamespaceAccess{{Namespace: "ns1", Resources: []ResourceAccess{{Resource: "secrets", ResourceNames: []string{"alice-token"}, Verbs: []string{"get"}}}}}}}}
+
+	for name, report := range map[string]interface{}{"heatmap": heatmap, "drift": drift, "feed": feed} {
+		require.NoError(t, SaveReport(testCtx, store, ReportKindMatrix, name, report))
+		content, err := store.Get(testCtx, ReportKindMatrix, name)
+		require.NoError(t, err)
+		assert.NotContains(t, string(content), "alice", name)
+	}
+
+	var saved DriftReport
+	require.NoError(t, LoadReport(testCtx, store, ReportKindMatrix, "drift", &saved))
+	assert.Equal(t, "admin", saved.Unmanaged[1].Name)
+	// The reports themselves are not redacted
+	assert.Equal(t, "alice", heatmap.Cells[0].User)
+}
+
+func TestCacheSnapshotIsNotSavedWhenRedacted(t *testing.T) {
+	checker := newTestChecker(&testReviews{allow: allowUsers("alice")}, NewPermissionsConfig())
+	_, err := checker.Check(testCtx, UserInfo{Name: "alice"}, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	withTestRedaction(t, testRedactionPolicy)
+	require.NoError(t, checker.SaveCacheSnapshot(testCtx, NewFileCacheSnapshotStore(path)))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	SetRedactionPolicy(RedactionPolicy{})
+	require.NoError(t, checker.SaveCacheSnapshot(testCtx, NewFileCacheSnapshotStore(path)))
+	entries, err := NewFileCacheSnapshotStore(path).Load(testCtx)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestRedactionPolicyUsername(t *testing.T) {
+	assert.Equal(t, "alice", RedactionPolicy{}.Username("alice"))
+	hashed := testRedactionPolicy.Username("alice")
+	assert.NotContains(t, hashed, "alice")
+	assert.Equal(t, hashed, testRedactionPolicy.Username("alice"))
+	assert.NotEqual(t, hashed, RedactionPolicy{HashUsernames: true, HashKey: "other"}.Username("alice"))
+	assert.Empty(t, testRedactionPolicy.Username(""))
+}
+
+func TestAuditRecordsAreRedacted(t *testing.T) {
+	withTestRedaction(t, testRedactionPolicy)
+	checker := newTestChecker(&testReviews{}, nil)
+	sink := &testAuditSink{}
+	checker.SetAuditSink(sink)
+
+	alice := UserInfo{Name: "alice", Groups: []string{"developers"}, Extra: map[string][]string{"email": {"alice@example.com"}}}
+	_, err := checker.Check(testCtx, alice, AccessRequest{Namespace: "ns1", Resource: "secrets", Name: "alice-token", Verb: "get"})
+	require.NoError(t, err)
+
+	require.Len(t, sink.records, 1)
+	record := sink.records[0]
+	assert.Equal(t, UserInfo{Name: testRedactionPolicy.Username("alice"), Groups: []string{"developers"}}, record.User)
+	assert.Equal(t, AccessRequest{Namespace: "ns1", Resource: "secrets", Name: redactedName, Verb: "get"}, record.Request)
+	assert.NotContains(t, record.Decision.Reason, "alice")
+}
+
+func TestRedactionPolicyReasons(t *testing.T) {
+	alice := UserInfo{Name: "alice"}
+	record := AuditRecord{User: alice, Decision: Decision{Reason: `RoleBinding "ns1/alice-pods" of ClusterRole "pod-reader" to User "alice"`}}
+
+	assert.Equal(t, record, RedactionPolicy{OmitResourceNames: true}.AuditRecord(record))
+	redacted := testRedactionPolicy.AuditRecord(record)
+	assert.Equal(t, `RoleBinding "ns1/`+testRedactionPolicy.Username("alice")+`-pods" of ClusterRole "pod-reader" to User "`+testRedactionPolicy.Username("alice")+`"`, redacted.Decision.Reason)
+}
+
+func TestRedactionPolicyPermissionChange(t *testing.T) {
+	change := PermissionChange{
+		User:   "alice",
+		Gained: []AccessRequest{{Namespace: "ns1", Resource: "secrets", Name: "alice-token", Verb: "get"}},
+		Lost:   []AccessRequest{{Namespace: "ns1", Resource: "pods", Verb: "list"}},
+	}
+
+	redacted := testRedactionPolicy.PermissionChange(change)
+	assert.Equal(t, testRedactionPolicy.Username("alice"), redacted.User)
+	assert.Equal(t, []AccessRequest{{Namespace: "ns1", Resource: "secrets", Name: redactedName, Verb: "get"}}, redacted.Gained)
+	assert.Equal(t, change.Lost, redacted.Lost)
+	// The change itself is left untouched
+	assert.Equal(t, "alice-token", change.Gained[0].Name)
+}
+
+func TestRedactionPolicyDriftReport(t *testing.T) {
+	report := &DriftReport{
+		Unmanaged: []DriftObject{{Kind: "RoleBinding", Namespace: "ns1", Name: "alice-admin"}},
+		Missing:   []DriftObject{{Kind: "ClusterRole", Name: "pod-reader"}, {Kind: "ClusterRoleBinding", Name: "bob-pods"}},
+	}
+
+	assert.Same(t, report, RedactionPolicy{HashUsernames: true, HashKey: "key"}.DriftReport(report))
+	redacted := testRedactionPolicy.DriftReport(report)
+	assert.Equal(t, []DriftObject{{Kind: "RoleBinding", Namespace: "ns1", Name: redactedName}}, redacted.Unmanaged)
+	assert.Equal(t, []DriftObject{{Kind: "ClusterRole", Name: "pod-reader"}, {Kind: "ClusterRoleBinding", Name: redactedName}}, redacted.Missing)
+	assert.Equal(t, "alice-admin", report.Unmanaged[0].Name)
+}
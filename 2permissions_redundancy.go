@@ -0,0 +1,226 @@
+package business
+
+import (
+	"sort"
+	"strings"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+)
+
+// RedundantRule is a rule of a role already granted by the other rules of the role.
+type RedundantRule struct {
+	Kind string `json:"kind"`
+	// Namespace of the role. Empty for ClusterRoles.
+	Namespace string `json:"namespace,omitempty"`
+	Role      string `json:"role"`
+	// Index is the position of the rule in the rules of the role.
+	Index int                `json:"index"`
+	Rule  rbac_v1.PolicyRule `json:"rule"`
+}
+
+// RedundantGrant is a subject of a binding already granted the rules of the bound role by other bindings.
+type RedundantGrant struct {
+	BindingKind string `json:"bindingKind"`
+	BindingName string `json:"bindingName"`
+	// Namespace of the binding. Empty for ClusterRoleBindings.
+	Namespace string          `json:"namespace,omitempty"`
+	Subject   rbac_v1.Subject `json:"subject"`
+	RoleRef   rbac_v1.RoleRef `json:"roleRef"`
+	// CoveredBy are the bindings granting the rules to the subject too.
+	CoveredBy []RBACObjectRef `json:"coveredBy"`
+}
+
+// RedundancyReport lists the rules and grants that can be deleted without changing the effective access.
+type RedundancyReport struct {
+	Rules  []RedundantRule  `json:"rules"`
+	Grants []RedundantGrant `json:"grants"`
+}
+
+// FindRedundancies detects the rules implied by other rules of the same role, e.g. get on pods next to
+// a wildcard, and the subjects of bindings granted the same rules by other bindings, e.g. a RoleBinding
+// of view to a user also bound to cluster-admin. Each finding can be deleted on its own: when two
+// grants cover each other, only one is reported. Grants are compared per subject, since the members
+// of the groups are unknown, and the rules of missing roles are considered empty.
+func (in *RBACSnapshot) FindRedundancies() *RedundancyReport {
+	report := &RedundancyReport{Rules: []RedundantRule{}, Grants: []RedundantGrant{}}
+	for _, name := range sortedMapKeys(in.ClusterRoles) {
+		report.Rules = append(report.Rules, redundantRules("ClusterRole", "", name, in.ClusterRoles[name].Rules)...)
+	}
+	for _, key := range sortedMapKeys(in.Roles) {
+		role := in.Roles[key]
+		report.Rules = append(report.Rules, redundantRules("Role", role.Namespace, role.Name, role.Rules)...)
+	}
+
+	// Cluster-wide grants first, so the namespaced grants they cover are the ones reported
+	grants := in.Grants()
+	sort.SliceStable(grants, func(i, j int) bool {
+		return grants[i].Namespace == "" && grants[j].Namespace != ""
+	})
+	redundant := make([]bool, len(grants))
+	for i := len(grants) - 1; i >= 0; i-- {
+		grant := grants[i]
+		if len(grant.Rules) == 0 {
+			continue
+		}
+		covering := []rbac_v1.PolicyRule{}
+		coveredBy := map[RBACObjectRef]bool{}
+		for j, other := range grants {
+			if j == i || redundant[j] || other.Subject != grant.Subject || !grantAppliesTo(other, grant.Namespace) {
+				continue
+			}
+			covering = append(covering, other.Rules...)
+			coveredBy[RBACObjectRef{Kind: other.BindingKind, Namespace: other.Namespace, Name: other.BindingName}] = true
+		}
+		if !rulesCovered(grant.Rules, covering) {
+			continue
+		}
+		redundant[i] = true
+		finding := RedundantGrant{
+			BindingKind: grant.BindingKind,
+			BindingName: grant.BindingName,
+			Namespace:   grant.Namespace,
+			Subject:     grant.Subject,
+			RoleRef:     grant.RoleRef,
+			CoveredBy:   make([]RBACObjectRef, 0, len(coveredBy)),
+		}
+		for ref := range coveredBy {
+			finding.CoveredBy = append(finding.CoveredBy, ref)
+		}
+		sort.Slice(finding.CoveredBy, func(a, b int) bool {
+			return finding.CoveredBy[a].Kind+"/"+finding.CoveredBy[a].Namespace+"/"+finding.CoveredBy[a].Name <
+				finding.CoveredBy[b].Kind+"/"+finding.CoveredBy[b].Namespace+"/"+finding.CoveredBy[b].Name
+		})
+		report.Grants = append(report.Grants, finding)
+	}
+	sort.SliceStable(report.Grants, func(i, j int) bool {
+		a, b := report.Grants[i], report.Grants[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.BindingName != b.BindingName {
+			return a.BindingName < b.BindingName
+		}
+		return a.Subject.Kind+"/"+a.Subject.Name < b.Subject.Kind+"/"+b.Subject.Name
+	})
+	return report
+}
+
+// RedundantGrantColumns are the columns of the redundant grants.
+var RedundantGrantColumns = []Column[RedundantGrant]{
+	{Header: "BINDING", Value: func(in RedundantGrant) string { return in.BindingKind + "/" + in.BindingName }},
+	{Header: "NAMESPACE", Value: func(in RedundantGrant) string { return in.Namespace }},
+	{Header: "SUBJECT", Value: func(in RedundantGrant) string { return in.Subject.Kind + "/" + in.Subject.Name }},
+	{Header: "ROLE", Value: func(in RedundantGrant) string { return in.RoleRef.Kind + "/" + in.RoleRef.Name }},
+	{Header: "COVERED BY", Wide: true, Value: func(in RedundantGrant) string {
+		refs := make([]string, 0, len(in.CoveredBy))
+		for _, ref := range in.CoveredBy {
+			refs = append(refs, ref.Kind+"/"+ref.Name)
+		}
+		return strings.Join(refs, ",")
+	}},
+}
+
+// redundantRules returns the rules of the role covered by its other rules. Rules already found
+// redundant do not cover the others, so duplicated rules are reported once.
+func redundantRules(kind, namespace, name string, rules []rbac_v1.PolicyRule) []RedundantRule {
+	found := []RedundantRule{}
+	redundant := make([]bool, len(rules))
+	for i := len(rules) - 1; i >= 0; i-- {
+		others := make([]rbac_v1.PolicyRule, 0, len(rules)-1)
+		for j, rule := range rules {
+			if j != i && !redundant[j] {
+				others = append(others, rule)
+			}
+		}
+		if rulesCovered(rules[i:i+1], others) {
+			redundant[i] = true
+			found = append(found, RedundantRule{Kind: kind, Namespace: namespace, Role: name, Index: i, Rule: rules[i]})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Index < found[j].Index })
+	return found
+}
+
+// rulesCovered returns true if everything allowed by the rules is allowed by the covering rules. Each
+// rule is split into single verb, API group, resource and name rules, so a rule can be covered by
+// several rules together.
+func rulesCovered(rules, covering []rbac_v1.PolicyRule) bool {
+	for _, rule := range rules {
+		for _, verb := range rule.Verbs {
+			for _, url := range rule.NonResourceURLs {
+				atom := rbac_v1.PolicyRule{Verbs: []string{verb}, NonResourceURLs: []string{url}}
+				if !atomCovered(atom, covering) {
+					return false
+				}
+			}
+			names := rule.ResourceNames
+			if len(names) == 0 {
+				names = []string{""}
+			}
+			for _, apiGroup := range rule.APIGroups {
+				for _, resource := range rule.Resources {
+					for _, name := range names {
+						atom := rbac_v1.PolicyRule{Verbs: []string{verb}, APIGroups: []string{apiGroup}, Resources: []string{resource}}
+						if name != "" {
+							atom.ResourceNames = []string{name}
+						}
+						if !atomCovered(atom, covering) {
+							return false
+						}
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+// atomCovered returns true if one of the rules allows everything the single-valued rule allows,
+// wildcards included.
+func atomCovered(atom rbac_v1.PolicyRule, rules []rbac_v1.PolicyRule) bool {
+	for _, rule := range rules {
+		if !containsString(rule.Verbs, rbac_v1.VerbAll) && !containsString(rule.Verbs, atom.Verbs[0]) {
+			continue
+		}
+		if len(atom.NonResourceURLs) > 0 {
+			if nonResourceURLCovered(rule.NonResourceURLs, atom.NonResourceURLs[0]) {
+				return true
+			}
+			continue
+		}
+		if !containsString(rule.APIGroups, rbac_v1.APIGroupAll) && !containsString(rule.APIGroups, atom.APIGroups[0]) {
+			continue
+		}
+		if !resourceCovered(rule.Resources, atom.Resources[0]) {
+			continue
+		}
+		if len(rule.ResourceNames) == 0 || len(atom.ResourceNames) > 0 && containsString(rule.ResourceNames, atom.ResourceNames[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceCovered returns true if the resources allow the resource, which can be a wildcard itself.
+func resourceCovered(resources []string, resource string) bool {
+	if containsString(resources, rbac_v1.ResourceAll) || containsString(resources, resource) {
+		return true
+	}
+	if _, subresource, ok := strings.Cut(resource, "/"); ok {
+		return containsString(resources, "*/"+subresource)
+	}
+	return false
+}
+
+// nonResourceURLCovered returns true if the URLs allow the URL, which can end with a wildcard itself.
+func nonResourceURLCovered(urls []string, url string) bool {
+	for _, u := range urls {
+		if u == rbac_v1.NonResourceAll || u == url {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(u, "*"); ok && strings.HasPrefix(url, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package business
+
+import (
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindRedundantRules(t *testing.T) {
+	report := testSnapshot(
+		// Duplicated rules are reported once
+		testClusterRole("duplicated",
+			testRule([]string{""}, []string{"pods"}, []string{"get"}),
+			testRule([]string{""}, []string{"pods"}, []string{"get", "list"}),
+			testRule([]string{""}, []string{"pods"}, []string{"get", "list"}),
+		),
+		testClusterRole("subresources",
+			testRule([]string{""}, []string{"pods/status"}, []string{"get"}),
+			testRule([]string{""}, []string{"*/status"}, []string{"get"}),
+		),
+		testClusterRole("urls",
+			rbac_v1.PolicyRule{NonResourceURLs: []string{"/healthz"}, Verbs: []string{"get"}},
+			rbac_v1.PolicyRule{NonResourceURLs: []string{"/health*"}, Verbs: []string{"get"}},
+		),
+		// A rule can be covered by several rules together, but not by the rules of restricted names
+		testRole("ns1", "split",
+			testRule([]string{"", "apps"}, []string{"configmaps"}, []string{"get"}),
+			testRule([]string{""}, []string{"configmaps"}, []string{"*"}),
+			testRule([]string{"*"}, []string{"configmaps"}, []string{"get"}),
+			rbac_v1.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"token"}, Verbs: []string{"get"}},
+			testRule([]string{""}, []string{"secrets"}, []string{"get"}),
+		),
+	).FindRedundancies()
+
+	require.Len(t, report.Rules, 6)
+	assert.Equal(t, []int{0, 2}, []int{report.Rules[0].Index, report.Rules[1].Index})
+	assert.Equal(t, "duplicated", report.Rules[0].Role)
+	assert.Equal(t, RedundantRule{Kind: "ClusterRole", Role: "subresources", Index: 0, Rule: testRule([]string{""}, []string{"pods/status"}, []string{"get"})}, report.Rules[2])
+	assert.Equal(t, "urls", report.Rules[3].Role)
+	assert.Equal(t, 0, report.Rules[3].Index)
+	assert.Equal(t, RedundantRule{Kind: "Role", Namespace: "ns1", Role: "split", Index: 0, Rule: testRule([]string{"", "apps"}, []string{"configmaps"}, []string{"get"})}, report.Rules[4])
+	assert.Equal(t, RedundantRule{Kind: "Role", Namespace: "ns1", Role: "split", Index: 3, Rule: rbac_v1.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"token"}, Verbs: []string{"get"}}}, report.Rules[5])
+	assert.Empty(t, report.Grants)
+}
+
+func TestFindRedundantGrants(t *testing.T) {
+	report := testSnapshot(
+		testClusterRole("pod-reader", testRule([]string{""}, []string{"pods"}, []string{"get", "list", "watch"})),
+		testClusterRole("cluster-admin", testRule([]string{"*"}, []string{"*"}, []string{"*"})),
+		testClusterRoleBinding("alice-admin", "cluster-admin", testUser("alice")),
+		testRoleBinding("ns1", "alice-pods", "ClusterRole", "pod-reader", testUser("alice")),
+		// Of two grants covering each other, only one is reported
+		testRoleBinding("ns1", "carol-pods", "ClusterRole", "pod-reader", testUser("carol")),
+		testRoleBinding("ns1", "carol-pods-again", "ClusterRole", "pod-reader", testUser("carol")),
+		// Grants of other namespaces, or to other subjects, do not cover
+		testRoleBinding("ns1", "bob-pods", "ClusterRole", "pod-reader", testUser("bob"), testGroup("developers")),
+		testRoleBinding("ns2", "bob-pods", "ClusterRole", "pod-reader", testUser("bob")),
+		testClusterRoleBinding("ops-admin", "cluster-admin", testGroup("ops")),
+		// Grants of missing roles grant nothing
+		testRoleBinding("ns1", "bob-missing", "ClusterRole", "missing", testUser("bob")),
+	).FindRedundancies()
+
+	assert.Empty(t, report.Rules)
+	require.Len(t, report.Grants, 2)
+	assert.Equal(t, RedundantGrant{
+		BindingKind: "RoleBinding",
+		BindingName: "alice-pods",
+		Namespace:   "ns1",
+		Subject:     testUser("alice"),
+		RoleRef:     rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: "pod-reader"},
+		CoveredBy:   []RBACObjectRef{{Kind: "ClusterRoleBinding", Name: "alice-admin"}},
+	}, report.Grants[0])
+	assert.Equal(t, testUser("carol"), report.Grants[1].Subject)
+	assert.Len(t, report.Grants[1].CoveredBy, 1)
+}
@@ -0,0 +1,88 @@
+package business
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+// RequestIDHeader is the HTTP header carrying the ID of the originating API request.
+const RequestIDHeader = "X-Request-Id"
+
+// PriorityHeader is the HTTP header carrying the priority of the checks of an API request, interactive or
+// background, see WithPriority.
+const PriorityHeader = "X-Kiali-Priority"
+
+// maxRequestIDLength bounds the request IDs taken from the RequestIDHeader.
+const maxRequestIDLength = 64
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the ID of the originating API request. The ID is included in the
+// log lines, audit records and errors produced by the permission checks made with the context, so the
+// decisions can be correlated with the request.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request ID set by WithRequestID, or an empty string.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// requestContext returns the context of the HTTP request, carrying the ID of its RequestIDHeader and the
+// priority of its PriorityHeader if any. The ID is written to the logs and audit records, so an ID longer
+// than maxRequestIDLength or with characters other than [A-Za-z0-9._-] is replaced with a generated one.
+// An unknown priority is ignored.
+func requestContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if requestID := r.Header.Get(RequestIDHeader); requestID != "" && RequestIDFromContext(ctx) == "" {
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		ctx = WithRequestID(ctx, requestID)
+	}
+	if priority, err := ParsePriority(r.Header.Get(PriorityHeader)); err == nil {
+		ctx = withDefaultPriority(ctx, priority)
+	}
+	return ctx
+}
+
+// validRequestID returns whether the request ID is short and only made of [A-Za-z0-9._-].
+func validRequestID(requestID string) bool {
+	if len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random request ID.
+func newRequestID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// logPrefix returns the prefix of the log lines of a check, naming its request ID.
+func logPrefix(ctx context.Context) string {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		return "[request " + requestID + "] "
+	}
+	return ""
+}
+
+// withRequestID annotates the error with the request ID of the context, if any.
+func withRequestID(ctx context.Context, err error) error {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		return fmt.Errorf("request %s: %w", requestID, err)
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package business
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestIDFromContext(t *testing.T) {
+	assert.Empty(t, RequestIDFromContext(testCtx))
+	assert.Equal(t, "req-1", RequestIDFromContext(WithRequestID(testCtx, "req-1")))
+
+	assert.Empty(t, logPrefix(testCtx))
+	assert.Equal(t, "[request req-1] ", logPrefix(WithRequestID(testCtx, "req-1")))
+}
+
+func TestRequestContextUsesTheRequestIDHeader(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Empty(t, RequestIDFromContext(requestContext(r)))
+
+	r.Header.Set(RequestIDHeader, "req-1")
+	assert.Equal(t, "req-1", RequestIDFromContext(requestContext(r)))
+
+	// The ID already in the context wins
+	r = r.WithContext(WithRequestID(r.Context(), "req-0"))
+	assert.Equal(t, "req-0", RequestIDFromContext(requestContext(r)))
+}
+
+func TestRequestContextReplacesTheInvalidRequestIDs(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(RequestIDHeader, "3f2a.b_C-9")
+	assert.Equal(t, "3f2a.b_C-9", RequestIDFromContext(requestContext(r)))
+
+	for _, requestID := range []string{"req 1", "req-1\nforged log line", "req/1", "réq", strings.Repeat("a", maxRequestIDLength+1)} {
+		r.Header.Set(RequestIDHeader, requestID)
+		generated := RequestIDFromContext(requestContext(r))
+		assert.NotEqual(t, requestID, generated)
+		assert.Regexp(t, "^[0-9a-f]{32}$", generated)
+	}
+}
+
+func TestWithRequestIDWrapsTheError(t *testing.T) {
+	assert.Equal(t, errTestAPIServer, withRequestID(testCtx, errTestAPIServer))
+
+	err := withRequestID(WithRequestID(testCtx, "req-1"), errTestAPIServer)
+	assert.ErrorIs(t, err, errTestAPIServer)
+	assert.Equal(t, "request req-1: "+errTestAPIServer.Error(), err.Error())
+}
+
+func TestCheckerPropagatesTheRequestID(t *testing.T) {
+	checker := newTestChecker(aliceReadsPods(), nil)
+	sink := &testAuditSink{}
+	checker.SetAuditSink(sink)
+
+	_, err := checker.Check(WithRequestID(testCtx, "req-1"), UserInfo{Name: "alice"}, aliceSecrets)
+	require.NoError(t, err)
+	require.Len(t, sink.records, 1)
+	assert.Equal(t, "req-1", sink.records[0].RequestID)
+
+	failing := newTestChecker(&testReviews{err: errTestAPIServer}, nil)
+	_, err = failing.Check(WithRequestID(testCtx, "req-2"), UserInfo{Name: "alice"}, alicePods)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "request req-2")
+}
+
+func TestRequirePermissionPropagatesTheRequestID(t *testing.T) {
+	checker := newTestChecker(aliceReadsPods(), nil)
+	sink := &testAuditSink{}
+	checker.SetAuditSink(sink)
+	var requestID string
+	handler := RequirePermission(checker,
+		func(r *http.Request) (UserInfo, error) { return UserInfo{Name: r.Header.Get("X-User")}, nil },
+		func(r *http.Request) AccessRequest { return alicePods },
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = RequestIDFromContext(r.Context())
+	}))
+
+	for _, user := range []string{"alice", "bob"} {
+		r := httptest.NewRequest(http.MethodGet, "/pods", nil)
+		r.Header.Set("X-User", user)
+		r.Header.Set(RequestIDHeader, "req-"+user)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// The next handler gets the ID of the allowed request, and the denial of bob is audited with its ID
+	assert.Equal(t, "req-alice", requestID)
+	require.Len(t, sink.records, 1)
+	assert.Equal(t, "req-bob", sink.records[0].RequestID)
+}
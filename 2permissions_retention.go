@@ -0,0 +1,458 @@
+package business
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kiali/kiali/log"
+)
+
+// DefaultCompactInterval is the period of the history merged into a single change or audit document by
+// the compaction, see RetentionPolicy.CompactInterval.
+const DefaultCompactInterval = 24 * time.Hour
+
+// DefaultRetentionInterval is the period of the retention runs of PermissionsService.
+const DefaultRetentionInterval = time.Hour
+
+// RetentionPolicy bounds the history and the audit records kept by the stores, so long-running deployments
+// do not grow unbounded. Zero values disable the corresponding bound, e.g.
+//
+//	retention:
+//	  max_age: 2160h
+//	  max_entries: 1000000
+//	  compact_after: 168h
+type RetentionPolicy struct {
+	// MaxAge is how long the history is kept. The permissions of the users at the cutoff are kept as
+	// baselines, so the access at the later times can still be queried.
+	MaxAge time.Duration `yaml:"max_age"`
+	// MaxEntries bounds the permission changes of the history database, and the documents of each kind of
+	// the report stores, the oldest being removed first.
+	MaxEntries int `yaml:"max_entries"`
+	// CompactAfter is the age from which the changes of a user, and the audit documents, are merged into one
+	// per CompactInterval. The access can then only be queried at the boundaries of the intervals.
+	CompactAfter time.Duration `yaml:"compact_after"`
+	// CompactInterval is the period merged by the compaction. Zero means DefaultCompactInterval.
+	CompactInterval time.Duration `yaml:"compact_interval"`
+}
+
+// IsEmpty returns whether the policy keeps everything.
+func (in RetentionPolicy) IsEmpty() bool {
+	return in.MaxAge == 0 && in.MaxEntries == 0 && in.CompactAfter == 0
+}
+
+func (in RetentionPolicy) compactInterval() time.Duration {
+	if in.CompactInterval > 0 {
+		return in.CompactInterval
+	}
+	return DefaultCompactInterval
+}
+
+func (in RetentionPolicy) validate() error {
+	switch {
+	case in.MaxAge < 0 || in.CompactAfter < 0 || in.CompactInterval < 0:
+		return errors.New("negative retention durations")
+	case in.MaxEntries < 0:
+		return fmt.Errorf("negative max entries %d", in.MaxEntries)
+	case in.MaxAge > 0 && in.CompactAfter >= in.MaxAge:
+		return fmt.Errorf("the history is removed after %s, before being compacted after %s", in.MaxAge, in.CompactAfter)
+	}
+	return nil
+}
+
+// RetentionResult counts what a store removed when applying a RetentionPolicy.
+type RetentionResult struct {
+	// Deleted is the number of changes, baselines and documents removed.
+	Deleted int `json:"deleted"`
+	// Compacted is the number of changes and documents merged into others.
+	Compacted int `json:"compacted"`
+}
+
+func (in *RetentionResult) add(other RetentionResult) {
+	in.Deleted += other.Deleted
+	in.Compacted += other.Compacted
+}
+
+// RetentionStore is a store enforcing a RetentionPolicy, e.g. SQLiteHistoryStore, or a ReportStore wrapped
+// by NewReportRetention.
+type RetentionStore interface {
+	ApplyRetention(ctx context.Context, policy RetentionPolicy, now time.Time) (RetentionResult, error)
+}
+
+// RunRetention applies the policy to the stores every interval, until the context is done. The errors are
+// logged, the next run retrying.
+func RunRetention(ctx context.Context, policy RetentionPolicy, interval time.Duration, stores ...RetentionStore) {
+	if policy.IsEmpty() || len(stores) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, store := range stores {
+			result, err := store.ApplyRetention(ctx, policy, time.Now())
+			if err != nil {
+				log.Errorf("Error applying the retention policy: %v", err)
+			} else if result.Deleted > 0 || result.Compacted > 0 {
+				log.Infof("Retention policy deleted %d and compacted %d history entries", result.Deleted, result.Compacted)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reportRetention applies the retention policies to the documents of a ReportStore.
+type reportRetention struct {
+	store ReportStore
+	kinds []string
+}
+
+// NewReportRetention makes the documents of the kinds of the store, all by default, subject to the retention
+// policies. Their age is read from their names, as made by ReportName and the store audit sink; the
+// documents with other names are only bounded by MaxEntries. The audit documents are compacted into one
+// per CompactInterval.
+func NewReportRetention(store ReportStore, kinds ...string) RetentionStore {
+	if len(kinds) == 0 {
+		kinds = []string{ReportKindMatrix, ReportKindAuditRecords, ReportKindDrift}
+	}
+	return &reportRetention{store: store, kinds: kinds}
+}
+
+// reportTime returns the time of the document named by ReportName or the store audit sink.
+func reportTime(name string) (time.Time, bool) {
+	const layout = "20060102T150405"
+	if len(name) < len(layout) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(layout, name[:len(layout)])
+	return t, err == nil
+}
+
+func (in *reportRetention) ApplyRetention(ctx context.Context, policy RetentionPolicy, now time.Time) (RetentionResult, error) {
+	result := RetentionResult{}
+	for _, kind := range in.kinds {
+		if kind == ReportKindAuditRecords && policy.CompactAfter > 0 {
+			compacted, err := in.compactAuditRecords(ctx, now.Add(-policy.CompactAfter), policy.compactInterval())
+			result.add(compacted)
+			if err != nil {
+				return result, err
+			}
+		}
+
+		names, err := in.store.List(ctx, kind)
+		if err != nil {
+			return result, err
+		}
+		kept := []string{}
+		for _, name := range names {
+			if t, ok := reportTime(name); ok && policy.MaxAge > 0 && t.Before(now.Add(-policy.MaxAge)) {
+				if err := in.store.Delete(ctx, kind, name); err != nil {
+					return result, err
+				}
+				result.Deleted++
+				continue
+			}
+			kept = append(kept, name)
+		}
+		// The names sort like the times, the oldest first
+		for i := 0; policy.MaxEntries > 0 && i < len(kept)-policy.MaxEntries; i++ {
+			if err := in.store.Delete(ctx, kind, kept[i]); err != nil {
+				return result, err
+			}
+			result.Deleted++
+		}
+	}
+	return result, nil
+}
+
+// compactAuditRecords merges the audit documents older than the cutoff into one per interval. The merged
+// document is written before the others are deleted, so an interruption duplicates records rather than
+// losing them.
+func (in *reportRetention) compactAuditRecords(ctx context.Context, cutoff time.Time, interval time.Duration) (RetentionResult, error) {
+	result := RetentionResult{}
+	names, err := in.store.List(ctx, ReportKindAuditRecords)
+	if err != nil {
+		return result, err
+	}
+	buckets := map[time.Time][]string{}
+	order := []time.Time{}
+	for _, name := range names {
+		t, ok := reportTime(name)
+		if !ok || !t.Before(cutoff) {
+			continue
+		}
+		bucket := t.Truncate(interval)
+		if _, ok := buckets[bucket]; !ok {
+			order = append(order, bucket)
+		}
+		buckets[bucket] = append(buckets[bucket], name)
+	}
+
+	for _, bucket := range order {
+		sources := buckets[bucket]
+		if len(sources) < 2 {
+			continue
+		}
+		records := []AuditRecord{}
+		for _, name := range sources {
+			batch := []AuditRecord{}
+			if err := LoadReport(ctx, in.store, ReportKindAuditRecords, name, &batch); err != nil {
+				return result, err
+			}
+			records = append(records, batch...)
+		}
+		compacted := bucket.UTC().Format("20060102T150405.000000000Z") + "-compacted"
+		if err := SaveReport(ctx, in.store, ReportKindAuditRecords, compacted, records); err != nil {
+			return result, err
+		}
+		for _, name := range sources {
+			if name == compacted {
+				continue
+			}
+			if err := in.store.Delete(ctx, ReportKindAuditRecords, name); err != nil {
+				return result, err
+			}
+			result.Compacted++
+		}
+	}
+	return result, nil
+}
+
+// ApplyRetention implements RetentionStore: the history before the cutoff of MaxAge and MaxEntries is
+// replaced by the baselines of the users at the cutoff, the changes older than CompactAfter are merged, and
+// the reports are pruned like by NewReportRetention. The file is then vacuumed if anything was removed.
+func (in *SQLiteHistoryStore) ApplyRetention(ctx context.Context, policy RetentionPolicy, now time.Time) (RetentionResult, error) {
+	result := RetentionResult{}
+	cutoff, err := in.retentionCutoff(ctx, policy, now)
+	if err != nil {
+		return result, err
+	}
+	if !cutoff.IsZero() {
+		deleted, err := in.truncateHistory(ctx, cutoff)
+		result.add(deleted)
+		if err != nil {
+			return result, err
+		}
+	}
+	if policy.CompactAfter > 0 {
+		compacted, err := in.compactHistory(ctx, now.Add(-policy.CompactAfter), policy.compactInterval())
+		result.add(compacted)
+		if err != nil {
+			return result, err
+		}
+	}
+	reports, err := NewReportRetention(in.ReportStore).ApplyRetention(ctx, policy, now)
+	result.add(reports)
+	if err != nil {
+		return result, err
+	}
+
+	if result.Deleted > 0 || result.Compacted > 0 {
+		// The deleted rows are only reused by SQLite, VACUUM shrinks the file
+		if _, err := in.db.ExecContext(ctx, "VACUUM"); err != nil {
+			return result, fmt.Errorf("failed to vacuum the history database: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// retentionCutoff returns the time up to which the history is removed, the latest of the MaxAge and
+// MaxEntries cutoffs, or zero if nothing is removed.
+func (in *SQLiteHistoryStore) retentionCutoff(ctx context.Context, policy RetentionPolicy, now time.Time) (time.Time, error) {
+	cutoff := time.Time{}
+	if policy.MaxAge > 0 {
+		cutoff = now.Add(-policy.MaxAge)
+	}
+	if policy.MaxEntries > 0 {
+		count := 0
+		if err := in.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM permission_changes").Scan(&count); err != nil {
+			return cutoff, err
+		}
+		if count > policy.MaxEntries {
+			var timestamp int64
+			err := in.db.QueryRowContext(ctx, "SELECT timestamp FROM permission_changes ORDER BY timestamp, id LIMIT 1 OFFSET ?", count-policy.MaxEntries-1).Scan(&timestamp)
+			if err != nil {
+				return cutoff, err
+			}
+			if t := time.Unix(0, timestamp); t.After(cutoff) {
+				cutoff = t
+			}
+		}
+	}
+	return cutoff, nil
+}
+
+// truncateHistory replaces the changes up to the cutoff and the previous baselines of every user by their
+// permissions at the cutoff.
+func (in *SQLiteHistoryStore) truncateHistory(ctx context.Context, cutoff time.Time) (RetentionResult, error) {
+	result := RetentionResult{}
+	users := []string{}
+	rows, err := in.db.QueryContext(ctx, "SELECT username FROM permission_changes WHERE timestamp <= ? UNION SELECT username FROM permission_baselines WHERE timestamp < ?", cutoff.UnixNano(), cutoff.UnixNano())
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		var user string
+		if err := rows.Scan(&user); err != nil {
+			rows.Close()
+			return result, err
+		}
+		users = append(users, user)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	for _, user := range users {
+		permissions, found, err := in.AccessAsOf(ctx, user, cutoff)
+		if err != nil {
+			return result, err
+		}
+		err = in.inTx(ctx, func(tx *sql.Tx) error {
+			if found {
+				content, err := json.Marshal(permissions)
+				if err != nil {
+					return err
+				}
+				if _, err := tx.ExecContext(ctx, "INSERT INTO permission_baselines (username, timestamp, permissions) VALUES (?, ?, ?)", user, cutoff.UnixNano(), string(content)); err != nil {
+					return err
+				}
+			}
+			for _, query := range []string{
+				"DELETE FROM permission_changes WHERE username = ? AND timestamp <= ?",
+				"DELETE FROM permission_baselines WHERE username = ? AND timestamp < ?",
+			} {
+				deleted, err := tx.ExecContext(ctx, query, user, cutoff.UnixNano())
+				if err != nil {
+					return err
+				}
+				n, _ := deleted.RowsAffected()
+				result.Deleted += int(n)
+			}
+			return nil
+		})
+		if err != nil {
+			return result, fmt.Errorf("failed to truncate the history of user %s: %w", redactedUser(user), err)
+		}
+	}
+	return result, nil
+}
+
+// compactHistory merges the changes of every user older than the cutoff into one per interval, with the
+// net gained and lost permissions, the causes of all of them and the hash of the last one.
+func (in *SQLiteHistoryStore) compactHistory(ctx context.Context, cutoff time.Time, interval time.Duration) (RetentionResult, error) {
+	result := RetentionResult{}
+	users := []string{}
+	rows, err := in.db.QueryContext(ctx, "SELECT DISTINCT username FROM permission_changes WHERE timestamp < ?", cutoff.UnixNano())
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		var user string
+		if err := rows.Scan(&user); err != nil {
+			rows.Close()
+			return result, err
+		}
+		users = append(users, user)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	for _, user := range users {
+		changes, err := in.History(ctx, user, time.Time{}, cutoff.Add(-1))
+		if err != nil {
+			return result, err
+		}
+		for start := 0; start < len(changes); {
+			bucket := changes[start].Timestamp.Truncate(interval)
+			end := start + 1
+			for end < len(changes) && changes[end].Timestamp.Truncate(interval).Equal(bucket) {
+				end++
+			}
+			if end-start > 1 {
+				if err := in.replaceChanges(ctx, user, changes[start:end], mergeChanges(changes[start:end])); err != nil {
+					return result, fmt.Errorf("failed to compact the history of user %s: %w", redactedUser(user), err)
+				}
+				result.Compacted += end - start - 1
+			}
+			start = end
+		}
+	}
+	return result, nil
+}
+
+// replaceChanges replaces the changes of the user, consecutive and oldest first, by the merged one.
+func (in *SQLiteHistoryStore) replaceChanges(ctx context.Context, user string, changes []PermissionChange, merged PermissionChange) error {
+	return in.inTx(ctx, func(tx *sql.Tx) error {
+		first, last := changes[0].Timestamp.UnixNano(), changes[len(changes)-1].Timestamp.UnixNano()
+		if _, err := tx.ExecContext(ctx, "DELETE FROM permission_changes WHERE username = ? AND timestamp >= ? AND timestamp <= ?", user, first, last); err != nil {
+			return err
+		}
+		columns := []interface{}{}
+		for _, value := range []interface{}{merged.Gained, merged.Lost, merged.Causes} {
+			content, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			columns = append(columns, string(content))
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO permission_changes (username, timestamp, hash, gained, lost, causes) VALUES (?, ?, ?, ?, ?, ?)",
+			append([]interface{}{user, last, merged.Hash}, columns...)...)
+		return err
+	})
+}
+
+// mergeChanges returns the change equivalent to the consecutive changes, oldest first: a permission gained
+// then lost, or lost then gained, is in neither.
+func mergeChanges(changes []PermissionChange) PermissionChange {
+	gained, lost := map[AccessRequest]bool{}, map[AccessRequest]bool{}
+	causes := map[RBACObjectRef]bool{}
+	merged := PermissionChange{User: changes[0].User, Causes: []RBACObjectRef{}}
+	for _, change := range changes {
+		for _, permission := range change.Lost {
+			if gained[permission] {
+				delete(gained, permission)
+			} else {
+				lost[permission] = true
+			}
+		}
+		for _, permission := range change.Gained {
+			if lost[permission] {
+				delete(lost, permission)
+			} else {
+				gained[permission] = true
+			}
+		}
+		for _, cause := range change.Causes {
+			if !causes[cause] {
+				causes[cause] = true
+				merged.Causes = append(merged.Causes, cause)
+			}
+		}
+		merged.Timestamp, merged.Hash = change.Timestamp, change.Hash
+	}
+	merged.Gained, merged.Lost = diffPermissions(lost, gained)
+	return merged
+}
+
+// inTx runs the function in a transaction, committed if it succeeds.
+func (in *SQLiteHistoryStore) inTx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := in.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
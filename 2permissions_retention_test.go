@@ -0,0 +1,204 @@
+package business
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionPolicyValidate(t *testing.T) {
+	assert.True(t, RetentionPolicy{}.IsEmpty())
+	assert.NoError(t, RetentionPolicy{}.validate())
+	assert.NoError(t, RetentionPolicy{MaxAge: 90 * 24 * time.Hour, MaxEntries: 1000, CompactAfter: 7 * 24 * time.Hour}.validate())
+	assert.Error(t, RetentionPolicy{MaxAge: -time.Hour}.validate())
+	assert.Error(t, RetentionPolicy{CompactInterval: -time.Hour}.validate())
+	assert.Error(t, RetentionPolicy{MaxEntries: -1}.validate())
+	// Compacting what is already removed is a configuration mistake
+	assert.Error(t, RetentionPolicy{MaxAge: time.Hour, CompactAfter: 2 * time.Hour}.validate())
+}
+
+func TestRetentionFromEnv(t *testing.T) {
+	t.Setenv(PermissionsConfigEnvPrefix+"RETENTION_MAX_AGE", "720h")
+	t.Setenv(PermissionsConfigEnvPrefix+"RETENTION_MAX_ENTRIES", "1000")
+	conf, err := LoadPermissionsConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, RetentionPolicy{MaxAge: 720 * time.Hour, MaxEntries: 1000}, conf.Retention)
+
+	t.Setenv(PermissionsConfigEnvPrefix+"RETENTION_MAX_ENTRIES", "many")
+	_, err = LoadPermissionsConfig("")
+	assert.Error(t, err)
+}
+
+func TestReportRetention(t *testing.T) {
+	store := NewFileReportStore(t.TempDir())
+	now := time.Date(2026, 10, 10, 12, 0, 0, 0, time.UTC)
+	for _, age := range []time.Duration{72 * time.Hour, 36 * time.Hour, 12 * time.Hour, time.Hour} {
+		require.NoError(t, SaveReport(testCtx, store, ReportKindDrift, ReportName(now.Add(-age)), DriftReport{}))
+	}
+	require.NoError(t, SaveReport(testCtx, store, ReportKindDrift, "manual", DriftReport{}))
+
+	result, err := NewReportRetention(store).ApplyRetention(testCtx, RetentionPolicy{MaxAge: 48 * time.Hour}, now)
+	require.NoError(t, err)
+	assert.Equal(t, RetentionResult{Deleted: 1}, result)
+	// The documents without a time are only bounded by their number
+	names, err := store.List(testCtx, ReportKindDrift)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ReportName(now.Add(-36 * time.Hour)), ReportName(now.Add(-12 * time.Hour)), ReportName(now.Add(-time.Hour)), "manual"}, names)
+
+	result, err = NewReportRetention(store, ReportKindDrift).ApplyRetention(testCtx, RetentionPolicy{MaxEntries: 2}, now)
+	require.NoError(t, err)
+	assert.Equal(t, RetentionResult{Deleted: 2}, result)
+	names, err = store.List(testCtx, ReportKindDrift)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ReportName(now.Add(-time.Hour)), "manual"}, names)
+}
+
+func TestReportRetentionCompactsAuditRecords(t *testing.T) {
+	store := NewFileReportStore(t.TempDir())
+	sink := NewStoreAuditSink(store, 1)
+	now := time.Date(2026, 10, 10, 12, 0, 0, 0, time.UTC)
+	day := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	for _, at := range []time.Time{day.Add(time.Hour), day.Add(2 * time.Hour), day.Add(25 * time.Hour), now.Add(-time.Hour)} {
+		sink.Record(testCtx, AuditRecord{Timestamp: at, User: UserInfo{Name: "alice"}, Request: alicePods})
+	}
+
+	result, err := NewReportRetention(store).ApplyRetention(testCtx, RetentionPolicy{CompactAfter: 48 * time.Hour}, now)
+	require.NoError(t, err)
+	assert.Equal(t, RetentionResult{Compacted: 2}, result)
+	names, err := store.List(testCtx, ReportKindAuditRecords)
+	require.NoError(t, err)
+	require.Len(t, names, 3)
+	assert.Equal(t, "20261001T000000.000000000Z-compacted", names[0])
+	records := []AuditRecord{}
+	require.NoError(t, LoadReport(testCtx, store, ReportKindAuditRecords, names[0], &records))
+	require.Len(t, records, 2)
+	assert.True(t, records[0].Timestamp.Equal(day.Add(time.Hour)))
+	assert.True(t, records[1].Timestamp.Equal(day.Add(2*time.Hour)))
+
+	// The compacted documents are left alone by the next runs
+	result, err = NewReportRetention(store).ApplyRetention(testCtx, RetentionPolicy{CompactAfter: 48 * time.Hour}, now)
+	require.NoError(t, err)
+	assert.Equal(t, RetentionResult{}, result)
+}
+
+func TestSQLiteHistoryStoreRetentionMaxAge(t *testing.T) {
+	store := openTestSQLiteHistoryStore(t, filepath.Join(t.TempDir(), "history.db"))
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	secrets := AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"}
+	deployments := AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "get"}
+	require.NoError(t, store.AppendBaseline(testCtx, "bob", []AccessRequest{alicePods}, start))
+	require.NoError(t, store.Append(testCtx, PermissionChange{User: "bob", Gained: []AccessRequest{secrets}, Timestamp: start.Add(time.Hour)}))
+	require.NoError(t, store.Append(testCtx, PermissionChange{User: "bob", Lost: []AccessRequest{alicePods}, Timestamp: start.Add(2 * time.Hour)}))
+	require.NoError(t, store.Append(testCtx, PermissionChange{User: "bob", Gained: []AccessRequest{deployments}, Timestamp: start.Add(8 * time.Hour)}))
+
+	result, err := store.ApplyRetention(testCtx, RetentionPolicy{MaxAge: 5 * time.Hour}, start.Add(10*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, RetentionResult{Deleted: 3}, result)
+
+	history, err := store.History(testCtx, "bob", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, []AccessRequest{deployments}, history[0].Gained)
+
+	// The access after the cutoff is unchanged, thanks to the baseline at the cutoff
+	permissions, found, err := store.AccessAsOf(testCtx, "bob", start.Add(9*time.Hour))
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []AccessRequest{secrets, deployments}, permissions)
+	_, found, err = store.AccessAsOf(testCtx, "bob", start.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestSQLiteHistoryStoreRetentionMaxEntries(t *testing.T) {
+	store := openTestSQLiteHistoryStore(t, filepath.Join(t.TempDir(), "history.db"))
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	secrets := AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"}
+	require.NoError(t, store.Append(testCtx, PermissionChange{User: "alice", Gained: []AccessRequest{alicePods}, Timestamp: start}))
+	require.NoError(t, store.Append(testCtx, PermissionChange{User: "alice", Gained: []AccessRequest{secrets}, Timestamp: start.Add(time.Hour)}))
+	require.NoError(t, store.Append(testCtx, PermissionChange{User: "alice", Lost: []AccessRequest{secrets}, Timestamp: start.Add(2 * time.Hour)}))
+
+	result, err := store.ApplyRetention(testCtx, RetentionPolicy{MaxEntries: 1}, start.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, RetentionResult{Deleted: 2}, result)
+	history, err := store.History(testCtx, "alice", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+	permissions, _, err := store.AccessAsOf(testCtx, "alice", start.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []AccessRequest{alicePods}, permissions)
+}
+
+func TestSQLiteHistoryStoreCompaction(t *testing.T) {
+	store := openTestSQLiteHistoryStore(t, filepath.Join(t.TempDir(), "history.db"))
+	day := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	now := day.Add(10 * 24 * time.Hour)
+	secrets := AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"}
+	cause := RBACObjectRef{Kind: "RoleBinding", Namespace: "ns1", Name: "alice-secrets"}
+	for _, change := range []PermissionChange{
+		{Gained: []AccessRequest{alicePods}, Timestamp: day.Add(time.Hour), Hash: "v1"},
+		{Lost: []AccessRequest{alicePods}, Timestamp: day.Add(2 * time.Hour), Hash: "v2"},
+		{Gained: []AccessRequest{secrets}, Causes: []RBACObjectRef{cause}, Timestamp: day.Add(3 * time.Hour), Hash: "v3"},
+		{Lost: []AccessRequest{secrets}, Timestamp: day.Add(25 * time.Hour), Hash: "v4"},
+		{Gained: []AccessRequest{alicePods}, Timestamp: now.Add(-2 * time.Hour), Hash: "v5"},
+		{Lost: []AccessRequest{alicePods}, Timestamp: now.Add(-time.Hour), Hash: "v6"},
+	} {
+		change.User = "alice"
+		require.NoError(t, store.Append(testCtx, change))
+	}
+
+	result, err := store.ApplyRetention(testCtx, RetentionPolicy{CompactAfter: 24 * time.Hour}, now)
+	require.NoError(t, err)
+	assert.Equal(t, RetentionResult{Compacted: 2}, result)
+
+	history, err := store.History(testCtx, "alice", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, history, 4)
+	// The pods gained and lost the same day are in neither
+	assert.Equal(t, []AccessRequest{secrets}, history[0].Gained)
+	assert.Empty(t, history[0].Lost)
+	assert.Equal(t, []RBACObjectRef{cause}, history[0].Causes)
+	assert.Equal(t, "v3", history[0].Hash)
+	assert.True(t, history[0].Timestamp.Equal(day.Add(3*time.Hour)))
+	assert.Equal(t, "v4", history[1].Hash)
+	assert.Equal(t, "v6", history[3].Hash)
+}
+
+func TestMergeChanges(t *testing.T) {
+	secrets := AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"}
+	merged := mergeChanges([]PermissionChange{
+		{User: "alice", Lost: []AccessRequest{alicePods}, Hash: "v1"},
+		{User: "alice", Gained: []AccessRequest{alicePods, secrets}, Hash: "v2"},
+	})
+	// A permission lost then gained back did not change
+	assert.Equal(t, []AccessRequest{secrets}, merged.Gained)
+	assert.Empty(t, merged.Lost)
+	assert.Equal(t, "alice", merged.User)
+	assert.Equal(t, "v2", merged.Hash)
+}
+
+// countingRetention is a RetentionStore counting its runs.
+type countingRetention struct{ runs int }
+
+func (in *countingRetention) ApplyRetention(ctx context.Context, policy RetentionPolicy, now time.Time) (RetentionResult, error) {
+	in.runs++
+	return RetentionResult{}, errTestAPIServer
+}
+
+func TestRunRetention(t *testing.T) {
+	store := &countingRetention{}
+	ctx, cancel := context.WithCancel(testCtx)
+	cancel()
+
+	// An empty policy does not run
+	RunRetention(ctx, RetentionPolicy{}, time.Hour, store)
+	assert.Equal(t, 0, store.runs)
+
+	// The failures do not stop the runs, the first one being immediate
+	RunRetention(ctx, RetentionPolicy{MaxEntries: 10}, time.Hour, store, store)
+	assert.Equal(t, 2, store.runs)
+}
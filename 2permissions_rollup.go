@@ -0,0 +1,117 @@
+package business
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NoTeam is the team of the users without any, in a rollup.
+const NoTeam = "<none>"
+
+// TeamRollup is the access of a team rolled up from the effective permissions of its members, so the
+// audits can be reviewed per team rather than per user. Permissions are sorted like the matrix.
+type TeamRollup struct {
+	Team    string   `json:"team"`
+	Members []string `json:"members"`
+	// Permissions are the permissions of at least one member.
+	Permissions []RolledUpPermission `json:"permissions"`
+}
+
+// RolledUpPermission is a permission of the members of a team.
+type RolledUpPermission struct {
+	AccessRequest
+	// Members are the sorted members holding the permission.
+	Members []string `json:"members"`
+	// Shared is set when every member of the team holds the permission, i.e. the team collectively can.
+	Shared bool `json:"shared"`
+}
+
+// RollUpByTeam aggregates the effective permissions of the users into their teams. teamsOf returns the
+// teams of a user; nil means the groups of the user, e.g. resolved with ResolveUserInfo. A user in
+// several teams counts in each, and the users without team are rolled up into NoTeam. The teams are
+// sorted by name.
+func RollUpByTeam(snapshot *RBACSnapshot, users []UserInfo, teamsOf func(UserInfo) []string) []TeamRollup {
+	if teamsOf == nil {
+		teamsOf = func(user UserInfo) []string { return user.Groups }
+	}
+	type teamAccumulator struct {
+		members     map[string]bool
+		permissions map[AccessRequest]map[string]bool
+	}
+	teams := map[string]*teamAccumulator{}
+
+	for _, user := range users {
+		names := teamsOf(user)
+		if len(names) == 0 {
+			names = []string{NoTeam}
+		}
+		effective := snapshot.EffectivePermissions(user)
+		for _, name := range names {
+			acc, ok := teams[name]
+			if !ok {
+				acc = &teamAccumulator{members: map[string]bool{}, permissions: map[AccessRequest]map[string]bool{}}
+				teams[name] = acc
+			}
+			acc.members[user.Name] = true
+			for permission := range effective {
+				if acc.permissions[permission] == nil {
+					acc.permissions[permission] = map[string]bool{}
+				}
+				acc.permissions[permission][user.Name] = true
+			}
+		}
+	}
+
+	rollups := make([]TeamRollup, 0, len(teams))
+	for name, acc := range teams {
+		rollup := TeamRollup{Team: name, Members: sortedKeys(acc.members), Permissions: make([]RolledUpPermission, 0, len(acc.permissions))}
+		requests := make([]AccessRequest, 0, len(acc.permissions))
+		for permission := range acc.permissions {
+			requests = append(requests, permission)
+		}
+		sortAccessRequests(requests)
+		for _, permission := range requests {
+			members := acc.permissions[permission]
+			rollup.Permissions = append(rollup.Permissions, RolledUpPermission{AccessRequest: permission, Members: sortedKeys(members), Shared: len(members) == len(acc.members)})
+		}
+		rollups = append(rollups, rollup)
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		return rollups[i].Team < rollups[j].Team
+	})
+	return rollups
+}
+
+// teamRollupRow is a row of TeamRollupTable.
+type teamRollupRow struct {
+	team       string
+	teamSize   int
+	permission RolledUpPermission
+}
+
+// TeamRollupTable returns the table of the rollups, with a row per team and permission. The wide format
+// also names the members holding the permissions not shared by the whole team.
+func TeamRollupTable(rollups []TeamRollup) *Table {
+	rows := []teamRollupRow{}
+	for _, rollup := range rollups {
+		for _, permission := range rollup.Permissions {
+			rows = append(rows, teamRollupRow{team: rollup.Team, teamSize: len(rollup.Members), permission: permission})
+		}
+	}
+	return NewTable(rows,
+		Column[teamRollupRow]{Header: "TEAM", Value: func(in teamRollupRow) string { return in.team }},
+		Column[teamRollupRow]{Header: "NAMESPACE", Value: func(in teamRollupRow) string { return in.permission.Namespace }},
+		Column[teamRollupRow]{Header: "RESOURCE", Value: func(in teamRollupRow) string { return groupResource(in.permission.APIGroup, in.permission.Resource) }},
+		Column[teamRollupRow]{Header: "VERB", Value: func(in teamRollupRow) string { return in.permission.Verb }},
+		Column[teamRollupRow]{Header: "MEMBERS", Value: func(in teamRollupRow) string {
+			return strconv.Itoa(len(in.permission.Members)) + "/" + strconv.Itoa(in.teamSize)
+		}},
+		Column[teamRollupRow]{Header: "HELD BY", Wide: true, Value: func(in teamRollupRow) string {
+			if in.permission.Shared {
+				return "all"
+			}
+			return strings.Join(in.permission.Members, ",")
+		}},
+	)
+}
@@ -0,0 +1,48 @@
+package business
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollUpByTeam(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+	users := []UserInfo{
+		{Name: "bob", Groups: []string{"developers"}},
+		{Name: "alice", Groups: []string{"developers"}},
+		{Name: "carol"},
+	}
+
+	rollups := RollUpByTeam(snapshot, users, nil)
+	require.Len(t, rollups, 2)
+	assert.Equal(t, TeamRollup{Team: NoTeam, Members: []string{"carol"}, Permissions: []RolledUpPermission{}}, rollups[0])
+
+	developers := rollups[1]
+	assert.Equal(t, "developers", developers.Team)
+	assert.Equal(t, []string{"alice", "bob"}, developers.Members)
+	require.Len(t, developers.Permissions, 10)
+	assert.Equal(t, RolledUpPermission{AccessRequest: AccessRequest{Resource: "pods", Verb: "get"}, Members: []string{"bob"}}, developers.Permissions[0])
+	assert.Equal(t, RolledUpPermission{AccessRequest: AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}, Members: []string{"alice"}}, developers.Permissions[3])
+	// The deployments are edited by the whole team, through its group
+	assert.Equal(t, RolledUpPermission{AccessRequest: AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "get"}, Members: []string{"alice", "bob"}, Shared: true}, developers.Permissions[6])
+
+	// The teams can come from elsewhere than the groups
+	rollups = RollUpByTeam(snapshot, users, func(user UserInfo) []string { return []string{"platform", "oncall"} })
+	require.Len(t, rollups, 2)
+	assert.Equal(t, "oncall", rollups[0].Team)
+	assert.Equal(t, []string{"alice", "bob", "carol"}, rollups[1].Members)
+}
+
+func TestTeamRollupTable(t *testing.T) {
+	table := TeamRollupTable(RollUpByTeam(testSnapshot(podReaderObjects()...), []UserInfo{
+		{Name: "alice", Groups: []string{"developers"}},
+		{Name: "bob", Groups: []string{"developers"}},
+	}, nil))
+
+	assert.Equal(t, []string{"TEAM", "NAMESPACE", "RESOURCE", "VERB", "MEMBERS", "HELD BY"}, table.headers)
+	require.Len(t, table.rows, 10)
+	assert.Equal(t, []string{"developers", "", "pods", "get", "1/2", "bob"}, table.rows[0])
+	assert.Equal(t, []string{"developers", "ns1", "deployments.apps", "get", "2/2", "all"}, table.rows[6])
+}
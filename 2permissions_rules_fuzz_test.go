@@ -0,0 +1,23 @@
+package business_test
+
+import (
+	"testing"
+
+	"github.com/kiali/kiali/business"
+	"github.com/kiali/kiali/business/permissionstest"
+)
+
+func FuzzRuleMatching(f *testing.F) {
+	f.Add("apps", "deployments,*/scale", "get,*", "", "apps", "deployments", "scale", "web", "get", "prod")
+	f.Add("", "pods,pods/log", "get,list", "", "", "pods", "log", "", "get", "ns1")
+	f.Add("", "configmaps", "update", "settings", "", "configmaps", "", "other", "update", "ns2")
+	f.Add("*", "*", "*", "", "batch", "jobs", "", "", "create", "")
+	f.Add("", "secrets", "get", "", "", "secrets", "", "", "list", "")
+	f.Fuzz(func(t *testing.T, groups, resources, verbs, names, group, resource, subresource, name, verb, namespace string) {
+		rule := permissionstest.RuleFromFuzz(groups, resources, verbs, names)
+		req := business.AccessRequest{APIGroup: group, Resource: resource, Subresource: subresource, Name: name, Verb: verb, Namespace: namespace}
+		if err := permissionstest.CheckRuleInvariants(rule, req); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package business
+
+import (
+	"context"
+	"sync"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+
+	"github.com/kiali/kiali/log"
+)
+
+// DefaultRulesReviewParallelism is the number of concurrent SelfSubjectRulesReviews made by
+// ReviewNamespaceRules when no parallelism is given.
+const DefaultRulesReviewParallelism = 10
+
+// NamespaceRules is the outcome of ReviewNamespaceRules. Namespaces whose review failed are in Errors
+// instead of Rules, so a partial result can still be used.
+type NamespaceRules struct {
+	Rules  map[string][]auth_v1.ResourceRule
+	Errors map[string]error
+	// Incomplete lists the namespaces where the authorizer could not list all the rules, e.g. because
+	// of a webhook authorizer. A missing rule does not mean a missing permission there.
+	Incomplete []string
+}
+
+// ReviewNamespaceRules issues a SelfSubjectRulesReview for every namespace, with at most parallelism
+// reviews in flight, to build the namespaced permission map of the client identity quickly on large
+// clusters. The reviews also count against the limiter of the client, if any, e.g. the one of a checker
+// shared by all its fan-outs, see NewLimitedClient.
+// If the context is cancelled the remaining namespaces are reported with the context error.
+func ReviewNamespaceRules(ctx context.Context, client PermissionsClient, namespaces []string, parallelism int) *NamespaceRules {
+	if parallelism <= 0 {
+		parallelism = DefaultRulesReviewParallelism
+	}
+
+	result := &NamespaceRules{
+		Rules:      make(map[string][]auth_v1.ResourceRule, len(namespaces)),
+		Errors:     map[string]error{},
+		Incomplete: []string{},
+	}
+	mu := sync.Mutex{}
+	jobs := make(chan string)
+
+	wg := sync.WaitGroup{}
+	wg.Add(parallelism)
+	for i := 0; i < parallelism; i++ {
+		go func() {
+			defer wg.Done()
+			for namespace := range jobs {
+				review, err := client.GetSelfSubjectRulesReview(ctx, namespace)
+
+				mu.Lock()
+				if err != nil {
+					log.Debugf("Error reviewing the rules of namespace %s: %v", namespace, err)
+					result.Errors[namespace] = err
+				} else {
+					result.Rules[namespace] = review.Status.ResourceRules
+					if review.Status.Incomplete {
+						result.Incomplete = append(result.Incomplete, namespace)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for i, namespace := range namespaces {
+		select {
+		case jobs <- namespace:
+		case <-ctx.Done():
+			mu.Lock()
+			for _, skipped := range namespaces[i:] {
+				result.Errors[skipped] = ctx.Err()
+			}
+			mu.Unlock()
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	result.Incomplete = mergeSorted(result.Incomplete, nil)
+	return result
+}
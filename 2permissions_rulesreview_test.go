@@ -0,0 +1,93 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kube_fake "k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rulesReviewClient answers the rules reviews with a rule reading the pods of the namespace. The reviews of
+// ns-error fail, and the ones of ns-webhook are incomplete. It records the maximum of reviews in flight.
+func rulesReviewClient(inflight, maxInflight *atomic.Int64) PermissionsClient {
+	k8s := kube_fake.NewSimpleClientset()
+	k8s.PrependReactor("create", "selfsubjectrulesreviews", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		current := inflight.Add(1)
+		defer inflight.Add(-1)
+		for {
+			seen := maxInflight.Load()
+			if current <= seen || maxInflight.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		review := action.(k8s_testing.CreateAction).GetObject().(*auth_v1.SelfSubjectRulesReview).DeepCopy()
+		switch review.Spec.Namespace {
+		case "ns-error":
+			return true, nil, errTestAPIServer
+		case "ns-webhook":
+			review.Status.Incomplete = true
+		}
+		review.Status.ResourceRules = []auth_v1.ResourceRule{{Verbs: []string{"get"}, Resources: []string{"pods"}, ResourceNames: []string{review.Spec.Namespace}}}
+		return true, review, nil
+	})
+	return NewPermissionsClient(k8s)
+}
+
+func TestReviewNamespaceRules(t *testing.T) {
+	var inflight, maxInflight atomic.Int64
+	namespaces := []string{"ns-webhook", "ns-error"}
+	for i := 0; i < 10; i++ {
+		namespaces = append(namespaces, fmt.Sprintf("ns%d", i))
+	}
+
+	result := ReviewNamespaceRules(testCtx, rulesReviewClient(&inflight, &maxInflight), namespaces, 3)
+
+	// Failed namespaces are reported apart, the other results are kept
+	assert.Len(t, result.Rules, 11)
+	assert.Equal(t, []string{"ns3"}, result.Rules["ns3"][0].ResourceNames)
+	require.Len(t, result.Errors, 1)
+	assert.ErrorIs(t, result.Errors["ns-error"], errTestAPIServer)
+	assert.Equal(t, []string{"ns-webhook"}, result.Incomplete)
+	assert.LessOrEqual(t, maxInflight.Load(), int64(3))
+}
+
+func TestReviewNamespaceRulesDefaultParallelism(t *testing.T) {
+	var inflight, maxInflight atomic.Int64
+	namespaces := []string{}
+	for i := 0; i < 2*DefaultRulesReviewParallelism; i++ {
+		namespaces = append(namespaces, fmt.Sprintf("ns%d", i))
+	}
+
+	result := ReviewNamespaceRules(testCtx, rulesReviewClient(&inflight, &maxInflight), namespaces, 0)
+	assert.Len(t, result.Rules, len(namespaces))
+	assert.LessOrEqual(t, maxInflight.Load(), int64(DefaultRulesReviewParallelism))
+}
+
+func TestReviewNamespaceRulesCancelled(t *testing.T) {
+	var inflight, maxInflight atomic.Int64
+	ctx, cancel := context.WithCancel(testCtx)
+	cancel()
+	namespaces := []string{}
+	for i := 0; i < 100; i++ {
+		namespaces = append(namespaces, fmt.Sprintf("ns%d", i))
+	}
+
+	// A namespace may still be dispatched to the idle worker, the remaining ones are skipped
+	result := ReviewNamespaceRules(ctx, rulesReviewClient(&inflight, &maxInflight), namespaces, 1)
+	assert.Equal(t, len(namespaces), len(result.Rules)+len(result.Errors))
+	assert.NotEmpty(t, result.Errors)
+	for namespace, err := range result.Errors {
+		assert.ErrorIs(t, err, context.Canceled, namespace)
+	}
+}
@@ -0,0 +1,231 @@
+package business
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3ReportStoreConfig configures a ReportStore on top of an S3-compatible object storage, e.g. AWS S3,
+// MinIO or Ceph.
+type S3ReportStoreConfig struct {
+	// Endpoint is the URL of the storage, e.g. https://s3.eu-west-1.amazonaws.com or http://minio:9000.
+	// The buckets are addressed in the path, which every S3-compatible storage supports.
+	Endpoint string `yaml:"endpoint"`
+	Region   string `yaml:"region"`
+	Bucket   string `yaml:"bucket"`
+	// Prefix is prepended to the keys of the documents, e.g. "kiali/".
+	Prefix          string `yaml:"prefix"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+// s3ReportStore is a ReportStore keeping the documents as objects, bucket/prefix/kind/name. The requests
+// are signed with AWS Signature Version 4.
+type s3ReportStore struct {
+	conf   S3ReportStoreConfig
+	client *http.Client
+}
+
+// NewS3ReportStore creates a ReportStore keeping the documents in the bucket of an S3-compatible storage.
+func NewS3ReportStore(conf S3ReportStoreConfig) (ReportStore, error) {
+	if _, err := url.Parse(conf.Endpoint); err != nil || conf.Endpoint == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", conf.Endpoint)
+	}
+	if conf.Bucket == "" {
+		return nil, fmt.Errorf("the S3 bucket is required")
+	}
+	if conf.Region == "" {
+		conf.Region = "us-east-1"
+	}
+	conf.Endpoint = strings.TrimSuffix(conf.Endpoint, "/")
+	return &s3ReportStore{conf: conf, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+func (in *s3ReportStore) Put(ctx context.Context, kind, name string, content []byte) error {
+	if err := validateReportKey(kind, name); err != nil {
+		return err
+	}
+	resp, err := in.do(ctx, http.MethodPut, in.conf.Prefix+kind+"/"+name, nil, content)
+	if err != nil {
+		return fmt.Errorf("failed to write report %s/%s: %w", kind, name, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (in *s3ReportStore) Get(ctx context.Context, kind, name string) ([]byte, error) {
+	if err := validateReportKey(kind, name); err != nil {
+		return nil, err
+	}
+	resp, err := in.do(ctx, http.MethodGet, in.conf.Prefix+kind+"/"+name, nil, nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report %s/%s: %w", kind, name, err)
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (in *s3ReportStore) Delete(ctx context.Context, kind, name string) error {
+	if err := validateReportKey(kind, name); err != nil {
+		return err
+	}
+	// S3 answers 204 whether or not the object exists
+	resp, err := in.do(ctx, http.MethodDelete, in.conf.Prefix+kind+"/"+name, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete report %s/%s: %w", kind, name, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3ListResult is the response of ListObjectsV2.
+type s3ListResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (in *s3ReportStore) List(ctx context.Context, kind string) ([]string, error) {
+	if err := validateReportKey(kind, "list"); err != nil {
+		return nil, err
+	}
+	prefix := in.conf.Prefix + kind + "/"
+	names := []string{}
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := in.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list reports of kind %s: %w", kind, err)
+		}
+		result := s3ListResult{}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list reports of kind %s: %w", kind, err)
+		}
+		for _, object := range result.Contents {
+			if name := strings.TrimPrefix(object.Key, prefix); name != "" && !strings.Contains(name, "/") {
+				names = append(names, name)
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// do sends a signed request for the key of the bucket, the bucket itself if the key is empty. Responses
+// other than 2xx are returned with an error.
+func (in *s3ReportStore) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	path := "/" + in.conf.Bucket
+	if key != "" {
+		path += "/" + key
+	}
+	req, err := http.NewRequestWithContext(ctx, method, in.conf.Endpoint+s3URIEncode(path, false), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawQuery = s3CanonicalQuery(query)
+	in.sign(req, path, body, time.Now().UTC())
+
+	resp, err := in.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return resp, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, message)
+	}
+	return resp, nil
+}
+
+// sign adds the AWS Signature Version 4 headers to the request.
+func (in *s3ReportStore) sign(req *http.Request, path string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3URIEncode(path, false),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + in.conf.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + in.conf.SecretAccessKey)
+	for _, part := range []string{date, in.conf.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+in.conf.AccessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// s3CanonicalQuery encodes the query with the keys sorted, as signed by Signature Version 4.
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, s3URIEncode(key, true)+"="+s3URIEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3URIEncode encodes all the characters but the unreserved ones, and the slashes unless encodeSlash.
+func s3URIEncode(s string, encodeSlash bool) string {
+	encoded := strings.Builder{}
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			encoded.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			encoded.WriteByte(b)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,141 @@
+package business
+
+import (
+	"strings"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+)
+
+// ResolutionScope restricts the resolution and reporting of permissions to some API groups and resources,
+// e.g. only Istio and the core networking resources for Kiali. A resource is in scope if its API group is
+// in APIGroups or if it is in Resources. The empty scope includes everything.
+type ResolutionScope struct {
+	// APIGroups are included with all their resources. The core group is "".
+	APIGroups []string `yaml:"api_groups"`
+	// Resources are in group/resource form, or just the resource for the core group (e.g. services,
+	// networking.k8s.io/networkpolicies).
+	Resources []string `yaml:"resources"`
+}
+
+// IsEmpty returns true if the scope includes everything.
+func (in ResolutionScope) IsEmpty() bool {
+	return len(in.APIGroups) == 0 && len(in.Resources) == 0
+}
+
+// Includes returns true if the resource of the API group is in scope.
+func (in ResolutionScope) Includes(apiGroup, resource string) bool {
+	if in.IsEmpty() || containsString(in.APIGroups, apiGroup) {
+		return true
+	}
+	if apiGroup != "" {
+		resource = apiGroup + "/" + resource
+	}
+	return containsString(in.Resources, resource)
+}
+
+// groups returns the API groups having resources in scope, the expansion of the "*" API group.
+func (in ResolutionScope) groups() []string {
+	set := map[string]bool{}
+	for _, group := range in.APIGroups {
+		set[group] = true
+	}
+	for _, resource := range in.Resources {
+		set[scopedResourceGroup(resource)] = true
+	}
+	return sortedKeys(set)
+}
+
+// resourcesOf returns the resources in scope of an API group not included as a whole.
+func (in ResolutionScope) resourcesOf(apiGroup string) []string {
+	resources := []string{}
+	for _, resource := range in.Resources {
+		if scopedResourceGroup(resource) == apiGroup {
+			resources = append(resources, resource[strings.LastIndex(resource, "/")+1:])
+		}
+	}
+	return resources
+}
+
+func scopedResourceGroup(resource string) string {
+	if i := strings.LastIndex(resource, "/"); i >= 0 {
+		return resource[:i]
+	}
+	return ""
+}
+
+// Rules returns the part of the rules in scope. Wildcard API groups and resources are narrowed to the ones
+// in scope, so a rule may be split per API group. Non-resource rules are out of any non-empty scope.
+func (in ResolutionScope) Rules(rules []rbac_v1.PolicyRule) []rbac_v1.PolicyRule {
+	if in.IsEmpty() {
+		return rules
+	}
+	scoped := []rbac_v1.PolicyRule{}
+	for _, rule := range rules {
+		for _, apiGroup := range rule.APIGroups {
+			groups := []string{apiGroup}
+			if apiGroup == "*" {
+				groups = in.groups()
+			}
+			for _, group := range groups {
+				if resources := in.ruleResources(group, rule.Resources); len(resources) > 0 {
+					scoped = append(scoped, rbac_v1.PolicyRule{
+						APIGroups:     []string{group},
+						Resources:     resources,
+						ResourceNames: rule.ResourceNames,
+						Verbs:         rule.Verbs,
+					})
+				}
+			}
+		}
+	}
+	return scoped
+}
+
+// ruleResources returns the resources of a rule in scope for the API group.
+func (in ResolutionScope) ruleResources(apiGroup string, resources []string) []string {
+	if containsString(in.APIGroups, apiGroup) {
+		return resources
+	}
+	scoped := []string{}
+	for _, resource := range resources {
+		res, sub, hasSub := strings.Cut(resource, "/")
+		switch {
+		case res == "*":
+			for _, r := range in.resourcesOf(apiGroup) {
+				if hasSub {
+					r += "/" + sub
+				}
+				scoped = append(scoped, r)
+			}
+		case in.Includes(apiGroup, res):
+			scoped = append(scoped, resource)
+		}
+	}
+	return scoped
+}
+
+// Scoped returns a snapshot whose roles only hold the rules in scope, so the evaluation, reports and
+// who-can helpers ignore the rest. The snapshot is returned as is for the empty scope.
+func (in *RBACSnapshot) Scoped(scope ResolutionScope) *RBACSnapshot {
+	if scope.IsEmpty() {
+		return in
+	}
+	scoped := &RBACSnapshot{
+		ClusterRoles:        make(map[string]*rbac_v1.ClusterRole, len(in.ClusterRoles)),
+		Roles:               make(map[string]*rbac_v1.Role, len(in.Roles)),
+		ClusterRoleBindings: in.ClusterRoleBindings,
+		RoleBindings:        in.RoleBindings,
+		LoadedAt:            in.LoadedAt,
+	}
+	for key, cr := range in.ClusterRoles {
+		copied := *cr
+		copied.Rules = scope.Rules(cr.Rules)
+		scoped.ClusterRoles[key] = &copied
+	}
+	for key, r := range in.Roles {
+		copied := *r
+		copied.Rules = scope.Rules(r.Rules)
+		scoped.Roles[key] = &copied
+	}
+	return scoped
+}
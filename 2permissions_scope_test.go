@@ -0,0 +1,83 @@
+package business
+
+import (
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/rest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testScope = ResolutionScope{
+	APIGroups: []string{"networking.istio.io"},
+	Resources: []string{"services", "networking.k8s.io/networkpolicies"},
+}
+
+func TestResolutionScopeIncludes(t *testing.T) {
+	cases := []struct {
+		apiGroup, resource string
+		included           bool
+	}{
+		{"networking.istio.io", "virtualservices", true},
+		{"", "services", true},
+		{"networking.k8s.io", "networkpolicies", true},
+		{"", "pods", false},
+		{"networking.k8s.io", "ingresses", false},
+		{"apps", "services", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.included, testScope.Includes(c.apiGroup, c.resource), "%s/%s", c.apiGroup, c.resource)
+	}
+	assert.True(t, ResolutionScope{}.IsEmpty())
+	assert.True(t, ResolutionScope{}.Includes("apps", "deployments"))
+}
+
+func TestResolutionScopeRules(t *testing.T) {
+	rules := []rbac_v1.PolicyRule{
+		{APIGroups: []string{"", "apps"}, Resources: []string{"pods", "services", "deployments"}, ResourceNames: []string{"web"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"*"}, Resources: []string{"*", "*/status"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"networking.istio.io"}, Resources: []string{"*"}, Verbs: []string{"watch"}},
+		{NonResourceURLs: []string{"/healthz"}, Verbs: []string{"get"}},
+	}
+
+	assert.Equal(t, []rbac_v1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"services"}, ResourceNames: []string{"web"}, Verbs: []string{"get"}},
+		{APIGroups: []string{""}, Resources: []string{"services", "services/status"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"networking.istio.io"}, Resources: []string{"*", "*/status"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"networking.k8s.io"}, Resources: []string{"networkpolicies", "networkpolicies/status"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"networking.istio.io"}, Resources: []string{"*"}, Verbs: []string{"watch"}},
+	}, testScope.Rules(rules))
+	assert.Equal(t, rules, ResolutionScope{}.Rules(rules))
+}
+
+func TestScopedSnapshot(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+	assert.Same(t, snapshot, snapshot.Scoped(ResolutionScope{}))
+
+	scoped := snapshot.Scoped(ResolutionScope{APIGroups: []string{"apps"}})
+	assert.Empty(t, scoped.EffectivePermissions(UserInfo{Name: "alice"}))
+	assert.Len(t, scoped.EffectivePermissions(UserInfo{Name: "carol", Groups: []string{"developers"}}), 4)
+	// The original snapshot is not modified
+	assert.Len(t, snapshot.EffectivePermissions(UserInfo{Name: "alice"}), 3)
+}
+
+func TestPermissionWatcherResolutionScope(t *testing.T) {
+	watcher, _ := startTestWatcher(t, func(watcher *PermissionWatcher) {
+		watcher.SetResolutionScope(ResolutionScope{APIGroups: []string{"apps"}})
+	})
+
+	assert.Empty(t, watcher.Snapshot().EffectivePermissions(UserInfo{Name: "bob"}))
+	assert.Len(t, watcher.Snapshot().EffectivePermissions(UserInfo{Name: "carol", Groups: []string{"developers"}}), 4)
+}
+
+func TestBuildResourceInventoryScope(t *testing.T) {
+	server, requests := inventoryAPIServer(t)
+
+	inventory, err := BuildResourceInventory(testCtx, &rest.Config{Host: server.URL}, inventoryClient(), UserInfo{Name: "bob"}, InventoryOptions{Scope: ResolutionScope{Resources: []string{"secrets"}}})
+	require.NoError(t, err)
+	assert.Empty(t, inventory.Resources)
+	paths, _ := requests()
+	assert.Empty(t, paths)
+}
@@ -0,0 +1,183 @@
+package business
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	authn_v1 "k8s.io/api/authentication/v1"
+	auth_v1 "k8s.io/api/authorization/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/kiali/kiali/log"
+)
+
+// selfQueryCluster is the cluster of the memoized reviews of the self-query servers.
+const selfQueryCluster = "self-query"
+
+// SelfRules are the rules of the caller in a namespace, see the SelfSubjectRulesReview.
+type SelfRules struct {
+	Namespace        string                    `json:"namespace"`
+	ResourceRules    []auth_v1.ResourceRule    `json:"resourceRules"`
+	NonResourceRules []auth_v1.NonResourceRule `json:"nonResourceRules"`
+	Incomplete       bool                      `json:"incomplete"`
+}
+
+// NewSelfQueryPermissionsServer creates a server only answering the questions of the callers about
+// themselves, with the SelfSubject APIs called with the bearer token of the caller. The server needs no
+// credentials of its own, so it is the safer choice for public-facing deployments: restConfig only
+// provides the address and the CA of the apiserver, its credentials are not used. The routes are:
+//
+//	GET /api/self: the identity of the caller, from a SelfSubjectReview (Kubernetes 1.28+)
+//	GET /api/self/can-i?verb=&resource=&apiGroup=&subresource=&namespace=&name=: a SelfSubjectAccessReview
+//	GET /api/self/rules?namespace=: a SelfSubjectRulesReview
+//
+// Callers are authenticated with a SelfSubjectReview, see SelfSubjectReviewAuthenticator, and can be rate
+// limited, see SetRateLimit. Client certificates cannot be forwarded, so only bearer tokens are supported.
+func NewSelfQueryPermissionsServer(restConfig *rest.Config) *PermissionsServer {
+	server := &PermissionsServer{
+		selfConfig:       rest.AnonymousClientConfig(restConfig),
+		selfReviews:      NewSelfReviewMemo(DefaultSelfReviewTTL),
+		selfRulesReviews: NewAPIRequestLimiter(DefaultMaxConcurrentAPIRequests),
+		router:           mux.NewRouter(),
+	}
+	server.authenticate = SelfSubjectReviewAuthenticator(server.selfConfig)
+
+	server.router.Methods("GET").Path("/healthz").Name("Healthz").HandlerFunc(server.healthz)
+	server.router.Methods("GET").Path("/readyz").Name("Readyz").HandlerFunc(server.readyz)
+	server.router.Methods("GET").Path("/api/self").Name("Self").HandlerFunc(server.guard(server.self, nil))
+	server.router.Methods("GET").Path("/api/self/can-i").Name("SelfCanI").HandlerFunc(server.guard(server.selfCanI, nil))
+	server.router.Methods("GET").Path("/api/self/rules").Name("SelfRules").HandlerFunc(server.guard(server.selfRules, nil))
+
+	return server
+}
+
+// SelfSubjectReviewAuthenticator authenticates the bearer tokens with SelfSubjectReviews made with the
+// tokens themselves, so no credentials are needed, unlike BearerTokenAuthenticator. The credentials of
+// restConfig are replaced by the tokens. The identities are cached like by BearerTokenAuthenticator.
+func SelfSubjectReviewAuthenticator(restConfig *rest.Config) Authenticator {
+	return cachedTokenAuthenticator(func(ctx context.Context, token string) (UserInfo, error) {
+		k8s, err := tokenClient(restConfig, token)
+		if err != nil {
+			return UserInfo{}, err
+		}
+		review, err := k8s.AuthenticationV1().SelfSubjectReviews().Create(ctx, &authn_v1.SelfSubjectReview{}, meta_v1.CreateOptions{})
+		if err != nil {
+			return UserInfo{}, withRequestID(ctx, fmt.Errorf("error reviewing token: %w", err))
+		}
+		user := UserInfo{Name: review.Status.UserInfo.Username, Groups: review.Status.UserInfo.Groups}
+		if len(review.Status.UserInfo.Extra) > 0 {
+			user.Extra = make(map[string][]string, len(review.Status.UserInfo.Extra))
+			for k, v := range review.Status.UserInfo.Extra {
+				user.Extra[k] = v
+			}
+		}
+		return user, nil
+	})
+}
+
+// tokenClient returns a client authenticated with the token only.
+func tokenClient(restConfig *rest.Config, token string) (kube.Interface, error) {
+	config := rest.AnonymousClientConfig(restConfig)
+	config.BearerToken = token
+	k8s, err := kube.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating client: %w", err)
+	}
+	return k8s, nil
+}
+
+// tokenIdentity identifies the client of a token in the memoized reviews, by the hash of the token so
+// the tokens are not kept in memory.
+func tokenIdentity(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:])
+}
+
+// callerClient returns the client of the caller of the request, or writes an error response and
+// returns false.
+func (in *PermissionsServer) callerClient(w http.ResponseWriter, r *http.Request) (PermissionsClient, bool) {
+	token, ok := bearerToken(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	k8s, err := tokenClient(in.selfConfig, token)
+	if err != nil {
+		log.Errorf("%s%v", logPrefix(requestContext(r)), err)
+		http.Error(w, "error creating client", http.StatusInternalServerError)
+		return nil, false
+	}
+	return NewPermissionsClient(k8s), true
+}
+
+func (in *PermissionsServer) self(w http.ResponseWriter, r *http.Request) {
+	caller, _ := UserFromContext(r.Context())
+	WriteOutput(w, r, caller, nil)
+}
+
+func (in *PermissionsServer) selfCanI(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	req, ok := accessRequestFromQuery(w, r)
+	if !ok {
+		return
+	}
+	client, ok := in.callerClient(w, r)
+	if !ok {
+		return
+	}
+	// The answers depend on the token, e.g. on its scopes, not only on the user
+	token, _ := bearerToken(r)
+	decision, err := in.selfReviews.Review(ctx, client, selfQueryCluster, tokenIdentity(token), req)
+	if err != nil {
+		selfQueryError(w, err)
+		return
+	}
+	WriteOutput(w, r, decision, nil)
+}
+
+func (in *PermissionsServer) selfRules(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	caller, _ := UserFromContext(r.Context())
+	client, ok := in.callerClient(w, r)
+	if !ok {
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+	if err := in.selfRulesReviews.acquire(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	review, err := client.GetSelfSubjectRulesReview(ctx, namespace)
+	in.selfRulesReviews.release()
+	if err != nil {
+		log.Debugf("%sError reviewing the rules of %s in namespace %s: %v", logPrefix(ctx), redactedUser(caller.Name), namespace, err)
+		selfQueryError(w, err)
+		return
+	}
+	WriteOutput(w, r, SelfRules{
+		Namespace:        namespace,
+		ResourceRules:    review.Status.ResourceRules,
+		NonResourceRules: review.Status.NonResourceRules,
+		Incomplete:       review.Status.Incomplete,
+	}, nil)
+}
+
+// selfQueryError writes the error of a SelfSubject API call: the rejections of the token by the
+// apiserver are passed on, the other errors are a 502.
+func selfQueryError(w http.ResponseWriter, err error) {
+	switch {
+	case k8s_errors.IsUnauthorized(err):
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	case k8s_errors.IsForbidden(err):
+		http.Error(w, "forbidden", http.StatusForbidden)
+	default:
+		http.Error(w, "error querying the apiserver", http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,161 @@
+package business
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	authn_v1 "k8s.io/api/authentication/v1"
+	auth_v1 "k8s.io/api/authorization/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfQueryAPIServer answers the SelfSubject APIs for the tokens of the map, allowing alice to read the
+// pods of ns1, and counts the access reviews. It fails the test if the server credentials are used.
+func selfQueryAPIServer(t *testing.T, tokens map[string]string, accessReviews *atomic.Int64) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := bearerToken(r)
+		if token == "server-token" {
+			t.Errorf("the server credentials were used for %s", r.URL.Path)
+		}
+		user, ok := tokens[token]
+		if !ok {
+			status := k8s_errors.NewUnauthorized("invalid bearer token").ErrStatus
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(status)
+			return
+		}
+
+		var response interface{}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/selfsubjectreviews"):
+			review := authn_v1.SelfSubjectReview{Status: authn_v1.SelfSubjectReviewStatus{UserInfo: authn_v1.UserInfo{Username: user, Groups: []string{"system:authenticated"}}}}
+			review.APIVersion, review.Kind = "authentication.k8s.io/v1", "SelfSubjectReview"
+			response = review
+		case strings.HasSuffix(r.URL.Path, "/selfsubjectaccessreviews"):
+			var review auth_v1.SelfSubjectAccessReview
+			if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			accessReviews.Add(1)
+			attrs := review.Spec.ResourceAttributes
+			review.APIVersion, review.Kind = "authorization.k8s.io/v1", "SelfSubjectAccessReview"
+			review.Status.Allowed = user == "alice" && attrs.Resource == "pods" && attrs.Namespace == "ns1"
+			response = review
+		case strings.HasSuffix(r.URL.Path, "/selfsubjectrulesreviews"):
+			var review auth_v1.SelfSubjectRulesReview
+			if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			review.APIVersion, review.Kind = "authorization.k8s.io/v1", "SelfSubjectRulesReview"
+			if user == "alice" && review.Spec.Namespace == "ns1" {
+				review.Status.ResourceRules = []auth_v1.ResourceRule{{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"pods"}}}
+			}
+			review.Status.NonResourceRules = []auth_v1.NonResourceRule{{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz"}}}
+			response = review
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// serveWithToken serves the GET request with the bearer token.
+func serveWithToken(server *PermissionsServer, target, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func newTestSelfQueryServer(t *testing.T, accessReviews *atomic.Int64) *PermissionsServer {
+	apiServer := selfQueryAPIServer(t, map[string]string{"alice-token": "alice", "bob-token": "bob"}, accessReviews)
+	return NewSelfQueryPermissionsServer(&rest.Config{Host: apiServer.URL, BearerToken: "server-token", ContentConfig: rest.ContentConfig{ContentType: "application/json"}})
+}
+
+func TestSelfQueryServerIdentity(t *testing.T) {
+	server := newTestSelfQueryServer(t, &atomic.Int64{})
+
+	w := serveWithToken(server, "/api/self", "alice-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var user UserInfo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &user))
+	assert.Equal(t, UserInfo{Name: "alice", Groups: []string{"system:authenticated"}}, user)
+
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(server, "/api/self", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serveWithToken(server, "/api/self", "stolen-token").Code)
+}
+
+func TestSelfQueryServerCanI(t *testing.T) {
+	accessReviews := &atomic.Int64{}
+	server := newTestSelfQueryServer(t, accessReviews)
+	canI := func(query, token string) Decision {
+		w := serveWithToken(server, "/api/self/can-i?"+query, token)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var decision Decision
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &decision))
+		return decision
+	}
+
+	assert.True(t, canI("verb=get&resource=pods&namespace=ns1", "alice-token").Allowed)
+	assert.False(t, canI("verb=get&resource=pods&namespace=ns2", "alice-token").Allowed)
+	assert.False(t, canI("verb=get&resource=pods&namespace=ns1", "bob-token").Allowed)
+	assert.Equal(t, int64(3), accessReviews.Load())
+
+	// The answers are memoized per token
+	assert.True(t, canI("verb=get&resource=pods&namespace=ns1", "alice-token").Allowed)
+	assert.Equal(t, int64(3), accessReviews.Load())
+
+	assert.Equal(t, http.StatusBadRequest, serveWithToken(server, "/api/self/can-i?resource=pods", "alice-token").Code)
+}
+
+func TestSelfQueryServerRules(t *testing.T) {
+	server := newTestSelfQueryServer(t, &atomic.Int64{})
+
+	w := serveWithToken(server, "/api/self/rules?namespace=ns1", "alice-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var rules SelfRules
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rules))
+	assert.Equal(t, SelfRules{
+		Namespace:        "ns1",
+		ResourceRules:    []auth_v1.ResourceRule{{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+		NonResourceRules: []auth_v1.NonResourceRule{{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz"}}},
+	}, rules)
+
+	// The server only answers for the caller
+	assert.Equal(t, http.StatusNotFound, serveWithToken(server, "/api/permissions/alice", "alice-token").Code)
+}
+
+func TestSelfQueryError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{err: k8s_errors.NewUnauthorized("token expired"), code: http.StatusUnauthorized},
+		{err: k8s_errors.NewForbidden(schema.GroupResource{Group: "authorization.k8s.io", Resource: "selfsubjectrulesreviews"}, "", errTestAPIServer), code: http.StatusForbidden},
+		{err: errTestAPIServer, code: http.StatusBadGateway},
+	} {
+		w := httptest.NewRecorder()
+		selfQueryError(w, tc.err)
+		assert.Equal(t, tc.code, w.Code, "%v", tc.err)
+	}
+}
@@ -0,0 +1,225 @@
+package business
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	auth_v1 "k8s.io/api/authorization/v1"
+
+	"github.com/kiali/kiali/log"
+)
+
+// DefaultSelfReviewTTL is how long the results of the self access reviews of CheckUserPermissionsDecision
+// are memoized.
+const DefaultSelfReviewTTL = 5 * time.Minute
+
+// DefaultSelfReviewMaxEntries bounds the memoized reviews of a SelfReviewMemo.
+const DefaultSelfReviewMaxEntries = 10000
+
+// selfReviewTimeout bounds a shared self review, which does not stop with the context of the first caller.
+const selfReviewTimeout = 30 * time.Second
+
+// selfReviewKey is the complete attribute tuple of a self access review. The user is the identity of the
+// client: the answer of the apiserver depends on it.
+type selfReviewKey struct {
+	Cluster       string
+	User          string
+	Namespace     string
+	APIGroup      string
+	Resource      string
+	Subresource   string
+	Name          string
+	Verb          string
+	FieldSelector string
+	LabelSelector string
+}
+
+type memoizedReview struct {
+	key      selfReviewKey
+	decision Decision
+	expires  time.Time
+	// username is the user the client was said to belong to, see ClearUserPermissions.
+	username string
+}
+
+// SelfReviewMemo memoizes the results of SelfSubjectAccessReviews, keyed by the complete attribute tuple,
+// so identical repeat questions within the TTL never hit the apiserver. Concurrent identical questions
+// share a single review. Errors are not memoized. Beyond DefaultSelfReviewMaxEntries, the least recently
+// used reviews are dropped. It is safe for concurrent use.
+type SelfReviewMemo struct {
+	ttl        time.Duration
+	maxEntries int
+	flight     singleflight.Group
+
+	mu sync.Mutex
+	// entries holds the elements of lru, whose values are *memoizedReview, most recently used first
+	entries map[selfReviewKey]*list.Element
+	lru     *list.List
+}
+
+// selfReviews is the memo of CheckUserPermissionsDecision.
+var selfReviews = NewSelfReviewMemo(DefaultSelfReviewTTL)
+
+// NewSelfReviewMemo creates a memo keeping the results for ttl.
+func NewSelfReviewMemo(ttl time.Duration) *SelfReviewMemo {
+	return &SelfReviewMemo{ttl: ttl, maxEntries: DefaultSelfReviewMaxEntries, entries: map[selfReviewKey]*list.Element{}, lru: list.New()}
+}
+
+// Review returns the decision of the apiserver of the cluster on the request of identity, the identity of
+// the client, reviewing it only if no identical review was made within the TTL. Clients whose credentials
+// change the answers, e.g. scoped tokens, are identified by their credentials, see tokenIdentity. Memoized decisions have
+// the DecisionSourceCache source. Memoized decisions older than the max staleness of the context, see
+// WithMaxStaleness, are reviewed again. An empty identity is never memoized.
+func (in *SelfReviewMemo) Review(ctx context.Context, client PermissionsClient, cluster, identity string, req AccessRequest) (Decision, error) {
+	return in.review(ctx, client, cluster, identity, "", req)
+}
+
+// review is Review, recording username as the user of the memoized decision, see Forget.
+func (in *SelfReviewMemo) review(ctx context.Context, client PermissionsClient, cluster, identity, username string, req AccessRequest) (Decision, error) {
+	if identity == "" {
+		decision, err := reviewSelf(ctx, client, req)
+		if err != nil {
+			return selfReviewError(ctx, username, req, err)
+		}
+		return decision, nil
+	}
+
+	key := selfReviewKey{
+		Cluster:       cluster,
+		User:          identity,
+		Namespace:     req.Namespace,
+		APIGroup:      req.APIGroup,
+		Resource:      req.Resource,
+		Subresource:   req.Subresource,
+		Name:          req.Name,
+		Verb:          req.Verb,
+		FieldSelector: req.FieldSelector,
+		LabelSelector: req.LabelSelector,
+	}
+
+	if decision, ok := in.get(ctx, key); ok {
+		return decision, nil
+	}
+
+	// The review is shared by the concurrent callers, it does not stop with the context of the first one
+	results := in.flight.DoChan(fmt.Sprintf("%#v", key), func() (interface{}, error) {
+		reviewCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), selfReviewTimeout)
+		defer cancel()
+		decision, err := reviewSelf(reviewCtx, client, req)
+		if err != nil {
+			return nil, err
+		}
+		in.put(&memoizedReview{key: key, decision: decision, expires: decision.Timestamp.Add(in.ttl), username: username})
+		return decision, nil
+	})
+	reviewed := username
+	if reviewed == "" {
+		reviewed = identity
+	}
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return selfReviewError(ctx, reviewed, req, result.Err)
+		}
+		return result.Val.(Decision), nil
+	case <-ctx.Done():
+		return selfReviewError(ctx, reviewed, req, ctx.Err())
+	}
+}
+
+// get returns the memoized decision of the review, if it is not expired and fresh enough for the context.
+func (in *SelfReviewMemo) get(ctx context.Context, key selfReviewKey) (Decision, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	element, ok := in.entries[key]
+	if !ok {
+		return Decision{}, false
+	}
+	entry := element.Value.(*memoizedReview)
+	if !time.Now().Before(entry.expires) || !freshEnough(ctx, entry.decision) {
+		return Decision{}, false
+	}
+	in.lru.MoveToFront(element)
+	decision := entry.decision
+	decision.Source = DecisionSourceCache
+	return decision, true
+}
+
+// put memoizes the review, dropping the least recently used one when the memo is full.
+func (in *SelfReviewMemo) put(entry *memoizedReview) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if element, ok := in.entries[entry.key]; ok {
+		element.Value = entry
+		in.lru.MoveToFront(element)
+		return
+	}
+	in.entries[entry.key] = in.lru.PushFront(entry)
+	if in.maxEntries > 0 && in.lru.Len() > in.maxEntries {
+		in.remove(in.lru.Back())
+	}
+}
+
+// remove drops the memoized review of the element. The caller holds the lock.
+func (in *SelfReviewMemo) remove(element *list.Element) {
+	in.lru.Remove(element)
+	delete(in.entries, element.Value.(*memoizedReview).key)
+}
+
+// reviewSelf asks the apiserver whether the identity of the client can make the request.
+func reviewSelf(ctx context.Context, client PermissionsClient, req AccessRequest) (Decision, error) {
+	ssar := &auth_v1.SelfSubjectAccessReview{
+		Spec: auth_v1.SelfSubjectAccessReviewSpec{ResourceAttributes: subjectAccessReviewFor(UserInfo{}, req).Spec.ResourceAttributes},
+	}
+	review, err := createSelfSubjectAccessReview(ctx, client, ssar)
+	if err != nil {
+		return Decision{}, err
+	}
+	return Decision{
+		Allowed:         review.Status.Allowed,
+		Denied:          review.Status.Denied,
+		Reason:          review.Status.Reason,
+		EvaluationError: review.Status.EvaluationError,
+		Source:          DecisionSourceAPIServer,
+		Timestamp:       time.Now(),
+	}, nil
+}
+
+// selfReviewError logs the error of the review of the request of username, and returns its decision and error.
+func selfReviewError(ctx context.Context, username string, req AccessRequest, err error) (Decision, error) {
+	log.Errorf("%sError reviewing %s of %s for user %s: %v", logPrefix(ctx), req.Verb, req.Resource, redactedUser(username), err)
+	return Decision{Source: DecisionSourceAPIServer, EvaluationError: err.Error(), Timestamp: time.Now()}, withRequestID(ctx, fmt.Errorf("error checking permissions: %w", err))
+}
+
+// len returns the number of memoized reviews, including the expired ones not dropped yet.
+func (in *SelfReviewMemo) len() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.lru.Len()
+}
+
+// Forget drops the memoized reviews of the user, in all the clusters: the reviews of the identity, and the
+// ones of the clients said to belong to the user.
+func (in *SelfReviewMemo) Forget(username string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for element := in.lru.Front(); element != nil; {
+		next := element.Next()
+		if entry := element.Value.(*memoizedReview); entry.key.User == username || entry.username == username {
+			in.remove(element)
+		}
+		element = next
+	}
+}
+
+// Purge drops all the memoized reviews, including the expired ones.
+func (in *SelfReviewMemo) Purge() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.entries = map[selfReviewKey]*list.Element{}
+	in.lru.Init()
+}
@@ -0,0 +1,280 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfReviewMemoizesRepeatQuestions(t *testing.T) {
+	reviews := &testReviews{allow: allowUsers("self")}
+	client := newTestClient(reviews)
+	memo := NewSelfReviewMemo(time.Hour)
+	pods := AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}
+
+	decision, err := memo.Review(testCtx, client, "", "alice", pods)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, DecisionSourceAPIServer, decision.Source)
+
+	decision, err = memo.Review(testCtx, client, "", "alice", pods)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, DecisionSourceCache, decision.Source)
+	assert.Equal(t, int64(1), reviews.calls.Load())
+
+	// Another identity asks again
+	_, err = memo.Review(testCtx, client, "", "bob", pods)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), reviews.calls.Load())
+
+	memo.Forget("alice")
+	_, err = memo.Review(testCtx, client, "", "alice", pods)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), reviews.calls.Load())
+}
+
+func TestSelfReviewMemoKeysTheCompleteAttributes(t *testing.T) {
+	reviews := &testReviews{allow: allowUsers("self")}
+	client := newTestClient(reviews)
+	memo := NewSelfReviewMemo(time.Hour)
+	pods := AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}
+
+	requests := []AccessRequest{pods}
+	for _, vary := range []func(*AccessRequest){
+		func(req *AccessRequest) { req.Namespace = "ns2" },
+		func(req *AccessRequest) { req.APIGroup = "metrics.k8s.io" },
+		func(req *AccessRequest) { req.Subresource = "log" },
+		func(req *AccessRequest) { req.Name = "web" },
+		func(req *AccessRequest) { req.Verb = "list" },
+		func(req *AccessRequest) { req.FieldSelector = "spec.nodeName=node1" },
+		func(req *AccessRequest) { req.LabelSelector = "app=web" },
+	} {
+		req := pods
+		vary(&req)
+		requests = append(requests, req)
+	}
+	for _, req := range requests {
+		decision, err := memo.Review(testCtx, client, "", "alice", req)
+		require.NoError(t, err)
+		assert.Equal(t, DecisionSourceAPIServer, decision.Source, "%+v", req)
+	}
+	// The same question in another cluster
+	_, err := memo.Review(testCtx, client, "west", "alice", pods)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(requests)+1), reviews.calls.Load())
+}
+
+func TestSelfReviewMemoExpires(t *testing.T) {
+	reviews := &testReviews{allow: allowUsers("self")}
+	client := newTestClient(reviews)
+	memo := NewSelfReviewMemo(time.Millisecond)
+
+	_, err := memo.Review(testCtx, client, "", "alice", AccessRequest{Resource: "pods", Verb: "get"})
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	decision, err := memo.Review(testCtx, client, "", "alice", AccessRequest{Resource: "pods", Verb: "get"})
+	require.NoError(t, err)
+	assert.Equal(t, DecisionSourceAPIServer, decision.Source)
+	assert.Equal(t, int64(2), reviews.calls.Load())
+}
+
+func TestSelfReviewMemoSharesConcurrentReviews(t *testing.T) {
+	release := make(chan struct{})
+	reviews := &testReviews{allow: func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+		<-release
+		return true
+	}}
+	client := newTestClient(reviews)
+	memo := NewSelfReviewMemo(time.Hour)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			decision, err := memo.Review(testCtx, client, "", "alice", AccessRequest{Resource: "pods", Verb: "get"})
+			assert.NoError(t, err)
+			assert.True(t, decision.Allowed)
+		}()
+	}
+	require.Eventually(t, func() bool { return reviews.calls.Load() == 1 }, 5*time.Second, time.Millisecond)
+	// Let the other questions join the review in flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int64(1), reviews.calls.Load())
+}
+
+func TestCheckUserPermissionsDecisionIsMemoized(t *testing.T) {
+	t.Cleanup(selfReviews.Purge)
+	reviews := &testReviews{allow: allowUsers("self")}
+	client := newTestClient(reviews)
+
+	for i := 0; i < 3; i++ {
+		decision, err := CheckUserPermissionsDecision(testCtx, client, "", "memoized-user", "pods", "list")
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+	}
+	assert.Equal(t, int64(1), reviews.calls.Load())
+
+	ClearUserPermissions("memoized-user")
+	_, err := CheckUserPermissionsDecision(testCtx, client, "", "memoized-user", "pods", "list")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), reviews.calls.Load())
+}
+
+func TestCheckUserPermissionsDecisionIsMemoizedPerClusterAndClient(t *testing.T) {
+	t.Cleanup(selfReviews.Purge)
+	const username = "multi-cluster-user"
+	eastReviews := &testReviews{allow: allowUsers("self")}
+	westReviews := &testReviews{}
+	east, west := newTestClient(eastReviews), newTestClient(westReviews)
+
+	// The same user is only allowed in one of the clusters
+	for i := 0; i < 2; i++ {
+		decision, err := CheckUserPermissionsDecision(testCtx, east, "east", username, "pods", "list")
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+		decision, err = CheckUserPermissionsDecision(testCtx, west, "west", username, "pods", "list")
+		require.NoError(t, err)
+		assert.False(t, decision.Allowed)
+	}
+	assert.Equal(t, int64(1), eastReviews.calls.Load())
+	assert.Equal(t, int64(1), westReviews.calls.Load())
+
+	// Another client of the cluster, e.g. with another token, is reviewed whatever the user given
+	_, err := CheckUserPermissionsDecision(testCtx, newTestClient(eastReviews), "east", username, "pods", "list")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), eastReviews.calls.Load())
+
+	ClearUserPermissions(username)
+	_, err = CheckUserPermissionsDecision(testCtx, east, "east", username, "pods", "list")
+	require.NoError(t, err)
+	_, err = CheckUserPermissionsDecision(testCtx, west, "west", username, "pods", "list")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), eastReviews.calls.Load())
+	assert.Equal(t, int64(2), westReviews.calls.Load())
+}
+
+func TestSelfReviewDoesNotMemoizeErrors(t *testing.T) {
+	reviews := &testReviews{err: errTestAPIServer}
+	client := newTestClient(reviews)
+	memo := NewSelfReviewMemo(time.Hour)
+
+	_, err := memo.Review(testCtx, client, "", "alice", AccessRequest{Resource: "pods", Verb: "get"})
+	assert.Error(t, err)
+	_, err = memo.Review(testCtx, client, "", "alice", AccessRequest{Resource: "pods", Verb: "get"})
+	assert.Error(t, err)
+	assert.Equal(t, int64(2), reviews.calls.Load())
+	assert.Zero(t, memo.len())
+}
+
+func TestSelfReviewDoesNotMemoizeUnknownIdentities(t *testing.T) {
+	reviews := &testReviews{allow: allowUsers("self")}
+	client := newTestClient(reviews)
+	memo := NewSelfReviewMemo(time.Hour)
+
+	for i := 0; i < 2; i++ {
+		decision, err := memo.Review(testCtx, client, "", "", AccessRequest{Resource: "pods", Verb: "get"})
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+	}
+	assert.Equal(t, int64(2), reviews.calls.Load())
+	assert.Zero(t, memo.len())
+}
+
+func TestSelfReviewMemoIsBounded(t *testing.T) {
+	client := newTestClient(&testReviews{allow: allowUsers("self")})
+	memo := NewSelfReviewMemo(time.Hour)
+	memo.maxEntries = 10
+
+	for i := 0; i < 50; i++ {
+		_, err := memo.Review(testCtx, client, "", fmt.Sprintf("token:%d", i), AccessRequest{Resource: "pods", Verb: "get"})
+		require.NoError(t, err)
+		assert.LessOrEqual(t, memo.len(), 10)
+	}
+
+	// The latest reviews are kept
+	memo.mu.Lock()
+	_, ok := memo.entries[selfReviewKey{User: "token:49", Resource: "pods", Verb: "get"}]
+	memo.mu.Unlock()
+	assert.True(t, ok)
+}
+
+func TestSelfReviewMemoDropsTheLeastRecentlyUsedReviews(t *testing.T) {
+	reviews := &testReviews{allow: allowUsers("self")}
+	client := newTestClient(reviews)
+	memo := NewSelfReviewMemo(time.Hour)
+	memo.maxEntries = 2
+	review := func(identity string) {
+		_, err := memo.Review(testCtx, client, "", identity, AccessRequest{Resource: "pods", Verb: "get"})
+		require.NoError(t, err)
+	}
+
+	review("token:a")
+	review("token:b")
+	// a is used again, so b is dropped for c
+	review("token:a")
+	review("token:c")
+	assert.Equal(t, 2, memo.len())
+	assert.Equal(t, int64(3), reviews.calls.Load())
+	review("token:a")
+	assert.Equal(t, int64(3), reviews.calls.Load())
+	review("token:b")
+	assert.Equal(t, int64(4), reviews.calls.Load())
+}
+
+func TestSelfReviewMemoSharesTheReviewBeyondTheFirstCaller(t *testing.T) {
+	release := make(chan struct{})
+	reviews := &testReviews{allow: func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+		<-release
+		return true
+	}}
+	client := newTestClient(reviews)
+	memo := NewSelfReviewMemo(time.Hour)
+	pods := AccessRequest{Resource: "pods", Verb: "get"}
+
+	// The review survives the caller that started it
+	ctx, cancel := context.WithCancel(testCtx)
+	cancelled := make(chan error)
+	go func() {
+		_, err := memo.Review(ctx, client, "", "alice", pods)
+		cancelled <- err
+	}()
+	require.Eventually(t, func() bool { return reviews.calls.Load() == 1 }, 5*time.Second, time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			decision, err := memo.Review(testCtx, client, "", "alice", pods)
+			assert.NoError(t, err)
+			assert.True(t, decision.Allowed)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int64(1), reviews.calls.Load())
+}
+
+func TestTokenIdentity(t *testing.T) {
+	identity := tokenIdentity("secret-token")
+
+	assert.NotContains(t, identity, "secret-token")
+	assert.True(t, strings.HasPrefix(identity, "token:"))
+	assert.Equal(t, identity, tokenIdentity("secret-token"))
+	assert.NotEqual(t, identity, tokenIdentity("other-token"))
+}
@@ -0,0 +1,69 @@
+package business
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/rest"
+)
+
+// PermissionsServer exposes the permission subsystem over HTTP, when it runs as a sidecar or a
+// standalone server rather than embedded in Kiali.
+type PermissionsServer struct {
+	checker *PermissionChecker
+	client  PermissionsClient
+	// watcher is optional; without it there are no informers to report about.
+	watcher *PermissionWatcher
+	router  *mux.Router
+	// selfConfig is the config without credentials of the self-query servers, see
+	// NewSelfQueryPermissionsServer. The checker, client and watcher are nil then.
+	selfConfig  *rest.Config
+	selfReviews *SelfReviewMemo
+	// selfRulesReviews bounds the concurrent rules reviews of the self-query servers.
+	selfRulesReviews *APIRequestLimiter
+	// authenticate resolves the caller of a request; see SetAuthenticator.
+	authMu       sync.RWMutex
+	authenticate Authenticator
+
+	// listNamespaces resolves the namespace selectors of the queries; see SetNamespaceLister.
+	namespacesMu   sync.RWMutex
+	listNamespaces NamespaceLister
+
+	// limiters are the rate limiters of the clients, see SetRateLimit.
+	limitersMu sync.Mutex
+	rateLimit  rate.Limit
+	rateBurst  int
+	limiters   map[string]*clientLimiter
+}
+
+// NewPermissionsServer creates the server and registers its routes. The watcher can be nil. The API
+// routes need an authenticator, see SetAuthenticator, and reading the permissions of other users needs
+// access to the UserPermissionsResource; the health routes are open.
+func NewPermissionsServer(client PermissionsClient, checker *PermissionChecker, watcher *PermissionWatcher) *PermissionsServer {
+	server := &PermissionsServer{
+		checker: checker,
+		client:  client,
+		watcher: watcher,
+		router:  mux.NewRouter(),
+	}
+
+	server.router.Methods("GET").Path("/healthz").Name("Healthz").HandlerFunc(server.healthz)
+	server.router.Methods("GET").Path("/readyz").Name("Readyz").HandlerFunc(server.readyz)
+	server.router.Methods("GET").Path("/api/access-feed").Name("AccessFeed").HandlerFunc(server.guard(AccessFeedHandler(client), listAllUserPermissions))
+	server.router.Methods("GET").Path("/api/permissions/stream").Name("PermissionsStream").HandlerFunc(server.guard(server.streamPermissions, nil))
+	server.router.Methods("GET").Path("/api/permissions/hash").Name("PermissionsHash").HandlerFunc(server.guard(server.permissionsHash, nil))
+	server.router.Methods("GET").Path("/api/who-can").Name("WhoCan").HandlerFunc(server.guard(server.whoCan, listAllUserPermissions))
+	server.router.Methods("POST").Path("/api/permissions/prefetch").Name("PermissionsPrefetch").HandlerFunc(server.guard(server.prefetch, nil))
+	server.router.Methods("GET").Path("/api/permissions-matrix").Name("PermissionMatrix").HandlerFunc(server.guard(server.permissionMatrix, listUserPermissions))
+	// Registered last, so the routes above are not taken for user names
+	server.router.Methods("GET").Path("/api/permissions/{user}").Name("UserPermissions").HandlerFunc(server.guard(server.userPermissions, readUserPermissions))
+
+	return server
+}
+
+// Handler returns the HTTP handler serving all the routes of the server.
+func (in *PermissionsServer) Handler() http.Handler {
+	return in.router
+}
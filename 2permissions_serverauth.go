@@ -0,0 +1,305 @@
+package business
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+	kube "k8s.io/client-go/kubernetes"
+
+	"github.com/kiali/kiali/log"
+)
+
+// PermissionsAPIGroup and UserPermissionsResource form the virtual resource authorizing the callers of
+// the PermissionsServer to read the permissions of other users: get on the name of the user, or list for
+// the endpoints reporting on every user, e.g. the access feed. Callers can always read their own.
+const (
+	PermissionsAPIGroup     = "permissions.kiali.io"
+	UserPermissionsResource = "userpermissions"
+)
+
+// DefaultTokenReviewCacheTTL is how long the identities of the bearer tokens are cached, so polling
+// clients do not cause a TokenReview per request.
+const DefaultTokenReviewCacheTTL = time.Minute
+
+// rateLimiterIdleTimeout is how long the rate limiter of an idle client is kept.
+const rateLimiterIdleTimeout = 10 * time.Minute
+
+// ErrNoCredentials is returned by the authenticators for the requests without their credentials.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Authenticator resolves the caller of an HTTP request.
+type Authenticator func(r *http.Request) (UserInfo, error)
+
+// BearerTokenAuthenticator authenticates the bearer tokens of the Authorization header with TokenReviews.
+// The identities are cached for DefaultTokenReviewCacheTTL, or until the tokens expire if sooner.
+func BearerTokenAuthenticator(k8s kube.Interface) Authenticator {
+	return cachedTokenAuthenticator(func(ctx context.Context, token string) (UserInfo, error) {
+		return reviewToken(ctx, k8s, token)
+	})
+}
+
+// cachedTokenAuthenticator authenticates the bearer tokens with review, caching the identities like
+// BearerTokenAuthenticator.
+func cachedTokenAuthenticator(review func(ctx context.Context, token string) (UserInfo, error)) Authenticator {
+	cache := newTokenIdentityCache(review)
+	return func(r *http.Request) (UserInfo, error) {
+		token, ok := bearerToken(r)
+		if !ok {
+			return UserInfo{}, ErrNoCredentials
+		}
+		return cache.resolve(requestContext(r), token)
+	}
+}
+
+// tokenIdentityCache caches the identities of the tokens authenticated by review, for
+// DefaultTokenReviewCacheTTL or until the tokens expire if sooner.
+type tokenIdentityCache struct {
+	review func(ctx context.Context, token string) (UserInfo, error)
+
+	mu sync.Mutex
+	// Only the hashes of the tokens are kept in memory
+	entries map[[sha256.Size]byte]cachedIdentity
+}
+
+type cachedIdentity struct {
+	user   UserInfo
+	expiry time.Time
+}
+
+func newTokenIdentityCache(review func(ctx context.Context, token string) (UserInfo, error)) *tokenIdentityCache {
+	return &tokenIdentityCache{review: review, entries: map[[sha256.Size]byte]cachedIdentity{}}
+}
+
+// resolve returns the cached identity of the token, or reviews it.
+func (in *tokenIdentityCache) resolve(ctx context.Context, token string) (UserInfo, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+	in.mu.Lock()
+	cached, ok := in.entries[key]
+	in.mu.Unlock()
+	if ok && now.Before(cached.expiry) {
+		return cached.user, nil
+	}
+
+	user, err := in.review(ctx, token)
+	if err != nil {
+		return UserInfo{}, err
+	}
+	expiry := now.Add(DefaultTokenReviewCacheTTL)
+	if tokenExpiry, ok := tokenExpiry(token); ok && tokenExpiry.Before(expiry) {
+		expiry = tokenExpiry
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for k, identity := range in.entries {
+		if !now.Before(identity.expiry) {
+			delete(in.entries, k)
+		}
+	}
+	in.entries[key] = cachedIdentity{user: user, expiry: expiry}
+	return user, nil
+}
+
+// bearerToken returns the bearer token of the Authorization header of the request.
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
+
+// FirstAuthenticator tries the authenticators in order, e.g. the client certificate with
+// UserInfoFromRequest and then the bearer token. The first one finding credentials decides: a request
+// with an invalid client certificate is not authenticated by its token.
+func FirstAuthenticator(authenticators ...Authenticator) Authenticator {
+	return func(r *http.Request) (UserInfo, error) {
+		for _, authenticate := range authenticators {
+			user, err := authenticate(r)
+			if errors.Is(err, ErrNoCredentials) || errors.Is(err, ErrNoClientCertificate) {
+				continue
+			}
+			return user, err
+		}
+		return UserInfo{}, ErrNoCredentials
+	}
+}
+
+// MutualTLSConfig returns the TLS config of the server verifying the client certificates with the CAs of
+// the clientCAFile, for UserInfoFromRequest. The certificates are optional at the TLS level, so clients
+// can authenticate with a bearer token instead.
+func MutualTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading the server certificate: %w", err)
+	}
+	caPEM, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the client CAs: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificate found in %s", clientCAFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// SetRateLimit limits the requests of each client to perSecond requests per second with bursts of burst
+// requests. Requests over the limit get a 429. The limit of the remote address applies before the
+// authentication, so invalid credentials cannot flood the authenticators, and the one of the user after
+// it: the authenticated requests are refunded to their address, so the users behind a proxy have their
+// own limits. Zero perSecond disables the limits. The burst is at least 1.
+func (in *PermissionsServer) SetRateLimit(perSecond float64, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+	in.limitersMu.Lock()
+	defer in.limitersMu.Unlock()
+	in.rateLimit = rate.Limit(perSecond)
+	in.rateBurst = burst
+	in.limiters = map[string]*clientLimiter{}
+}
+
+// clientLimiter is the rate limiter of a client.
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// reserve takes a request from the rate limit of the client. It returns the function giving the request
+// back, or nil and the delay before the next request of the client if it is over its limit.
+func (in *PermissionsServer) reserve(client string) (func(), time.Duration) {
+	in.limitersMu.Lock()
+	defer in.limitersMu.Unlock()
+	if in.rateLimit <= 0 {
+		return func() {}, 0
+	}
+	now := time.Now()
+	limiter, ok := in.limiters[client]
+	if !ok {
+		for key, idle := range in.limiters {
+			if now.Sub(idle.lastSeen) > rateLimiterIdleTimeout {
+				delete(in.limiters, key)
+			}
+		}
+		limiter = &clientLimiter{limiter: rate.NewLimiter(in.rateLimit, in.rateBurst)}
+		in.limiters[client] = limiter
+	}
+	limiter.lastSeen = now
+	reservation := limiter.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return nil, time.Second
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return nil, delay
+	}
+	// Cancelled at the time of the reservation, which has already acted
+	return func() { reservation.CancelAt(now) }, 0
+}
+
+// tooManyRequests rejects a request over the rate limit of its client.
+func tooManyRequests(w http.ResponseWriter, delay time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+}
+
+// guard wraps an API handler with the rate limits, the authentication and the authorization of the
+// caller, see SetRateLimit. required returns the access needed by the caller, and false if the caller
+// reads its own permissions. The caller is stored in the context of the request, see UserFromContext.
+func (in *PermissionsServer) guard(handler http.HandlerFunc, required func(r *http.Request, caller UserInfo) (AccessRequest, bool)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(r)
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		refundAddress, delay := in.reserve("address:" + host)
+		if refundAddress == nil {
+			tooManyRequests(w, delay)
+			return
+		}
+
+		var caller UserInfo
+		err = ErrNoCredentials
+		if authenticate := in.authenticator(); authenticate != nil {
+			caller, err = authenticate(r)
+		}
+		if err != nil {
+			log.Debugf("%sRejecting unauthenticated permissions request: %v", logPrefix(ctx), err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		refundAddress()
+		if refundUser, delay := in.reserve("user:" + caller.Name); refundUser == nil {
+			tooManyRequests(w, delay)
+			return
+		}
+
+		if required != nil {
+			if req, needed := required(r, caller); needed {
+				// The enforcement mode does not apply to the server, which fails closed
+				decision, err := in.checker.authorize(ctx, caller, req)
+				if err != nil {
+					log.Errorf("%sError authorizing the permissions request of %s: %v", logPrefix(ctx), redactedUser(caller.Name), err)
+					http.Error(w, "error authorizing the request", http.StatusInternalServerError)
+					return
+				}
+				if !decision.Allowed {
+					http.Error(w, fmt.Sprintf("user %q cannot %s %s of %s", caller.Name, req.Verb, UserPermissionsResource, nameOrAll(req.Name)), http.StatusForbidden)
+					return
+				}
+			}
+		}
+		handler(w, r.WithContext(WithUser(r.Context(), caller)))
+	}
+}
+
+// readUserPermissions requires get on the permissions of the user of the path, unless it is the caller
+// without group query parameters: the groups of the query are not the ones of the caller, and would tell
+// what other groups can do.
+func readUserPermissions(r *http.Request, caller UserInfo) (AccessRequest, bool) {
+	user := mux.Vars(r)["user"]
+	_, groups := r.URL.Query()["group"]
+	return AccessRequest{APIGroup: PermissionsAPIGroup, Resource: UserPermissionsResource, Name: user, Verb: "get"}, user != caller.Name || groups
+}
+
+// listUserPermissions requires list on the permissions of the users, unless the caller is the only
+// user of the user query parameters. Only the handlers reading the user query parameters may use it.
+func listUserPermissions(r *http.Request, caller UserInfo) (AccessRequest, bool) {
+	users := r.URL.Query()["user"]
+	own := len(users) > 0
+	for _, user := range users {
+		own = own && user == caller.Name
+	}
+	return AccessRequest{APIGroup: PermissionsAPIGroup, Resource: UserPermissionsResource, Verb: "list"}, !own
+}
+
+// listAllUserPermissions requires list on the permissions of the users, whatever the query.
+func listAllUserPermissions(r *http.Request, caller UserInfo) (AccessRequest, bool) {
+	return AccessRequest{APIGroup: PermissionsAPIGroup, Resource: UserPermissionsResource, Verb: "list"}, true
+}
+
+func nameOrAll(name string) string {
+	if name == "" {
+		return "all the users"
+	}
+	return name
+}
@@ -0,0 +1,234 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer returns a server whose callers are named by the X-Test-User header, with the groups of the
+// X-Test-Group headers, and whose reviews are answered by reviews.
+func newTestServer(reviews *testReviews, conf *PermissionsConfig) *PermissionsServer {
+	client := newTestClient(reviews, podReaderObjects()...)
+	checker := NewPermissionChecker(client)
+	if conf != nil {
+		if err := checker.ApplyConfig(conf); err != nil {
+			panic(err)
+		}
+	}
+	server := NewPermissionsServer(client, checker, nil)
+	server.SetAuthenticator(testAuthenticator)
+	return server
+}
+
+// testAuthenticator names the callers by the X-Test-User header, with the groups of the X-Test-Group headers.
+func testAuthenticator(r *http.Request) (UserInfo, error) {
+	name := r.Header.Get("X-Test-User")
+	if name == "" {
+		return UserInfo{}, ErrNoCredentials
+	}
+	return UserInfo{Name: name, Groups: r.Header.Values("X-Test-Group")}, nil
+}
+
+func serve(server *PermissionsServer, method, target, caller string, groups ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if caller != "" {
+		r.Header.Set("X-Test-User", caller)
+	}
+	for _, group := range groups {
+		r.Header.Add("X-Test-Group", group)
+	}
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestServerRequiresAuthentication(t *testing.T) {
+	server := newTestServer(&testReviews{}, nil)
+
+	for _, route := range []struct{ method, target string }{
+		{"GET", "/api/access-feed"},
+		{"GET", "/api/permissions/stream"},
+		{"GET", "/api/permissions/hash"},
+		{"GET", "/api/who-can?verb=get&resource=pods"},
+		{"POST", "/api/permissions/prefetch"},
+		{"GET", "/api/permissions-matrix"},
+		{"GET", "/api/permissions/alice"},
+	} {
+		assert.Equal(t, http.StatusUnauthorized, serve(server, route.method, route.target, "").Code, "%s %s", route.method, route.target)
+	}
+	// The probes are open
+	assert.Equal(t, http.StatusOK, serve(server, "GET", "/healthz", "").Code)
+}
+
+func TestServerAuthorizationIgnoresEnforcementMode(t *testing.T) {
+	for _, mode := range []EnforcementMode{EnforcementModeEnforce, EnforcementModeAudit, EnforcementModeDisabled} {
+		t.Run(string(mode), func(t *testing.T) {
+			conf := NewPermissionsConfig()
+			conf.Mode = mode
+			server := newTestServer(&testReviews{allow: allowUsers("admin")}, conf)
+
+			assert.Equal(t, http.StatusForbidden, serve(server, "GET", "/api/permissions/alice", "mallory").Code)
+			assert.Equal(t, http.StatusForbidden, serve(server, "GET", "/api/permissions-matrix?user=alice", "mallory").Code)
+			assert.Equal(t, http.StatusOK, serve(server, "GET", "/api/permissions/alice", "admin").Code)
+		})
+	}
+}
+
+func TestServerAuthorizationFailsClosed(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.Mode = EnforcementModeAudit
+	server := newTestServer(&testReviews{err: errTestAPIServer}, conf)
+
+	assert.Equal(t, http.StatusInternalServerError, serve(server, "GET", "/api/permissions/alice", "mallory").Code)
+}
+
+func TestServerOwnPermissionsUseTheGroupsOfTheCaller(t *testing.T) {
+	server := newTestServer(&testReviews{}, nil)
+
+	w := serve(server, "GET", "/api/permissions/alice", "alice", "developers")
+	require.Equal(t, http.StatusOK, w.Code)
+	var permissions UserPermissions
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &permissions))
+	assert.Equal(t, []string{"developers"}, permissions.Groups)
+	assert.Contains(t, permissions.Permissions, AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "patch"})
+}
+
+func TestServerOwnPermissionsWithOtherGroupsNeedAccess(t *testing.T) {
+	server := newTestServer(&testReviews{}, nil)
+
+	assert.Equal(t, http.StatusForbidden, serve(server, "GET", "/api/permissions/alice?group=system:masters", "alice").Code)
+}
+
+func TestServerListsOfOwnPermissionsNeedAccess(t *testing.T) {
+	server := newTestServer(&testReviews{}, nil)
+
+	// The access feed and who-can ignore the user query parameters
+	assert.Equal(t, http.StatusForbidden, serve(server, "GET", "/api/access-feed?user=alice", "alice").Code)
+	assert.Equal(t, http.StatusForbidden, serve(server, "GET", "/api/who-can?user=alice&verb=get&resource=pods", "alice").Code)
+	assert.Equal(t, http.StatusOK, serve(server, "GET", "/api/permissions-matrix?user=alice", "alice").Code)
+}
+
+func TestServerRateLimit(t *testing.T) {
+	server := newTestServer(&testReviews{}, nil)
+	server.SetRateLimit(0.001, 2)
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, serve(server, "GET", "/api/permissions/alice", "alice").Code)
+	}
+	w := serve(server, "GET", "/api/permissions/alice", "alice")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// Each user has its own limit, and the unauthenticated clients the one of their address
+	assert.Equal(t, http.StatusOK, serve(server, "GET", "/api/permissions/bob", "bob").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(server, "GET", "/api/permissions/alice", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(server, "GET", "/api/permissions/alice", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(server, "GET", "/api/permissions/alice", "").Code)
+
+	server.SetRateLimit(0, 0)
+	assert.Equal(t, http.StatusOK, serve(server, "GET", "/api/permissions/alice", "alice").Code)
+}
+
+func TestServerRateLimitsInvalidTokensBeforeTheirReview(t *testing.T) {
+	server := newTestServer(&testReviews{}, nil)
+	reviews := 0
+	server.SetAuthenticator(cachedTokenAuthenticator(func(ctx context.Context, token string) (UserInfo, error) {
+		reviews++
+		if token == "invalid" {
+			return UserInfo{}, errors.New("invalid bearer token")
+		}
+		return UserInfo{Name: "alice"}, nil
+	}))
+	server.SetRateLimit(0.001, 2)
+	request := func(token string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/permissions/alice", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// The valid tokens are refunded to the limit of their address
+	assert.Equal(t, http.StatusUnauthorized, request("invalid"))
+	assert.Equal(t, http.StatusOK, request("valid"))
+	assert.Equal(t, http.StatusUnauthorized, request("invalid"))
+	assert.Equal(t, 3, reviews)
+
+	// Once the address is over its limit, the tokens are not reviewed anymore
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusTooManyRequests, request("invalid"))
+	}
+	assert.Equal(t, 3, reviews)
+}
+
+func TestCachedTokenAuthenticator(t *testing.T) {
+	reviews := 0
+	authenticate := cachedTokenAuthenticator(func(ctx context.Context, token string) (UserInfo, error) {
+		reviews++
+		if token == "invalid" {
+			return UserInfo{}, errors.New("invalid bearer token")
+		}
+		return UserInfo{Name: "alice"}, nil
+	})
+	request := func(authorization string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/api/permissions/hash", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		return r
+	}
+
+	for i := 0; i < 2; i++ {
+		user, err := authenticate(request("Bearer token"))
+		require.NoError(t, err)
+		assert.Equal(t, "alice", user.Name)
+	}
+	assert.Equal(t, 1, reviews)
+
+	// Expired tokens are reviewed again
+	expired := testJWT(time.Now().Add(-time.Minute))
+	for i := 0; i < 2; i++ {
+		_, err := authenticate(request("Bearer " + expired))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, reviews)
+
+	// Failed reviews are not cached
+	for i := 0; i < 2; i++ {
+		_, err := authenticate(request("Bearer invalid"))
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 5, reviews)
+
+	for _, authorization := range []string{"", "Bearer ", "Basic YWxpY2U6c2VjcmV0"} {
+		_, err := authenticate(request(authorization))
+		assert.ErrorIs(t, err, ErrNoCredentials, authorization)
+	}
+}
+
+func TestFirstAuthenticator(t *testing.T) {
+	noCertificate := func(r *http.Request) (UserInfo, error) { return UserInfo{}, ErrNoClientCertificate }
+	invalid := func(r *http.Request) (UserInfo, error) { return UserInfo{}, errors.New("invalid credentials") }
+
+	user, err := FirstAuthenticator(noCertificate, testAuthenticator)(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+	assert.Empty(t, user.Name)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Test-User", "alice")
+	user, err = FirstAuthenticator(noCertificate, testAuthenticator)(r)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user.Name)
+
+	// The first authenticator finding credentials decides
+	_, err = FirstAuthenticator(invalid, testAuthenticator)(r)
+	assert.EqualError(t, err, "invalid credentials")
+}
@@ -0,0 +1,212 @@
+package business
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	authn_v1 "k8s.io/api/authentication/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/kiali/kiali/log"
+)
+
+// SessionReviewInterval is the interval of the TokenReviews of the sessions whose token does not tell its
+// expiry, e.g. opaque tokens, which can be revoked anytime.
+const SessionReviewInterval = 5 * time.Minute
+
+// sessionReviewTimeout bounds the periodic TokenReviews of the sessions.
+const sessionReviewTimeout = 30 * time.Second
+
+// ErrSessionExpired is returned by the checks of a SessionChecker after its token expired or it was closed.
+var ErrSessionExpired = errors.New("permissions session expired")
+
+// errTokenNotAuthenticated is returned for the tokens rejected by the TokenReviews.
+var errTokenNotAuthenticated = errors.New("token not authenticated")
+
+// SessionChecker checks the permissions of the identity of a token, authenticated when the session is
+// created. Each session has its own decision cache, dropped when the session ends, so a web backend can
+// keep a checker per login. The session closes itself when the token expires or, for the tokens that do
+// not tell their expiry, when a periodic TokenReview no longer authenticates the token as the same identity.
+type SessionChecker struct {
+	user    UserInfo
+	expiry  time.Time
+	token   string
+	k8s     kube.Interface
+	checker *PermissionChecker
+	// timer closes the session at the expiry of the token, or reviews the token again
+	timer *time.Timer
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// NewSessionChecker authenticates the token with a TokenReview and returns a checker for its identity. The
+// restConfig is the identity of the backend: it must be allowed to create TokenReviews and
+// SubjectAccessReviews. The session expires with the token, according to its exp claim if it is a JWT.
+// Otherwise the token is reviewed again every SessionReviewInterval, and the session ends once the token is
+// rejected.
+func NewSessionChecker(ctx context.Context, restConfig *rest.Config, token string) (*SessionChecker, error) {
+	k8s, err := kube.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating client: %w", err)
+	}
+	user, err := reviewToken(ctx, k8s, token)
+	if err != nil {
+		return nil, err
+	}
+
+	expiry, ok := tokenExpiry(token)
+	if ok && !expiry.After(time.Now()) {
+		return nil, ErrSessionExpired
+	}
+
+	session := &SessionChecker{
+		user:    user,
+		expiry:  expiry,
+		token:   token,
+		k8s:     k8s,
+		checker: NewPermissionChecker(NewPermissionsClient(k8s)),
+		done:    make(chan struct{}),
+	}
+	// Close waits for the timer to be set, even if it fires right away
+	session.mu.Lock()
+	if ok {
+		session.timer = time.AfterFunc(time.Until(expiry), session.Close)
+	} else {
+		session.timer = time.AfterFunc(SessionReviewInterval, session.review)
+	}
+	session.mu.Unlock()
+	return session, nil
+}
+
+// User returns the identity authenticated for the session.
+func (in *SessionChecker) User() UserInfo {
+	return in.user
+}
+
+// ExpiresAt returns when the session closes itself, zero if the token does not tell its expiry.
+func (in *SessionChecker) ExpiresAt() time.Time {
+	return in.expiry
+}
+
+// Done is closed when the session ends, e.g. to remove it from the sessions of the backend.
+func (in *SessionChecker) Done() <-chan struct{} {
+	return in.done
+}
+
+// Check decides if the identity of the session can perform the request, see PermissionChecker.Check.
+func (in *SessionChecker) Check(ctx context.Context, req AccessRequest) (Decision, error) {
+	if in.isClosed() {
+		return Decision{}, ErrSessionExpired
+	}
+	// The lock is not held across the review, Close must not wait for the apiserver
+	decision, err := in.checker.Check(ctx, in.user, req)
+	if in.isClosed() {
+		return Decision{}, ErrSessionExpired
+	}
+	return decision, err
+}
+
+func (in *SessionChecker) isClosed() bool {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	return in.closed
+}
+
+// IsAllowed is a shortcut of Check returning whether the request is allowed.
+func (in *SessionChecker) IsAllowed(ctx context.Context, req AccessRequest) (bool, error) {
+	decision, err := in.Check(ctx, req)
+	return decision.Allowed, err
+}
+
+// Close ends the session, e.g. on logout, dropping its cached decisions. It is called when the token
+// expires, and can be called several times.
+func (in *SessionChecker) Close() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.closed {
+		return
+	}
+	in.closed = true
+	in.timer.Stop()
+	_ = in.checker.PurgeCache(context.Background())
+	close(in.done)
+}
+
+// review authenticates the token again, closing the session if it is rejected or is now of another
+// identity, whose decisions would differ from the cached ones. Errors reaching the apiserver keep the
+// session until the next review.
+func (in *SessionChecker) review() {
+	ctx, cancel := context.WithTimeout(context.Background(), sessionReviewTimeout)
+	defer cancel()
+	user, err := reviewToken(ctx, in.k8s, in.token)
+	switch {
+	case errors.Is(err, errTokenNotAuthenticated):
+		log.Infof("Closing the permissions session of user %s, its token is no longer authenticated", redactedUser(in.user.Name))
+		in.Close()
+		return
+	case err != nil:
+		log.Warningf("Error reviewing the token of the permissions session of user %s, retried in %s: %v", redactedUser(in.user.Name), SessionReviewInterval, err)
+	case decisionCacheKey(user, AccessRequest{}) != decisionCacheKey(in.user, AccessRequest{}):
+		log.Infof("Closing the permissions session of user %s, its token is now of user %s", redactedUser(in.user.Name), redactedUser(user.Name))
+		in.Close()
+		return
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if !in.closed {
+		in.timer = time.AfterFunc(SessionReviewInterval, in.review)
+	}
+}
+
+// reviewToken authenticates the token with a TokenReview and returns its identity.
+func reviewToken(ctx context.Context, k8s kube.Interface, token string) (UserInfo, error) {
+	review, err := k8s.AuthenticationV1().TokenReviews().Create(ctx, &authn_v1.TokenReview{
+		Spec: authn_v1.TokenReviewSpec{Token: token},
+	}, meta_v1.CreateOptions{})
+	if err != nil {
+		return UserInfo{}, withRequestID(ctx, fmt.Errorf("error reviewing token: %w", err))
+	}
+	if !review.Status.Authenticated {
+		return UserInfo{}, withRequestID(ctx, fmt.Errorf("%w: %s", errTokenNotAuthenticated, review.Status.Error))
+	}
+
+	user := UserInfo{Name: review.Status.User.Username, Groups: review.Status.User.Groups}
+	if len(review.Status.User.Extra) > 0 {
+		user.Extra = make(map[string][]string, len(review.Status.User.Extra))
+		for k, v := range review.Status.User.Extra {
+			user.Extra[k] = v
+		}
+	}
+	return user, nil
+}
+
+// tokenExpiry returns the exp claim of a JWT. The signature is not verified: the token is only trusted
+// once authenticated by the TokenReview.
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
@@ -0,0 +1,225 @@
+package business
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	authn_v1 "k8s.io/api/authentication/v1"
+	auth_v1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/rest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sessionAPIServer authenticates the tokens of the map and allows the SubjectAccessReviews of alice,
+// counting them.
+func sessionAPIServer(t *testing.T, tokens map[string]string, reviews *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var response interface{}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/tokenreviews"):
+			var review authn_v1.TokenReview
+			if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			review.APIVersion, review.Kind = "authentication.k8s.io/v1", "TokenReview"
+			if user, ok := tokens[review.Spec.Token]; ok {
+				review.Status.Authenticated = true
+				review.Status.User = authn_v1.UserInfo{Username: user, Groups: []string{"system:authenticated"}}
+			} else {
+				review.Status.Error = "invalid bearer token"
+			}
+			response = review
+		case strings.HasSuffix(r.URL.Path, "/subjectaccessreviews"):
+			var review auth_v1.SubjectAccessReview
+			if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			*reviews++
+			review.APIVersion, review.Kind = "authorization.k8s.io/v1", "SubjectAccessReview"
+			review.Status.Allowed = review.Spec.User == "alice"
+			response = review
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// sessionConfig returns the config of the fake apiserver, which decodes JSON and not the protobuf the typed
+// clients send by default.
+func sessionConfig(server *httptest.Server) *rest.Config {
+	return &rest.Config{Host: server.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}}
+}
+
+// testJWT returns an unsigned JWT expiring at exp.
+func testJWT(exp time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"alice","exp":%d}`, exp.Unix())))
+	return "eyJhbGciOiJub25lIn0." + payload + ".signature"
+}
+
+func TestSessionCheckerAuthenticatesTheToken(t *testing.T) {
+	reviews := 0
+	server := sessionAPIServer(t, map[string]string{"alice-token": "alice"}, &reviews)
+
+	session, err := NewSessionChecker(testCtx, sessionConfig(server), "alice-token")
+	require.NoError(t, err)
+	defer session.Close()
+	assert.Equal(t, "alice", session.User().Name)
+	assert.Equal(t, []string{"system:authenticated"}, session.User().Groups)
+	// The opaque token does not tell its expiry, it is reviewed again instead
+	assert.True(t, session.ExpiresAt().IsZero())
+
+	for i := 0; i < 2; i++ {
+		allowed, err := session.IsAllowed(testCtx, alicePods)
+		require.NoError(t, err)
+		assert.True(t, allowed)
+	}
+	assert.Equal(t, 1, reviews)
+}
+
+func TestSessionCheckerRejectsInvalidTokens(t *testing.T) {
+	reviews := 0
+	server := sessionAPIServer(t, map[string]string{"alice-token": "alice", testJWT(time.Now().Add(-time.Minute)): "alice"}, &reviews)
+
+	_, err := NewSessionChecker(testCtx, sessionConfig(server), "stolen-token")
+	assert.ErrorContains(t, err, "invalid bearer token")
+
+	_, err = NewSessionChecker(testCtx, sessionConfig(server), testJWT(time.Now().Add(-time.Minute)))
+	assert.ErrorIs(t, err, ErrSessionExpired)
+}
+
+func TestSessionCheckerExpiresWithTheToken(t *testing.T) {
+	reviews := 0
+	token := testJWT(time.Now().Add(2 * time.Second))
+	server := sessionAPIServer(t, map[string]string{token: "alice"}, &reviews)
+
+	session, err := NewSessionChecker(testCtx, sessionConfig(server), token)
+	require.NoError(t, err)
+	exp, _ := tokenExpiry(token)
+	assert.Equal(t, exp, session.ExpiresAt())
+
+	select {
+	case <-session.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session not closed when its token expired")
+	}
+	_, err = session.Check(testCtx, alicePods)
+	assert.ErrorIs(t, err, ErrSessionExpired)
+}
+
+func TestSessionCheckerReviewsTheOpaqueTokensAgain(t *testing.T) {
+	reviews := 0
+	tokens := map[string]string{"alice-token": "alice"}
+	server := sessionAPIServer(t, tokens, &reviews)
+
+	session, err := NewSessionChecker(testCtx, sessionConfig(server), "alice-token")
+	require.NoError(t, err)
+	defer session.Close()
+
+	// A review authenticating the same identity keeps the session
+	session.review()
+	allowed, err := session.IsAllowed(testCtx, alicePods)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	// Once the token is revoked, the session ends
+	delete(tokens, "alice-token")
+	session.review()
+	select {
+	case <-session.Done():
+	default:
+		t.Fatal("session not closed when its token was revoked")
+	}
+	_, err = session.Check(testCtx, alicePods)
+	assert.ErrorIs(t, err, ErrSessionExpired)
+}
+
+func TestSessionCheckerEndsWhenTheTokenChangesIdentity(t *testing.T) {
+	reviews := 0
+	tokens := map[string]string{"shared-token": "alice"}
+	server := sessionAPIServer(t, tokens, &reviews)
+
+	session, err := NewSessionChecker(testCtx, sessionConfig(server), "shared-token")
+	require.NoError(t, err)
+	defer session.Close()
+
+	tokens["shared-token"] = "bob"
+	session.review()
+	_, err = session.Check(testCtx, alicePods)
+	assert.ErrorIs(t, err, ErrSessionExpired)
+}
+
+func TestSessionCheckerKeepsTheSessionWhenTheReviewFails(t *testing.T) {
+	reviews := 0
+	server := sessionAPIServer(t, map[string]string{"alice-token": "alice"}, &reviews)
+
+	session, err := NewSessionChecker(testCtx, sessionConfig(server), "alice-token")
+	require.NoError(t, err)
+	defer session.Close()
+
+	server.Close()
+	session.review()
+	select {
+	case <-session.Done():
+		t.Fatal("session closed when the apiserver could not be reached")
+	default:
+	}
+}
+
+func TestSessionCheckerClose(t *testing.T) {
+	reviews := 0
+	server := sessionAPIServer(t, map[string]string{"alice-token": "alice"}, &reviews)
+
+	session, err := NewSessionChecker(testCtx, sessionConfig(server), "alice-token")
+	require.NoError(t, err)
+	session.Close()
+	session.Close()
+
+	select {
+	case <-session.Done():
+	default:
+		t.Fatal("session not done after Close")
+	}
+	allowed, err := session.IsAllowed(testCtx, alicePods)
+	assert.ErrorIs(t, err, ErrSessionExpired)
+	assert.False(t, allowed)
+	assert.Zero(t, reviews)
+}
+
+func TestTokenExpiry(t *testing.T) {
+	exp := time.Unix(1900000000, 0)
+	cases := map[string]struct {
+		token string
+		ok    bool
+	}{
+		"jwt":            {token: testJWT(exp), ok: true},
+		"opaque":         {token: "alice-token"},
+		"invalid base64": {token: "header.%%%.signature"},
+		"invalid json":   {token: "header." + base64.RawURLEncoding.EncodeToString([]byte("{")) + ".signature"},
+		"without exp":    {token: "header." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice"}`)) + ".signature"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			expiry, ok := tokenExpiry(c.token)
+			assert.Equal(t, c.ok, ok)
+			if c.ok {
+				assert.Equal(t, exp, expiry)
+			}
+		})
+	}
+}
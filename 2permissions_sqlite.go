@@ -0,0 +1,245 @@
+package business
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kiali/kiali/log"
+)
+
+// SQLiteDriverName is the database/sql driver opened by OpenSQLiteHistoryStore, registered by the binary,
+// e.g. with a blank import of modernc.org/sqlite, which needs no cgo.
+const SQLiteDriverName = "sqlite"
+
+// sqliteMigrations are the schema versions of the history database, applied in order. A released
+// migration is never modified: changes are appended as new migrations.
+var sqliteMigrations = [][]string{
+	// 1: the permission changes of the users
+	{
+		`CREATE TABLE permission_changes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL,
+			timestamp INTEGER NOT NULL,
+			hash TEXT NOT NULL,
+			gained TEXT NOT NULL,
+			lost TEXT NOT NULL,
+			causes TEXT NOT NULL
+		)`,
+		`CREATE INDEX permission_changes_username_timestamp ON permission_changes (username, timestamp)`,
+	},
+	// 2: the complete permissions of the users when they start being watched, from which the changes apply
+	{
+		`CREATE TABLE permission_baselines (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL,
+			timestamp INTEGER NOT NULL,
+			permissions TEXT NOT NULL
+		)`,
+		`CREATE INDEX permission_baselines_username_timestamp ON permission_baselines (username, timestamp)`,
+	},
+	// 3: the documents of the ReportStore
+	{
+		`CREATE TABLE reports (
+			kind VARCHAR(253) NOT NULL,
+			name VARCHAR(253) NOT NULL,
+			content TEXT NOT NULL,
+			PRIMARY KEY (kind, name)
+		)`,
+	},
+}
+
+// SQLiteHistoryStore is a local history database in a single SQLite file, for the CLI and the sidecar
+// that run without external infrastructure. It persists the permission changes, as a
+// PermissionHistoryStore and a PermissionBaselineStore, answering what a user could do at a point in time,
+// see AccessAsOf, and the reports, as a ReportStore.
+type SQLiteHistoryStore struct {
+	ReportStore
+	db *sql.DB
+}
+
+// OpenSQLiteHistoryStore opens the history database in the file at path, created if it does not exist,
+// and migrates its schema to the current version.
+func OpenSQLiteHistoryStore(ctx context.Context, path string) (*SQLiteHistoryStore, error) {
+	db, err := sql.Open(SQLiteDriverName, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database %s: %w", path, err)
+	}
+	// SQLite serializes the writers, a single connection avoids the busy errors
+	db.SetMaxOpenConns(1)
+	store, err := NewSQLiteHistoryStore(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open history database %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// NewSQLiteHistoryStore creates a history store on the SQLite database, and migrates its schema.
+func NewSQLiteHistoryStore(ctx context.Context, db *sql.DB) (*SQLiteHistoryStore, error) {
+	if err := migrateSQLite(ctx, db); err != nil {
+		return nil, err
+	}
+	return &SQLiteHistoryStore{
+		ReportStore: &sqlReportStore{db: db, table: "reports", dialect: SQLDialectSQLite},
+		db:          db,
+	}, nil
+}
+
+// migrateSQLite applies the migrations newer than the schema version of the database, each in its own
+// transaction with the version update, so a failed migration leaves the previous version.
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)"); err != nil {
+		return fmt.Errorf("failed to read the schema version: %w", err)
+	}
+	version := 0
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read the schema version: %w", err)
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("schema version %d is newer than the supported version %d", version, len(sqliteMigrations))
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		statements := append(append([]string{}, sqliteMigrations[i]...), "DELETE FROM schema_version")
+		for _, statement := range statements {
+			if _, err = tx.ExecContext(ctx, statement); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "INSERT INTO schema_version (version) VALUES (?)", i+1)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to migrate the schema to version %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to migrate the schema to version %d: %w", i+1, err)
+		}
+		log.Infof("Migrated the history database schema to version %d", i+1)
+	}
+	return nil
+}
+
+// Close closes the database.
+func (in *SQLiteHistoryStore) Close() error {
+	return in.db.Close()
+}
+
+// Append implements PermissionHistoryStore.
+func (in *SQLiteHistoryStore) Append(ctx context.Context, change PermissionChange) error {
+	columns := []interface{}{}
+	for _, value := range []interface{}{change.Gained, change.Lost, change.Causes} {
+		content, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		columns = append(columns, string(content))
+	}
+	_, err := in.db.ExecContext(ctx, "INSERT INTO permission_changes (username, timestamp, hash, gained, lost, causes) VALUES (?, ?, ?, ?, ?, ?)",
+		append([]interface{}{change.User, change.Timestamp.UnixNano(), change.Hash}, columns...)...)
+	return err
+}
+
+// AppendBaseline implements PermissionBaselineStore.
+func (in *SQLiteHistoryStore) AppendBaseline(ctx context.Context, user string, permissions []AccessRequest, timestamp time.Time) error {
+	content, err := json.Marshal(permissions)
+	if err != nil {
+		return err
+	}
+	_, err = in.db.ExecContext(ctx, "INSERT INTO permission_baselines (username, timestamp, permissions) VALUES (?, ?, ?)", user, timestamp.UnixNano(), string(content))
+	return err
+}
+
+// History returns the changes of the user between since and until, oldest first. A zero time does not
+// bound the changes.
+func (in *SQLiteHistoryStore) History(ctx context.Context, user string, since, until time.Time) ([]PermissionChange, error) {
+	end := int64(1<<63 - 1)
+	if !until.IsZero() {
+		end = until.UnixNano()
+	}
+	start := int64(0)
+	if !since.IsZero() {
+		start = since.UnixNano()
+	}
+	rows, err := in.db.QueryContext(ctx, "SELECT timestamp, hash, gained, lost, causes FROM permission_changes WHERE username = ? AND timestamp >= ? AND timestamp <= ? ORDER BY timestamp, id", user, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the history of user %s: %w", redactedUser(user), err)
+	}
+	defer rows.Close()
+	history := []PermissionChange{}
+	for rows.Next() {
+		var timestamp int64
+		var gained, lost, causes string
+		change := PermissionChange{User: user}
+		if err := rows.Scan(&timestamp, &change.Hash, &gained, &lost, &causes); err != nil {
+			return nil, err
+		}
+		change.Timestamp = time.Unix(0, timestamp)
+		for _, column := range []struct {
+			content string
+			value   interface{}
+		}{{gained, &change.Gained}, {lost, &change.Lost}, {causes, &change.Causes}} {
+			if err := json.Unmarshal([]byte(column.content), column.value); err != nil {
+				return nil, fmt.Errorf("corrupted change of user %s: %w", redactedUser(user), err)
+			}
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
+
+// AccessAsOf returns the permissions of the user at the time, e.g. "what could bob do last Tuesday": the
+// latest baseline of the user before the time, with the changes recorded since applied. Without a
+// baseline, the changes apply from no permissions, which is exact only if the user was watched from the
+// time they had none. The user is named as persisted, i.e. hashed if the redaction policy hashes the
+// usernames. The permissions are sorted, and found is false if nothing was recorded for the user
+// before the time.
+func (in *SQLiteHistoryStore) AccessAsOf(ctx context.Context, user string, at time.Time) (permissions []AccessRequest, found bool, err error) {
+	current := map[AccessRequest]bool{}
+	from := int64(0)
+	var timestamp int64
+	var content string
+	err = in.db.QueryRowContext(ctx, "SELECT timestamp, permissions FROM permission_baselines WHERE username = ? AND timestamp <= ? ORDER BY timestamp DESC, id DESC LIMIT 1", user, at.UnixNano()).Scan(&timestamp, &content)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return nil, false, fmt.Errorf("failed to read the baseline of user %s: %w", redactedUser(user), err)
+	default:
+		baseline := []AccessRequest{}
+		if err := json.Unmarshal([]byte(content), &baseline); err != nil {
+			return nil, false, fmt.Errorf("corrupted baseline of user %s: %w", redactedUser(user), err)
+		}
+		for _, permission := range baseline {
+			current[permission] = true
+		}
+		from, found = timestamp, true
+	}
+
+	changes, err := in.History(ctx, user, time.Unix(0, from), at)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, change := range changes {
+		for _, permission := range change.Lost {
+			delete(current, permission)
+		}
+		for _, permission := range change.Gained {
+			current[permission] = true
+		}
+		found = true
+	}
+
+	permissions = make([]AccessRequest, 0, len(current))
+	for permission := range current {
+		permissions = append(permissions, permission)
+	}
+	sortAccessRequests(permissions)
+	return permissions, found, nil
+}
@@ -0,0 +1,122 @@
+package business
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestSQLiteHistoryStore(t *testing.T, path string) *SQLiteHistoryStore {
+	t.Helper()
+	store, err := OpenSQLiteHistoryStore(testCtx, path)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSQLiteHistoryStoreMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store := openTestSQLiteHistoryStore(t, path)
+	require.NoError(t, store.Append(testCtx, PermissionChange{User: "alice", Timestamp: time.Now()}))
+	require.NoError(t, store.Close())
+
+	// Reopening keeps the data of the current schema
+	store = openTestSQLiteHistoryStore(t, path)
+	history, err := store.History(testCtx, "alice", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+	var version int
+	require.NoError(t, store.db.QueryRowContext(testCtx, "SELECT version FROM schema_version").Scan(&version))
+	assert.Equal(t, len(sqliteMigrations), version)
+
+	// A database of a newer release is not downgraded
+	_, err = store.db.ExecContext(testCtx, "UPDATE schema_version SET version = ?", len(sqliteMigrations)+1)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	_, err = OpenSQLiteHistoryStore(testCtx, path)
+	assert.ErrorContains(t, err, "is newer than the supported version")
+}
+
+func TestSQLiteHistoryStoreHistory(t *testing.T) {
+	store := openTestSQLiteHistoryStore(t, filepath.Join(t.TempDir(), "history.db"))
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	for i, change := range []PermissionChange{
+		{User: "alice", Gained: []AccessRequest{alicePods}, Hash: "v1"},
+		{User: "bob", Gained: []AccessRequest{alicePods}, Hash: "v1"},
+		{User: "alice", Lost: []AccessRequest{alicePods}, Causes: []RBACObjectRef{{Kind: "RoleBinding", Namespace: "ns1", Name: "alice-pods"}}, Hash: "v2"},
+	} {
+		change.Timestamp = start.Add(time.Duration(i) * time.Hour)
+		require.NoError(t, store.Append(testCtx, change))
+	}
+
+	history, err := store.History(testCtx, "alice", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, []AccessRequest{alicePods}, history[0].Gained)
+	assert.Equal(t, []AccessRequest{alicePods}, history[1].Lost)
+	assert.Equal(t, []RBACObjectRef{{Kind: "RoleBinding", Namespace: "ns1", Name: "alice-pods"}}, history[1].Causes)
+	assert.Equal(t, "v2", history[1].Hash)
+	assert.True(t, history[1].Timestamp.Equal(start.Add(2*time.Hour)))
+
+	history, err = store.History(testCtx, "alice", start.Add(time.Minute), time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+	history, err = store.History(testCtx, "alice", time.Time{}, start.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+}
+
+func TestSQLiteHistoryStoreAccessAsOf(t *testing.T) {
+	store := openTestSQLiteHistoryStore(t, filepath.Join(t.TempDir(), "history.db"))
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	secrets := AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"}
+	require.NoError(t, store.AppendBaseline(testCtx, "bob", []AccessRequest{alicePods}, start))
+	require.NoError(t, store.Append(testCtx, PermissionChange{User: "bob", Gained: []AccessRequest{secrets}, Timestamp: start.Add(time.Hour)}))
+	require.NoError(t, store.Append(testCtx, PermissionChange{User: "bob", Lost: []AccessRequest{alicePods}, Timestamp: start.Add(2 * time.Hour)}))
+
+	for _, tc := range []struct {
+		at       time.Time
+		expected []AccessRequest
+		found    bool
+	}{
+		{at: start.Add(-time.Hour), expected: []AccessRequest{}},
+		{at: start, expected: []AccessRequest{alicePods}, found: true},
+		{at: start.Add(90 * time.Minute), expected: []AccessRequest{alicePods, secrets}, found: true},
+		{at: start.Add(3 * time.Hour), expected: []AccessRequest{secrets}, found: true},
+	} {
+		permissions, found, err := store.AccessAsOf(testCtx, "bob", tc.at)
+		require.NoError(t, err)
+		assert.Equal(t, tc.found, found, tc.at)
+		assert.Equal(t, tc.expected, permissions, tc.at)
+	}
+
+	// A newer baseline replaces the older changes
+	require.NoError(t, store.AppendBaseline(testCtx, "bob", []AccessRequest{}, start.Add(4*time.Hour)))
+	permissions, found, err := store.AccessAsOf(testCtx, "bob", start.Add(5*time.Hour))
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Empty(t, permissions)
+}
+
+func TestSQLiteHistoryStoreReports(t *testing.T) {
+	store := openTestSQLiteHistoryStore(t, filepath.Join(t.TempDir(), "history.db"))
+	assertReportStore(t, store)
+}
+
+func TestPermissionHistoryRecorderBaselines(t *testing.T) {
+	store := openTestSQLiteHistoryStore(t, filepath.Join(t.TempDir(), "history.db"))
+	recorder := NewPermissionHistoryRecorder(10, store)
+	at := time.Now()
+	recorder.RecordBaseline("alice", map[AccessRequest]bool{alicePods: true}, at)
+
+	permissions, found, err := store.AccessAsOf(testCtx, "alice", at)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []AccessRequest{alicePods}, permissions)
+
+	// The stores without baselines are left alone
+	NewPermissionHistoryRecorder(10, nil).RecordBaseline("alice", map[AccessRequest]bool{alicePods: true}, at)
+}
@@ -0,0 +1,48 @@
+package business
+
+import (
+	"context"
+	"time"
+)
+
+type maxStalenessKey struct{}
+
+// WithMaxStaleness returns a context whose checks are not answered with cached decisions older than
+// maxStaleness: older ones are refreshed with the authorizers, and the fresh decisions cached again. It
+// gives the sensitive operations, e.g. deleting a namespace, an explicit freshness contract whatever the
+// cache TTLs. Zero forces a fresh decision.
+func WithMaxStaleness(ctx context.Context, maxStaleness time.Duration) context.Context {
+	return context.WithValue(ctx, maxStalenessKey{}, maxStaleness)
+}
+
+// MaxStalenessFromContext returns the max staleness set by WithMaxStaleness. The second value is false
+// if none is set.
+func MaxStalenessFromContext(ctx context.Context) (time.Duration, bool) {
+	maxStaleness, ok := ctx.Value(maxStalenessKey{}).(time.Duration)
+	return maxStaleness, ok
+}
+
+// MaxStaleness returns how old the decisions returned by Check can be with the current config, i.e. the
+// longest cache TTL of the resources. Zero means every decision is fresh. WithMaxStaleness lowers it
+// for a check.
+func (in *PermissionChecker) MaxStaleness() time.Duration {
+	in.mu.RLock()
+	conf := in.conf
+	in.mu.RUnlock()
+	return conf.maxCacheTTL()
+}
+
+// maxCacheTTL returns the longest cache TTL of the resources. The user overrides only shorten them.
+func (in *PermissionsConfig) maxCacheTTL() time.Duration {
+	ttl := max(in.CacheTTL, 0)
+	for _, tier := range in.SensitivityTiers {
+		ttl = max(ttl, tier.CacheTTL)
+	}
+	return ttl
+}
+
+// freshEnough returns false if the cached decision is older than the max staleness of the context.
+func freshEnough(ctx context.Context, cached Decision) bool {
+	maxStaleness, ok := MaxStalenessFromContext(ctx)
+	return !ok || time.Since(cached.Timestamp) <= maxStaleness
+}
@@ -0,0 +1,51 @@
+package business
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckerMaxStaleness(t *testing.T) {
+	checker := newTestChecker(aliceReadsPods(), nil)
+	assert.Equal(t, NewPermissionsConfig().CacheTTL, checker.MaxStaleness())
+
+	conf := NewPermissionsConfig()
+	conf.SensitivityTiers = []SensitivityTier{{Name: "logs", Resources: []string{"pods/log"}, CacheTTL: time.Hour}}
+	require.NoError(t, checker.ApplyConfig(conf))
+	assert.Equal(t, time.Hour, checker.MaxStaleness())
+
+	conf = NewPermissionsConfig()
+	conf.CacheTTL = 0
+	require.NoError(t, checker.ApplyConfig(conf))
+	assert.Zero(t, checker.MaxStaleness())
+}
+
+func TestWithMaxStalenessRefreshesOlderDecisions(t *testing.T) {
+	reviews := aliceReadsPods()
+	checker := newTestChecker(reviews, nil)
+	alice := UserInfo{Name: "alice"}
+	_, err := checker.Check(testCtx, alice, alicePods)
+	require.NoError(t, err)
+
+	decision, err := checker.Check(WithMaxStaleness(testCtx, time.Hour), alice, alicePods)
+	require.NoError(t, err)
+	assert.Equal(t, DecisionSourceCache, decision.Source)
+	assert.Equal(t, int64(1), reviews.calls.Load())
+
+	decision, err = checker.Check(WithMaxStaleness(testCtx, 0), alice, alicePods)
+	require.NoError(t, err)
+	assert.Equal(t, DecisionSourceAPIServer, decision.Source)
+	assert.Equal(t, int64(2), reviews.calls.Load())
+
+	// The fresh decision is cached again
+	decision, err = checker.Check(testCtx, alice, alicePods)
+	require.NoError(t, err)
+	assert.Equal(t, DecisionSourceCache, decision.Source)
+	assert.Equal(t, int64(2), reviews.calls.Load())
+
+	_, ok := MaxStalenessFromContext(testCtx)
+	assert.False(t, ok)
+}
@@ -0,0 +1,326 @@
+package business
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kiali/kiali/log"
+)
+
+// Kinds of the documents of the reporting subsystems, see ReportStore.
+const (
+	ReportKindMatrix       = "matrices"
+	ReportKindAuditRecords = "audit-records"
+	ReportKindDrift        = "drift-reports"
+)
+
+// ErrReportNotFound is returned by ReportStore.Get for missing documents.
+var ErrReportNotFound = errors.New("report not found")
+
+// ReportStore persists the documents of the reporting subsystems, e.g. permission matrices, audit records
+// and drift reports, so they are not limited to stdout and memory. Documents are identified by their kind,
+// one of the ReportKind constants, and a name unique in the kind, e.g. a timestamp. Neither can contain
+// slashes. See SaveReport and LoadReport for JSON documents.
+type ReportStore interface {
+	// Put writes the document, replacing any document of the same name.
+	Put(ctx context.Context, kind, name string, content []byte) error
+	// Get returns the document, or ErrReportNotFound.
+	Get(ctx context.Context, kind, name string) ([]byte, error)
+	// List returns the sorted names of the documents of the kind.
+	List(ctx context.Context, kind string) ([]string, error)
+	// Delete removes the document, if it exists.
+	Delete(ctx context.Context, kind, name string) error
+}
+
+// SaveReport writes the value as a JSON document of the store, e.g.
+//
+//	business.SaveReport(ctx, store, business.ReportKindDrift, business.ReportName(time.Now()), report)
+//
+// The review campaigns, usage heatmaps, access feeds and drift reports are redacted by the redaction
+// policy of the process, see RedactionPolicy. The audit records are redacted when audited.
+func SaveReport(ctx context.Context, store ReportStore, kind, name string, value interface{}) error {
+	content, err := json.Marshal(currentRedaction().report(value))
+	if err != nil {
+		return fmt.Errorf("error encoding %s/%s: %w", kind, name, err)
+	}
+	return store.Put(ctx, kind, name, content)
+}
+
+// LoadReport reads a JSON document of the store into value.
+func LoadReport(ctx context.Context, store ReportStore, kind, name string, value interface{}) error {
+	content, err := store.Get(ctx, kind, name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, value); err != nil {
+		return fmt.Errorf("error decoding %s/%s: %w", kind, name, err)
+	}
+	return nil
+}
+
+// validateReportKey checks that the kind and the name can be used as path segments.
+func validateReportKey(kind, name string) error {
+	for _, segment := range []string{kind, name} {
+		if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, `/\`) {
+			return fmt.Errorf("invalid report %q of kind %q", name, kind)
+		}
+	}
+	return nil
+}
+
+// fileReportStore is a ReportStore keeping the documents in files, dir/kind/name.
+type fileReportStore struct {
+	dir string
+}
+
+// NewFileReportStore creates a ReportStore keeping the documents in the directory, e.g. a persistent volume.
+func NewFileReportStore(dir string) ReportStore {
+	return &fileReportStore{dir: dir}
+}
+
+func (in *fileReportStore) Put(ctx context.Context, kind, name string, content []byte) error {
+	if err := validateReportKey(kind, name); err != nil {
+		return err
+	}
+	dir := filepath.Join(in.dir, kind)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create report directory %s: %w", dir, err)
+	}
+	// Written aside and renamed, so readers never see a partial document
+	path := filepath.Join(dir, name)
+	tmp, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write report %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write report %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write report %s: %w", path, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (in *fileReportStore) Get(ctx context.Context, kind, name string) ([]byte, error) {
+	if err := validateReportKey(kind, name); err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(filepath.Join(in.dir, kind, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrReportNotFound
+	}
+	return content, err
+}
+
+func (in *fileReportStore) List(ctx context.Context, kind string) ([]string, error) {
+	if err := validateReportKey(kind, "list"); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(in.dir, kind))
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (in *fileReportStore) Delete(ctx context.Context, kind, name string) error {
+	if err := validateReportKey(kind, name); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(in.dir, kind, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete report %s/%s: %w", kind, name, err)
+	}
+	return nil
+}
+
+// SQLDialect selects the SQL syntax of a SQL ReportStore.
+type SQLDialect string
+
+const (
+	SQLDialectPostgres SQLDialect = "postgres"
+	SQLDialectMySQL    SQLDialect = "mysql"
+	SQLDialectSQLite   SQLDialect = "sqlite"
+)
+
+// sqlIdentifier matches the table names accepted by NewSQLReportStore, since they are part of the queries.
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sqlReportStore is a ReportStore keeping the documents in a table of a SQL database.
+type sqlReportStore struct {
+	db      *sql.DB
+	table   string
+	dialect SQLDialect
+}
+
+// NewSQLReportStore creates a ReportStore keeping the documents in the table of the database, created if
+// it does not exist. The driver of the database is registered by the caller, e.g. with a blank import.
+func NewSQLReportStore(ctx context.Context, db *sql.DB, table string, dialect SQLDialect) (ReportStore, error) {
+	if !sqlIdentifier.MatchString(table) {
+		return nil, fmt.Errorf("invalid report table name %q", table)
+	}
+	contentType := "TEXT"
+	switch dialect {
+	case SQLDialectPostgres, SQLDialectSQLite:
+	case SQLDialectMySQL:
+		contentType = "LONGTEXT"
+	default:
+		return nil, fmt.Errorf("unknown SQL dialect %q, expected %s, %s or %s", dialect, SQLDialectPostgres, SQLDialectMySQL, SQLDialectSQLite)
+	}
+	create := "CREATE TABLE IF NOT EXISTS " + table + " (kind VARCHAR(253) NOT NULL, name VARCHAR(253) NOT NULL, content " + contentType + " NOT NULL, PRIMARY KEY (kind, name))"
+	if _, err := db.ExecContext(ctx, create); err != nil {
+		return nil, fmt.Errorf("failed to create report table %s: %w", table, err)
+	}
+	return &sqlReportStore{db: db, table: table, dialect: dialect}, nil
+}
+
+// query replaces the ? placeholders of the query with the ones of the dialect.
+func (in *sqlReportStore) query(query string) string {
+	if in.dialect != SQLDialectPostgres {
+		return query
+	}
+	parts := strings.Split(query, "?")
+	replaced := strings.Builder{}
+	for i, part := range parts {
+		if i > 0 {
+			fmt.Fprintf(&replaced, "$%d", i)
+		}
+		replaced.WriteString(part)
+	}
+	return replaced.String()
+}
+
+func (in *sqlReportStore) Put(ctx context.Context, kind, name string, content []byte) error {
+	if err := validateReportKey(kind, name); err != nil {
+		return err
+	}
+	// Replaced in a transaction rather than upserted, since the upsert syntax differs in every dialect
+	tx, err := in.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, in.query("DELETE FROM "+in.table+" WHERE kind = ? AND name = ?"), kind, name); err != nil {
+		return fmt.Errorf("failed to write report %s/%s: %w", kind, name, err)
+	}
+	if _, err := tx.ExecContext(ctx, in.query("INSERT INTO "+in.table+" (kind, name, content) VALUES (?, ?, ?)"), kind, name, string(content)); err != nil {
+		return fmt.Errorf("failed to write report %s/%s: %w", kind, name, err)
+	}
+	return tx.Commit()
+}
+
+func (in *sqlReportStore) Get(ctx context.Context, kind, name string) ([]byte, error) {
+	if err := validateReportKey(kind, name); err != nil {
+		return nil, err
+	}
+	var content string
+	err := in.db.QueryRowContext(ctx, in.query("SELECT content FROM "+in.table+" WHERE kind = ? AND name = ?"), kind, name).Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report %s/%s: %w", kind, name, err)
+	}
+	return []byte(content), nil
+}
+
+func (in *sqlReportStore) List(ctx context.Context, kind string) ([]string, error) {
+	rows, err := in.db.QueryContext(ctx, in.query("SELECT name FROM "+in.table+" WHERE kind = ? ORDER BY name"), kind)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reports of kind %s: %w", kind, err)
+	}
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (in *sqlReportStore) Delete(ctx context.Context, kind, name string) error {
+	if _, err := in.db.ExecContext(ctx, in.query("DELETE FROM "+in.table+" WHERE kind = ? AND name = ?"), kind, name); err != nil {
+		return fmt.Errorf("failed to delete report %s/%s: %w", kind, name, err)
+	}
+	return nil
+}
+
+// DefaultAuditBatchSize is the number of audit records per document of a store audit sink.
+const DefaultAuditBatchSize = 500
+
+// storeAuditSink is a FlushingAuditSink writing the audit records to a ReportStore, in batches.
+type storeAuditSink struct {
+	store     ReportStore
+	batchSize int
+
+	mu      sync.Mutex
+	records []AuditRecord
+	batches int
+}
+
+// NewStoreAuditSink creates an audit sink writing the records to the store, as ReportKindAuditRecords
+// documents of batchSize records named after the time of their first record. The batches are written when
+// full and when the sink is flushed, e.g. when the checker is drained. A batch failing to be written is
+// logged and dropped, so the memory stays bounded.
+func NewStoreAuditSink(store ReportStore, batchSize int) FlushingAuditSink {
+	if batchSize <= 0 {
+		batchSize = DefaultAuditBatchSize
+	}
+	return &storeAuditSink{store: store, batchSize: batchSize}
+}
+
+func (in *storeAuditSink) Record(ctx context.Context, record AuditRecord) {
+	in.mu.Lock()
+	in.records = append(in.records, record)
+	full := len(in.records) >= in.batchSize
+	in.mu.Unlock()
+	if full {
+		if err := in.Flush(ctx); err != nil {
+			log.Errorf("%sError writing audit records: %v", logPrefix(ctx), err)
+		}
+	}
+}
+
+func (in *storeAuditSink) Flush(ctx context.Context) error {
+	in.mu.Lock()
+	records := in.records
+	in.records = nil
+	in.batches++
+	batch := in.batches
+	in.mu.Unlock()
+	if len(records) == 0 {
+		return nil
+	}
+	// The sequence number keeps apart the batches starting in the same nanosecond
+	name := fmt.Sprintf("%s-%06d", records[0].Timestamp.UTC().Format("20060102T150405.000000000Z"), batch)
+	return SaveReport(ctx, in.store, ReportKindAuditRecords, name, records)
+}
+
+// ReportName returns a name for a document of the time, sorting like the times, e.g. for drift reports.
+func ReportName(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
@@ -0,0 +1,189 @@
+package business
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertReportStore checks the contract of ReportStore on an empty store.
+func assertReportStore(t *testing.T, store ReportStore) {
+	t.Helper()
+	_, err := store.Get(testCtx, ReportKindDrift, "missing")
+	assert.ErrorIs(t, err, ErrReportNotFound)
+	names, err := store.List(testCtx, ReportKindDrift)
+	require.NoError(t, err)
+	assert.Empty(t, names)
+
+	require.NoError(t, store.Put(testCtx, ReportKindDrift, "b", []byte("first b")))
+	require.NoError(t, store.Put(testCtx, ReportKindDrift, "a", []byte("first a")))
+	require.NoError(t, store.Put(testCtx, ReportKindDrift, "a", []byte("second a")))
+	require.NoError(t, store.Put(testCtx, ReportKindMatrix, "c", []byte("matrix")))
+	content, err := store.Get(testCtx, ReportKindDrift, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "second a", string(content))
+	names, err = store.List(testCtx, ReportKindDrift)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, names)
+
+	require.NoError(t, store.Delete(testCtx, ReportKindDrift, "a"))
+	require.NoError(t, store.Delete(testCtx, ReportKindDrift, "a"))
+	names, err = store.List(testCtx, ReportKindDrift)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, names)
+
+	// The kinds and names are path segments
+	for _, name := range []string{"", "..", "a/b", `a\b`} {
+		assert.Error(t, store.Put(testCtx, ReportKindDrift, name, []byte("{}")), name)
+		_, err := store.Get(testCtx, ReportKindDrift, name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestFileReportStore(t *testing.T) {
+	assertReportStore(t, NewFileReportStore(t.TempDir()))
+}
+
+func TestSQLReportStore(t *testing.T) {
+	db, err := sql.Open(SQLiteDriverName, ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	store, err := NewSQLReportStore(testCtx, db, "reports", SQLDialectSQLite)
+	require.NoError(t, err)
+	assertReportStore(t, store)
+
+	_, err = NewSQLReportStore(testCtx, db, "reports; DROP TABLE reports", SQLDialectSQLite)
+	assert.Error(t, err)
+	_, err = NewSQLReportStore(testCtx, db, "reports", "oracle")
+	assert.Error(t, err)
+}
+
+func TestSQLReportStorePlaceholders(t *testing.T) {
+	query := "DELETE FROM reports WHERE kind = ? AND name = ?"
+	assert.Equal(t, "DELETE FROM reports WHERE kind = $1 AND name = $2", (&sqlReportStore{dialect: SQLDialectPostgres}).query(query))
+	assert.Equal(t, query, (&sqlReportStore{dialect: SQLDialectMySQL}).query(query))
+}
+
+// s3TestServer is an in-memory S3-compatible storage of the bucket "reports", listing a key per page.
+func s3TestServer(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key-id/") || r.Header.Get("X-Amz-Date") == "" {
+			http.Error(w, "unsigned request", http.StatusForbidden)
+			return
+		}
+		// The bucket itself has no key
+		key := ""
+		if r.URL.Path != "/reports" {
+			var ok bool
+			if key, ok = strings.CutPrefix(r.URL.Path, "/reports/"); !ok {
+				http.Error(w, "no such bucket", http.StatusNotFound)
+				return
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPut:
+			content, _ := io.ReadAll(r.Body)
+			objects[key] = content
+		case r.Method == http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		case key != "":
+			content, ok := objects[key]
+			if !ok {
+				http.Error(w, "no such key", http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(content)
+		default:
+			keys := []string{}
+			for key := range objects {
+				if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			page, _ := strconv.Atoi(r.URL.Query().Get("continuation-token"))
+			result := s3ListResult{}
+			if page < len(keys) {
+				result.Contents = append(result.Contents, struct {
+					Key string `xml:"Key"`
+				}{Key: keys[page]})
+			}
+			if page+1 < len(keys) {
+				result.IsTruncated, result.NextContinuationToken = true, strconv.Itoa(page+1)
+			}
+			_ = xml.NewEncoder(w).Encode(result)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestS3ReportStore(t *testing.T) {
+	server := s3TestServer(t)
+	store, err := NewS3ReportStore(S3ReportStoreConfig{Endpoint: server.URL + "/", Bucket: "reports", Prefix: "kiali/", AccessKeyID: "key-id", SecretAccessKey: "secret"})
+	require.NoError(t, err)
+	assertReportStore(t, store)
+
+	// The errors of the storage are reported
+	store, err = NewS3ReportStore(S3ReportStoreConfig{Endpoint: server.URL, Bucket: "other", AccessKeyID: "key-id"})
+	require.NoError(t, err)
+	assert.Error(t, store.Put(testCtx, ReportKindDrift, "a", []byte("{}")))
+
+	_, err = NewS3ReportStore(S3ReportStoreConfig{Endpoint: server.URL})
+	assert.Error(t, err)
+}
+
+func TestS3URIEncode(t *testing.T) {
+	assert.Equal(t, "/reports/kiali/a%20b~", s3URIEncode("/reports/kiali/a b~", false))
+	assert.Equal(t, "kiali%2Fdrift-reports%2F", s3URIEncode("kiali/drift-reports/", true))
+	assert.Equal(t, "continuation-token=a%2Bb&list-type=2", s3CanonicalQuery(map[string][]string{"list-type": {"2"}, "continuation-token": {"a+b"}}))
+}
+
+func TestStoreAuditSink(t *testing.T) {
+	store := NewFileReportStore(t.TempDir())
+	sink := NewStoreAuditSink(store, 2)
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		sink.Record(testCtx, AuditRecord{User: UserInfo{Name: "alice"}, Request: alicePods, Timestamp: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	// The full batches are written right away, the others when flushed
+	names, err := store.List(testCtx, ReportKindAuditRecords)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"20260102T030405.000000000Z-000001"}, names)
+	require.NoError(t, sink.Flush(testCtx))
+	require.NoError(t, sink.Flush(testCtx))
+	names, err = store.List(testCtx, ReportKindAuditRecords)
+	require.NoError(t, err)
+	require.Len(t, names, 2)
+
+	records := []AuditRecord{}
+	require.NoError(t, LoadReport(testCtx, store, ReportKindAuditRecords, names[1], &records))
+	require.Len(t, records, 1)
+	assert.Equal(t, start.Add(2*time.Second), records[0].Timestamp.UTC())
+}
+
+func TestReportName(t *testing.T) {
+	at := time.Date(2026, 10, 16, 12, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	assert.Equal(t, "20261016T103000Z", ReportName(at))
+}
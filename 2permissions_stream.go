@@ -0,0 +1,117 @@
+package business
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kiali/kiali/log"
+)
+
+// streamHeartbeatInterval is the period of the comments sent on idle permission streams,
+// so proxies do not close them.
+const streamHeartbeatInterval = 30 * time.Second
+
+// SetAuthenticator sets the function resolving the user of the HTTP requests, e.g. the one of
+// BuildAuthenticator for the configured identity providers. The API endpoints
+// respond 401 when it is not set or it fails. It can be called while the server is serving.
+func (in *PermissionsServer) SetAuthenticator(authenticate Authenticator) {
+	in.authMu.Lock()
+	defer in.authMu.Unlock()
+	in.authenticate = authenticate
+}
+
+// SetNamespaceLister sets the function listing the namespaces selected by the namespaceGlob and
+// namespaceSelector query parameters of the who-can and matrix queries. Without it, only the globs are
+// supported, matched against the namespaces of the RBAC objects. It can be called while the server is
+// serving.
+func (in *PermissionsServer) SetNamespaceLister(listNamespaces NamespaceLister) {
+	in.namespacesMu.Lock()
+	defer in.namespacesMu.Unlock()
+	in.listNamespaces = listNamespaces
+}
+
+// authenticator returns the authenticator of the server, nil if it is not set.
+func (in *PermissionsServer) authenticator() Authenticator {
+	in.authMu.RLock()
+	defer in.authMu.RUnlock()
+	return in.authenticate
+}
+
+// callerFromRequest returns the user of the request, or writes a 401 response and returns false. The
+// caller authenticated by guard is reused.
+func (in *PermissionsServer) callerFromRequest(w http.ResponseWriter, r *http.Request) (UserInfo, bool) {
+	if user, ok := UserFromContext(r.Context()); ok {
+		return user, true
+	}
+	authenticate := in.authenticator()
+	if authenticate == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return UserInfo{}, false
+	}
+	user, err := authenticate(r)
+	if err != nil {
+		log.Debugf("Rejecting unauthenticated permissions request: %v", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return UserInfo{}, false
+	}
+	return user, true
+}
+
+// streamPermissions streams the permission changes of the caller as Server-Sent Events, so UI
+// capability toggles can react to RBAC changes without polling. Each change is sent as a
+// "permission-change" event whose data is the JSON encoded PermissionChange.
+func (in *PermissionsServer) streamPermissions(w http.ResponseWriter, r *http.Request) {
+	if in.watcher == nil {
+		http.Error(w, "permission streaming is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	user, ok := in.callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	changes, cancel := in.watcher.Watch(user)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := w.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(change)
+			if err != nil {
+				log.Errorf("Error marshalling permission change of user %s: %v", redactedUser(user.Name), err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: permission-change\ndata: %s\n\n", data); err != nil {
+				log.Debugf("Permission stream of user %s closed: %v", redactedUser(user.Name), err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package business
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamPermissionsNeedsAWatcher(t *testing.T) {
+	server := newTestServer(&testReviews{}, nil)
+
+	assert.Equal(t, http.StatusServiceUnavailable, serve(server, "GET", "/api/permissions/stream", "alice").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(server, "GET", "/api/permissions/stream", "").Code)
+}
+
+func TestStreamPermissions(t *testing.T) {
+	watcher, k8s := startTestWatcher(t, nil)
+	client := newTestClient(&testReviews{}, podReaderObjects()...)
+	server := NewPermissionsServer(client, NewPermissionChecker(client), watcher)
+	server.SetAuthenticator(testAuthenticator)
+	httpServer := httptest.NewServer(server.Handler())
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithCancel(testCtx)
+	defer cancel()
+	r, err := http.NewRequestWithContext(ctx, "GET", httpServer.URL+"/api/permissions/stream", nil)
+	require.NoError(t, err)
+	r.Header.Set("X-Test-User", "alice")
+	resp, err := http.DefaultClient.Do(r)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// The caller is subscribed once the headers are sent
+	_, err = k8s.RbacV1().RoleBindings("ns2").Create(testCtx, testRoleBinding("ns2", "alice-ns2", "ClusterRole", "pod-reader", testUser("alice")), meta_v1.CreateOptions{})
+	require.NoError(t, err)
+
+	events := bufio.NewScanner(resp.Body)
+	require.True(t, events.Scan())
+	assert.Equal(t, "event: permission-change", events.Text())
+	require.True(t, events.Scan())
+	data, found := strings.CutPrefix(events.Text(), "data: ")
+	require.True(t, found)
+	var change PermissionChange
+	require.NoError(t, json.Unmarshal([]byte(data), &change))
+	assert.Equal(t, "alice", change.User)
+	assert.Len(t, change.Gained, 3)
+	assert.Equal(t, []RBACObjectRef{{Kind: "RoleBinding", Namespace: "ns2", Name: "alice-ns2"}}, change.Causes)
+}
@@ -0,0 +1,174 @@
+package business_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	kube_fake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiali/kiali/business"
+	"github.com/kiali/kiali/business/permissionstest"
+)
+
+const stressScenario = `
+allow alice get pods in ns1
+allow group:developers * deployments.apps in ns1
+`
+
+// stressIterations is the number of times each worker runs every operation, lowered by -short.
+func stressIterations() int {
+	if testing.Short() {
+		return 20
+	}
+	return 200
+}
+
+func stressConfigs() []*business.PermissionsConfig {
+	enforce := business.NewPermissionsConfig()
+	short := business.NewPermissionsConfig()
+	short.CacheTTL = time.Millisecond
+	short.CacheMaxEntries = 10
+	uncached := business.NewPermissionsConfig()
+	uncached.CacheTTL = 0
+	return []*business.PermissionsConfig{enforce, short, uncached}
+}
+
+// stressRequests are the checks of the stress tests, for users with and without access.
+var stressRequests = []struct {
+	user business.UserInfo
+	req  business.AccessRequest
+}{
+	{business.UserInfo{Name: "alice"}, business.AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}},
+	{business.UserInfo{Name: "alice"}, business.AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "get"}},
+	{business.UserInfo{Name: "carol", Groups: []string{"developers"}}, business.AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "patch"}},
+	{business.UserInfo{Name: "bob"}, business.AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"}},
+}
+
+// assertStressDecisions checks that the checker still decides as the scenario once the stress is over.
+func assertStressDecisions(t *testing.T, checker *business.PermissionChecker) {
+	t.Helper()
+	require.NoError(t, checker.ApplyConfig(business.NewPermissionsConfig()))
+	require.NoError(t, checker.PurgeCache(context.Background()))
+	for i, expected := range []bool{true, false, true, false} {
+		check := stressRequests[i]
+		decision, err := checker.Check(context.Background(), check.user, check.req)
+		require.NoError(t, err)
+		assert.Equal(t, expected, decision.Allowed, "%s %s", check.user.Name, business.FormatAccessRequest(check.req))
+	}
+}
+
+func TestCheckerStress(t *testing.T) {
+	client := permissionstest.MustNewFakePermissionsClient(stressScenario)
+	checker := business.NewPermissionChecker(client)
+	configs := stressConfigs()
+	ctx := context.Background()
+
+	permissionstest.Hammer(t, 8, stressIterations(),
+		func(worker, i int) {
+			check := stressRequests[(worker+i)%len(stressRequests)]
+			if _, err := checker.Check(ctx, check.user, check.req); err != nil {
+				t.Error(err)
+			}
+		},
+		func(worker, i int) {
+			if err := checker.ApplyConfig(configs[(worker+i)%len(configs)]); err != nil {
+				t.Error(err)
+			}
+		},
+		func(worker, i int) {
+			slices := business.UserCacheSlices(stressRequests[i%len(stressRequests)].user.Name)
+			if i%10 == 0 {
+				slices = nil
+			}
+			if err := checker.InvalidateCache(ctx, slices); err != nil {
+				t.Error(err)
+			}
+		},
+		func(worker, i int) {
+			hint := business.PrefetchHint{Namespaces: []string{"ns1", "ns2"}, Resources: []business.PrefetchResource{{Resource: "pods", Verbs: []string{"get", "list"}}}}
+			if _, err := checker.Prefetch(ctx, business.UserInfo{Name: fmt.Sprintf("user-%d", i%5)}, hint); err != nil {
+				t.Error(err)
+			}
+		},
+	)
+
+	assertStressDecisions(t, checker)
+}
+
+func TestCheckerAndWatcherStress(t *testing.T) {
+	k8s := kube_fake.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(k8s, 0)
+	watcher := business.NewPermissionWatcher(factory)
+	bindingLister := factory.Rbac().V1().RoleBindings().Lister()
+	checker := business.NewPermissionChecker(permissionstest.MustNewFakePermissionsClient(stressScenario))
+	watcher.SetChangeHandler(func(causes []business.RBACObjectRef, slices []business.CacheSlice) {
+		if err := checker.InvalidateCache(context.Background(), slices); err != nil {
+			t.Error(err)
+		}
+	})
+	stop := make(chan struct{})
+	defer close(stop)
+	require.NoError(t, watcher.Start(stop))
+	ctx := context.Background()
+
+	permissionstest.Hammer(t, 8, stressIterations(),
+		func(worker, i int) {
+			check := stressRequests[(worker+i)%len(stressRequests)]
+			if _, err := checker.Check(ctx, check.user, check.req); err != nil {
+				t.Error(err)
+			}
+		},
+		func(worker, i int) {
+			// Every worker adds and removes its own binding, so the watcher sees a stream of updates
+			name := fmt.Sprintf("stress-%d", worker)
+			bindings := k8s.RbacV1().RoleBindings("ns1")
+			if i%2 == 0 {
+				_, _ = bindings.Create(ctx, &rbac_v1.RoleBinding{
+					ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns1", Name: name},
+					RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: "view"},
+					Subjects:   []rbac_v1.Subject{{Kind: rbac_v1.UserKind, APIGroup: rbac_v1.GroupName, Name: fmt.Sprintf("user-%d", worker)}},
+				}, meta_v1.CreateOptions{})
+			} else {
+				_ = bindings.Delete(ctx, name, meta_v1.DeleteOptions{})
+			}
+			// The watches of the fake clientset panic when 100 events are pending, so the workers wait for the
+			// informers to see their change before the next one
+			for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Microsecond) {
+				if _, err := bindingLister.RoleBindings("ns1").Get(name); (err == nil) == (i%2 == 0) {
+					return
+				}
+			}
+			t.Errorf("binding %s not seen by the informers", name)
+		},
+		func(worker, i int) {
+			changes, cancel := watcher.Watch(business.UserInfo{Name: fmt.Sprintf("user-%d", worker)})
+			select {
+			case <-changes:
+			default:
+			}
+			cancel()
+		},
+		func(worker, i int) {
+			if snapshot := watcher.Snapshot(); snapshot != nil {
+				snapshot.EffectivePermissions(business.UserInfo{Name: "alice"})
+			}
+		},
+		func(worker, i int) {
+			if i%10 == 0 {
+				if err := checker.ApplyConfig(stressConfigs()[worker%3]); err != nil {
+					t.Error(err)
+				}
+			}
+		},
+	)
+
+	assertStressDecisions(t, checker)
+}
@@ -0,0 +1,63 @@
+package business
+
+// symbolTable maps the strings repeated across many entries, like the principals and the requests of the
+// cached decisions, to small integers, so each entry holds integers instead of its own copies. The
+// symbols are reference counted, and their integers reused once released, so the table does not grow
+// beyond the live entries. It is not safe for concurrent use: its owner locks it.
+type symbolTable struct {
+	ids   map[string]uint32
+	names []string
+	refs  []int
+	// free are the released integers, reused before growing names.
+	free []uint32
+}
+
+func newSymbolTable() *symbolTable {
+	return &symbolTable{ids: map[string]uint32{}}
+}
+
+// lookup returns the symbol of the name, if it is in use.
+func (in *symbolTable) lookup(name string) (uint32, bool) {
+	id, ok := in.ids[name]
+	return id, ok
+}
+
+// acquire returns the symbol of the name, adding it if needed, and takes a reference on it.
+func (in *symbolTable) acquire(name string) uint32 {
+	if id, ok := in.ids[name]; ok {
+		in.refs[id]++
+		return id
+	}
+	var id uint32
+	if n := len(in.free); n > 0 {
+		id = in.free[n-1]
+		in.free = in.free[:n-1]
+		in.names[id], in.refs[id] = name, 1
+	} else {
+		id = uint32(len(in.names))
+		in.names = append(in.names, name)
+		in.refs = append(in.refs, 1)
+	}
+	in.ids[name] = id
+	return id
+}
+
+// release drops a reference on the symbol, and removes it with the last one.
+func (in *symbolTable) release(id uint32) {
+	if in.refs[id]--; in.refs[id] > 0 {
+		return
+	}
+	delete(in.ids, in.names[id])
+	in.names[id] = ""
+	in.free = append(in.free, id)
+}
+
+// name returns the interned name of the symbol, shared by all its users.
+func (in *symbolTable) name(id uint32) string {
+	return in.names[id]
+}
+
+// len returns the number of symbols in use.
+func (in *symbolTable) len() int {
+	return len(in.ids)
+}
@@ -0,0 +1,50 @@
+package business
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSymbolTable(t *testing.T) {
+	table := newSymbolTable()
+	pods := table.acquire("pods")
+	assert.Equal(t, pods, table.acquire("pods"))
+	secrets := table.acquire("secrets")
+	assert.NotEqual(t, pods, secrets)
+	assert.Equal(t, "secrets", table.name(secrets))
+	assert.Equal(t, 2, table.len())
+
+	// The symbol stays until its last reference is released
+	table.release(pods)
+	id, ok := table.lookup("pods")
+	assert.True(t, ok)
+	assert.Equal(t, pods, id)
+	table.release(pods)
+	_, ok = table.lookup("pods")
+	assert.False(t, ok)
+	assert.Equal(t, 1, table.len())
+
+	// The released integers are reused
+	assert.Equal(t, pods, table.acquire("nodes"))
+	assert.Equal(t, "nodes", table.name(pods))
+}
+
+func TestMemoryDecisionCacheReleasesReplacedReasons(t *testing.T) {
+	cache := newMemoryDecisionCache(0)
+	key := decisionCacheKey(UserInfo{Name: "alice"}, alicePods)
+
+	require.NoError(t, cache.Set(testCtx, key, Decision{Allowed: true, Reason: "bound by alice-pods"}, time.Minute))
+	assert.Equal(t, 3, cache.symbolCount())
+	require.NoError(t, cache.Set(testCtx, key, Decision{Reason: "no binding"}, time.Minute))
+	assert.Equal(t, 3, cache.symbolCount())
+
+	decision, ok, err := cache.Get(testCtx, key)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, Decision{Reason: "no binding"}, decision)
+	_, ok = cache.symbols.lookup("bound by alice-pods")
+	assert.False(t, ok)
+}
@@ -0,0 +1,143 @@
+package business
+
+import (
+	"sort"
+	"strings"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// SynthesizeRole returns the smallest set of PolicyRules allowing all the requirements and nothing
+// else. Requirements are merged when it does not widen the access: verbs on the same resource,
+// resources sharing the same verbs and names, and API groups sharing the same rule body.
+// The Namespace of the requirements is ignored; see SynthesizeRoleManifests.
+func SynthesizeRole(requirements []AccessRequest) []rbac_v1.PolicyRule {
+	type target struct{ apiGroup, resource string }
+
+	// Verbs allowed on any object, and on named objects, of each resource
+	anyName := map[target]map[string]bool{}
+	byName := map[target]map[string]map[string]bool{}
+	for _, req := range requirements {
+		t := target{apiGroup: req.APIGroup, resource: req.Resource}
+		if req.Subresource != "" {
+			t.resource = req.Resource + "/" + req.Subresource
+		}
+		if req.Name == "" {
+			if anyName[t] == nil {
+				anyName[t] = map[string]bool{}
+			}
+			anyName[t][req.Verb] = true
+			continue
+		}
+		if byName[t] == nil {
+			byName[t] = map[string]map[string]bool{}
+		}
+		if byName[t][req.Name] == nil {
+			byName[t][req.Name] = map[string]bool{}
+		}
+		byName[t][req.Name][req.Verb] = true
+	}
+
+	// Atoms are (apiGroup, resource, names, verbs) tuples; named verbs already allowed on any name are dropped
+	type atom struct {
+		apiGroup, resource string
+		names, verbs       []string
+	}
+	atoms := []atom{}
+	for t, verbs := range anyName {
+		atoms = append(atoms, atom{apiGroup: t.apiGroup, resource: t.resource, verbs: sortedKeys(verbs)})
+	}
+	for t, names := range byName {
+		namesByVerbs := map[string][]string{}
+		for name, verbs := range names {
+			remaining := map[string]bool{}
+			for verb := range verbs {
+				if !anyName[t][verb] {
+					remaining[verb] = true
+				}
+			}
+			if len(remaining) > 0 {
+				key := strings.Join(sortedKeys(remaining), ",")
+				namesByVerbs[key] = append(namesByVerbs[key], name)
+			}
+		}
+		for verbs, names := range namesByVerbs {
+			sort.Strings(names)
+			atoms = append(atoms, atom{apiGroup: t.apiGroup, resource: t.resource, names: names, verbs: strings.Split(verbs, ",")})
+		}
+	}
+
+	// Merge the resources sharing API group, names and verbs
+	resourcesByBody := map[string][]string{}
+	for _, a := range atoms {
+		key := a.apiGroup + "|" + strings.Join(a.names, ",") + "|" + strings.Join(a.verbs, ",")
+		resourcesByBody[key] = append(resourcesByBody[key], a.resource)
+	}
+
+	// Merge the API groups sharing resources, names and verbs
+	groupsByBody := map[string][]string{}
+	for key, resources := range resourcesByBody {
+		parts := strings.SplitN(key, "|", 2)
+		sort.Strings(resources)
+		body := strings.Join(resources, ",") + "|" + parts[1]
+		groupsByBody[body] = append(groupsByBody[body], parts[0])
+	}
+
+	rules := make([]rbac_v1.PolicyRule, 0, len(groupsByBody))
+	for body, apiGroups := range groupsByBody {
+		parts := strings.Split(body, "|")
+		sort.Strings(apiGroups)
+		rule := rbac_v1.PolicyRule{
+			APIGroups: apiGroups,
+			Resources: strings.Split(parts[0], ","),
+			Verbs:     strings.Split(parts[2], ","),
+		}
+		if parts[1] != "" {
+			rule.ResourceNames = strings.Split(parts[1], ",")
+		}
+		rules = append(rules, rule)
+	}
+	sortPolicyRules(rules)
+
+	return rules
+}
+
+// SynthesizeRoleManifests synthesizes the rules of the requirements of each namespace and renders
+// them as a Role and RoleBinding per namespace, plus a ClusterRole and ClusterRoleBinding for
+// the cluster-scoped requirements. All the objects are named after the given name.
+func SynthesizeRoleManifests(name string, requirements []AccessRequest, subjects []rbac_v1.Subject) (string, error) {
+	return RenderManifests(SynthesizeRoleObjects(name, requirements, subjects))
+}
+
+// SynthesizeRoleObjects returns the objects rendered by SynthesizeRoleManifests, e.g. to render them
+// with RenderTerraform instead.
+func SynthesizeRoleObjects(name string, requirements []AccessRequest, subjects []rbac_v1.Subject) []runtime.Object {
+	byNamespace := map[string][]AccessRequest{}
+	for _, req := range requirements {
+		byNamespace[req.Namespace] = append(byNamespace[req.Namespace], req)
+	}
+	namespaces := make([]string, 0, len(byNamespace))
+	for ns := range byNamespace {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	objects := []runtime.Object{}
+	for _, ns := range namespaces {
+		objects = append(objects, buildRoleManifests(manifestName(name), ns, SynthesizeRole(byNamespace[ns]), subjects)...)
+	}
+	return objects
+}
+
+// sortPolicyRules sorts the rules in a deterministic order, so generated manifests are stable.
+// The core API group sorts first.
+func sortPolicyRules(rules []rbac_v1.PolicyRule) {
+	// The NUL separator sorts before any name, so a shorter list sorts before the lists it prefixes
+	key := func(r rbac_v1.PolicyRule) string {
+		return strings.Join(r.APIGroups, ",") + "\x00" + strings.Join(r.Resources, ",") + "\x00" + strings.Join(r.ResourceNames, ",") + "\x00" + strings.Join(r.Verbs, ",")
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return key(rules[i]) < key(rules[j])
+	})
+}
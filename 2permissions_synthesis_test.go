@@ -0,0 +1,80 @@
+package business
+
+import (
+	"strings"
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSynthesizeRole(t *testing.T) {
+	rules := SynthesizeRole([]AccessRequest{
+		{Resource: "pods", Verb: "get"},
+		{Resource: "pods", Verb: "list"},
+		{Resource: "services", Verb: "list"},
+		{Resource: "services", Verb: "get"},
+		{APIGroup: "apps", Resource: "deployments", Verb: "get"},
+		{APIGroup: "extensions", Resource: "deployments", Verb: "get"},
+		{Resource: "configmaps", Name: "settings", Verb: "get"},
+		{Resource: "configmaps", Name: "features", Verb: "get"},
+		{Resource: "pods", Name: "web", Verb: "get"},
+		{Resource: "pods", Subresource: "log", Verb: "get"},
+	})
+
+	assert.Equal(t, []rbac_v1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{"features", "settings"}, Verbs: []string{"get"}},
+		{APIGroups: []string{""}, Resources: []string{"pods", "services"}, Verbs: []string{"get", "list"}},
+		{APIGroups: []string{""}, Resources: []string{"pods/log"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"apps", "extensions"}, Resources: []string{"deployments"}, Verbs: []string{"get"}},
+	}, rules)
+}
+
+func TestSynthesizeRoleDoesNotWidenTheAccess(t *testing.T) {
+	requirements := []AccessRequest{
+		{Resource: "pods", Verb: "get"},
+		{Resource: "services", Verb: "list"},
+		{Resource: "secrets", Name: "token", Verb: "get"},
+	}
+	rules := SynthesizeRole(requirements)
+	assert.Len(t, rules, 3)
+
+	snapshot := testSnapshot(
+		testClusterRole("synthesized", rules...),
+		testClusterRoleBinding("alice", "synthesized", testUser("alice")),
+	)
+	alice := UserInfo{Name: "alice"}
+	for _, req := range requirements {
+		assert.True(t, snapshot.Explain(alice, req).Allowed, req)
+	}
+	for _, req := range []AccessRequest{
+		{Resource: "pods", Verb: "list"},
+		{Resource: "services", Verb: "get"},
+		{Resource: "secrets", Name: "other", Verb: "get"},
+		{Resource: "secrets", Verb: "get"},
+	} {
+		assert.False(t, snapshot.Explain(alice, req).Allowed, req)
+	}
+}
+
+func TestSynthesizeRoleManifests(t *testing.T) {
+	manifests, err := SynthesizeRoleManifests("Team A", []AccessRequest{
+		{Namespace: "ns2", Resource: "pods", Verb: "get"},
+		{Namespace: "ns1", Resource: "pods", Verb: "list"},
+		{Resource: "nodes", Verb: "get"},
+	}, []rbac_v1.Subject{testGroup("team-a")})
+	require.NoError(t, err)
+
+	docs := strings.Split(manifests, "---\n")
+	require.Len(t, docs, 6)
+	// The cluster-scoped requirements come first
+	assert.Contains(t, docs[0], "kind: ClusterRole\n")
+	assert.Contains(t, docs[1], "kind: ClusterRoleBinding\n")
+	assert.Contains(t, docs[2], "namespace: ns1\n")
+	assert.Contains(t, docs[4], "namespace: ns2\n")
+	for _, doc := range docs {
+		assert.Contains(t, doc, "name: team-a\n")
+	}
+}
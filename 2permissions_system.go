@@ -0,0 +1,120 @@
+package business
+
+import (
+	"strings"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BootstrapLabel is set by the apiserver on the default RBAC objects it creates at startup.
+const BootstrapLabel = "kubernetes.io/bootstrapping"
+
+// SystemFilter selects the system RBAC objects left out of the reports and who-can output, since audits
+// usually care about the access granted by humans only.
+type SystemFilter struct {
+	// Roles omits the system subjects of the bindings to the system:* roles, and the system:* roles left
+	// without bindings. The bindings of the other subjects to system:* roles are kept, they grant real
+	// privileges.
+	Roles bool `yaml:"roles"`
+	// Subjects omits the kube-system ServiceAccounts and the system:* users of the control plane. Groups
+	// are kept: bindings to system:authenticated or system:serviceaccounts are usually made by humans.
+	Subjects bool `yaml:"subjects"`
+	// BootstrapBindings omits the bindings created by the apiserver at startup.
+	BootstrapBindings bool `yaml:"bootstrap_bindings"`
+}
+
+// ExcludeAllSystem filters out every kind of system RBAC objects.
+var ExcludeAllSystem = SystemFilter{Roles: true, Subjects: true, BootstrapBindings: true}
+
+// WithoutSystem returns a snapshot without the system objects selected by the filter. Bindings left
+// without subjects are removed.
+func (in *RBACSnapshot) WithoutSystem(filter SystemFilter) *RBACSnapshot {
+	filtered := &RBACSnapshot{
+		ClusterRoles:        make(map[string]*rbac_v1.ClusterRole, len(in.ClusterRoles)),
+		Roles:               make(map[string]*rbac_v1.Role, len(in.Roles)),
+		ClusterRoleBindings: make([]*rbac_v1.ClusterRoleBinding, 0, len(in.ClusterRoleBindings)),
+		RoleBindings:        make([]*rbac_v1.RoleBinding, 0, len(in.RoleBindings)),
+		LoadedAt:            in.LoadedAt,
+	}
+	// The system roles still bound, by key of ClusterRoles or Roles
+	boundClusterRoles, boundRoles := map[string]bool{}, map[string]bool{}
+	for _, crb := range in.ClusterRoleBindings {
+		if filter.excludesBinding(crb.ObjectMeta) {
+			continue
+		}
+		if subjects := filter.subjects(crb.Subjects, "", crb.RoleRef); len(subjects) > 0 {
+			copied := *crb
+			copied.Subjects = subjects
+			filtered.ClusterRoleBindings = append(filtered.ClusterRoleBindings, &copied)
+			boundClusterRoles[crb.RoleRef.Name] = true
+		}
+	}
+	for _, rb := range in.RoleBindings {
+		if filter.excludesBinding(rb.ObjectMeta) {
+			continue
+		}
+		if subjects := filter.subjects(rb.Subjects, rb.Namespace, rb.RoleRef); len(subjects) > 0 {
+			copied := *rb
+			copied.Subjects = subjects
+			filtered.RoleBindings = append(filtered.RoleBindings, &copied)
+			if rb.RoleRef.Kind == "ClusterRole" {
+				boundClusterRoles[rb.RoleRef.Name] = true
+			} else {
+				boundRoles[rb.Namespace+"/"+rb.RoleRef.Name] = true
+			}
+		}
+	}
+	for key, cr := range in.ClusterRoles {
+		if !filter.Roles || !isSystemName(cr.Name) || boundClusterRoles[key] {
+			filtered.ClusterRoles[key] = cr
+		}
+	}
+	for key, r := range in.Roles {
+		if !filter.Roles || !isSystemName(r.Name) || boundRoles[key] {
+			filtered.Roles[key] = r
+		}
+	}
+	return filtered
+}
+
+func (in SystemFilter) excludesBinding(meta meta_v1.ObjectMeta) bool {
+	return in.BootstrapBindings && meta.Labels[BootstrapLabel] != ""
+}
+
+// subjects returns the subjects of a binding of the role living in the namespace, without the system ones.
+func (in SystemFilter) subjects(subjects []rbac_v1.Subject, bindingNamespace string, roleRef rbac_v1.RoleRef) []rbac_v1.Subject {
+	if !in.Subjects && !(in.Roles && isSystemName(roleRef.Name)) {
+		return subjects
+	}
+	kept := make([]rbac_v1.Subject, 0, len(subjects))
+	for _, subject := range subjects {
+		if !isSystemSubject(subject, bindingNamespace) {
+			kept = append(kept, subject)
+		}
+	}
+	return kept
+}
+
+// isSystemSubject returns true for the kube-system ServiceAccounts and the system:* users, other than the
+// ServiceAccounts of the other namespaces.
+func isSystemSubject(subject rbac_v1.Subject, bindingNamespace string) bool {
+	switch subject.Kind {
+	case rbac_v1.ServiceAccountKind:
+		namespace := subject.Namespace
+		if namespace == "" {
+			namespace = bindingNamespace
+		}
+		return namespace == meta_v1.NamespaceSystem
+	case rbac_v1.UserKind:
+		if strings.HasPrefix(subject.Name, "system:serviceaccount:") {
+			return strings.HasPrefix(subject.Name, "system:serviceaccount:"+meta_v1.NamespaceSystem+":")
+		}
+		return isSystemName(subject.Name)
+	}
+	return false
+}
+
+func isSystemName(name string) bool {
+	return strings.HasPrefix(name, "system:")
+}
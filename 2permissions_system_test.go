@@ -0,0 +1,108 @@
+package business
+
+import (
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// systemObjects are the usual fixture plus system roles, subjects and bootstrap bindings.
+func systemObjects() []runtime.Object {
+	bootstrap := testClusterRoleBinding("cluster-admin", "pod-reader", testGroup("system:masters"))
+	bootstrap.Labels = map[string]string{BootstrapLabel: "rbac-defaults"}
+	return append(podReaderObjects(),
+		testClusterRole("system:viewer", testRule([]string{""}, []string{"nodes"}, []string{"get"})),
+		testClusterRoleBinding("system:viewer", "system:viewer", testGroup("ops")),
+		testClusterRole("system:kube-scheduler", testRule([]string{""}, []string{"pods"}, []string{"list"})),
+		testClusterRoleBinding("system:kube-scheduler", "system:kube-scheduler", testUser("system:kube-scheduler")),
+		bootstrap,
+		testClusterRoleBinding("controllers", "pod-reader",
+			rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Namespace: "kube-system", Name: "replicaset-controller"},
+			testUser("system:kube-scheduler"),
+			testUser("system:serviceaccount:kube-system:namespace-controller"),
+		),
+		testRoleBinding("kube-system", "kube-system-sa", "ClusterRole", "pod-reader",
+			rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Name: "coredns"},
+		),
+		testRoleBinding("ns1", "apps", "ClusterRole", "pod-reader",
+			rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Name: "app"},
+			testUser("system:serviceaccount:ns1:web"),
+			testUser("system:kube-proxy"),
+			testGroup("system:authenticated"),
+		),
+	)
+}
+
+func bindingNames(snapshot *RBACSnapshot) []string {
+	names := []string{}
+	for _, crb := range snapshot.ClusterRoleBindings {
+		names = append(names, crb.Name)
+	}
+	for _, rb := range snapshot.RoleBindings {
+		names = append(names, rb.Namespace+"/"+rb.Name)
+	}
+	return names
+}
+
+func TestWithoutSystem(t *testing.T) {
+	snapshot := testSnapshot(systemObjects()...)
+
+	filtered := snapshot.WithoutSystem(ExcludeAllSystem)
+	// The binding of the ops group to a system role grants real privileges, it is kept with its role
+	assert.ElementsMatch(t, []string{"bob-pods", "system:viewer", "ns1/alice-pods", "ns1/developers-deployments", "ns1/apps"}, bindingNames(filtered))
+	assert.Contains(t, filtered.ClusterRoles, "system:viewer")
+	assert.NotContains(t, filtered.ClusterRoles, "system:kube-scheduler")
+	assert.Contains(t, filtered.ClusterRoles, "pod-reader")
+	for _, rb := range filtered.RoleBindings {
+		if rb.Name == "apps" {
+			assert.Equal(t, []rbac_v1.Subject{
+				{Kind: rbac_v1.ServiceAccountKind, Name: "app"},
+				testUser("system:serviceaccount:ns1:web"),
+				testGroup("system:authenticated"),
+			}, rb.Subjects)
+		}
+	}
+
+	// The snapshot is not modified
+	assert.Len(t, snapshot.ClusterRoleBindings, 5)
+	assert.Len(t, snapshot.RoleBindings, 4)
+	assert.ElementsMatch(t, bindingNames(snapshot), bindingNames(snapshot.WithoutSystem(SystemFilter{})))
+}
+
+func TestWithoutSystemFilters(t *testing.T) {
+	snapshot := testSnapshot(systemObjects()...)
+
+	// Only the system subjects of the bindings to system roles are omitted
+	roles := snapshot.WithoutSystem(SystemFilter{Roles: true})
+	assert.ElementsMatch(t, []string{"bob-pods", "system:viewer", "cluster-admin", "controllers", "ns1/alice-pods", "ns1/developers-deployments", "kube-system/kube-system-sa", "ns1/apps"},
+		bindingNames(roles))
+	assert.Contains(t, roles.ClusterRoles, "system:viewer")
+	assert.NotContains(t, roles.ClusterRoles, "system:kube-scheduler")
+	assert.ElementsMatch(t, []string{"bob-pods", "system:viewer", "system:kube-scheduler", "controllers", "ns1/alice-pods", "ns1/developers-deployments", "kube-system/kube-system-sa", "ns1/apps"},
+		bindingNames(snapshot.WithoutSystem(SystemFilter{BootstrapBindings: true})))
+	assert.ElementsMatch(t, []string{"bob-pods", "system:viewer", "cluster-admin", "ns1/alice-pods", "ns1/developers-deployments", "ns1/apps"},
+		bindingNames(snapshot.WithoutSystem(SystemFilter{Subjects: true})))
+}
+
+func TestIsSystemSubject(t *testing.T) {
+	cases := []struct {
+		subject          rbac_v1.Subject
+		bindingNamespace string
+		system           bool
+	}{
+		{rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Namespace: "kube-system", Name: "default"}, "", true},
+		{rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Name: "default"}, "kube-system", true},
+		{rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Name: "default"}, "ns1", false},
+		{testUser("system:kube-controller-manager"), "", true},
+		{testUser("system:serviceaccount:kube-system:default"), "", true},
+		{testUser("system:serviceaccount:ns1:default"), "", false},
+		{testUser("alice"), "", false},
+		{testGroup("system:masters"), "", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.system, isSystemSubject(c.subject, c.bindingNamespace), "%+v in %q", c.subject, c.bindingNamespace)
+	}
+}
@@ -0,0 +1,88 @@
+package business
+
+import (
+	"sort"
+	"strings"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// DefaultTenancyLabel is the label of the multi-tenancy convention naming the group owning a namespace.
+const DefaultTenancyLabel = "tenant"
+
+// TenancyConvention describes how namespaces are assigned to tenants, i.e. groups, with labels or
+// annotations, e.g. tenant=team-a. Annotation values can list several groups, separated by commas.
+type TenancyConvention struct {
+	// Labels are the keys of the namespace labels whose values are tenant groups. Empty means
+	// DefaultTenancyLabel, unless Annotations is set.
+	Labels []string `yaml:"labels"`
+	// Annotations are the keys of the namespace annotations whose values are comma-separated tenant groups.
+	Annotations []string `yaml:"annotations"`
+}
+
+// TenancyResolution is the result of ResolveTenantNamespaces. Namespace lists are sorted.
+type TenancyResolution struct {
+	User UserInfo
+	// Namespaces are the tenant namespaces of the user where RBAC grants the probe request.
+	Namespaces []string
+	// TenantOnly are the tenant namespaces of the user where RBAC does not grant the probe request,
+	// usually a missing RoleBinding.
+	TenantOnly []string
+	// RBACOnly are the namespaces where RBAC grants the probe request but which are not tenant
+	// namespaces of the user, e.g. shared namespaces or leftover bindings.
+	RBACOnly []string
+}
+
+// Tenants returns the groups owning the namespace according to the convention.
+func (in TenancyConvention) Tenants(namespace *core_v1.Namespace) []string {
+	labels := in.Labels
+	if len(labels) == 0 && len(in.Annotations) == 0 {
+		labels = []string{DefaultTenancyLabel}
+	}
+	set := map[string]bool{}
+	for _, key := range labels {
+		if value := namespace.Labels[key]; value != "" {
+			set[value] = true
+		}
+	}
+	for _, key := range in.Annotations {
+		for _, value := range strings.Split(namespace.Annotations[key], ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				set[value] = true
+			}
+		}
+	}
+	return sortedKeys(set)
+}
+
+// ResolveTenantNamespaces returns the tenant namespaces of the user, those owned by one of its groups,
+// intersected with the namespaces where the snapshot grants the probe request, e.g. list pods. The
+// namespace of the probe is ignored.
+func (in TenancyConvention) ResolveTenantNamespaces(snapshot *RBACSnapshot, namespaces []core_v1.Namespace, user UserInfo, probe AccessRequest) *TenancyResolution {
+	resolution := &TenancyResolution{User: user, Namespaces: []string{}, TenantOnly: []string{}, RBACOnly: []string{}}
+	for i := range namespaces {
+		namespace := namespaces[i].Name
+		tenant := false
+		for _, group := range in.Tenants(&namespaces[i]) {
+			if containsString(user.Groups, group) {
+				tenant = true
+				break
+			}
+		}
+		probe.Namespace = namespace
+		allowed := snapshot.Explain(user, probe).Allowed
+
+		switch {
+		case tenant && allowed:
+			resolution.Namespaces = append(resolution.Namespaces, namespace)
+		case tenant:
+			resolution.TenantOnly = append(resolution.TenantOnly, namespace)
+		case allowed:
+			resolution.RBACOnly = append(resolution.RBACOnly, namespace)
+		}
+	}
+	sort.Strings(resolution.Namespaces)
+	sort.Strings(resolution.TenantOnly)
+	sort.Strings(resolution.RBACOnly)
+	return resolution
+}
@@ -0,0 +1,47 @@
+package business
+
+import (
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tenantNamespace(name string, labels, annotations map[string]string) core_v1.Namespace {
+	return core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations}}
+}
+
+func TestTenancyConventionTenants(t *testing.T) {
+	namespace := tenantNamespace("ns1",
+		map[string]string{"tenant": "developers", "team": "web"},
+		map[string]string{"tenants": "ops, sre,,web"},
+	)
+
+	assert.Equal(t, []string{"developers"}, TenancyConvention{}.Tenants(&namespace))
+	assert.Equal(t, []string{"ops", "sre", "web"}, TenancyConvention{Annotations: []string{"tenants"}}.Tenants(&namespace))
+	assert.Equal(t, []string{"developers", "ops", "sre", "web"}, TenancyConvention{Labels: []string{"tenant", "team"}, Annotations: []string{"tenants"}}.Tenants(&namespace))
+	assert.Empty(t, TenancyConvention{Labels: []string{"owner"}}.Tenants(&namespace))
+}
+
+func TestResolveTenantNamespaces(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+	namespaces := []core_v1.Namespace{
+		tenantNamespace("ns3", map[string]string{"tenant": "ops"}, nil),
+		tenantNamespace("ns2", map[string]string{"tenant": "developers"}, nil),
+		tenantNamespace("ns1", map[string]string{"tenant": "developers"}, nil),
+	}
+
+	// carol edits the deployments of ns1 only
+	carol := UserInfo{Name: "carol", Groups: []string{"developers"}}
+	resolution := TenancyConvention{}.ResolveTenantNamespaces(snapshot, namespaces, carol, AccessRequest{APIGroup: "apps", Resource: "deployments", Verb: "list"})
+	assert.Equal(t, &TenancyResolution{User: carol, Namespaces: []string{"ns1"}, TenantOnly: []string{"ns2"}, RBACOnly: []string{}}, resolution)
+
+	// bob reads the pods of every namespace
+	bob := UserInfo{Name: "bob", Groups: []string{"developers"}}
+	resolution = TenancyConvention{}.ResolveTenantNamespaces(snapshot, namespaces, bob, AccessRequest{Namespace: "ignored", Resource: "pods", Verb: "list"})
+	assert.Equal(t, []string{"ns1", "ns2"}, resolution.Namespaces)
+	assert.Empty(t, resolution.TenantOnly)
+	assert.Equal(t, []string{"ns3"}, resolution.RBACOnly)
+}
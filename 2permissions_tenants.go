@@ -0,0 +1,164 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// ErrUnknownTenant is returned for the tenants not registered in a TenantRegistry.
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// TenantRegistry manages an independent checker, with its own config, cache and MaxConcurrentAPIRequests,
+// per tenant, e.g. per cluster or set of credentials. Tenants can be added and removed at runtime. It is safe for concurrent use.
+//
+// The settings applying to the whole process, see processSettings, cannot differ between the tenants: the
+// configs of a tenant, when added or later applied, are rejected if they would change them for the others.
+type TenantRegistry struct {
+	mu       sync.RWMutex
+	checkers map[string]*PermissionChecker
+	// settings are the process-wide settings of the configs of the tenants, including the ones being added.
+	settings map[string]processSettings
+}
+
+// NewTenantRegistry creates an empty registry.
+func NewTenantRegistry() *TenantRegistry {
+	return &TenantRegistry{checkers: map[string]*PermissionChecker{}, settings: map[string]processSettings{}}
+}
+
+// Add registers a tenant whose checks are made with the client. A nil conf means the default config.
+// Unless the conf sets a CacheKeyPrefix, the tenant name is used, so tenants sharing a Redis server do
+// not share decisions.
+func (in *TenantRegistry) Add(name string, client PermissionsClient, conf *PermissionsConfig) (*PermissionChecker, error) {
+	if conf == nil {
+		conf = NewPermissionsConfig()
+	}
+	tenantConf := *conf
+	if tenantConf.CacheKeyPrefix == "" {
+		tenantConf.CacheKeyPrefix = "tenant:" + name + ":"
+	}
+	if _, exists := in.Checker(name); exists {
+		return nil, fmt.Errorf("tenant %s already exists", name)
+	}
+	checker := NewPermissionChecker(client)
+	checker.claimProcessSettings = func(conf *PermissionsConfig) error {
+		return in.claimProcessSettings(name, conf)
+	}
+	if err := checker.ApplyConfig(&tenantConf); err != nil {
+		in.releaseProcessSettings(name)
+		return nil, fmt.Errorf("invalid config of tenant %s: %w", name, err)
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if _, exists := in.checkers[name]; exists {
+		return nil, fmt.Errorf("tenant %s already exists", name)
+	}
+	in.checkers[name] = checker
+	return checker, nil
+}
+
+// Remove unregisters the tenant and purges its cached decisions. Checks in flight complete normally.
+func (in *TenantRegistry) Remove(ctx context.Context, name string) error {
+	in.mu.Lock()
+	checker, ok := in.checkers[name]
+	delete(in.checkers, name)
+	delete(in.settings, name)
+	in.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w %s", ErrUnknownTenant, name)
+	}
+	return checker.PurgeCache(ctx)
+}
+
+// Checker returns the checker of the tenant, e.g. to apply a new config to it.
+func (in *TenantRegistry) Checker(name string) (*PermissionChecker, bool) {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	checker, ok := in.checkers[name]
+	return checker, ok
+}
+
+// Tenants returns the names of the registered tenants, sorted.
+func (in *TenantRegistry) Tenants() []string {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	names := make([]string, 0, len(in.checkers))
+	for name := range in.checkers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check decides if the user can perform the request in the tenant, see PermissionChecker.Check.
+func (in *TenantRegistry) Check(ctx context.Context, tenant string, user UserInfo, req AccessRequest) (Decision, error) {
+	checker, ok := in.Checker(tenant)
+	if !ok {
+		return Decision{}, fmt.Errorf("%w %s", ErrUnknownTenant, tenant)
+	}
+	return checker.Check(ctx, user, req)
+}
+
+// processSettings are the settings of a config applied to the whole process by
+// PermissionChecker.ApplyConfig, rather than to the checker.
+type processSettings struct {
+	Redaction       RedactionPolicy
+	FeatureGates    FeatureGates
+	ConsumerBudgets []ConsumerBudget
+}
+
+// processSettingsOf returns the process-wide settings of the config.
+func processSettingsOf(conf *PermissionsConfig) processSettings {
+	settings := processSettings{
+		Redaction:       conf.Redaction,
+		FeatureGates:    conf.FeatureGates,
+		ConsumerBudgets: conf.ConsumerBudgets,
+	}
+	if len(settings.ConsumerBudgets) == 0 {
+		settings.ConsumerBudgets = nil
+	}
+	return settings
+}
+
+// equal returns true if both settings have the same effect. The feature gates not set on one side have
+// their default value there.
+func (in processSettings) equal(other processSettings) bool {
+	for _, gates := range []FeatureGates{in.FeatureGates, other.FeatureGates} {
+		for feature := range gates {
+			if in.FeatureGates.Enabled(feature) != other.FeatureGates.Enabled(feature) {
+				return false
+			}
+		}
+	}
+	in.FeatureGates, other.FeatureGates = nil, nil
+	return reflect.DeepEqual(in, other)
+}
+
+// claimProcessSettings records the process-wide settings of the config of the tenant, unless they differ
+// from the ones of the other tenants.
+func (in *TenantRegistry) claimProcessSettings(tenant string, conf *PermissionsConfig) error {
+	settings := processSettingsOf(conf)
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for other, otherSettings := range in.settings {
+		if other != tenant && !settings.equal(otherSettings) {
+			return fmt.Errorf("the redaction, feature_gates and consumer_budgets settings apply to the whole process, and differ from the ones of tenant %s", other)
+		}
+	}
+	in.settings[tenant] = settings
+	return nil
+}
+
+// releaseProcessSettings forgets the process-wide settings of a tenant that could not be added.
+func (in *TenantRegistry) releaseProcessSettings(tenant string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if _, registered := in.checkers[tenant]; !registered {
+		delete(in.settings, tenant)
+	}
+}
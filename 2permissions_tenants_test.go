@@ -0,0 +1,85 @@
+package business
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantRegistryIsolatesTheTenants(t *testing.T) {
+	registry := NewTenantRegistry()
+	east, err := registry.Add("east", newTestClient(&testReviews{allow: allowUsers("alice")}), nil)
+	require.NoError(t, err)
+	_, err = registry.Add("west", newTestClient(&testReviews{allow: allowUsers("bob")}), nil)
+	require.NoError(t, err)
+
+	req := AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}
+	decision, err := registry.Check(testCtx, "east", UserInfo{Name: "alice"}, req)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	decision, err = registry.Check(testCtx, "west", UserInfo{Name: "alice"}, req)
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+
+	_, err = registry.Check(testCtx, "north", UserInfo{Name: "alice"}, req)
+	assert.ErrorIs(t, err, ErrUnknownTenant)
+	assert.Equal(t, []string{"east", "west"}, registry.Tenants())
+
+	checker, ok := registry.Checker("east")
+	require.True(t, ok)
+	assert.Same(t, east, checker)
+
+	_, err = registry.Add("east", newTestClient(&testReviews{}), nil)
+	assert.Error(t, err)
+}
+
+func TestTenantRegistryRejectsDifferentProcessSettings(t *testing.T) {
+	t.Cleanup(func() { SetRedactionPolicy(RedactionPolicy{}) })
+	registry := NewTenantRegistry()
+	conf := NewPermissionsConfig()
+	_, err := registry.Add("east", newTestClient(&testReviews{}), conf)
+	require.NoError(t, err)
+
+	other := NewPermissionsConfig()
+	other.FeatureGates = FeatureGates{FeatureCELAuthorizer: true}
+	_, err = registry.Add("west", newTestClient(&testReviews{}), other)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"east"}, registry.Tenants())
+
+	other.FeatureGates = nil
+	other.CacheTTL = conf.CacheTTL * 2
+	west, err := registry.Add("west", newTestClient(&testReviews{}), other)
+	require.NoError(t, err)
+
+	redacted := *other
+	redacted.Redaction = RedactionPolicy{HashUsernames: true, HashKey: "key"}
+	assert.Error(t, west.ApplyConfig(&redacted))
+
+	// Alone in the registry, the tenant can change them
+	require.NoError(t, registry.Remove(testCtx, "east"))
+	assert.NoError(t, west.ApplyConfig(&redacted))
+}
+
+func TestTenantRegistryIsolatesTheAPIRequestLimits(t *testing.T) {
+	registry := NewTenantRegistry()
+	conf := NewPermissionsConfig()
+	conf.MaxConcurrentAPIRequests = 5
+	east, err := registry.Add("east", newTestClient(&testReviews{}), conf)
+	require.NoError(t, err)
+
+	other := NewPermissionsConfig()
+	other.MaxConcurrentAPIRequests = 50
+	west, err := registry.Add("west", newTestClient(&testReviews{}), other)
+	require.NoError(t, err)
+
+	assert.Equal(t, 5, east.APIRequestLimiter().limit)
+	assert.Equal(t, 50, west.APIRequestLimiter().limit)
+}
+
+func TestProcessSettingsDefaults(t *testing.T) {
+	defaults := processSettingsOf(&PermissionsConfig{})
+	explicit := processSettingsOf(&PermissionsConfig{ConsumerBudgets: []ConsumerBudget{}, FeatureGates: FeatureGates{}})
+
+	assert.True(t, defaults.equal(explicit))
+}
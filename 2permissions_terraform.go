@@ -0,0 +1,128 @@
+package business
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// invalidTerraformNameChars matches the characters not allowed in the names of Terraform resources.
+var invalidTerraformNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// RenderTerraform renders the roles and bindings as the resources of the Terraform kubernetes provider,
+// kubernetes_role, kubernetes_cluster_role, kubernetes_role_binding and kubernetes_cluster_role_binding,
+// for the teams managing RBAC with Terraform. The objects can be synthesized, see SynthesizeRoleObjects,
+// or observed, see RBACSnapshot.Objects. Only the names, rules, role references and subjects are
+// rendered: labels, annotations and aggregation rules are not.
+func RenderTerraform(objects []runtime.Object) (string, error) {
+	var out strings.Builder
+	names := map[string]int{}
+	// resource returns a unique name of the resource, since objects of different namespaces can share a name
+	resource := func(kind, namespace, name string) string {
+		id := name
+		if namespace != "" {
+			id = namespace + "_" + name
+		}
+		id = invalidTerraformNameChars.ReplaceAllString(id, "_")
+		if id == "" || id[0] >= '0' && id[0] <= '9' || id[0] == '-' {
+			id = "_" + id
+		}
+		names[kind+"."+id]++
+		if count := names[kind+"."+id]; count > 1 {
+			id = fmt.Sprintf("%s_%d", id, count)
+		}
+		if out.Len() > 0 {
+			out.WriteString("\n")
+		}
+		fmt.Fprintf(&out, "resource %q %q {\n", kind, id)
+		return id
+	}
+
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *rbac_v1.ClusterRole:
+			resource("kubernetes_cluster_role", "", o.Name)
+			writeTerraformMetadata(&out, o.Name, "")
+			writeTerraformRules(&out, o.Rules)
+		case *rbac_v1.Role:
+			resource("kubernetes_role", o.Namespace, o.Name)
+			writeTerraformMetadata(&out, o.Name, o.Namespace)
+			writeTerraformRules(&out, o.Rules)
+		case *rbac_v1.ClusterRoleBinding:
+			resource("kubernetes_cluster_role_binding", "", o.Name)
+			writeTerraformMetadata(&out, o.Name, "")
+			writeTerraformBinding(&out, o.RoleRef, o.Subjects)
+		case *rbac_v1.RoleBinding:
+			resource("kubernetes_role_binding", o.Namespace, o.Name)
+			writeTerraformMetadata(&out, o.Name, o.Namespace)
+			writeTerraformBinding(&out, o.RoleRef, o.Subjects)
+		default:
+			return "", fmt.Errorf("cannot render %T as Terraform, only roles and bindings are supported", obj)
+		}
+		out.WriteString("}\n")
+	}
+	return out.String(), nil
+}
+
+func writeTerraformMetadata(out *strings.Builder, name, namespace string) {
+	out.WriteString("  metadata {\n")
+	fmt.Fprintf(out, "    name = %s\n", terraformString(name))
+	if namespace != "" {
+		fmt.Fprintf(out, "    namespace = %s\n", terraformString(namespace))
+	}
+	out.WriteString("  }\n")
+}
+
+func writeTerraformRules(out *strings.Builder, rules []rbac_v1.PolicyRule) {
+	for _, rule := range rules {
+		out.WriteString("  rule {\n")
+		if len(rule.NonResourceURLs) > 0 {
+			fmt.Fprintf(out, "    non_resource_urls = %s\n", terraformList(rule.NonResourceURLs))
+		} else {
+			fmt.Fprintf(out, "    api_groups = %s\n", terraformList(rule.APIGroups))
+			fmt.Fprintf(out, "    resources = %s\n", terraformList(rule.Resources))
+			if len(rule.ResourceNames) > 0 {
+				fmt.Fprintf(out, "    resource_names = %s\n", terraformList(rule.ResourceNames))
+			}
+		}
+		fmt.Fprintf(out, "    verbs = %s\n", terraformList(rule.Verbs))
+		out.WriteString("  }\n")
+	}
+}
+
+func writeTerraformBinding(out *strings.Builder, roleRef rbac_v1.RoleRef, subjects []rbac_v1.Subject) {
+	out.WriteString("  role_ref {\n")
+	fmt.Fprintf(out, "    api_group = %s\n", terraformString(roleRef.APIGroup))
+	fmt.Fprintf(out, "    kind = %s\n", terraformString(roleRef.Kind))
+	fmt.Fprintf(out, "    name = %s\n", terraformString(roleRef.Name))
+	out.WriteString("  }\n")
+	for _, subject := range subjects {
+		out.WriteString("  subject {\n")
+		fmt.Fprintf(out, "    kind = %s\n", terraformString(subject.Kind))
+		fmt.Fprintf(out, "    name = %s\n", terraformString(subject.Name))
+		if subject.APIGroup != "" {
+			fmt.Fprintf(out, "    api_group = %s\n", terraformString(subject.APIGroup))
+		}
+		if subject.Namespace != "" {
+			fmt.Fprintf(out, "    namespace = %s\n", terraformString(subject.Namespace))
+		}
+		out.WriteString("  }\n")
+	}
+}
+
+// terraformString quotes the string for HCL, where template sequences must be escaped too.
+func terraformString(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "${", "$${", "%{", "%%{").Replace(s)
+	return `"` + s + `"`
+}
+
+func terraformList(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, terraformString(value))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
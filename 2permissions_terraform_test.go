@@ -0,0 +1,80 @@
+package business
+
+import (
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderTerraform(t *testing.T) {
+	rules := []rbac_v1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{"settings"}, Verbs: []string{"get"}},
+		{NonResourceURLs: []string{"/healthz"}, Verbs: []string{"get"}},
+	}
+	tf, err := RenderTerraform([]runtime.Object{
+		testClusterRole("pod-reader", rules...),
+		testRoleBinding("ns1", "robot", "ClusterRole", "pod-reader", rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Namespace: "ns1", Name: "robot"}),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `resource "kubernetes_cluster_role" "pod-reader" {
+  metadata {
+    name = "pod-reader"
+  }
+  rule {
+    api_groups = [""]
+    resources = ["configmaps"]
+    resource_names = ["settings"]
+    verbs = ["get"]
+  }
+  rule {
+    non_resource_urls = ["/healthz"]
+    verbs = ["get"]
+  }
+}
+
+resource "kubernetes_role_binding" "ns1_robot" {
+  metadata {
+    name = "robot"
+    namespace = "ns1"
+  }
+  role_ref {
+    api_group = "rbac.authorization.k8s.io"
+    kind = "ClusterRole"
+    name = "pod-reader"
+  }
+  subject {
+    kind = "ServiceAccount"
+    name = "robot"
+    namespace = "ns1"
+  }
+}
+`, tf)
+}
+
+func TestRenderTerraformNames(t *testing.T) {
+	tf, err := RenderTerraform([]runtime.Object{
+		testRole("ns1", "1.readers", testRule([]string{""}, []string{"pods"}, []string{"get"})),
+		testRole("ns1", "1:readers", testRule([]string{""}, []string{"pods"}, []string{"list"})),
+		testClusterRoleBinding("oidc:${admins}", "admin", testGroup(`oidc:"admins"`)),
+	})
+	require.NoError(t, err)
+	// The names are valid and unique identifiers, and the strings are escaped
+	assert.Contains(t, tf, `resource "kubernetes_role" "ns1_1_readers" {`)
+	assert.Contains(t, tf, `resource "kubernetes_role" "ns1_1_readers_2" {`)
+	assert.Contains(t, tf, `resource "kubernetes_cluster_role_binding" "oidc_admins_" {`)
+	assert.Contains(t, tf, `    name = "oidc:$${admins}"`)
+	assert.Contains(t, tf, `    name = "oidc:\"admins\""`)
+
+	tf, err = RenderTerraform([]runtime.Object{testClusterRole("1-admin")})
+	require.NoError(t, err)
+	assert.Contains(t, tf, `resource "kubernetes_cluster_role" "_1-admin" {`)
+
+	_, err = RenderTerraform([]runtime.Object{&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Name: "settings"}}})
+	assert.Error(t, err)
+}
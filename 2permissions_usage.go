@@ -0,0 +1,219 @@
+package business
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditDecisionAnnotation is set by the apiserver on the audit events with the authorization decision.
+const auditDecisionAnnotation = "authorization.k8s.io/decision"
+
+// auditEvent holds the fields of the apiserver audit events (audit.k8s.io/v1 Event) used to count usage.
+type auditEvent struct {
+	Stage string `json:"stage"`
+	User  struct {
+		Username string   `json:"username"`
+		Groups   []string `json:"groups"`
+	} `json:"user"`
+	Verb      string `json:"verb"`
+	ObjectRef *struct {
+		APIGroup    string `json:"apiGroup"`
+		Resource    string `json:"resource"`
+		Subresource string `json:"subresource"`
+	} `json:"objectRef"`
+	StageTimestamp time.Time         `json:"stageTimestamp"`
+	Annotations    map[string]string `json:"annotations"`
+}
+
+// usageKey is a cell of the usage heatmap. The resource includes the subresource, e.g. pods/log.
+type usageKey struct {
+	User     string
+	APIGroup string
+	Resource string
+	Verb     string
+}
+
+type usage struct {
+	count    int
+	lastUsed time.Time
+}
+
+// UsageCell is the usage of a permission by a user over the window of the heatmap. Granted cells come
+// from the permissions of the user, possibly with wildcards, and count all the requests they match, so a
+// request can be counted by several cells. Cells not granted are requests matching no permission of the
+// user in the snapshot, e.g. allowed by another authorizer or by a binding deleted since.
+type UsageCell struct {
+	User     string     `json:"user"`
+	APIGroup string     `json:"apiGroup"`
+	Resource string     `json:"resource"`
+	Verb     string     `json:"verb"`
+	Count    int        `json:"count"`
+	LastUsed *time.Time `json:"lastUsed,omitempty"`
+	Granted  bool       `json:"granted"`
+}
+
+// UsageHeatmap holds the usage counts of the permissions over a time window, so dashboards can show hot
+// and dormant (zero count) permissions.
+type UsageHeatmap struct {
+	From  time.Time   `json:"from"`
+	To    time.Time   `json:"to"`
+	Cells []UsageCell `json:"cells"`
+}
+
+// UsageCounter counts the allowed resource requests of the apiserver audit logs in a time window. It is
+// safe for concurrent use, e.g. to ingest the logs of several apiservers.
+type UsageCounter struct {
+	from, to time.Time
+
+	mu     sync.Mutex
+	counts map[usageKey]*usage
+	users  map[string]UserInfo
+}
+
+// NewUsageCounter creates a counter of the requests made from, inclusive, to to, exclusive.
+func NewUsageCounter(from, to time.Time) *UsageCounter {
+	return &UsageCounter{from: from, to: to, counts: map[usageKey]*usage{}, users: map[string]UserInfo{}}
+}
+
+// IngestAuditLog counts the requests of an apiserver audit log, in the JSON lines format of the log
+// backend. Only the ResponseComplete stage of the resource requests is counted, and the requests denied
+// by the authorizer are skipped.
+func (in *UsageCounter) IngestAuditLog(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var event auditEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return fmt.Errorf("invalid audit event at line %d: %w", lineNumber, err)
+		}
+		in.record(event)
+	}
+	return scanner.Err()
+}
+
+func (in *UsageCounter) record(event auditEvent) {
+	if event.Stage != "ResponseComplete" || event.ObjectRef == nil || event.ObjectRef.Resource == "" ||
+		event.Annotations[auditDecisionAnnotation] == "forbid" ||
+		event.StageTimestamp.Before(in.from) || !event.StageTimestamp.Before(in.to) {
+		return
+	}
+	resource := event.ObjectRef.Resource
+	if event.ObjectRef.Subresource != "" {
+		resource += "/" + event.ObjectRef.Subresource
+	}
+	key := usageKey{User: event.User.Username, APIGroup: event.ObjectRef.APIGroup, Resource: resource, Verb: event.Verb}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	u, ok := in.counts[key]
+	if !ok {
+		u = &usage{}
+		in.counts[key] = u
+	}
+	u.count++
+	if event.StageTimestamp.After(u.lastUsed) {
+		u.lastUsed = event.StageTimestamp
+	}
+	if _, ok := in.users[event.User.Username]; !ok {
+		in.users[event.User.Username] = UserInfo{Name: event.User.Username, Groups: event.User.Groups}
+	}
+}
+
+// Heatmap combines the counts with the permission matrix of the snapshot. The matrix covers the users
+// seen in the audit logs, with the groups they had, and the given users, e.g. to also report the
+// permissions of the users who made no request. Cells are sorted by user, API group, resource and verb.
+func (in *UsageCounter) Heatmap(snapshot *RBACSnapshot, users []UserInfo) *UsageHeatmap {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	all := make(map[string]UserInfo, len(in.users)+len(users))
+	for name, user := range in.users {
+		all[name] = user
+	}
+	for _, user := range users {
+		all[user.Name] = user
+	}
+	matrixUsers := make([]UserInfo, 0, len(all))
+	for _, user := range all {
+		matrixUsers = append(matrixUsers, user)
+	}
+
+	granted := map[usageKey]bool{}
+	for user, permission := range snapshot.PermissionMatrix(matrixUsers) {
+		resource := permission.Resource
+		if permission.Subresource != "" {
+			resource += "/" + permission.Subresource
+		}
+		granted[usageKey{User: user.Name, APIGroup: permission.APIGroup, Resource: resource, Verb: permission.Verb}] = true
+	}
+
+	// The counts of each user, so a granted cell is only matched with the requests of its user
+	countsByUser := map[string][]usageKey{}
+	for key := range in.counts {
+		countsByUser[key.User] = append(countsByUser[key.User], key)
+	}
+
+	heatmap := &UsageHeatmap{From: in.from, To: in.to, Cells: make([]UsageCell, 0, len(granted))}
+	matched := map[usageKey]bool{}
+	for cellKey := range granted {
+		cell := UsageCell{User: cellKey.User, APIGroup: cellKey.APIGroup, Resource: cellKey.Resource, Verb: cellKey.Verb, Granted: true}
+		for _, key := range countsByUser[cellKey.User] {
+			u := in.counts[key]
+			if !usageMatches(cellKey, key) {
+				continue
+			}
+			matched[key] = true
+			cell.Count += u.count
+			if cell.LastUsed == nil || u.lastUsed.After(*cell.LastUsed) {
+				lastUsed := u.lastUsed
+				cell.LastUsed = &lastUsed
+			}
+		}
+		heatmap.Cells = append(heatmap.Cells, cell)
+	}
+	for key, u := range in.counts {
+		if !matched[key] {
+			lastUsed := u.lastUsed
+			heatmap.Cells = append(heatmap.Cells, UsageCell{User: key.User, APIGroup: key.APIGroup, Resource: key.Resource, Verb: key.Verb, Count: u.count, LastUsed: &lastUsed})
+		}
+	}
+
+	sort.Slice(heatmap.Cells, func(i, j int) bool {
+		a, b := heatmap.Cells[i], heatmap.Cells[j]
+		if a.User != b.User {
+			return a.User < b.User
+		}
+		if a.APIGroup != b.APIGroup {
+			return a.APIGroup < b.APIGroup
+		}
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		return a.Verb < b.Verb
+	})
+	return heatmap
+}
+
+// usageMatches returns true if the granted cell, possibly with wildcards, covers the used key.
+func usageMatches(granted, used usageKey) bool {
+	if granted.User != used.User || (granted.APIGroup != "*" && granted.APIGroup != used.APIGroup) ||
+		(granted.Verb != "*" && granted.Verb != used.Verb) {
+		return false
+	}
+	if granted.Resource == "*" || granted.Resource == used.Resource {
+		return true
+	}
+	// As in RBAC, only */subresource matches the subresources of several resources
+	_, sub, hasSub := strings.Cut(used.Resource, "/")
+	return hasSub && granted.Resource == "*/"+sub
+}
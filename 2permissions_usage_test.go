@@ -0,0 +1,101 @@
+package business
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var usageWindow = time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+// auditLine returns an audit event of the log backend, minutes after the start of the usage window.
+func auditLine(user, verb, apiGroup, resource, subresource, decision string, minutes int) string {
+	return fmt.Sprintf(`{"stage":"ResponseComplete","user":{"username":%q,"groups":["developers"]},"verb":%q,"objectRef":{"apiGroup":%q,"resource":%q,"subresource":%q},"stageTimestamp":%q,"annotations":{"authorization.k8s.io/decision":%q}}`,
+		user, verb, apiGroup, resource, subresource, usageWindow.Add(time.Duration(minutes)*time.Minute).Format(time.RFC3339), decision)
+}
+
+func TestUsageCounterIngestAuditLog(t *testing.T) {
+	counter := NewUsageCounter(usageWindow, usageWindow.Add(time.Hour))
+	log := strings.Join([]string{
+		auditLine("alice", "get", "", "pods", "", "allow", 1),
+		auditLine("alice", "get", "", "pods", "", "allow", 5),
+		"",
+		auditLine("alice", "list", "", "pods", "", "forbid", 2),
+		auditLine("alice", "get", "", "pods", "", "allow", -1),
+		auditLine("alice", "get", "", "pods", "", "allow", 60),
+		`{"stage":"RequestReceived","user":{"username":"alice"},"verb":"get","objectRef":{"resource":"pods"},"stageTimestamp":"2026-10-01T00:10:00Z"}`,
+		`{"stage":"ResponseComplete","user":{"username":"alice"},"verb":"get","stageTimestamp":"2026-10-01T00:10:00Z"}`,
+		auditLine("bob", "get", "", "secrets", "", "allow", 3),
+	}, "\n")
+
+	require.NoError(t, counter.IngestAuditLog(strings.NewReader(log)))
+	assert.Equal(t, map[usageKey]*usage{
+		{User: "alice", Resource: "pods", Verb: "get"}:  {count: 2, lastUsed: usageWindow.Add(5 * time.Minute)},
+		{User: "bob", Resource: "secrets", Verb: "get"}: {count: 1, lastUsed: usageWindow.Add(3 * time.Minute)},
+	}, counter.counts)
+
+	err := counter.IngestAuditLog(strings.NewReader(auditLine("alice", "get", "", "pods", "", "allow", 1) + "\n{"))
+	assert.ErrorContains(t, err, "invalid audit event at line 2")
+}
+
+func TestUsageCounterHeatmap(t *testing.T) {
+	counter := NewUsageCounter(usageWindow, usageWindow.Add(time.Hour))
+	log := strings.Join([]string{
+		auditLine("alice", "get", "", "pods", "", "allow", 1),
+		auditLine("alice", "get", "", "pods", "", "allow", 5),
+		auditLine("carol", "update", "apps", "deployments", "", "allow", 2),
+		auditLine("carol", "get", "", "pods", "log", "allow", 3),
+	}, "\n")
+	require.NoError(t, counter.IngestAuditLog(strings.NewReader(log)))
+
+	heatmap := counter.Heatmap(testSnapshot(podReaderObjects()...), []UserInfo{{Name: "bob"}})
+	assert.Equal(t, usageWindow, heatmap.From)
+	cells := []string{}
+	for _, cell := range heatmap.Cells {
+		cells = append(cells, fmt.Sprintf("%s %s/%s %s %d %t", cell.User, cell.APIGroup, cell.Resource, cell.Verb, cell.Count, cell.Granted))
+	}
+	// alice and carol are seen with the developers group in the audit log, bob made no request
+	assert.Equal(t, []string{
+		"alice /pods get 2 true",
+		"alice /pods list 0 true",
+		"alice /pods watch 0 true",
+		"alice apps/deployments get 0 true",
+		"alice apps/deployments list 0 true",
+		"alice apps/deployments patch 0 true",
+		"alice apps/deployments update 0 true",
+		"bob /pods get 0 true",
+		"bob /pods list 0 true",
+		"bob /pods watch 0 true",
+		"carol /pods/log get 1 false",
+		"carol apps/deployments get 0 true",
+		"carol apps/deployments list 0 true",
+		"carol apps/deployments patch 0 true",
+		"carol apps/deployments update 1 true",
+	}, cells)
+	assert.Equal(t, usageWindow.Add(5*time.Minute), *heatmap.Cells[0].LastUsed)
+	assert.Nil(t, heatmap.Cells[1].LastUsed)
+}
+
+func TestUsageMatches(t *testing.T) {
+	used := usageKey{User: "alice", Resource: "pods/log", Verb: "get"}
+	cases := []struct {
+		granted usageKey
+		matches bool
+	}{
+		{usageKey{User: "alice", Resource: "pods/log", Verb: "get"}, true},
+		{usageKey{User: "alice", APIGroup: "*", Resource: "*", Verb: "*"}, true},
+		{usageKey{User: "alice", Resource: "pods/*", Verb: "get"}, false},
+		{usageKey{User: "alice", Resource: "*/log", Verb: "get"}, true},
+		{usageKey{User: "alice", Resource: "pods", Verb: "get"}, false},
+		{usageKey{User: "alice", APIGroup: "apps", Resource: "*", Verb: "get"}, false},
+		{usageKey{User: "alice", Resource: "pods/log", Verb: "list"}, false},
+		{usageKey{User: "bob", Resource: "pods/log", Verb: "get"}, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.matches, usageMatches(c.granted, used), "%+v", c.granted)
+	}
+}
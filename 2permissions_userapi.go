@@ -0,0 +1,281 @@
+package business
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kiali/kiali/log"
+)
+
+// UserPermissions are the effective permissions of a user, sorted, with their PermissionsHash.
+type UserPermissions struct {
+	User   string   `json:"user"`
+	Groups []string `json:"groups,omitempty"`
+	Hash   string   `json:"hash"`
+	// ListMeta paginates the permissions, see ListOptions.
+	ListMeta
+	Permissions []AccessRequest `json:"permissions"`
+}
+
+// userPermissions serves the effective permissions of the user of the path, with its groups given as
+// repeated group query parameters, or the ones of the caller when the user is the caller. The ETag of the response is the PermissionsHash, so polling clients
+// sending If-None-Match get a 304 without the permissions while they are unchanged. The permissions are
+// paginated, see ListOptions.
+func (in *PermissionsServer) userPermissions(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	user := UserInfo{Name: mux.Vars(r)["user"], Groups: r.URL.Query()["group"]}
+	if caller, ok := UserFromContext(r.Context()); ok && caller.Name == user.Name && !r.URL.Query().Has("group") {
+		user = caller
+	}
+	opts, err := ParseListOptions(r)
+	if err != nil {
+		listError(w, err)
+		return
+	}
+	snapshot, err := in.snapshot(r)
+	if err != nil {
+		log.Errorf("%sError reading the permissions of user %s: %v", logPrefix(ctx), redactedUser(user.Name), err)
+		http.Error(w, "error reading RBAC objects", http.StatusInternalServerError)
+		return
+	}
+
+	effective := snapshot.EffectivePermissions(user)
+	hash := PermissionsHash(effective)
+	if notModified(w, r, hash) {
+		return
+	}
+	permissions := make([]AccessRequest, 0, len(effective))
+	for p := range effective {
+		permissions = append(permissions, p)
+	}
+	sortAccessRequests(permissions)
+	response := UserPermissions{User: user.Name, Groups: user.Groups, Hash: hash}
+	if response.Permissions, response.ListMeta, err = paginate(permissions, opts); err != nil {
+		listError(w, err)
+		return
+	}
+	writeList(w, r, response, "permissions", opts, NewTable(response.Permissions, AccessRequestColumns...))
+}
+
+// snapshot returns the snapshot of the watcher if there is one, or reads the RBAC objects.
+func (in *PermissionsServer) snapshot(r *http.Request) (*RBACSnapshot, error) {
+	if in.watcher != nil {
+		if snapshot := in.watcher.Snapshot(); snapshot != nil {
+			return snapshot, nil
+		}
+	}
+	return LoadRBACSnapshot(requestContext(r), in.client)
+}
+
+// WhoCanResponse lists the subjects allowed to make a request, see RBACSnapshot.WhoCan.
+type WhoCanResponse struct {
+	Request AccessRequest `json:"request"`
+	// Namespaces are the namespaces selected by the namespace selector of the query, if any: the
+	// subjects are allowed in at least one of them.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// ListMeta paginates the subjects, see ListOptions.
+	ListMeta
+	Subjects []rbac_v1.Subject `json:"subjects"`
+}
+
+// whoCan serves the subjects allowed to make the request of the verb, apiGroup, resource, subresource,
+// namespace and name query parameters. Instead of a namespace, the namespaceGlob and namespaceSelector
+// query parameters select several ones, see namespaceSelectorFromQuery. The subjects are paginated, see
+// ListOptions.
+func (in *PermissionsServer) whoCan(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	req, ok := accessRequestFromQuery(w, r)
+	if !ok {
+		return
+	}
+	selector, ok := namespaceSelectorFromQuery(w, r)
+	if !ok {
+		return
+	}
+	if req.Namespace != "" && !selector.IsEmpty() {
+		http.Error(w, "the namespace query parameter excludes namespaceGlob and namespaceSelector", http.StatusBadRequest)
+		return
+	}
+	opts, err := ParseListOptions(r)
+	if err != nil {
+		listError(w, err)
+		return
+	}
+	snapshot, err := in.snapshot(r)
+	if err != nil {
+		log.Errorf("%sError reading the subjects allowed to %s %s: %v", logPrefix(ctx), req.Verb, req.Resource, err)
+		http.Error(w, "error reading RBAC objects", http.StatusInternalServerError)
+		return
+	}
+
+	response := WhoCanResponse{Request: req}
+	var subjects []rbac_v1.Subject
+	if selector.IsEmpty() {
+		subjects = snapshot.WhoCan(req)
+	} else {
+		if response.Namespaces, ok = in.selectNamespaces(w, r, snapshot, selector); !ok {
+			return
+		}
+		subjects = snapshot.WhoCanInNamespaces(req, response.Namespaces)
+	}
+	if response.Subjects, response.ListMeta, err = paginate(subjects, opts); err != nil {
+		listError(w, err)
+		return
+	}
+	writeList(w, r, response, "subjects", opts, NewTable(response.Subjects, SubjectColumns...))
+}
+
+// accessRequestFromQuery reads the request of the verb, apiGroup, resource, subresource, namespace and
+// name query parameters, or writes a 400 response and returns false if the verb or the resource is missing.
+func accessRequestFromQuery(w http.ResponseWriter, r *http.Request) (AccessRequest, bool) {
+	query := r.URL.Query()
+	req := AccessRequest{
+		Namespace:   query.Get("namespace"),
+		APIGroup:    query.Get("apiGroup"),
+		Resource:    query.Get("resource"),
+		Subresource: query.Get("subresource"),
+		Name:        query.Get("name"),
+		Verb:        query.Get("verb"),
+	}
+	if req.Verb == "" || req.Resource == "" {
+		http.Error(w, "the verb and resource query parameters are required", http.StatusBadRequest)
+		return AccessRequest{}, false
+	}
+	return req, true
+}
+
+// namespaceSelectorFromQuery reads the namespace selector of the namespaceGlob and namespaceSelector query
+// parameters, e.g. namespaceGlob=team-*&namespaceSelector=env=prod, or writes a 400 response and returns
+// false if they are invalid.
+func namespaceSelectorFromQuery(w http.ResponseWriter, r *http.Request) (NamespaceSelector, bool) {
+	query := r.URL.Query()
+	selector := NamespaceSelector{Glob: query.Get("namespaceGlob"), LabelSelector: query.Get("namespaceSelector")}
+	if err := selector.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return NamespaceSelector{}, false
+	}
+	return selector, true
+}
+
+// selectNamespaces returns the namespaces selected by the selector, listed with the NamespaceLister of
+// the server. Without lister, globs are matched against the namespaces of the snapshot, and label
+// selectors are rejected. Errors are written to the response and false returned.
+func (in *PermissionsServer) selectNamespaces(w http.ResponseWriter, r *http.Request, snapshot *RBACSnapshot, selector NamespaceSelector) ([]string, bool) {
+	ctx := requestContext(r)
+	in.namespacesMu.RLock()
+	listNamespaces := in.listNamespaces
+	in.namespacesMu.RUnlock()
+
+	namespaces := []core_v1.Namespace{}
+	switch {
+	case listNamespaces != nil:
+		var err error
+		if namespaces, err = listNamespaces(ctx); err != nil {
+			log.Errorf("%sError listing the namespaces: %v", logPrefix(ctx), err)
+			http.Error(w, "error listing namespaces", http.StatusInternalServerError)
+			return nil, false
+		}
+	case selector.LabelSelector != "":
+		http.Error(w, "the namespaceSelector query parameter is not supported by this server", http.StatusBadRequest)
+		return nil, false
+	default:
+		for _, name := range snapshot.Namespaces() {
+			namespaces = append(namespaces, core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: name}})
+		}
+	}
+
+	selected, err := selector.Select(namespaces)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return selected, true
+}
+
+// MatrixEntry is a permission of a user in a PermissionMatrixResponse.
+type MatrixEntry struct {
+	User string `json:"user"`
+	AccessRequest
+}
+
+// PermissionMatrixResponse lists the permissions of several users, sorted by user.
+type PermissionMatrixResponse struct {
+	// Namespaces are the namespaces selected by the namespace selector of the query, if any: the entries
+	// are the permissions in these namespaces and the cluster-wide ones.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// ListMeta paginates the entries, see ListOptions.
+	ListMeta
+	Entries []MatrixEntry `json:"entries"`
+}
+
+// permissionMatrix serves the permissions of the users of the repeated user query parameters, without
+// their groups. The namespaceGlob and namespaceSelector query parameters restrict the entries to the
+// selected namespaces and the cluster-wide ones, see namespaceSelectorFromQuery. The entries are paginated,
+// see ListOptions.
+func (in *PermissionsServer) permissionMatrix(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	usernames := r.URL.Query()["user"]
+	if len(usernames) == 0 {
+		http.Error(w, "at least one user query parameter is required", http.StatusBadRequest)
+		return
+	}
+	selector, ok := namespaceSelectorFromQuery(w, r)
+	if !ok {
+		return
+	}
+	opts, err := ParseListOptions(r)
+	if err != nil {
+		listError(w, err)
+		return
+	}
+	snapshot, err := in.snapshot(r)
+	if err != nil {
+		log.Errorf("%sError reading the permission matrix: %v", logPrefix(ctx), err)
+		http.Error(w, "error reading RBAC objects", http.StatusInternalServerError)
+		return
+	}
+
+	response := PermissionMatrixResponse{}
+	selected := map[string]bool{}
+	if !selector.IsEmpty() {
+		if response.Namespaces, ok = in.selectNamespaces(w, r, snapshot, selector); !ok {
+			return
+		}
+		for _, namespace := range response.Namespaces {
+			selected[namespace] = true
+		}
+	}
+
+	sort.Strings(usernames)
+	entries := []MatrixEntry{}
+	for _, username := range usernames {
+		permissions := []AccessRequest{}
+		for p := range snapshot.EffectivePermissions(UserInfo{Name: username}) {
+			if selector.IsEmpty() || p.Namespace == "" || selected[p.Namespace] {
+				permissions = append(permissions, p)
+			}
+		}
+		sortAccessRequests(permissions)
+		for _, p := range permissions {
+			entries = append(entries, MatrixEntry{User: username, AccessRequest: p})
+		}
+	}
+
+	if response.Entries, response.ListMeta, err = paginate(entries, opts); err != nil {
+		listError(w, err)
+		return
+	}
+	writeList(w, r, response, "entries", opts, NewTable(response.Entries,
+		Column[MatrixEntry]{Header: "USER", Value: func(in MatrixEntry) string { return in.User }},
+		Column[MatrixEntry]{Header: "NAMESPACE", Value: func(in MatrixEntry) string { return in.Namespace }},
+		Column[MatrixEntry]{Header: "RESOURCE", Value: func(in MatrixEntry) string { return groupResource(in.APIGroup, in.Resource) }},
+		Column[MatrixEntry]{Header: "SUBRESOURCE", Wide: true, Value: func(in MatrixEntry) string { return in.Subresource }},
+		Column[MatrixEntry]{Header: "NAME", Wide: true, Value: func(in MatrixEntry) string { return in.Name }},
+		Column[MatrixEntry]{Header: "VERB", Value: func(in MatrixEntry) string { return in.Verb }},
+	))
+}
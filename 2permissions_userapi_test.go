@@ -0,0 +1,69 @@
+package business
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveIfNoneMatch serves the GET request of the caller with the If-None-Match header.
+func serveIfNoneMatch(server *PermissionsServer, target, caller, etag string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.Header.Set("X-Test-User", caller)
+	r.Header.Set("If-None-Match", etag)
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestUserPermissionsConditionalGet(t *testing.T) {
+	server := newTestServer(&testReviews{allow: allowUsers("admin")}, nil)
+
+	w := serve(server, "GET", "/api/permissions/alice", "admin")
+	require.Equal(t, http.StatusOK, w.Code)
+	var permissions UserPermissions
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &permissions))
+	assert.Equal(t, "alice", permissions.User)
+	assert.Equal(t, []AccessRequest{
+		{Namespace: "ns1", Resource: "pods", Verb: "get"},
+		{Namespace: "ns1", Resource: "pods", Verb: "list"},
+		{Namespace: "ns1", Resource: "pods", Verb: "watch"},
+	}, permissions.Permissions)
+	etag := w.Header().Get("ETag")
+	assert.Equal(t, `"`+permissions.Hash+`"`, etag)
+
+	// Unchanged permissions are not sent again
+	w = serveIfNoneMatch(server, "/api/permissions/alice", "admin", etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	// The permissions of other users, or with other groups, have other ETags
+	assert.Equal(t, http.StatusOK, serveIfNoneMatch(server, "/api/permissions/bob", "admin", etag).Code)
+	assert.Equal(t, http.StatusOK, serveIfNoneMatch(server, "/api/permissions/alice?group=developers", "admin", etag).Code)
+}
+
+func TestPermissionsHashEndpoint(t *testing.T) {
+	server := newTestServer(&testReviews{}, nil)
+
+	w := serve(server, "GET", "/api/permissions/hash", "alice", "developers")
+	require.Equal(t, http.StatusOK, w.Code)
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "alice", response["user"])
+	assert.Equal(t, testSnapshot(podReaderObjects()...).PermissionsHash(UserInfo{Name: "alice", Groups: []string{"developers"}}), response["hash"])
+	assert.Equal(t, `"`+response["hash"]+`"`, w.Header().Get("ETag"))
+
+	// The hash is the one of the caller, with its groups
+	r := httptest.NewRequest(http.MethodGet, "/api/permissions/hash", nil)
+	r.Header.Set("X-Test-User", "alice")
+	r.Header.Set("X-Test-Group", "developers")
+	r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, http.StatusOK, serveIfNoneMatch(server, "/api/permissions/hash", "alice", `"`+response["hash"]+`"`).Code)
+}
@@ -0,0 +1,176 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// The verbs of the Kubernetes API, and the special verbs of RBAC.
+const (
+	VerbGet              = "get"
+	VerbList             = "list"
+	VerbWatch            = "watch"
+	VerbCreate           = "create"
+	VerbUpdate           = "update"
+	VerbPatch            = "patch"
+	VerbDelete           = "delete"
+	VerbDeleteCollection = "deletecollection"
+	VerbImpersonate      = "impersonate"
+	VerbBind             = "bind"
+	VerbEscalate         = "escalate"
+	VerbApprove          = "approve"
+	VerbSign             = "sign"
+	VerbUse              = "use"
+	VerbAll              = "*"
+)
+
+// KnownVerbs are the verbs accepted by ValidateVerb.
+var KnownVerbs = []string{
+	VerbGet, VerbList, VerbWatch, VerbCreate, VerbUpdate, VerbPatch, VerbDelete, VerbDeleteCollection,
+	VerbImpersonate, VerbBind, VerbEscalate, VerbApprove, VerbSign, VerbUse, VerbAll,
+}
+
+// Well-known API groups. The core group is the empty one.
+const (
+	GroupCore          = ""
+	GroupApps          = "apps"
+	GroupBatch         = "batch"
+	GroupRBAC          = "rbac.authorization.k8s.io"
+	GroupNetworking    = "networking.k8s.io"
+	GroupAutoscaling   = "autoscaling"
+	GroupPolicy        = "policy"
+	GroupIstioNetwork  = "networking.istio.io"
+	GroupIstioSecurity = "security.istio.io"
+)
+
+// Well-known resources, in the plural lowercase form used by RBAC.
+const (
+	ResourcePods                     = "pods"
+	ResourceServices                 = "services"
+	ResourceConfigMaps               = "configmaps"
+	ResourceSecrets                  = "secrets"
+	ResourceNamespaces               = "namespaces"
+	ResourceNodes                    = "nodes"
+	ResourceServiceAccounts          = "serviceaccounts"
+	ResourceEndpoints                = "endpoints"
+	ResourceDeployments              = "deployments"
+	ResourceReplicaSets              = "replicasets"
+	ResourceStatefulSets             = "statefulsets"
+	ResourceDaemonSets               = "daemonsets"
+	ResourceJobs                     = "jobs"
+	ResourceCronJobs                 = "cronjobs"
+	ResourceRoles                    = "roles"
+	ResourceRoleBindings             = "rolebindings"
+	ResourceClusterRoles             = "clusterroles"
+	ResourceClusterRoleBindings      = "clusterrolebindings"
+	ResourceIngresses                = "ingresses"
+	ResourceNetworkPolicies          = "networkpolicies"
+	ResourceVirtualServices          = "virtualservices"
+	ResourceDestinationRules         = "destinationrules"
+	ResourceGateways                 = "gateways"
+	ResourceAuthorizationPolicies    = "authorizationpolicies"
+	ResourcePeerAuthentications      = "peerauthentications"
+	ResourceHorizontalPodAutoscalers = "horizontalpodautoscalers"
+	ResourcePodDisruptionBudgets     = "poddisruptionbudgets"
+)
+
+// wellKnownResourceGroups are the API groups of the well-known resources, so ValidateAccessRequest
+// catches e.g. deployments in the core group. The gateways are served by Istio and by the Gateway API.
+var wellKnownResourceGroups = map[string][]string{
+	ResourcePods:                     {GroupCore},
+	ResourceServices:                 {GroupCore},
+	ResourceConfigMaps:               {GroupCore},
+	ResourceSecrets:                  {GroupCore},
+	ResourceNamespaces:               {GroupCore},
+	ResourceNodes:                    {GroupCore},
+	ResourceServiceAccounts:          {GroupCore},
+	ResourceEndpoints:                {GroupCore},
+	ResourceDeployments:              {GroupApps},
+	ResourceReplicaSets:              {GroupApps},
+	ResourceStatefulSets:             {GroupApps},
+	ResourceDaemonSets:               {GroupApps},
+	ResourceJobs:                     {GroupBatch},
+	ResourceCronJobs:                 {GroupBatch},
+	ResourceRoles:                    {GroupRBAC},
+	ResourceRoleBindings:             {GroupRBAC},
+	ResourceClusterRoles:             {GroupRBAC},
+	ResourceClusterRoleBindings:      {GroupRBAC},
+	ResourceIngresses:                {GroupNetworking},
+	ResourceNetworkPolicies:          {GroupNetworking},
+	ResourceVirtualServices:          {GroupIstioNetwork},
+	ResourceDestinationRules:         {GroupIstioNetwork},
+	ResourceGateways:                 {GroupIstioNetwork, "gateway.networking.k8s.io"},
+	ResourceAuthorizationPolicies:    {GroupIstioSecurity},
+	ResourcePeerAuthentications:      {GroupIstioSecurity},
+	ResourceHorizontalPodAutoscalers: {GroupAutoscaling},
+	ResourcePodDisruptionBudgets:     {GroupPolicy},
+}
+
+// ValidateVerb returns an error if the verb is not one of the KnownVerbs, e.g. a typo like "gets".
+func ValidateVerb(verb string) error {
+	if !containsString(KnownVerbs, verb) {
+		return fmt.Errorf("unknown verb %q", verb)
+	}
+	return nil
+}
+
+// ValidateAccessRequest returns an error if the verb of the request is unknown, its resource is not in
+// the lowercase form used by RBAC (e.g. Pod instead of pods), or it is a well-known resource in the wrong
+// API group. Other resources, e.g. of CRDs, are not checked against the cluster.
+func ValidateAccessRequest(req AccessRequest) error {
+	if err := ValidateVerb(req.Verb); err != nil {
+		return err
+	}
+	if req.Resource == "" {
+		return fmt.Errorf("missing resource")
+	}
+	if req.Resource != strings.ToLower(req.Resource) {
+		return fmt.Errorf("invalid resource %q, expected the lowercase plural form", req.Resource)
+	}
+	if groups, ok := wellKnownResourceGroups[req.Resource]; ok && req.APIGroup != "*" && !containsString(groups, req.APIGroup) {
+		return fmt.Errorf("resource %s is not served by API group %q, expected %q", req.Resource, req.APIGroup, groups[0])
+	}
+	return nil
+}
+
+// can checks the request with the verb, for the per-verb helpers of the checker.
+func (in *PermissionChecker) can(ctx context.Context, user UserInfo, req AccessRequest, verb string) (bool, error) {
+	req.Verb = verb
+	decision, err := in.Check(ctx, user, req)
+	if err != nil {
+		return false, err
+	}
+	return decision.Allowed, nil
+}
+
+// CanGet checks the get verb on the resource of the request, whose Verb is ignored. Like Check, it
+// applies the enforcement mode of the checker.
+func (in *PermissionChecker) CanGet(ctx context.Context, user UserInfo, req AccessRequest) (bool, error) {
+	return in.can(ctx, user, req, VerbGet)
+}
+
+// CanList checks the list verb on the resource of the request, see CanGet.
+func (in *PermissionChecker) CanList(ctx context.Context, user UserInfo, req AccessRequest) (bool, error) {
+	return in.can(ctx, user, req, VerbList)
+}
+
+// CanWatch checks the watch verb on the resource of the request, see CanGet.
+func (in *PermissionChecker) CanWatch(ctx context.Context, user UserInfo, req AccessRequest) (bool, error) {
+	return in.can(ctx, user, req, VerbWatch)
+}
+
+// CanCreate checks the create verb on the resource of the request, see CanGet.
+func (in *PermissionChecker) CanCreate(ctx context.Context, user UserInfo, req AccessRequest) (bool, error) {
+	return in.can(ctx, user, req, VerbCreate)
+}
+
+// CanUpdate checks the update verb on the resource of the request, see CanGet.
+func (in *PermissionChecker) CanUpdate(ctx context.Context, user UserInfo, req AccessRequest) (bool, error) {
+	return in.can(ctx, user, req, VerbUpdate)
+}
+
+// CanDelete checks the delete verb on the resource of the request, see CanGet.
+func (in *PermissionChecker) CanDelete(ctx context.Context, user UserInfo, req AccessRequest) (bool, error) {
+	return in.can(ctx, user, req, VerbDelete)
+}
@@ -0,0 +1,56 @@
+package business
+
+import (
+	"context"
+	"testing"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAccessRequest(t *testing.T) {
+	assert.NoError(t, ValidateVerb(VerbDeleteCollection))
+	assert.Error(t, ValidateVerb("gets"))
+
+	for _, req := range []AccessRequest{
+		{Resource: ResourcePods, Verb: VerbGet},
+		{APIGroup: GroupApps, Resource: ResourceDeployments, Verb: VerbAll},
+		{APIGroup: "*", Resource: ResourceDeployments, Verb: VerbList},
+		{APIGroup: "gateway.networking.k8s.io", Resource: ResourceGateways, Verb: VerbGet},
+		{APIGroup: "example.com", Resource: "widgets", Verb: VerbGet},
+	} {
+		assert.NoError(t, ValidateAccessRequest(req), "%+v", req)
+	}
+	for _, req := range []AccessRequest{
+		{Resource: ResourcePods, Verb: "gets"},
+		{Verb: VerbGet},
+		{Resource: "Pod", Verb: VerbGet},
+		{Resource: ResourceDeployments, Verb: VerbGet},
+	} {
+		assert.Error(t, ValidateAccessRequest(req), "%+v", req)
+	}
+}
+
+func TestCheckerPerVerbHelpers(t *testing.T) {
+	checker := newTestChecker(&testReviews{allow: func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+		return attrs.Verb == VerbGet || attrs.Verb == VerbList
+	}}, nil)
+	alice := UserInfo{Name: "alice"}
+	// The verb of the request is ignored
+	pods := AccessRequest{Namespace: "ns1", Resource: ResourcePods, Verb: VerbDelete}
+
+	for verb, can := range map[string]func(context.Context, UserInfo, AccessRequest) (bool, error){
+		VerbGet:    checker.CanGet,
+		VerbList:   checker.CanList,
+		VerbWatch:  checker.CanWatch,
+		VerbCreate: checker.CanCreate,
+		VerbUpdate: checker.CanUpdate,
+		VerbDelete: checker.CanDelete,
+	} {
+		allowed, err := can(testCtx, alice, pods)
+		require.NoError(t, err, verb)
+		assert.Equal(t, verb == VerbGet || verb == VerbList, allowed, verb)
+	}
+}
@@ -0,0 +1,112 @@
+package business
+
+import (
+	"context"
+	"time"
+
+	"github.com/kiali/kiali/log"
+)
+
+// Discrepancy is a decision of the authorizers contradicted by the local RBAC evaluation.
+type Discrepancy struct {
+	Timestamp time.Time
+	RequestID string
+	User      UserInfo
+	Request   AccessRequest
+	// Live is the decision of the authorizers, whose Source names the authorizer that made it.
+	Live Decision
+	// LocalAllowed is the outcome of the local evaluation.
+	LocalAllowed bool
+	// LocalPaths are the RBAC rules allowing the request locally, when the authorizers denied it.
+	LocalPaths []PermissionPath
+	// ClosestRules are the local rules closest to the request, when the authorizers allowed it.
+	ClosestRules []NearMiss
+}
+
+// DiscrepancyReporter receives the discrepancies found by the verification.
+type DiscrepancyReporter interface {
+	Report(ctx context.Context, discrepancy Discrepancy)
+}
+
+// logDiscrepancyReporter is the default DiscrepancyReporter, writing to the Kiali log.
+type logDiscrepancyReporter struct{}
+
+func (logDiscrepancyReporter) Report(ctx context.Context, d Discrepancy) {
+	req := d.Request
+	log.Warningf("%sPermission discrepancy: user [%s] verb [%s] resource [%s/%s] subresource [%s] name [%s] namespace [%s]: %s allowed=%t (%s), local RBAC allowed=%t",
+		logPrefix(ctx), d.User.Name, req.Verb, req.APIGroup, req.Resource, req.Subresource, req.Name, req.Namespace, d.Live.Source, d.Live.Allowed, d.Live.Reason, d.LocalAllowed)
+}
+
+// maxConcurrentVerifications bounds the verifications running in the background; the samples beyond it
+// are dropped rather than queued, so the verification never delays nor piles up behind the checks.
+const maxConcurrentVerifications = 4
+
+type verification struct {
+	source   func() *RBACSnapshot
+	reporter DiscrepancyReporter
+	// slots holds a token per running verification.
+	slots chan struct{}
+}
+
+// SetVerificationSource enables the verification mode: a sample of the decisions made by the authorizers,
+// set by the VerificationSampleRate of the config, is also evaluated against the RBAC snapshot returned by
+// source (e.g. PermissionWatcher.Snapshot), and the discrepancies are reported, so the local evaluation can
+// be trusted as a fast path. Legitimate discrepancies exist when authorizers other than RBAC are in use.
+// The sampled decisions are verified in the background, at most maxConcurrentVerifications at a time, the
+// others are skipped. A nil reporter logs the discrepancies; a nil source disables the verification.
+func (in *PermissionChecker) SetVerificationSource(source func() *RBACSnapshot, reporter DiscrepancyReporter) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if source == nil {
+		in.verification = nil
+		return
+	}
+	if reporter == nil {
+		reporter = logDiscrepancyReporter{}
+	}
+	in.verification = &verification{source: source, reporter: reporter, slots: make(chan struct{}, maxConcurrentVerifications)}
+}
+
+// verifyInBackground verifies the decision in a goroutine, unless all the slots are in use. The
+// verification outlives the check, so it does not use the cancellation of its context.
+func (in *verification) verifyInBackground(ctx context.Context, user UserInfo, req AccessRequest, decision Decision) {
+	select {
+	case in.slots <- struct{}{}:
+	default:
+		log.Debugf("%sPermission verification skipped, %d verifications are running", logPrefix(ctx), maxConcurrentVerifications)
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() { <-in.slots }()
+		in.verify(ctx, user, req, decision)
+	}()
+}
+
+// verify evaluates the request locally and reports a discrepancy with the decision, if any.
+func (in *verification) verify(ctx context.Context, user UserInfo, req AccessRequest, decision Decision) {
+	snapshot := in.source()
+	if snapshot == nil {
+		return
+	}
+	explanation := snapshot.Explain(user, req)
+	if explanation.Allowed == decision.Allowed {
+		return
+	}
+
+	discrepancy := Discrepancy{
+		Timestamp:    time.Now(),
+		RequestID:    RequestIDFromContext(ctx),
+		User:         user,
+		Request:      req,
+		Live:         decision,
+		LocalAllowed: explanation.Allowed,
+		LocalPaths:   explanation.Paths,
+	}
+	if !explanation.Allowed {
+		if denial, err := snapshot.ExplainDenial(user, req); err == nil {
+			discrepancy.ClosestRules = denial.ClosestRules
+		}
+	}
+	in.reporter.Report(ctx, currentRedaction().Discrepancy(discrepancy))
+}
@@ -0,0 +1,135 @@
+package business
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testDiscrepancyReporter struct {
+	mu            sync.Mutex
+	discrepancies []Discrepancy
+}
+
+func (in *testDiscrepancyReporter) Report(ctx context.Context, d Discrepancy) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.discrepancies = append(in.discrepancies, d)
+}
+
+func TestVerificationReportsAllowedByLiveOnlyWithClosestRules(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+	reporter := &testDiscrepancyReporter{}
+	v := &verification{source: func() *RBACSnapshot { return snapshot }, reporter: reporter}
+
+	// alice reads the pods of ns1 only, the webhook allowing ns2 contradicts RBAC
+	req := AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "get"}
+	v.verify(WithRequestID(testCtx, "r1"), UserInfo{Name: "alice"}, req, Decision{Allowed: true, Source: "webhook"})
+
+	require.Len(t, reporter.discrepancies, 1)
+	d := reporter.discrepancies[0]
+	assert.Equal(t, "r1", d.RequestID)
+	assert.False(t, d.LocalAllowed)
+	assert.True(t, d.Live.Allowed)
+	require.NotEmpty(t, d.ClosestRules)
+	assert.Equal(t, "alice-pods", d.ClosestRules[0].BindingName)
+	assert.Equal(t, []string{"namespace"}, d.ClosestRules[0].Mismatches)
+}
+
+func TestVerificationReportsDeniedByLiveWithLocalPaths(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+	reporter := &testDiscrepancyReporter{}
+	v := &verification{source: func() *RBACSnapshot { return snapshot }, reporter: reporter}
+
+	req := AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "list"}
+	v.verify(testCtx, UserInfo{Name: "alice"}, req, Decision{Allowed: false, Source: DecisionSourceAPIServer})
+
+	require.Len(t, reporter.discrepancies, 1)
+	assert.True(t, reporter.discrepancies[0].LocalAllowed)
+	assert.NotEmpty(t, reporter.discrepancies[0].LocalPaths)
+	assert.Empty(t, reporter.discrepancies[0].ClosestRules)
+}
+
+func TestVerificationIgnoresAgreements(t *testing.T) {
+	snapshot := testSnapshot(podReaderObjects()...)
+	reporter := &testDiscrepancyReporter{}
+	v := &verification{source: func() *RBACSnapshot { return snapshot }, reporter: reporter}
+
+	v.verify(testCtx, UserInfo{Name: "alice"}, AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}, Decision{Allowed: true})
+	v.verify(testCtx, UserInfo{Name: "alice"}, AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"}, Decision{Allowed: false})
+
+	assert.Empty(t, reporter.discrepancies)
+}
+
+func TestVerificationWithoutSnapshot(t *testing.T) {
+	reporter := &testDiscrepancyReporter{}
+	v := &verification{source: func() *RBACSnapshot { return nil }, reporter: reporter}
+
+	v.verify(testCtx, UserInfo{Name: "alice"}, AccessRequest{Resource: "pods", Verb: "get"}, Decision{Allowed: true})
+
+	assert.Empty(t, reporter.discrepancies)
+}
+
+func TestCheckerVerifiesTheSampledDecisions(t *testing.T) {
+	conf := NewPermissionsConfig()
+	conf.FeatureGates = FeatureGates{FeatureLocalEvaluator: true}
+	conf.VerificationSampleRate = 1
+	// The apiserver allows alice to read the pods of every namespace, RBAC only those of ns1
+	checker := newTestChecker(&testReviews{allow: func(user UserInfo, attrs *auth_v1.ResourceAttributes) bool {
+		return user.Name == "alice" && attrs.Resource == "pods"
+	}}, conf)
+	snapshot := testSnapshot(podReaderObjects()...)
+	reporter := &testDiscrepancyReporter{}
+	checker.SetVerificationSource(func() *RBACSnapshot { return snapshot }, reporter)
+
+	ctx, cancel := context.WithCancel(WithRequestID(testCtx, "r1"))
+	for _, namespace := range []string{"ns1", "ns2"} {
+		decision, err := checker.Check(ctx, UserInfo{Name: "alice"}, AccessRequest{Namespace: namespace, Resource: "pods", Verb: "get"})
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+	}
+	// The verification outlives the request
+	cancel()
+
+	assert.Eventually(t, func() bool {
+		reporter.mu.Lock()
+		defer reporter.mu.Unlock()
+		return len(reporter.discrepancies) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "ns2", reporter.discrepancies[0].Request.Namespace)
+	assert.Equal(t, "r1", reporter.discrepancies[0].RequestID)
+}
+
+func TestVerificationSkipsTheSamplesBeyondTheBudget(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, maxConcurrentVerifications+1)
+	snapshot := testSnapshot(podReaderObjects()...)
+	reporter := &testDiscrepancyReporter{}
+	v := &verification{source: func() *RBACSnapshot {
+		started <- struct{}{}
+		<-release
+		return snapshot
+	}, reporter: reporter, slots: make(chan struct{}, maxConcurrentVerifications)}
+
+	req := AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "get"}
+	for i := 0; i < maxConcurrentVerifications+1; i++ {
+		v.verifyInBackground(testCtx, UserInfo{Name: "alice"}, req, Decision{Allowed: true})
+	}
+	for i := 0; i < maxConcurrentVerifications; i++ {
+		<-started
+	}
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		reporter.mu.Lock()
+		defer reporter.mu.Unlock()
+		return len(reporter.discrepancies) == maxConcurrentVerifications
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, started)
+}
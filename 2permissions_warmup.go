@@ -0,0 +1,99 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kiali/kiali/log"
+)
+
+// DefaultWarmUpParallelism is the number of concurrent checks of a warm-up when the config does not set it.
+const DefaultWarmUpParallelism = 10
+
+// WarmUp pre-resolves the configured WarmUpRequests of every user into the decision cache, e.g. for the
+// recently active users of the session store, so the first requests after a deploy are not slow.
+// No denial is audited. Failed checks are logged and counted in the returned error, but do not stop the
+// warm-up. The checks count against the MaxConcurrentAPIRequests of the checker, see APIRequestLimiter,
+// with PriorityBackground unless the context has a priority. The requests whose cache TTL is zero, see
+// UserCacheTTLs, are skipped.
+//
+// WarmUpRequests is empty by default, so WarmUp does nothing until they are configured. It also does
+// nothing when caching or enforcement is disabled.
+func (in *PermissionChecker) WarmUp(ctx context.Context, users []UserInfo) error {
+	ctx = withDefaultPriority(ctx, PriorityBackground)
+	in.mu.RLock()
+	conf, cache, chain := in.conf, in.cache, in.chain
+	in.mu.RUnlock()
+
+	if conf.Mode == EnforcementModeDisabled {
+		return nil
+	}
+	if len(conf.WarmUpRequests) == 0 {
+		log.Debugf("Permissions warm-up of %d users skipped, no warm_up_requests configured", len(users))
+		return nil
+	}
+	parallelism := conf.WarmUpParallelism
+	if parallelism <= 0 {
+		parallelism = DefaultWarmUpParallelism
+	}
+
+	type warmUpCheck struct {
+		user UserInfo
+		req  AccessRequest
+	}
+	checks := make(chan warmUpCheck)
+	mu := sync.Mutex{}
+	// checked counts the checks made, without the ones skipped for their TTL
+	checked, failed := 0, 0
+	var firstErr error
+	start := time.Now()
+
+	wg := sync.WaitGroup{}
+	wg.Add(parallelism)
+	for i := 0; i < parallelism; i++ {
+		go func() {
+			defer wg.Done()
+			for check := range checks {
+				ttl := conf.cacheTTLFor(check.user, check.req)
+				if ttl <= 0 {
+					continue
+				}
+				user := withoutGroups(check.user, conf.ExcludedGroups)
+				_, err := in.cachedAuthorize(ctx, cache, ttl, chain, user, check.req)
+				mu.Lock()
+				checked++
+				if err != nil {
+					failed++
+					if firstErr == nil {
+						firstErr = err
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for _, user := range users {
+		for _, req := range conf.WarmUpRequests {
+			select {
+			case checks <- warmUpCheck{user: user, req: req}:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+	}
+	close(checks)
+	wg.Wait()
+
+	log.Infof("Permissions warm-up of %d users done in %s: %d checks, %d failed", len(users), time.Since(start), checked, failed)
+	if ctx.Err() != nil {
+		return fmt.Errorf("permissions warm-up interrupted: %w", ctx.Err())
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d permissions warm-up checks failed: %w", failed, checked, firstErr)
+	}
+	return nil
+}
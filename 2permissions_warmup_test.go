@@ -0,0 +1,79 @@
+package business
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func warmUpConfig() *PermissionsConfig {
+	conf := NewPermissionsConfig()
+	conf.WarmUpRequests = AccessRequests{alicePods, aliceSecrets}
+	conf.WarmUpParallelism = 2
+	return conf
+}
+
+func TestWarmUpFillsTheCache(t *testing.T) {
+	reviews := aliceReadsPods()
+	checker := newTestChecker(reviews, warmUpConfig())
+	sink := &testAuditSink{}
+	checker.SetAuditSink(sink)
+	users := []UserInfo{{Name: "alice"}, {Name: "bob"}, {Name: "carol"}}
+
+	require.NoError(t, checker.WarmUp(testCtx, users))
+	assert.Equal(t, int64(6), reviews.calls.Load())
+	// The denials of the warm-up are not audited
+	assert.Empty(t, sink.records)
+
+	for _, user := range users {
+		for _, req := range []AccessRequest{alicePods, aliceSecrets} {
+			decision, err := checker.Check(testCtx, user, req)
+			require.NoError(t, err)
+			assert.Equal(t, DecisionSourceCache, decision.Source)
+		}
+	}
+	assert.Equal(t, int64(6), reviews.calls.Load())
+}
+
+func TestWarmUpDoesNothingWithoutRequestsOrEnforcement(t *testing.T) {
+	reviews := aliceReadsPods()
+	checker := newTestChecker(reviews, nil)
+	require.NoError(t, checker.WarmUp(testCtx, []UserInfo{{Name: "alice"}}))
+
+	conf := warmUpConfig()
+	conf.Mode = EnforcementModeDisabled
+	require.NoError(t, checker.ApplyConfig(conf))
+	require.NoError(t, checker.WarmUp(testCtx, []UserInfo{{Name: "alice"}}))
+	assert.Zero(t, reviews.calls.Load())
+}
+
+func TestWarmUpReportsTheFailedChecks(t *testing.T) {
+	checker := newTestChecker(&testReviews{err: errTestAPIServer}, warmUpConfig())
+
+	err := checker.WarmUp(testCtx, []UserInfo{{Name: "alice"}, {Name: "bob"}})
+	assert.ErrorIs(t, err, errTestAPIServer)
+	assert.ErrorContains(t, err, "4 of 4 permissions warm-up checks failed")
+}
+
+func TestWarmUpCountsOnlyTheChecksMade(t *testing.T) {
+	conf := warmUpConfig()
+	// Nothing of ns1 is cached for alice, the check of the secrets of alice is skipped
+	conf.UserCacheTTLs = []UserCacheTTL{{Users: []string{"alice", "bob"}, Namespaces: []string{"ns1"}, CacheTTL: 0}}
+	conf.WarmUpRequests = AccessRequests{aliceSecrets, {Namespace: "ns2", Resource: "pods", Verb: "get"}}
+	checker := newTestChecker(&testReviews{err: errTestAPIServer}, conf)
+
+	err := checker.WarmUp(testCtx, []UserInfo{{Name: "alice"}, {Name: "carol"}})
+	assert.ErrorContains(t, err, "3 of 3 permissions warm-up checks failed")
+}
+
+func TestWarmUpInterrupted(t *testing.T) {
+	checker := newTestChecker(aliceReadsPods(), warmUpConfig())
+	ctx, cancel := context.WithCancel(testCtx)
+	cancel()
+
+	err := checker.WarmUp(ctx, []UserInfo{{Name: "alice"}})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "permissions warm-up interrupted")
+}
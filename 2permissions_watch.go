@@ -0,0 +1,586 @@
+package business
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	kube_cache "k8s.io/client-go/tools/cache"
+
+	"github.com/kiali/kiali/log"
+)
+
+// permissionChangeBufferSize is the number of changes buffered for each subscriber. The subscribers not
+// keeping up are unsubscribed, see Watch.
+const permissionChangeBufferSize = 16
+
+// PermissionChange is sent to the subscribers of a user when an RBAC change modifies
+// the effective permissions of the user.
+type PermissionChange struct {
+	User      string
+	Gained    []AccessRequest
+	Lost      []AccessRequest
+	Timestamp time.Time
+	// Causes are the RBAC objects whose change triggered the recomputation.
+	Causes []RBACObjectRef
+	// Hash is the PermissionsHash of the permissions of the user after the change.
+	Hash string
+}
+
+// RBACObjectRef identifies a Role, ClusterRole or binding, or a Namespace whose parent changed, see FeatureHNC.
+type RBACObjectRef struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// AllNamespacesSlice is the Namespace of the cache slices covering every namespace, including the
+// cluster-scoped decisions.
+const AllNamespacesSlice = "*"
+
+// CacheSlice is a part of the decision cache affected by an RBAC change: the decisions of a user in a
+// namespace. An empty User means the decisions of all the users in the namespace.
+type CacheSlice struct {
+	Namespace string `json:"namespace"`
+	User      string `json:"user,omitempty"`
+}
+
+// PermissionWatcher watches the RBAC objects of a cluster through informers and notifies
+// the subscribers when the effective permissions of their user change, so UIs can live-update
+// the actions they render. It is safe for concurrent use, including changes of its options.
+type PermissionWatcher struct {
+	factory informers.SharedInformerFactory
+	changed chan struct{}
+	// hierarchy is set when the namespaces are watched for their hierarchy, see FeatureHNC. It is set by
+	// Start, before the changes are processed.
+	hierarchy bool
+
+	// optionsMu guards the options, read once per recomputation
+	optionsMu sync.RWMutex
+	history   *PermissionHistoryRecorder
+	scope     ResolutionScope
+	// scopeChanged forces the next recomputation to be complete, see processChanges
+	scopeChanged bool
+	// onChange is called after every recomputation caused by RBAC changes
+	onChange func(causes []RBACObjectRef, slices []CacheSlice)
+
+	causesMu sync.Mutex
+	causes   map[RBACObjectRef]bool
+	// slices are the cache slices affected by the changes, unless a cluster-wide object changed
+	slices      map[CacheSlice]bool
+	clusterWide bool
+
+	// snapshot is replaced, never modified, on every change, so readers keep evaluating the snapshot
+	// they loaded without locking while a new one is swapped in
+	snapshot atomic.Pointer[RBACSnapshot]
+
+	mu            sync.Mutex
+	subscriptions map[int]*permissionSubscription
+	nextID        int
+}
+
+type permissionSubscription struct {
+	user        UserInfo
+	ch          chan PermissionChange
+	permissions map[AccessRequest]bool
+	// closed is set, under the lock of the watcher, once ch is closed
+	closed bool
+}
+
+// NewPermissionWatcher creates a watcher using the RBAC informers of the factory.
+// Start must be called before changes are delivered.
+func NewPermissionWatcher(factory informers.SharedInformerFactory) *PermissionWatcher {
+	return &PermissionWatcher{
+		factory:       factory,
+		changed:       make(chan struct{}, 1),
+		causes:        map[RBACObjectRef]bool{},
+		slices:        map[CacheSlice]bool{},
+		subscriptions: map[int]*permissionSubscription{},
+	}
+}
+
+// SetHistoryRecorder makes the watcher record every change detected for its subscribed users.
+// It is usually called before Start; otherwise it applies from the next recomputation.
+func (in *PermissionWatcher) SetHistoryRecorder(recorder *PermissionHistoryRecorder) {
+	in.optionsMu.Lock()
+	defer in.optionsMu.Unlock()
+	in.history = recorder
+}
+
+// SetResolutionScope restricts the snapshots of the watcher, and so the permissions of the subscribers,
+// to the API groups and resources in scope. It is usually called before Start; otherwise it applies
+// from the next recomputation, i.e. the next RBAC change.
+func (in *PermissionWatcher) SetResolutionScope(scope ResolutionScope) {
+	in.optionsMu.Lock()
+	defer in.optionsMu.Unlock()
+	in.scope = scope
+	in.scopeChanged = true
+}
+
+// SetChangeHandler registers a function called with the changed RBAC objects after every recomputation,
+// whether or not the permissions of a subscribed user changed. It is usually called before Start;
+// otherwise it applies from the next recomputation.
+// When only Roles and RoleBindings changed, slices are the cache slices affected by the changes: the
+// subjects of the RoleBindings in their namespace, or the whole namespace for Roles and groups. Otherwise
+// slices is nil, meaning that any decision may be affected. With the FeatureHNC feature gate, the slices
+// include the descendants of the namespaces, and the whole namespaces whose parent changed.
+func (in *PermissionWatcher) SetChangeHandler(handler func(causes []RBACObjectRef, slices []CacheSlice)) {
+	in.optionsMu.Lock()
+	defer in.optionsMu.Unlock()
+	in.onChange = handler
+}
+
+// Start registers the informer handlers, starts the informers and waits for their sync. With the
+// FeatureHNC feature gate, the namespaces are also watched, and the Roles and RoleBindings propagated
+// down their hierarchy, see RBACSnapshot.WithHierarchy.
+// Changes are processed until stopCh is closed.
+func (in *PermissionWatcher) Start(stopCh <-chan struct{}) error {
+	rbac := in.factory.Rbac().V1()
+	for kind, informer := range map[string]kube_cache.SharedIndexInformer{
+		"ClusterRole":        rbac.ClusterRoles().Informer(),
+		"ClusterRoleBinding": rbac.ClusterRoleBindings().Informer(),
+		"Role":               rbac.Roles().Informer(),
+		"RoleBinding":        rbac.RoleBindings().Informer(),
+	} {
+		kind := kind
+		handler := kube_cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { in.notifyChanged(kind, obj) },
+			UpdateFunc: func(oldObj, newObj interface{}) { in.notifyChanged(kind, oldObj, newObj) },
+			DeleteFunc: func(obj interface{}) { in.notifyChanged(kind, obj) },
+		}
+		if _, err := informer.AddEventHandler(handler); err != nil {
+			return fmt.Errorf("error registering RBAC event handler: %w", err)
+		}
+	}
+	if FeatureEnabled(FeatureHNC) {
+		in.hierarchy = true
+		if _, err := in.factory.Core().V1().Namespaces().Informer().AddEventHandler(in.hierarchyHandler()); err != nil {
+			return fmt.Errorf("error registering namespace event handler: %w", err)
+		}
+	}
+
+	in.factory.Start(stopCh)
+	for informerType, synced := range in.factory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return fmt.Errorf("RBAC informer for %v failed to sync", informerType)
+		}
+	}
+
+	in.optionsMu.RLock()
+	scope := in.scope
+	in.optionsMu.RUnlock()
+	snapshot, _, err := in.snapshotFromListers(scope)
+	if err != nil {
+		return err
+	}
+	// The initial adds are not changes
+	in.takeCauses()
+	in.snapshot.Store(snapshot)
+	in.mu.Lock()
+	for _, sub := range in.subscriptions {
+		sub.permissions = snapshot.EffectivePermissions(sub.user)
+	}
+	in.mu.Unlock()
+
+	go in.run(stopCh)
+	return nil
+}
+
+// HasSynced returns true once the informers are synced and changes are being processed.
+func (in *PermissionWatcher) HasSynced() bool {
+	return in.snapshot.Load() != nil
+}
+
+// Snapshot returns the RBAC snapshot of the informer caches, or nil before they are synced. It does not
+// lock, so it can be called on the check hot path. The snapshot is immutable: changes are applied to a new
+// snapshot, swapped in atomically, and the returned one stays consistent while it is being evaluated.
+// It must not be modified.
+func (in *PermissionWatcher) Snapshot() *RBACSnapshot {
+	return in.snapshot.Load()
+}
+
+// Watch subscribes to the permission changes of the user. The returned function cancels the
+// subscription and closes the channel. A subscriber whose buffer is full when a change is sent is
+// unsubscribed and its channel closed, rather than missing the change, e.g. a revocation: it must
+// subscribe again and read its current permissions.
+func (in *PermissionWatcher) Watch(user UserInfo) (<-chan PermissionChange, func()) {
+	in.optionsMu.RLock()
+	history := in.history
+	in.optionsMu.RUnlock()
+
+	in.mu.Lock()
+	sub := &permissionSubscription{user: user, ch: make(chan PermissionChange, permissionChangeBufferSize)}
+	// Stamped under the lock, so the baseline precedes the changes of the user even if it is stored after them
+	baselineTime := time.Now()
+	if snapshot := in.snapshot.Load(); snapshot != nil {
+		sub.permissions = snapshot.EffectivePermissions(user)
+	}
+	id := in.nextID
+	in.nextID++
+	in.subscriptions[id] = sub
+	in.mu.Unlock()
+
+	if history != nil && sub.permissions != nil {
+		// The changes of the user apply from these permissions, see SQLiteHistoryStore.AccessAsOf
+		history.RecordBaseline(user.Name, sub.permissions, baselineTime)
+	}
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			in.mu.Lock()
+			defer in.mu.Unlock()
+			in.unsubscribe(id, sub)
+		})
+	}
+}
+
+// hierarchyHandler notifies the namespaces whose parent changed: the Roles and RoleBindings they inherit
+// change with it. The other namespace changes do not change the permissions.
+func (in *PermissionWatcher) hierarchyHandler() kube_cache.ResourceEventHandler {
+	return kube_cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if namespace, ok := obj.(*core_v1.Namespace); ok && hncParent(namespace.Labels) != "" {
+				in.notifyChanged("Namespace", obj)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			previous, okPrevious := oldObj.(*core_v1.Namespace)
+			current, okCurrent := newObj.(*core_v1.Namespace)
+			if okPrevious && okCurrent && hncParent(previous.Labels) != hncParent(current.Labels) {
+				in.notifyChanged("Namespace", oldObj, newObj)
+			}
+		},
+		DeleteFunc: func(obj interface{}) { in.notifyChanged("Namespace", obj) },
+	}
+}
+
+// notifyChanged records the changed object, in its old and new versions for updates, and signals the
+// processing loop. Bursts of events are coalesced into a single recomputation.
+func (in *PermissionWatcher) notifyChanged(kind string, objs ...interface{}) {
+	in.causesMu.Lock()
+	for _, obj := range objs {
+		if tombstone, ok := obj.(kube_cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			continue
+		}
+		in.causes[RBACObjectRef{Kind: kind, Namespace: accessor.GetNamespace(), Name: accessor.GetName()}] = true
+
+		switch o := obj.(type) {
+		case *rbac_v1.RoleBinding:
+			for _, subject := range o.Subjects {
+				in.slices[CacheSlice{Namespace: o.Namespace, User: subjectUsername(subject, o.Namespace)}] = true
+			}
+		case *rbac_v1.Role:
+			in.slices[CacheSlice{Namespace: o.Namespace}] = true
+		case *core_v1.Namespace:
+			in.slices[CacheSlice{Namespace: o.Name}] = true
+		default:
+			in.clusterWide = true
+		}
+	}
+	in.causesMu.Unlock()
+
+	select {
+	case in.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (in *PermissionWatcher) run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-in.changed:
+			in.processChanges()
+		}
+	}
+}
+
+// processChanges recomputes the effective permissions of every subscribed user and sends the differences.
+// When only Roles and RoleBindings changed, only the permissions of the affected users in the affected
+// namespaces are recomputed, see updatedPermissions, so large matrices stay cheap to maintain.
+func (in *PermissionWatcher) processChanges() {
+	in.optionsMu.Lock()
+	history, scope, onChange, scopeChanged := in.history, in.scope, in.onChange, in.scopeChanged
+	in.scopeChanged = false
+	in.optionsMu.Unlock()
+
+	causes, slices := in.takeCauses()
+	snapshot, hierarchy, err := in.snapshotFromListers(scope)
+	if err != nil {
+		log.Errorf("Error reading RBAC objects from informers: %v", err)
+		return
+	}
+	slices = withDescendants(slices, hierarchy)
+
+	if onChange != nil && len(causes) > 0 {
+		onChange(causes, slices)
+	}
+
+	in.snapshot.Store(snapshot)
+
+	// The changes are recorded once the subscriptions are unlocked, the store can be slow
+	var changes []PermissionChange
+	defer func() {
+		for _, change := range changes {
+			history.Record(change)
+		}
+	}()
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	now := time.Now()
+	for id, sub := range in.subscriptions {
+		var current map[AccessRequest]bool
+		if scopeChanged || slices == nil || sub.permissions == nil {
+			current = snapshot.EffectivePermissions(sub.user)
+		} else if current = updatedPermissions(snapshot, sub.user, sub.permissions, slices); current == nil {
+			continue
+		}
+		gained, lost := diffPermissions(sub.permissions, current)
+		sub.permissions = current
+		if len(gained) == 0 && len(lost) == 0 {
+			continue
+		}
+
+		change := PermissionChange{User: sub.user.Name, Gained: gained, Lost: lost, Timestamp: now, Causes: causes, Hash: PermissionsHash(current)}
+		if history != nil {
+			changes = append(changes, change)
+		}
+		select {
+		case sub.ch <- change:
+		default:
+			log.Warningf("Closing the permission changes of user %s: subscriber is not keeping up", redactedUser(sub.user.Name))
+			in.unsubscribe(id, sub)
+		}
+	}
+}
+
+// unsubscribe removes the subscription and closes its channel, once. The caller holds the lock.
+func (in *PermissionWatcher) unsubscribe(id int, sub *permissionSubscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(in.subscriptions, id)
+	close(sub.ch)
+}
+
+// updatedPermissions applies the changes of the cache slices to the previous permissions of the user: the
+// permissions granted in the namespaces of the slices of the user are recomputed from their RoleBindings,
+// the others are kept. It returns nil if no slice affects the user. The previous permissions are not
+// modified, since they may be compared with the result.
+func updatedPermissions(snapshot *RBACSnapshot, user UserInfo, previous map[AccessRequest]bool, slices []CacheSlice) map[AccessRequest]bool {
+	namespaces := map[string]bool{}
+	for _, slice := range slices {
+		if slice.User == "" || slice.User == user.Name {
+			namespaces[slice.Namespace] = true
+		}
+	}
+	if len(namespaces) == 0 {
+		return nil
+	}
+
+	current := make(map[AccessRequest]bool, len(previous))
+	for permission := range previous {
+		if !namespaces[permission.Namespace] {
+			current[permission] = true
+		}
+	}
+	for permission := range snapshot.namespacePermissions(user, namespaces) {
+		current[permission] = true
+	}
+	return current
+}
+
+// takeCauses returns the objects changed since the previous call, sorted, and the cache slices they
+// affect, or nil slices if a cluster-wide object changed.
+func (in *PermissionWatcher) takeCauses() ([]RBACObjectRef, []CacheSlice) {
+	in.causesMu.Lock()
+	defer in.causesMu.Unlock()
+
+	causes := make([]RBACObjectRef, 0, len(in.causes))
+	for ref := range in.causes {
+		causes = append(causes, ref)
+	}
+	var slices []CacheSlice
+	if !in.clusterWide {
+		slices = sortedSlices(in.slices)
+	}
+	in.causes = map[RBACObjectRef]bool{}
+	in.slices = map[CacheSlice]bool{}
+	in.clusterWide = false
+	sort.Slice(causes, func(i, j int) bool {
+		a, b := causes[i], causes[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return causes, slices
+}
+
+// sortedSlices returns the slices of the set, sorted, without the user slices included in the slice of
+// their whole namespace.
+func sortedSlices(set map[CacheSlice]bool) []CacheSlice {
+	wholeNamespaces := map[string]bool{}
+	for slice := range set {
+		if slice.User == "" {
+			wholeNamespaces[slice.Namespace] = true
+		}
+	}
+	slices := make([]CacheSlice, 0, len(set))
+	for slice := range set {
+		if slice.User == "" || !wholeNamespaces[slice.Namespace] {
+			slices = append(slices, slice)
+		}
+	}
+	sort.Slice(slices, func(i, j int) bool {
+		if slices[i].Namespace != slices[j].Namespace {
+			return slices[i].Namespace < slices[j].Namespace
+		}
+		return slices[i].User < slices[j].User
+	})
+	return slices
+}
+
+// withDescendants adds the slices of the descendants of the namespaces of the slices, which inherit their
+// Roles and RoleBindings. Nil slices, affecting any decision, and a nil hierarchy leave the slices unchanged.
+func withDescendants(slices []CacheSlice, hierarchy *NamespaceHierarchy) []CacheSlice {
+	if slices == nil || hierarchy == nil {
+		return slices
+	}
+	set := make(map[CacheSlice]bool, len(slices))
+	for _, slice := range slices {
+		set[slice] = true
+		for _, descendant := range hierarchy.Descendants(slice.Namespace) {
+			set[CacheSlice{Namespace: descendant, User: slice.User}] = true
+		}
+	}
+	return sortedSlices(set)
+}
+
+// subjectUsername returns the username of a binding subject, or an empty string for groups, whose
+// members are unknown.
+func subjectUsername(subject rbac_v1.Subject, bindingNamespace string) string {
+	switch subject.Kind {
+	case rbac_v1.UserKind:
+		return subject.Name
+	case rbac_v1.ServiceAccountKind:
+		namespace := subject.Namespace
+		if namespace == "" {
+			namespace = bindingNamespace
+		}
+		return "system:serviceaccount:" + namespace + ":" + subject.Name
+	default:
+		return ""
+	}
+}
+
+// snapshotFromListers builds a new RBACSnapshot from the informer caches. The objects are shared with the
+// caches, which replace them on updates instead of modifying them. It is restricted to the scope. When the
+// namespaces are watched, the snapshot includes the objects inherited down their hierarchy, also returned.
+func (in *PermissionWatcher) snapshotFromListers(scope ResolutionScope) (*RBACSnapshot, *NamespaceHierarchy, error) {
+	rbac := in.factory.Rbac().V1()
+	crs, err := rbac.ClusterRoles().Lister().List(labels.Everything())
+	if err != nil {
+		return nil, nil, err
+	}
+	crbs, err := rbac.ClusterRoleBindings().Lister().List(labels.Everything())
+	if err != nil {
+		return nil, nil, err
+	}
+	roles, err := rbac.Roles().Lister().List(labels.Everything())
+	if err != nil {
+		return nil, nil, err
+	}
+	rbs, err := rbac.RoleBindings().Lister().List(labels.Everything())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	snapshot := &RBACSnapshot{
+		ClusterRoles:        make(map[string]*rbac_v1.ClusterRole, len(crs)),
+		Roles:               make(map[string]*rbac_v1.Role, len(roles)),
+		ClusterRoleBindings: crbs,
+		RoleBindings:        rbs,
+		LoadedAt:            time.Now(),
+	}
+	for _, cr := range crs {
+		snapshot.ClusterRoles[cr.Name] = cr
+	}
+	for _, r := range roles {
+		snapshot.Roles[r.Namespace+"/"+r.Name] = r
+	}
+
+	// The feature gate can be disabled after Start
+	if !in.hierarchy || !FeatureEnabled(FeatureHNC) {
+		return snapshot.Scoped(scope), nil, nil
+	}
+	namespaces, err := in.factory.Core().V1().Namespaces().Lister().List(labels.Everything())
+	if err != nil {
+		return nil, nil, err
+	}
+	values := make([]core_v1.Namespace, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		values = append(values, *namespace)
+	}
+	hierarchy := NewNamespaceHierarchy(values)
+	return snapshot.WithHierarchy(hierarchy).Scoped(scope), hierarchy, nil
+}
+
+// diffPermissions returns the permissions present only in current (gained) and only in previous (lost), sorted.
+func diffPermissions(previous, current map[AccessRequest]bool) (gained, lost []AccessRequest) {
+	gained, lost = []AccessRequest{}, []AccessRequest{}
+	for p := range current {
+		if !previous[p] {
+			gained = append(gained, p)
+		}
+	}
+	for p := range previous {
+		if !current[p] {
+			lost = append(lost, p)
+		}
+	}
+	sortAccessRequests(gained)
+	sortAccessRequests(lost)
+	return gained, lost
+}
+
+// sortAccessRequests sorts the requests by namespace, API group, resource, subresource, name and verb.
+func sortAccessRequests(requests []AccessRequest) {
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.APIGroup != b.APIGroup {
+			return a.APIGroup < b.APIGroup
+		}
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		if a.Subresource != b.Subresource {
+			return a.Subresource < b.Subresource
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Verb < b.Verb
+	})
+}
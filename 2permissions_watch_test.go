@@ -0,0 +1,294 @@
+package business
+
+import (
+	"testing"
+	"time"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	kube_fake "k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestWatcher starts a watcher over a fake clientset holding the usual fixture and the objects, stopped
+// at the end of the test. The watcher is configured by setup, when set, before it is started. It returns once
+// the RBAC informers watch the clientset, since the fake clientset drops the events of objects changed before.
+func startTestWatcher(t *testing.T, setup func(*PermissionWatcher), objects ...runtime.Object) (*PermissionWatcher, *kube_fake.Clientset) {
+	t.Helper()
+	k8s, waitWatching := newTestWatchedClientset(t, objects...)
+	watcher := NewPermissionWatcher(informers.NewSharedInformerFactory(k8s, 0))
+	if setup != nil {
+		setup(watcher)
+	}
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	require.NoError(t, watcher.Start(stopCh))
+	waitWatching()
+	return watcher, k8s
+}
+
+// newTestWatchedClientset returns a fake clientset holding the usual fixture and the objects, and a function
+// waiting until the four RBAC informers of a watcher watch it.
+func newTestWatchedClientset(t *testing.T, objects ...runtime.Object) (*kube_fake.Clientset, func()) {
+	k8s := kube_fake.NewSimpleClientset(append(podReaderObjects(), objects...)...)
+	watching := make(chan struct{}, 4)
+	k8s.PrependWatchReactor("*", func(action k8s_testing.Action) (bool, watch.Interface, error) {
+		w, err := k8s.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return false, nil, err
+		}
+		if action.GetResource().Group == rbac_v1.GroupName {
+			watching <- struct{}{}
+		}
+		return true, w, nil
+	})
+	return k8s, func() {
+		t.Helper()
+		for i := 0; i < 4; i++ {
+			select {
+			case <-watching:
+			case <-time.After(5 * time.Second):
+				require.FailNow(t, "RBAC informers not watching")
+			}
+		}
+	}
+}
+
+// receiveChange returns the next change of the channel, failing the test if none comes in time.
+func receiveChange(t *testing.T, changes <-chan PermissionChange) PermissionChange {
+	t.Helper()
+	select {
+	case change, ok := <-changes:
+		require.True(t, ok, "channel closed")
+		return change
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no permission change received")
+		return PermissionChange{}
+	}
+}
+
+func TestPermissionWatcherNotifiesTheChanges(t *testing.T) {
+	watcher, k8s := startTestWatcher(t, nil)
+	require.True(t, watcher.HasSynced())
+	changes, cancel := watcher.Watch(UserInfo{Name: "alice"})
+	defer cancel()
+
+	binding := testRoleBinding("ns2", "alice-ns2", "ClusterRole", "pod-reader", testUser("alice"))
+	_, err := k8s.RbacV1().RoleBindings("ns2").Create(testCtx, binding, meta_v1.CreateOptions{})
+	require.NoError(t, err)
+
+	change := receiveChange(t, changes)
+	assert.Equal(t, "alice", change.User)
+	assert.Equal(t, []AccessRequest{
+		{Namespace: "ns2", Resource: "pods", Verb: "get"},
+		{Namespace: "ns2", Resource: "pods", Verb: "list"},
+		{Namespace: "ns2", Resource: "pods", Verb: "watch"},
+	}, change.Gained)
+	assert.Empty(t, change.Lost)
+	assert.Equal(t, []RBACObjectRef{{Kind: "RoleBinding", Namespace: "ns2", Name: "alice-ns2"}}, change.Causes)
+	assert.Equal(t, PermissionsHash(watcher.Snapshot().EffectivePermissions(UserInfo{Name: "alice"})), change.Hash)
+
+	require.NoError(t, k8s.RbacV1().RoleBindings("ns1").Delete(testCtx, "alice-pods", meta_v1.DeleteOptions{}))
+	change = receiveChange(t, changes)
+	assert.Empty(t, change.Gained)
+	assert.Len(t, change.Lost, 3)
+	assert.Equal(t, "ns1", change.Lost[0].Namespace)
+}
+
+func TestPermissionWatcherOnlyNotifiesTheAffectedUsers(t *testing.T) {
+	watcher, k8s := startTestWatcher(t, nil)
+	aliceChanges, cancelAlice := watcher.Watch(UserInfo{Name: "alice"})
+	defer cancelAlice()
+	developerChanges, cancelDeveloper := watcher.Watch(UserInfo{Name: "carol", Groups: []string{"developers"}})
+	defer cancelDeveloper()
+
+	// bob already reads the pods of every namespace
+	_, err := k8s.RbacV1().RoleBindings("ns2").Create(testCtx, testRoleBinding("ns2", "bob-ns2", "ClusterRole", "pod-reader", testUser("bob")), meta_v1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = k8s.RbacV1().RoleBindings("ns2").Create(testCtx, testRoleBinding("ns2", "developers-ns2", "ClusterRole", "pod-reader", testGroup("developers")), meta_v1.CreateOptions{})
+	require.NoError(t, err)
+
+	change := receiveChange(t, developerChanges)
+	assert.Equal(t, "carol", change.User)
+	assert.Len(t, change.Gained, 3)
+	select {
+	case change := <-aliceChanges:
+		assert.Fail(t, "unexpected change", "%+v", change)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPermissionWatcherCancel(t *testing.T) {
+	watcher, _ := startTestWatcher(t, nil)
+	changes, cancel := watcher.Watch(UserInfo{Name: "alice"})
+
+	cancel()
+	cancel()
+	_, ok := <-changes
+	assert.False(t, ok)
+}
+
+func TestPermissionWatcherClosesTheSubscribersNotKeepingUp(t *testing.T) {
+	watcher, k8s := startTestWatcher(t, nil)
+	changes, cancel := watcher.Watch(UserInfo{Name: "alice"})
+	defer cancel()
+	// The subscriber reads none of its changes
+	watcher.mu.Lock()
+	for _, sub := range watcher.subscriptions {
+		for i := 0; i < permissionChangeBufferSize; i++ {
+			sub.ch <- PermissionChange{User: "alice"}
+		}
+	}
+	watcher.mu.Unlock()
+
+	require.NoError(t, k8s.RbacV1().RoleBindings("ns1").Delete(testCtx, "alice-pods", meta_v1.DeleteOptions{}))
+	require.Eventually(t, func() bool {
+		watcher.mu.Lock()
+		defer watcher.mu.Unlock()
+		return len(watcher.subscriptions) == 0
+	}, 5*time.Second, time.Millisecond)
+
+	// The buffered changes are delivered, then the channel is closed instead of missing the revocation
+	for i := 0; i < permissionChangeBufferSize; i++ {
+		receiveChange(t, changes)
+	}
+	_, ok := <-changes
+	assert.False(t, ok)
+}
+
+func TestPermissionWatcherChangeHandler(t *testing.T) {
+	type call struct {
+		causes []RBACObjectRef
+		slices []CacheSlice
+	}
+	calls := make(chan call, 10)
+	_, k8s := startTestWatcher(t, func(watcher *PermissionWatcher) {
+		watcher.SetChangeHandler(func(causes []RBACObjectRef, slices []CacheSlice) {
+			// The handlers of the informers may still be receiving the initial objects
+			for _, cause := range causes {
+				if cause.Name == "alice-ns2" || cause.Name == "alice-all" {
+					calls <- call{causes: causes, slices: slices}
+					return
+				}
+			}
+		})
+	})
+
+	_, err := k8s.RbacV1().RoleBindings("ns2").Create(testCtx, testRoleBinding("ns2", "alice-ns2", "ClusterRole", "pod-reader", testUser("alice")), meta_v1.CreateOptions{})
+	require.NoError(t, err)
+	select {
+	case c := <-calls:
+		assert.Equal(t, []CacheSlice{{Namespace: "ns2", User: "alice"}}, c.slices)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "change handler not called")
+	}
+
+	// Cluster-wide changes may affect any decision
+	_, err = k8s.RbacV1().ClusterRoleBindings().Create(testCtx, testClusterRoleBinding("alice-all", "pod-reader", testUser("alice")), meta_v1.CreateOptions{})
+	require.NoError(t, err)
+	select {
+	case c := <-calls:
+		assert.Equal(t, []RBACObjectRef{{Kind: "ClusterRoleBinding", Name: "alice-all"}}, c.causes)
+		assert.Nil(t, c.slices)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "change handler not called")
+	}
+}
+
+func TestTakeCausesMergesTheSlicesOfANamespace(t *testing.T) {
+	watcher := NewPermissionWatcher(nil)
+	watcher.notifyChanged("RoleBinding", testRoleBinding("ns1", "alice-pods", "Role", "reader", testUser("alice")))
+	watcher.notifyChanged("Role", testRole("ns1", "reader"))
+	watcher.notifyChanged("RoleBinding", testRoleBinding("ns2", "bob-pods", "Role", "reader", testUser("bob"), testGroup("developers")))
+
+	causes, slices := watcher.takeCauses()
+	assert.Equal(t, []RBACObjectRef{
+		{Kind: "Role", Namespace: "ns1", Name: "reader"},
+		{Kind: "RoleBinding", Namespace: "ns1", Name: "alice-pods"},
+		{Kind: "RoleBinding", Namespace: "ns2", Name: "bob-pods"},
+	}, causes)
+	// Groups have unknown members, so they affect every user of the namespace
+	assert.Equal(t, []CacheSlice{{Namespace: "ns1"}, {Namespace: "ns2"}}, slices)
+
+	causes, slices = watcher.takeCauses()
+	assert.Empty(t, causes)
+	assert.Empty(t, slices)
+}
+
+func TestDiffPermissions(t *testing.T) {
+	get := AccessRequest{Resource: "pods", Verb: "get"}
+	list := AccessRequest{Resource: "pods", Verb: "list"}
+	watches := AccessRequest{Resource: "pods", Verb: "watch"}
+
+	gained, lost := diffPermissions(map[AccessRequest]bool{get: true, list: true}, map[AccessRequest]bool{list: true, watches: true})
+	assert.Equal(t, []AccessRequest{watches}, gained)
+	assert.Equal(t, []AccessRequest{get}, lost)
+}
+
+func TestUpdatedPermissions(t *testing.T) {
+	alice := UserInfo{Name: "alice"}
+	previous := map[AccessRequest]bool{
+		{Namespace: "ns1", Resource: "pods", Verb: "get"}:     true,
+		{Namespace: "ns2", Resource: "secrets", Verb: "get"}:  true,
+		{Namespace: "ns3", Resource: "services", Verb: "get"}: true,
+	}
+	// The RoleBinding of ns1 was deleted and alice was granted the pods of ns2
+	snapshot := testSnapshot(
+		testClusterRole("pod-reader", testRule([]string{""}, []string{"pods"}, []string{"get"})),
+		testRoleBinding("ns2", "alice-ns2", "ClusterRole", "pod-reader", testUser("alice")),
+	)
+
+	current := updatedPermissions(snapshot, alice, previous, []CacheSlice{{Namespace: "ns1", User: "alice"}, {Namespace: "ns2"}})
+	assert.Equal(t, map[AccessRequest]bool{
+		{Namespace: "ns2", Resource: "pods", Verb: "get"}:     true,
+		{Namespace: "ns3", Resource: "services", Verb: "get"}: true,
+	}, current)
+	assert.Len(t, previous, 3)
+
+	// The slices of the other users do not affect alice
+	assert.Nil(t, updatedPermissions(snapshot, alice, previous, []CacheSlice{{Namespace: "ns1", User: "bob"}}))
+}
+
+func TestPermissionWatcherSwapsTheSnapshots(t *testing.T) {
+	unstarted := NewPermissionWatcher(informers.NewSharedInformerFactory(kube_fake.NewSimpleClientset(), 0))
+	assert.False(t, unstarted.HasSynced())
+	assert.Nil(t, unstarted.Snapshot())
+
+	watcher, k8s := startTestWatcher(t, nil)
+	changes, cancel := watcher.Watch(UserInfo{Name: "alice"})
+	defer cancel()
+	previous := watcher.Snapshot()
+	require.NotNil(t, previous)
+
+	// Readers evaluate the snapshots without locking while they are swapped
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = watcher.Snapshot().EffectivePermissions(UserInfo{Name: "alice"})
+			}
+		}
+	}()
+
+	_, err := k8s.RbacV1().RoleBindings("ns2").Create(testCtx, testRoleBinding("ns2", "alice-ns2", "ClusterRole", "pod-reader", testUser("alice")), meta_v1.CreateOptions{})
+	require.NoError(t, err)
+	receiveChange(t, changes)
+	close(stop)
+	<-done
+
+	current := watcher.Snapshot()
+	assert.NotSame(t, previous, current)
+	assert.Len(t, previous.EffectivePermissions(UserInfo{Name: "alice"}), 3)
+	assert.Len(t, current.EffectivePermissions(UserInfo{Name: "alice"}), 6)
+}
@@ -0,0 +1,75 @@
+package business
+
+import (
+	"strings"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ExpandWildcards returns the permissions with their wildcard API groups, resources and verbs replaced
+// by the concrete ones of the discovery, e.g. from PermissionsClient.ServerPreferredResources, so the
+// reports show what the wildcards currently mean. The verbs are the ones listed by the discovery, so the
+// special verbs also granted by a "*" verb, like impersonate or escalate, are not shown. A wildcard
+// permission matching no served resource is dropped, since it currently grants nothing: a later
+// expansion may show it again, e.g. once a CRD is installed. Permissions without wildcards are kept.
+func ExpandWildcards(perms map[AccessRequest]bool, discovery []*meta_v1.APIResourceList) map[AccessRequest]bool {
+	type servedResource struct {
+		group, resource, subresource string
+		verbs                        []string
+	}
+	served := []servedResource{}
+	for _, list := range discovery {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, res := range list.APIResources {
+			resource, subresource, _ := strings.Cut(res.Name, "/")
+			served = append(served, servedResource{group: gv.Group, resource: resource, subresource: subresource, verbs: res.Verbs})
+		}
+	}
+
+	expanded := make(map[AccessRequest]bool, len(perms))
+	for perm, granted := range perms {
+		if !granted {
+			continue
+		}
+		if perm.APIGroup != rbac_v1.APIGroupAll && perm.Resource != rbac_v1.ResourceAll && perm.Verb != rbac_v1.VerbAll {
+			expanded[perm] = true
+			continue
+		}
+		for _, res := range served {
+			if !wildcardResourceMatches(perm, res.group, res.resource, res.subresource) {
+				continue
+			}
+			concrete := perm
+			concrete.APIGroup, concrete.Resource, concrete.Subresource = res.group, res.resource, res.subresource
+			if perm.Verb != rbac_v1.VerbAll {
+				if containsString(res.verbs, perm.Verb) {
+					expanded[concrete] = true
+				}
+				continue
+			}
+			for _, verb := range res.verbs {
+				concrete.Verb = verb
+				expanded[concrete] = true
+			}
+		}
+	}
+	return expanded
+}
+
+// wildcardResourceMatches returns true if the permission, whose API group, resource or verb is a
+// wildcard, covers the served resource. Like in the RBAC rules, a "*" resource covers the subresources
+// too, and "*/scale" covers the scale subresource of any resource.
+func wildcardResourceMatches(perm AccessRequest, group, resource, subresource string) bool {
+	if perm.APIGroup != rbac_v1.APIGroupAll && perm.APIGroup != group {
+		return false
+	}
+	if perm.Resource == rbac_v1.ResourceAll {
+		return perm.Subresource == "" || perm.Subresource == subresource
+	}
+	return perm.Resource == resource && perm.Subresource == subresource
+}
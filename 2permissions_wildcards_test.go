@@ -0,0 +1,50 @@
+package business
+
+import (
+	"testing"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testDiscovery() []*meta_v1.APIResourceList {
+	return []*meta_v1.APIResourceList{
+		{GroupVersion: "v1", APIResources: []meta_v1.APIResource{
+			{Name: "pods", Verbs: []string{"get", "list", "watch", "create", "delete"}},
+			{Name: "pods/log", Verbs: []string{"get"}},
+			{Name: "configmaps", Verbs: []string{"get", "list"}},
+		}},
+		{GroupVersion: "apps/v1", APIResources: []meta_v1.APIResource{
+			{Name: "deployments", Verbs: []string{"get", "list", "update"}},
+			{Name: "deployments/scale", Verbs: []string{"get", "update"}},
+		}},
+		{GroupVersion: "not/a/group/version"},
+	}
+}
+
+func TestExpandWildcards(t *testing.T) {
+	expanded := ExpandWildcards(map[AccessRequest]bool{
+		{Namespace: "ns1", Resource: "*", Verb: "get"}:                                         true,
+		{Namespace: "ns1", APIGroup: "*", Resource: "*", Subresource: "scale", Verb: "update"}: true,
+		{Namespace: "ns2", APIGroup: "apps", Resource: "deployments", Verb: "*"}:               true,
+		{Namespace: "ns1", Resource: "secrets", Verb: "get"}:                                   true,
+		{Namespace: "ns1", Resource: "services", Verb: "get"}:                                  false,
+		// Nothing served matches, so it currently grants nothing
+		{Namespace: "ns1", APIGroup: "batch", Resource: "*", Verb: "get"}: true,
+	}, testDiscovery())
+
+	assert.Equal(t, map[AccessRequest]bool{
+		// A "*" resource covers the subresources too
+		{Namespace: "ns1", Resource: "pods", Verb: "get"}:                     true,
+		{Namespace: "ns1", Resource: "pods", Subresource: "log", Verb: "get"}: true,
+		{Namespace: "ns1", Resource: "configmaps", Verb: "get"}:               true,
+		// "*/scale" covers the scale subresource of any resource
+		{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Subresource: "scale", Verb: "update"}: true,
+		// A "*" verb is the verbs listed by the discovery
+		{Namespace: "ns2", APIGroup: "apps", Resource: "deployments", Verb: "get"}:    true,
+		{Namespace: "ns2", APIGroup: "apps", Resource: "deployments", Verb: "list"}:   true,
+		{Namespace: "ns2", APIGroup: "apps", Resource: "deployments", Verb: "update"}: true,
+		{Namespace: "ns1", Resource: "secrets", Verb: "get"}:                          true,
+	}, expanded)
+}
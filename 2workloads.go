@@ -646,13 +646,14 @@ func (in *WorkloadService) fetchWorkloadsFromCluster(ctx context.Context, cluste
 	}
 
 	// Check permissions for each resource type
+	permissionsClient := NewKialiPermissionsClient(userClient)
 	resourceTypes := []string{
 		"pods", "replicationcontrollers", "deployments", "replicasets",
 		"deploymentconfigs", "statefulsets", "jobs", "cronjobs", "daemonsets",
 	}
 
 	for _, resourceType := range resourceTypes {
-		allowed, err := CheckUserPermissions(ctx, userClient, in.businessLayer.Permissions.ResourcePermissions[resourceType], resourceType, "list")
+		allowed, err := CheckUserPermissions(ctx, permissionsClient, cluster, in.businessLayer.Permissions.ResourcePermissions[resourceType], resourceType, "list")
 		if err != nil {
 			log.Errorf("Error checking permissions for resource %s: %v", resourceType, err)
 			continue
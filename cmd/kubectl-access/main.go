@@ -0,0 +1,622 @@
+// Command kubectl-access is a kubectl plugin reporting on the RBAC permissions of a cluster. Installed on
+// the PATH, it runs as "kubectl access":
+//
+//	kubectl access who-can delete deployments.apps -n prod
+//	kubectl access who-can get secrets --namespace-selector env=prod
+//	kubectl access explain alice get pods/log web-0 --group developers -n prod -o wide
+//	kubectl access permissions system:serviceaccount:prod:robot -o yaml
+//	kubectl access permissions alice --group developers --expand-wildcards
+//	kubectl access drift --dir ./rbac -o json
+//	kubectl access onboard payments --group payments-devs --tier edit > payments.yaml
+//	kubectl access compare --workload checks.yaml --repeat 10
+//	kubectl access cache-footprint --users 500000 --max-entries 100000
+//	kubectl access history bob --db history.db --as-of 2026-10-06
+//
+// It honors the kubectl conventions: --kubeconfig, --context, --namespace/-n and
+// -o json|yaml|table|wide|custom-columns=HEADER:.json.path,...
+// Permissions are evaluated locally on a snapshot of the RBAC objects, so the caller needs to be allowed
+// to list them, not to impersonate the users. The history is read from the SQLite history database of a
+// sidecar, see business.SQLiteHistoryStore.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	kube "k8s.io/client-go/kubernetes"
+	_ "modernc.org/sqlite"
+
+	"github.com/kiali/kiali/business"
+)
+
+func main() {
+	if err := newAccessCommand(genericclioptions.IOStreams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr}).Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// accessOptions are the flags shared by the subcommands.
+type accessOptions struct {
+	configFlags *genericclioptions.ConfigFlags
+	streams     genericclioptions.IOStreams
+	// kube and mapper are used instead of the client and the REST mapper of the kubeconfig when set,
+	// e.g. by the tests
+	kube   kube.Interface
+	mapper meta.RESTMapper
+
+	output string
+	groups []string
+
+	namespaceGlob     string
+	namespaceSelector string
+
+	expandWildcards bool
+
+	dir           string
+	gitOpsLabels  bool
+	includeSystem bool
+
+	tier string
+
+	workload string
+	repeat   int
+
+	users      int
+	maxEntries int
+
+	db    string
+	asOf  string
+	since time.Duration
+}
+
+func newAccessCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	return newAccessCommandWithOptions(&accessOptions{configFlags: genericclioptions.NewConfigFlags(true), streams: streams})
+}
+
+// newAccessCommandWithOptions returns the command setting the options o.
+func newAccessCommandWithOptions(o *accessOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "kubectl access",
+		Short:        "Report on the RBAC permissions of the cluster",
+		SilenceUsage: true,
+	}
+	o.configFlags.AddFlags(cmd.PersistentFlags())
+	cmd.PersistentFlags().StringVarP(&o.output, "output", "o", "", "Output format: json, yaml, table, wide or custom-columns=HEADER:.json.path,...")
+
+	whoCan := &cobra.Command{
+		Use:   "who-can VERB RESOURCE [NAME]",
+		Short: "List the subjects allowed to perform the verb on the resource, e.g. who-can get pods/log",
+		Args:  cobra.RangeArgs(2, 3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.runWhoCan(cmd.Context(), args)
+		},
+	}
+	whoCan.Flags().StringVar(&o.namespaceGlob, "namespace-glob", "", "List the subjects allowed in any namespace matching the glob, e.g. team-*")
+	whoCan.Flags().StringVar(&o.namespaceSelector, "namespace-selector", "", "List the subjects allowed in any namespace matching the label selector, e.g. env=prod")
+	cmd.AddCommand(whoCan)
+
+	explain := &cobra.Command{
+		Use:   "explain USER VERB RESOURCE [NAME]",
+		Short: "Explain which bindings allow the user to perform the verb on the resource",
+		Args:  cobra.RangeArgs(3, 4),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.runExplain(cmd.Context(), args)
+		},
+	}
+	explain.Flags().StringSliceVar(&o.groups, "group", nil, "Group of the user, can be repeated")
+	cmd.AddCommand(explain)
+
+	permissions := &cobra.Command{
+		Use:   "permissions USER",
+		Short: "List the permissions granted to the user",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.runPermissions(cmd.Context(), args[0])
+		},
+	}
+	permissions.Flags().StringSliceVar(&o.groups, "group", nil, "Group of the user, can be repeated")
+	permissions.Flags().BoolVar(&o.expandWildcards, "expand-wildcards", false, "Replace the wildcards with the resources and verbs currently served by the cluster")
+	cmd.AddCommand(permissions)
+
+	drift := &cobra.Command{
+		Use:   "drift (--dir DIR | --gitops-labels)",
+		Short: "Report the bindings not managed in Git, failing if there are any, e.g. in a CI gate",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.runDrift(cmd.Context())
+		},
+	}
+	drift.Flags().StringVar(&o.dir, "dir", "", "Directory of the desired manifests")
+	drift.Flags().BoolVar(&o.gitOpsLabels, "gitops-labels", false, "Consider the objects labeled by Flux or Argo CD as desired")
+	drift.Flags().BoolVar(&o.includeSystem, "include-system", false, "Also report the system bindings")
+	cmd.AddCommand(drift)
+
+	onboard := &cobra.Command{
+		Use:   "onboard TEAM --group GROUP [--tier view|edit|admin]",
+		Short: "Print the namespace and bindings onboarding the team, failing if they would not grant the tier",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.runOnboard(cmd.Context(), args[0])
+		},
+	}
+	onboard.Flags().StringSliceVar(&o.groups, "group", nil, "Group of the team, can be repeated")
+	onboard.Flags().StringVar(&o.tier, "tier", string(business.AccessTierEdit), "Access tier of the team: view, edit or admin")
+	cmd.AddCommand(onboard)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "redundant",
+		Short: "List the grants that can be deleted without changing the effective access, and the redundant rules with -o json|yaml",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.runRedundant(cmd.Context())
+		},
+	})
+
+	compare := &cobra.Command{
+		Use:   "compare --workload FILE [--repeat N]",
+		Short: "Compare the latency and the decisions of the local evaluation and of SubjectAccessReviews on a workload of checks",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.runCompare(cmd.Context())
+		},
+	}
+	compare.Flags().StringVar(&o.workload, "workload", "", "YAML file listing the checks, see business.LoadComparisonWorkload")
+	compare.Flags().IntVar(&o.repeat, "repeat", 1, "Number of runs of the workload")
+	cmd.AddCommand(compare)
+
+	footprint := &cobra.Command{
+		Use:   "cache-footprint [--users N] [--max-entries N]",
+		Short: "Measure the memory of the decision cache under a load of unique users, failing if its bound does not hold",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.runCacheFootprint()
+		},
+	}
+	footprint.Flags().IntVar(&o.users, "users", 200000, "Number of unique users inserted")
+	footprint.Flags().IntVar(&o.maxEntries, "max-entries", business.DefaultCacheMaxEntries, "Bound of the cache, 0 for none")
+	cmd.AddCommand(footprint)
+
+	history := &cobra.Command{
+		Use:   "history USER --db FILE [--as-of TIME | --since DURATION]",
+		Short: "List the permission changes of the user, or the permissions the user had at a time",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.runHistory(cmd.Context(), args[0])
+		},
+	}
+	history.Flags().StringVar(&o.db, "db", "", "SQLite history database")
+	history.Flags().StringVar(&o.asOf, "as-of", "", "List the permissions at the time, RFC 3339 or YYYY-MM-DD")
+	history.Flags().DurationVar(&o.since, "since", 0, "List the changes of the last duration only, e.g. 168h")
+	_ = history.MarkFlagRequired("db")
+	cmd.AddCommand(history)
+
+	return cmd
+}
+
+// client returns the client of the cluster of the kubeconfig.
+func (in *accessOptions) client() (business.PermissionsClient, error) {
+	k8s, err := in.kubeClient()
+	if err != nil {
+		return nil, err
+	}
+	return business.NewPermissionsClient(k8s), nil
+}
+
+// kubeClient creates a client-go client for the cluster of the kubeconfig.
+func (in *accessOptions) kubeClient() (kube.Interface, error) {
+	if in.kube != nil {
+		return in.kube, nil
+	}
+	restConfig, err := in.configFlags.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kube.NewForConfig(restConfig)
+}
+
+// snapshot loads the RBAC objects of the cluster of the kubeconfig.
+func (in *accessOptions) snapshot(ctx context.Context) (*business.RBACSnapshot, error) {
+	client, err := in.client()
+	if err != nil {
+		return nil, err
+	}
+	return business.LoadRBACSnapshot(ctx, client)
+}
+
+// request builds the request of the arguments, in the namespace of the flags or of the kubeconfig, unless
+// the resource is cluster-scoped. Resources are given as resource[.group][/subresource], like kubectl auth
+// can-i, see business.ParseAccessRequest.
+func (in *accessOptions) request(verb, resource string, name []string) (business.AccessRequest, error) {
+	req, err := business.ParseAccessRequest(strings.Join(append([]string{verb, resource}, name...), " "))
+	if err != nil {
+		return business.AccessRequest{}, err
+	}
+	if in.clusterScoped(req) {
+		return req, nil
+	}
+	if req.Namespace, _, err = in.configFlags.ToRawKubeConfigLoader().Namespace(); err != nil {
+		return business.AccessRequest{}, err
+	}
+	return req, nil
+}
+
+// clusterScoped returns true if the REST mapper of the cluster knows the resource of the request as
+// cluster-scoped. The unknown resources, e.g. wildcards, are namespaced, like kubectl auth can-i.
+func (in *accessOptions) clusterScoped(req business.AccessRequest) bool {
+	mapper := in.mapper
+	if mapper == nil {
+		var err error
+		if mapper, err = in.configFlags.ToRESTMapper(); err != nil {
+			return false
+		}
+	}
+	kind, err := mapper.KindFor(schema.GroupVersionResource{Group: req.APIGroup, Resource: req.Resource})
+	if err != nil {
+		return false
+	}
+	mapping, err := mapper.RESTMapping(kind.GroupKind(), kind.Version)
+	return err == nil && mapping.Scope.Name() == meta.RESTScopeNameRoot
+}
+
+func (in *accessOptions) runWhoCan(ctx context.Context, args []string) error {
+	format, err := business.ParseOutputFormat(in.output, business.OutputTable)
+	if err != nil {
+		return err
+	}
+	snapshot, err := in.snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := in.request(args[0], args[1], args[2:])
+	if err != nil {
+		return err
+	}
+	selector := business.NamespaceSelector{Glob: in.namespaceGlob, LabelSelector: in.namespaceSelector}
+	namespaces := []string{req.Namespace}
+	var subjects []rbac_v1.Subject
+	if selector.IsEmpty() {
+		subjects = snapshot.WhoCan(req)
+	} else {
+		if namespaces, err = in.selectNamespaces(ctx, selector); err != nil {
+			return err
+		}
+		subjects = snapshot.WhoCanInNamespaces(req, namespaces)
+	}
+	if format.Name != business.OutputWide {
+		return business.PrintOutput(in.streams.Out, format, subjects, business.NewTable(subjects, business.SubjectColumns...))
+	}
+	// The wide format shows the bindings allowing each subject, in each namespace
+	paths := []business.PermissionPath{}
+	for _, namespace := range namespaces {
+		req.Namespace = namespace
+		for _, subject := range subjects {
+			paths = append(paths, snapshot.Explain(subjectUser(subject), req).Paths...)
+		}
+	}
+	return business.PrintOutput(in.streams.Out, format, paths, business.NewTable(paths, business.PermissionPathColumns...))
+}
+
+// selectNamespaces lists the namespaces of the cluster selected by the selector.
+func (in *accessOptions) selectNamespaces(ctx context.Context, selector business.NamespaceSelector) ([]string, error) {
+	if err := selector.Validate(); err != nil {
+		return nil, err
+	}
+	k8s, err := in.kubeClient()
+	if err != nil {
+		return nil, err
+	}
+	list, err := k8s.CoreV1().Namespaces().List(ctx, meta_v1.ListOptions{LabelSelector: selector.LabelSelector})
+	if err != nil {
+		return nil, fmt.Errorf("error listing the namespaces: %w", err)
+	}
+	return selector.Select(list.Items)
+}
+
+func (in *accessOptions) runExplain(ctx context.Context, args []string) error {
+	format, err := business.ParseOutputFormat(in.output, business.OutputTable)
+	if err != nil {
+		return err
+	}
+	snapshot, err := in.snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := in.request(args[1], args[2], args[3:])
+	if err != nil {
+		return err
+	}
+	explanation := snapshot.Explain(business.UserInfo{Name: args[0], Groups: in.groups}, req)
+	if format.Name != business.OutputTable && format.Name != business.OutputWide {
+		return business.PrintOutput(in.streams.Out, format, explanation, nil)
+	}
+	fmt.Fprintf(in.streams.Out, "allowed: %t\n", explanation.Allowed)
+	if !explanation.Allowed {
+		return nil
+	}
+	return business.PrintOutput(in.streams.Out, format, explanation.Paths, business.NewTable(explanation.Paths, business.PermissionPathColumns...))
+}
+
+func (in *accessOptions) runPermissions(ctx context.Context, username string) error {
+	format, err := business.ParseOutputFormat(in.output, business.OutputTable)
+	if err != nil {
+		return err
+	}
+	client, err := in.client()
+	if err != nil {
+		return err
+	}
+	snapshot, err := business.LoadRBACSnapshot(ctx, client)
+	if err != nil {
+		return err
+	}
+	effective := snapshot.EffectivePermissions(business.UserInfo{Name: username, Groups: in.groups})
+	if in.expandWildcards {
+		// Discovery can fail for some API groups only, e.g. an unavailable aggregated API
+		discovery, err := client.ServerPreferredResources()
+		if err != nil && len(discovery) == 0 {
+			return fmt.Errorf("error discovering cluster resources: %w", err)
+		}
+		effective = business.ExpandWildcards(effective, discovery)
+	}
+	permissions := []business.AccessRequest{}
+	for req := range effective {
+		permissions = append(permissions, req)
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		a, b := permissions[i], permissions[j]
+		for _, pair := range [][2]string{{a.Namespace, b.Namespace}, {a.APIGroup, b.APIGroup}, {a.Resource, b.Resource}, {a.Subresource, b.Subresource}, {a.Name, b.Name}} {
+			if pair[0] != pair[1] {
+				return pair[0] < pair[1]
+			}
+		}
+		return a.Verb < b.Verb
+	})
+	return business.PrintOutput(in.streams.Out, format, permissions, business.NewTable(permissions, business.AccessRequestColumns...))
+}
+
+func (in *accessOptions) runDrift(ctx context.Context) error {
+	if (in.dir == "") == !in.gitOpsLabels {
+		return fmt.Errorf("one of --dir or --gitops-labels is required")
+	}
+	format, err := business.ParseOutputFormat(in.output, business.OutputTable)
+	if err != nil {
+		return err
+	}
+	snapshot, err := in.snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	if !in.includeSystem {
+		snapshot = snapshot.WithoutSystem(business.ExcludeAllSystem)
+	}
+
+	var desired *business.DesiredRBAC
+	if in.gitOpsLabels {
+		desired = business.DesiredFromGitOpsLabels(snapshot)
+	} else {
+		namespace, _, err := in.configFlags.ToRawKubeConfigLoader().Namespace()
+		if err != nil {
+			return err
+		}
+		if desired, err = business.LoadDesiredRBAC(in.dir, namespace); err != nil {
+			return err
+		}
+	}
+
+	report := business.DetectDrift(snapshot, desired)
+	if format.Name == business.OutputTable || format.Name == business.OutputWide {
+		err = business.PrintOutput(in.streams.Out, format, report.Unmanaged, business.NewTable(report.Unmanaged, business.DriftObjectColumns...))
+	} else {
+		err = business.PrintOutput(in.streams.Out, format, report, nil)
+	}
+	if err != nil {
+		return err
+	}
+	if report.HasDrift() {
+		return fmt.Errorf("%d unmanaged bindings", len(report.Unmanaged))
+	}
+	return nil
+}
+
+// runOnboard prints the bundle as YAML manifests, and the failed checks of its verification on the
+// error stream. The namespace of the team is the one of the flags, or the name of the team.
+func (in *accessOptions) runOnboard(ctx context.Context, name string) error {
+	team := business.TeamDefinition{Name: name, Groups: in.groups, Tier: business.AccessTier(in.tier)}
+	if in.configFlags.Namespace != nil {
+		team.Namespace = *in.configFlags.Namespace
+	}
+	bundle, err := business.BuildOnboardingBundle(team)
+	if err != nil {
+		return err
+	}
+	snapshot, err := in.snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	verification, err := bundle.Verify(snapshot)
+	if err != nil {
+		return err
+	}
+	if !verification.Passed {
+		for _, check := range verification.Checks {
+			if check.Allowed == check.Expected {
+				continue
+			}
+			resource := check.Request.Resource
+			if check.Request.Subresource != "" {
+				resource += "/" + check.Request.Subresource
+			}
+			fmt.Fprintf(in.streams.ErrOut, "%s %s: allowed %t, expected %t\n", check.Request.Verb, resource, check.Allowed, check.Expected)
+		}
+		return fmt.Errorf("the bundle would not grant team %s the %s tier", name, in.tier)
+	}
+	manifests, err := business.RenderManifests(bundle.Objects)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(in.streams.Out, manifests)
+	return err
+}
+
+func (in *accessOptions) runRedundant(ctx context.Context) error {
+	format, err := business.ParseOutputFormat(in.output, business.OutputTable)
+	if err != nil {
+		return err
+	}
+	snapshot, err := in.snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	report := snapshot.FindRedundancies()
+	if format.Name == business.OutputTable || format.Name == business.OutputWide {
+		return business.PrintOutput(in.streams.Out, format, report.Grants, business.NewTable(report.Grants, business.RedundantGrantColumns...))
+	}
+	return business.PrintOutput(in.streams.Out, format, report, nil)
+}
+
+// runCompare prints the comparison report, and fails if the paths disagree. Unlike the other commands,
+// the caller needs to be allowed to create SubjectAccessReviews.
+func (in *accessOptions) runCompare(ctx context.Context) error {
+	if in.workload == "" {
+		return fmt.Errorf("--workload is required")
+	}
+	format, err := business.ParseOutputFormat(in.output, business.OutputTable)
+	if err != nil {
+		return err
+	}
+	cases, err := business.LoadComparisonWorkload(in.workload)
+	if err != nil {
+		return err
+	}
+	client, err := in.client()
+	if err != nil {
+		return err
+	}
+	report, err := business.CompareEvaluation(ctx, client, cases, in.repeat)
+	if err != nil {
+		return err
+	}
+
+	if format.Name != business.OutputTable && format.Name != business.OutputWide {
+		err = business.PrintOutput(in.streams.Out, format, report, nil)
+	} else {
+		out := in.streams.Out
+		fmt.Fprintf(out, "checks: %d, snapshot load: %s\n", report.Checks, report.SnapshotLoad)
+		fmt.Fprintf(out, "%-6s %-12s %-12s %-12s %-12s %s\n", "PATH", "P50", "P90", "P99", "MAX", "APISERVER CALLS")
+		for _, path := range []struct {
+			name    string
+			latency business.LatencySummary
+			calls   int64
+		}{{"local", report.Local, report.LocalAPIServerCalls}, {"live", report.Live, report.LiveAPIServerCalls}} {
+			fmt.Fprintf(out, "%-6s %-12s %-12s %-12s %-12s %d\n", path.name, path.latency.P50, path.latency.P90, path.latency.P99, path.latency.Max, path.calls)
+		}
+		for _, disagreement := range report.Disagreements {
+			fmt.Fprintln(out, disagreement.String())
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(report.Disagreements) > 0 {
+		return fmt.Errorf("%d disagreements between the local evaluation and the apiserver", len(report.Disagreements))
+	}
+	return nil
+}
+
+// runCacheFootprint measures the cache in this process, no cluster is needed.
+func (in *accessOptions) runCacheFootprint() error {
+	format, err := business.ParseOutputFormat(in.output, business.OutputTable)
+	if err != nil {
+		return err
+	}
+	footprint := business.MeasureCacheFootprint(in.users, in.maxEntries)
+	footprints := []business.CacheFootprint{footprint}
+	err = business.PrintOutput(in.streams.Out, format, footprint, business.NewTable(footprints,
+		business.Column[business.CacheFootprint]{Header: "INSERTED", Value: func(in business.CacheFootprint) string { return fmt.Sprint(in.Inserted) }},
+		business.Column[business.CacheFootprint]{Header: "MAX ENTRIES", Value: func(in business.CacheFootprint) string { return fmt.Sprint(in.MaxEntries) }},
+		business.Column[business.CacheFootprint]{Header: "ENTRIES", Value: func(in business.CacheFootprint) string { return fmt.Sprint(in.Entries) }},
+		business.Column[business.CacheFootprint]{Header: "SYMBOLS", Value: func(in business.CacheFootprint) string { return fmt.Sprint(in.Symbols) }},
+		business.Column[business.CacheFootprint]{Header: "HEAP BYTES", Value: func(in business.CacheFootprint) string { return fmt.Sprint(in.HeapBytes) }},
+		business.Column[business.CacheFootprint]{Header: "BYTES/ENTRY", Value: func(in business.CacheFootprint) string { return fmt.Sprint(in.BytesPerEntry) }},
+	))
+	if err != nil {
+		return err
+	}
+	if !footprint.BoundHeld {
+		return fmt.Errorf("the cache held %d entries, more than its bound of %d", footprint.Entries, footprint.MaxEntries)
+	}
+	return nil
+}
+
+func (in *accessOptions) runHistory(ctx context.Context, username string) error {
+	format, err := business.ParseOutputFormat(in.output, business.OutputTable)
+	if err != nil {
+		return err
+	}
+	// Opened as a file URI, so a missing database is an error rather than created empty
+	store, err := business.OpenSQLiteHistoryStore(ctx, "file:"+in.db+"?mode=rw")
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if in.asOf == "" {
+		since := time.Time{}
+		if in.since > 0 {
+			since = time.Now().Add(-in.since)
+		}
+		changes, err := store.History(ctx, username, since, time.Time{})
+		if err != nil {
+			return err
+		}
+		return business.PrintOutput(in.streams.Out, format, changes, business.NewTable(changes,
+			business.Column[business.PermissionChange]{Header: "TIME", Value: func(in business.PermissionChange) string { return in.Timestamp.Format(time.RFC3339) }},
+			business.Column[business.PermissionChange]{Header: "GAINED", Value: func(in business.PermissionChange) string { return fmt.Sprint(len(in.Gained)) }},
+			business.Column[business.PermissionChange]{Header: "LOST", Value: func(in business.PermissionChange) string { return fmt.Sprint(len(in.Lost)) }},
+			business.Column[business.PermissionChange]{Header: "CAUSES", Value: func(in business.PermissionChange) string {
+				causes := []string{}
+				for _, cause := range in.Causes {
+					causes = append(causes, cause.Kind+"/"+cause.Name)
+				}
+				return strings.Join(causes, ",")
+			}},
+		))
+	}
+
+	at, err := time.Parse(time.RFC3339, in.asOf)
+	if err != nil {
+		if at, err = time.ParseInLocation("2006-01-02", in.asOf, time.Local); err != nil {
+			return fmt.Errorf("invalid --as-of %q, expected RFC 3339 or YYYY-MM-DD", in.asOf)
+		}
+	}
+	permissions, found, err := store.AccessAsOf(ctx, username, at)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no history of user %s before %s", username, at.Format(time.RFC3339))
+	}
+	return business.PrintOutput(in.streams.Out, format, permissions, business.NewTable(permissions, business.AccessRequestColumns...))
+}
+
+// subjectUser returns the user matching the subject, to explain its permissions.
+func subjectUser(subject rbac_v1.Subject) business.UserInfo {
+	switch subject.Kind {
+	case rbac_v1.GroupKind:
+		return business.UserInfo{Groups: []string{subject.Name}}
+	case rbac_v1.ServiceAccountKind:
+		return business.UserInfo{Name: "system:serviceaccount:" + subject.Namespace + ":" + subject.Name}
+	default:
+		return business.UserInfo{Name: subject.Name}
+	}
+}
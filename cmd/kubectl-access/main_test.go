@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	kube_fake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiali/kiali/business"
+)
+
+// testKubeconfig has a context whose namespace is team.
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://kubernetes.invalid
+contexts:
+- name: test
+  context:
+    cluster: test
+    namespace: team
+current-context: test
+`
+
+// testObjects grant alice the pods of team, bob the pods of prod and carol the namespaces.
+func testObjects() []runtime.Object {
+	rule := func(resource string) []rbac_v1.PolicyRule {
+		return []rbac_v1.PolicyRule{{APIGroups: []string{""}, Resources: []string{resource}, Verbs: []string{"get", "list"}}}
+	}
+	binding := func(namespace, user string) *rbac_v1.RoleBinding {
+		return &rbac_v1.RoleBinding{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: namespace, Name: user + "-pods"},
+			RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: "pod-reader"},
+			Subjects:   []rbac_v1.Subject{{Kind: rbac_v1.UserKind, APIGroup: rbac_v1.GroupName, Name: user}},
+		}
+	}
+	return []runtime.Object{
+		&rbac_v1.ClusterRole{ObjectMeta: meta_v1.ObjectMeta{Name: "pod-reader"}, Rules: rule("pods")},
+		&rbac_v1.ClusterRole{ObjectMeta: meta_v1.ObjectMeta{Name: "namespace-reader"}, Rules: rule("namespaces")},
+		binding("team", "alice"),
+		binding("prod", "bob"),
+		&rbac_v1.ClusterRoleBinding{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "carol-namespaces"},
+			RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: "namespace-reader"},
+			Subjects:   []rbac_v1.Subject{{Kind: rbac_v1.UserKind, APIGroup: rbac_v1.GroupName, Name: "carol"}},
+		},
+	}
+}
+
+// testMapper knows the pods and deployments as namespaced, and the namespaces as cluster-scoped.
+func testMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	return mapper
+}
+
+// runAccess runs the command with the arguments against the testObjects, returning its output.
+func runAccess(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	return runAccessWith(t, testObjects(), args...)
+}
+
+// runAccessWith runs the command with the arguments against the objects, returning its output.
+func runAccessWith(t *testing.T, objects []runtime.Object, args ...string) (string, error) {
+	t.Helper()
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(testKubeconfig), 0o600))
+	t.Setenv("KUBECONFIG", kubeconfig)
+
+	out := &bytes.Buffer{}
+	cmd := newAccessCommandWithOptions(&accessOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+		streams:     genericclioptions.IOStreams{In: &bytes.Buffer{}, Out: out, ErrOut: &bytes.Buffer{}},
+		kube:        kube_fake.NewSimpleClientset(objects...),
+		mapper:      testMapper(),
+	})
+	cmd.SetArgs(args)
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func explainRequest(t *testing.T, args ...string) business.AccessRequest {
+	t.Helper()
+	out, err := runAccess(t, append([]string{"explain"}, args...)...)
+	require.NoError(t, err)
+	var explanation business.Explanation
+	require.NoError(t, json.Unmarshal([]byte(out), &explanation))
+	return explanation.Request
+}
+
+func TestRequestNamespace(t *testing.T) {
+	// The namespace of the kubeconfig by default, the one of the flags otherwise
+	assert.Equal(t, "team", explainRequest(t, "alice", "get", "pods", "-o", "json").Namespace)
+	assert.Equal(t, "prod", explainRequest(t, "alice", "get", "pods", "-n", "prod", "-o", "json").Namespace)
+	assert.Equal(t, "prod", explainRequest(t, "alice", "get", "deployments.apps", "--namespace", "prod", "-o", "json").Namespace)
+
+	// The cluster-scoped resources have no namespace, whatever the flags
+	req := explainRequest(t, "carol", "get", "namespaces", "-n", "prod", "-o", "json")
+	assert.Equal(t, business.AccessRequest{Resource: "namespaces", Verb: "get"}, req)
+	assert.Equal(t, "", explainRequest(t, "carol", "get", "namespaces", "-o", "json").Namespace)
+
+	// The unknown resources are namespaced
+	assert.Equal(t, "team", explainRequest(t, "alice", "get", "widgets.example.com", "-o", "json").Namespace)
+}
+
+func TestWhoCan(t *testing.T) {
+	subjects := func(args ...string) []string {
+		out, err := runAccess(t, append([]string{"who-can"}, args...)...)
+		require.NoError(t, err)
+		var subjects []rbac_v1.Subject
+		require.NoError(t, json.Unmarshal([]byte(out), &subjects))
+		names := []string{}
+		for _, subject := range subjects {
+			names = append(names, subject.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"alice"}, subjects("get", "pods", "-o", "json"))
+	assert.Equal(t, []string{"bob"}, subjects("get", "pods", "-n", "prod", "-o", "json"))
+	assert.Equal(t, []string{"carol"}, subjects("list", "namespaces", "-o", "json"))
+}
+
+func TestOutputFormats(t *testing.T) {
+	out, err := runAccess(t, "permissions", "alice")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Equal(t, []string{"NAMESPACE", "RESOURCE", "VERB"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"team", "pods", "get"}, strings.Fields(lines[1]))
+
+	out, err = runAccess(t, "permissions", "alice", "-o", "wide")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"NAMESPACE", "RESOURCE", "SUBRESOURCE", "NAME", "VERB"}, strings.Fields(strings.Split(out, "\n")[0]))
+
+	out, err = runAccess(t, "permissions", "alice", "-o", "yaml")
+	require.NoError(t, err)
+	var permissions []business.AccessRequest
+	require.NoError(t, yaml.Unmarshal([]byte(out), &permissions))
+	assert.Equal(t, []business.AccessRequest{
+		{Namespace: "team", Resource: "pods", Verb: "get"},
+		{Namespace: "team", Resource: "pods", Verb: "list"},
+	}, permissions)
+
+	out, err = runAccess(t, "permissions", "alice", "-o", "custom-columns=VERB:.Verb")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"VERB", "get", "list"}, strings.Fields(out))
+
+	_, err = runAccess(t, "permissions", "alice", "-o", "bogus")
+	assert.Error(t, err)
+}
+
+func TestExplain(t *testing.T) {
+	out, err := runAccess(t, "explain", "alice", "get", "pods")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out, "allowed: true\n"))
+	assert.Contains(t, out, "alice-pods")
+
+	out, err = runAccess(t, "explain", "alice", "get", "pods", "-n", "prod")
+	require.NoError(t, err)
+	assert.Equal(t, "allowed: false\n", out)
+}
+
+func TestDrift(t *testing.T) {
+	dir := t.TempDir()
+	// The manifests of team have no namespace, they get the one of the kubeconfig
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "rbac.yaml"), []byte(`apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: alice-pods
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: carol-namespaces
+`), 0o600))
+
+	out, err := runAccess(t, "drift", "--dir", dir)
+	assert.EqualError(t, err, "1 unmanaged bindings")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, []string{"KIND", "NAMESPACE", "NAME"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"RoleBinding", "prod", "bob-pods"}, strings.Fields(lines[1]))
+
+	out, err = runAccess(t, "drift", "--dir", dir, "-o", "json")
+	assert.Error(t, err)
+	var report business.DriftReport
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	assert.Equal(t, dir, report.Source)
+	assert.Equal(t, []business.DriftObject{{Kind: "RoleBinding", Namespace: "prod", Name: "bob-pods"}}, report.Unmanaged)
+
+	// Without GitOps labels, every binding is unmanaged
+	_, err = runAccess(t, "drift", "--gitops-labels")
+	assert.EqualError(t, err, "3 unmanaged bindings")
+
+	_, err = runAccess(t, "drift")
+	assert.EqualError(t, err, "one of --dir or --gitops-labels is required")
+	_, err = runAccess(t, "drift", "--dir", dir, "--gitops-labels")
+	assert.EqualError(t, err, "one of --dir or --gitops-labels is required")
+}
+
+// tierObjects are simplified default ClusterRoles of the access tiers.
+func tierObjects() []runtime.Object {
+	rule := func(group string, resources []string, verbs ...string) rbac_v1.PolicyRule {
+		return rbac_v1.PolicyRule{APIGroups: []string{group}, Resources: resources, Verbs: verbs}
+	}
+	view := []rbac_v1.PolicyRule{
+		rule("", []string{"pods", "pods/log"}, "get", "list", "watch"),
+		rule("apps", []string{"deployments"}, "get", "list", "watch"),
+	}
+	edit := append([]rbac_v1.PolicyRule{
+		rule("", []string{"secrets"}, "get"),
+		rule("", []string{"pods/exec"}, "create"),
+		rule("apps", []string{"deployments"}, "update"),
+	}, view...)
+	return []runtime.Object{
+		&rbac_v1.ClusterRole{ObjectMeta: meta_v1.ObjectMeta{Name: "view"}, Rules: view},
+		&rbac_v1.ClusterRole{ObjectMeta: meta_v1.ObjectMeta{Name: "edit"}, Rules: edit},
+		&rbac_v1.ClusterRole{ObjectMeta: meta_v1.ObjectMeta{Name: "admin"}, Rules: append([]rbac_v1.PolicyRule{
+			rule(rbac_v1.GroupName, []string{"roles", "rolebindings"}, "create", "delete"),
+		}, edit...)},
+	}
+}
+
+func TestOnboard(t *testing.T) {
+	out, err := runAccessWith(t, tierObjects(), "onboard", "payments", "--group", "payments-devs")
+	require.NoError(t, err)
+	var binding rbac_v1.RoleBinding
+	manifests := strings.Split(out, "---\n")
+	require.Len(t, manifests, 2)
+	assert.Contains(t, manifests[0], "kind: Namespace\n")
+	require.NoError(t, yaml.Unmarshal([]byte(manifests[1]), &binding))
+	assert.Equal(t, "payments", binding.Namespace)
+	assert.Equal(t, "edit", binding.RoleRef.Name)
+	assert.Equal(t, "payments-devs", binding.Subjects[0].Name)
+
+	// The namespace of the flags is the one of the team
+	out, err = runAccessWith(t, tierObjects(), "onboard", "payments", "--group", "payments-devs", "--tier", "view", "-n", "team-payments")
+	require.NoError(t, err)
+	assert.Contains(t, out, "namespace: team-payments\n")
+
+	// A team already granted more than its tier fails the verification
+	objects := append(tierObjects(), &rbac_v1.ClusterRoleBinding{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "payments-admin"},
+		RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: "admin"},
+		Subjects:   []rbac_v1.Subject{{Kind: rbac_v1.GroupKind, APIGroup: rbac_v1.GroupName, Name: "payments-devs"}},
+	})
+	out, err = runAccessWith(t, objects, "onboard", "payments", "--group", "payments-devs")
+	assert.EqualError(t, err, "the bundle would not grant team payments the edit tier")
+	assert.Empty(t, out)
+
+	// The tiers are the ClusterRoles of the cluster
+	_, err = runAccess(t, "onboard", "payments", "--group", "payments-devs")
+	assert.Error(t, err)
+}
+
+func TestRedundant(t *testing.T) {
+	// alice is granted the pods of every namespace, the binding of alice in team is redundant
+	objects := append(testObjects(), &rbac_v1.ClusterRoleBinding{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "alice-all-pods"},
+		RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: "pod-reader"},
+		Subjects:   []rbac_v1.Subject{{Kind: rbac_v1.UserKind, APIGroup: rbac_v1.GroupName, Name: "alice"}},
+	})
+
+	out, err := runAccessWith(t, objects, "redundant")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, []string{"BINDING", "NAMESPACE", "SUBJECT", "ROLE"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"RoleBinding/alice-pods", "team", "User/alice", "ClusterRole/pod-reader"}, strings.Fields(lines[1]))
+
+	out, err = runAccessWith(t, objects, "redundant", "-o", "json")
+	require.NoError(t, err)
+	var report business.RedundancyReport
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	require.Len(t, report.Grants, 1)
+	assert.Equal(t, "alice-pods", report.Grants[0].BindingName)
+	require.Len(t, report.Grants[0].CoveredBy, 1)
+	assert.Equal(t, "alice-all-pods", report.Grants[0].CoveredBy[0].Name)
+
+	out, err = runAccess(t, "redundant")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"BINDING", "NAMESPACE", "SUBJECT", "ROLE"}, strings.Fields(out))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -14,6 +15,15 @@ type UserPermissions struct {
 	Resources map[string][]string
 	// APIGroups is a map of API groups to their allowed resources
 	APIGroups map[string][]string
+	// Rules are the rules the permissions were built from, with their ClusterRole and ClusterRoleBinding
+	Rules []ContributingRule
+}
+
+// ContributingRule is a rule of a ClusterRole bound to the user
+type ContributingRule struct {
+	ClusterRoleBinding string
+	ClusterRole        string
+	Rule               rbacv1.PolicyRule
 }
 
 // GetUserPermissions retrieves the permissions for a given user by checking their ClusterRoleBindings
@@ -41,6 +51,8 @@ func GetUserPermissions(k8s kubernetes.Interface, username string) (*UserPermiss
 
 				// Process rules
 				for _, rule := range cr.Rules {
+					permissions.Rules = append(permissions.Rules, ContributingRule{ClusterRoleBinding: crb.Name, ClusterRole: cr.Name, Rule: rule})
+
 					// Process API groups
 					for _, apiGroup := range rule.APIGroups {
 						if apiGroup == "*" {
@@ -99,6 +111,104 @@ func (p *UserPermissions) HasPermission(apiGroup, resource, verb string) bool {
 	return false
 }
 
+// HasPermissionWithRules is HasPermission also returning the rules allowing the action, e.g. to explain
+// the decision, without calling the API server again. The action is allowed only if a rule matches its API
+// group, resource and verb: unlike the Resources map of HasPermission, a wildcard resource only allows the
+// API groups of its rule
+func (p *UserPermissions) HasPermissionWithRules(apiGroup, resource, verb string) (bool, []ContributingRule) {
+	if apiGroup == "core" {
+		apiGroup = ""
+	}
+
+	var rules []ContributingRule
+	for _, contributing := range p.Rules {
+		rule := contributing.Rule
+		if containsOrWildcard(rule.APIGroups, apiGroup) && containsOrWildcard(rule.Resources, resource) && containsOrWildcard(rule.Verbs, verb) {
+			rules = append(rules, contributing)
+		}
+	}
+	return len(rules) > 0, rules
+}
+
+// containsOrWildcard checks if the values contain the value or the "*" wildcard
+func containsOrWildcard(values []string, value string) bool {
+	for _, v := range values {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAllPermissions checks if a user has permission to perform all the actions on a resource, evaluating
+// the resource once, e.g. to gate a UI on get and update. No verbs grant nothing, so the gate stays closed
+func (p *UserPermissions) HasAllPermissions(apiGroup, resource string, verbs []string) bool {
+	if len(verbs) == 0 {
+		return false
+	}
+	allowed := p.allowedVerbs(apiGroup, resource)
+	for _, verb := range verbs {
+		if !containsOrWildcard(allowed, verb) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasAnyPermission checks if a user has permission to perform at least one of the actions on a resource,
+// evaluating the resource once
+func (p *UserPermissions) HasAnyPermission(apiGroup, resource string, verbs []string) bool {
+	allowed := p.allowedVerbs(apiGroup, resource)
+	for _, verb := range verbs {
+		if containsOrWildcard(allowed, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedVerbs returns the verbs a user can perform on a resource, like HasPermission evaluates them
+func (p *UserPermissions) allowedVerbs(apiGroup, resource string) []string {
+	verbs := append([]string{}, p.Resources["*"]...)
+	if apiGroup == "core" {
+		apiGroup = ""
+	}
+	if resourceVerbs, ok := p.Resources[resource]; ok && containsOrWildcard(p.APIGroups[apiGroup], resource) {
+		verbs = append(verbs, resourceVerbs...)
+	}
+	return verbs
+}
+
+// CanGet checks if a user can get the resource
+func (p *UserPermissions) CanGet(apiGroup, resource string) bool {
+	return p.HasPermission(apiGroup, resource, "get")
+}
+
+// CanList checks if a user can list the resource
+func (p *UserPermissions) CanList(apiGroup, resource string) bool {
+	return p.HasPermission(apiGroup, resource, "list")
+}
+
+// CanWatch checks if a user can watch the resource
+func (p *UserPermissions) CanWatch(apiGroup, resource string) bool {
+	return p.HasPermission(apiGroup, resource, "watch")
+}
+
+// CanCreate checks if a user can create the resource
+func (p *UserPermissions) CanCreate(apiGroup, resource string) bool {
+	return p.HasPermission(apiGroup, resource, "create")
+}
+
+// CanUpdate checks if a user can update the resource
+func (p *UserPermissions) CanUpdate(apiGroup, resource string) bool {
+	return p.HasPermission(apiGroup, resource, "update")
+}
+
+// CanDelete checks if a user can delete the resource
+func (p *UserPermissions) CanDelete(apiGroup, resource string) bool {
+	return p.HasPermission(apiGroup, resource, "delete")
+}
+
 // FilterResources filters a list of resources based on user permissions
 func (p *UserPermissions) FilterResources(apiGroup, resourceType string, resources []interface{}) []interface{} {
 	if !p.HasPermission(apiGroup, resourceType, "list") {
@@ -0,0 +1,85 @@
+package kubernetes
+
+import (
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasPermissionWithRules(t *testing.T) {
+	permissions := fakeUserPermissions(t)
+
+	allowed, rules := permissions.HasPermissionWithRules("core", "pods", "list")
+	assert.True(t, allowed)
+	assert.Equal(t, []ContributingRule{{
+		ClusterRoleBinding: "alice-viewer",
+		ClusterRole:        "viewer",
+		Rule:               rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list", "watch"}},
+	}}, rules)
+
+	allowed, rules = permissions.HasPermissionWithRules("apps", "deployments", "get")
+	assert.True(t, allowed)
+	if assert.Len(t, rules, 1) {
+		assert.Equal(t, []string{"deployments"}, rules[0].Rule.Resources)
+	}
+
+	allowed, rules = permissions.HasPermissionWithRules("apps", "deployments", "delete")
+	assert.False(t, allowed)
+	assert.Nil(t, rules)
+}
+
+func TestHasPermissionWithRulesChecksTheAPIGroupOfWildcards(t *testing.T) {
+	appsAdmin := ContributingRule{
+		ClusterRoleBinding: "alice-apps-admin",
+		ClusterRole:        "apps-admin",
+		Rule:               rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+	}
+	permissions := &UserPermissions{
+		Resources: map[string][]string{"*": {"*"}},
+		APIGroups: map[string][]string{"apps": {"*"}},
+		Rules:     []ContributingRule{appsAdmin},
+	}
+
+	allowed, rules := permissions.HasPermissionWithRules("apps", "deployments", "delete")
+	assert.True(t, allowed)
+	assert.Equal(t, []ContributingRule{appsAdmin}, rules)
+
+	// The aggregated wildcard allows the secrets of the core group, no rule does
+	assert.True(t, permissions.HasPermission("core", "secrets", "get"))
+	allowed, rules = permissions.HasPermissionWithRules("core", "secrets", "get")
+	assert.False(t, allowed)
+	assert.Empty(t, rules)
+}
+
+func TestUserPermissionsPerVerbHelpers(t *testing.T) {
+	permissions := fakeUserPermissions(t)
+
+	assert.True(t, permissions.CanGet("", "pods"))
+	assert.True(t, permissions.CanList("core", "pods"))
+	assert.True(t, permissions.CanWatch("", "pods"))
+	assert.False(t, permissions.CanCreate("", "pods"))
+	assert.False(t, permissions.CanUpdate("", "pods"))
+	assert.False(t, permissions.CanDelete("", "pods"))
+	assert.True(t, permissions.CanGet("apps", "deployments"))
+	assert.False(t, permissions.CanList("apps", "deployments"))
+}
+
+func TestHasAllAndAnyPermissions(t *testing.T) {
+	permissions := fakeUserPermissions(t)
+
+	assert.True(t, permissions.HasAllPermissions("core", "pods", []string{"get", "list", "watch"}))
+	assert.False(t, permissions.HasAllPermissions("", "pods", []string{"get", "update"}))
+	// An empty list of verbs does not open the gate
+	assert.False(t, permissions.HasAllPermissions("", "pods", nil))
+	assert.False(t, permissions.HasAnyPermission("", "pods", []string{}))
+	assert.True(t, permissions.HasAnyPermission("", "pods", []string{"get", "update"}))
+	assert.False(t, permissions.HasAnyPermission("apps", "deployments", []string{"update", "delete"}))
+	// The resource is only allowed in its API group
+	assert.False(t, permissions.HasAnyPermission("apps", "pods", []string{"get"}))
+
+	// Like for a single verb, a wildcard verb allows them all
+	permissions.Resources["*"] = []string{"*"}
+	assert.True(t, permissions.HasAllPermissions("apps", "deployments", []string{"update", "delete"}))
+}
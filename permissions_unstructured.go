@@ -0,0 +1,47 @@
+package kubernetes
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// FilterUnstructured filters objects returned by the dynamic client when their GroupVersionResource
+// is already known, e.g. the one used for the List call.
+func (p *UserPermissions) FilterUnstructured(gvr schema.GroupVersionResource, items []unstructured.Unstructured) []unstructured.Unstructured {
+	if !p.HasPermission(gvr.Group, gvr.Resource, "list") {
+		return []unstructured.Unstructured{}
+	}
+
+	filtered := make([]unstructured.Unstructured, 0, len(items))
+	filtered = append(filtered, items...)
+	return filtered
+}
+
+// FilterUnstructuredList filters a list of arbitrary objects, possibly of different kinds, resolving the
+// resource of each object from its apiVersion and kind with the given RESTMapper. This allows generic
+// controllers to filter any custom resource without knowing its GroupVersionResource upfront.
+func (p *UserPermissions) FilterUnstructuredList(mapper meta.RESTMapper, list *unstructured.UnstructuredList) ([]unstructured.Unstructured, error) {
+	allowed := map[schema.GroupVersionKind]bool{}
+	filtered := make([]unstructured.Unstructured, 0, len(list.Items))
+
+	for _, item := range list.Items {
+		gvk := item.GroupVersionKind()
+		isAllowed, checked := allowed[gvk]
+		if !checked {
+			mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve resource of kind %s: %w", gvk.String(), err)
+			}
+			isAllowed = p.HasPermission(mapping.Resource.Group, mapping.Resource.Resource, "list")
+			allowed[gvk] = isAllowed
+		}
+		if isAllowed {
+			filtered = append(filtered, item)
+		}
+	}
+
+	return filtered, nil
+}
@@ -0,0 +1,76 @@
+package kubernetes
+
+import (
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUserPermissions returns the permissions of alice, who reads the pods and gets the deployments.
+func fakeUserPermissions(t *testing.T) *UserPermissions {
+	t.Helper()
+	k8s := fake.NewSimpleClientset(
+		&rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "viewer"},
+			Rules: []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list", "watch"}},
+				{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"get"}},
+			},
+		},
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "alice-viewer"},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "viewer"},
+			Subjects:   []rbacv1.Subject{{Kind: "User", Name: "alice"}},
+		},
+	)
+	permissions, err := GetUserPermissions(k8s, "alice")
+	require.NoError(t, err)
+	return permissions
+}
+
+func unstructuredObject(apiVersion, kind, name string) unstructured.Unstructured {
+	obj := unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetName(name)
+	return obj
+}
+
+func TestFilterUnstructured(t *testing.T) {
+	permissions := fakeUserPermissions(t)
+	items := []unstructured.Unstructured{unstructuredObject("v1", "Pod", "web"), unstructuredObject("v1", "Pod", "db")}
+
+	assert.Equal(t, items, permissions.FilterUnstructured(schema.GroupVersionResource{Version: "v1", Resource: "pods"}, items))
+	assert.Empty(t, permissions.FilterUnstructured(schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, items))
+}
+
+func TestFilterUnstructuredList(t *testing.T) {
+	permissions := fakeUserPermissions(t)
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+
+	list := &unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+		unstructuredObject("v1", "Pod", "web"),
+		unstructuredObject("apps/v1", "Deployment", "web"),
+		unstructuredObject("v1", "Pod", "db"),
+	}}
+	filtered, err := permissions.FilterUnstructuredList(mapper, list)
+	require.NoError(t, err)
+	require.Len(t, filtered, 2)
+	assert.Equal(t, "Pod", filtered[0].GetKind())
+	assert.Equal(t, "db", filtered[1].GetName())
+
+	// Objects of unknown kinds cannot be filtered
+	list.Items = append(list.Items, unstructuredObject("example.com/v1", "Widget", "w"))
+	_, err = permissions.FilterUnstructuredList(mapper, list)
+	assert.Error(t, err)
+}
@@ -0,0 +1,79 @@
+package permissionstest
+
+import (
+	"context"
+	"fmt"
+
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kube "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/kiali/kiali/business"
+)
+
+// CheckConformance verifies that the local RBAC evaluation matches the live SelfSubjectAccessReview results
+// of the apiserver, which is the correctness oracle, for every case. The reviews are made impersonating the
+// users of the cases, so restConfig must have the privileges to impersonate them. It returns the mismatches.
+// It is meant to be run against a disposable cluster (envtest or kind) where only the RBAC authorizer is
+// enabled, since other authorizers (e.g. webhooks) are invisible to the local evaluation.
+func CheckConformance(ctx context.Context, restConfig *rest.Config, client business.PermissionsClient, cases []business.ConformanceCase) ([]business.ConformanceMismatch, error) {
+	snapshot, err := business.LoadRBACSnapshot(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	impersonator := business.NewImpersonator(restConfig)
+	mismatches := []business.ConformanceMismatch{}
+	for _, c := range cases {
+		live, err := impersonator.Check(ctx, c.User, c.Request)
+		if err != nil {
+			return mismatches, err
+		}
+		explanation := snapshot.Explain(c.User, c.Request)
+		if explanation.Allowed != live.Allowed {
+			mismatches = append(mismatches, business.ConformanceMismatch{Case: c, Local: explanation.Allowed, Live: live.Allowed, Explanation: explanation})
+		}
+	}
+	return mismatches, nil
+}
+
+// ApplyRBACObjects creates the objects, or updates them if they already exist, e.g. so a conformance run
+// can be repeated on the same cluster. Only Namespaces, ClusterRoles, Roles and their bindings are
+// supported; existing Namespaces are left as they are.
+func ApplyRBACObjects(ctx context.Context, k8s kube.Interface, objects []runtime.Object) error {
+	for _, obj := range objects {
+		var err error
+		switch o := obj.(type) {
+		case *core_v1.Namespace:
+			if _, err = k8s.CoreV1().Namespaces().Create(ctx, o, meta_v1.CreateOptions{}); errors.IsAlreadyExists(err) {
+				err = nil
+			}
+		case *rbac_v1.ClusterRole:
+			if _, err = k8s.RbacV1().ClusterRoles().Create(ctx, o, meta_v1.CreateOptions{}); errors.IsAlreadyExists(err) {
+				_, err = k8s.RbacV1().ClusterRoles().Update(ctx, o, meta_v1.UpdateOptions{})
+			}
+		case *rbac_v1.Role:
+			if _, err = k8s.RbacV1().Roles(o.Namespace).Create(ctx, o, meta_v1.CreateOptions{}); errors.IsAlreadyExists(err) {
+				_, err = k8s.RbacV1().Roles(o.Namespace).Update(ctx, o, meta_v1.UpdateOptions{})
+			}
+		case *rbac_v1.ClusterRoleBinding:
+			if _, err = k8s.RbacV1().ClusterRoleBindings().Create(ctx, o, meta_v1.CreateOptions{}); errors.IsAlreadyExists(err) {
+				_, err = k8s.RbacV1().ClusterRoleBindings().Update(ctx, o, meta_v1.UpdateOptions{})
+			}
+		case *rbac_v1.RoleBinding:
+			if _, err = k8s.RbacV1().RoleBindings(o.Namespace).Create(ctx, o, meta_v1.CreateOptions{}); errors.IsAlreadyExists(err) {
+				_, err = k8s.RbacV1().RoleBindings(o.Namespace).Update(ctx, o, meta_v1.UpdateOptions{})
+			}
+		default:
+			return fmt.Errorf("unsupported object %T", obj)
+		}
+		if err != nil {
+			return fmt.Errorf("error applying object: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+//go:build integration
+
+package permissionstest
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kube "k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiali/kiali/business"
+)
+
+// conformanceNamespaces are the namespaces used by the conformance fixtures.
+var conformanceNamespaces = []string{"conformance-ns1", "conformance-ns2"}
+
+// TestConformance starts an envtest control plane, applies the conformance RBAC fixtures and compares
+// the local evaluation with the apiserver for the whole case matrix. Set PERMISSIONS_USE_EXISTING_CLUSTER=true
+// to run against the cluster of the current kubeconfig instead, e.g. a kind cluster in CI.
+// Run with the "integration" tag; envtest needs the KUBEBUILDER_ASSETS binaries.
+func TestConformance(t *testing.T) {
+	useExisting := strings.EqualFold(os.Getenv("PERMISSIONS_USE_EXISTING_CLUSTER"), "true")
+	env := &envtest.Environment{UseExistingCluster: &useExisting}
+	restConfig, err := env.Start()
+	require.NoError(t, err, "error starting the test control plane")
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Logf("error stopping the test control plane: %v", err)
+		}
+	})
+
+	k8s, err := kube.NewForConfig(restConfig)
+	require.NoError(t, err)
+	require.NoError(t, ApplyRBACObjects(context.Background(), k8s, conformanceFixtures()))
+
+	mismatches, err := CheckConformance(context.Background(), restConfig, business.NewPermissionsClient(k8s), conformanceCases())
+	require.NoError(t, err)
+	for _, mismatch := range mismatches {
+		t.Error(mismatch)
+	}
+}
+
+// conformanceRoleObjects returns a Role and a RoleBinding granting the rules to the subjects in the
+// namespace, or a ClusterRole and a ClusterRoleBinding if the namespace is empty.
+func conformanceRoleObjects(name, namespace string, rules []rbac_v1.PolicyRule, subjects []rbac_v1.Subject) []runtime.Object {
+	if namespace == "" {
+		return []runtime.Object{
+			&rbac_v1.ClusterRole{ObjectMeta: meta_v1.ObjectMeta{Name: name}, Rules: rules},
+			&rbac_v1.ClusterRoleBinding{
+				ObjectMeta: meta_v1.ObjectMeta{Name: name},
+				RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: name},
+				Subjects:   subjects,
+			},
+		}
+	}
+	return []runtime.Object{
+		&rbac_v1.Role{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: namespace}, Rules: rules},
+		&rbac_v1.RoleBinding{
+			ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: namespace},
+			RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "Role", Name: name},
+			Subjects:   subjects,
+		},
+	}
+}
+
+// conformanceFixtures returns the namespaces and the RBAC objects exercising the matching rules of the
+// evaluator: wildcards, subresources, resource names, group and ServiceAccount subjects, and ClusterRoles
+// bound in a namespace.
+func conformanceFixtures() []runtime.Object {
+	ns1, ns2 := conformanceNamespaces[0], conformanceNamespaces[1]
+	alice := rbac_v1.Subject{Kind: rbac_v1.UserKind, APIGroup: rbac_v1.GroupName, Name: "conformance-alice"}
+	bob := rbac_v1.Subject{Kind: rbac_v1.UserKind, APIGroup: rbac_v1.GroupName, Name: "conformance-bob"}
+	developers := rbac_v1.Subject{Kind: rbac_v1.GroupKind, APIGroup: rbac_v1.GroupName, Name: "conformance-developers"}
+	robot := rbac_v1.Subject{Kind: rbac_v1.ServiceAccountKind, Namespace: ns1, Name: "robot"}
+
+	fixtures := []runtime.Object{}
+	for _, ns := range conformanceNamespaces {
+		fixtures = append(fixtures, &core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: ns}})
+	}
+	fixtures = append(fixtures, conformanceRoleObjects("conformance-pod-reader", ns1, []rbac_v1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"pods", "pods/log"}, Verbs: []string{"get", "list"}},
+	}, []rbac_v1.Subject{alice})...)
+	fixtures = append(fixtures, conformanceRoleObjects("conformance-configmap-editor", ns2, []rbac_v1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{"settings"}, Verbs: []string{"get", "update"}},
+	}, []rbac_v1.Subject{bob})...)
+	fixtures = append(fixtures, conformanceRoleObjects("conformance-deployer", "", []rbac_v1.PolicyRule{
+		{APIGroups: []string{"apps"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+		{APIGroups: []string{"*"}, Resources: []string{"*/scale"}, Verbs: []string{"update"}},
+	}, []rbac_v1.Subject{developers})...)
+	fixtures = append(fixtures, conformanceRoleObjects("conformance-secret-reader", "", []rbac_v1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}},
+	}, nil)...)
+	// The ClusterRole above is bound in a single namespace only
+	fixtures = append(fixtures, &rbac_v1.RoleBinding{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "conformance-secret-reader", Namespace: ns1},
+		RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: "conformance-secret-reader"},
+		Subjects:   []rbac_v1.Subject{robot},
+	})
+	return fixtures
+}
+
+// conformanceCases returns the matrix of checks run against conformanceFixtures, mixing allowed and
+// denied requests for every kind of rule.
+func conformanceCases() []business.ConformanceCase {
+	ns1 := conformanceNamespaces[0]
+	alice := business.UserInfo{Name: "conformance-alice", Groups: []string{"system:authenticated"}}
+	bob := business.UserInfo{Name: "conformance-bob", Groups: []string{"system:authenticated"}}
+	carol := business.UserInfo{Name: "conformance-carol", Groups: []string{"system:authenticated", "conformance-developers"}}
+	robot := business.UserInfo{Name: "system:serviceaccount:" + ns1 + ":robot", Groups: []string{"system:authenticated", "system:serviceaccounts", "system:serviceaccounts:" + ns1}}
+
+	cases := []business.ConformanceCase{}
+	for _, ns := range conformanceNamespaces {
+		cases = append(cases,
+			business.ConformanceCase{User: alice, Request: business.AccessRequest{Namespace: ns, Resource: "pods", Verb: "get"}},
+			business.ConformanceCase{User: alice, Request: business.AccessRequest{Namespace: ns, Resource: "pods", Verb: "delete"}},
+			business.ConformanceCase{User: alice, Request: business.AccessRequest{Namespace: ns, Resource: "pods", Subresource: "log", Verb: "get"}},
+			business.ConformanceCase{User: alice, Request: business.AccessRequest{Namespace: ns, Resource: "pods", Subresource: "exec", Verb: "create"}},
+			business.ConformanceCase{User: bob, Request: business.AccessRequest{Namespace: ns, Resource: "configmaps", Name: "settings", Verb: "update"}},
+			business.ConformanceCase{User: bob, Request: business.AccessRequest{Namespace: ns, Resource: "configmaps", Name: "other", Verb: "update"}},
+			business.ConformanceCase{User: bob, Request: business.AccessRequest{Namespace: ns, Resource: "configmaps", Verb: "list"}},
+			business.ConformanceCase{User: carol, Request: business.AccessRequest{Namespace: ns, APIGroup: "apps", Resource: "deployments", Verb: "delete"}},
+			business.ConformanceCase{User: carol, Request: business.AccessRequest{Namespace: ns, APIGroup: "apps", Resource: "deployments", Subresource: "scale", Verb: "update"}},
+			business.ConformanceCase{User: carol, Request: business.AccessRequest{Namespace: ns, APIGroup: "batch", Resource: "jobs", Verb: "create"}},
+			business.ConformanceCase{User: robot, Request: business.AccessRequest{Namespace: ns, Resource: "secrets", Verb: "get"}},
+			business.ConformanceCase{User: robot, Request: business.AccessRequest{Namespace: ns, Resource: "secrets", Verb: "list"}},
+		)
+	}
+	cases = append(cases,
+		business.ConformanceCase{User: carol, Request: business.AccessRequest{APIGroup: "apps", Resource: "deployments", Verb: "list"}},
+		business.ConformanceCase{User: robot, Request: business.AccessRequest{Resource: "secrets", Verb: "get"}},
+	)
+	return cases
+}
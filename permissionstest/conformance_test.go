@@ -0,0 +1,68 @@
+package permissionstest
+
+import (
+	"context"
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kube_fake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiali/kiali/business"
+)
+
+// podReaderObjects let alice read the pods of ns1.
+func podReaderObjects() []runtime.Object {
+	return []runtime.Object{
+		&rbac_v1.Role{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns1", Name: "pod-reader"},
+			Rules:      []rbac_v1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}},
+		},
+		&rbac_v1.RoleBinding{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns1", Name: "alice-pods"},
+			RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "Role", Name: "pod-reader"},
+			Subjects:   []rbac_v1.Subject{{Kind: rbac_v1.UserKind, APIGroup: rbac_v1.GroupName, Name: "alice"}},
+		},
+	}
+}
+
+func TestCheckConformanceReportsTheMismatches(t *testing.T) {
+	// The apiserver lets alice read the pods of every namespace, RBAC only the ones of ns1
+	fake := MustNewFakePermissionsClient("allow alice get pods", podReaderObjects()...)
+	alice := business.UserInfo{Name: "alice"}
+	cases := []business.ConformanceCase{
+		{User: alice, Request: business.AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}},
+		{User: alice, Request: business.AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "get"}},
+		{User: alice, Request: business.AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"}},
+	}
+
+	mismatches, err := CheckConformance(context.Background(), fake.ServeAPI(t), fake, cases)
+	require.NoError(t, err)
+	require.Len(t, mismatches, 1)
+	assert.Equal(t, cases[1], mismatches[0].Case)
+	assert.False(t, mismatches[0].Local)
+	assert.True(t, mismatches[0].Live)
+	assert.Contains(t, mismatches[0].String(), "namespace [ns2]")
+	assert.Len(t, fake.Reviews(), 3)
+}
+
+func TestApplyRBACObjects(t *testing.T) {
+	k8s := kube_fake.NewSimpleClientset()
+	objects := append([]runtime.Object{&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "ns1"}}}, podReaderObjects()...)
+	require.NoError(t, ApplyRBACObjects(context.Background(), k8s, objects))
+
+	// Applying again updates the objects
+	role := objects[1].(*rbac_v1.Role).DeepCopy()
+	role.Rules[0].Verbs = []string{"get", "list"}
+	require.NoError(t, ApplyRBACObjects(context.Background(), k8s, append(objects, role)))
+	applied, err := k8s.RbacV1().Roles("ns1").Get(context.Background(), "pod-reader", meta_v1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"get", "list"}, applied.Rules[0].Verbs)
+
+	assert.ErrorContains(t, ApplyRBACObjects(context.Background(), k8s, []runtime.Object{&core_v1.Pod{}}), "unsupported object *v1.Pod")
+}
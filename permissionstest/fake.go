@@ -0,0 +1,285 @@
+// Package permissionstest provides a programmable fake of the permission client, so services built on the
+// permission helpers can unit-test their authorization logic without a cluster.
+//
+// Access reviews are answered from a scenario of statements, one per line:
+//
+//	allow alice get pods in ns1
+//	allow group:developers * deployments.apps
+//	deny bob delete pods/log named web-0 in ns2
+//	allow system:serviceaccount:ns1:robot list secrets
+//
+// Each statement is "allow" or "deny", a subject, a verb, a resource and optionally "named <name>" and
+// "in <namespace>", and "fields <selector>" or "labels <selector>" to only match the requests scoped by
+// exactly that field or label selector. The subject is a user name, "group:<name>" or "*" for everybody. The resource is
+// "<resource>[.<apiGroup>][/<subresource>]". The verb, resource, API group and namespace can be "*", e.g.
+// "*.*" for every resource of every API group, while "*" alone is every resource of the core group. Without
+// "in" the statement applies to all namespaces, and without "named" to all the objects. The first matching
+// statement decides; requests matching no statement are denied.
+//
+// The fake can also be served over HTTP, like the apiserver, see FakePermissionsClient.ServeAPI. The
+// outputs of the reports can be compared with golden files, see AssertGoldenOutput.
+package permissionstest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/kiali/kiali/business"
+)
+
+// Statement is a parsed scenario line.
+type Statement struct {
+	Allow         bool
+	Subject       string
+	Verb          string
+	APIGroup      string
+	Resource      string
+	Subresource   string
+	Name          string
+	Namespace     string
+	FieldSelector string
+	LabelSelector string
+}
+
+// matches returns true if the statement applies to the user and the request.
+func (s Statement) matches(user business.UserInfo, req business.AccessRequest) bool {
+	switch {
+	case s.Subject == "*":
+	case strings.HasPrefix(s.Subject, "group:"):
+		found := false
+		for _, group := range user.Groups {
+			found = found || group == strings.TrimPrefix(s.Subject, "group:")
+		}
+		if !found {
+			return false
+		}
+	case s.Subject != user.Name:
+		return false
+	}
+	return wildcardMatch(s.Verb, req.Verb) &&
+		wildcardMatch(s.Resource, req.Resource) &&
+		wildcardMatch(s.APIGroup, req.APIGroup) &&
+		(s.Subresource == req.Subresource || s.Subresource == "*") &&
+		(s.Name == "" || s.Name == req.Name) &&
+		(s.Namespace == "" || wildcardMatch(s.Namespace, req.Namespace)) &&
+		(s.FieldSelector == "" || s.FieldSelector == req.FieldSelector) &&
+		(s.LabelSelector == "" || s.LabelSelector == req.LabelSelector)
+}
+
+func wildcardMatch(pattern, value string) bool {
+	return pattern == "*" || pattern == value
+}
+
+// ParseScenario parses the statements of a scenario. Empty lines and lines starting with # are ignored.
+func ParseScenario(scenario string) ([]Statement, error) {
+	statements := []Statement{}
+	scanner := bufio.NewScanner(strings.NewReader(scenario))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		statement, err := parseStatement(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		statements = append(statements, statement)
+	}
+	return statements, scanner.Err()
+}
+
+func parseStatement(line string) (Statement, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return Statement{}, fmt.Errorf("expected \"allow|deny <subject> <verb> <resource>\", got %q", line)
+	}
+
+	statement := Statement{Subject: fields[1], Verb: fields[2]}
+	switch fields[0] {
+	case "allow":
+		statement.Allow = true
+	case "deny":
+	default:
+		return Statement{}, fmt.Errorf("expected allow or deny, got %q", fields[0])
+	}
+
+	resource := fields[3]
+	if i := strings.Index(resource, "/"); i >= 0 {
+		resource, statement.Subresource = resource[:i], resource[i+1:]
+	}
+	if i := strings.Index(resource, "."); i >= 0 {
+		resource, statement.APIGroup = resource[:i], resource[i+1:]
+	}
+	statement.Resource = resource
+
+	rest := fields[4:]
+	for len(rest) > 0 {
+		if len(rest) < 2 {
+			return Statement{}, fmt.Errorf("missing value after %q", rest[0])
+		}
+		switch rest[0] {
+		case "named":
+			statement.Name = rest[1]
+		case "in":
+			statement.Namespace = rest[1]
+		case "fields":
+			statement.FieldSelector = rest[1]
+		case "labels":
+			statement.LabelSelector = rest[1]
+		default:
+			return Statement{}, fmt.Errorf("unexpected %q, expected named, in, fields or labels", rest[0])
+		}
+		rest = rest[2:]
+	}
+	return statement, nil
+}
+
+// FakePermissionsClient is a business.PermissionsClient answering access reviews from a scenario. The RBAC
+// objects passed to NewFakePermissionsClient are served by a client-go fake clientset, for the helpers
+// evaluating RBAC locally. It is safe for concurrent use.
+type FakePermissionsClient struct {
+	business.PermissionsClient
+
+	mu         sync.Mutex
+	self       business.UserInfo
+	statements []Statement
+	reviews    []business.AccessRequest
+}
+
+// NewFakePermissionsClient creates a fake answering reviews from the scenario, serving the given RBAC objects.
+// The identity of the client, used by self reviews, is set with SetSelf.
+func NewFakePermissionsClient(scenario string, objects ...runtime.Object) (*FakePermissionsClient, error) {
+	statements, err := ParseScenario(scenario)
+	if err != nil {
+		return nil, err
+	}
+	return &FakePermissionsClient{
+		PermissionsClient: business.NewPermissionsClient(fake.NewSimpleClientset(objects...)),
+		statements:        statements,
+	}, nil
+}
+
+// MustNewFakePermissionsClient is like NewFakePermissionsClient but panics on an invalid scenario.
+func MustNewFakePermissionsClient(scenario string, objects ...runtime.Object) *FakePermissionsClient {
+	client, err := NewFakePermissionsClient(scenario, objects...)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// SetSelf sets the identity of the client, answered about by the self reviews.
+func (in *FakePermissionsClient) SetSelf(user business.UserInfo) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.self = user
+}
+
+// AddScenario appends statements to the scenario. They have lower precedence than the existing ones.
+func (in *FakePermissionsClient) AddScenario(scenario string) error {
+	statements, err := ParseScenario(scenario)
+	if err != nil {
+		return err
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.statements = append(in.statements, statements...)
+	return nil
+}
+
+// Reviews returns the requests reviewed so far, in order, e.g. to assert on caching.
+func (in *FakePermissionsClient) Reviews() []business.AccessRequest {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return append([]business.AccessRequest{}, in.reviews...)
+}
+
+// decide evaluates the scenario for the user and the request, and records the review.
+func (in *FakePermissionsClient) decide(user business.UserInfo, req business.AccessRequest) (bool, string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.reviews = append(in.reviews, req)
+	for _, statement := range in.statements {
+		if statement.matches(user, req) {
+			if statement.Allow {
+				return true, "allowed by the test scenario"
+			}
+			return false, "denied by the test scenario"
+		}
+	}
+	return false, "no statement of the test scenario matches"
+}
+
+func (in *FakePermissionsClient) GetSelfSubjectAccessReview(ctx context.Context, namespace, api, resourceType string, verbs []string) ([]*auth_v1.SelfSubjectAccessReview, error) {
+	in.mu.Lock()
+	self := in.self
+	in.mu.Unlock()
+
+	reviews := make([]*auth_v1.SelfSubjectAccessReview, 0, len(verbs))
+	for _, verb := range verbs {
+		attrs := &auth_v1.ResourceAttributes{Namespace: namespace, Verb: verb, Group: api, Resource: resourceType}
+		allowed, reason := in.decide(self, accessRequestFor(attrs))
+		reviews = append(reviews, &auth_v1.SelfSubjectAccessReview{
+			Spec:   auth_v1.SelfSubjectAccessReviewSpec{ResourceAttributes: attrs},
+			Status: auth_v1.SubjectAccessReviewStatus{Allowed: allowed, Denied: !allowed, Reason: reason},
+		})
+	}
+	return reviews, nil
+}
+
+func (in *FakePermissionsClient) CreateSelfSubjectAccessReview(ctx context.Context, ssar *auth_v1.SelfSubjectAccessReview) (*auth_v1.SelfSubjectAccessReview, error) {
+	if ssar.Spec.ResourceAttributes == nil {
+		return nil, fmt.Errorf("the fake only reviews resource attributes")
+	}
+	in.mu.Lock()
+	self := in.self
+	in.mu.Unlock()
+	allowed, reason := in.decide(self, accessRequestFor(ssar.Spec.ResourceAttributes))
+
+	review := ssar.DeepCopy()
+	review.Status = auth_v1.SubjectAccessReviewStatus{Allowed: allowed, Denied: !allowed, Reason: reason}
+	return review, nil
+}
+
+func (in *FakePermissionsClient) CreateSubjectAccessReview(ctx context.Context, sar *auth_v1.SubjectAccessReview) (*auth_v1.SubjectAccessReview, error) {
+	if sar.Spec.ResourceAttributes == nil {
+		return nil, fmt.Errorf("the fake only reviews resource attributes")
+	}
+	user := business.UserInfo{Name: sar.Spec.User, Groups: sar.Spec.Groups}
+	if len(sar.Spec.Extra) > 0 {
+		user.Extra = make(map[string][]string, len(sar.Spec.Extra))
+		for k, v := range sar.Spec.Extra {
+			user.Extra[k] = v
+		}
+	}
+	allowed, reason := in.decide(user, accessRequestFor(sar.Spec.ResourceAttributes))
+
+	review := sar.DeepCopy()
+	review.Status = auth_v1.SubjectAccessReviewStatus{Allowed: allowed, Denied: !allowed, Reason: reason}
+	return review, nil
+}
+
+func accessRequestFor(attrs *auth_v1.ResourceAttributes) business.AccessRequest {
+	req := business.AccessRequest{
+		Namespace:   attrs.Namespace,
+		APIGroup:    attrs.Group,
+		Resource:    attrs.Resource,
+		Subresource: attrs.Subresource,
+		Name:        attrs.Name,
+		Verb:        attrs.Verb,
+	}
+	if attrs.FieldSelector != nil {
+		req.FieldSelector = attrs.FieldSelector.RawSelector
+	}
+	if attrs.LabelSelector != nil {
+		req.LabelSelector = attrs.LabelSelector.RawSelector
+	}
+	return req
+}
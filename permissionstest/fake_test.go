@@ -0,0 +1,155 @@
+package permissionstest
+
+import (
+	"context"
+	"testing"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiali/kiali/business"
+)
+
+const testScenario = `
+# alice reads the pods of ns1
+allow alice get pods in ns1
+allow group:developers * deployments.apps
+deny bob delete pods/log named web-0 in ns2
+allow * list nodes fields spec.nodeName=node1
+allow carol get *
+allow dave get *.*
+`
+
+func TestParseScenario(t *testing.T) {
+	statements, err := ParseScenario(testScenario)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Statement{
+		{Allow: true, Subject: "alice", Verb: "get", Resource: "pods", Namespace: "ns1"},
+		{Allow: true, Subject: "group:developers", Verb: "*", APIGroup: "apps", Resource: "deployments"},
+		{Subject: "bob", Verb: "delete", Resource: "pods", Subresource: "log", Name: "web-0", Namespace: "ns2"},
+		{Allow: true, Subject: "*", Verb: "list", Resource: "nodes", FieldSelector: "spec.nodeName=node1"},
+		{Allow: true, Subject: "carol", Verb: "get", Resource: "*"},
+		{Allow: true, Subject: "dave", Verb: "get", APIGroup: "*", Resource: "*"},
+	}, statements)
+}
+
+func TestParseScenarioErrors(t *testing.T) {
+	for scenario, message := range map[string]string{
+		"allow alice get":                   "line 1: expected",
+		"\npermit alice get pods":           "line 2: expected allow or deny",
+		"allow alice get pods in":           "missing value after \"in\"",
+		"allow alice get pods within ns1":   "unexpected \"within\"",
+		"allow alice get pods named":        "missing value after \"named\"",
+		"allow alice get pods labels a=b x": "missing value after \"x\"",
+	} {
+		_, err := ParseScenario(scenario)
+		assert.ErrorContains(t, err, message, scenario)
+	}
+}
+
+func TestStatementMatches(t *testing.T) {
+	statements, err := ParseScenario(testScenario)
+	require.NoError(t, err)
+	alice := business.UserInfo{Name: "alice"}
+	developer := business.UserInfo{Name: "carol", Groups: []string{"developers"}}
+	bob := business.UserInfo{Name: "bob"}
+
+	cases := []struct {
+		statement Statement
+		user      business.UserInfo
+		req       business.AccessRequest
+		matches   bool
+	}{
+		{statements[0], alice, business.AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}, true},
+		{statements[0], alice, business.AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "get"}, false},
+		{statements[0], alice, business.AccessRequest{Namespace: "ns1", Resource: "pods", Subresource: "log", Verb: "get"}, false},
+		{statements[0], bob, business.AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"}, false},
+		{statements[1], developer, business.AccessRequest{Namespace: "ns3", APIGroup: "apps", Resource: "deployments", Verb: "patch"}, true},
+		{statements[1], developer, business.AccessRequest{Namespace: "ns3", Resource: "deployments", Verb: "patch"}, false},
+		{statements[1], alice, business.AccessRequest{Namespace: "ns3", APIGroup: "apps", Resource: "deployments", Verb: "patch"}, false},
+		{statements[2], bob, business.AccessRequest{Namespace: "ns2", Resource: "pods", Subresource: "log", Name: "web-0", Verb: "delete"}, true},
+		{statements[2], bob, business.AccessRequest{Namespace: "ns2", Resource: "pods", Subresource: "log", Name: "web-1", Verb: "delete"}, false},
+		{statements[3], bob, business.AccessRequest{Resource: "nodes", Verb: "list", FieldSelector: "spec.nodeName=node1"}, true},
+		{statements[3], bob, business.AccessRequest{Resource: "nodes", Verb: "list"}, false},
+		// The wildcard resources are of the API group of the statement
+		{statements[4], business.UserInfo{Name: "carol"}, business.AccessRequest{Namespace: "ns1", Resource: "secrets", Verb: "get"}, true},
+		{statements[4], business.UserInfo{Name: "carol"}, business.AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "get"}, false},
+		{statements[5], business.UserInfo{Name: "dave"}, business.AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "get"}, true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.matches, c.statement.matches(c.user, c.req), "%+v %+v %+v", c.statement, c.user, c.req)
+	}
+}
+
+func TestFakePermissionsClientReviews(t *testing.T) {
+	client := MustNewFakePermissionsClient(testScenario)
+	ctx := context.Background()
+
+	review, err := client.CreateSubjectAccessReview(ctx, &auth_v1.SubjectAccessReview{Spec: auth_v1.SubjectAccessReviewSpec{
+		User:               "carol",
+		Groups:             []string{"developers"},
+		ResourceAttributes: &auth_v1.ResourceAttributes{Namespace: "ns1", Group: "apps", Resource: "deployments", Verb: "update"},
+	}})
+	require.NoError(t, err)
+	assert.True(t, review.Status.Allowed)
+	assert.Equal(t, "allowed by the test scenario", review.Status.Reason)
+
+	// Requests matching no statement are denied
+	review, err = client.CreateSubjectAccessReview(ctx, &auth_v1.SubjectAccessReview{Spec: auth_v1.SubjectAccessReviewSpec{
+		User:               "alice",
+		ResourceAttributes: &auth_v1.ResourceAttributes{Namespace: "ns1", Resource: "secrets", Verb: "get"},
+	}})
+	require.NoError(t, err)
+	assert.False(t, review.Status.Allowed)
+	assert.True(t, review.Status.Denied)
+
+	_, err = client.CreateSubjectAccessReview(ctx, &auth_v1.SubjectAccessReview{Spec: auth_v1.SubjectAccessReviewSpec{User: "alice"}})
+	assert.Error(t, err)
+
+	assert.Equal(t, []business.AccessRequest{
+		{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "update"},
+		{Namespace: "ns1", Resource: "secrets", Verb: "get"},
+	}, client.Reviews())
+}
+
+func TestFakePermissionsClientSelfReviews(t *testing.T) {
+	client := MustNewFakePermissionsClient(testScenario)
+	client.SetSelf(business.UserInfo{Name: "alice"})
+
+	reviews, err := client.GetSelfSubjectAccessReview(context.Background(), "ns1", "", "pods", []string{"get", "list"})
+	require.NoError(t, err)
+	require.Len(t, reviews, 2)
+	assert.True(t, reviews[0].Status.Allowed)
+	assert.False(t, reviews[1].Status.Allowed)
+
+	review, err := client.CreateSelfSubjectAccessReview(context.Background(), &auth_v1.SelfSubjectAccessReview{Spec: auth_v1.SelfSubjectAccessReviewSpec{
+		ResourceAttributes: &auth_v1.ResourceAttributes{Namespace: "ns1", Resource: "pods", Verb: "get"},
+	}})
+	require.NoError(t, err)
+	assert.True(t, review.Status.Allowed)
+}
+
+func TestFakePermissionsClientAddScenario(t *testing.T) {
+	client := MustNewFakePermissionsClient("deny alice get pods in ns2")
+	require.NoError(t, client.AddScenario("allow alice get pods"))
+	assert.Error(t, client.AddScenario("allow alice"))
+	checker := business.NewPermissionChecker(client)
+	alice := business.UserInfo{Name: "alice"}
+
+	// The statements added have lower precedence
+	allowed, err := checker.IsAllowed(context.Background(), alice, business.AccessRequest{Namespace: "ns1", Resource: "pods", Verb: "get"})
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, err = checker.IsAllowed(context.Background(), alice, business.AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "get"})
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+func TestNewFakePermissionsClientRejectsInvalidScenarios(t *testing.T) {
+	_, err := NewFakePermissionsClient("allow alice get")
+	assert.Error(t, err)
+	assert.Panics(t, func() { MustNewFakePermissionsClient("allow alice get") })
+}
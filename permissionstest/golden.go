@@ -0,0 +1,77 @@
+package permissionstest
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kiali/kiali/business"
+)
+
+// update rewrites the golden files with the actual outputs instead of comparing them:
+//
+//	go test ./... -update
+var update = flag.Bool("update", false, "update the golden files of permissionstest.AssertGolden")
+
+// GoldenDir is the directory of the golden files, relative to the package under test.
+const GoldenDir = "testdata"
+
+// AssertGolden compares the output with the golden file testdata/<name>.golden, or rewrites the file when
+// the tests run with -update. The line endings are normalized, so the golden files can be checked out
+// with CRLF endings. The first differing line is reported.
+func AssertGolden(t testing.TB, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join(GoldenDir, name+".golden")
+	got = bytes.ReplaceAll(got, []byte("\r\n"), []byte("\n"))
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("error creating the golden file directory: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("error writing golden file %s: %v", path, err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading golden file %s, run the tests with -update to create it: %v", path, err)
+	}
+	want = bytes.ReplaceAll(want, []byte("\r\n"), []byte("\n"))
+	if bytes.Equal(got, want) {
+		return
+	}
+	gotLines, wantLines := strings.Split(string(got), "\n"), strings.Split(string(want), "\n")
+	for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+		var gotLine, wantLine string
+		if i < len(gotLines) {
+			gotLine = gotLines[i]
+		}
+		if i < len(wantLines) {
+			wantLine = wantLines[i]
+		}
+		if gotLine != wantLine {
+			t.Errorf("output differs from golden file %s at line %d, run the tests with -update if the change is expected:\n got: %q\nwant: %q", path, i+1, gotLine, wantLine)
+			return
+		}
+	}
+}
+
+// AssertGoldenOutput renders the value in the output format, like the CLI and the API do, and compares it
+// with the golden file testdata/<name>.<format>.golden, see AssertGolden. The table is used by the table
+// and wide formats, e.g. business.NewTable(matrix, business.AccessRequestColumns...).
+func AssertGoldenOutput(t testing.TB, name, output string, value interface{}, table *business.Table) {
+	t.Helper()
+	format, err := business.ParseOutputFormat(output, business.OutputTable)
+	if err != nil {
+		t.Fatalf("invalid output format %q: %v", output, err)
+	}
+	out := bytes.Buffer{}
+	if err := business.PrintOutput(&out, format, value, table); err != nil {
+		t.Fatalf("error rendering %s output: %v", format.Name, err)
+	}
+	AssertGolden(t, name+"."+format.Name, out.Bytes())
+}
@@ -0,0 +1,52 @@
+package permissionstest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingTB records the errors of the assertions instead of failing the test.
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (in *recordingTB) Helper() {}
+
+func (in *recordingTB) Errorf(format string, args ...interface{}) {
+	in.errors = append(in.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertGolden(t *testing.T) {
+	// The golden files can have CRLF endings
+	AssertGolden(t, "crlf", []byte("first line\nsecond line\n"))
+	AssertGoldenOutput(t, "user", "json", map[string]string{"user": "alice"}, nil)
+
+	recorder := &recordingTB{TB: t}
+	AssertGolden(recorder, "crlf", []byte("first line\nother line\n"))
+	require.Len(t, recorder.errors, 1)
+	assert.Contains(t, recorder.errors[0], "at line 2")
+	assert.Contains(t, recorder.errors[0], `"other line"`)
+}
+
+func TestAssertGoldenUpdate(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.Chdir(dir))
+	*update = true
+	t.Cleanup(func() {
+		*update = false
+		_ = os.Chdir(wd)
+	})
+
+	AssertGolden(t, "report", []byte("line\r\n"))
+	content, err := os.ReadFile(filepath.Join(dir, GoldenDir, "report.golden"))
+	require.NoError(t, err)
+	assert.Equal(t, "line\n", string(content))
+}
@@ -0,0 +1,156 @@
+package permissionstest
+
+import (
+	"fmt"
+	"strings"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kiali/kiali/business"
+)
+
+// RuleFromFuzz builds a rule from the comma separated lists of a fuzz input, so fuzz targets can generate
+// random rules from strings:
+//
+//	func FuzzRuleMatching(f *testing.F) {
+//		f.Add("apps", "deployments,*/scale", "get,*", "", "apps", "deployments", "scale", "web", "get", "prod")
+//		f.Fuzz(func(t *testing.T, groups, resources, verbs, names, group, resource, subresource, name, verb, namespace string) {
+//			rule := permissionstest.RuleFromFuzz(groups, resources, verbs, names)
+//			req := business.AccessRequest{APIGroup: group, Resource: resource, Subresource: subresource, Name: name, Verb: verb, Namespace: namespace}
+//			if err := permissionstest.CheckRuleInvariants(rule, req); err != nil {
+//				t.Error(err)
+//			}
+//		})
+//	}
+func RuleFromFuzz(apiGroups, resources, verbs, resourceNames string) rbac_v1.PolicyRule {
+	split := func(list string) []string {
+		if list == "" {
+			return nil
+		}
+		return strings.Split(list, ",")
+	}
+	return rbac_v1.PolicyRule{APIGroups: split(apiGroups), Resources: split(resources), Verbs: split(verbs), ResourceNames: split(resourceNames)}
+}
+
+// CheckRuleInvariants checks the local evaluation of the rule for the request against invariants that must
+// hold for any rule and request, and returns the first one violated:
+//
+//   - the decision is the one of the reference implementation of the RBAC authorizer of the apiserver
+//   - widening the verbs, API groups or resources of the rule to "*", or dropping its resource names,
+//     never denies an allowed request
+//   - a rule bound cluster-wide decides the same in every namespace
+//   - a rule bound in a namespace allows nothing in the other namespaces nor cluster-wide
+func CheckRuleInvariants(rule rbac_v1.PolicyRule, req business.AccessRequest) error {
+	allowed := clusterAllows(rule, req)
+	if reference := referenceRuleAllows(rule, req); allowed != reference {
+		return fmt.Errorf("rule %s, request %s: allowed=%t, reference allowed=%t", ruleString(rule), business.FormatAccessRequest(req), allowed, reference)
+	}
+
+	if allowed {
+		verbs, groups, resources, names := rule, rule, rule, rule
+		verbs.Verbs = []string{rbac_v1.VerbAll}
+		groups.APIGroups = []string{rbac_v1.APIGroupAll}
+		resources.Resources = []string{rbac_v1.ResourceAll}
+		names.ResourceNames = nil
+		for _, widened := range []struct {
+			what string
+			rule rbac_v1.PolicyRule
+		}{{"verbs", verbs}, {"API groups", groups}, {"resources", resources}, {"resource names", names}} {
+			if !clusterAllows(widened.rule, req) {
+				return fmt.Errorf("rule %s, request %s: allowed, but denied once the %s are widened", ruleString(rule), business.FormatAccessRequest(req), widened.what)
+			}
+		}
+	}
+
+	other := req
+	other.Namespace = req.Namespace + "-other"
+	if clusterAllows(rule, other) != allowed {
+		return fmt.Errorf("rule %s, request %s: a cluster-wide binding decides differently in namespace %s", ruleString(rule), business.FormatAccessRequest(req), other.Namespace)
+	}
+	if req.Namespace != "" {
+		namespaced := namespaceAllows(rule, req.Namespace, req)
+		if namespaced != allowed {
+			return fmt.Errorf("rule %s, request %s: a binding in the namespace decides allowed=%t, a cluster-wide one allowed=%t", ruleString(rule), business.FormatAccessRequest(req), namespaced, allowed)
+		}
+		if namespaceAllows(rule, req.Namespace, other) {
+			return fmt.Errorf("rule %s, request %s: a binding in namespace %s allows namespace %s", ruleString(rule), business.FormatAccessRequest(req), req.Namespace, other.Namespace)
+		}
+		clusterScoped := req
+		clusterScoped.Namespace = ""
+		if namespaceAllows(rule, req.Namespace, clusterScoped) {
+			return fmt.Errorf("rule %s, request %s: a binding in namespace %s allows the cluster-scoped request", ruleString(rule), business.FormatAccessRequest(req), req.Namespace)
+		}
+	}
+	return nil
+}
+
+// fuzzUser is the user bound to the rules of CheckRuleInvariants.
+var fuzzUser = business.UserInfo{Name: "fuzz"}
+
+// clusterAllows evaluates the rule bound to the user by a ClusterRoleBinding.
+func clusterAllows(rule rbac_v1.PolicyRule, req business.AccessRequest) bool {
+	role := rbac_v1.ClusterRole{ObjectMeta: meta_v1.ObjectMeta{Name: "fuzz"}, Rules: []rbac_v1.PolicyRule{rule}}
+	binding := rbac_v1.ClusterRoleBinding{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "fuzz"},
+		Subjects:   []rbac_v1.Subject{{Kind: rbac_v1.UserKind, APIGroup: rbac_v1.GroupName, Name: fuzzUser.Name}},
+		RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "ClusterRole", Name: role.Name},
+	}
+	return business.NewRBACSnapshot([]rbac_v1.ClusterRole{role}, nil, []rbac_v1.ClusterRoleBinding{binding}, nil).Explain(fuzzUser, req).Allowed
+}
+
+// namespaceAllows evaluates the rule bound to the user by a RoleBinding in the namespace.
+func namespaceAllows(rule rbac_v1.PolicyRule, namespace string, req business.AccessRequest) bool {
+	role := rbac_v1.Role{ObjectMeta: meta_v1.ObjectMeta{Name: "fuzz", Namespace: namespace}, Rules: []rbac_v1.PolicyRule{rule}}
+	binding := rbac_v1.RoleBinding{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "fuzz", Namespace: namespace},
+		Subjects:   []rbac_v1.Subject{{Kind: rbac_v1.UserKind, APIGroup: rbac_v1.GroupName, Name: fuzzUser.Name}},
+		RoleRef:    rbac_v1.RoleRef{APIGroup: rbac_v1.GroupName, Kind: "Role", Name: role.Name},
+	}
+	return business.NewRBACSnapshot(nil, []rbac_v1.Role{role}, nil, []rbac_v1.RoleBinding{binding}).Explain(fuzzUser, req).Allowed
+}
+
+// referenceRuleAllows is RuleAllows of the RBAC authorizer of the apiserver, kept as a transcription
+// independent of the business package so the fuzzing compares two implementations.
+func referenceRuleAllows(rule rbac_v1.PolicyRule, req business.AccessRequest) bool {
+	has := func(values []string, wildcard, value string) bool {
+		for _, v := range values {
+			if v == wildcard || v == value {
+				return true
+			}
+		}
+		return false
+	}
+	if !has(rule.Verbs, rbac_v1.VerbAll, req.Verb) || !has(rule.APIGroups, rbac_v1.APIGroupAll, req.APIGroup) {
+		return false
+	}
+
+	combined := req.Resource
+	if req.Subresource != "" {
+		combined = req.Resource + "/" + req.Subresource
+	}
+	resourceMatches := false
+	for _, r := range rule.Resources {
+		if r == rbac_v1.ResourceAll || r == combined || (req.Subresource != "" && r == "*/"+req.Subresource) {
+			resourceMatches = true
+			break
+		}
+	}
+	if !resourceMatches {
+		return false
+	}
+
+	if len(rule.ResourceNames) == 0 {
+		return true
+	}
+	for _, n := range rule.ResourceNames {
+		if n == req.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func ruleString(rule rbac_v1.PolicyRule) string {
+	return fmt.Sprintf("{apiGroups=%q resources=%q verbs=%q resourceNames=%q}", rule.APIGroups, rule.Resources, rule.Verbs, rule.ResourceNames)
+}
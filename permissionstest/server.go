@@ -0,0 +1,94 @@
+package permissionstest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/rest"
+
+	"github.com/kiali/kiali/business"
+)
+
+// ServeAPI serves the access reviews of the fake over HTTP, like the apiserver, for the code creating its
+// own clients from a rest.Config, e.g. the impersonating checks. It returns the config of a client of the
+// server, which is closed at the end of the test.
+//
+// SubjectAccessReviews are answered for their user. SelfSubjectAccessReviews are answered for the user
+// impersonated by the request, with its Impersonate-User, Impersonate-Group and Impersonate-Extra-*
+// headers, or for the identity set with SetSelf. Other requests get a 404.
+func (in *FakePermissionsClient) ServeAPI(t testing.TB) *rest.Config {
+	server := httptest.NewServer(http.HandlerFunc(in.serveReview))
+	t.Cleanup(server.Close)
+	// The server decodes JSON, not the protobuf the typed clients send by default
+	return &rest.Config{Host: server.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}}
+}
+
+func (in *FakePermissionsClient) serveReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "the fake only creates access reviews", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var response interface{}
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/apis/authorization.k8s.io/v1/subjectaccessreviews"):
+		var sar auth_v1.SubjectAccessReview
+		if err := json.NewDecoder(r.Body).Decode(&sar); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		review, err := in.CreateSubjectAccessReview(r.Context(), &sar)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		review.APIVersion, review.Kind = "authorization.k8s.io/v1", "SubjectAccessReview"
+		response = review
+	case strings.HasSuffix(r.URL.Path, "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews"):
+		var ssar auth_v1.SelfSubjectAccessReview
+		if err := json.NewDecoder(r.Body).Decode(&ssar); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ssar.Spec.ResourceAttributes == nil {
+			http.Error(w, "the fake only reviews resource attributes", http.StatusBadRequest)
+			return
+		}
+		allowed, reason := in.decide(in.requestUser(r), accessRequestFor(ssar.Spec.ResourceAttributes))
+		ssar.APIVersion, ssar.Kind = "authorization.k8s.io/v1", "SelfSubjectAccessReview"
+		ssar.Status = auth_v1.SubjectAccessReviewStatus{Allowed: allowed, Denied: !allowed, Reason: reason}
+		response = ssar
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// requestUser returns the user impersonated by the request, or the identity of the fake.
+func (in *FakePermissionsClient) requestUser(r *http.Request) business.UserInfo {
+	name := r.Header.Get("Impersonate-User")
+	if name == "" {
+		in.mu.Lock()
+		defer in.mu.Unlock()
+		return in.self
+	}
+
+	user := business.UserInfo{Name: name, Groups: r.Header.Values("Impersonate-Group")}
+	for key, values := range r.Header {
+		if extra := strings.TrimPrefix(key, "Impersonate-Extra-"); extra != key {
+			if user.Extra == nil {
+				user.Extra = map[string][]string{}
+			}
+			user.Extra[strings.ToLower(extra)] = values
+		}
+	}
+	return user
+}
@@ -0,0 +1,85 @@
+package permissionstest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	auth_v1 "k8s.io/api/authorization/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube "k8s.io/client-go/kubernetes"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiali/kiali/business"
+)
+
+func TestServeAPISubjectAccessReviews(t *testing.T) {
+	fake := MustNewFakePermissionsClient(testScenario)
+	k8s, err := kube.NewForConfig(fake.ServeAPI(t))
+	require.NoError(t, err)
+	checker := business.NewPermissionChecker(business.NewPermissionsClient(k8s))
+
+	developer := business.UserInfo{Name: "carol", Groups: []string{"developers"}}
+	decision, err := checker.Check(context.Background(), developer, business.AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "update"})
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, "allowed by the test scenario", decision.Reason)
+
+	decision, err = checker.Check(context.Background(), business.UserInfo{Name: "alice"}, business.AccessRequest{Namespace: "ns2", Resource: "pods", Verb: "get"})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+
+	assert.Equal(t, []business.AccessRequest{
+		{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "update"},
+		{Namespace: "ns2", Resource: "pods", Verb: "get"},
+	}, fake.Reviews())
+}
+
+func TestServeAPISelfSubjectAccessReviews(t *testing.T) {
+	fake := MustNewFakePermissionsClient(testScenario)
+	fake.SetSelf(business.UserInfo{Name: "alice"})
+	restConfig := fake.ServeAPI(t)
+
+	// The impersonated user, with its groups
+	developer := business.UserInfo{Name: "erin", Groups: []string{"developers"}}
+	decision, err := business.CheckImpersonated(context.Background(), restConfig, developer, business.AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "get"})
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	decision, err = business.CheckImpersonated(context.Background(), restConfig, business.UserInfo{Name: "erin"}, business.AccessRequest{Namespace: "ns1", APIGroup: "apps", Resource: "deployments", Verb: "get"})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+
+	// The identity of the fake without impersonation
+	k8s, err := kube.NewForConfig(restConfig)
+	require.NoError(t, err)
+	review, err := k8s.AuthorizationV1().SelfSubjectAccessReviews().Create(context.Background(), &auth_v1.SelfSubjectAccessReview{Spec: auth_v1.SelfSubjectAccessReviewSpec{
+		ResourceAttributes: &auth_v1.ResourceAttributes{Namespace: "ns1", Resource: "pods", Verb: "get"},
+	}}, meta_v1.CreateOptions{})
+	require.NoError(t, err)
+	assert.True(t, review.Status.Allowed)
+
+	_, err = k8s.AuthorizationV1().SelfSubjectAccessReviews().Create(context.Background(), &auth_v1.SelfSubjectAccessReview{}, meta_v1.CreateOptions{})
+	assert.Error(t, err)
+}
+
+func TestServeAPIRequests(t *testing.T) {
+	fake := MustNewFakePermissionsClient("allow alice get pods")
+	restConfig := fake.ServeAPI(t)
+	user := fake.requestUser(&http.Request{Header: http.Header{
+		"Impersonate-User":         {"alice"},
+		"Impersonate-Group":        {"developers", "ops"},
+		"Impersonate-Extra-Scopes": {"read", "write"},
+	}})
+	assert.Equal(t, business.UserInfo{Name: "alice", Groups: []string{"developers", "ops"}, Extra: map[string][]string{"scopes": {"read", "write"}}}, user)
+
+	resp, err := http.Get(restConfig.Host + "/api/v1/pods")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp, err = http.Post(restConfig.Host+"/api/v1/namespaces", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,54 @@
+package permissionstest
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"testing"
+)
+
+// Hammer runs the operations concurrently from workers goroutines, each calling every operation
+// iterations times in turn, so the tests run with -race can lock in the concurrency guarantees of the
+// checker, the caches and the watcher, e.g.:
+//
+//	permissionstest.Hammer(t, 8, 1000,
+//		func(worker, i int) { checker.Check(ctx, alice, req) },
+//		func(worker, i int) { checker.SetMode(business.EnforcementModeAudit) },
+//		func(worker, i int) { checker.ApplyConfig(conf) },
+//	)
+//
+// The workers start together. A panicking operation fails the test with its stack.
+func Hammer(t testing.TB, workers, iterations int, ops ...func(worker, iteration int)) {
+	t.Helper()
+	start := make(chan struct{})
+	wg := sync.WaitGroup{}
+	mu := sync.Mutex{}
+	failures := []string{}
+
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(worker int) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					mu.Lock()
+					failures = append(failures, fmt.Sprintf("worker %d panicked: %v\n%s", worker, r, debug.Stack()))
+					mu.Unlock()
+				}
+			}()
+			<-start
+			for i := 0; i < iterations; i++ {
+				// The workers start on different operations, so every pair runs concurrently
+				for j := range ops {
+					ops[(worker+j)%len(ops)](worker, i)
+				}
+			}
+		}(w)
+	}
+	close(start)
+	wg.Wait()
+
+	for _, failure := range failures {
+		t.Error(failure)
+	}
+}